@@ -16,6 +16,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
@@ -83,24 +84,38 @@ func main() {
 
 	// Consistency checks.
 	fmt.Printf("\nConsistency checks:\n")
-	if end := h.RootDirOffset + h.RootDirLength; end != h.MetadataOffset {
-		fail("root dir end (%d) != metadata offset (%d)", end, h.MetadataOffset)
-	} else {
-		fmt.Printf("  RootDir end -> MetadataOffset: OK\n")
-	}
-	if end := h.MetadataOffset + h.MetadataLength; end != h.LeafDirOffset {
-		fail("metadata end (%d) != leaf dir offset (%d)", end, h.LeafDirOffset)
-	} else {
-		fmt.Printf("  Metadata end -> LeafDirOffset: OK\n")
+	// Sections must follow the header without overlapping. The usual order
+	// is root dir, metadata, leaf dirs, tile data; archives written with a
+	// stable layout put the tile data at a fixed offset after the root dir,
+	// behind padding, and the metadata and leaf dirs after it.
+	sections := []struct {
+		name   string
+		offset uint64
+		length uint64
+	}{
+		{"RootDir", h.RootDirOffset, h.RootDirLength},
+		{"Metadata", h.MetadataOffset, h.MetadataLength},
+		{"LeafDirs", h.LeafDirOffset, h.LeafDirLength},
+		{"TileData", h.TileDataOffset, h.TileDataLength},
 	}
-	if end := h.LeafDirOffset + h.LeafDirLength; end != h.TileDataOffset {
-		fail("leaf dir end (%d) != tile data offset (%d)", end, h.TileDataOffset)
-	} else {
-		fmt.Printf("  LeafDir end -> TileDataOffset: OK\n")
+	sort.SliceStable(sections, func(i, j int) bool { return sections[i].offset < sections[j].offset })
+	end := uint64(pmtiles.HeaderSize)
+	for _, sec := range sections {
+		switch {
+		case sec.offset < end:
+			fail("%s offset (%d) overlaps the previous section (ends at %d)", sec.name, sec.offset, end)
+		case sec.offset > end:
+			fmt.Printf("  %s at %d: OK (after %d bytes of padding)\n", sec.name, sec.offset, sec.offset-end)
+		default:
+			fmt.Printf("  %s at %d: OK\n", sec.name, sec.offset)
+		}
+		if e := sec.offset + sec.length; e > end {
+			end = e
+		}
 	}
 
 	// File size check (local files only).
-	expectedSize := h.TileDataOffset + h.TileDataLength
+	expectedSize := end
 	if fs, ok := src.(*fileSource); ok {
 		if uint64(fs.size) != expectedSize {
 			fail("file size %d != expected %d", fs.size, expectedSize)
@@ -1,31 +1,73 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"image"
 	"os"
 
 	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: coginfo <file.tif>\n")
+	tags := flag.Bool("tags", false, "Dump every TIFF tag and GeoKey with type, count, and decoded value instead of the summary (also works on files that fail to open)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: coginfo [--tags] <file.tif>\n\nFlags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
+	path := flag.Arg(0)
+
+	if *tags {
+		if err := dumpTags(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	r, err := cog.Open(os.Args[1])
+	r, err := cog.Open(path)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 	defer r.Close()
 
-	fmt.Printf("File: %s\n", os.Args[1])
-	fmt.Printf("EPSG: %d\n", r.EPSG())
+	fmt.Printf("File: %s\n", path)
+	if g := r.EPSGGuess(); g != nil {
+		confidence := "high"
+		if !g.Confident {
+			confidence = "low"
+		}
+		fmt.Printf("EPSG: %d (inferred, no GeoKeys; %s confidence: %s)\n", r.EPSG(), confidence, g.Reason)
+	} else if r.EPSG() == coord.UserDefined {
+		if _, err := r.Projection(); err != nil {
+			fmt.Printf("EPSG: %d (user-defined, not supported: %v)\n", r.EPSG(), err)
+		} else {
+			fmt.Printf("EPSG: %d (user-defined, from WKT: %s)\n", r.EPSG(), r.CRSName())
+		}
+	} else if name := r.CRSName(); name != "" {
+		fmt.Printf("EPSG: %d (%s)\n", r.EPSG(), name)
+	} else {
+		fmt.Printf("EPSG: %d (not supported)\n", r.EPSG())
+	}
 	fmt.Printf("Full-res size: %d x %d\n", r.Width(), r.Height())
 	fmt.Printf("Pixel size (CRS units): %f\n", r.PixelSize())
 	fmt.Printf("IFD count: %d (1 full-res + %d overviews)\n", r.IFDCount(), r.NumOverviews())
+	if factors := r.SynthesizedOverviews(); len(factors) > 0 {
+		fmt.Printf("Synthesized overviews: %v (missing from the file, computed in memory)\n", factors)
+	}
+	if level := r.PinnedLevel(); level >= 0 {
+		fmt.Printf("Pinned level: %d (%dx%d, decoded once and kept in memory)\n", level, r.IFDWidth(level), r.IFDHeight(level))
+	}
+	if n := r.NumMasks(); n > 0 {
+		fmt.Printf("Mask IFDs: %d (transparency masks, applied as alpha to %d of %d levels)\n", n, r.MaskedLevels(), r.IFDCount())
+	}
 
 	geo := r.GeoInfo()
 	fmt.Printf("Origin: X=%f, Y=%f\n", geo.OriginX, geo.OriginY)
@@ -46,6 +88,31 @@ func main() {
 		}
 	}
 
+	// Print provenance derived from GDAL metadata.
+	md := r.GDALMeta()
+	if bands := md.Bands(); len(bands) > 0 {
+		fmt.Printf("\nBands:\n")
+		for _, b := range bands {
+			fmt.Printf("  %d:", b.Band)
+			if b.Description != "" {
+				fmt.Printf(" %s", b.Description)
+			}
+			if b.Unit != "" {
+				fmt.Printf(" [%s]", b.Unit)
+			}
+			if b.HasStats {
+				fmt.Printf(" min=%g max=%g mean=%g stddev=%g", b.Min, b.Max, b.Mean, b.StdDev)
+			}
+			fmt.Println()
+		}
+	}
+	if d := md.AcquisitionDate(); d != "" {
+		fmt.Printf("Acquisition date: %s\n", d)
+	}
+	if ovr := md.OverviewResampling(); ovr != "" {
+		fmt.Printf("Overview resampling: %s\n", ovr)
+	}
+
 	// Print auto-detected preset if available.
 	if preset, ok := r.DetectPreset(); ok {
 		fmt.Printf("\nDetected preset: %s\n", preset.Name)
@@ -64,7 +131,11 @@ func main() {
 		w := r.IFDWidth(level)
 		h := r.IFDHeight(level)
 		ps := r.IFDPixelSize(level)
-		fmt.Printf("\n  IFD %d: %dx%d, tile %dx%d, pixel size=%f\n", level, w, h, ts[0], ts[1], ps)
+		synth := ""
+		if r.IsSynthesized(level) {
+			synth = " (synthesized)"
+		}
+		fmt.Printf("\n  IFD %d: %dx%d, tile %dx%d, pixel size=%f%s\n", level, w, h, ts[0], ts[1], ps, synth)
 
 		tile, err := r.ReadTile(level, 0, 0)
 		if err != nil {
@@ -81,6 +152,36 @@ func main() {
 	}
 }
 
+// dumpTags prints the raw tags of every IFD in the file, in file order,
+// like tiffinfo and listgeo.
+func dumpTags(path string) error {
+	dirs, err := cog.ReadTags(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("File: %s\n", path)
+	for i, d := range dirs {
+		fmt.Printf("\nIFD %d (offset %d):\n", i, d.Offset)
+		for _, t := range d.Tags {
+			fmt.Printf("  %5d %-26s %-9s %6d  %s\n", t.ID, orUnknown(t.Name), t.Type, t.Count, t.Value)
+		}
+		if len(d.GeoKeys) > 0 {
+			fmt.Printf("  GeoKeys:\n")
+			for _, k := range d.GeoKeys {
+				fmt.Printf("  %5d %-26s %-15s %3d  %s\n", k.ID, orUnknown(k.Name), k.Location, k.Count, k.Value)
+			}
+		}
+	}
+	return nil
+}
+
+func orUnknown(name string) string {
+	if name == "" {
+		return "(unknown)"
+	}
+	return name
+}
+
 func samplePixels(img image.Image, count int) {
 	b := img.Bounds()
 	step := b.Dx() / (count + 1)
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+	"github.com/pspoerri/geotiff2pmtiles/internal/tile"
+)
+
+// runFlags are the command-line settings whose combinations validateFlags
+// checks, as given (before --format contours or hillshade is resolved).
+type runFlags struct {
+	explicit map[string]bool // flags given on the command line
+
+	output   string   // output path; empty with --daemon and --batch
+	mbtiles  bool     // MBTiles output
+	backfill []string // PMTiles inputs
+
+	format        string
+	daemon        string
+	batch         string
+	serve         string
+	preview       string
+	terrainOutput string
+	zoomOffset    int
+	splitByDate   bool
+	shard         string
+	shardIndex    int
+	incremental   bool
+	resume        bool
+	streaming     bool
+	stableLayout  bool
+	writerTempDir string
+	targetSizeMB  int
+	reportPath    string
+	debugOverlay  bool
+	graticule     float64
+	tileFilter    string
+
+	contourInterval string
+	hillshadeAlt    float64
+	hillshadeZ      float64
+	hillshadeStyle  string
+	hillshadeEnc    string
+	colorMap        string
+	colorMapMode    string
+}
+
+// validateFlags returns an error for the first setting that is out of range
+// or cannot be combined with another one.
+func validateFlags(f runFlags) error {
+	if f.batch != "" {
+		if f.daemon != "" || f.serve != "" || f.preview != "" || f.terrainOutput != "" || f.zoomOffset != 0 || f.splitByDate ||
+			f.shard != "" || f.incremental || f.resume || f.targetSizeMB > 0 || f.format == "contours" {
+			return errors.New("--batch cannot be combined with --daemon, --serve, --preview, --terrain-output, --zoom-offset, --split-by-date, --shard, --incremental, --resume, --target-size, or --format contours")
+		}
+		if f.explicit["report"] && f.reportPath != "off" {
+			return errors.New("--report cannot be combined with --batch: the report describes a single archive")
+		}
+	}
+	if (f.daemon != "" || f.batch != "") && (f.serve != "" || f.preview != "" || f.targetSizeMB > 0) {
+		return errors.New("--daemon cannot be combined with --serve, --preview, or --target-size")
+	}
+	if f.output != "" {
+		ext := ".pmtiles"
+		if f.mbtiles {
+			ext = ".mbtiles"
+		}
+		if !strings.HasSuffix(f.output, ext) {
+			return fmt.Errorf("Output file must have %s extension", ext)
+		}
+	}
+	if f.mbtiles {
+		// The MBTiles writer builds the database in one pass when
+		// finalizing: there is no archive to checkpoint, preview, stream
+		// into or copy unchanged tiles from, and jobs write PMTiles.
+		if f.daemon != "" || f.batch != "" || f.serve != "" || f.preview != "" || f.splitByDate || f.shard != "" ||
+			f.incremental || f.resume || f.streaming || f.stableLayout {
+			return errors.New("--output-format mbtiles cannot be combined with --daemon, --batch, --serve, --preview, --split-by-date, --shard, --incremental, --resume, --streaming, or --stable-layout")
+		}
+	}
+	if len(f.backfill) > 0 {
+		if f.daemon != "" || f.batch != "" || f.terrainOutput != "" || f.incremental {
+			return errors.New("PMTiles inputs cannot be combined with --daemon, --batch, --terrain-output, or --incremental")
+		}
+		for _, p := range f.backfill {
+			if filepath.Clean(p) == filepath.Clean(f.output) {
+				return fmt.Errorf("%s is both an input and the output", p)
+			}
+		}
+	}
+	if f.terrainOutput != "" {
+		if !strings.HasSuffix(f.terrainOutput, ".pmtiles") {
+			return errors.New("--terrain-output must have .pmtiles extension")
+		}
+		if f.daemon != "" || f.serve != "" || f.preview != "" {
+			return errors.New("--terrain-output cannot be combined with --daemon, --serve, or --preview")
+		}
+		if _, ok := encode.DEMEncodingFor(f.format); ok {
+			return errors.New("--terrain-output writes the float sources as terrarium; --format selects the imagery format")
+		}
+	}
+	if f.zoomOffset != 0 {
+		if f.zoomOffset < 0 {
+			return fmt.Errorf("--zoom-offset must be >= 0, got %d: x and y would lie outside the grid of a lower zoom", f.zoomOffset)
+		}
+		if f.daemon != "" || f.serve != "" {
+			return errors.New("--zoom-offset cannot be combined with --daemon or --serve")
+		}
+	}
+	if f.explicit["report"] && f.reportPath != "off" && (f.daemon != "" || f.splitByDate || f.shard != "") {
+		return errors.New("--report cannot be combined with --daemon, --split-by-date, or --shard: the report describes a single archive")
+	}
+	if f.splitByDate {
+		if f.daemon != "" || f.serve != "" || f.preview != "" || f.terrainOutput != "" || f.incremental || f.targetSizeMB > 0 {
+			return errors.New("--split-by-date cannot be combined with --daemon, --serve, --preview, --terrain-output, --incremental, or --target-size")
+		}
+	}
+	if f.shard != "" {
+		if f.daemon != "" || f.serve != "" || f.preview != "" || f.splitByDate || f.incremental || f.resume || f.terrainOutput != "" || f.targetSizeMB > 0 {
+			return errors.New("--shard cannot be combined with --daemon, --serve, --preview, --split-by-date, --incremental, --resume, --terrain-output, or --target-size")
+		}
+		if f.explicit["shard-index"] && f.shardIndex < 0 {
+			return fmt.Errorf("--shard-index must be >= 0, got %d", f.shardIndex)
+		}
+	} else if f.explicit["shard-index"] {
+		return errors.New("--shard-index requires --shard")
+	}
+	if f.incremental {
+		if f.daemon != "" || f.serve != "" || f.terrainOutput != "" || f.targetSizeMB > 0 {
+			return errors.New("--incremental cannot be combined with --daemon, --serve, --terrain-output, or --target-size")
+		}
+		if f.debugOverlay {
+			return errors.New("--incremental cannot be combined with --debug-overlay: parents would be downsampled from labelled tiles")
+		}
+	}
+	if f.streaming {
+		if f.resume {
+			return errors.New("--streaming cannot be combined with --resume: the checkpoint links the writer's temp file, which streaming does not have")
+		}
+		if f.writerTempDir != "" {
+			return errors.New("--writer-temp-dir has no effect with --streaming: tile data is written into the archive")
+		}
+	}
+	if f.resume {
+		if f.daemon != "" || f.serve != "" || f.splitByDate || f.incremental || f.terrainOutput != "" || f.targetSizeMB > 0 || f.format == "contours" {
+			return errors.New("--resume cannot be combined with --daemon, --serve, --split-by-date, --incremental, --terrain-output, --target-size, or --format contours")
+		}
+	}
+
+	contours := f.format == "contours"
+	if contours {
+		if f.daemon != "" || f.serve != "" || f.preview != "" || f.splitByDate || f.incremental || f.terrainOutput != "" || f.targetSizeMB > 0 || f.tileFilter != "" {
+			return errors.New("--format contours cannot be combined with --daemon, --serve, --preview, --split-by-date, --incremental, --terrain-output, --target-size, or --tile-filter")
+		}
+	}
+	if f.contourInterval != "" && !contours {
+		return errors.New("--contour-interval requires --format contours")
+	}
+
+	hillshade := f.format == "hillshade"
+	if hillshade {
+		if f.terrainOutput != "" || f.targetSizeMB > 0 {
+			return errors.New("--format hillshade cannot be combined with --terrain-output or --target-size")
+		}
+		if f.hillshadeAlt <= 0 || f.hillshadeAlt > 90 {
+			return fmt.Errorf("--hillshade-altitude must be in (0, 90], got %g", f.hillshadeAlt)
+		}
+		if f.hillshadeZ <= 0 {
+			return fmt.Errorf("--hillshade-z-factor must be > 0, got %g", f.hillshadeZ)
+		}
+		if f.hillshadeStyle != "gray" && f.hillshadeStyle != "color" {
+			return fmt.Errorf("--hillshade-style: unknown style %q (want gray, color)", f.hillshadeStyle)
+		}
+		switch f.hillshadeEnc {
+		case "png", "jpeg", "webp":
+		default:
+			return fmt.Errorf("--hillshade-encoding: unknown encoding %q (want png, jpeg, webp)", f.hillshadeEnc)
+		}
+	} else {
+		for _, name := range []string{"hillshade-azimuth", "hillshade-altitude", "hillshade-z-factor", "hillshade-style", "hillshade-encoding"} {
+			if f.explicit[name] {
+				return fmt.Errorf("--%s requires --format hillshade", name)
+			}
+		}
+	}
+
+	if f.colorMap != "" {
+		if hillshade || contours || f.terrainOutput != "" || f.targetSizeMB > 0 {
+			return errors.New("--color-map cannot be combined with --format hillshade or contours, --terrain-output, or --target-size")
+		}
+		if _, elevation := encode.DEMEncodingFor(f.format); elevation {
+			return fmt.Errorf("--color-map cannot be used with %s: colored pixels would decode as elevations", f.format)
+		}
+		if f.colorMapMode != "interpolate" && f.colorMapMode != "discrete" {
+			return fmt.Errorf("--color-map-mode: unknown mode %q (want interpolate, discrete)", f.colorMapMode)
+		}
+	} else if f.explicit["color-map-mode"] {
+		return errors.New("--color-map-mode requires --color-map")
+	}
+
+	if f.graticule < 0 || (f.graticule > 0 && !f.debugOverlay) {
+		return errors.New("--graticule must be a positive spacing in degrees and requires --debug-overlay")
+	}
+	return nil
+}
+
+// contentFlags are the settings that affect tile content, as resolved for
+// the run (format, quality, zoom range, sources opened).
+type contentFlags struct {
+	version    string
+	format     string
+	quality    int
+	tileSize   int
+	minZoom    int
+	maxZoom    int
+	resampling string
+	gamma      float64
+	fillColor  string
+	background string
+	bands      cog.BandConfig
+	nodata     string
+	fillVoids  int
+	tileFilter string
+	manifest   string // hash of the --manifest file, if any
+
+	sharpen        string
+	premultiply    bool
+	qualitySpec    string // with per-zoom qualities
+	maxTileBytes   int
+	zoomOffset     int
+	hillshade      *tile.Hillshade
+	hillshadeStyle string
+	colorMap       *tile.ColorMap
+	colorMapMode   string
+	overlay        bool
+	inputOrder     []string // the inputs, with --input-order
+	debugOverlay   bool
+	graticule      float64
+	assumeEPSG     int // CRS of inputs without GeoKeys
+}
+
+// contentSettings returns the fingerprint of the settings that affect tile
+// content: an --incremental state or a --resume checkpoint only carries over
+// to a run with the same. Settings at their defaults are left out, so that
+// a new setting does not change the fingerprint of runs that do not use it.
+func contentSettings(c contentFlags) string {
+	settings := fmt.Sprintf("version=%s format=%s quality=%d tile-size=%d zoom=%d-%d resampling=%s gamma=%g fill-color=%q background=%q bands=%q nodata=%q fill-voids=%d tile-filter=%q",
+		c.version, c.format, c.quality, c.tileSize, c.minZoom, c.maxZoom, c.resampling, c.gamma, c.fillColor, c.background, c.bands, c.nodata, c.fillVoids, c.tileFilter)
+	if c.manifest != "" {
+		settings += " manifest=" + c.manifest
+	}
+	if c.sharpen != "" {
+		settings += fmt.Sprintf(" sharpen=%q", c.sharpen)
+	}
+	if c.premultiply {
+		settings += " premultiply-alpha"
+	}
+	if c.qualitySpec != "" {
+		settings += fmt.Sprintf(" quality-per-zoom=%q", c.qualitySpec)
+	}
+	if c.maxTileBytes > 0 {
+		settings += fmt.Sprintf(" max-tile-bytes=%d", c.maxTileBytes)
+	}
+	if c.zoomOffset != 0 {
+		settings += fmt.Sprintf(" zoom-offset=%d", c.zoomOffset)
+	}
+	if c.hillshade != nil {
+		settings += fmt.Sprintf(" hillshade=%g/%g/%g/%s", c.hillshade.Azimuth, c.hillshade.Altitude, c.hillshade.ZFactor, c.hillshadeStyle)
+	}
+	if c.colorMap != nil {
+		settings += fmt.Sprintf(" color-map=%v/%s", c.colorMap.Stops, c.colorMapMode)
+	}
+	if c.bands.HasBilevelColors {
+		settings += fmt.Sprintf(" bilevel=%v", c.bands.BilevelColors)
+	}
+	if c.overlay {
+		// Overlays leave missing tiles out instead of filling them.
+		settings += " type=overlay"
+	}
+	if len(c.inputOrder) > 0 {
+		// Overlap priority follows the input order.
+		settings += fmt.Sprintf(" input-order=%q", strings.Join(c.inputOrder, ","))
+	}
+	if c.debugOverlay {
+		settings += fmt.Sprintf(" debug-overlay graticule=%g", c.graticule)
+	}
+	if c.assumeEPSG != 0 {
+		// Inputs without GeoKeys are placed in this CRS.
+		settings += fmt.Sprintf(" assume-epsg=%d", c.assumeEPSG)
+	}
+	return settings
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/checkpoint"
+	"github.com/pspoerri/geotiff2pmtiles/internal/tile"
+)
+
+// defaultFlags returns the flags of a plain run to out.pmtiles.
+func defaultFlags() runFlags {
+	return runFlags{
+		explicit:       map[string]bool{},
+		output:         "out.pmtiles",
+		format:         "jpeg",
+		hillshadeAlt:   45,
+		hillshadeZ:     1,
+		hillshadeStyle: "gray",
+		hillshadeEnc:   "png",
+		colorMapMode:   "interpolate",
+	}
+}
+
+func TestValidateFlags(t *testing.T) {
+	if err := validateFlags(defaultFlags()); err != nil {
+		t.Fatalf("plain run: %v", err)
+	}
+
+	valid := map[string]func(f *runFlags){
+		"resume":      func(f *runFlags) { f.resume = true },
+		"streaming":   func(f *runFlags) { f.streaming = true },
+		"mbtiles":     func(f *runFlags) { f.output, f.mbtiles = "out.mbtiles", true },
+		"hillshade":   func(f *runFlags) { f.format = "hillshade" },
+		"contours":    func(f *runFlags) { f.format, f.contourInterval = "contours", "10" },
+		"shard-index": func(f *runFlags) { f.shard, f.explicit["shard-index"] = "0-7", true },
+		"graticule":   func(f *runFlags) { f.debugOverlay, f.graticule = true, 1 },
+		"report with --shard off": func(f *runFlags) {
+			f.shard, f.reportPath, f.explicit["report"] = "0-7", "off", true
+		},
+	}
+	for name, set := range valid {
+		f := defaultFlags()
+		set(&f)
+		if err := validateFlags(f); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	invalid := map[string]func(f *runFlags){
+		"wrong extension":            func(f *runFlags) { f.output = "out.mbtiles" },
+		"batch and resume":           func(f *runFlags) { f.output, f.batch, f.resume = "", "jobs.json", true },
+		"daemon and serve":           func(f *runFlags) { f.output, f.daemon, f.serve = "", ":8080", ":8081" },
+		"mbtiles and streaming":      func(f *runFlags) { f.output, f.mbtiles, f.streaming = "out.mbtiles", true, true },
+		"backfill is the output":     func(f *runFlags) { f.backfill = []string{"./out.pmtiles"} },
+		"terrain output with DEM":    func(f *runFlags) { f.terrainOutput, f.format = "dem.pmtiles", "terrarium" },
+		"negative zoom offset":       func(f *runFlags) { f.zoomOffset = -1 },
+		"report with shard":          func(f *runFlags) { f.shard, f.reportPath, f.explicit["report"] = "0-7", "r.json", true },
+		"shard-index without shard":  func(f *runFlags) { f.explicit["shard-index"] = true },
+		"incremental debug overlay":  func(f *runFlags) { f.incremental, f.debugOverlay = true, true },
+		"streaming and resume":       func(f *runFlags) { f.streaming, f.resume = true, true },
+		"writer temp dir streaming":  func(f *runFlags) { f.streaming, f.writerTempDir = true, "/tmp" },
+		"resume and target size":     func(f *runFlags) { f.resume, f.targetSizeMB = true, 100 },
+		"contours and tile filter":   func(f *runFlags) { f.format, f.tileFilter = "contours", "cat" },
+		"interval without contours":  func(f *runFlags) { f.contourInterval = "10" },
+		"hillshade altitude":         func(f *runFlags) { f.format, f.hillshadeAlt = "hillshade", 91 },
+		"hillshade flag without it":  func(f *runFlags) { f.explicit["hillshade-style"] = true },
+		"color map with terrarium":   func(f *runFlags) { f.colorMap, f.format = "map.txt", "terrarium" },
+		"color map mode":             func(f *runFlags) { f.colorMap, f.colorMapMode = "map.txt", "steps" },
+		"color map mode without map": func(f *runFlags) { f.explicit["color-map-mode"] = true },
+		"graticule without overlay":  func(f *runFlags) { f.graticule = 1 },
+	}
+	for name, set := range invalid {
+		f := defaultFlags()
+		set(&f)
+		if err := validateFlags(f); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestContentSettings(t *testing.T) {
+	base := contentFlags{version: "1.0", format: "webp", quality: 85, tileSize: 256, minZoom: 0, maxZoom: 14, resampling: "bicubic", gamma: 1}
+	want := `version=1.0 format=webp quality=85 tile-size=256 zoom=0-14 resampling=bicubic gamma=1 fill-color="" background="" bands="bands 1,2,3" nodata="" fill-voids=0 tile-filter=""`
+	if got := contentSettings(base); got != want {
+		t.Errorf("contentSettings = %s, want %s", got, want)
+	}
+
+	// Every setting that changes tiles changes the fingerprint, so a
+	// checkpoint of a run without it is not resumed with it.
+	changes := map[string]func(c *contentFlags){
+		"quality":     func(c *contentFlags) { c.quality = 90 },
+		"zoom":        func(c *contentFlags) { c.maxZoom = 15 },
+		"manifest":    func(c *contentFlags) { c.manifest = "abc" },
+		"sharpen":     func(c *contentFlags) { c.sharpen = "0-8" },
+		"premultiply": func(c *contentFlags) { c.premultiply = true },
+		"zoom offset": func(c *contentFlags) { c.zoomOffset = 1 },
+		"hillshade": func(c *contentFlags) {
+			c.hillshade, c.hillshadeStyle = &tile.Hillshade{Azimuth: 315, Altitude: 45, ZFactor: 1}, "gray"
+		},
+		"overlay":       func(c *contentFlags) { c.overlay = true },
+		"input order":   func(c *contentFlags) { c.inputOrder = []string{"b.tif", "a.tif"} },
+		"debug overlay": func(c *contentFlags) { c.debugOverlay = true },
+		"graticule":     func(c *contentFlags) { c.debugOverlay, c.graticule = true, 1 },
+		"assume epsg":   func(c *contentFlags) { c.assumeEPSG = 2056 },
+	}
+	for name, set := range changes {
+		c := base
+		set(&c)
+		if contentSettings(c) == want {
+			t.Errorf("%s: fingerprint unchanged", name)
+		}
+	}
+}
+
+// TestResumeChangedSettings resumes the checkpoint of a plain run with
+// settings that change tile content: the fingerprints must differ, so the
+// run starts over instead of mixing tiles of both.
+func TestResumeChangedSettings(t *testing.T) {
+	dir := t.TempDir()
+	plain := contentFlags{version: "1.0", format: "png", quality: 85, tileSize: 256, maxZoom: 12, resampling: "bicubic", gamma: 1}
+	if err := checkpoint.Save(dir, checkpoint.State{Settings: contentSettings(plain), Zoom: 10}); err != nil {
+		t.Fatal(err)
+	}
+	state, err := checkpoint.Load(dir)
+	if err != nil || state == nil {
+		t.Fatalf("Load = %v, %v", state, err)
+	}
+	if state.Settings != contentSettings(plain) {
+		t.Errorf("same settings: fingerprint mismatch")
+	}
+
+	overlay, epsg := plain, plain
+	overlay.debugOverlay = true
+	epsg.assumeEPSG = 2056
+	for name, c := range map[string]contentFlags{"--debug-overlay": overlay, "--assume-epsg": epsg} {
+		if state.Settings == contentSettings(c) {
+			t.Errorf("resumed with %s: fingerprint matches the plain run's", name)
+		}
+	}
+}
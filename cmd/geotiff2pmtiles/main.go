@@ -1,25 +1,47 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"image/color"
+	"io"
 	"io/fs"
 	"log"
 	"math"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/pspoerri/geotiff2pmtiles/internal/buildinfo"
+	"github.com/pspoerri/geotiff2pmtiles/internal/checkpoint"
 	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog/remote"
 	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+	"github.com/pspoerri/geotiff2pmtiles/internal/daemon"
 	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+	"github.com/pspoerri/geotiff2pmtiles/internal/incremental"
+	"github.com/pspoerri/geotiff2pmtiles/internal/manifest"
+	"github.com/pspoerri/geotiff2pmtiles/internal/mbtiles"
 	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+	"github.com/pspoerri/geotiff2pmtiles/internal/profile"
+	"github.com/pspoerri/geotiff2pmtiles/internal/report"
+	"github.com/pspoerri/geotiff2pmtiles/internal/scratch"
+	"github.com/pspoerri/geotiff2pmtiles/internal/serve"
+	"github.com/pspoerri/geotiff2pmtiles/internal/shard"
 	"github.com/pspoerri/geotiff2pmtiles/internal/tile"
+	"github.com/pspoerri/geotiff2pmtiles/internal/ui"
+	"github.com/pspoerri/geotiff2pmtiles/internal/vector"
+	"github.com/pspoerri/geotiff2pmtiles/tileorder"
 )
 
 // Set via -ldflags at build time.
@@ -29,21 +51,36 @@ var (
 	buildDate = "unknown"
 )
 
+// units formats the sizes and durations printed (--units, --machine-readable).
+var units ui.Format
+
 func main() {
 	var (
 		format          string
 		quality         int
+		qualitySpec     string
 		minZoom         int
 		maxZoom         int
 		showVersion     bool
+		versionJSON     bool
 		tileSize        int
 		concurrency     int
 		verbose         bool
+		unitsName       string
+		machineReadable bool
 		resampling      string
 		cpuProfile      string
 		memProfile      string
 		memLimitMB      int
+		encodeCacheMB   int
+		remoteCacheMB   int
+		sourceCacheN    int
+		rawCacheMB      int
+		tempDir         string
+		writerTempDir   string
 		noSpill         bool
+		spillFormat     string
+		tileOrderStr    string
 		fillColor       string
 		attribution     string
 		layerType       string
@@ -52,46 +89,187 @@ func main() {
 		rescaleStr      string
 		rescaleRange    string
 		nodataStr       string
+		manifestPath    string
 		resamplingGamma float64
+		targetSizeMB    int
+		maxTileBytes    int
+		nanReport       int
+		serveAddr       string
+		flushInterval   time.Duration
+		previewAddr     string
+		daemonAddr      string
+		batchPath       string
+		profileName     string
+		debugOverlay    bool
+		graticule       float64
+		background      string
+		premultiply     bool
+		bilevelFG       string
+		bilevelBG       string
+		showTiming      bool
+		fillVoids       int
+		poolCheck       bool
+		levelByLevel    bool
+		inputOrder      bool
+		overlapCheck    string
+		overlapMaxDelta float64
+		fsync           bool
+		stableLayout    bool
+		streaming       bool
+		outputFormat    string
+		tileFilter      string
+		tilesetName     string
+		tilesetVersion  string
+		layerID         string
+		terrainOutput   string
+		incrementalRun  bool
+		resume          bool
+		reportPath      string
+		memCheck        string
+		sharpen         string
+		zoomOffset      int
+		splitByDate     bool
+		shardSpec       string
+		shardIndex      int
+		contourInterval string
+		hillshadeAz     float64
+		hillshadeAlt    float64
+		hillshadeZ      float64
+		hillshadeStyle  string
+		hillshadeEnc    string
+		colorMapSpec    string
+		colorMapMode    string
+		assumeEPSG      int
 	)
 
-	flag.StringVar(&format, "format", "jpeg", "Tile encoding: jpeg, png, webp, terrarium")
-	flag.IntVar(&quality, "quality", 85, "JPEG/WebP quality 1-100")
+	flag.StringVar(&format, "format", "jpeg", "Tile encoding: jpeg, png, webp, terrarium, terrainrgb (Mapbox Terrain-RGB), contours (float DEM input → MVT contour lines), hillshade (float DEM input → shaded relief)")
+	flag.StringVar(&qualitySpec, "quality", "85", "JPEG/WebP quality 1-100, \"lossless\" (WebP), or per zoom: \"z<min>-<max>:<q>\", \"z<zoom>:<q>\", \"z<min>+:<q>\" entries, comma-separated, e.g. \"z0-10:75,z11-14:85,z15+:92\" (a bare quality among them covers the other zooms)")
+	flag.IntVar(&targetSizeMB, "target-size", 0, "Approximate archive size in MB; picks JPEG/WebP quality per zoom (at most --quality) by sampling tiles (0 = disabled)")
+	flag.IntVar(&maxTileBytes, "max-tile-bytes", 0, "Re-encode JPEG/WebP tiles larger than this many bytes at stepped-down quality (10 lower per step, down to 10) so every tile stays small enough for fast range requests (0 = no limit)")
 	flag.IntVar(&minZoom, "min-zoom", -1, "Minimum zoom level (default: auto)")
 	flag.IntVar(&maxZoom, "max-zoom", -1, "Maximum zoom level (default: auto from resolution)")
-	flag.IntVar(&tileSize, "tile-size", 256, "Output tile size in pixels")
+	flag.IntVar(&zoomOffset, "zoom-offset", 0, "Add this to every zoom level in the archive (tiles, header, metadata) without changing x/y, e.g. 1 to label 512px tiles by the 256px zoom of the same scale, for clients configured with the same offset")
+	flag.IntVar(&tileSize, "tile-size", 256, "Output tile size in pixels (an even number between 2 and 4096)")
 	flag.IntVar(&concurrency, "concurrency", runtime.NumCPU(), "Number of parallel workers")
-	flag.StringVar(&resampling, "resampling", "bicubic", "Interpolation method: lanczos, bicubic, bilinear, nearest, mode")
+	flag.StringVar(&resampling, "resampling", "bicubic", "Interpolation method: lanczos, bicubic, bilinear, nearest, mode (default mode for rasters detected as categorical)")
 	flag.Float64Var(&resamplingGamma, "resampling-gamma", 1.0, "Gamma correction for resampling output encoding (1.0 = disabled, typical 1.5-2.2 for dB-space to RGB)")
+	flag.StringVar(&sharpen, "sharpen", "", "Put back the local contrast that averaging removes when building the parent tiles of these zooms: \"min-max[:strength]\" ranges, comma-separated, e.g. \"0-8\" or \"0-6,7-9:0.5\" (strength 1 restores all of it; not for terrarium, nearest, mode)")
 	flag.BoolVar(&verbose, "verbose", false, "Verbose progress output")
+	flag.IntVar(&nanReport, "nan-report", 0, "Float input: log the N tiles per zoom where most samples fell back to nearest-neighbour because of nodata (NaN) neighbours, to locate bad source regions (0 = only the totals, with --verbose)")
+	flag.StringVar(&unitsName, "units", "binary", "Units for sizes in the output: binary (KiB, MiB, GiB) or si (kB, MB, GB); the decimal separator follows the locale (LC_ALL, LC_NUMERIC, LANG)")
+	flag.BoolVar(&machineReadable, "machine-readable", false, "Print sizes as plain bytes and durations as plain seconds, for scripts parsing the output")
+	flag.BoolVar(&showTiming, "timing", false, "Print a per-zoom timing table (render, downsample, encode, write, store, finalize) at the end; implied by --verbose")
 	flag.BoolVar(&showVersion, "version", false, "Print version and exit")
+	flag.BoolVar(&versionJSON, "json", false, "With --version, print version, commit, build date, Go version and enabled features as JSON")
+	flag.BoolVar(&fsync, "fsync", false, "Fsync the archive and its directory when finalizing, so it survives a power loss (the archive is always written to .partial and renamed)")
+	flag.BoolVar(&streaming, "streaming", false, "Write tile data straight into the archive instead of a temp file, so finalizing writes only the directories and the disk holds the data once (--stable-layout layout; clustered where the file system can collapse ranges, e.g. ext4 and XFS)")
+	flag.StringVar(&outputFormat, "output-format", "", "Output container: pmtiles, or mbtiles (an SQLite file, for pipelines that consume MBTiles) (default: mbtiles for a .mbtiles output, else pmtiles)")
+	flag.BoolVar(&stableLayout, "stable-layout", false, "Put tile data at a fixed offset ahead of metadata and leaf directories, so rebuilds keep unchanged tiles at the same byte offsets for rsync/zsync/S3 multipart deltas")
+	flag.StringVar(&tileFilter, "tile-filter", "", "Shell command each encoded tile is piped through (stdin → stdout) before writing, e.g. \"pngquant -\"; gets TILE_Z, TILE_X, TILE_Y, TILE_FORMAT in its environment")
+	flag.BoolVar(&poolCheck, "pool-check", false, "Track pooled tile images and report any not returned after generation, by call site (diagnostic; slower)")
 	flag.StringVar(&cpuProfile, "cpuprofile", "", "Write CPU profile to file")
 	flag.StringVar(&memProfile, "memprofile", "", "Write memory profile to file")
+	flag.StringVar(&overlapCheck, "overlap-check", "off", "Sample regions where sources overlap and report how much they disagree: off, report (warn above --overlap-threshold), fail (abort above it)")
+	flag.Float64Var(&overlapMaxDelta, "overlap-threshold", 16, "Mean per-sample difference above which --overlap-check flags a pair (0-255 for 8-bit output, data units for float)")
+	flag.BoolVar(&inputOrder, "input-order", false, "Where sources overlap, take them in input order instead of preferring the one whose resolution best matches the output (coarser sources then only fill uncovered pixels)")
+	flag.BoolVar(&levelByLevel, "level-by-level", false, "Finish each zoom level before starting the next instead of downsampling parents as soon as their children are done (same output; for comparison)")
+	flag.StringVar(&memCheck, "mem-check", "report", "Estimate peak memory before starting and compare it with available RAM: off, report (warn if it does not fit), fail (abort if it does not fit); also reports the actual peak RSS at the end")
 	flag.IntVar(&memLimitMB, "mem-limit", 0, "Tile store memory limit in MB before disk spilling (0 = auto ~90% of RAM)")
+	flag.StringVar(&tempDir, "temp-dir", "", "Directory for tile store spill files, e.g. a fast scratch disk (default: next to the output); also for the writer temp file when on the output's file system. Where both share a directory, the stores read tiles back from the writer temp file instead of writing spill files")
+	flag.StringVar(&writerTempDir, "writer-temp-dir", "", "Directory for the writer temp file holding all tile data (default: --temp-dir if on the output's file system, else next to the output)")
+	flag.IntVar(&encodeCacheMB, "encode-cache", 0, "Keep up to this many MB of encoded tiles by content, so repeated tiles (e.g. flat areas of classified data) skip encoding (0 = off)")
+	flag.IntVar(&sourceCacheN, "source-cache", 0, "Decoded source tiles to keep in memory (0 = auto, 128 per worker); raise it where decoding is the bottleneck, e.g. on fast NVMe")
+	flag.IntVar(&rawCacheMB, "raw-cache", 0, "Keep up to this many MB of compressed source tiles, so tiles dropped from the --source-cache are decoded again without rereading storage, e.g. on network file systems (0 = off)")
+	flag.IntVar(&remoteCacheMB, "remote-cache", remote.DefaultCacheBytes>>20, "Block cache in MB for inputs read from http(s):// and s3:// URLs, shared by all remote inputs")
 	flag.BoolVar(&noSpill, "no-spill", false, "Disable disk spilling (keep all tiles in memory)")
+	flag.StringVar(&tileOrderStr, "tile-order", "hilbert", "Curve along which workers take the tiles of each zoom level: hilbert, or zorder (Morton order, simpler and nearly as local); changes cache behaviour, not the output")
+	flag.StringVar(&spillFormat, "spill-format", tile.SpillEncoded, "What the tile stores keep of each tile for its parent: encoded (the output bytes, decoded on read-back) or raw (the pixels: 4 bytes each in memory and in the spill files, read back without a decode, e.g. for WebP at high quality)")
 	flag.StringVar(&fillColor, "fill-color", "0,0,0,0", "Substitute transparent/nodata with RGBA (color transform); also fill missing tile positions, e.g. \"0,0,0,255\" or \"#000000ff\" (default: transparent)")
+	flag.StringVar(&bilevelFG, "bilevel-foreground", "", "1-bit input (scanned plans, masks): color of the black pixels, e.g. \"#1f3a93\" (default: black)")
+	flag.StringVar(&bilevelBG, "bilevel-background", "", "1-bit input: color of the white pixels, e.g. \"#ffffff00\" for a transparent overlay (default: white)")
+	flag.StringVar(&background, "background", "", "Composite transparent and semi-transparent pixels over this opaque color before encoding, e.g. \"#ffffff\" for JPEG (default: none; transparent pixels become black in JPEG)")
+	flag.BoolVar(&premultiply, "premultiply-alpha", false, "Store PNG/WebP tiles with their colors multiplied by alpha, for renderers that blend premultiplied textures; recorded as premultiplied_alpha in the metadata")
 	flag.StringVar(&attribution, "attribution", "", "Attribution string for data sources (stored in metadata)")
-	flag.StringVar(&layerType, "type", "baselayer", "Layer type: baselayer, overlay")
+	flag.StringVar(&layerType, "type", "baselayer", "Layer type: baselayer, overlay (overlay: alpha format, JPEG rejected, missing tiles left out instead of filled)")
+	flag.StringVar(&tilesetName, "name", "", "Tileset name shown by tile servers and catalogs (stored in metadata; default: \"geotiff2pmtiles\")")
+	flag.StringVar(&tilesetVersion, "tileset-version", "", "Tileset version stored in metadata, e.g. \"1.2.0\" (default: none)")
+	flag.StringVar(&layerID, "layer-id", "", "Stable layer identifier stored as \"id\" in metadata (default: none)")
 	flag.StringVar(&bandsStr, "bands", "1,2,3", "1-indexed band numbers for R,G,B output (e.g. \"4,1,2\" for NIR-R-G)")
-	flag.StringVar(&alphaBandStr, "alpha-band", "auto", "1-indexed band for alpha (0=auto: band 4 for 8-bit spp>=4; -1=force no alpha)")
+	flag.StringVar(&alphaBandStr, "alpha-band", "auto", "1-indexed band for alpha (0=auto: band 4 for 8-bit spp>=4; -1=force no alpha, ignoring mask IFDs)")
 	flag.StringVar(&rescaleStr, "rescale", "auto", "Rescale mode: auto, log, linear, none (auto: requires --rescale-range for 16-bit)")
-	flag.StringVar(&rescaleRange, "rescale-range", "", "Input value range for rescaling: min,max (required for 16-bit data)")
-	flag.StringVar(&nodataStr, "nodata", "", "Nodata value: pixels with all bands equal to this integer are transparent (auto-detected from GeoTIFF if not set)")
+	flag.StringVar(&rescaleRange, "rescale-range", "", "Input value range for rescaling: min,max, or percentiles of the data as pLO,pHI, e.g. \"p2,p98\" (required for 16-bit data)")
+	flag.StringVar(&nodataStr, "nodata", "", "Nodata values: pixels with all bands matching are transparent (float input: masked before resampling); a list of values and ranges, e.g. \"-9999,-32767\", \"0,255\" or \"<-1000\"; per file with --manifest (auto-detected from GeoTIFF if not set)")
+	flag.IntVar(&assumeEPSG, "assume-epsg", 0, "CRS of inputs without GeoKeys (plain TIFF + world file), as an EPSG code, e.g. 2056, 3857, 4326, or 25832; required when the CRS guessed from their coordinates is uncertain (default: guess)")
+	flag.StringVar(&manifestPath, "manifest", "", "JSON file with per-source settings matched by path pattern, e.g. a nodata spec per DEM tile (see README)")
+	flag.StringVar(&contourInterval, "contour-interval", "", "With --format contours: elevation interval between lines, one value for all zooms (\"25\") or \"min-max:interval\" ranges, comma-separated, e.g. \"0-10:100,11-12:50,13-14:10\" (default: 500 at zoom ≤ 8 down to 10 from zoom 13)")
+	flag.Float64Var(&hillshadeAz, "hillshade-azimuth", tile.DefaultHillshade.Azimuth, "With --format hillshade: direction the light comes from, degrees clockwise from north")
+	flag.Float64Var(&hillshadeAlt, "hillshade-altitude", tile.DefaultHillshade.Altitude, "With --format hillshade: angle of the light above the horizon, degrees (0 < altitude ≤ 90)")
+	flag.Float64Var(&hillshadeZ, "hillshade-z-factor", tile.DefaultHillshade.ZFactor, "With --format hillshade: vertical exaggeration; 0.3048 for DEMs in feet")
+	flag.StringVar(&hillshadeStyle, "hillshade-style", "gray", "With --format hillshade: gray, or color (shading over an elevation tint)")
+	flag.StringVar(&hillshadeEnc, "hillshade-encoding", "png", "With --format hillshade: tile encoding of the shaded relief: png, jpeg, webp")
+	flag.StringVar(&colorMapSpec, "color-map", "", "Color single-band input (classes, NDVI, ...) with a color ramp: a GDAL color-relief text file (\"value R G B [A]\" lines), a JSON file, or inline JSON [[value, \"#rrggbb\"], ...]")
+	flag.StringVar(&colorMapMode, "color-map-mode", "interpolate", "With --color-map: interpolate (blend between stops), or discrete (each stop's color up to the next stop, for classes)")
+	flag.IntVar(&fillVoids, "fill-voids", 0, "Terrarium and hillshade: fill nodata holes of up to this many output pixels by inverse-distance interpolation from their rim (0 = off)")
+	flag.StringVar(&serveAddr, "serve", "", "Serve tiles over HTTP at this address (e.g. \":8080\"), rendering on demand and caching into the output archive")
+	flag.DurationVar(&flushInterval, "flush-interval", 5*time.Minute, "With --serve: how often to merge rendered tiles into the output archive (0 = only on shutdown); 256 MiB of new tiles flush early")
+	flag.StringVar(&previewAddr, "preview", "", "Serve finished zoom levels read-only over HTTP at this address during generation (e.g. \":8080\"); implies --level-by-level")
+	flag.StringVar(&profileName, "profile", "", "Target client preset: "+strings.Join(profile.Names(), ", ")+" (sets tile size, format, quality; explicit flags win)")
+	flag.BoolVar(&debugOverlay, "debug-overlay", false, "Draw tile boundaries and z/x/y labels onto every tile (diagnostic archive for checking georeferencing)")
+	flag.Float64Var(&graticule, "graticule", 0, "With --debug-overlay: also draw a lat/lon graticule every N degrees (0 = none)")
+	flag.StringVar(&terrainOutput, "terrain-output", "", "Split mixed inputs: write float (DEM) sources as Terrarium to this archive, generated in the same pass as the imagery output")
+	flag.BoolVar(&splitByDate, "split-by-date", false, "Write one archive per acquisition date, <output>-<date>.pmtiles, all in one pass over the shared sources; dates come from --manifest \"date\" entries or the GDAL acquisition date")
+	flag.StringVar(&shardSpec, "shard", "", "Split the output into archives <output>-<shard>.pmtiles generated side by side: comma-separated zoom bands, each optionally cut into a COLSxROWS grid at its min zoom, e.g. \"0-7,8-@4x4\" (an open band runs to the max zoom); recombine them with pmtransform --merge")
+	flag.IntVar(&shardIndex, "shard-index", -1, "With --shard: generate only this shard (0-based, as listed in the settings summary), to spread the shards over machines")
+	flag.BoolVar(&incrementalRun, "incremental", false, "Record input digests in <output>.state.json and, on re-runs, regenerate only the tiles of inputs that changed, copying the rest from the existing archive")
+	flag.StringVar(&reportPath, "report", "", "Write a JSON run report (settings, tile counts, per-zoom timing, dedup ratio, coverage gaps, warnings) to this path (default: <output>.run-report.json; off = none)")
+	flag.BoolVar(&resume, "resume", false, "Checkpoint the run into <output>.resume after every zoom level and, if a checkpoint of the same settings and inputs is there, continue below its last finished level (implies --level-by-level)")
+	flag.StringVar(&batchPath, "batch", "", "Run the jobs of this JSON file (an array of daemon job objects: output, bounds, zooms, format, ...) one after another over the inputs, sharing their caches; no output argument")
+	flag.StringVar(&daemonAddr, "daemon", "", "Keep the inputs open and run jobs submitted as JSON to POST /jobs at this address (\":8090\" or \"unix:/path.sock\"); no output argument")
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: geotiff2pmtiles [flags] <input-dir-or-files...> <output.pmtiles>\n\n")
-		fmt.Fprintf(os.Stderr, "Convert GeoTIFF/COG files to a PMTiles v3 archive.\n")
-		fmt.Fprintf(os.Stderr, "Directories are scanned recursively for .tif/.tiff files.\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: geotiff2pmtiles [flags] <input-dir-or-files...> <output.pmtiles|.mbtiles>\n")
+		fmt.Fprintf(os.Stderr, "       geotiff2pmtiles --daemon <addr> [flags] <input-dir-or-files...>\n")
+		fmt.Fprintf(os.Stderr, "       geotiff2pmtiles --batch <jobs.json> [flags] <input-dir-or-files...>\n\n")
+		fmt.Fprintf(os.Stderr, "Convert GeoTIFF/COG files to a PMTiles v3 archive or an MBTiles file.\n")
+		fmt.Fprintf(os.Stderr, "Directories are scanned recursively for .tif/.tiff files.\n")
+		fmt.Fprintf(os.Stderr, "GDAL .vrt mosaics given as inputs are expanded into the files they reference.\n")
+		fmt.Fprintf(os.Stderr, "Raster .pmtiles archives given as inputs fill the pixels no GeoTIFF covers.\n\n")
 		fmt.Fprintf(os.Stderr, "Flags:\n")
 		flag.PrintDefaults()
 	}
 
 	flag.Parse()
 
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	// Collect warnings for the run report while logging them as before.
+	warnings := new(report.Warnings)
+	log.SetOutput(io.MultiWriter(os.Stderr, warnings))
+
+	// Fill in what -ldflags did not stamp (e.g. plain `go install`) from
+	// the build info the toolchain embeds.
+	build := buildinfo.New("geotiff2pmtiles", version, commit, buildDate)
+	version, commit, buildDate = build.Version, build.Commit, build.BuildDate
+	if versionJSON && !showVersion {
+		log.Fatal("--json requires --version")
+	}
 	if showVersion {
-		fmt.Printf("geotiff2pmtiles %s (commit %s, built %s)\n", version, commit, buildDate)
+		if versionJSON {
+			data, err := build.JSON()
+			if err != nil {
+				log.Fatalf("Failed to encode version: %v", err)
+			}
+			fmt.Println(string(data))
+		} else {
+			fmt.Println(build)
+		}
 		os.Exit(0)
 	}
+	var err error
+	if units, err = ui.New(unitsName, machineReadable); err != nil {
+		log.Fatalf("--units: %v", err)
+	}
 
 	// CPU profiling.
 	if cpuProfile != "" {
@@ -128,16 +306,183 @@ func main() {
 	}
 
 	args := flag.Args()
-	if len(args) < 2 {
-		flag.Usage()
-		os.Exit(1)
+	var outputPath string
+	inputPaths := args
+	if daemonAddr != "" || batchPath != "" {
+		// Jobs name their own outputs.
+		if len(args) < 1 {
+			flag.Usage()
+			os.Exit(1)
+		}
+	} else {
+		if len(args) < 2 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		outputPath = args[len(args)-1]
+		inputPaths = args[:len(args)-1]
+
+	}
+	var mbtilesOutput bool
+	switch outputFormat {
+	case "":
+		mbtilesOutput = strings.HasSuffix(outputPath, ".mbtiles")
+	case "pmtiles":
+	case "mbtiles":
+		mbtilesOutput = true
+	default:
+		log.Fatalf("--output-format: unknown format %q (want pmtiles or mbtiles)", outputFormat)
+	}
+	// PMTiles archives among the inputs backfill what the GeoTIFFs leave
+	// uncovered.
+	inputPaths, backfillPaths := splitBackfillInputs(inputPaths)
+	if err := validateFlags(runFlags{
+		explicit:        explicit,
+		output:          outputPath,
+		mbtiles:         mbtilesOutput,
+		backfill:        backfillPaths,
+		format:          format,
+		daemon:          daemonAddr,
+		batch:           batchPath,
+		serve:           serveAddr,
+		preview:         previewAddr,
+		terrainOutput:   terrainOutput,
+		zoomOffset:      zoomOffset,
+		splitByDate:     splitByDate,
+		shard:           shardSpec,
+		shardIndex:      shardIndex,
+		incremental:     incrementalRun,
+		resume:          resume,
+		streaming:       streaming,
+		stableLayout:    stableLayout,
+		writerTempDir:   writerTempDir,
+		targetSizeMB:    targetSizeMB,
+		reportPath:      reportPath,
+		debugOverlay:    debugOverlay,
+		graticule:       graticule,
+		tileFilter:      tileFilter,
+		contourInterval: contourInterval,
+		hillshadeAlt:    hillshadeAlt,
+		hillshadeZ:      hillshadeZ,
+		hillshadeStyle:  hillshadeStyle,
+		hillshadeEnc:    hillshadeEnc,
+		colorMap:        colorMapSpec,
+		colorMapMode:    colorMapMode,
+	}); err != nil {
+		log.Fatal(err)
+	}
+
+	var batchJobs []daemon.Job
+	if batchPath != "" {
+		if batchJobs, err = daemon.LoadJobs(batchPath); err != nil {
+			log.Fatalf("--batch: %v", err)
+		}
+	}
+	if reportPath == "" && daemonAddr == "" && batchPath == "" && !splitByDate && shardSpec == "" {
+		reportPath = report.Path(outputPath)
+	}
+	var shardBands []shard.Band
+	if shardSpec != "" {
+		bands, err := shard.Parse(shardSpec)
+		if err != nil {
+			log.Fatalf("--shard: %v", err)
+		}
+		shardBands = bands
+	}
+	if resume {
+		// Checkpoints exist only between finished levels.
+		levelByLevel = true
+	}
+	if previewAddr != "" {
+		// The pipelined schedule finishes every zoom near the end of the
+		// max zoom; level by level, each zoom can be checked while the
+		// lower ones are still being rendered.
+		levelByLevel = true
+	}
+
+	// Contour output runs the Terrarium pipeline and traces each finished
+	// tile into vector contour lines on its way to the archive.
+	contours := format == "contours"
+	var contourIntervals []vector.IntervalRange
+	if contours {
+		format = "terrarium"
+	}
+	if contourInterval != "" {
+		ranges, err := vector.ParseIntervals(contourInterval)
+		if err != nil {
+			log.Fatalf("Contour interval: %v", err)
+		}
+		contourIntervals = ranges
+	}
+
+	// Hillshade output renders the float sources as shaded relief, which
+	// is then encoded and downsampled like imagery.
+	hillshade := format == "hillshade"
+	var hs *tile.Hillshade
+	if hillshade {
+		hs = &tile.Hillshade{Azimuth: hillshadeAz, Altitude: hillshadeAlt, ZFactor: hillshadeZ, Color: hillshadeStyle == "color"}
+		format = hillshadeEnc
 	}
 
-	outputPath := args[len(args)-1]
-	inputPaths := args[:len(args)-1]
+	// A color map reads single-band sources as values and colors them,
+	// which are then encoded and downsampled like imagery.
+	var colorMap *tile.ColorMap
+	if colorMapSpec != "" {
+		var err error
+		if colorMap, err = tile.LoadColorMap(colorMapSpec); err != nil {
+			log.Fatalf("--color-map: %v", err)
+		}
+		colorMap.Discrete = colorMapMode == "discrete"
+		// Nodata and values outside a discrete map are transparent, which
+		// the JPEG default cannot show.
+		if !explicit["format"] {
+			format = "png"
+		}
+	}
+
+	baseQuality, qualityRanges, err := tile.ParseQuality(qualitySpec)
+	if err != nil {
+		log.Fatalf("--quality: %v", err)
+	}
+	quality = 85
+	if baseQuality != 0 {
+		quality = baseQuality
+	}
+	if len(qualityRanges) > 0 && targetSizeMB > 0 {
+		log.Fatal("--target-size cannot be combined with a per-zoom --quality")
+	}
+	if quality == encode.Lossless && targetSizeMB > 0 {
+		log.Fatal("--target-size cannot be combined with --quality lossless")
+	}
+
+	// Apply the client profile to the settings not given on the command
+	// line. The format depends on the sources and is chosen once they are open.
+	var prof *profile.Profile
+	if profileName != "" {
+		p, err := profile.Lookup(profileName)
+		if err != nil {
+			log.Fatalf("Profile: %v", err)
+		}
+		prof = &p
+		if !explicit["tile-size"] {
+			tileSize = p.TileSize
+		}
+		if baseQuality == 0 || !explicit["quality"] {
+			quality = p.Quality
+		}
+	}
+
+	switch memCheck {
+	case "off", "report", "fail":
+	default:
+		log.Fatalf("--mem-check: unknown mode %q (want off, report, fail)", memCheck)
+	}
 
-	if !strings.HasSuffix(outputPath, ".pmtiles") {
-		log.Fatal("Output file must have .pmtiles extension")
+	if err := tile.ValidateTileSize(tileSize); err != nil {
+		log.Fatalf("Tile size: %v", err)
+	}
+	if !tile.IsPowerOfTwo(tileSize) {
+		log.Printf("WARNING: tile size %d is not a power of two; most map clients expect 256 or 512", tileSize)
 	}
 
 	// Resolve tile encoder.
@@ -151,6 +496,15 @@ func main() {
 	if err != nil {
 		log.Fatalf("Resampling: %v", err)
 	}
+	var sharpenRanges []tile.SharpenRange
+	if sharpen != "" {
+		if _, ok := encode.DEMEncodingFor(format); ok {
+			log.Fatalf("--sharpen does not apply to %s output (elevations must not be altered)", format)
+		}
+		if sharpenRanges, err = tile.ParseSharpen(sharpen); err != nil {
+			log.Fatalf("Sharpen: %v", err)
+		}
+	}
 
 	// Parse fill color.
 	var fc *color.RGBA
@@ -162,15 +516,69 @@ func main() {
 		fc = &c
 	}
 
+	// Parse background color.
+	var bg *color.RGBA
+	if background != "" {
+		c, err := parseColor(background)
+		if err != nil {
+			log.Fatalf("Background: %v", err)
+		}
+		if c.A != 255 {
+			log.Fatalf("Background: color must be opaque, got alpha %d", c.A)
+		}
+		bg = &c
+	}
+
+	// Overlays are drawn over another layer, so their tiles need alpha.
+	var overlay bool
+	switch layerType {
+	case "baselayer":
+	case "overlay":
+		overlay = true
+		if explicit["format"] && (format == "jpeg" || format == "jpg") {
+			log.Fatal("--type overlay cannot be used with JPEG: tiles would be opaque and hide the layer below (use png or webp)")
+		}
+		if bg != nil {
+			log.Fatal("--type overlay cannot be used with --background: flattened tiles would hide the layer below")
+		}
+		if fc != nil && fc.A == 255 {
+			log.Printf("Warning: --fill-color %s is opaque; nodata pixels of the overlay will hide the layer below", fillColor)
+		}
+	default:
+		log.Fatalf("--type: unknown layer type %q (want baselayer or overlay)", layerType)
+	}
+
+	// Load the per-source manifest.
+	var mf *manifest.Manifest
+	if manifestPath != "" {
+		mf, err = manifest.Load(manifestPath)
+		if err != nil {
+			log.Fatalf("Manifest: %v", err)
+		}
+	}
+
 	// Collect GeoTIFF files.
 	tiffFiles, err := collectTIFFs(inputPaths)
 	if err != nil {
 		log.Fatalf("Collecting input files: %v", err)
 	}
 	if len(tiffFiles) == 0 {
+		if len(backfillPaths) > 0 {
+			log.Fatal("No GeoTIFF files found in the specified inputs; PMTiles inputs only backfill them (pmtransform converts archives)")
+		}
 		log.Fatal("No GeoTIFF files found in the specified inputs")
 	}
 	log.Printf("Found %d GeoTIFF file(s)", len(tiffFiles))
+	remoteInputs := 0
+	for _, p := range tiffFiles {
+		if remote.IsURL(p) {
+			remoteInputs++
+		}
+	}
+	if remoteInputs > 0 && incrementalRun {
+		log.Fatal("--incremental needs local inputs: remote files cannot be hashed without downloading them")
+	}
+	remote.DefaultCache.SetLimit(int64(remoteCacheMB) * 1024 * 1024)
 
 	// Open all COG readers and gather metadata.
 	// OpenAll validates that all files exist before opening any,
@@ -180,14 +588,83 @@ func main() {
 	if err != nil {
 		log.Fatalf("Opening GeoTIFFs:\n%v", err)
 	}
+	allSources := sources
+	var rawCache *cog.RawCache
+	if rawCacheMB > 0 {
+		rawCache = cog.NewRawCache(int64(rawCacheMB) * 1024 * 1024)
+		for _, src := range sources {
+			src.SetRawCache(rawCache)
+		}
+	}
 	defer func() {
-		for _, s := range sources {
+		for _, s := range allSources {
 			s.Close()
 		}
 	}()
 
 	if verbose {
-		log.Printf("Opened %d COG(s) in %v", len(sources), time.Since(start).Round(time.Millisecond))
+		log.Printf("Opened %d COG(s) in %s", len(sources), units.Duration(time.Since(start)))
+	}
+
+	backfill, backfillReaders, err := openBackfill(backfillPaths, len(allSources))
+	if err != nil {
+		log.Fatalf("Opening PMTiles inputs: %v", err)
+	}
+	defer func() {
+		for _, r := range backfillReaders {
+			r.Close()
+		}
+	}()
+
+	// Inputs without GeoKeys get a CRS guessed from their coordinates; a
+	// wrong guess shifts the whole archive, so uncertain ones need a flag.
+	if err := auditEPSG(allSources, assumeEPSG, verbose); err != nil {
+		log.Fatalf("CRS: %v", err)
+	}
+
+	// Split mode: the float sources become the terrain layer, everything
+	// else below (band config, presets, zoom) applies to the imagery.
+	var terrainSources []*cog.Reader
+	if terrainOutput != "" {
+		var imagery []*cog.Reader
+		for _, s := range sources {
+			if s.IsFloat() {
+				terrainSources = append(terrainSources, s)
+			} else {
+				imagery = append(imagery, s)
+			}
+		}
+		if len(imagery) == 0 || len(terrainSources) == 0 {
+			log.Fatalf("--terrain-output needs both imagery and float (DEM) inputs, got %d imagery and %d float file(s)",
+				len(imagery), len(terrainSources))
+		}
+		sources = imagery
+		log.Printf("Split: %d imagery file(s) → %s, %d terrain file(s) → %s",
+			len(imagery), outputPath, len(terrainSources), terrainOutput)
+	}
+
+	// Irregular overview chains (e.g. 1, 4, 16) are filled in memory by the
+	// reader; computing those levels on the fly costs extra reads.
+	for _, s := range sources {
+		if factors := s.SynthesizedOverviews(); len(factors) > 0 {
+			log.Printf("WARNING: %s: overview chain has gaps; synthesizing %s overview(s) in memory "+
+				"(rebuild with e.g. gdaladdo -r average %s 2 4 8 16 ... for faster runs)",
+				s.Path(), joinFactors(factors), s.Path())
+		}
+	}
+
+	// Non-square pixels are sampled with both sizes, but the max zoom and
+	// the overviews can only follow one of them: the finer.
+	var nonSquare []*cog.Reader
+	for _, s := range sources {
+		if s.NonSquarePixels() {
+			nonSquare = append(nonSquare, s)
+		}
+	}
+	if len(nonSquare) > 0 {
+		geo := nonSquare[0].GeoInfo()
+		log.Printf("WARNING: %d source(s) have non-square pixels, e.g. %s (%g × %g CRS units); sampling uses both sizes, the max zoom and overviews follow the finer one",
+			len(nonSquare), nonSquare[0].Path(), geo.PixelSizeX, geo.PixelSizeY)
 	}
 
 	// Check for geographic holes in coverage.
@@ -203,9 +680,11 @@ func main() {
 	// Auto-detect preset from GeoTIFF structure and GDAL metadata.
 	// Apply format override (e.g. terrarium for float data) before band config
 	// parsing so that the format is settled before we proceed.
+	presetFormat := false
 	if preset, ok := sources[0].DetectPreset(); ok {
-		if preset.Format != "" && format == "jpeg" {
+		if preset.Format != "" && format == "jpeg" && !hillshade && colorMap == nil {
 			format = preset.Format
+			presetFormat = true
 			log.Printf("Auto-detected: %s (format: %s)", preset.Name, format)
 			enc, err = encode.NewEncoder(format, quality)
 			if err != nil {
@@ -214,42 +693,223 @@ func main() {
 		}
 	}
 
-	// Validate terrarium requires float input.
-	if format == "terrarium" && !sources[0].IsFloat() {
-		log.Fatal("Terrarium format requires float GeoTIFF input (elevation data)")
+	// Validate elevation encodings require float input.
+	demEncoding, elevation := encode.DEMEncodingFor(format)
+	if elevation && !sources[0].IsFloat() {
+		if contours {
+			log.Fatal("Contour format requires float GeoTIFF input (elevation data)")
+		}
+		log.Fatalf("%s format requires float GeoTIFF input (elevation data)", format)
+	}
+	if hillshade && !sources[0].IsFloat() {
+		log.Fatal("Hillshade format requires float GeoTIFF input (elevation data)")
+	}
+	if len(backfill) > 0 && (elevation || hillshade || colorMap != nil) {
+		log.Fatalf("PMTiles inputs hold images, not values: they cannot backfill --format %s or --color-map output", format)
+	}
+	if colorMap != nil && sources[0].SamplesPerPixel() != 1 {
+		log.Fatalf("--color-map requires single-band input, %s has %d bands", sources[0].Path(), sources[0].SamplesPerPixel())
+	}
+	if bg != nil && elevation {
+		log.Fatalf("--background cannot be used with %s: flattened pixels would decode as elevations", format)
+	}
+	if overlay && elevation {
+		log.Fatalf("--type overlay cannot be used with %s: elevation tiles are not drawn over other layers", format)
+	}
+	if debugOverlay && elevation {
+		log.Fatalf("--debug-overlay cannot be used with %s: overlay pixels would decode as elevations", format)
+	}
+	if fillVoids < 0 {
+		log.Fatalf("--fill-voids must be >= 0, got %d", fillVoids)
+	}
+	if encodeCacheMB < 0 {
+		log.Fatalf("--encode-cache must be >= 0, got %d", encodeCacheMB)
+	}
+	if maxTileBytes < 0 {
+		log.Fatalf("--max-tile-bytes must be >= 0, got %d", maxTileBytes)
+	}
+	if nanReport < 0 {
+		log.Fatalf("--nan-report must be >= 0, got %d", nanReport)
+	}
+	if remoteCacheMB < 0 {
+		log.Fatalf("--remote-cache must be >= 0, got %d", remoteCacheMB)
+	}
+	if sourceCacheN < 0 {
+		log.Fatalf("--source-cache must be >= 0, got %d", sourceCacheN)
+	}
+	if rawCacheMB < 0 {
+		log.Fatalf("--raw-cache must be >= 0, got %d", rawCacheMB)
+	}
+
+	// Classification rasters: interpolating between class codes invents
+	// classes that are not in the data, so they default to mode resampling.
+	if colorMap != nil && colorMap.Discrete && !explicit["resampling"] {
+		resampling = "mode"
+		resamplingMode = tile.ResamplingMode
+	} else if !elevation && !hillshade {
+		if reason, ok := sources[0].DetectCategorical(); ok {
+			switch {
+			case !explicit["resampling"]:
+				resampling = "mode"
+				resamplingMode = tile.ResamplingMode
+				log.Printf("Auto-detected categorical raster (%s): using mode resampling (set --resampling to override)", reason)
+			case resamplingMode != tile.ResamplingMode && resamplingMode != tile.ResamplingNearest:
+				log.Printf("WARNING: %s looks categorical (%s); --resampling %s will blend class values (use mode or nearest)",
+					sources[0].Path(), reason, resampling)
+			}
+		}
+	}
+	if sharpen != "" && (resamplingMode == tile.ResamplingNearest || resamplingMode == tile.ResamplingMode) {
+		log.Printf("WARNING: --sharpen is ignored with %s resampling, which does not average", resampling)
+	}
+	if fillVoids > 0 && !elevation && !hillshade && terrainOutput == "" {
+		log.Fatal("--fill-voids requires terrarium, terrainrgb, or hillshade output (float elevation input)")
 	}
 
 	// Parse band config.
-	bandCfg, err := parseBandConfig(bandsStr, alphaBandStr, rescaleStr, rescaleRange, sources[0])
+	bandCfg, stretch, err := parseBandConfig(bandsStr, alphaBandStr, rescaleStr, rescaleRange, sources[0])
 	if err != nil {
 		log.Fatalf("Band config: %v", err)
 	}
+	if bilevelFG != "" || bilevelBG != "" {
+		if sources[0].BitsPerSample() != 1 || sources[0].SamplesPerPixel() != 1 {
+			log.Fatalf("--bilevel-foreground and --bilevel-background require 1-bit input, %s has %d band(s) of %d bits",
+				sources[0].Path(), sources[0].SamplesPerPixel(), sources[0].BitsPerSample())
+		}
+		bandCfg.HasBilevelColors = true
+		bandCfg.BilevelColors = [2]color.RGBA{{0, 0, 0, 255}, {255, 255, 255, 255}}
+		for i, spec := range []string{bilevelFG, bilevelBG} {
+			if spec == "" {
+				continue
+			}
+			if bandCfg.BilevelColors[i], err = parseColor(spec); err != nil {
+				log.Fatalf("--bilevel-%s: %v", [2]string{"foreground", "background"}[i], err)
+			}
+		}
+	}
 
-	// Apply nodata: CLI override takes precedence, then preset/IFD auto-detection.
-	if nodataStr != "" {
-		v, err := strconv.ParseFloat(strings.TrimSpace(nodataStr), 64)
-		if err != nil || v < 0 || v > 65535 || v != math.Floor(v) {
-			log.Fatalf("--nodata: must be a non-negative integer ≤ 65535, got %q", nodataStr)
+	// Apply nodata: manifest entries and the CLI override take precedence,
+	// then preset/IFD auto-detection. Every source gets its nodata spec (see
+	// applyNoData); the band config's single integer value is what the
+	// description and the profile see of 8/16-bit nodata.
+	if sources[0].IsFloat() || colorMap != nil {
+		if err := applyNoData(sources, nodataStr, mf, verbose); err != nil {
+			log.Fatalf("--nodata: %v", err)
+		}
+	} else if nodataStr != "" || mf.HasNoData() {
+		if nodataStr != "" {
+			spec, err := cog.ParseNoDataSpec(nodataStr)
+			if err != nil {
+				log.Fatalf("--nodata: %v", err)
+			}
+			lo, hi := integerNoDataRange(sources[0])
+			for _, v := range spec.Values {
+				if v < lo || v > hi || v != math.Floor(v) {
+					log.Fatalf("--nodata: values must be integers from %g to %g, got %g", lo, hi, v)
+				}
+			}
+			if len(spec.Values) == 1 && !spec.HasBelow && !spec.HasAbove {
+				bandCfg.HasNodata = true
+				bandCfg.Nodata = spec.Values[0]
+			}
+		}
+		if err := applyNoData(sources, nodataStr, mf, verbose); err != nil {
+			log.Fatalf("--nodata: %v", err)
 		}
-		bandCfg.HasNodata = true
-		bandCfg.Nodata = v
-	} else if !bandCfg.HasNodata {
+	} else if lo, hi := integerNoDataRange(sources[0]); !bandCfg.HasNodata {
 		// Auto-detect from the first source file if not already set by preset.
 		if nd := sources[0].NoData(); nd != "" {
-			if v, err := strconv.ParseFloat(strings.TrimSpace(nd), 64); err == nil && v >= 0 && v <= 65535 && v == math.Floor(v) {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(nd), 64); err == nil && v >= lo && v <= hi && v == math.Floor(v) {
 				bandCfg.HasNodata = true
 				bandCfg.Nodata = v
 			}
 		}
 	}
 
+	if stretch {
+		lo, hi, err := cog.Percentiles(sources, bandCfg, bandCfg.RescaleMin, bandCfg.RescaleMax)
+		if err != nil {
+			log.Fatalf("--rescale-range: %v", err)
+		}
+		log.Printf("Rescale range: p%g-p%g of the sources is [%g, %g]", bandCfg.RescaleMin, bandCfg.RescaleMax, lo, hi)
+		bandCfg.RescaleMin, bandCfg.RescaleMax = lo, hi
+	}
+
+	if len(terrainSources) > 0 {
+		// --nodata is the imagery's; DEM nodata comes from the files and
+		// the manifest.
+		if err := applyNoData(terrainSources, "", mf, verbose); err != nil {
+			log.Fatalf("--manifest: %v", err)
+		}
+	}
+
+	if mf != nil {
+		for _, pattern := range mf.Unused(tiffFiles) {
+			log.Printf("WARNING: --manifest: pattern %q matches no input file", pattern)
+		}
+	}
+
+	// Date series: every acquisition date becomes a layer of the pass and
+	// an archive of its own.
+	var series []dateGroup
+	if splitByDate {
+		if series, err = groupByDate(sources, mf, outputPath); err != nil {
+			log.Fatalf("--split-by-date: %v", err)
+		}
+	}
+
+	// Profile format: pick the profile's alpha-capable format when the
+	// output can contain transparent pixels.
+	if prof != nil && !explicit["format"] && !presetFormat && !sources[0].IsFloat() {
+		src := sources[0]
+		transparent := overlay || colorMap != nil || bandCfg.AlphaBand > 0 ||
+			(bandCfg.AlphaBand == 0 && ((src.BitsPerSample() == 8 && src.SamplesPerPixel() >= 4) || src.HasMaskBand() || src.MaskedLevels() > 0)) ||
+			bandCfg.HasNodata || nodataStr != "" || mf.HasNoData() || len(gaps) > 0 ||
+			(bandCfg.HasBilevelColors && (bandCfg.BilevelColors[0].A < 255 || bandCfg.BilevelColors[1].A < 255))
+		format = prof.FormatFor(transparent)
+		enc, err = encode.NewEncoder(format, quality)
+		if err != nil {
+			log.Fatalf("Encoder: %v", err)
+		}
+	}
+
+	// Overlay format: the JPEG default has no alpha. Prefer WebP, which
+	// needs a CGo build, and fall back to PNG.
+	if overlay && format == "jpeg" {
+		format = "webp"
+		if enc, err = encode.NewEncoder(format, quality); err != nil {
+			format = "png"
+			if enc, err = encode.NewEncoder(format, quality); err != nil {
+				log.Fatalf("Encoder: %v", err)
+			}
+		}
+		log.Printf("Overlay: using %s tiles", format)
+	}
+
 	log.Printf("Band config: %s", bandCfg)
 	for _, src := range sources {
 		src.SetBandConfig(bandCfg)
 	}
 
-	// Compute merged bounds in WGS84.
-	mergedBounds := cog.MergedBoundsWGS84(sources)
+	// Compare sources where they overlap, now that band config and nodata
+	// are set and the samples look like the output.
+	switch overlapCheck {
+	case "off":
+	case "report", "fail":
+		checkOverlaps(allSources, overlapMaxDelta, overlapCheck == "fail")
+	default:
+		log.Fatalf("--overlap-check: unknown mode %q (want off, report, fail)", overlapCheck)
+	}
+
+	// Compute merged bounds in WGS84. In split mode the pass covers both
+	// layers, and each archive records its own bounds.
+	mergedBounds := clampToMercator(cog.MergedBoundsWGS84(sources))
+	passBounds := mergedBounds
+	var terrainBounds cog.Bounds
+	if len(terrainSources) > 0 {
+		terrainBounds = clampToMercator(cog.MergedBoundsWGS84(terrainSources))
+		passBounds = clampToMercator(cog.MergedBoundsWGS84(allSources))
+	}
 	if verbose {
 		log.Printf("Merged bounds (WGS84): lon [%.6f, %.6f], lat [%.6f, %.6f]",
 			mergedBounds.MinLon, mergedBounds.MaxLon, mergedBounds.MinLat, mergedBounds.MaxLat)
@@ -275,6 +935,31 @@ func main() {
 	if verbose {
 		log.Printf("Zoom range: %d - %d (auto-detected max: %d)", minZoom, maxZoom, autoMax)
 	}
+	if maxZoom+zoomOffset > tile.MaxZoomLabel {
+		log.Fatalf("--zoom-offset %d: max zoom %d would be stored as %d (limit %d)", zoomOffset, maxZoom, maxZoom+zoomOffset, tile.MaxZoomLabel)
+	}
+
+	// Sharded run: every zoom band and grid cell becomes an archive.
+	var shards []shard.Shard
+	if shardBands != nil {
+		if shards, err = shard.Plan(shardBands, minZoom, maxZoom, mergedBounds); err != nil {
+			log.Fatalf("--shard: %v", err)
+		}
+		if shardIndex >= len(shards) {
+			log.Fatalf("--shard-index %d: the run has %d shards (0-%d)", shardIndex, len(shards), len(shards)-1)
+		}
+	}
+
+	tileOrder, err := tileorder.ParseCurve(tileOrderStr)
+	if err != nil {
+		log.Fatalf("--tile-order: %v", err)
+	}
+	if spillFormat != tile.SpillEncoded && spillFormat != tile.SpillRaw {
+		log.Fatalf("--spill-format: unknown format %q (want %s or %s)", spillFormat, tile.SpillEncoded, tile.SpillRaw)
+	}
+	if spillFormat == tile.SpillRaw && noSpill {
+		log.Fatalf("--spill-format raw: not with --no-spill, which would keep every tile's pixels in memory")
+	}
 
 	// Compute memory limit for disk spilling.
 	var memoryLimitBytes int64
@@ -285,32 +970,207 @@ func main() {
 	}
 	// 0 = auto-detect from system RAM (handled inside Generate).
 
+	if targetSizeMB > 0 && format != "jpeg" && format != "webp" {
+		log.Fatalf("--target-size requires a lossy format (jpeg, webp), got %q", format)
+	}
+	if len(qualityRanges) > 0 && format != "jpeg" && format != "webp" {
+		log.Fatalf("A per-zoom --quality requires jpeg or webp output, got %q", format)
+	}
+	if premultiply && format != "png" && format != "webp" {
+		log.Fatalf("--premultiply-alpha requires png or webp output, got %q", format)
+	}
+
+	// Settings that affect tile content: an --incremental state or a
+	// --resume checkpoint only carries over to a run with the same.
+	var settings string
+	if incrementalRun || resume {
+		c := contentFlags{
+			version: version, format: format, quality: quality, tileSize: tileSize, minZoom: minZoom, maxZoom: maxZoom,
+			resampling: resampling, gamma: resamplingGamma, fillColor: fillColor, background: background,
+			bands: bandCfg, nodata: nodataStr, fillVoids: fillVoids, tileFilter: tileFilter,
+			sharpen: sharpen, premultiply: premultiply, maxTileBytes: maxTileBytes, zoomOffset: zoomOffset,
+			hillshade: hs, hillshadeStyle: hillshadeStyle, colorMap: colorMap, colorMapMode: colorMapMode, overlay: overlay,
+			debugOverlay: debugOverlay, graticule: graticule, assumeEPSG: assumeEPSG,
+		}
+		if manifestPath != "" {
+			if c.manifest, _, err = incremental.HashFile(manifestPath); err != nil {
+				log.Fatalf("Manifest: %v", err)
+			}
+		}
+		if len(qualityRanges) > 0 {
+			c.qualitySpec = qualitySpec
+		}
+		if inputOrder {
+			c.inputOrder = tiffFiles
+		}
+		settings = contentSettings(c)
+	}
+
+	// Incremental run: compare the inputs with the last run's state and
+	// regenerate only the footprints of those that changed.
+	var inc *incrementalState
+	if incrementalRun {
+		inc, err = prepareIncremental(outputPath, sources, settings, minZoom, maxZoom, zoomOffset, enc.PMTileType(), concurrency, verbose)
+		if err != nil {
+			log.Fatalf("Incremental: %v", err)
+		}
+	}
+
+	// Resumable run: pick up the checkpoint of an interrupted run with the
+	// same settings and inputs. Inputs are compared by size and modification
+	// time; hashing them all would cost much of what resuming saves.
+	var resumeState *checkpoint.State
+	resumeDir := checkpoint.Dir(outputPath)
+	if resume {
+		settings += " inputs=" + fileStamps(sources)
+		if spillFormat == tile.SpillRaw {
+			// The checkpointed tile stores hold raw pixels.
+			settings += " spill=raw"
+		}
+		resumeState, err = checkpoint.Load(resumeDir)
+		if err != nil {
+			log.Fatalf("Resume: %v", err)
+		}
+		if resumeState != nil && resumeState.Settings != settings {
+			log.Printf("Checkpoint in %s was written with other settings or inputs; starting over", resumeDir)
+			resumeState = nil
+		}
+	}
+
+	// Temporary files: spill files on --temp-dir, the writer's temp file
+	// where Finalize can copy it cheaply into the archive.
+	dirs := scratch.Plan(outputPath, tempDir, writerTempDir)
+	if dirs.Warning != "" {
+		log.Printf("WARNING: %s", dirs.Warning)
+	}
+
 	// Print settings summary.
+	qualityDisplay := encode.QualityName(quality)
+	if len(qualityRanges) > 0 {
+		qualityDisplay = qualitySpec
+	}
 	fmt.Printf("geotiff2pmtiles %s (commit %s, built %s)\n", version, commit, buildDate)
-	switch format {
-	case "jpeg", "webp":
-		fmt.Printf("  %-14s %s (quality: %d)\n", "Format:", format, quality)
+	switch {
+	case contours:
+		fmt.Printf("  %-14s contours (MVT layer %q)\n", "Format:", vector.LayerName)
+		intervals := make([]string, 0, maxZoom-minZoom+1)
+		for z := minZoom; z <= maxZoom; z++ {
+			intervals = append(intervals, fmt.Sprintf("z%d=%g", z, vector.IntervalForZoom(contourIntervals, z)))
+		}
+		fmt.Printf("  %-14s %s (index line every %d)\n", "Intervals:", strings.Join(intervals, " "), vector.IndexEvery)
+	case hillshade:
+		if format == "jpeg" || format == "webp" {
+			fmt.Printf("  %-14s hillshade, %s (quality: %s)\n", "Format:", format, qualityDisplay)
+		} else {
+			fmt.Printf("  %-14s hillshade, %s\n", "Format:", format)
+		}
+		fmt.Printf("  %-14s azimuth %g°, altitude %g°, z-factor %g, %s\n", "Light:", hs.Azimuth, hs.Altitude, hs.ZFactor, hillshadeStyle)
+	case format == "jpeg" || format == "webp":
+		fmt.Printf("  %-14s %s (quality: %s)\n", "Format:", format, qualityDisplay)
 	default:
 		fmt.Printf("  %-14s %s\n", "Format:", format)
 	}
+	if colorMap != nil {
+		fmt.Printf("  %-14s %d stops, %g to %g, %s\n", "Color map:", len(colorMap.Stops),
+			colorMap.Stops[0].Value, colorMap.Stops[len(colorMap.Stops)-1].Value, colorMapMode)
+	}
+	if maxTileBytes > 0 {
+		fmt.Printf("  %-14s %s (larger tiles re-encoded at lower quality)\n", "Max tile:", units.Size(int64(maxTileBytes)))
+	}
+	if targetSizeMB > 0 {
+		fmt.Printf("  %-14s %s (quality ≤ %d per zoom)\n", "Target size:", units.Size(int64(targetSizeMB)<<20), quality)
+	}
 	fmt.Printf("  %-14s %dpx\n", "Tile size:", tileSize)
+	if prof != nil {
+		fmt.Printf("  %-14s %s\n", "Profile:", prof.Name)
+	}
 	fmt.Printf("  %-14s %d – %d (auto-max: %d)\n", "Zoom:", minZoom, maxZoom, autoMax)
+	if zoomOffset != 0 {
+		fmt.Printf("  %-14s +%d (stored as zoom %d – %d)\n", "Zoom offset:", zoomOffset, minZoom+zoomOffset, maxZoom+zoomOffset)
+	}
 	if resamplingGamma != 1.0 {
 		fmt.Printf("  %-14s %s (gamma %.2g)\n", "Resampling:", resampling, resamplingGamma)
 	} else {
 		fmt.Printf("  %-14s %s\n", "Resampling:", resampling)
 	}
+	if sharpen != "" {
+		fmt.Printf("  %-14s zoom %s\n", "Sharpen:", sharpen)
+	}
+	if ovr := sources[0].GDALMeta().OverviewResampling(); ovr != "" {
+		fmt.Printf("  %-14s %s (source overviews)\n", "Ovr resampling:", strings.ToLower(ovr))
+	}
 	fmt.Printf("  %-14s %d\n", "Concurrency:", concurrency)
+	if levelByLevel {
+		fmt.Printf("  %-14s level by level\n", "Scheduling:")
+	}
+	if len(tiffFiles) > 1 {
+		if inputOrder {
+			fmt.Printf("  %-14s input order\n", "Overlaps:")
+		} else {
+			fmt.Printf("  %-14s finest resolution first\n", "Overlaps:")
+		}
+	}
+	if overlapCheck != "off" {
+		fmt.Printf("  %-14s %s (mean Δ > %g)\n", "Overlap check:", overlapCheck, overlapMaxDelta)
+	}
+	if tileFilter != "" {
+		fmt.Printf("  %-14s %s\n", "Tile filter:", tileFilter)
+	}
+	if overlay {
+		fmt.Printf("  %-14s overlay (missing tiles left out)\n", "Type:")
+	}
+	if assumeEPSG != 0 {
+		fmt.Printf("  %-14s EPSG:%d for inputs without GeoKeys\n", "Assumed CRS:", assumeEPSG)
+	}
 	if fc != nil {
 		fmt.Printf("  %-14s rgba(%d,%d,%d,%d)\n", "Fill color:", fc.R, fc.G, fc.B, fc.A)
 	}
+	if bg != nil {
+		fmt.Printf("  %-14s rgb(%d,%d,%d)\n", "Background:", bg.R, bg.G, bg.B)
+	}
+	if premultiply {
+		fmt.Printf("  %-14s premultiplied\n", "Alpha:")
+	}
 	if noSpill {
 		fmt.Printf("  %-14s disabled (all in memory)\n", "Disk spill:")
 	} else if memLimitMB > 0 {
-		fmt.Printf("  %-14s %d MB\n", "Mem limit:", memLimitMB)
+		fmt.Printf("  %-14s %s\n", "Mem limit:", units.Size(int64(memLimitMB)<<20))
 	} else {
 		fmt.Printf("  %-14s auto (~90%% of RAM)\n", "Mem limit:")
 	}
+	if spillFormat == tile.SpillRaw {
+		fmt.Printf("  %-14s raw pixels (no decode on read-back)\n", "Spill format:")
+	}
+	if tileOrder != tileorder.Hilbert {
+		fmt.Printf("  %-14s %s\n", "Tile order:", tileOrder)
+	}
+	if encodeCacheMB > 0 {
+		fmt.Printf("  %-14s %s\n", "Encode cache:", units.Size(int64(encodeCacheMB)<<20))
+	}
+	if sourceCacheN > 0 || rawCacheMB > 0 {
+		decoded := "auto"
+		if sourceCacheN > 0 {
+			decoded = fmt.Sprintf("%d", sourceCacheN)
+		}
+		raw := "off"
+		if rawCacheMB > 0 {
+			raw = units.Size(int64(rawCacheMB) << 20)
+		}
+		fmt.Printf("  %-14s %s decoded tiles, compressed tier %s\n", "Source cache:", decoded, raw)
+	}
+	if remoteInputs > 0 {
+		fmt.Printf("  %-14s %s for %d remote input(s)\n", "Remote cache:", units.Size(int64(remoteCacheMB)<<20), remoteInputs)
+	}
+	if streaming {
+		fmt.Printf("  %-14s tile data written into %s%s, finalize writes directories only\n", "Streaming:", outputPath, pmtiles.PartialSuffix)
+	}
+	if tempDir != "" || writerTempDir != "" {
+		if dirs.Colocated {
+			fmt.Printf("  %-14s spill %s, writer %s (temp dir is on another file system)\n", "Temp dirs:", dirs.Spill, dirs.Writer)
+		} else {
+			fmt.Printf("  %-14s spill %s, writer %s\n", "Temp dirs:", dirs.Spill, dirs.Writer)
+		}
+	}
 	if bandCfg.Bands != ([3]int{1, 2, 3}) || bandCfg.AlphaBand != 0 || bandCfg.Rescale != cog.RescaleNone {
 		fmt.Printf("  %-14s %d,%d,%d\n", "Bands:", bandCfg.Bands[0], bandCfg.Bands[1], bandCfg.Bands[2])
 		switch bandCfg.AlphaBand {
@@ -328,11 +1188,84 @@ func main() {
 			fmt.Printf("  %-14s log [%.0f, %.0f]\n", "Rescale:", bandCfg.RescaleMin, bandCfg.RescaleMax)
 		}
 	}
+	if fillVoids > 0 {
+		fmt.Printf("  %-14s holes up to %d px\n", "Fill voids:", fillVoids)
+	}
 	fmt.Printf("  %-14s %d file(s)\n", "Input:", len(tiffFiles))
-	fmt.Printf("  %-14s %s\n", "Output:", outputPath)
+	for i, src := range backfill {
+		label := ""
+		if i == 0 {
+			label = "Backfill:"
+		}
+		fmt.Printf("  %-14s %s (%d px tiles, zoom %d)\n", label, backfillPaths[i], src.TileSize(), backfillReaders[i].Header().MaxZoom)
+	}
+	if daemonAddr != "" {
+		fmt.Printf("  %-14s %s (jobs via POST /jobs)\n", "Daemon:", daemonAddr)
+	} else if batchPath != "" {
+		fmt.Printf("  %-14s %d job(s) from %s\n", "Batch:", len(batchJobs), batchPath)
+	} else if mbtilesOutput {
+		fmt.Printf("  %-14s %s (MBTiles)\n", "Output:", outputPath)
+	} else if len(series) == 0 && len(shards) == 0 {
+		fmt.Printf("  %-14s %s\n", "Output:", outputPath)
+	}
+	if terrainOutput != "" {
+		fmt.Printf("  %-14s %s (terrarium, %d file(s), same pass)\n", "Terrain:", terrainOutput, len(terrainSources))
+	}
+	for i, g := range series {
+		label := ""
+		if i == 0 {
+			label = "Series:"
+		}
+		fmt.Printf("  %-14s %s: %d file(s) → %s\n", label, g.date, len(g.sources), g.output)
+	}
+	for i, s := range shards {
+		label := ""
+		if i == 0 {
+			label = "Shards:"
+		}
+		note := ""
+		if shardIndex >= 0 && i != shardIndex {
+			note = " (skipped, --shard-index)"
+		}
+		fmt.Printf("  %-14s %d %s → %s%s\n", label, i, s.Name, s.Output(outputPath), note)
+	}
+	if serveAddr != "" {
+		fmt.Printf("  %-14s %s (flush every %v)\n", "Serve:", serveAddr, flushInterval)
+	}
+	if previewAddr != "" {
+		fmt.Printf("  %-14s %s (finished zooms, read-only)\n", "Preview:", previewAddr)
+	}
+	if debugOverlay {
+		if graticule > 0 {
+			fmt.Printf("  %-14s tile boundaries, labels, graticule every %g°\n", "Debug overlay:", graticule)
+		} else {
+			fmt.Printf("  %-14s tile boundaries, labels\n", "Debug overlay:")
+		}
+	}
+	if resume {
+		if resumeState != nil {
+			fmt.Printf("  %-14s from zoom %d (checkpoint in %s)\n", "Resume:", resumeState.Zoom-1, resumeDir)
+		} else {
+			fmt.Printf("  %-14s checkpoint every zoom to %s\n", "Resume:", resumeDir)
+		}
+	}
+	if reportPath != "" && reportPath != "off" && serveAddr == "" {
+		fmt.Printf("  %-14s %s\n", "Report:", reportPath)
+	}
+	if inc != nil {
+		fmt.Printf("  %-14s %s\n", "Incremental:", inc.summary())
+		if inc.upToDate() {
+			inc.close()
+			fmt.Printf("Up to date: no input changed since the last run → %s\n", outputPath)
+			return
+		}
+	}
 
 	// Build tile generation config.
-	outputDir := filepath.Dir(outputPath)
+	var backfillSources []tile.RasterSource
+	for _, src := range backfill {
+		backfillSources = append(backfillSources, src)
+	}
 	cfg := tile.Config{
 		MinZoom:          minZoom,
 		MaxZoom:          maxZoom,
@@ -340,63 +1273,1286 @@ func main() {
 		Concurrency:      concurrency,
 		Verbose:          verbose,
 		Encoder:          enc,
-		Bounds:           mergedBounds,
+		Bounds:           passBounds,
 		Resampling:       resamplingMode,
 		ResamplingGamma:  resamplingGamma,
-		IsTerrarium:      format == "terrarium",
+		IsTerrarium:      elevation,
+		DEMEncoding:      demEncoding,
+		Overlay:          overlay,
+		FillVoids:        fillVoids,
+		Hillshade:        hs,
+		ColorMap:         colorMap,
+		EncodeCacheBytes: int64(encodeCacheMB) * 1024 * 1024,
+		MaxTileBytes:     maxTileBytes,
+		NaNReport:        nanReport,
+		SourceCacheTiles: sourceCacheN,
+		Backfill:         backfillSources,
 		FillColor:        fc,
+		Background:       bg,
+		PremultiplyAlpha: premultiply,
 		MemoryLimitBytes: memoryLimitBytes,
-		OutputDir:        outputDir,
+		OutputDir:        dirs.Spill,
+		OwnSpillFiles:    dirs.Spill != dirs.Writer,
+		SpillFormat:      spillFormat,
+		TileOrder:        tileOrder,
+		OrderedWrites:    streaming,
+		LevelByLevel:     levelByLevel,
+		InputOrder:       inputOrder,
+		Sharpen:          sharpenRanges,
+	}
+	if debugOverlay {
+		cfg.DebugOverlay = &tile.DebugOverlay{Graticule: graticule}
+	}
+	if inc != nil && inc.prev != nil {
+		cfg.Regions = inc.regions
+		cfg.Previous = inc.prev
+		if zoomOffset != 0 {
+			cfg.Previous = tile.NewZoomOffsetReader(inc.prev, zoomOffset)
+		}
 	}
 
-	// Build description for PMTiles metadata.
-	description := buildDescription(sources, mergedBounds, gaps, format, quality, tileSize, minZoom, maxZoom, resampling, resamplingGamma, fc, bandCfg)
-
-	// Create PMTiles writer.
-	writer, err := pmtiles.NewWriter(outputPath, pmtiles.WriterOptions{
-		MinZoom:     minZoom,
-		MaxZoom:     maxZoom,
-		Bounds:      mergedBounds,
-		TileFormat:  enc.PMTileType(),
-		TileSize:    tileSize,
-		TempDir:     outputDir,
-		Description: description,
-		Attribution: attribution,
-		Type:        layerType,
-	})
-	if err != nil {
-		log.Fatalf("Creating PMTiles writer: %v", err)
+	// Memory preflight: runs are long, and an OOM kill hours in loses all
+	// of it. Serve and daemon modes hold no pyramid and are not checked.
+	var memEstimate tile.MemoryEstimate
+	if memCheck != "off" && daemonAddr == "" && batchPath == "" && serveAddr == "" {
+		layers := []tile.Layer{{Config: cfg, Sources: sources}}
+		if terrainOutput != "" {
+			layers = append(layers, tile.Layer{Config: terrainConfig(cfg), Sources: terrainSources})
+		}
+		if len(series) > 0 {
+			layers = layers[:0]
+			for _, g := range series {
+				layers = append(layers, tile.Layer{Config: cfg, Sources: g.sources})
+			}
+		}
+		memEstimate = tile.EstimateMemory(layers)
+		checkMemory(memEstimate, memCheck == "fail", verbose)
 	}
 
-	// Generate tiles.
+	// Choose per-zoom quality to fit the target archive size.
+	var zoomQuality map[int]int
+	if targetSizeMB > 0 {
+		planStart := time.Now()
+		targetBytes := int64(targetSizeMB) * 1024 * 1024
+		plan, err := tile.PlanQuality(cfg, sources, format, quality, targetBytes)
+		if err != nil {
+			log.Fatalf("Target size: %v", err)
+		}
+		cfg.ZoomEncoders, err = plan.Encoders(format)
+		if err != nil {
+			log.Fatalf("Encoder: %v", err)
+		}
+		zoomQuality = plan.Quality
+		fmt.Printf("Quality plan for %s (estimated %s, %s):\n",
+			units.Size(targetBytes), units.Size(plan.TotalBytes), units.Duration(time.Since(planStart)))
+		for _, z := range plan.Zooms() {
+			fmt.Printf("  Zoom %-9d quality %3d  ~%s\n", z, plan.Quality[z], units.Size(plan.EstimatedBytes[z]))
+		}
+		if !plan.Fits {
+			log.Printf("WARNING: target size %s not reachable even at quality %d (estimated %s)",
+				units.Size(targetBytes), tile.MinBudgetQuality, units.Size(plan.TotalBytes))
+		}
+	} else if len(qualityRanges) > 0 {
+		zoomQuality = tile.ZoomQualities(quality, qualityRanges, minZoom, maxZoom)
+		cfg.ZoomEncoders, err = tile.QualityPlan{Quality: zoomQuality}.Encoders(format)
+		if err != nil {
+			log.Fatalf("Encoder: %v", err)
+		}
+	}
+
+	// Build description for PMTiles metadata.
+	descFormat := format
+	if contours {
+		descFormat = "contours"
+	} else if hillshade {
+		descFormat = "hillshade, " + format
+	} else if colorMap != nil {
+		descFormat = "color map, " + format
+	}
+	description := buildDescription(sources, mergedBounds, gaps, descFormat, quality, zoomQuality, tileSize, minZoom, maxZoom, zoomOffset, resampling, resamplingGamma, fc, bandCfg)
+
+	writerOpts := pmtiles.WriterOptions{
+		MinZoom:      minZoom + zoomOffset,
+		MaxZoom:      maxZoom + zoomOffset,
+		Bounds:       mergedBounds,
+		TileFormat:   enc.PMTileType(),
+		TileSize:     tileSize,
+		TempDir:      dirs.Writer,
+		Description:  description,
+		Name:         tilesetName,
+		Version:      tilesetVersion,
+		LayerID:      layerID,
+		Attribution:  attribution,
+		Type:         layerType,
+		Readable:     previewAddr != "",
+		Sync:         fsync,
+		SyncDir:      fsync,
+		StableLayout: stableLayout,
+		Streaming:    streaming,
+		GeneratedAt:  pmtiles.GenerationTime(),
+		Extra:        sourceProvenance(sources),
+
+		EstimatedTiles: tile.CountTiles(minZoom, maxZoom, mergedBounds),
+		ZoomOffset:     zoomOffset,
+
+		PremultipliedAlpha: premultiply,
+	}
+	if writerOpts.Extra == nil {
+		writerOpts.Extra = make(map[string]interface{})
+	}
+	if zoomOffset != 0 {
+		// Clients need the offset to map the stored zooms to scales.
+		writerOpts.Extra["zoom_offset"] = zoomOffset
+	}
+	if contours {
+		writerOpts.TileFormat = pmtiles.TileTypeMVT
+		writerOpts.TileCompression = pmtiles.CompressionGzip
+		if !explicit["type"] {
+			// Contour lines are drawn over a base map.
+			writerOpts.Type = "overlay"
+		}
+		writerOpts.Extra["vector_layers"] = []map[string]interface{}{{
+			"id":      vector.LayerName,
+			"minzoom": minZoom + zoomOffset,
+			"maxzoom": maxZoom + zoomOffset,
+			"fields":  map[string]string{"ele": "Number", "index": "Boolean"},
+		}}
+	}
+	if debugOverlay {
+		// Mark the archive so it is not mistaken for production imagery.
+		writerOpts.Extra["debug_overlay"] = true
+	}
+	if prof != nil {
+		for k, v := range prof.Metadata(tileSize) {
+			writerOpts.Extra[k] = v
+		}
+	}
+
+	// Daemon and batch modes: keep the sources open and generate one
+	// extract per job, using the settings above as job defaults.
+	if daemonAddr != "" || batchPath != "" {
+		jr := &jobRunner{
+			cfg:        cfg,
+			writerOpts: writerOpts,
+			sources:    sources,
+			format:     format,
+			quality:    quality,
+			tileFilter: tileFilter,
+			tempDir:    tempDir,
+			writerDir:  writerTempDir,
+			describe: func(b cog.Bounds, format string, quality, minZoom, maxZoom int) string {
+				return buildDescription(sources, b, gaps, format, quality, nil, tileSize, minZoom, maxZoom, 0, resampling, resamplingGamma, fc, bandCfg)
+			},
+		}
+		jr.cfg.SourceCache = tile.NewSourceCache(cfg)
+		if batchPath != "" {
+			if failed := runBatch(batchJobs, jr.run); failed > 0 {
+				log.Fatalf("--batch: %d of %d job(s) failed", failed, len(batchJobs))
+			}
+			return
+		}
+		runDaemon(daemonAddr, jr.run, verbose)
+		return
+	}
+
+	// On-demand mode: render tiles as they are requested instead of
+	// generating the full pyramid up front.
+	if serveAddr != "" {
+		if tileFilter != "" {
+			log.Printf("WARNING: --tile-filter is not applied to tiles rendered by --serve")
+		}
+		runServe(cfg, sources, writerOpts, outputPath, serveAddr, flushInterval)
+		return
+	}
+
+	if len(series) > 0 {
+		describe := func(group []*cog.Reader, b cog.Bounds) string {
+			return buildDescription(group, b, cog.CheckCoverageGaps(group), format, quality, nil, tileSize, minZoom, maxZoom, zoomOffset, resampling, resamplingGamma, fc, bandCfg)
+		}
+		seriesStats := runDateSeries(cfg, sources, series, writerOpts, tileFilter, format, zoomOffset, describe, start)
+		if memCheck != "off" {
+			if rss, err := tile.PeakRSS(); err == nil {
+				fmt.Printf("Peak memory: %s RSS (estimated %s)\n", units.Size(rss), units.Size(memEstimate.Total()))
+			}
+		}
+		if showTiming || verbose {
+			fmt.Printf("Timing:\n%s", seriesStats[0].Timing.Table())
+		}
+		return
+	}
+
+	if len(shards) > 0 {
+		if shardIndex >= 0 {
+			shards = shards[shardIndex : shardIndex+1]
+		}
+		shardStats := runShards(cfg, sources, shards, writerOpts, outputPath, tileFilter, format, zoomOffset, contourIntervals, contours, start)
+		if memCheck != "off" {
+			if rss, err := tile.PeakRSS(); err == nil {
+				fmt.Printf("Peak memory: %s RSS (estimated %s)\n", units.Size(rss), units.Size(memEstimate.Total()))
+			}
+		}
+		if showTiming || verbose {
+			for i, s := range shards {
+				fmt.Printf("Timing %s:\n%s", s.Name, shardStats[i].Timing.Table())
+			}
+		}
+		return
+	}
+
+	// Create the writer, or continue the checkpointed one. pmWriter is the
+	// PMTiles writer that --resume, --preview and --incremental need; the
+	// MBTiles writer rules them out.
+	var writer tilesetWriter
+	var pmWriter *pmtiles.Writer
+	switch {
+	case mbtilesOutput:
+		writer, err = mbtiles.NewWriter(outputPath, writerOpts)
+	case resumeState != nil:
+		pmWriter, err = pmtiles.ResumeWriter(outputPath, writerOpts, filepath.Join(resumeDir, resumeState.Writer))
+		writer = pmWriter
+	default:
+		pmWriter, err = pmtiles.NewWriter(outputPath, writerOpts)
+		writer = pmWriter
+	}
+	if err != nil {
+		log.Fatalf("Creating writer: %v", err)
+	}
+	if resume {
+		cfg.Checkpoint = &tile.Checkpoint{
+			Dir:        resumeDir,
+			Settings:   settings,
+			SaveWriter: pmWriter.Checkpoint,
+			Resume:     resumeState,
+		}
+	}
+
+	// Expose completed zoom levels while lower zooms are still generated.
+	if previewAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(previewAddr, serve.Preview(pmWriter, writerOpts.TileFormat)); err != nil {
+				log.Printf("WARNING: preview server: %v", err)
+			}
+		}()
+		log.Printf("Preview at http://%s/{z}/{x}/{y}%s (finished zooms: /zooms)", previewAddr, enc.FileExtension())
+	}
+
+	if poolCheck {
+		tile.SetRGBALeakCheck(true)
+	}
+
+	// Generate tiles.
+	var out tile.TileWriter = writer
+	if tileFilter != "" {
+		out = tile.NewFilterWriter(writer, tileFilter, format)
+	}
+	if zoomOffset != 0 {
+		out = tile.NewZoomOffsetWriter(out, zoomOffset)
+	}
+	var contourWriter *vector.ContourWriter
+	if contours {
+		// Outermost, so intervals follow the real zoom, not the label.
+		contourWriter = vector.NewContourWriter(out, contourIntervals)
+		out = contourWriter
+	}
+	layers := []tile.Layer{{Config: cfg, Sources: sources, Writer: out}}
+	var terrainWriter *pmtiles.Writer
+	if terrainOutput != "" {
+		tcfg := terrainConfig(cfg)
+		terrainDirs := scratch.Plan(terrainOutput, tempDir, writerTempDir)
+		if terrainDirs.Warning != "" && terrainDirs.Warning != dirs.Warning {
+			log.Printf("WARNING: %s", terrainDirs.Warning)
+		}
+		terrainWriter, err = newTerrainWriter(terrainOutput, terrainDirs.Writer, writerOpts, tcfg.Encoder.PMTileType(), terrainSources, terrainBounds,
+			buildDescription(terrainSources, terrainBounds, cog.CheckCoverageGaps(terrainSources), "terrarium", quality, nil,
+				tileSize, minZoom, maxZoom, zoomOffset, resampling, 1.0, fc, cog.BandConfig{}))
+		if err != nil {
+			writer.Abort()
+			log.Fatalf("Creating terrain PMTiles writer: %v", err)
+		}
+		var terrainOut tile.TileWriter = terrainWriter
+		if zoomOffset != 0 {
+			terrainOut = tile.NewZoomOffsetWriter(terrainWriter, zoomOffset)
+		}
+		layers = append(layers, tile.Layer{Config: tcfg, Sources: terrainSources, Writer: terrainOut})
+	}
 	genStart := time.Now()
-	stats, err := tile.Generate(cfg, sources, writer)
+	layerStats, err := tile.GenerateLayers(layers)
 	if err != nil {
 		writer.Abort()
+		if terrainWriter != nil {
+			terrainWriter.Abort()
+		}
 		log.Fatalf("Tile generation: %v", err)
 	}
+	genElapsed := time.Since(genStart)
+	stats := layerStats[0]
 
+	if stats.OversizedTiles > 0 {
+		log.Printf("WARNING: %d tile(s) encoded to more than %d bytes and were re-encoded at lower quality; %d still exceed it",
+			stats.OversizedTiles, maxTileBytes, stats.OversizedLeft)
+	}
+	if verbose || nanReport > 0 {
+		for i, ls := range layerStats {
+			label := outputPath
+			if i > 0 {
+				label = terrainOutput
+			}
+			logNaNFallbacks(label, ls.NaNFallbacks, zoomOffset)
+		}
+	}
 	if verbose {
-		log.Printf("Generated %d tiles (%d uniform, %d empty) in %v",
+		log.Printf("Generated %d tiles (%d uniform, %d empty) in %s",
 			stats.TileCount, stats.UniformTiles, stats.EmptyTiles,
-			time.Since(genStart).Round(time.Millisecond))
+			units.Duration(time.Since(genStart)))
+		if encodeCacheMB > 0 {
+			log.Printf("Encode cache: %d of %d tiles reused an encoding", stats.EncodeCacheHits, stats.TileCount)
+		}
+		if rawCache != nil {
+			hits, misses := rawCache.Stats()
+			log.Printf("Raw cache: %d compressed tiles reused, %d read", hits, misses)
+		}
+		if remoteInputs > 0 {
+			hits, misses := remote.DefaultCache.Stats()
+			log.Printf("Remote cache: %d block hits, %d fetched", hits, misses)
+		}
+		log.Printf("RGBA pool: %s", tile.ReadRGBAPoolStats())
+	}
+	if poolCheck {
+		leaks, untracked := tile.RGBALeaks()
+		for _, l := range leaks {
+			log.Printf("WARNING: RGBA pool: %d image(s) not returned, taken at %s", l.Count, l.Site)
+		}
+		if len(leaks) == 0 {
+			log.Printf("RGBA pool: all images returned (%d puts of images from elsewhere)", untracked)
+		}
 	}
 
-	// Finalize PMTiles file.
+	// Tiles outside the changed footprints carry over unchanged. They were
+	// filtered when first written, so they bypass --tile-filter.
+	if inc != nil && inc.prev != nil {
+		n, err := inc.copyUnchanged(pmWriter, minZoom, maxZoom, zoomOffset)
+		if err != nil {
+			writer.Abort()
+			log.Fatalf("Incremental: copying unchanged tiles: %v", err)
+		}
+		stats.TileCount += n
+		if verbose {
+			log.Printf("Copied %d unchanged tile(s) from the previous archive", n)
+		}
+	}
+
+	// Finalize the output file.
+	finalizeStart := time.Now()
 	if err := writer.Finalize(); err != nil {
-		log.Fatalf("Finalizing PMTiles: %v", err)
+		log.Fatalf("Finalizing %s: %v", outputPath, err)
+	}
+	stats.Timing.Finalize = time.Since(finalizeStart)
+	if n := writer.DuplicateTiles(); n > 0 {
+		log.Printf("WARNING: %d tile(s) were written more than once; kept the last write", n)
+	}
+	if resume {
+		// The archive is complete; there is nothing left to resume.
+		if err := os.RemoveAll(resumeDir); err != nil {
+			log.Printf("WARNING: removing checkpoint: %v", err)
+		}
+	}
+
+	if terrainWriter != nil {
+		if err := terrainWriter.Finalize(); err != nil {
+			log.Fatalf("Finalizing terrain PMTiles: %v", err)
+		}
+	}
+	if inc != nil {
+		if err := inc.save(); err != nil {
+			log.Fatalf("Saving incremental state: %v", err)
+		}
+	} else if err := os.Remove(incremental.StatePath(outputPath)); err == nil {
+		// The state described the archive just replaced.
+		log.Printf("Removed %s: archive rebuilt without --incremental", incremental.StatePath(outputPath))
 	}
 
-	elapsed := time.Since(start).Round(time.Millisecond)
+	elapsed := units.Duration(time.Since(start))
+	if contourWriter != nil {
+		// Elevation tiles without lines are not written.
+		stats.TileCount = contourWriter.TileCount()
+	}
 	fi, _ := os.Stat(outputPath)
-	fmt.Printf("Done: %d tiles, %s, %v → %s\n", stats.TileCount, humanSize(fi.Size()), elapsed, outputPath)
+	fmt.Printf("Done: %d tiles, %s, %s → %s\n", stats.TileCount, units.Size(fi.Size()), elapsed, outputPath)
+	if terrainWriter != nil {
+		fi, _ := os.Stat(terrainOutput)
+		fmt.Printf("Done: %d tiles, %s, %s → %s\n", layerStats[1].TileCount, units.Size(fi.Size()), elapsed, terrainOutput)
+	}
+	if memCheck != "off" {
+		if rss, err := tile.PeakRSS(); err == nil {
+			fmt.Printf("Peak memory: %s RSS (estimated %s)\n", units.Size(rss), units.Size(memEstimate.Total()))
+		}
+	}
+	if showTiming || verbose {
+		fmt.Printf("Timing:\n%s", stats.Timing.Table())
+	}
+	if prof != nil {
+		fmt.Printf("%s: %s\n", prof.Name, prof.ClientHint(tileSize))
+	}
+
+	if reportPath != "off" {
+		rep := report.New("geotiff2pmtiles", version, start, flag.CommandLine)
+		rep.Inputs = tiffFiles
+		rep.SetStats(stats, zoomOffset)
+		rep.Tiles.Duplicates = writer.DuplicateTiles()
+		rep.GenerateSeconds = genElapsed.Seconds()
+		rep.SetGaps(gaps)
+		if rss, err := tile.PeakRSS(); err == nil {
+			rep.PeakRSSBytes = rss
+		}
+		if err := rep.SetArchive(outputPath); err != nil {
+			log.Printf("WARNING: run report: reading %s: %v", outputPath, err)
+		}
+		rep.Warnings = warnings.Lines()
+		if err := rep.Write(reportPath, time.Now()); err != nil {
+			log.Printf("WARNING: writing run report: %v", err)
+		} else if verbose {
+			log.Printf("Run report: %s", reportPath)
+		}
+	}
+}
+
+// tilesetWriter writes the output: a pmtiles.Writer or, with
+// --output-format mbtiles, an mbtiles.Writer.
+type tilesetWriter interface {
+	tile.TileWriter
+	Finalize() error
+	Abort()
+	DuplicateTiles() int64
+}
+
+// dateGroup is one acquisition date of a --split-by-date run.
+type dateGroup struct {
+	date    string
+	sources []*cog.Reader
+	output  string // <output>-<date>.pmtiles
+}
+
+// groupByDate groups the sources by acquisition date, taken from the
+// manifest's "date" entry or else the GDAL acquisition date, sorted by
+// date. Every source must have a date.
+func groupByDate(sources []*cog.Reader, mf *manifest.Manifest, output string) ([]dateGroup, error) {
+	byDate := make(map[string][]*cog.Reader)
+	var undated []string
+	for _, src := range sources {
+		var date string
+		if e := mf.Lookup(src.Path()); e != nil && e.Date != "" {
+			date = e.Date
+		} else {
+			date = seriesDate(src.GDALMeta().AcquisitionDate())
+		}
+		if date == "" {
+			undated = append(undated, src.Path())
+			continue
+		}
+		byDate[date] = append(byDate[date], src)
+	}
+	if len(undated) > 0 {
+		if len(undated) > 3 {
+			undated = append(undated[:3], "...")
+		}
+		return nil, fmt.Errorf("no acquisition date for %s (set \"date\" in --manifest)", strings.Join(undated, ", "))
+	}
+
+	groups := make([]dateGroup, 0, len(byDate))
+	for date, srcs := range byDate {
+		groups = append(groups, dateGroup{
+			date:    date,
+			sources: srcs,
+			output:  strings.TrimSuffix(output, ".pmtiles") + "-" + date + ".pmtiles",
+		})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].date < groups[j].date })
+	return groups, nil
+}
+
+// seriesDate turns a GDAL acquisition date such as "2019:06:14 10:32:00"
+// or "2019-06-14T10:32:00Z" into the file-name safe day "2019-06-14".
+func seriesDate(s string) string {
+	if i := strings.IndexAny(s, " T"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.Trim(strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r == '.', r == '_':
+			return r
+		}
+		return '-'
+	}, s), "-")
+}
+
+// runDateSeries generates the archives of a --split-by-date run in one
+// pass: each date is a layer over the shared config and opened sources,
+// written to its own archive. The metadata records the date, and the name
+// and layer id get it as a suffix. allSources are the sources writerOpts
+// was built for; their provenance is replaced by each date's own.
+func runDateSeries(cfg tile.Config, allSources []*cog.Reader, series []dateGroup, writerOpts pmtiles.WriterOptions,
+	tileFilter, format string, zoomOffset int, describe func([]*cog.Reader, cog.Bounds) string, start time.Time) []tile.Stats {
+	allProvenance := sourceProvenance(allSources)
+	writers := make([]*pmtiles.Writer, len(series))
+	abort := func() {
+		for _, w := range writers {
+			if w != nil {
+				w.Abort()
+			}
+		}
+	}
+	layers := make([]tile.Layer, len(series))
+	for i, g := range series {
+		opts := writerOpts
+		opts.Bounds = clampToMercator(cog.MergedBoundsWGS84(g.sources))
+		opts.EstimatedTiles = tile.CountTiles(cfg.MinZoom, cfg.MaxZoom, opts.Bounds)
+		opts.Description = describe(g.sources, opts.Bounds)
+		name := opts.Name
+		if name == "" {
+			name = "geotiff2pmtiles"
+		}
+		opts.Name = name + " " + g.date
+		if opts.LayerID != "" {
+			opts.LayerID += "-" + g.date
+		}
+		opts.Extra = sourceProvenance(g.sources)
+		if opts.Extra == nil {
+			opts.Extra = make(map[string]interface{})
+		}
+		for k, v := range writerOpts.Extra {
+			if _, ok := allProvenance[k]; !ok {
+				opts.Extra[k] = v
+			}
+		}
+		opts.Extra["date"] = g.date
+
+		w, err := pmtiles.NewWriter(g.output, opts)
+		if err != nil {
+			abort()
+			log.Fatalf("Creating PMTiles writer for %s: %v", g.date, err)
+		}
+		writers[i] = w
+		var out tile.TileWriter = w
+		if tileFilter != "" {
+			out = tile.NewFilterWriter(out, tileFilter, format)
+		}
+		if zoomOffset != 0 {
+			out = tile.NewZoomOffsetWriter(out, zoomOffset)
+		}
+		layers[i] = tile.Layer{Config: cfg, Sources: g.sources, Writer: out}
+	}
+
+	stats, err := tile.GenerateLayers(layers)
+	if err != nil {
+		abort()
+		log.Fatalf("Tile generation: %v", err)
+	}
+	for i, w := range writers {
+		if err := w.Finalize(); err != nil {
+			log.Fatalf("Finalizing %s: %v", series[i].output, err)
+		}
+		if n := w.DuplicateTiles(); n > 0 {
+			log.Printf("WARNING: %s: %d tile(s) were written more than once; kept the last write", series[i].output, n)
+		}
+	}
+	elapsed := units.Duration(time.Since(start))
+	for i, g := range series {
+		fi, _ := os.Stat(g.output)
+		fmt.Printf("Done: %d tiles, %s, %s → %s\n", stats[i].TileCount, units.Size(fi.Size()), elapsed, g.output)
+	}
+	return stats
+}
+
+// runShards generates the archives of a --shard run. Each shard is a
+// generation of its own over the shared sources and source cache, with
+// its zoom band and bounds; up to concurrency shards run at once and split
+// the workers and the tile store memory between them.
+func runShards(cfg tile.Config, sources []*cog.Reader, shards []shard.Shard, writerOpts pmtiles.WriterOptions, outputPath string,
+	tileFilter, format string, zoomOffset int, contourIntervals []vector.IntervalRange, contours bool, start time.Time) []tile.Stats {
+	parallel := max(1, min(len(shards), cfg.Concurrency))
+	if cfg.MemoryLimitBytes == 0 {
+		cfg.MemoryLimitBytes = tile.ComputeMemoryLimit(tile.DefaultMemoryPressurePercent, cfg.Verbose)
+	}
+	if cfg.MemoryLimitBytes > 0 {
+		cfg.MemoryLimitBytes /= int64(parallel)
+	}
+	cfg.SourceCache = tile.NewSourceCache(cfg)
+	cfg.Concurrency = max(1, cfg.Concurrency/parallel)
+	cfg.Quiet = parallel > 1
+
+	writers := make([]*pmtiles.Writer, len(shards))
+	abort := func() {
+		for _, w := range writers {
+			if w != nil {
+				w.Abort()
+			}
+		}
+	}
+	for i, s := range shards {
+		opts := writerOpts
+		opts.MinZoom = s.MinZoom + zoomOffset
+		opts.MaxZoom = s.MaxZoom + zoomOffset
+		opts.Bounds = s.Bounds
+		opts.EstimatedTiles = tile.CountTiles(s.MinZoom, s.MaxZoom, s.Bounds)
+		opts.Extra = make(map[string]interface{}, len(writerOpts.Extra)+1)
+		for k, v := range writerOpts.Extra {
+			opts.Extra[k] = v
+		}
+		opts.Extra["shard"] = s.Name
+		w, err := pmtiles.NewWriter(s.Output(outputPath), opts)
+		if err != nil {
+			abort()
+			log.Fatalf("Creating PMTiles writer for shard %s: %v", s.Name, err)
+		}
+		writers[i] = w
+	}
+
+	stats := make([]tile.Stats, len(shards))
+	errs := make([]error, len(shards))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, s := range shards {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			var out tile.TileWriter = writers[i]
+			if tileFilter != "" {
+				out = tile.NewFilterWriter(out, tileFilter, format)
+			}
+			if zoomOffset != 0 {
+				out = tile.NewZoomOffsetWriter(out, zoomOffset)
+			}
+			var contourWriter *vector.ContourWriter
+			if contours {
+				contourWriter = vector.NewContourWriter(out, contourIntervals)
+				out = contourWriter
+			}
+			sc := cfg
+			sc.MinZoom, sc.MaxZoom, sc.Bounds = s.MinZoom, s.MaxZoom, s.Bounds
+			if stats[i], errs[i] = tile.Generate(sc, sources, out); errs[i] != nil {
+				return
+			}
+			if contourWriter != nil {
+				stats[i].TileCount = contourWriter.TileCount()
+			}
+			if errs[i] = writers[i].Finalize(); errs[i] != nil {
+				return
+			}
+			fi, _ := os.Stat(s.Output(outputPath))
+			fmt.Printf("Done: shard %s, %d tiles, %s, %s → %s\n", s.Name, stats[i].TileCount, units.Size(fi.Size()),
+				units.Duration(time.Since(start)), s.Output(outputPath))
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			abort()
+			log.Fatalf("Shard %s: %v", shards[i].Name, err)
+		}
+	}
+	for i, w := range writers {
+		if n := w.DuplicateTiles(); n > 0 {
+			log.Printf("WARNING: %s: %d tile(s) were written more than once; kept the last write", shards[i].Output(outputPath), n)
+		}
+	}
+	return stats
+}
+
+// fileStamps describes the source files by path, size, and modification
+// time, for comparing the inputs of a --resume checkpoint. Files that
+// cannot be read are described as missing; remote files by URL only.
+func fileStamps(sources []*cog.Reader) string {
+	stamps := make([]string, len(sources))
+	for i, src := range sources {
+		if remote.IsURL(src.Path()) {
+			stamps[i] = fmt.Sprintf("%q:remote", src.Path())
+			continue
+		}
+		fi, err := os.Stat(src.Path())
+		if err != nil {
+			stamps[i] = fmt.Sprintf("%q:missing", src.Path())
+			continue
+		}
+		stamps[i] = fmt.Sprintf("%q:%d:%d", src.Path(), fi.Size(), fi.ModTime().UnixNano())
+	}
+	return strings.Join(stamps, ",")
+}
+
+// incrementalState is the bookkeeping of an --incremental run.
+type incrementalState struct {
+	path    string             // state file
+	state   *incremental.State // this run's inputs, saved once the archive is final
+	prev    *pmtiles.Reader    // the last run's archive; nil for a full run
+	reason  string             // why this is a full run
+	changes []incremental.Change
+	regions []cog.Bounds // changed footprints, padded by one max-zoom tile
+}
+
+// prepareIncremental hashes the sources and compares them with the state of
+// the last run. The run is a full one if there is no state, the settings
+// differ, or the previous archive cannot be reused; otherwise it covers the
+// footprints of the changed files.
+func prepareIncremental(output string, sources []*cog.Reader, settings string, minZoom, maxZoom, zoomOffset int,
+	tileType uint8, concurrency int, verbose bool) (*incrementalState, error) {
+	inc := &incrementalState{path: incremental.StatePath(output), state: incremental.New(settings)}
+
+	start := time.Now()
+	files := make([]incremental.FileState, len(sources))
+	errs := make([]error, len(sources))
+	sem := make(chan struct{}, max(concurrency, 1))
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		b := cog.MergedBoundsWGS84([]*cog.Reader{src})
+		minX, minY := coord.LonLatToTile(b.MinLon, b.MaxLat, maxZoom)
+		maxX, maxY := coord.LonLatToTile(b.MaxLon, b.MinLat, maxZoom)
+		files[i].Bounds = [4]float64{b.MinLon, b.MinLat, b.MaxLon, b.MaxLat}
+		files[i].Tiles = [5]int{maxZoom, minX, minY, maxX, maxY}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			files[i].SHA256, files[i].Size, errs[i] = incremental.HashFile(src.Path())
+		}()
+	}
+	wg.Wait()
+	for i, src := range sources {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		inc.state.Files[src.Path()] = files[i]
+	}
+	if verbose {
+		log.Printf("Hashed %d input file(s) in %s", len(sources), units.Duration(time.Since(start)))
+	}
+
+	prev, err := incremental.Load(inc.path)
+	if err != nil {
+		log.Printf("WARNING: %v", err)
+	}
+	switch {
+	case prev == nil:
+		inc.reason = "no previous state"
+		return inc, nil
+	case prev.Settings != settings:
+		inc.reason = "settings changed"
+		return inc, nil
+	}
+
+	r, err := pmtiles.OpenReader(output)
+	if err != nil {
+		if os.IsNotExist(err) {
+			inc.reason = "no previous archive"
+		} else {
+			inc.reason = fmt.Sprintf("previous archive unreadable: %v", err)
+		}
+		return inc, nil
+	}
+	if h := r.Header(); int(h.MinZoom) != minZoom+zoomOffset || int(h.MaxZoom) != maxZoom+zoomOffset || h.TileType != tileType {
+		r.Close()
+		inc.reason = "previous archive has other zooms or tile format"
+		return inc, nil
+	}
+	inc.prev = r
+
+	inc.changes = incremental.Diff(prev, inc.state)
+	pad := 360 / math.Exp2(float64(maxZoom))
+	for _, c := range inc.changes {
+		if verbose {
+			log.Printf("  %s: %s", c.Kind, c.Path)
+		}
+		for _, b := range c.Regions {
+			inc.regions = append(inc.regions, clampToMercator(cog.Bounds{
+				MinLon: b[0] - pad, MinLat: b[1] - pad, MaxLon: b[2] + pad, MaxLat: b[3] + pad,
+			}))
+		}
+	}
+	return inc, nil
+}
+
+// upToDate reports whether the previous archive can be kept as is.
+func (inc *incrementalState) upToDate() bool {
+	return inc.prev != nil && len(inc.changes) == 0
+}
+
+// summary describes the run for the settings summary.
+func (inc *incrementalState) summary() string {
+	if inc.prev == nil {
+		return "full run (" + inc.reason + ")"
+	}
+	counts := make(map[string]int)
+	for _, c := range inc.changes {
+		counts[c.Kind]++
+	}
+	var parts []string
+	for _, kind := range []string{incremental.Added, incremental.Modified, incremental.Removed} {
+		if counts[kind] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[kind], kind))
+		}
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("%d file(s) unchanged", len(inc.state.Files))
+	}
+	return fmt.Sprintf("%s of %d file(s)", strings.Join(parts, ", "), len(inc.state.Files))
+}
+
+// copyUnchanged writes the previous archive's tiles outside the regenerated
+// regions to w, as stored, in one sequential pass over the old archive.
+// The archive stores zoom z as z+zoomOffset.
+func (inc *incrementalState) copyUnchanged(w *pmtiles.Writer, minZoom, maxZoom, zoomOffset int) (int64, error) {
+	regen := tile.NewDirtySet(minZoom)
+	regen.MarkRegions(maxZoom, inc.regions)
+	var n int64
+	err := inc.prev.StreamZooms(minZoom+zoomOffset, maxZoom+zoomOffset, func(z, x, y int, data []byte) error {
+		if regen.Contains(z-zoomOffset, x, y) {
+			return nil
+		}
+		n++
+		return w.WriteTile(z, x, y, data)
+	})
+	return n, err
+}
+
+// close releases the previous archive.
+func (inc *incrementalState) close() {
+	if inc.prev != nil {
+		inc.prev.Close()
+	}
+}
+
+// save closes the previous archive and records this run's inputs.
+func (inc *incrementalState) save() error {
+	inc.close()
+	return inc.state.Save(inc.path)
+}
+
+// terrainConfig derives the terrain layer of a --terrain-output run from the
+// imagery config: Terrarium encoding, without the imagery-only settings.
+func terrainConfig(cfg tile.Config) tile.Config {
+	cfg.Encoder = &encode.TerrariumEncoder{}
+	cfg.IsTerrarium = true
+	cfg.DEMEncoding = encode.Terrarium
+	cfg.ResamplingGamma = 1.0
+	cfg.Background = nil
+	cfg.PremultiplyAlpha = false
+	cfg.DebugOverlay = nil
+	cfg.ZoomEncoders = nil
+	cfg.SourceCache = nil
+	return cfg
+}
+
+// newTerrainWriter creates the --terrain-output archive with the imagery's
+// tileset options, its own bounds, description, and source provenance, and
+// its temp file in tempDir. A layer id gets a "-terrain" suffix so the two
+// archives stay distinct.
+func newTerrainWriter(path, tempDir string, opts pmtiles.WriterOptions, tileFormat uint8, sources []*cog.Reader, bounds cog.Bounds, description string) (*pmtiles.Writer, error) {
+	opts.Bounds = bounds
+	opts.TileFormat = tileFormat
+	opts.TempDir = tempDir
+	opts.Description = description
+	opts.Readable = false
+	opts.PremultipliedAlpha = false
+	extra := sourceProvenance(sources)
+	if v, ok := opts.Extra["zoom_offset"]; ok {
+		if extra == nil {
+			extra = make(map[string]interface{})
+		}
+		extra["zoom_offset"] = v
+	}
+	opts.Extra = extra
+	if opts.LayerID != "" {
+		opts.LayerID += "-terrain"
+	}
+	return pmtiles.NewWriter(path, opts)
+}
+
+// runServe serves tiles over HTTP, rendering them from the sources on demand
+// and flushing the cache into outputPath until interrupted.
+func runServe(cfg tile.Config, sources []*cog.Reader, writerOpts pmtiles.WriterOptions,
+	outputPath, addr string, flushInterval time.Duration) {
+	renderer, err := tile.NewRenderer(cfg, sources)
+	if err != nil {
+		log.Fatalf("Renderer: %v", err)
+	}
+
+	srv := serve.New(renderer, serve.Options{
+		OutputPath:    outputPath,
+		FlushInterval: flushInterval,
+		Writer:        writerOpts,
+		Verbose:       cfg.Verbose,
+	})
+	n, err := srv.Load(outputPath)
+	if err != nil {
+		log.Fatalf("Loading cached tiles: %v", err)
+	}
+	defer srv.Close()
+	if n > 0 {
+		log.Printf("Serving %d cached tile(s) from %s", n, outputPath)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Serving tiles at http://%s/{z}/{x}/{y}%s (Ctrl-C to stop)", addr, cfg.Encoder.FileExtension())
+	if err := srv.Run(ctx, addr); err != nil {
+		log.Fatalf("Serve: %v", err)
+	}
+	fmt.Printf("Done: %d tiles cached → %s\n", srv.NumTiles(), outputPath)
+}
+
+// runDaemon serves the job API on addr and runs submitted jobs one at a
+// time until interrupted.
+func runDaemon(addr string, run daemon.Runner, verbose bool) {
+	ln, err := daemon.Listen(addr)
+	if err != nil {
+		log.Fatalf("Daemon: %v", err)
+	}
+	d := daemon.New(run, verbose)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Accepting jobs at %s (POST /jobs, GET /jobs/{id}; Ctrl-C to stop)", addr)
+	if err := d.Run(ctx, ln); err != nil {
+		log.Fatalf("Daemon: %v", err)
+	}
+}
+
+// runBatch runs the jobs of a --batch file in order with run and returns
+// how many failed. A failed job is logged and the batch goes on, so one bad
+// extent does not cost the extracts after it.
+func runBatch(jobs []daemon.Job, run daemon.Runner) (failed int) {
+	for i, job := range jobs {
+		start := time.Now()
+		log.Printf("Job %d/%d: %s", i+1, len(jobs), job.Output)
+		res, err := run(job)
+		if err != nil {
+			log.Printf("Job %d/%d failed: %v", i+1, len(jobs), err)
+			failed++
+			continue
+		}
+		fmt.Printf("Done: %d tiles, %s, %s → %s\n", res.Tiles, units.Size(res.Bytes), units.Duration(time.Since(start)), job.Output)
+	}
+	return failed
+}
+
+// jobRunner turns daemon jobs into tile generation runs over the sources
+// opened at startup. cfg and writerOpts hold the startup settings that a
+// job falls back to; cfg.SourceCache is shared so later jobs start warm.
+type jobRunner struct {
+	cfg        tile.Config
+	writerOpts pmtiles.WriterOptions
+	sources    []*cog.Reader
+	format     string
+	quality    int
+	tileFilter string
+	tempDir    string // --temp-dir and --writer-temp-dir, planned per job output
+	writerDir  string
+	describe   func(b cog.Bounds, format string, quality, minZoom, maxZoom int) string
+}
+
+func (jr *jobRunner) run(job daemon.Job) (daemon.Result, error) {
+	cfg := jr.cfg
+	b := cfg.Bounds
+	if jb := job.Bounds; jb != nil {
+		b.MinLon, b.MinLat = math.Max(b.MinLon, jb[0]), math.Max(b.MinLat, jb[1])
+		b.MaxLon, b.MaxLat = math.Min(b.MaxLon, jb[2]), math.Min(b.MaxLat, jb[3])
+		if b.MinLon >= b.MaxLon || b.MinLat >= b.MaxLat {
+			return daemon.Result{}, fmt.Errorf("bounds %v do not overlap the sources", *jb)
+		}
+	}
+
+	maxZoom := cfg.MaxZoom
+	if job.MaxZoom != nil {
+		maxZoom = *job.MaxZoom
+	}
+	var minZoom int
+	if job.MinZoom != nil {
+		minZoom = *job.MinZoom
+	} else {
+		minZoom = min(coord.MinZoomForSingleTile(b.MinLon, b.MinLat, b.MaxLon, b.MaxLat), maxZoom)
+	}
+	if minZoom < 0 || minZoom > maxZoom {
+		return daemon.Result{}, fmt.Errorf("invalid zoom range %d – %d", minZoom, maxZoom)
+	}
+
+	format, quality := jr.format, jr.quality
+	if job.Format != "" {
+		format = job.Format
+	}
+	if job.Quality > 0 {
+		quality = job.Quality
+	}
+	dem, elevation := encode.DEMEncodingFor(format)
+	if elevation != cfg.IsTerrarium {
+		return daemon.Result{}, fmt.Errorf("format %q does not match the sources (started as %s)", format, jr.format)
+	}
+	cfg.DEMEncoding = dem
+	enc, err := encode.NewEncoder(format, quality)
+	if err != nil {
+		return daemon.Result{}, err
+	}
+
+	dirs := scratch.Plan(job.Output, jr.tempDir, jr.writerDir)
+	if dirs.Warning != "" {
+		log.Printf("WARNING: %s", dirs.Warning)
+	}
+	cfg.MinZoom, cfg.MaxZoom = minZoom, maxZoom
+	cfg.Bounds = b
+	cfg.Encoder = enc
+	cfg.OutputDir = dirs.Spill
+	cfg.OwnSpillFiles = dirs.Spill != dirs.Writer
+
+	opts := jr.writerOpts
+	opts.MinZoom, opts.MaxZoom = minZoom, maxZoom
+	opts.Bounds = b
+	opts.TileFormat = enc.PMTileType()
+	opts.TempDir = dirs.Writer
+	opts.Description = jr.describe(b, format, quality, minZoom, maxZoom)
+	opts.GeneratedAt = pmtiles.GenerationTime()
+	if job.Name != "" {
+		opts.Name = job.Name
+	}
+	if job.Version != "" {
+		opts.Version = job.Version
+	}
+	if job.LayerID != "" {
+		opts.LayerID = job.LayerID
+	}
+
+	writer, err := pmtiles.NewWriter(job.Output, opts)
+	if err != nil {
+		return daemon.Result{}, fmt.Errorf("creating PMTiles writer: %w", err)
+	}
+	var out tile.TileWriter = writer
+	if jr.tileFilter != "" {
+		out = tile.NewFilterWriter(writer, jr.tileFilter, format)
+	}
+	stats, err := tile.Generate(cfg, jr.sources, out)
+	if err != nil {
+		writer.Abort()
+		return daemon.Result{}, fmt.Errorf("tile generation: %w", err)
+	}
+	if err := writer.Finalize(); err != nil {
+		return daemon.Result{}, fmt.Errorf("finalizing PMTiles: %w", err)
+	}
+	if n := writer.DuplicateTiles(); n > 0 {
+		log.Printf("WARNING: %d tile(s) were written more than once; kept the last write", n)
+	}
+	fi, err := os.Stat(job.Output)
+	if err != nil {
+		return daemon.Result{}, err
+	}
+	return daemon.Result{Tiles: stats.TileCount, Bytes: fi.Size()}, nil
+}
+
+// checkOverlaps logs how much overlapping sources disagree (see
+// cog.CheckOverlaps) and warns about pairs whose mean difference exceeds
+// maxDelta, typically misaligned or differently processed deliveries. With
+// fail set, such a pair aborts the run before anything is generated.
+func checkOverlaps(sources []*cog.Reader, maxDelta float64, fail bool) {
+	start := time.Now()
+	reports := cog.CheckOverlaps(sources)
+	log.Printf("Overlap check: %d overlapping pair(s) sampled in %s", len(reports), units.Duration(time.Since(start)))
+	var bad int
+	for _, r := range reports {
+		line := fmt.Sprintf("%s / %s: mean Δ %.1f, max Δ %.1f over %d samples (X [%.1f, %.1f], Y [%.1f, %.1f])",
+			filepath.Base(sources[r.A].Path()), filepath.Base(sources[r.B].Path()),
+			r.MeanDelta, r.MaxDelta, r.Samples, r.MinX, r.MaxX, r.MinY, r.MaxY)
+		if r.MeanDelta > maxDelta {
+			log.Printf("WARNING: sources disagree where they overlap: %s", line)
+			bad++
+		} else {
+			log.Printf("  %s", line)
+		}
+	}
+	if bad > 0 && fail {
+		log.Fatalf("--overlap-check fail: %d overlapping pair(s) differ by more than mean Δ %g", bad, maxDelta)
+	}
+}
+
+// auditEPSG reports the CRS inferred for each source without GeoKeys,
+// grouped by guess. With assume set, those sources use it instead. Without,
+// an uncertain guess is an error: generating from it could shift the whole
+// archive by hundreds of meters or more.
+func auditEPSG(sources []*cog.Reader, assume int, verbose bool) error {
+	if assume != 0 && coord.ForEPSG(assume) == nil {
+		return fmt.Errorf("--assume-epsg %d is not supported (see README, Coordinate reference systems)", assume)
+	}
+	var groups []cog.EPSGGuess
+	files := make(map[cog.EPSGGuess][]string)
+	for _, src := range sources {
+		g := src.EPSGGuess()
+		if g == nil {
+			continue
+		}
+		if _, ok := files[*g]; !ok {
+			groups = append(groups, *g)
+		}
+		files[*g] = append(files[*g], filepath.Base(src.Path()))
+		if assume != 0 {
+			src.AssumeEPSG(assume)
+		}
+	}
+	var uncertain int
+	for _, g := range groups {
+		confidence := "high"
+		if !g.Confident {
+			confidence = "low"
+			uncertain += len(files[g])
+		}
+		if assume != 0 {
+			log.Printf("CRS: EPSG:%d assumed for %d file(s) without GeoKeys (--assume-epsg; guessed EPSG:%d with %s confidence: %s)",
+				assume, len(files[g]), g.EPSG, confidence, g.Reason)
+		} else {
+			log.Printf("CRS: EPSG:%d inferred for %d file(s) without GeoKeys (%s confidence: %s)",
+				g.EPSG, len(files[g]), confidence, g.Reason)
+		}
+		if verbose {
+			log.Printf("  %s", strings.Join(files[g], ", "))
+		}
+	}
+	if uncertain > 0 && assume == 0 {
+		return fmt.Errorf("the CRS of %d file(s) without GeoKeys is uncertain; check their coordinates and confirm with --assume-epsg", uncertain)
+	}
+	return nil
+}
+
+// checkMemory prints the peak memory estimate as a settings line and warns,
+// or with fail aborts, when it exceeds the available memory.
+func checkMemory(e tile.MemoryEstimate, fail, verbose bool) {
+	avail, err := tile.AvailableMemory()
+	if err != nil {
+		fmt.Printf("  %-14s ~%s (available RAM unknown: %v)\n", "Peak memory:", units.Size(e.Total()), err)
+		return
+	}
+	fmt.Printf("  %-14s ~%s (available %s)\n", "Peak memory:", units.Size(e.Total()), units.Size(int64(avail)))
+	if verbose {
+		log.Printf("Memory estimate: source cache %s, raw cache %s, pinned overviews %s, workers %s, tile stores %s, encode cache %s, index %s, runtime %s",
+			units.Size(e.SourceCache), units.Size(e.RawCache), units.Size(e.Pinned), units.Size(e.Workers),
+			units.Size(e.Stores), units.Size(e.EncodeCache), units.Size(e.Index), units.Size(e.Runtime))
+	}
+	if e.Total() <= int64(avail) {
+		return
+	}
+	msg := fmt.Sprintf("estimated peak memory %s exceeds available %s (tile stores %s, source cache %s, workers %s); "+
+		"set a lower --mem-limit (spilling to disk; not with --no-spill), lower --concurrency, or free memory",
+		units.Size(e.Total()), units.Size(int64(avail)), units.Size(e.Stores), units.Size(e.SourceCache), units.Size(e.Workers))
+	if fail {
+		log.Fatalf("--mem-check fail: %s", msg)
+	}
+	log.Printf("WARNING: %s", msg)
+}
+
+// applyNoData sets the nodata spec of each source: a manifest entry
+// matching the source's path wins, then --nodata, then the file's own
+// GDAL_NODATA tag (already applied by cog.Open). The spec masks float
+// samples (and integer ones read as values) to NaN and, for 8/16-bit
+// imagery, replaces the band config's nodata value.
+func applyNoData(sources []*cog.Reader, global string, mf *manifest.Manifest, verbose bool) error {
+	var globalSpec *cog.NoDataSpec
+	if global != "" {
+		spec, err := cog.ParseNoDataSpec(global)
+		if err != nil {
+			return err
+		}
+		globalSpec = &spec
+		log.Printf("Nodata: %s", spec)
+	}
+	set := func(src *cog.Reader, spec cog.NoDataSpec) {
+		src.SetFloatNoData(spec)
+		src.SetIntegerNoData(spec)
+	}
+	for _, src := range sources {
+		switch entry := mf.Lookup(src.Path()); {
+		case entry != nil && entry.NoData != "":
+			spec, err := cog.ParseNoDataSpec(entry.NoData)
+			if err != nil {
+				return fmt.Errorf("manifest entry %q: %v", entry.Path, err)
+			}
+			set(src, spec)
+		case globalSpec != nil:
+			set(src, *globalSpec)
+		}
+		if verbose {
+			if spec := src.FloatNoData(); !spec.IsEmpty() {
+				log.Printf("  %s: nodata %s", filepath.Base(src.Path()), spec)
+			}
+		}
+	}
+	return nil
+}
+
+// logNaNFallbacks logs the NaN sampling fallbacks of the generation of
+// output per zoom, with the tiles that had the most (see --nan-report).
+func logNaNFallbacks(output string, fallbacks []tile.NaNFallbacks, zoomOffset int) {
+	for _, f := range fallbacks {
+		log.Printf("%s: z%d: %d sample(s) in %d tile(s) fell back to nearest-neighbour on nodata neighbours",
+			filepath.Base(output), f.Zoom+zoomOffset, f.Samples, f.Tiles)
+		for _, t := range f.Worst {
+			minLon, minLat, maxLon, maxLat := coord.TileBounds(t.Z, t.X, t.Y)
+			log.Printf("  z%d/%d/%d: %d sample(s), around %.4f, %.4f",
+				t.Z+zoomOffset, t.X, t.Y, t.Samples, (minLon+maxLon)/2, (minLat+maxLat)/2)
+		}
+	}
+}
+
+// splitBackfillInputs separates the PMTiles archives named among the
+// inputs from the GeoTIFF inputs. Archives are taken only when named
+// explicitly: a directory walk could find the output of an earlier run.
+func splitBackfillInputs(paths []string) (tiffs, archives []string) {
+	for _, p := range paths {
+		if strings.HasSuffix(strings.ToLower(p), ".pmtiles") {
+			archives = append(archives, p)
+		} else {
+			tiffs = append(tiffs, p)
+		}
+	}
+	return tiffs, archives
+}
+
+// openBackfill opens PMTiles archives as backfill sources, with cache IDs
+// from firstID on, after those of the GeoTIFF sources. Each archive's tile
+// size is read from its metadata, or found by decoding a tile.
+func openBackfill(paths []string, firstID int) ([]*tile.PMTilesSource, []*pmtiles.Reader, error) {
+	var srcs []*tile.PMTilesSource
+	var readers []*pmtiles.Reader
+	for i, p := range paths {
+		r, err := pmtiles.OpenReader(p)
+		if err != nil {
+			for _, r := range readers {
+				r.Close()
+			}
+			return nil, nil, err
+		}
+		readers = append(readers, r)
+		src, err := tile.NewPMTilesSource(r, firstID+i, r.TileSize())
+		if err != nil {
+			for _, r := range readers {
+				r.Close()
+			}
+			return nil, nil, fmt.Errorf("%s: %w", p, err)
+		}
+		srcs = append(srcs, src)
+	}
+	return srcs, readers, nil
 }
 
 // collectTIFFs resolves input paths to a list of .tif files.
 // Directories are walked recursively to find TIFF files in subfolders.
+// A .vrt named explicitly is kept as is and expanded by cog.OpenAll;
+// directory walks skip VRTs, which usually sit next to the files they
+// reference. URLs are kept as they are: remote directories cannot be
+// listed.
 func collectTIFFs(paths []string) ([]string, error) {
 	var result []string
 	for _, p := range paths {
+		if remote.IsURL(p) {
+			result = append(result, p)
+			continue
+		}
 		info, err := os.Stat(p)
 		if err != nil {
 			return nil, fmt.Errorf("stat %s: %w", p, err)
@@ -414,7 +2570,7 @@ func collectTIFFs(paths []string) ([]string, error) {
 			if err != nil {
 				return nil, fmt.Errorf("walk %s: %w", p, err)
 			}
-		} else if isTIFF(p) {
+		} else if isTIFF(p) || cog.IsVRT(p) {
 			result = append(result, p)
 		}
 	}
@@ -427,19 +2583,32 @@ func isTIFF(name string) bool {
 }
 
 func buildDescription(sources []*cog.Reader, mergedBounds cog.Bounds, gaps []cog.CoverageGap,
-	format string, quality int, tileSize int, minZoom, maxZoom int, resampling string, resamplingGamma float64, fc *color.RGBA, bandCfg cog.BandConfig) string {
+	format string, quality int, zoomQuality map[int]int, tileSize int, minZoom, maxZoom, zoomOffset int, resampling string, resamplingGamma float64, fc *color.RGBA, bandCfg cog.BandConfig) string {
 
 	var b strings.Builder
 
 	b.WriteString(fmt.Sprintf("Processing: geotiff2pmtiles %s\n", version))
 	switch format {
 	case "jpeg", "webp":
-		b.WriteString(fmt.Sprintf("  Format: %s (quality: %d)\n", format, quality))
+		if len(zoomQuality) > 0 {
+			b.WriteString(fmt.Sprintf("  Format: %s (quality per zoom:", format))
+			for z := minZoom; z <= maxZoom; z++ {
+				if q, ok := zoomQuality[z]; ok {
+					b.WriteString(fmt.Sprintf(" z%d=%s", z, encode.QualityName(q)))
+				}
+			}
+			b.WriteString(")\n")
+		} else {
+			b.WriteString(fmt.Sprintf("  Format: %s (quality: %s)\n", format, encode.QualityName(quality)))
+		}
 	default:
 		b.WriteString(fmt.Sprintf("  Format: %s\n", format))
 	}
 	b.WriteString(fmt.Sprintf("  Tile size: %dpx\n", tileSize))
 	b.WriteString(fmt.Sprintf("  Zoom: %d - %d\n", minZoom, maxZoom))
+	if zoomOffset != 0 {
+		b.WriteString(fmt.Sprintf("  Zoom offset: +%d (stored as zoom %d - %d)\n", zoomOffset, minZoom+zoomOffset, maxZoom+zoomOffset))
+	}
 	if resamplingGamma != 1.0 {
 		b.WriteString(fmt.Sprintf("  Resampling: %s (gamma %.2g)\n", resampling, resamplingGamma))
 	} else {
@@ -469,7 +2638,11 @@ func buildDescription(sources []*cog.Reader, mergedBounds cog.Bounds, gaps []cog
 	b.WriteString("\n")
 
 	epsg := sources[0].EPSG()
-	b.WriteString(fmt.Sprintf("Source: %d GeoTIFF file(s), EPSG:%d\n", len(sources), epsg))
+	if epsg == coord.UserDefined {
+		b.WriteString(fmt.Sprintf("Source: %d GeoTIFF file(s), user-defined CRS %q (from WKT)\n", len(sources), sources[0].CRSName()))
+	} else {
+		b.WriteString(fmt.Sprintf("Source: %d GeoTIFF file(s), EPSG:%d\n", len(sources), epsg))
+	}
 
 	mergedMinX, mergedMinY := math.MaxFloat64, math.MaxFloat64
 	mergedMaxX, mergedMaxY := -math.MaxFloat64, -math.MaxFloat64
@@ -507,7 +2680,81 @@ func buildDescription(sources []*cog.Reader, mergedBounds cog.Bounds, gaps []cog
 	return b.String()
 }
 
+// sourceProvenance collects GDAL_METADATA provenance from the sources for the
+// PMTiles metadata JSON: band descriptions and statistics (from the first
+// source that has them), the distinct acquisition dates, and the overview
+// resampling method. Returns nil when the sources carry none of these.
+func sourceProvenance(sources []*cog.Reader) map[string]interface{} {
+	extra := make(map[string]interface{})
+
+	for _, src := range sources {
+		bands := src.GDALMeta().Bands()
+		if len(bands) == 0 {
+			continue
+		}
+		out := make([]map[string]interface{}, len(bands))
+		for i, b := range bands {
+			m := map[string]interface{}{"band": b.Band}
+			if b.Description != "" {
+				m["description"] = b.Description
+			}
+			if b.Unit != "" {
+				m["unit"] = b.Unit
+			}
+			if b.HasStats {
+				m["min"], m["max"], m["mean"], m["stddev"] = b.Min, b.Max, b.Mean, b.StdDev
+			}
+			out[i] = m
+		}
+		extra["source_bands"] = out
+		break
+	}
+
+	seen := make(map[string]bool)
+	var dates []string
+	for _, src := range sources {
+		if d := src.GDALMeta().AcquisitionDate(); d != "" && !seen[d] {
+			seen[d] = true
+			dates = append(dates, d)
+		}
+	}
+	if len(dates) > 0 {
+		sort.Strings(dates)
+		extra["acquisition_dates"] = dates
+	}
+
+	if r := sources[0].GDALMeta().OverviewResampling(); r != "" {
+		extra["overview_resampling"] = r
+	}
+
+	if len(extra) == 0 {
+		return nil
+	}
+	return extra
+}
+
 // parseColor parses an RGBA color from "R,G,B,A" or "#RRGGBBAA" format.
+// clampToMercator clips b to the Web Mercator latitude limit so that zoom
+// detection, tile ranges, and metadata describe only what can be rendered.
+// It warns with the area that is dropped and exits if nothing remains.
+func clampToMercator(b cog.Bounds) cog.Bounds {
+	lo, hi, ok := coord.ClampMercatorLat(b.MinLat, b.MaxLat)
+	if !ok {
+		log.Fatalf("Source data (lat %.4f to %.4f) lies entirely beyond the Web Mercator limit of ±%.4f°; use an EPSG:4326 tiling scheme instead (see README, Polar data)",
+			b.MinLat, b.MaxLat, coord.MaxMercatorLat)
+	}
+	if lo == b.MinLat && hi == b.MaxLat {
+		return b
+	}
+	total := coord.LonLatArea(b.MinLon, b.MinLat, b.MaxLon, b.MaxLat)
+	kept := coord.LonLatArea(b.MinLon, lo, b.MaxLon, hi)
+	log.Printf("WARNING: Source extends to lat %.4f to %.4f, beyond the Web Mercator limit of ±%.4f°; clipping drops %.0f km² (%.1f%% of the source extent)",
+		b.MinLat, b.MaxLat, coord.MaxMercatorLat, total-kept, 100*(total-kept)/total)
+	log.Printf("WARNING: For polar coverage use an EPSG:4326 tiling scheme instead (see README, Polar data)")
+	b.MinLat, b.MaxLat = lo, hi
+	return b
+}
+
 func parseColor(s string) (color.RGBA, error) {
 	if strings.HasPrefix(s, "#") {
 		return parseHexColor(s)
@@ -560,22 +2807,30 @@ func parseHexColor(s string) (color.RGBA, error) {
 	return color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: uint8(a)}, nil
 }
 
-// parseBandConfig parses CLI flags into a cog.BandConfig.
-func parseBandConfig(bandsStr, alphaBandStr, rescaleStr, rescaleRange string, firstSrc *cog.Reader) (cog.BandConfig, error) {
-	var cfg cog.BandConfig
-
+// parseBandConfig parses CLI flags into a cog.BandConfig. stretch reports a
+// percentile --rescale-range ("p2,p98"): RescaleMin and RescaleMax then hold
+// the percentiles, to be resolved with cog.Percentiles once nodata is known.
+func parseBandConfig(bandsStr, alphaBandStr, rescaleStr, rescaleRange string, firstSrc *cog.Reader) (cfg cog.BandConfig, stretch bool, err error) {
 	// Parse --bands.
 	parts := strings.Split(bandsStr, ",")
 	if len(parts) != 3 {
-		return cfg, fmt.Errorf("--bands must be 3 comma-separated band numbers (e.g. \"1,2,3\"), got %q", bandsStr)
+		return cfg, false, fmt.Errorf("--bands must be 3 comma-separated band numbers (e.g. \"1,2,3\"), got %q", bandsStr)
 	}
 	for i, p := range parts {
 		v, err := strconv.Atoi(strings.TrimSpace(p))
 		if err != nil || v < 1 {
-			return cfg, fmt.Errorf("invalid band number %q (must be >= 1)", p)
+			return cfg, false, fmt.Errorf("invalid band number %q (must be >= 1)", p)
 		}
 		cfg.Bands[i] = v
 	}
+	bandsExplicit := bandsStr != "1,2,3"
+	if spp := firstSrc.SamplesPerPixel(); bandsExplicit {
+		for _, b := range cfg.Bands {
+			if b > spp {
+				return cfg, false, fmt.Errorf("--bands: band %d out of range, %s has %d band(s)", b, firstSrc.Path(), spp)
+			}
+		}
+	}
 
 	// Parse --alpha-band.
 	switch alphaBandStr {
@@ -584,14 +2839,24 @@ func parseBandConfig(bandsStr, alphaBandStr, rescaleStr, rescaleRange string, fi
 	default:
 		v, err := strconv.Atoi(strings.TrimSpace(alphaBandStr))
 		if err != nil {
-			return cfg, fmt.Errorf("--alpha-band must be \"auto\" or an integer, got %q", alphaBandStr)
+			return cfg, false, fmt.Errorf("--alpha-band must be \"auto\" or an integer, got %q", alphaBandStr)
 		}
 		cfg.AlphaBand = v
 	}
 
 	// Parse --rescale and --rescale-range.
+	setRange := func(mode cog.RescaleMode) error {
+		if rescaleRange == "" {
+			return fmt.Errorf("--rescale-range is required when --rescale is set to %q", rescaleStr)
+		}
+		minV, maxV, pct, err := parseRescaleRange(rescaleRange)
+		if err != nil {
+			return fmt.Errorf("--rescale-range: %w", err)
+		}
+		cfg.Rescale, cfg.RescaleMin, cfg.RescaleMax, stretch = mode, minV, maxV, pct
+		return nil
+	}
 	is16 := firstSrc.BitsPerSample() == 16
-	bandsExplicit := bandsStr != "1,2,3"
 	switch rescaleStr {
 	case "auto":
 		if is16 {
@@ -599,51 +2864,55 @@ func parseBandConfig(bandsStr, alphaBandStr, rescaleStr, rescaleRange string, fi
 				// Try auto-detection from GDAL metadata before erroring.
 				if preset, ok := firstSrc.DetectPreset(); ok && !bandsExplicit {
 					log.Printf("Auto-detected: %s (%s)", preset.Name, preset.BandCfg)
-					return preset.BandCfg, nil
+					return preset.BandCfg, false, nil
 				}
-				return cfg, fmt.Errorf("16-bit GeoTIFF detected: --rescale-range min,max is required\n" +
-					"  Hint: use gdalinfo or inspect the data to find the value range.\n" +
-					"  Example: --rescale linear --rescale-range 0,5000")
+				return cfg, false, fmt.Errorf("16-bit GeoTIFF detected: --rescale-range min,max is required\n" +
+					"  Hint: use gdalinfo or inspect the data to find the value range, or stretch between percentiles.\n" +
+					"  Example: --rescale linear --rescale-range 0,5000 (or --rescale-range p2,p98)")
 			}
-			cfg.Rescale = cog.RescaleLinear
-			minV, maxV, err := parseRange(rescaleRange)
-			if err != nil {
-				return cfg, fmt.Errorf("--rescale-range: %w", err)
-			}
-			cfg.RescaleMin = minV
-			cfg.RescaleMax = maxV
+			err = setRange(cog.RescaleLinear)
+		} else if rescaleRange != "" {
+			// An explicit range stretches 8-bit data too.
+			err = setRange(cog.RescaleLinear)
 		} else {
 			cfg.Rescale = cog.RescaleNone
 		}
 	case "linear":
-		if rescaleRange == "" {
-			return cfg, fmt.Errorf("--rescale-range is required when --rescale is set to %q", rescaleStr)
-		}
-		minV, maxV, err := parseRange(rescaleRange)
-		if err != nil {
-			return cfg, fmt.Errorf("--rescale-range: %w", err)
-		}
-		cfg.Rescale = cog.RescaleLinear
-		cfg.RescaleMin = minV
-		cfg.RescaleMax = maxV
+		err = setRange(cog.RescaleLinear)
 	case "log":
-		if rescaleRange == "" {
-			return cfg, fmt.Errorf("--rescale-range is required when --rescale is set to %q", rescaleStr)
-		}
-		minV, maxV, err := parseRange(rescaleRange)
-		if err != nil {
-			return cfg, fmt.Errorf("--rescale-range: %w", err)
-		}
-		cfg.Rescale = cog.RescaleLog
-		cfg.RescaleMin = minV
-		cfg.RescaleMax = maxV
+		err = setRange(cog.RescaleLog)
 	case "none":
 		cfg.Rescale = cog.RescaleNone
 	default:
-		return cfg, fmt.Errorf("--rescale must be auto, linear, log, or none, got %q", rescaleStr)
+		err = fmt.Errorf("--rescale must be auto, linear, log, or none, got %q", rescaleStr)
 	}
+	return cfg, stretch, err
+}
 
-	return cfg, nil
+// parseRescaleRange parses --rescale-range: "min,max" values, or "pLO,pHI"
+// percentiles of the data (pct true), e.g. "p2,p98".
+func parseRescaleRange(s string) (minV, maxV float64, pct bool, err error) {
+	lo, hi, ok := strings.Cut(s, ",")
+	lo, hi = strings.TrimSpace(lo), strings.TrimSpace(hi)
+	if ok && strings.HasPrefix(lo, "p") && strings.HasPrefix(hi, "p") {
+		minV, err1 := strconv.ParseFloat(lo[1:], 64)
+		maxV, err2 := strconv.ParseFloat(hi[1:], 64)
+		if err1 != nil || err2 != nil || minV < 0 || maxV > 100 || minV >= maxV {
+			return 0, 0, false, fmt.Errorf("invalid percentiles %q (want pLO,pHI with 0 <= LO < HI <= 100, e.g. p2,p98)", s)
+		}
+		return minV, maxV, true, nil
+	}
+	minV, maxV, err = parseRange(s)
+	return minV, maxV, false, err
+}
+
+// integerNoDataRange returns the nodata values integer samples of src can
+// hold: -32768 to 32767 for signed samples, 0 to 65535 otherwise.
+func integerNoDataRange(src *cog.Reader) (lo, hi float64) {
+	if src.IsSigned() {
+		return -32768, 32767
+	}
+	return 0, 65535
 }
 
 // parseRange parses a "min,max" string into two float64 values.
@@ -663,20 +2932,11 @@ func parseRange(s string) (float64, float64, error) {
 	return minV, maxV, nil
 }
 
-func humanSize(bytes int64) string {
-	const (
-		KB = 1024
-		MB = KB * 1024
-		GB = MB * 1024
-	)
-	switch {
-	case bytes >= GB:
-		return fmt.Sprintf("%.1f GB", float64(bytes)/float64(GB))
-	case bytes >= MB:
-		return fmt.Sprintf("%.1f MB", float64(bytes)/float64(MB))
-	case bytes >= KB:
-		return fmt.Sprintf("%.1f KB", float64(bytes)/float64(KB))
-	default:
-		return fmt.Sprintf("%d B", bytes)
+// joinFactors formats overview decimation factors as "2×, 8×".
+func joinFactors(factors []int) string {
+	parts := make([]string, len(factors))
+	for i, f := range factors {
+		parts[i] = fmt.Sprintf("%d×", f)
 	}
+	return strings.Join(parts, ", ")
 }
@@ -0,0 +1,210 @@
+// pmcoverage exports the tiles present in a PMTiles archive as GeoJSON or CSV.
+//
+// Usage:
+//
+//	pmcoverage [flags] input.pmtiles
+//
+// By default every tile at the archive's max zoom is written as a GeoJSON
+// polygon feature. --outline instead writes a single MultiPolygon tracing the
+// area covered at that zoom, and --format csv writes plain z,x,y rows.
+// Useful for planning incremental updates and checking what was generated.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/buildinfo"
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+)
+
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+func main() {
+	var (
+		zoom       int
+		format     string
+		outline    bool
+		outputPath string
+	)
+	flag.IntVar(&zoom, "zoom", -1, "Zoom level to export (default: archive max zoom)")
+	flag.StringVar(&format, "format", "geojson", "Output format: geojson, csv")
+	flag.BoolVar(&outline, "outline", false, "Export the coverage outline as one MultiPolygon instead of individual tiles (geojson only)")
+	flag.StringVar(&outputPath, "output", "", "Output file (default: stdout)")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s\n\n", buildinfo.New("pmcoverage", version, commit, buildDate))
+		fmt.Fprintf(os.Stderr, "Usage: pmcoverage [flags] input.pmtiles\n\n")
+		fmt.Fprintf(os.Stderr, "Export the tiles present in a PMTiles archive as GeoJSON or CSV.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  pmcoverage map.pmtiles > tiles.geojson\n")
+		fmt.Fprintf(os.Stderr, "  pmcoverage --outline --output coverage.geojson map.pmtiles\n")
+		fmt.Fprintf(os.Stderr, "  pmcoverage --zoom 12 --format csv map.pmtiles > z12.csv\n")
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err := run(flag.Arg(0), outputPath, zoom, format, outline); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(inputPath, outputPath string, zoom int, format string, outline bool) error {
+	if format != "geojson" && format != "csv" {
+		return fmt.Errorf("unknown format %q (want: geojson, csv)", format)
+	}
+	if outline && format != "geojson" {
+		return fmt.Errorf("--outline requires --format geojson")
+	}
+
+	reader, err := pmtiles.OpenReader(inputPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	h := reader.Header()
+	if zoom < 0 {
+		zoom = int(h.MaxZoom)
+	}
+	if zoom < int(h.MinZoom) || zoom > int(h.MaxZoom) {
+		return fmt.Errorf("zoom %d outside archive range %d–%d", zoom, h.MinZoom, h.MaxZoom)
+	}
+
+	var out io.Writer = os.Stdout
+	if outputPath != "" {
+		f, err := os.Create(outputPath)
+		if err != nil {
+			return fmt.Errorf("creating %s: %w", outputPath, err)
+		}
+		defer f.Close()
+		out = f
+	}
+	bw := bufio.NewWriter(out)
+
+	switch {
+	case format == "csv":
+		err = writeCSV(bw, reader, zoom)
+	case outline:
+		// Tracing needs all tiles at once.
+		err = writeOutline(bw, zoom, reader.TilesAtZoom(zoom))
+	default:
+		err = writeTiles(bw, reader, zoom)
+	}
+	if err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "%d tiles at zoom %d\n", reader.TileCountAtZoom(zoom), zoom)
+	return nil
+}
+
+func writeCSV(w io.Writer, reader *pmtiles.Reader, z int) error {
+	if _, err := fmt.Fprintln(w, "z,x,y"); err != nil {
+		return err
+	}
+	return reader.ForEachTileAtZoom(z, func(x, y int) error {
+		_, err := fmt.Fprintf(w, "%d,%d,%d\n", z, x, y)
+		return err
+	})
+}
+
+type feature struct {
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties"`
+	Geometry   geometry               `json:"geometry"`
+}
+
+type geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// writeTiles streams one Polygon feature per tile, so memory stays flat even
+// for archives with millions of tiles at the chosen zoom.
+func writeTiles(w io.Writer, reader *pmtiles.Reader, z int) error {
+	if _, err := io.WriteString(w, `{"type":"FeatureCollection","features":[`+"\n"); err != nil {
+		return err
+	}
+	first := true
+	err := reader.ForEachTileAtZoom(z, func(x, y int) error {
+		ring := lonLatRing(z, coord.TileRing{{x, y}, {x, y + 1}, {x + 1, y + 1}, {x + 1, y}, {x, y}})
+		data, err := json.Marshal(feature{
+			Type:       "Feature",
+			Properties: map[string]interface{}{"z": z, "x": x, "y": y},
+			Geometry:   geometry{Type: "Polygon", Coordinates: [][][2]float64{ring}},
+		})
+		if err != nil {
+			return err
+		}
+		if !first {
+			if _, err := io.WriteString(w, ",\n"); err != nil {
+				return err
+			}
+		}
+		first = false
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n]}\n")
+	return err
+}
+
+// writeOutline writes a FeatureCollection with a single MultiPolygon feature
+// tracing the area covered by tiles.
+func writeOutline(w io.Writer, zoom int, tiles [][3]int) error {
+	var coords [][][][2]float64
+	for _, poly := range coord.TileOutline(tiles) {
+		rings := make([][][2]float64, len(poly))
+		for i, r := range poly {
+			rings[i] = lonLatRing(zoom, r)
+		}
+		coords = append(coords, rings)
+	}
+	fc := map[string]interface{}{
+		"type": "FeatureCollection",
+		"features": []feature{{
+			Type:       "Feature",
+			Properties: map[string]interface{}{"zoom": zoom, "tiles": len(tiles)},
+			Geometry:   geometry{Type: "MultiPolygon", Coordinates: coords},
+		}},
+	}
+	enc := json.NewEncoder(w)
+	return enc.Encode(fc)
+}
+
+// lonLatRing converts a ring of tile-grid corners to WGS84 coordinates,
+// rounded to 7 decimals (~1 cm).
+func lonLatRing(z int, r coord.TileRing) [][2]float64 {
+	out := make([][2]float64, len(r))
+	for i, p := range r {
+		lon, lat := coord.TileCornerLonLat(z, p[0], p[1])
+		out[i] = [2]float64{round7(lon), round7(lat)}
+	}
+	return out
+}
+
+func round7(v float64) float64 {
+	return math.Round(v*1e7) / 1e7
+}
@@ -21,6 +21,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/pspoerri/geotiff2pmtiles/internal/buildinfo"
 	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
 )
 
@@ -110,7 +111,7 @@ func main() {
 	flag.StringVar(&metadataFile, "metadata-file", "", "Replace entire metadata with JSON file content")
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "pmheader %s (%s, %s)\n\n", version, commit, buildDate)
+		fmt.Fprintf(os.Stderr, "%s\n\n", buildinfo.New("pmheader", version, commit, buildDate))
 		fmt.Fprintf(os.Stderr, "Usage: pmheader [flags] input.pmtiles [output.pmtiles]\n\n")
 		fmt.Fprintf(os.Stderr, "Patch PMTiles v3 header fields and metadata without re-encoding tile data.\n")
 		fmt.Fprintf(os.Stderr, "When output is omitted, changes are applied in-place.\n\n")
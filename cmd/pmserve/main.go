@@ -0,0 +1,110 @@
+// pmserve serves a PMTiles archive over HTTP with a map viewer, for checking
+// an output visually without deploying it to a tile server.
+//
+// Usage:
+//
+//	pmserve [flags] input.pmtiles
+//
+// Open the printed URL in a browser: the viewer shows the archive over an
+// OpenStreetMap base map, fitted to its bounds. Tiles are served at
+// /{z}/{x}/{y}.ext and described by /tiles.json (TileJSON), so the archive
+// can also be added to QGIS or another client as an XYZ layer.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/buildinfo"
+	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+	"github.com/pspoerri/geotiff2pmtiles/internal/serve"
+)
+
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+var errFound = errors.New("found")
+
+func main() {
+	var addr string
+	flag.StringVar(&addr, "addr", "localhost:8080", "Address to listen on (\":8080\" for all interfaces)")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s\n\n", buildinfo.New("pmserve", version, commit, buildDate))
+		fmt.Fprintf(os.Stderr, "Usage: pmserve [flags] input.pmtiles\n\n")
+		fmt.Fprintf(os.Stderr, "Serve a PMTiles archive over HTTP with a MapLibre viewer for visual QA.\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  pmserve output.pmtiles\n")
+		fmt.Fprintf(os.Stderr, "  pmserve --addr :9000 output.pmtiles\n")
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err := run(flag.Arg(0), addr); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(inputPath, addr string) error {
+	reader, err := pmtiles.OpenReader(inputPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	h := reader.Header()
+	tileSize := 0
+	if h.TileType != pmtiles.TileTypeMVT {
+		tileSize = discoverTileSize(reader)
+	}
+	handler, err := serve.Archive(reader, tileSize)
+	if err != nil {
+		return fmt.Errorf("%s: %w", inputPath, err)
+	}
+
+	host := addr
+	if strings.HasPrefix(host, ":") {
+		host = "localhost" + host
+	}
+	log.Printf("Serving %s (%s, zoom %d-%d, %d tiles) at http://%s/",
+		inputPath, pmtiles.TileTypeString(h.TileType), h.MinZoom, h.MaxZoom, reader.NumTiles(), host)
+	return http.ListenAndServe(addr, handler)
+}
+
+// discoverTileSize returns the tile size recorded in the archive metadata,
+// else the width of the first tile at the archive's max zoom, or 256 if it
+// does not decode (e.g. WebP in a build without cgo).
+func discoverTileSize(reader *pmtiles.Reader) int {
+	if size := reader.TileSize(); size > 0 {
+		return size
+	}
+	size := 256
+	format := pmtiles.TileTypeString(reader.Header().TileType)
+	z := int(reader.Header().MaxZoom)
+	reader.ForEachTileAtZoom(z, func(x, y int) error {
+		data, err := reader.ReadTile(z, x, y)
+		if err != nil || data == nil {
+			return nil
+		}
+		if img, err := encode.DecodeImage(data, format); err == nil && img.Bounds().Dx() > 0 {
+			size = img.Bounds().Dx()
+		}
+		return errFound
+	})
+	return size
+}
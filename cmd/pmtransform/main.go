@@ -1,22 +1,26 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"image/color"
 	"log"
 	"os"
-	"path/filepath"
 	"runtime"
 	"runtime/pprof"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/pspoerri/geotiff2pmtiles/internal/buildinfo"
 	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
 	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+	"github.com/pspoerri/geotiff2pmtiles/internal/mbtiles"
 	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+	"github.com/pspoerri/geotiff2pmtiles/internal/scratch"
 	"github.com/pspoerri/geotiff2pmtiles/internal/tile"
+	"github.com/pspoerri/geotiff2pmtiles/internal/ui"
 )
 
 // Set via -ldflags at build time.
@@ -26,6 +30,9 @@ var (
 	buildDate = "unknown"
 )
 
+// units formats the sizes and durations printed (--units, --machine-readable).
+var units ui.Format
+
 func main() {
 	var (
 		format          string
@@ -33,9 +40,12 @@ func main() {
 		minZoom         int
 		maxZoom         int
 		showVersion     bool
+		versionJSON     bool
 		tileSize        int
 		concurrency     int
 		verbose         bool
+		unitsName       string
+		machineReadable bool
 		resampling      string
 		cpuProfile      string
 		memProfile      string
@@ -46,41 +56,90 @@ func main() {
 		attribution     string
 		layerType       string
 		resamplingGamma float64
+		fsync           bool
+		stableLayout    bool
+		streaming       bool
+		tileFilter      string
+		tilesetName     string
+		tilesetVersion  string
+		layerID         string
+		dither          bool
+		tempDir         string
+		writerTempDir   string
+		merge           bool
+		premultiply     bool
 	)
 
 	flag.StringVar(&format, "format", "", "Target tile encoding: jpeg, png, webp (default: keep source format)")
 	flag.IntVar(&quality, "quality", 85, "JPEG/WebP quality 1-100")
 	flag.IntVar(&minZoom, "min-zoom", -1, "Minimum zoom level (default: keep source)")
 	flag.IntVar(&maxZoom, "max-zoom", -1, "Maximum zoom level (default: keep source)")
-	flag.IntVar(&tileSize, "tile-size", -1, "Output tile size in pixels (default: keep source)")
+	flag.IntVar(&tileSize, "tile-size", -1, "Output tile size in pixels, an even number between 2 and 4096 (default: keep source)")
 	flag.IntVar(&concurrency, "concurrency", runtime.NumCPU(), "Number of parallel workers")
 	flag.StringVar(&resampling, "resampling", "bicubic", "Interpolation method: lanczos, bicubic, bilinear, nearest, mode")
 	flag.Float64Var(&resamplingGamma, "resampling-gamma", 1.0, "Gamma correction for resampling output encoding (1.0 = disabled, typical 1.5-2.2 for dB-space to RGB)")
 	flag.BoolVar(&verbose, "verbose", false, "Verbose progress output")
+	flag.StringVar(&unitsName, "units", "binary", "Units for sizes in the output: binary (KiB, MiB, GiB) or si (kB, MB, GB); the decimal separator follows the locale (LC_ALL, LC_NUMERIC, LANG)")
+	flag.BoolVar(&machineReadable, "machine-readable", false, "Print sizes as plain bytes and durations as plain seconds, for scripts parsing the output")
 	flag.BoolVar(&showVersion, "version", false, "Print version and exit")
+	flag.BoolVar(&versionJSON, "json", false, "With --version, print version, commit, build date, Go version and enabled features as JSON")
 	flag.StringVar(&cpuProfile, "cpuprofile", "", "Write CPU profile to file")
 	flag.StringVar(&memProfile, "memprofile", "", "Write memory profile to file")
 	flag.IntVar(&memLimitMB, "mem-limit", 0, "Tile store memory limit in MB before disk spilling (0 = auto ~90% of RAM)")
 	flag.BoolVar(&noSpill, "no-spill", false, "Disable disk spilling (keep all tiles in memory)")
+	flag.StringVar(&tempDir, "temp-dir", "", "Directory for tile store spill files, e.g. a fast scratch disk (default: next to the output); also for the writer temp file when on the output's file system")
+	flag.StringVar(&writerTempDir, "writer-temp-dir", "", "Directory for the writer temp file holding all tile data (default: --temp-dir if on the output's file system, else next to the output)")
 	flag.StringVar(&fillColor, "fill-color", "0,0,0,0", "Substitute transparent/nodata with RGBA (color transform); also fill missing tile positions, e.g. \"0,0,0,255\" or \"#000000ff\" (default: transparent)")
-	flag.BoolVar(&rebuild, "rebuild", false, "Force full pyramid rebuild (required for resampling changes)")
+	flag.BoolVar(&fsync, "fsync", false, "Fsync the archive and its directory when finalizing, so it survives a power loss (the archive is always written to .partial and renamed)")
+	flag.BoolVar(&streaming, "streaming", false, "Write tile data straight into the archive instead of a temp file, so finalizing writes only the directories and the disk holds the data once (--stable-layout layout; clustered when tiles arrive in tile-ID order, as in a plain copy)")
+	flag.BoolVar(&stableLayout, "stable-layout", false, "Put tile data at a fixed offset ahead of metadata and leaf directories, so rebuilds keep unchanged tiles at the same byte offsets for rsync/zsync/S3 multipart deltas")
+	flag.StringVar(&tileFilter, "tile-filter", "", "Shell command each tile is piped through (stdin → stdout) before writing, e.g. \"pngquant -\"; gets TILE_Z, TILE_X, TILE_Y, TILE_FORMAT in its environment")
+	flag.BoolVar(&dither, "dither", false, "Ordered-dither 16-bit source tiles (e.g. 16-bit PNG) to 8 bits instead of rounding, to avoid banding in smooth gradients")
+	flag.BoolVar(&premultiply, "premultiply-alpha", false, "Store PNG/WebP tiles with their colors multiplied by alpha; =false converts premultiplied tiles back to straight alpha (default: keep source)")
+	flag.BoolVar(&rebuild, "rebuild", false, "Force full pyramid rebuild (required for resampling changes; lowering --min-zoom alone only adds the new levels)")
 	flag.StringVar(&attribution, "attribution", "", "Attribution string for data sources (default: keep source)")
 	flag.StringVar(&layerType, "type", "", "Layer type: baselayer, overlay (default: keep source)")
+	flag.StringVar(&tilesetName, "name", "", "Tileset name stored in metadata (default: keep source)")
+	flag.StringVar(&tilesetVersion, "tileset-version", "", "Tileset version stored in metadata, e.g. \"1.2.0\" (default: keep source)")
+	flag.StringVar(&layerID, "layer-id", "", "Stable layer identifier stored as \"id\" in metadata (default: keep source)")
+	flag.BoolVar(&merge, "merge", false, "Merge the archives given before the output into one, e.g. regional tilesets or the shards of a geotiff2pmtiles --shard run; later archives win where they overlap, drawn over earlier ones (--quality, --resampling apply to those tiles); metadata comes from the first archive")
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: pmtransform [flags] <input.pmtiles> <output.pmtiles>\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: pmtransform [flags] <input.pmtiles|.mbtiles> <output.pmtiles|.mbtiles>\n")
+		fmt.Fprintf(os.Stderr, "       pmtransform --merge [flags] <input.pmtiles>... <output.pmtiles>\n\n")
 		fmt.Fprintf(os.Stderr, "Transform an existing PMTiles archive: change format, zoom levels,\n")
-		fmt.Fprintf(os.Stderr, "resampling, or fill empty tiles. Always creates a new file.\n\n")
+		fmt.Fprintf(os.Stderr, "resampling, or fill empty tiles. Always creates a new file.\n")
+		fmt.Fprintf(os.Stderr, "Either side may be an MBTiles file, to convert between the two formats.\n")
+		fmt.Fprintf(os.Stderr, "With --merge, combine several archives of one tileset into one.\n\n")
 		fmt.Fprintf(os.Stderr, "Flags:\n")
 		flag.PrintDefaults()
 	}
 
 	flag.Parse()
 
+	// Fill in what -ldflags did not stamp (e.g. plain `go install`) from
+	// the build info the toolchain embeds.
+	build := buildinfo.New("pmtransform", version, commit, buildDate)
+	version, commit, buildDate = build.Version, build.Commit, build.BuildDate
+	if versionJSON && !showVersion {
+		log.Fatal("--json requires --version")
+	}
 	if showVersion {
-		fmt.Printf("pmtransform %s (commit %s, built %s)\n", version, commit, buildDate)
+		if versionJSON {
+			data, err := build.JSON()
+			if err != nil {
+				log.Fatalf("Failed to encode version: %v", err)
+			}
+			fmt.Println(string(data))
+		} else {
+			fmt.Println(build)
+		}
 		os.Exit(0)
 	}
+	var err error
+	if units, err = ui.New(unitsName, machineReadable); err != nil {
+		log.Fatalf("--units: %v", err)
+	}
 
 	// CPU profiling.
 	if cpuProfile != "" {
@@ -111,6 +170,36 @@ func main() {
 	}
 
 	args := flag.Args()
+	if merge {
+		flag.Visit(func(f *flag.Flag) {
+			if !mergeFlags[f.Name] {
+				log.Fatalf("--%s cannot be combined with --merge: merging only re-encodes where archives overlap; transform the merged archive instead", f.Name)
+			}
+		})
+		if len(args) < 3 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		if streaming && writerTempDir != "" {
+			log.Fatal("--writer-temp-dir has no effect with --streaming: tile data is written into the archive")
+		}
+		resamplingMode, err := tile.ParseResampling(resampling)
+		if err != nil {
+			log.Fatalf("Resampling: %v", err)
+		}
+		runMerge(args[:len(args)-1], args[len(args)-1], pmtiles.WriterOptions{
+			Name:         tilesetName,
+			Version:      tilesetVersion,
+			LayerID:      layerID,
+			Attribution:  attribution,
+			Type:         layerType,
+			Sync:         fsync,
+			SyncDir:      fsync,
+			StableLayout: stableLayout,
+			Streaming:    streaming,
+		}, quality, resamplingMode, tempDir, writerTempDir, verbose)
+		return
+	}
 	if len(args) != 2 {
 		flag.Usage()
 		os.Exit(1)
@@ -119,19 +208,29 @@ func main() {
 	inputPath := args[0]
 	outputPath := args[1]
 
-	if !strings.HasSuffix(inputPath, ".pmtiles") {
-		log.Fatal("Input file must have .pmtiles extension")
+	if !isTileset(inputPath) {
+		log.Fatal("Input file must have .pmtiles or .mbtiles extension")
 	}
-	if !strings.HasSuffix(outputPath, ".pmtiles") {
-		log.Fatal("Output file must have .pmtiles extension")
+	if !isTileset(outputPath) {
+		log.Fatal("Output file must have .pmtiles or .mbtiles extension")
 	}
 	if inputPath == outputPath {
 		log.Fatal("Input and output paths must be different")
 	}
+	mbtilesOutput := strings.HasSuffix(outputPath, ".mbtiles")
+	if streaming && mbtilesOutput {
+		log.Fatal("--streaming requires a .pmtiles output: MBTiles files are written in one pass at the end")
+	}
+	if stableLayout && mbtilesOutput {
+		log.Fatal("--stable-layout requires a .pmtiles output")
+	}
+	if streaming && writerTempDir != "" {
+		log.Fatal("--writer-temp-dir has no effect with --streaming: tile data is written into the archive")
+	}
 
-	// Open source PMTiles.
+	// Open the source tileset.
 	start := time.Now()
-	reader, err := pmtiles.OpenReader(inputPath)
+	reader, err := openTileset(inputPath)
 	if err != nil {
 		log.Fatalf("Opening input: %v", err)
 	}
@@ -139,9 +238,12 @@ func main() {
 
 	srcHeader := reader.Header()
 	srcFormat := pmtiles.TileTypeString(srcHeader.TileType)
+	srcPremultiplied := reader.PremultipliedAlpha()
 
 	// Read source metadata for description/attribution/type propagation.
 	var srcDescription, srcAttribution, srcType string
+	var srcName, srcVersion, srcID string
+	var srcZoomOffset int
 	if srcMeta, err := reader.ReadMetadata(); err != nil {
 		if verbose {
 			log.Printf("Warning: could not read source metadata: %v", err)
@@ -156,15 +258,41 @@ func main() {
 		if v, ok := srcMeta["type"].(string); ok {
 			srcType = v
 		}
+		if v, ok := srcMeta["name"].(string); ok {
+			srcName = v
+		}
+		if v, ok := srcMeta["version"].(string); ok {
+			srcVersion = v
+		}
+		if v, ok := srcMeta["id"].(string); ok {
+			srcID = v
+		}
+		if v, ok := srcMeta["zoom_offset"].(float64); ok {
+			srcZoomOffset = int(v)
+		}
 	}
 
-	// Carry forward source attribution and type when not explicitly overridden.
+	// Carry forward source attribution, type, name, version, and layer ID
+	// when not explicitly overridden. The tool defaults ("geotiff2pmtiles")
+	// are not a user-chosen name, so they become "pmtransform" as before.
 	if attribution == "" {
 		attribution = srcAttribution
 	}
 	if layerType == "" {
 		layerType = srcType
 	}
+	if tilesetName == "" {
+		tilesetName = srcName
+	}
+	if tilesetName == "" || tilesetName == "geotiff2pmtiles" {
+		tilesetName = "pmtransform"
+	}
+	if tilesetVersion == "" {
+		tilesetVersion = srcVersion
+	}
+	if layerID == "" {
+		layerID = srcID
+	}
 
 	if verbose {
 		log.Printf("Opened %s: %d tiles, zoom %d-%d, format %s, bounds [%.4f,%.4f,%.4f,%.4f]",
@@ -186,6 +314,16 @@ func main() {
 	if tileSize < 0 {
 		tileSize = discoverSourceTileSize(reader, srcFormat)
 	}
+	premultiplySet := false
+	flag.Visit(func(f *flag.Flag) { premultiplySet = premultiplySet || f.Name == "premultiply-alpha" })
+	if premultiplySet {
+		if premultiply && format != "png" && format != "webp" {
+			log.Fatalf("--premultiply-alpha requires png or webp output, got %q", format)
+		}
+	} else {
+		// Keep the source's alpha, which only PNG and WebP carry.
+		premultiply = srcPremultiplied && (format == "png" || format == "webp")
+	}
 
 	// Resolve resampling method.
 	resamplingMode, err := tile.ParseResampling(resampling)
@@ -210,12 +348,16 @@ func main() {
 	}
 
 	// Determine transform mode.
-	formatChanged := format != srcFormat
+	formatChanged := format != srcFormat || premultiply != srcPremultiplied
 	zoomChanged := minZoom < int(srcHeader.MinZoom) // adding lower zoom levels
 	mode := tile.TransformPassthrough
 
-	if rebuild || zoomChanged {
+	if rebuild || (zoomChanged && (formatChanged || fc != nil)) {
 		mode = tile.TransformRebuild
+	} else if zoomChanged {
+		// Only lower zooms are added: copy the existing levels verbatim
+		// and downsample the new ones from the source min zoom.
+		mode = tile.TransformExtend
 	} else if formatChanged {
 		mode = tile.TransformReencode
 	} else if fc != nil {
@@ -223,6 +365,16 @@ func main() {
 		mode = tile.TransformReencode
 	}
 
+	// Rebuild downsamples 2×2 children into one parent, which needs an even tile size.
+	if mode == tile.TransformRebuild || mode == tile.TransformExtend {
+		if err := tile.ValidateTileSize(tileSize); err != nil {
+			log.Fatalf("Tile size: %v", err)
+		}
+		if !tile.IsPowerOfTwo(tileSize) {
+			log.Printf("WARNING: tile size %d is not a power of two; most map clients expect 256 or 512", tileSize)
+		}
+	}
+
 	// Compute memory limit.
 	var memoryLimitBytes int64
 	if noSpill {
@@ -240,6 +392,15 @@ func main() {
 		modeStr = "re-encode"
 	case tile.TransformRebuild:
 		modeStr = "rebuild pyramid"
+	case tile.TransformExtend:
+		modeStr = fmt.Sprintf("extend (copy z%d+, downsample below)", srcHeader.MinZoom)
+	}
+
+	// Temporary files: spill files on --temp-dir, the writer's temp file
+	// where Finalize can copy it cheaply into the archive.
+	dirs := scratch.Plan(outputPath, tempDir, writerTempDir)
+	if dirs.Warning != "" {
+		log.Printf("WARNING: %s", dirs.Warning)
 	}
 
 	fmt.Printf("pmtransform %s (commit %s, built %s)\n", version, commit, buildDate)
@@ -251,29 +412,49 @@ func main() {
 	fmt.Printf("  %-14s %dpx\n", "Tile size:", tileSize)
 	fmt.Printf("  %-14s %d – %d (source: %d – %d)\n", "Zoom:",
 		minZoom, maxZoom, srcHeader.MinZoom, srcHeader.MaxZoom)
-	if mode == tile.TransformRebuild {
+	if mode == tile.TransformRebuild || mode == tile.TransformExtend {
 		if resamplingGamma != 1.0 {
 			fmt.Printf("  %-14s %s (gamma %.2g)\n", "Resampling:", resampling, resamplingGamma)
 		} else {
 			fmt.Printf("  %-14s %s\n", "Resampling:", resampling)
 		}
 	}
+	if dither && mode != tile.TransformPassthrough {
+		fmt.Printf("  %-14s ordered (16-bit source tiles)\n", "Dither:")
+	}
+	if premultiply != srcPremultiplied {
+		fmt.Printf("  %-14s %s → %s\n", "Alpha:", alphaName(srcPremultiplied), alphaName(premultiply))
+	} else if premultiply {
+		fmt.Printf("  %-14s premultiplied\n", "Alpha:")
+	}
 	fmt.Printf("  %-14s %d\n", "Concurrency:", concurrency)
+	if tileFilter != "" {
+		fmt.Printf("  %-14s %s\n", "Tile filter:", tileFilter)
+	}
 	if fc != nil {
 		fmt.Printf("  %-14s rgba(%d,%d,%d,%d)\n", "Fill color:", fc.R, fc.G, fc.B, fc.A)
 	}
 	if noSpill {
 		fmt.Printf("  %-14s disabled (all in memory)\n", "Disk spill:")
 	} else if memLimitMB > 0 {
-		fmt.Printf("  %-14s %d MB\n", "Mem limit:", memLimitMB)
-	} else if mode == tile.TransformRebuild {
+		fmt.Printf("  %-14s %s\n", "Mem limit:", units.Size(int64(memLimitMB)<<20))
+	} else if mode == tile.TransformRebuild || mode == tile.TransformExtend {
 		fmt.Printf("  %-14s auto (~90%% of RAM)\n", "Mem limit:")
 	}
+	if streaming {
+		fmt.Printf("  %-14s tile data written into %s%s, finalize writes directories only\n", "Streaming:", outputPath, pmtiles.PartialSuffix)
+	}
+	if tempDir != "" || writerTempDir != "" {
+		if dirs.Colocated {
+			fmt.Printf("  %-14s spill %s, writer %s (temp dir is on another file system)\n", "Temp dirs:", dirs.Spill, dirs.Writer)
+		} else {
+			fmt.Printf("  %-14s spill %s, writer %s\n", "Temp dirs:", dirs.Spill, dirs.Writer)
+		}
+	}
 	fmt.Printf("  %-14s %s (%d tiles)\n", "Input:", inputPath, reader.NumTiles())
 	fmt.Printf("  %-14s %s\n", "Output:", outputPath)
 
 	// Build config.
-	outputDir := filepath.Dir(outputPath)
 	cfg := tile.TransformConfig{
 		MinZoom:          minZoom,
 		MaxZoom:          maxZoom,
@@ -288,75 +469,310 @@ func main() {
 		FillColor:        fc,
 		Bounds:           bounds,
 		MemoryLimitBytes: memoryLimitBytes,
-		OutputDir:        outputDir,
+		OutputDir:        dirs.Spill,
+		Dither:           dither,
+
+		SourcePremultiplied: srcPremultiplied,
+		PremultiplyAlpha:    premultiply,
 	}
 
 	// Build description with processing steps prepended to source description.
 	description := buildTransformDescription(srcDescription, srcHeader, mode, srcFormat, format, quality,
 		tileSize, minZoom, maxZoom, resampling, resamplingGamma, fc)
 
-	// Create PMTiles writer.
-	writer, err := pmtiles.NewWriter(outputPath, pmtiles.WriterOptions{
-		MinZoom:     minZoom,
-		MaxZoom:     maxZoom,
-		Bounds:      cog.Bounds{MinLon: float64(bounds[0]), MinLat: float64(bounds[1]), MaxLon: float64(bounds[2]), MaxLat: float64(bounds[3])},
-		TileFormat:  enc.PMTileType(),
-		TileSize:    tileSize,
-		TempDir:     outputDir,
-		Name:        "pmtransform",
-		Description: description,
-		Attribution: attribution,
-		Type:        layerType,
-	})
+	// Create the writer.
+	writerOpts := pmtiles.WriterOptions{
+		MinZoom:      minZoom,
+		MaxZoom:      maxZoom,
+		Bounds:       cog.Bounds{MinLon: float64(bounds[0]), MinLat: float64(bounds[1]), MaxLon: float64(bounds[2]), MaxLat: float64(bounds[3])},
+		TileFormat:   enc.PMTileType(),
+		TileSize:     tileSize,
+		TempDir:      dirs.Writer,
+		Name:         tilesetName,
+		Version:      tilesetVersion,
+		LayerID:      layerID,
+		Description:  description,
+		Attribution:  attribution,
+		Type:         layerType,
+		Sync:         fsync,
+		SyncDir:      fsync,
+		StableLayout: stableLayout,
+		Streaming:    streaming,
+		GeneratedAt:  pmtiles.GenerationTime(),
+
+		EstimatedTiles: int64(reader.NumTiles()),
+		ZoomOffset:     srcZoomOffset,
+
+		PremultipliedAlpha: premultiply,
+	}
+	var writer tilesetWriter
+	if mbtilesOutput {
+		writer, err = mbtiles.NewWriter(outputPath, writerOpts)
+	} else {
+		writer, err = pmtiles.NewWriter(outputPath, writerOpts)
+	}
 	if err != nil {
-		log.Fatalf("Creating PMTiles writer: %v", err)
+		log.Fatalf("Creating writer: %v", err)
 	}
 
 	// Run transform.
+	var out tile.TileWriter = writer
+	if tileFilter != "" {
+		out = tile.NewFilterWriter(writer, tileFilter, format)
+	}
 	genStart := time.Now()
-	stats, err := tile.Transform(cfg, reader, writer)
+	stats, err := tile.Transform(cfg, reader, out)
 	if err != nil {
 		writer.Abort()
 		log.Fatalf("Transform: %v", err)
 	}
 
 	if verbose {
-		log.Printf("Processed %d tiles (%d uniform, %d empty) in %v",
+		log.Printf("Processed %d tiles (%d uniform, %d empty) in %s",
 			stats.TileCount, stats.UniformTiles, stats.EmptyTiles,
-			time.Since(genStart).Round(time.Millisecond))
+			units.Duration(time.Since(genStart)))
 	}
 
-	// Finalize PMTiles file.
+	// Finalize the output file.
 	if err := writer.Finalize(); err != nil {
-		log.Fatalf("Finalizing PMTiles: %v", err)
+		log.Fatalf("Finalizing %s: %v", outputPath, err)
+	}
+	if n := writer.DuplicateTiles(); n > 0 {
+		log.Printf("WARNING: %d tile(s) were written more than once; kept the last write", n)
 	}
 
-	elapsed := time.Since(start).Round(time.Millisecond)
+	elapsed := units.Duration(time.Since(start))
 	fi, _ := os.Stat(outputPath)
-	fmt.Printf("Done: %d tiles, %s, %v → %s\n", stats.TileCount, humanSize(fi.Size()), elapsed, outputPath)
+	fmt.Printf("Done: %d tiles, %s, %s → %s\n", stats.TileCount, units.Size(fi.Size()), elapsed, outputPath)
 }
 
-// discoverSourceTileSize reads and decodes one tile to infer the source tile size.
-// PMTiles v3 header does not store tile size, so we must decode to discover it.
+// mergeFlags are the flags that apply to --merge. The others change tiles,
+// which merging copies as they are except where archives overlap.
+var mergeFlags = map[string]bool{
+	"merge": true, "verbose": true, "cpuprofile": true, "memprofile": true,
+	"temp-dir": true, "writer-temp-dir": true, "fsync": true, "stable-layout": true, "streaming": true,
+	"name": true, "tileset-version": true, "layer-id": true, "attribution": true, "type": true,
+	"quality": true, "resampling": true,
+}
+
+// runMerge merges the archives inputs into output (--merge). Raster tiles
+// in several inputs are merged by tile.MergeArchives, encoded at quality
+// and downsampled with resampling; other tiles are taken from the last
+// input that has them. The header covers the union of their zooms and
+// bounds; the metadata is the first archive's, with the fields set in opts
+// overriding it.
+func runMerge(inputs []string, output string, opts pmtiles.WriterOptions, quality int, resampling tile.Resampling, tempDir, writerTempDir string, verbose bool) {
+	if !strings.HasSuffix(output, ".pmtiles") {
+		log.Fatal("--merge: output file must have .pmtiles extension")
+	}
+	start := time.Now()
+	readers := make([]*pmtiles.Reader, len(inputs))
+	var numTiles int64
+	for i, path := range inputs {
+		if path == output {
+			log.Fatal("Input and output paths must be different")
+		}
+		r, err := pmtiles.OpenReader(path)
+		if err != nil {
+			log.Fatalf("Opening input: %v", err)
+		}
+		defer r.Close()
+		readers[i] = r
+		numTiles += int64(r.NumTiles())
+		if r.PremultipliedAlpha() != readers[0].PremultipliedAlpha() {
+			log.Fatalf("%s: %s alpha, want %s as in %s", path, alphaName(r.PremultipliedAlpha()), alphaName(readers[0].PremultipliedAlpha()), inputs[0])
+		}
+
+		h := r.Header()
+		b := cog.Bounds{MinLon: float64(h.MinLon), MinLat: float64(h.MinLat), MaxLon: float64(h.MaxLon), MaxLat: float64(h.MaxLat)}
+		if i == 0 {
+			opts.MinZoom, opts.MaxZoom, opts.Bounds = int(h.MinZoom), int(h.MaxZoom), b
+			continue
+		}
+		opts.MinZoom = min(opts.MinZoom, int(h.MinZoom))
+		opts.MaxZoom = max(opts.MaxZoom, int(h.MaxZoom))
+		opts.Bounds = cog.Bounds{
+			MinLon: min(opts.Bounds.MinLon, b.MinLon),
+			MinLat: min(opts.Bounds.MinLat, b.MinLat),
+			MaxLon: max(opts.Bounds.MaxLon, b.MaxLon),
+			MaxLat: max(opts.Bounds.MaxLat, b.MaxLat),
+		}
+	}
+
+	first := readers[0].Header()
+	format := pmtiles.TileTypeString(first.TileType)
+	opts.TileFormat = first.TileType
+	opts.TileCompression = first.TileCompression
+	opts.TileSize = discoverSourceTileSize(readers[0], format)
+	opts.EstimatedTiles = numTiles
+	opts.GeneratedAt = pmtiles.GenerationTime()
+	opts.PremultipliedAlpha = readers[0].PremultipliedAlpha()
+
+	// Keep the first archive's metadata: source provenance, vector layers,
+	// and the like are the same for every shard of a tileset.
+	meta, err := readers[0].ReadMetadata()
+	if err != nil && verbose {
+		log.Printf("Warning: could not read source metadata: %v", err)
+	}
+	str := func(key string) string {
+		s, _ := meta[key].(string)
+		return s
+	}
+	for key, v := range map[string]*string{"name": &opts.Name, "version": &opts.Version, "id": &opts.LayerID, "attribution": &opts.Attribution, "type": &opts.Type} {
+		if *v == "" {
+			*v = str(key)
+		}
+	}
+	if v, ok := meta["zoom_offset"].(float64); ok {
+		opts.ZoomOffset = int(v)
+	}
+	opts.Description = fmt.Sprintf("Processing: pmtransform %s\n  Mode: merge of %d archives\n", version, len(inputs))
+	if d := str("description"); d != "" {
+		opts.Description += "\n" + d
+	}
+	opts.Extra = make(map[string]interface{}, len(meta))
+	for k, v := range meta {
+		if k != "shard" {
+			opts.Extra[k] = v
+		}
+	}
+
+	dirs := scratch.Plan(output, tempDir, writerTempDir)
+	if dirs.Warning != "" {
+		log.Printf("WARNING: %s", dirs.Warning)
+	}
+	opts.TempDir = dirs.Writer
+
+	fmt.Printf("pmtransform %s (commit %s, built %s)\n", version, commit, buildDate)
+	fmt.Printf("  %-14s merge\n", "Mode:")
+	fmt.Printf("  %-14s %s\n", "Format:", format)
+	fmt.Printf("  %-14s %d – %d\n", "Zoom:", opts.MinZoom, opts.MaxZoom)
+	if opts.Streaming {
+		fmt.Printf("  %-14s tile data written into %s%s, finalize writes directories only\n", "Streaming:", output, pmtiles.PartialSuffix)
+	}
+	fmt.Printf("  %-14s %d archives (%d tiles)\n", "Input:", len(inputs), numTiles)
+	fmt.Printf("  %-14s %s\n", "Output:", output)
+
+	writer, err := pmtiles.NewWriter(output, opts)
+	if err != nil {
+		log.Fatalf("Creating PMTiles writer: %v", err)
+	}
+	var n int64
+	if format == "mvt" || format == "unknown" {
+		// Tiles that cannot be decoded cannot be combined either.
+		n, err = pmtiles.Merge(writer, readers)
+		if err == nil && writer.DuplicateTiles() > 0 {
+			log.Printf("WARNING: %d %s tile(s) are in more than one input; kept the one of the later input", writer.DuplicateTiles(), format)
+			n -= writer.DuplicateTiles()
+		}
+	} else {
+		var enc encode.Encoder
+		if enc, err = encode.NewEncoder(format, quality); err != nil {
+			writer.Abort()
+			log.Fatalf("Encoder: %v", err)
+		}
+		archives := make([]tile.PMTilesReader, len(readers))
+		for i, r := range readers {
+			archives[i] = r
+		}
+		var stats tile.MergeStats
+		stats, err = tile.MergeArchives(tile.MergeConfig{
+			TileSize:      opts.TileSize,
+			Encoder:       enc,
+			SourceFormat:  format,
+			Resampling:    resampling,
+			Verbose:       verbose,
+			Premultiplied: opts.PremultipliedAlpha,
+		}, archives, writer)
+		n = stats.Copied + stats.Overlaps()
+		if err == nil && stats.Overlaps() > 0 {
+			log.Printf("Overlaps: %d tile(s) in more than one input: %d drawn over each other, %d downsampled again from the merged tiles",
+				stats.Overlaps(), stats.Composited, stats.Rebuilt)
+		}
+	}
+	if err != nil {
+		writer.Abort()
+		log.Fatalf("Merge: %v", err)
+	}
+	if err := writer.Finalize(); err != nil {
+		log.Fatalf("Finalizing PMTiles: %v", err)
+	}
+
+	elapsed := units.Duration(time.Since(start))
+	fi, _ := os.Stat(output)
+	fmt.Printf("Done: %d tiles, %s, %s → %s\n", n, units.Size(fi.Size()), elapsed, output)
+}
+
+// alphaName describes tiles with premultiplied or straight alpha.
+func alphaName(premultiplied bool) string {
+	if premultiplied {
+		return "premultiplied"
+	}
+	return "straight"
+}
+
+// tileset is an input tileset: a PMTiles archive or an MBTiles file.
+type tileset interface {
+	tile.PMTilesReader
+	ReadMetadata() (map[string]interface{}, error)
+	NumTiles() int
+	TileSize() int
+	PremultipliedAlpha() bool
+	Close() error
+}
+
+// tilesetWriter writes the output: a pmtiles.Writer or an mbtiles.Writer.
+type tilesetWriter interface {
+	tile.TileWriter
+	Finalize() error
+	Abort()
+	DuplicateTiles() int64
+}
+
+// isTileset reports whether path names a tileset format pmtransform reads
+// and writes.
+func isTileset(path string) bool {
+	return strings.HasSuffix(path, ".pmtiles") || strings.HasSuffix(path, ".mbtiles")
+}
+
+// openTileset opens path as an MBTiles file if it has the .mbtiles
+// extension, and as a PMTiles archive otherwise.
+func openTileset(path string) (tileset, error) {
+	if strings.HasSuffix(path, ".mbtiles") {
+		return mbtiles.OpenReader(path)
+	}
+	return pmtiles.OpenReader(path)
+}
+
+// errFound stops a tile walk once the answer is known.
+var errFound = errors.New("found")
+
+// discoverSourceTileSize returns the source tile size. The PMTiles v3 header
+// does not store it; tilesets written by this tool record it in their
+// metadata, and for other tilesets one tile is decoded to discover it.
 // Returns 256 if no tile could be decoded (e.g. all empty).
-func discoverSourceTileSize(reader *pmtiles.Reader, format string) int {
-	tiles := reader.TilesAtZoom(int(reader.Header().MaxZoom))
-	for _, t := range tiles {
-		z, x, y := t[0], t[1], t[2]
+func discoverSourceTileSize(reader tileset, format string) int {
+	if size := reader.TileSize(); size > 0 {
+		return size
+	}
+	size := 256
+	z := int(reader.Header().MaxZoom)
+	reader.ForEachTileAtZoom(z, func(x, y int) error {
 		data, err := reader.ReadTile(z, x, y)
 		if err != nil || data == nil {
-			continue
+			return nil
 		}
 		img, err := encode.DecodeImage(data, format)
 		if err != nil {
-			continue
+			return nil
 		}
-		b := img.Bounds()
-		if b.Dx() > 0 && b.Dy() > 0 {
-			return b.Dx()
+		if b := img.Bounds(); b.Dx() > 0 && b.Dy() > 0 {
+			size = b.Dx()
+			return errFound
 		}
-	}
-	return 256
+		return nil
+	})
+	return size
 }
 
 // parseColor parses an RGBA color from "R,G,B,A" or "#RRGGBBAA" format.
@@ -426,6 +842,8 @@ func buildTransformDescription(srcDescription string, srcHeader pmtiles.Header,
 		modeStr = "re-encode"
 	case tile.TransformRebuild:
 		modeStr = "rebuild"
+	case tile.TransformExtend:
+		modeStr = "extend"
 	}
 	b.WriteString(fmt.Sprintf("  Mode: %s\n", modeStr))
 
@@ -448,7 +866,7 @@ func buildTransformDescription(srcDescription string, srcHeader pmtiles.Header,
 		b.WriteString(fmt.Sprintf("  Zoom: %d - %d\n", minZoom, maxZoom))
 	}
 
-	if mode == tile.TransformRebuild {
+	if mode == tile.TransformRebuild || mode == tile.TransformExtend {
 		if resamplingGamma != 1.0 {
 			b.WriteString(fmt.Sprintf("  Resampling: %s (gamma %.2g)\n", resampling, resamplingGamma))
 		} else {
@@ -467,21 +885,3 @@ func buildTransformDescription(srcDescription string, srcHeader pmtiles.Header,
 
 	return b.String()
 }
-
-func humanSize(bytes int64) string {
-	const (
-		KB = 1024
-		MB = KB * 1024
-		GB = MB * 1024
-	)
-	switch {
-	case bytes >= GB:
-		return fmt.Sprintf("%.1f GB", float64(bytes)/float64(GB))
-	case bytes >= MB:
-		return fmt.Sprintf("%.1f MB", float64(bytes)/float64(MB))
-	case bytes >= KB:
-		return fmt.Sprintf("%.1f KB", float64(bytes)/float64(KB))
-	default:
-		return fmt.Sprintf("%d B", bytes)
-	}
-}
@@ -1,21 +1,32 @@
 package integration_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync/atomic"
 	"testing"
 
+	"github.com/pspoerri/geotiff2pmtiles/internal/checkpoint"
 	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
 	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
 	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+	"github.com/pspoerri/geotiff2pmtiles/internal/mbtiles"
 	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
 	"github.com/pspoerri/geotiff2pmtiles/internal/tile"
+	"github.com/pspoerri/geotiff2pmtiles/internal/vector"
+	"github.com/pspoerri/geotiff2pmtiles/tileorder"
 )
 
 const testdataDir = "testdata"
@@ -29,15 +40,36 @@ type tiffWriterConfig struct {
 	Width, Height     int
 	TileWidth, TileHt int // defaults to 256 if zero
 	SamplesPerPixel   int // 1=gray, 3=RGB, 4=RGBA
-	BitsPerSample     int // 8 or 16
+	BitsPerSample     int // 8 or 16, or 1 for a single bilevel band (PixelFunc != 0 sets the bit)
 	OriginLon         float64
 	OriginLat         float64
 	PixelSizeDeg      float64 // degrees per pixel (WGS84)
-	EPSG              int     // 4326 or 3857
-	NoData            string  // e.g. "0" or ""
-	GDALMetadataXML   string  // tag 42112 (optional)
+	EPSG              int     // 4326, 3857, or another projected code of coord.ForEPSG
+	// WKT, with EPSG coord.UserDefined, is the CRS definition written to
+	// GeoAsciiParams as the PCS citation, as GDAL does for custom CRSs.
+	WKT             string
+	NoData          string // e.g. "0" or ""
+	GDALMetadataXML string // tag 42112 (optional)
+	// Orientation writes TIFF tag 274 (2-4) and stores the raster mirrored
+	// accordingly, so the displayed image still matches PixelFunc.
+	Orientation int
+	// Signed writes SampleFormat 2 (signed integers); PixelFunc then
+	// returns the bits of each sample, e.g. uint16(int16(v)).
+	Signed bool
 	// PixelFunc returns the sample value for pixel (x, y) and band index (0-based).
 	PixelFunc func(x, y, band int) uint16
+	// FloatFunc, when set, writes a single-band float32 raster (e.g. a DEM)
+	// with this value per pixel instead of PixelFunc's integer samples.
+	FloatFunc func(x, y int) float32
+	// MaskFunc, when set, adds a 1-bit transparency mask IFD (NewSubfileType
+	// 4) as GDAL writes for internal masks: true marks valid pixels.
+	MaskFunc func(x, y int) bool
+	// ZSTD stores each tile as a Zstandard frame (compression 50000) of
+	// raw blocks.
+	ZSTD bool
+	// Planar stores the bands of each tile as separate tiles
+	// (PlanarConfiguration 2) rather than interleaved.
+	Planar bool
 }
 
 var tiffSeq atomic.Int64
@@ -65,6 +97,10 @@ func writeSyntheticGeoTIFF(t *testing.T, cfg tiffWriterConfig) string {
 	if cfg.PixelFunc == nil {
 		cfg.PixelFunc = func(x, y, band int) uint16 { return 128 }
 	}
+	if cfg.FloatFunc != nil {
+		cfg.SamplesPerPixel = 1
+		cfg.BitsPerSample = 32
+	}
 
 	seq := tiffSeq.Add(1)
 	path := filepath.Join(t.TempDir(), fmt.Sprintf("synthetic_%d.tif", seq))
@@ -75,6 +111,24 @@ func writeSyntheticGeoTIFF(t *testing.T, cfg tiffWriterConfig) string {
 	tilesDown := (cfg.Height + cfg.TileHt - 1) / cfg.TileHt
 	numTiles := tilesAcross * tilesDown
 	tileBytes := cfg.TileWidth * cfg.TileHt * cfg.SamplesPerPixel * bytesPerSample
+	if cfg.BitsPerSample == 1 {
+		tileBytes = (cfg.TileWidth + 7) / 8 * cfg.TileHt
+	}
+	rawTileBytes := tileBytes
+	if cfg.ZSTD {
+		tileBytes = len(zstdRawFrame(make([]byte, rawTileBytes)))
+	}
+	// Band-separate tiles: each tile's bands are stored one after the
+	// other, and each is a tile of its own in TileOffsets, band-major.
+	planes := 1
+	if cfg.Planar {
+		if cfg.ZSTD || cfg.BitsPerSample == 1 {
+			t.Fatal("Planar is written uncompressed with 8 or 16-bit samples only")
+		}
+		planes = cfg.SamplesPerPixel
+	}
+	planeBytes := tileBytes / planes
+	numTileEntries := numTiles * planes
 
 	// ---- Collect IFD entries ----
 	type ifdEntry struct {
@@ -116,8 +170,12 @@ func writeSyntheticGeoTIFF(t *testing.T, cfg tiffWriterConfig) string {
 		}
 	}
 
-	// 259 Compression = 1 (None)
-	add(259, 3, 1, 1)
+	// 259 Compression = 1 (None) or 50000 (ZSTD)
+	if cfg.ZSTD {
+		add(259, 3, 1, 50000)
+	} else {
+		add(259, 3, 1, 1)
+	}
 
 	// 262 Photometric (1=MinIsBlack for gray, 2=RGB)
 	if cfg.SamplesPerPixel == 1 {
@@ -126,32 +184,51 @@ func writeSyntheticGeoTIFF(t *testing.T, cfg tiffWriterConfig) string {
 		add(262, 3, 1, 2)
 	}
 
+	// 274 Orientation (optional)
+	flipX := cfg.Orientation == 2 || cfg.Orientation == 3
+	flipY := cfg.Orientation == 3 || cfg.Orientation == 4
+	if cfg.Orientation != 0 {
+		add(274, 3, 1, uint32(cfg.Orientation))
+	}
+
 	// 277 SamplesPerPixel
 	add(277, 3, 1, uint32(cfg.SamplesPerPixel))
 
+	// 284 PlanarConfiguration (optional; 1, contiguous, is the default)
+	if cfg.Planar {
+		add(284, 3, 1, 2)
+	}
+
 	// 322 TileWidth
 	add(322, 3, 1, uint32(cfg.TileWidth))
 	// 323 TileLength
 	add(323, 3, 1, uint32(cfg.TileHt))
 
 	// 324 TileOffsets (filled later)
-	tileOffsetsData := make([]byte, 4*numTiles)
+	tileOffsetsData := make([]byte, 4*numTileEntries)
 	tileOffsetsIdx := len(entries)
-	if numTiles == 1 {
+	if numTileEntries == 1 {
 		add(324, 4, 1, 0) // placeholder, filled after layout
 	} else {
-		addExtern(324, 4, uint32(numTiles), tileOffsetsData)
+		addExtern(324, 4, uint32(numTileEntries), tileOffsetsData)
 	}
 
 	// 325 TileByteCounts
-	tileByteCountsData := make([]byte, 4*numTiles)
-	for i := 0; i < numTiles; i++ {
-		bo.PutUint32(tileByteCountsData[i*4:], uint32(tileBytes))
+	tileByteCountsData := make([]byte, 4*numTileEntries)
+	for i := 0; i < numTileEntries; i++ {
+		bo.PutUint32(tileByteCountsData[i*4:], uint32(planeBytes))
 	}
-	if numTiles == 1 {
+	if numTileEntries == 1 {
 		add(325, 4, 1, uint32(tileBytes))
 	} else {
-		addExtern(325, 4, uint32(numTiles), tileByteCountsData)
+		addExtern(325, 4, uint32(numTileEntries), tileByteCountsData)
+	}
+
+	// 339 SampleFormat = 3 (IEEE float) or 2 (signed integer)
+	if cfg.FloatFunc != nil {
+		add(339, 3, 1, 3)
+	} else if cfg.Signed {
+		add(339, 3, 1, 2)
 	}
 
 	// 33550 ModelPixelScale: [scaleX, scaleY, 0]
@@ -193,8 +270,23 @@ func writeSyntheticGeoTIFF(t *testing.T, cfg tiffWriterConfig) string {
 				1024, 0, 1, 1, // ModelTypeGeoKey = Projected
 				3072, 0, 1, 3857, // ProjectedCSTypeGeoKey
 			}
+		case coord.UserDefined:
+			geoKeys = []uint16{
+				1, 1, 0, 3,
+				1024, 0, 1, 1, // ModelTypeGeoKey = Projected
+				3072, 0, 1, coord.UserDefined, // ProjectedCSTypeGeoKey
+				3073, 34737, uint16(len(cfg.WKT) + 1), 0, // PCSCitationGeoKey
+			}
 		default:
-			t.Fatalf("unsupported EPSG for synthetic TIFF: %d", cfg.EPSG)
+			// Any other projected CRS of coord.ForEPSG.
+			if coord.ForEPSG(cfg.EPSG) == nil {
+				t.Fatalf("unsupported EPSG for synthetic TIFF: %d", cfg.EPSG)
+			}
+			geoKeys = []uint16{
+				1, 1, 0, 2,
+				1024, 0, 1, 1, // ModelTypeGeoKey = Projected
+				3072, 0, 1, uint16(cfg.EPSG), // ProjectedCSTypeGeoKey
+			}
 		}
 		buf := make([]byte, 2*len(geoKeys))
 		for i, v := range geoKeys {
@@ -203,6 +295,12 @@ func writeSyntheticGeoTIFF(t *testing.T, cfg tiffWriterConfig) string {
 		addExtern(34735, 3, uint32(len(geoKeys)), buf)
 	}
 
+	// 34737 GeoAsciiParams: strings end with "|", the tag with NUL.
+	if cfg.EPSG == coord.UserDefined {
+		data := append([]byte(cfg.WKT+"|"), 0)
+		addExtern(34737, 2, uint32(len(data)), data)
+	}
+
 	// 42112 GDAL_METADATA (optional)
 	if cfg.GDALMetadataXML != "" {
 		data := append([]byte(cfg.GDALMetadataXML), 0) // NUL terminated
@@ -245,11 +343,13 @@ func writeSyntheticGeoTIFF(t *testing.T, cfg tiffWriterConfig) string {
 	tileDataStart := externOffset
 
 	// Fill tile offsets.
-	if numTiles == 1 {
+	if numTileEntries == 1 {
 		entries[tileOffsetsIdx].value = tileDataStart
 	} else {
-		for i := 0; i < numTiles; i++ {
-			bo.PutUint32(tileOffsetsData[i*4:], tileDataStart+uint32(i*tileBytes))
+		for s := 0; s < planes; s++ {
+			for i := 0; i < numTiles; i++ {
+				bo.PutUint32(tileOffsetsData[(s*numTiles+i)*4:], tileDataStart+uint32(i*tileBytes+s*planeBytes))
+			}
 		}
 	}
 
@@ -283,30 +383,131 @@ func writeSyntheticGeoTIFF(t *testing.T, cfg tiffWriterConfig) string {
 	}
 
 	// ---- Write tile pixel data ----
+	pix := buf[tileDataStart:]
+	if cfg.ZSTD {
+		pix = make([]byte, numTiles*rawTileBytes)
+	}
 	for ty := 0; ty < tilesDown; ty++ {
 		for tx := 0; tx < tilesAcross; tx++ {
 			tileIdx := ty*tilesAcross + tx
-			tileOff := int(tileDataStart) + tileIdx*tileBytes
+			tileOff := tileIdx * rawTileBytes
 			for py := 0; py < cfg.TileHt; py++ {
 				for px := 0; px < cfg.TileWidth; px++ {
 					imgX := tx*cfg.TileWidth + px
 					imgY := ty*cfg.TileHt + py
+					if cfg.FloatFunc != nil {
+						var v float32
+						if imgX < cfg.Width && imgY < cfg.Height {
+							v = cfg.FloatFunc(imgX, imgY)
+						}
+						bo.PutUint32(pix[tileOff+(py*cfg.TileWidth+px)*4:], math.Float32bits(v))
+						continue
+					}
 					for band := 0; band < cfg.SamplesPerPixel; band++ {
 						var val uint16
 						if imgX < cfg.Width && imgY < cfg.Height {
-							val = cfg.PixelFunc(imgX, imgY, band)
+							dispX, dispY := imgX, imgY
+							if flipX {
+								dispX = cfg.Width - 1 - imgX
+							}
+							if flipY {
+								dispY = cfg.Height - 1 - imgY
+							}
+							val = cfg.PixelFunc(dispX, dispY, band)
+						}
+						if cfg.BitsPerSample == 1 {
+							if val != 0 {
+								pix[tileOff+py*((cfg.TileWidth+7)/8)+px/8] |= 0x80 >> (px % 8)
+							}
+							continue
 						}
 						pixOff := tileOff + (py*cfg.TileWidth+px)*cfg.SamplesPerPixel*bytesPerSample + band*bytesPerSample
 						if bytesPerSample == 1 {
-							buf[pixOff] = byte(val)
+							pix[pixOff] = byte(val)
 						} else {
-							bo.PutUint16(buf[pixOff:], val)
+							bo.PutUint16(pix[pixOff:], val)
 						}
 					}
 				}
 			}
 		}
 	}
+	if cfg.ZSTD {
+		for i := 0; i < numTiles; i++ {
+			copy(buf[int(tileDataStart)+i*tileBytes:], zstdRawFrame(pix[i*rawTileBytes:(i+1)*rawTileBytes]))
+		}
+	}
+	if cfg.Planar {
+		// Separate the interleaved samples of each tile into its bands.
+		interleaved := make([]byte, tileBytes)
+		for i := 0; i < numTiles; i++ {
+			tile := pix[i*tileBytes : (i+1)*tileBytes]
+			copy(interleaved, tile)
+			for px := 0; px < cfg.TileWidth*cfg.TileHt; px++ {
+				for s := 0; s < planes; s++ {
+					src := (px*planes + s) * bytesPerSample
+					copy(tile[s*planeBytes+px*bytesPerSample:], interleaved[src:src+bytesPerSample])
+				}
+			}
+		}
+	}
+
+	if cfg.MaskFunc != nil {
+		// Chain the mask IFD after the image: IFD, tile offsets, byte
+		// counts, then the tiles.
+		maskOff := uint32(len(buf))
+		bo.PutUint32(buf[off:], maskOff)
+		rowBytes := (cfg.TileWidth + 7) / 8
+		maskTileBytes := rowBytes * cfg.TileHt
+		const maskEntries = 11
+		arrays := maskOff + 2 + maskEntries*12 + 4
+		tiles := arrays + uint32(8*numTiles)
+		mask := make([]byte, int(tiles-maskOff)+numTiles*maskTileBytes)
+
+		m := 2
+		entry := func(tag, dtype uint16, count, value uint32) {
+			bo.PutUint16(mask[m:], tag)
+			bo.PutUint16(mask[m+2:], dtype)
+			bo.PutUint32(mask[m+4:], count)
+			bo.PutUint32(mask[m+8:], value)
+			m += 12
+		}
+		bo.PutUint16(mask, maskEntries)
+		entry(254, 4, 1, 4) // NewSubfileType = transparency mask
+		entry(256, 3, 1, uint32(cfg.Width))
+		entry(257, 3, 1, uint32(cfg.Height))
+		entry(258, 3, 1, 1)
+		entry(259, 3, 1, 1)
+		entry(262, 3, 1, 4) // Photometric = transparency mask
+		entry(277, 3, 1, 1)
+		entry(322, 3, 1, uint32(cfg.TileWidth))
+		entry(323, 3, 1, uint32(cfg.TileHt))
+		if numTiles == 1 {
+			entry(324, 4, 1, tiles)
+			entry(325, 4, 1, uint32(maskTileBytes))
+		} else {
+			entry(324, 4, uint32(numTiles), arrays)
+			entry(325, 4, uint32(numTiles), arrays+uint32(4*numTiles))
+		}
+
+		for i := 0; i < numTiles; i++ {
+			rel := int(arrays-maskOff) + 4*i
+			bo.PutUint32(mask[rel:], tiles+uint32(i*maskTileBytes))
+			bo.PutUint32(mask[rel+4*numTiles:], uint32(maskTileBytes))
+
+			tx, ty := i%tilesAcross, i/tilesAcross
+			tileOff := int(tiles-maskOff) + i*maskTileBytes
+			for py := 0; py < cfg.TileHt; py++ {
+				for px := 0; px < cfg.TileWidth; px++ {
+					imgX, imgY := tx*cfg.TileWidth+px, ty*cfg.TileHt+py
+					if imgX < cfg.Width && imgY < cfg.Height && cfg.MaskFunc(imgX, imgY) {
+						mask[tileOff+py*rowBytes+px/8] |= 0x80 >> (px % 8)
+					}
+				}
+			}
+		}
+		buf = append(buf, mask...)
+	}
 
 	if err := os.WriteFile(path, buf, 0644); err != nil {
 		t.Fatalf("writing synthetic GeoTIFF: %v", err)
@@ -318,6 +519,28 @@ func putFloat64(buf []byte, bo binary.ByteOrder, v float64) {
 	bo.PutUint64(buf, math.Float64bits(v))
 }
 
+// zstdRawFrame wraps data in a Zstandard frame of uncompressed (raw)
+// blocks: valid input for any ZSTD decoder, without an encoder.
+func zstdRawFrame(data []byte) []byte {
+	const maxBlock = 128 << 10
+	out := binary.LittleEndian.AppendUint32(nil, 0xFD2FB528)
+	out = append(out, 0xA0) // single segment, 4-byte content size
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(data)))
+	for {
+		n := min(len(data), maxBlock)
+		header := uint32(n) << 3 // block type 0 (raw)
+		if n == len(data) {
+			header |= 1 // last block
+		}
+		out = append(out, byte(header), byte(header>>8), byte(header>>16))
+		out = append(out, data[:n]...)
+		data = data[n:]
+		if len(data) == 0 {
+			return out
+		}
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Pipeline runners
 // ---------------------------------------------------------------------------
@@ -332,9 +555,97 @@ type pipelineConfig struct {
 	TileSize    int
 	Resampling  string
 	FillColor   *color.RGBA
+	Background  *color.RGBA
 	BandCfg     cog.BandConfig
 	MemLimitMB  int
 	Concurrency int
+	// SpillFormat selects what the tile stores keep (--spill-format).
+	SpillFormat string
+	// TileOrder is the curve workers take tiles along (--tile-order).
+	TileOrder tileorder.Curve
+	// ZoomEncoders optionally overrides the encoder per zoom level.
+	ZoomEncoders map[int]encode.Encoder
+	// LevelByLevel disables zoom-level pipelining (tile.Config.LevelByLevel).
+	LevelByLevel bool
+	// InputOrder disables per-tile resolution ranking (tile.Config.InputOrder).
+	InputOrder bool
+	// TileFilter pipes each tile through this shell command (--tile-filter).
+	TileFilter string
+	// Previous, with Regions, makes the run incremental as --incremental
+	// does: only tiles in Regions are generated, the rest are copied from
+	// the archive at Previous.
+	Previous string
+	Regions  []cog.Bounds
+	// Dirty lists tiles to regenerate besides Regions, marked in a
+	// tile.DirtySet with their ancestors.
+	Dirty [][3]int
+	// Sharpen restores averaged-away contrast in these zooms (--sharpen).
+	Sharpen []tile.SharpenRange
+	// ZoomOffset relabels the stored zooms as --zoom-offset does.
+	ZoomOffset int
+	// Overlay generates an overlay layer as --type overlay does.
+	Overlay bool
+	// ContourIntervals sets the per-zoom intervals of Format "contours"
+	// (--contour-interval); nil uses the defaults.
+	ContourIntervals []vector.IntervalRange
+	// Hillshade renders the float inputs as shaded relief, encoded as
+	// Format (--format hillshade).
+	Hillshade *tile.Hillshade
+	// ColorMap colors the single-band inputs' values (--color-map).
+	ColorMap *tile.ColorMap
+	// Checkpoint saves a checkpoint into this directory after every zoom
+	// level and continues from the one there, as --resume does.
+	// InterruptZoom > 0 fails the run at its first tile of that zoom, as a
+	// crash would; runPipeline then returns "".
+	Checkpoint    string
+	InterruptZoom int
+	// EncodeCacheMB reuses encodings of repeated tiles (--encode-cache).
+	EncodeCacheMB int
+	// MaxTileBytes re-encodes larger tiles at lower quality (--max-tile-bytes).
+	MaxTileBytes int
+	// Backfill names PMTiles archives sampled where the inputs have no
+	// data, as .pmtiles inputs are.
+	Backfill []string
+	// NaNReport keeps the tiles with the most NaN fallbacks (--nan-report).
+	NaNReport int
+	// Stats, when set, receives the stats of the generation.
+	Stats *tile.Stats
+	// Bounds, when set, replaces the sources' bounds, as the bounds of a
+	// --shard grid cell do.
+	Bounds *cog.Bounds
+	// NoData maps input paths to the nodata spec a --manifest entry would
+	// give them (cog.ParseNoDataSpec syntax).
+	NoData map[string]string
+	// MBTiles writes an MBTiles file, as --output-format mbtiles does.
+	MBTiles bool
+	// PremultiplyAlpha stores premultiplied colors (--premultiply-alpha).
+	PremultiplyAlpha bool
+	// Streaming writes the tile data straight into the archive, as
+	// --streaming does.
+	Streaming bool
+}
+
+// errInterrupted is the failure of a run interrupted by InterruptZoom.
+var errInterrupted = errors.New("interrupted")
+
+// interruptWriter fails the first write to zoom.
+type interruptWriter struct {
+	*pmtiles.Writer
+	zoom int
+}
+
+func (w interruptWriter) WriteTile(z, x, y int, data []byte) error {
+	if z == w.zoom {
+		return errInterrupted
+	}
+	return w.Writer.WriteTile(z, x, y, data)
+}
+
+func (w interruptWriter) WriteTileLocated(z, x, y int, data []byte) (int64, error) {
+	if z == w.zoom {
+		return -1, errInterrupted
+	}
+	return w.Writer.WriteTileLocated(z, x, y, data)
 }
 
 // runPipeline executes the full GeoTIFF→PMTiles pipeline and returns the output path.
@@ -358,7 +669,16 @@ func runPipeline(t *testing.T, cfg pipelineConfig) string {
 	}
 
 	outputPath := filepath.Join(t.TempDir(), "output.pmtiles")
+	if cfg.MBTiles {
+		outputPath = filepath.Join(t.TempDir(), "output.mbtiles")
+	}
 
+	// Contours are generated as Terrarium and traced on the way out, as
+	// --format contours does.
+	contours := cfg.Format == "contours"
+	if contours {
+		cfg.Format = "terrarium"
+	}
 	enc, err := encode.NewEncoder(cfg.Format, cfg.Quality)
 	if err != nil {
 		t.Fatalf("NewEncoder(%q): %v", cfg.Format, err)
@@ -381,9 +701,34 @@ func runPipeline(t *testing.T, cfg pipelineConfig) string {
 
 	for _, src := range sources {
 		src.SetBandConfig(cfg.BandCfg)
+		if nd, ok := cfg.NoData[src.Path()]; ok {
+			spec, err := cog.ParseNoDataSpec(nd)
+			if err != nil {
+				t.Fatalf("nodata %q: %v", nd, err)
+			}
+			src.SetFloatNoData(spec)
+			src.SetIntegerNoData(spec)
+		}
+	}
+
+	var backfill []tile.RasterSource
+	for i, p := range cfg.Backfill {
+		r, err := pmtiles.OpenReader(p)
+		if err != nil {
+			t.Fatalf("pmtiles.OpenReader: %v", err)
+		}
+		defer r.Close()
+		src, err := tile.NewPMTilesSource(r, len(sources)+i, r.TileSize())
+		if err != nil {
+			t.Fatalf("tile.NewPMTilesSource: %v", err)
+		}
+		backfill = append(backfill, src)
 	}
 
 	mergedBounds := cog.MergedBoundsWGS84(sources)
+	if cfg.Bounds != nil {
+		mergedBounds = *cfg.Bounds
+	}
 
 	minZoom := cfg.MinZoom
 	maxZoom := cfg.MaxZoom
@@ -404,6 +749,7 @@ func runPipeline(t *testing.T, cfg pipelineConfig) string {
 	}
 
 	outputDir := filepath.Dir(outputPath)
+	dem, elevation := encode.DEMEncodingFor(cfg.Format)
 	genCfg := tile.Config{
 		MinZoom:          minZoom,
 		MaxZoom:          maxZoom,
@@ -412,40 +758,297 @@ func runPipeline(t *testing.T, cfg pipelineConfig) string {
 		Encoder:          enc,
 		Bounds:           mergedBounds,
 		Resampling:       resamplingMode,
+		IsTerrarium:      elevation,
+		DEMEncoding:      dem,
 		FillColor:        cfg.FillColor,
+		Background:       cfg.Background,
 		MemoryLimitBytes: memoryLimitBytes,
 		OutputDir:        outputDir,
-	}
-
-	writer, err := pmtiles.NewWriter(outputPath, pmtiles.WriterOptions{
-		MinZoom:    minZoom,
-		MaxZoom:    maxZoom,
+		SpillFormat:      cfg.SpillFormat,
+		TileOrder:        cfg.TileOrder,
+		ZoomEncoders:     cfg.ZoomEncoders,
+		LevelByLevel:     cfg.LevelByLevel,
+		InputOrder:       cfg.InputOrder,
+		Sharpen:          cfg.Sharpen,
+		Overlay:          cfg.Overlay,
+		Hillshade:        cfg.Hillshade,
+		ColorMap:         cfg.ColorMap,
+		EncodeCacheBytes: int64(cfg.EncodeCacheMB) * 1024 * 1024,
+		MaxTileBytes:     cfg.MaxTileBytes,
+		NaNReport:        cfg.NaNReport,
+		Backfill:         backfill,
+		PremultiplyAlpha: cfg.PremultiplyAlpha,
+		OrderedWrites:    cfg.Streaming,
+	}
+
+	layerType := "baselayer"
+	if cfg.Overlay {
+		layerType = "overlay"
+	}
+	writerOpts := pmtiles.WriterOptions{
+		MinZoom:    minZoom + cfg.ZoomOffset,
+		MaxZoom:    maxZoom + cfg.ZoomOffset,
 		Bounds:     mergedBounds,
 		TileFormat: enc.PMTileType(),
 		TileSize:   cfg.TileSize,
 		TempDir:    outputDir,
-		Type:       "baselayer",
-	})
-	if err != nil {
-		t.Fatalf("pmtiles.NewWriter: %v", err)
+		Type:       layerType,
+
+		PremultipliedAlpha: cfg.PremultiplyAlpha,
+		Streaming:          cfg.Streaming,
+		EstimatedTiles:     tile.CountTiles(minZoom, maxZoom, mergedBounds),
+	}
+	if contours {
+		writerOpts.TileFormat = pmtiles.TileTypeMVT
+		writerOpts.TileCompression = pmtiles.CompressionGzip
+	}
+	var writer *pmtiles.Writer
+	var archive tilesetWriter
+	if cfg.MBTiles {
+		archive, err = mbtiles.NewWriter(outputPath, writerOpts)
+		if err != nil {
+			t.Fatalf("mbtiles.NewWriter: %v", err)
+		}
+	}
+	if cfg.Checkpoint != "" {
+		state, err := checkpoint.Load(cfg.Checkpoint)
+		if err != nil {
+			t.Fatalf("checkpoint.Load: %v", err)
+		}
+		if state != nil {
+			writer, err = pmtiles.ResumeWriter(outputPath, writerOpts, filepath.Join(cfg.Checkpoint, state.Writer))
+			if err != nil {
+				t.Fatalf("pmtiles.ResumeWriter: %v", err)
+			}
+		}
+		genCfg.Checkpoint = &tile.Checkpoint{Dir: cfg.Checkpoint, Resume: state}
+	}
+	if writer == nil && archive == nil {
+		writer, err = pmtiles.NewWriter(outputPath, writerOpts)
+		if err != nil {
+			t.Fatalf("pmtiles.NewWriter: %v", err)
+		}
+	}
+	if archive == nil {
+		archive = writer
+	}
+	if genCfg.Checkpoint != nil {
+		genCfg.Checkpoint.SaveWriter = writer.Checkpoint
+	}
+
+	var prev *pmtiles.Reader
+	if cfg.Previous != "" {
+		prev, err = pmtiles.OpenReader(cfg.Previous)
+		if err != nil {
+			t.Fatalf("pmtiles.OpenReader: %v", err)
+		}
+		defer prev.Close()
+		genCfg.Regions = cfg.Regions
+		genCfg.Previous = prev
+	}
+	var regen *tile.DirtySet
+	if prev != nil {
+		regen = tile.NewDirtySet(minZoom)
+		regen.MarkRegions(maxZoom, cfg.Regions)
+		if len(cfg.Dirty) > 0 {
+			genCfg.Dirty = tile.NewDirtySet(minZoom)
+			for _, tc := range cfg.Dirty {
+				genCfg.Dirty.Mark(tc[0], tc[1], tc[2])
+			}
+			regen.Merge(genCfg.Dirty)
+		}
 	}
 
-	_, err = tile.Generate(genCfg, sources, writer)
+	var out tile.TileWriter = archive
+	if cfg.InterruptZoom > 0 {
+		out = interruptWriter{writer, cfg.InterruptZoom}
+	}
+	if cfg.TileFilter != "" {
+		out = tile.NewFilterWriter(out, cfg.TileFilter, cfg.Format)
+	}
+	if cfg.ZoomOffset != 0 {
+		out = tile.NewZoomOffsetWriter(out, cfg.ZoomOffset)
+	}
+	if contours {
+		out = vector.NewContourWriter(out, cfg.ContourIntervals)
+	}
+	stats, err := tile.Generate(genCfg, sources, out)
 	if err != nil {
-		writer.Abort()
+		archive.Abort()
+		if cfg.InterruptZoom > 0 && errors.Is(err, errInterrupted) {
+			return ""
+		}
 		t.Fatalf("tile.Generate: %v", err)
 	}
+	if cfg.Stats != nil {
+		*cfg.Stats = stats
+	}
 
-	if err := writer.Finalize(); err != nil {
+	if prev != nil {
+		for z := minZoom; z <= maxZoom; z++ {
+			for _, tc := range prev.TilesAtZoom(z) {
+				if regen.Contains(tc[0], tc[1], tc[2]) {
+					continue
+				}
+				data, err := prev.ReadTile(tc[0], tc[1], tc[2])
+				if err != nil {
+					t.Fatalf("reading previous tile %v: %v", tc, err)
+				}
+				if err := archive.WriteTile(tc[0], tc[1], tc[2], data); err != nil {
+					t.Fatalf("copying previous tile %v: %v", tc, err)
+				}
+			}
+		}
+	}
+
+	if err := archive.Finalize(); err != nil {
 		t.Fatalf("writer.Finalize: %v", err)
 	}
 
 	return outputPath
 }
 
-// transformConfig configures a PMTiles→PMTiles transform run.
+// tilesetWriter is a pmtiles.Writer or an mbtiles.Writer.
+type tilesetWriter interface {
+	tile.TileWriter
+	Finalize() error
+	Abort()
+}
+
+// tilesetReader is a pmtiles.Reader or an mbtiles.Reader.
+type tilesetReader interface {
+	tile.PMTilesReader
+	TileSize() int
+	PremultipliedAlpha() bool
+	Close() error
+}
+
+// openTileset opens path as an MBTiles file if it has the .mbtiles
+// extension, and as a PMTiles archive otherwise.
+func openTileset(t *testing.T, path string) tilesetReader {
+	t.Helper()
+	if strings.HasSuffix(path, ".mbtiles") {
+		r, err := mbtiles.OpenReader(path)
+		if err != nil {
+			t.Fatalf("mbtiles.OpenReader: %v", err)
+		}
+		return r
+	}
+	r, err := pmtiles.OpenReader(path)
+	if err != nil {
+		t.Fatalf("pmtiles.OpenReader: %v", err)
+	}
+	return r
+}
+
+// runLayersPipeline generates one archive per configuration in a single
+// tile.GenerateLayers pass and returns their paths. The layers share the
+// first configuration's zoom range, tile size, resampling, and concurrency,
+// and the merged bounds of all their sources.
+func runLayersPipeline(t *testing.T, cfgs ...pipelineConfig) []string {
+	t.Helper()
+
+	var all []*cog.Reader
+	layerSources := make([][]*cog.Reader, len(cfgs))
+	for i, cfg := range cfgs {
+		sources, err := cog.OpenAll(cfg.InputPaths)
+		if err != nil {
+			t.Fatalf("cog.OpenAll: %v", err)
+		}
+		for _, src := range sources {
+			src.SetBandConfig(cfg.BandCfg)
+			defer src.Close()
+		}
+		layerSources[i] = sources
+		all = append(all, sources...)
+	}
+	mergedBounds := cog.MergedBoundsWGS84(all)
+
+	first := cfgs[0]
+	if first.TileSize == 0 {
+		first.TileSize = 256
+	}
+	if first.Resampling == "" {
+		first.Resampling = "bilinear"
+	}
+	if first.Concurrency == 0 {
+		first.Concurrency = 2
+	}
+	resamplingMode, err := tile.ParseResampling(first.Resampling)
+	if err != nil {
+		t.Fatalf("ParseResampling(%q): %v", first.Resampling, err)
+	}
+
+	dir := t.TempDir()
+	var paths []string
+	var writers []*pmtiles.Writer
+	var layers []tile.Layer
+	for i, cfg := range cfgs {
+		if cfg.Format == "" {
+			cfg.Format = "png"
+		}
+		if cfg.Quality == 0 {
+			cfg.Quality = 85
+		}
+		enc, err := encode.NewEncoder(cfg.Format, cfg.Quality)
+		if err != nil {
+			t.Fatalf("NewEncoder(%q): %v", cfg.Format, err)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("layer%d.pmtiles", i))
+		dem, elevation := encode.DEMEncodingFor(cfg.Format)
+		writer, err := pmtiles.NewWriter(path, pmtiles.WriterOptions{
+			MinZoom:    first.MinZoom,
+			MaxZoom:    first.MaxZoom,
+			Bounds:     cog.MergedBoundsWGS84(layerSources[i]),
+			TileFormat: enc.PMTileType(),
+			TileSize:   first.TileSize,
+			TempDir:    dir,
+			Type:       "baselayer",
+		})
+		if err != nil {
+			t.Fatalf("pmtiles.NewWriter: %v", err)
+		}
+		paths = append(paths, path)
+		writers = append(writers, writer)
+		layers = append(layers, tile.Layer{
+			Config: tile.Config{
+				MinZoom:      first.MinZoom,
+				MaxZoom:      first.MaxZoom,
+				TileSize:     first.TileSize,
+				Concurrency:  first.Concurrency,
+				Encoder:      enc,
+				Bounds:       mergedBounds,
+				Resampling:   resamplingMode,
+				IsTerrarium:  elevation,
+				DEMEncoding:  dem,
+				FillColor:    cfg.FillColor,
+				OutputDir:    dir,
+				LevelByLevel: first.LevelByLevel,
+			},
+			Sources: layerSources[i],
+			Writer:  writer,
+		})
+	}
+
+	if _, err := tile.GenerateLayers(layers); err != nil {
+		for _, w := range writers {
+			w.Abort()
+		}
+		t.Fatalf("tile.GenerateLayers: %v", err)
+	}
+	for _, w := range writers {
+		if err := w.Finalize(); err != nil {
+			t.Fatalf("writer.Finalize: %v", err)
+		}
+	}
+	return paths
+}
+
+// transformConfig configures a transform run. Input and output are PMTiles
+// archives, or MBTiles files by their .mbtiles extension.
 type transformConfig struct {
 	InputPath   string
+	Output      string // output file name (default transform.pmtiles)
 	Format      string
 	Quality     int
 	MinZoom     int
@@ -455,6 +1058,9 @@ type transformConfig struct {
 	Rebuild     bool
 	Concurrency int
 	FillColor   *color.RGBA
+	// PremultiplyAlpha sets the output's alpha as pmtransform
+	// --premultiply-alpha does; nil keeps the source's.
+	PremultiplyAlpha *bool
 }
 
 // runTransform executes the PMTiles transform pipeline and returns the output path.
@@ -471,12 +1077,12 @@ func runTransform(t *testing.T, cfg transformConfig) string {
 		cfg.Concurrency = 2
 	}
 
-	outputPath := filepath.Join(t.TempDir(), "transform.pmtiles")
-
-	reader, err := pmtiles.OpenReader(cfg.InputPath)
-	if err != nil {
-		t.Fatalf("pmtiles.OpenReader: %v", err)
+	if cfg.Output == "" {
+		cfg.Output = "transform.pmtiles"
 	}
+	outputPath := filepath.Join(t.TempDir(), cfg.Output)
+
+	reader := openTileset(t, cfg.InputPath)
 	defer reader.Close()
 
 	srcHeader := reader.Header()
@@ -497,7 +1103,11 @@ func runTransform(t *testing.T, cfg transformConfig) string {
 		maxZoom = int(srcHeader.MaxZoom)
 	}
 
+	// As pmtransform: the size the source records, else 256.
 	tileSize := cfg.TileSize
+	if tileSize <= 0 {
+		tileSize = reader.TileSize()
+	}
 	if tileSize <= 0 {
 		tileSize = 256
 	}
@@ -512,12 +1122,20 @@ func runTransform(t *testing.T, cfg transformConfig) string {
 		t.Fatalf("ParseResampling: %v", err)
 	}
 
+	srcPremultiplied := reader.PremultipliedAlpha()
+	premultiply := srcPremultiplied
+	if cfg.PremultiplyAlpha != nil {
+		premultiply = *cfg.PremultiplyAlpha
+	}
+
 	// Determine mode.
-	formatChanged := format != srcFormat
+	formatChanged := format != srcFormat || premultiply != srcPremultiplied
 	zoomChanged := minZoom < int(srcHeader.MinZoom)
 	mode := tile.TransformPassthrough
-	if cfg.Rebuild || zoomChanged {
+	if cfg.Rebuild || (zoomChanged && (formatChanged || cfg.FillColor != nil)) {
 		mode = tile.TransformRebuild
+	} else if zoomChanged {
+		mode = tile.TransformExtend
 	} else if formatChanged {
 		mode = tile.TransformReencode
 	} else if cfg.FillColor != nil {
@@ -539,9 +1157,12 @@ func runTransform(t *testing.T, cfg transformConfig) string {
 		FillColor:    cfg.FillColor,
 		Bounds:       bounds,
 		OutputDir:    outputDir,
+
+		SourcePremultiplied: srcPremultiplied,
+		PremultiplyAlpha:    premultiply,
 	}
 
-	writer, err := pmtiles.NewWriter(outputPath, pmtiles.WriterOptions{
+	writerOpts := pmtiles.WriterOptions{
 		MinZoom:    minZoom,
 		MaxZoom:    maxZoom,
 		Bounds:     cog.Bounds{MinLon: float64(bounds[0]), MinLat: float64(bounds[1]), MaxLon: float64(bounds[2]), MaxLat: float64(bounds[3])},
@@ -549,9 +1170,17 @@ func runTransform(t *testing.T, cfg transformConfig) string {
 		TileSize:   tileSize,
 		TempDir:    outputDir,
 		Type:       "baselayer",
-	})
+
+		PremultipliedAlpha: premultiply,
+	}
+	var writer tilesetWriter
+	if strings.HasSuffix(outputPath, ".mbtiles") {
+		writer, err = mbtiles.NewWriter(outputPath, writerOpts)
+	} else {
+		writer, err = pmtiles.NewWriter(outputPath, writerOpts)
+	}
 	if err != nil {
-		t.Fatalf("pmtiles.NewWriter: %v", err)
+		t.Fatalf("NewWriter: %v", err)
 	}
 
 	_, err = tile.Transform(transformCfg, reader, writer)
@@ -679,6 +1308,110 @@ func assertTilePixel(t *testing.T, path string, z, x, y int, px, py int, wantR,
 	}
 }
 
+// assertArchivesIdentical checks that two PMTiles archives hold the same
+// tiles with byte-identical data, then that the files themselves match.
+// The tile comparison runs first so a failure names the offending tile.
+func assertArchivesIdentical(t *testing.T, pathA, pathB string) {
+	t.Helper()
+
+	ra, err := pmtiles.OpenReader(pathA)
+	if err != nil {
+		t.Fatalf("assertArchivesIdentical: OpenReader(%s): %v", pathA, err)
+	}
+	defer ra.Close()
+	rb, err := pmtiles.OpenReader(pathB)
+	if err != nil {
+		t.Fatalf("assertArchivesIdentical: OpenReader(%s): %v", pathB, err)
+	}
+	defer rb.Close()
+
+	ha, hb := ra.Header(), rb.Header()
+	if ha.MinZoom != hb.MinZoom || ha.MaxZoom != hb.MaxZoom {
+		t.Fatalf("zoom range differs: %d-%d vs %d-%d", ha.MinZoom, ha.MaxZoom, hb.MinZoom, hb.MaxZoom)
+	}
+	diffs := 0
+	for z := int(ha.MinZoom); z <= int(ha.MaxZoom); z++ {
+		ta, tb := ra.TilesAtZoom(z), rb.TilesAtZoom(z)
+		if len(ta) != len(tb) {
+			t.Errorf("zoom %d: %d vs %d tiles", z, len(ta), len(tb))
+			diffs++
+			continue
+		}
+		for i, tc := range ta {
+			if tb[i] != tc {
+				t.Errorf("zoom %d: tile %d is %v vs %v", z, i, tc, tb[i])
+				diffs++
+				break
+			}
+			da, err := ra.ReadTile(tc[0], tc[1], tc[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			db, err := rb.ReadTile(tc[0], tc[1], tc[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(da, db) {
+				t.Errorf("tile %d/%d/%d differs (%d vs %d bytes)", tc[0], tc[1], tc[2], len(da), len(db))
+				if diffs++; diffs >= 5 {
+					t.Fatal("too many differing tiles")
+				}
+			}
+		}
+	}
+	if diffs > 0 {
+		return
+	}
+
+	fa, err := os.ReadFile(pathA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb, err := os.ReadFile(pathB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fa, fb) {
+		t.Errorf("tiles match but archives differ (%d vs %d bytes)", len(fa), len(fb))
+	}
+}
+
+// assertSameTiles checks that the tilesets at pathA and pathB, PMTiles or
+// MBTiles, hold the same tiles with the same data.
+func assertSameTiles(t *testing.T, pathA, pathB string) {
+	t.Helper()
+	ra, rb := openTileset(t, pathA), openTileset(t, pathB)
+	defer ra.Close()
+	defer rb.Close()
+
+	ha, hb := ra.Header(), rb.Header()
+	if ha.MinZoom != hb.MinZoom || ha.MaxZoom != hb.MaxZoom {
+		t.Fatalf("zoom range differs: %d-%d vs %d-%d", ha.MinZoom, ha.MaxZoom, hb.MinZoom, hb.MaxZoom)
+	}
+	for z := int(ha.MinZoom); z <= int(ha.MaxZoom); z++ {
+		if na, nb := ra.TileCountAtZoom(z), rb.TileCountAtZoom(z); na != nb {
+			t.Fatalf("zoom %d: %d vs %d tiles", z, na, nb)
+		}
+		err := ra.ForEachTileAtZoom(z, func(x, y int) error {
+			da, err := ra.ReadTile(z, x, y)
+			if err != nil {
+				return err
+			}
+			db, err := rb.ReadTile(z, x, y)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(da, db) {
+				return fmt.Errorf("tile %d/%d/%d differs (%d vs %d bytes)", z, x, y, len(da), len(db))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s vs %s: %v", filepath.Base(pathA), filepath.Base(pathB), err)
+		}
+	}
+}
+
 func absDiff(a, b int) int {
 	if a > b {
 		return a - b
@@ -810,3 +1543,203 @@ func assertPlausiblePMTiles(t *testing.T, path string, exp plausibilityExpectati
 		result.TileCount, exp.MinZoom, exp.MaxZoom,
 		h.MinLon, h.MinLat, h.MaxLon, h.MaxLat)
 }
+
+// ---------------------------------------------------------------------------
+// Independent PMTiles v3 decoder
+// ---------------------------------------------------------------------------
+
+// specArchive is a from-the-spec PMTiles v3 reader that shares no code with
+// internal/pmtiles, so end-to-end tests catch writer bugs that the in-repo
+// reader would mirror. It implements only what readers such as go-pmtiles and
+// pmtiles.js rely on: the 127-byte header, varint directories with
+// run-length and offset-delta encoding, leaf directories, and Hilbert tile IDs.
+type specArchive struct {
+	data []byte
+
+	rootOffset, rootLength      uint64
+	metaOffset, metaLength      uint64
+	leafOffset, leafLength      uint64
+	tileDataOffset              uint64
+	addressedTiles, tileEntries uint64
+	internalCompression         uint8
+	tileType                    uint8
+	minZoom, maxZoom            uint8
+	minLon, minLat              float64
+	maxLon, maxLat              float64
+}
+
+// specEntry is one directory entry.
+type specEntry struct {
+	tileID, offset uint64
+	length, run    uint32
+}
+
+func openSpecArchive(t *testing.T, path string) *specArchive {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < 127 || string(data[:7]) != "PMTiles" || data[7] != 3 {
+		t.Fatalf("%s: not a PMTiles v3 archive", path)
+	}
+	le := binary.LittleEndian
+	u := func(off int) uint64 { return le.Uint64(data[off:]) }
+	deg := func(off int) float64 { return float64(int32(le.Uint32(data[off:]))) / 1e7 }
+	return &specArchive{
+		data:                data,
+		rootOffset:          u(8),
+		rootLength:          u(16),
+		metaOffset:          u(24),
+		metaLength:          u(32),
+		leafOffset:          u(40),
+		leafLength:          u(48),
+		tileDataOffset:      u(56),
+		addressedTiles:      u(72),
+		tileEntries:         u(80),
+		internalCompression: data[97],
+		tileType:            data[99],
+		minZoom:             data[100],
+		maxZoom:             data[101],
+		minLon:              deg(102),
+		minLat:              deg(106),
+		maxLon:              deg(110),
+		maxLat:              deg(114),
+	}
+}
+
+// section returns the internally compressed bytes at [off, off+n), inflated.
+func (a *specArchive) section(t *testing.T, off, n uint64) []byte {
+	t.Helper()
+	raw := a.data[off : off+n]
+	switch a.internalCompression {
+	case 1: // none
+		return raw
+	case 2: // gzip
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("gzip section at %d: %v", off, err)
+		}
+		out, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("gzip section at %d: %v", off, err)
+		}
+		return out
+	}
+	t.Fatalf("unsupported internal compression %d", a.internalCompression)
+	return nil
+}
+
+// directory decodes the directory stored at [off, off+n).
+func (a *specArchive) directory(t *testing.T, off, n uint64) []specEntry {
+	t.Helper()
+	r := bytes.NewReader(a.section(t, off, n))
+	next := func() uint64 {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			t.Fatalf("directory at %d: %v", off, err)
+		}
+		return v
+	}
+	entries := make([]specEntry, next())
+	var id uint64
+	for i := range entries {
+		id += next()
+		entries[i].tileID = id
+	}
+	for i := range entries {
+		entries[i].run = uint32(next())
+	}
+	for i := range entries {
+		entries[i].length = uint32(next())
+	}
+	for i := range entries {
+		if v := next(); v == 0 && i > 0 {
+			entries[i].offset = entries[i-1].offset + uint64(entries[i-1].length)
+		} else {
+			entries[i].offset = v - 1
+		}
+	}
+	return entries
+}
+
+// entries returns all tile entries (run length > 0), following leaf
+// directories.
+func (a *specArchive) entries(t *testing.T) []specEntry {
+	t.Helper()
+	var out []specEntry
+	var walk func(dir []specEntry)
+	walk = func(dir []specEntry) {
+		for _, e := range dir {
+			if e.run == 0 {
+				walk(a.directory(t, a.leafOffset+e.offset, uint64(e.length)))
+			} else {
+				out = append(out, e)
+			}
+		}
+	}
+	walk(a.directory(t, a.rootOffset, a.rootLength))
+	return out
+}
+
+// tile returns the bytes of tile z/x/y, or nil if it is not addressed.
+func (a *specArchive) tile(t *testing.T, z, x, y int) []byte {
+	t.Helper()
+	id := specTileID(z, x, y)
+	dir := a.directory(t, a.rootOffset, a.rootLength)
+	for depth := 0; depth < 4; depth++ {
+		// Last entry with tileID <= id.
+		i := sort.Search(len(dir), func(i int) bool { return dir[i].tileID > id }) - 1
+		if i < 0 {
+			return nil
+		}
+		e := dir[i]
+		if e.run == 0 {
+			dir = a.directory(t, a.leafOffset+e.offset, uint64(e.length))
+			continue
+		}
+		if id >= e.tileID+uint64(e.run) {
+			return nil
+		}
+		start := a.tileDataOffset + e.offset
+		return a.data[start : start+uint64(e.length)]
+	}
+	t.Fatalf("tile %d/%d/%d: leaf directories nested too deep", z, x, y)
+	return nil
+}
+
+// metadata parses the JSON metadata section.
+func (a *specArchive) metadata(t *testing.T) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	if err := json.Unmarshal(a.section(t, a.metaOffset, a.metaLength), &m); err != nil {
+		t.Fatalf("metadata: %v", err)
+	}
+	return m
+}
+
+// specTileID computes the PMTiles tile ID of z/x/y: the number of tiles at
+// lower zooms plus the position of (x, y) along the zoom's Hilbert curve.
+func specTileID(z, x, y int) uint64 {
+	id := (uint64(1)<<(2*uint(z)) - 1) / 3
+	tx, ty := uint64(x), uint64(y)
+	for s := uint64(1) << uint(z) >> 1; s > 0; s >>= 1 {
+		var rx, ry uint64
+		if tx&s != 0 {
+			rx = 1
+		}
+		if ty&s != 0 {
+			ry = 1
+		}
+		id += s * s * ((3 * rx) ^ ry)
+		// Rotate the quadrant so the curve stays continuous.
+		if ry == 0 {
+			if rx == 1 {
+				tx = s - 1 - tx&(s-1)
+				ty = s - 1 - ty&(s-1)
+			}
+			tx, ty = ty, tx
+		}
+	}
+	return id
+}
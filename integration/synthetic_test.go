@@ -1,12 +1,36 @@
 package integration_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"image"
 	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/pspoerri/geotiff2pmtiles/internal/checkpoint"
 	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
 	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
 	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+	"github.com/pspoerri/geotiff2pmtiles/internal/prealloc"
+	"github.com/pspoerri/geotiff2pmtiles/internal/shard"
+	"github.com/pspoerri/geotiff2pmtiles/internal/tile"
+	"github.com/pspoerri/geotiff2pmtiles/internal/vector"
+	"github.com/pspoerri/geotiff2pmtiles/tileorder"
 )
 
 // TestBasicRGBPipeline generates a 512x512 8-bit RGB GeoTIFF with a gradient,
@@ -111,6 +135,94 @@ func TestRGBATransparency(t *testing.T) {
 	}
 }
 
+// TestJPEGBackground converts an RGBA GeoTIFF with a transparent left three
+// quarters to JPEG with a white background and checks that transparent areas, both
+// at max zoom and in the downsampled level, come out white instead of black.
+func TestJPEGBackground(t *testing.T) {
+	tiffPath := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512,
+		SamplesPerPixel: 4,
+		BitsPerSample:   8,
+		OriginLon:       -25.0,
+		OriginLat:       70.0,
+		PixelSizeDeg:    0.1, // 51.2° extent; opaque from lon 13.4 east
+		EPSG:            4326,
+		PixelFunc: func(x, y, band int) uint16 {
+			if band == 3 {
+				if x < 384 {
+					return 0
+				}
+				return 255
+			}
+			return 200
+		},
+	})
+
+	outPath := runPipeline(t, pipelineConfig{
+		InputPaths: []string{tiffPath},
+		Format:     "jpeg",
+		Quality:    90,
+		MinZoom:    0,
+		MaxZoom:    1,
+		Background: &color.RGBA{R: 255, G: 255, B: 255, A: 255},
+	})
+
+	// Pixels at lat 40: lon 5 is transparent in the source, lon 21 opaque.
+	assertTilePixel(t, outPath, 1, 1, 0, 7, 194, 255, 255, 255, 255, 8)
+	assertTilePixel(t, outPath, 1, 1, 0, 30, 194, 200, 200, 200, 255, 8)
+	// Downsampled level: lon -100 lies outside the source.
+	assertTilePixel(t, outPath, 0, 0, 0, 57, 87, 255, 255, 255, 255, 8)
+	assertTilePixel(t, outPath, 0, 0, 0, 131, 97, 255, 255, 255, 255, 8)
+}
+
+// TestOrientationMatchesTopLeft stores the same image mirrored under TIFF
+// orientations 2-4 and checks that each converts to an archive identical to
+// the plain top-left one. The image size is not a multiple of the tile size,
+// so mirrored tiles straddle stored tile boundaries.
+func TestOrientationMatchesTopLeft(t *testing.T) {
+	write := func(orientation int) string {
+		return writeSyntheticGeoTIFF(t, tiffWriterConfig{
+			Width: 300, Height: 200,
+			TileWidth: 128, TileHt: 128,
+			SamplesPerPixel: 4,
+			OriginLon:       5.0,
+			OriginLat:       47.0,
+			PixelSizeDeg:    0.01,
+			Orientation:     orientation,
+			PixelFunc: func(x, y, band int) uint16 {
+				switch band {
+				case 0:
+					return uint16(x * 255 / 299)
+				case 1:
+					return uint16(y * 255 / 199)
+				case 2:
+					return uint16((x * y) % 251)
+				}
+				if x < 40 && y < 30 {
+					return 0 // transparent top-left corner
+				}
+				return 255
+			},
+		})
+	}
+	run := func(path string) string {
+		return runPipeline(t, pipelineConfig{
+			InputPaths:  []string{path},
+			Format:      "png",
+			MinZoom:     5,
+			MaxZoom:     7,
+			Concurrency: 1,
+		})
+	}
+
+	want := run(write(1))
+	for _, o := range []int{2, 3, 4} {
+		t.Run(fmt.Sprintf("orientation%d", o), func(t *testing.T) {
+			assertArchivesIdentical(t, want, run(write(o)))
+		})
+	}
+}
+
 // TestGrayscaleWithNodata generates a 256x256 8-bit grayscale GeoTIFF where
 // nodata=0, converts to PNG, and checks that tiles are produced.
 func TestGrayscaleWithNodata(t *testing.T) {
@@ -320,6 +432,80 @@ func TestFillColor(t *testing.T) {
 	}
 }
 
+// TestOverlaySkipsFill converts two distant sources with a fill color as a
+// baselayer and as an overlay: the baselayer fills the tile positions
+// between them, the overlay leaves them out.
+func TestOverlaySkipsFill(t *testing.T) {
+	patch := func(lon, lat float64) string {
+		return writeSyntheticGeoTIFF(t, tiffWriterConfig{
+			Width: 64, Height: 64,
+			SamplesPerPixel: 3,
+			BitsPerSample:   8,
+			OriginLon:       lon,
+			OriginLat:       lat,
+			PixelSizeDeg:    0.05,
+			EPSG:            4326,
+			PixelFunc: func(x, y, band int) uint16 {
+				return uint16((x*4 + y*2 + band*50) % 256)
+			},
+		})
+	}
+	inputs := []string{patch(-40, 60), patch(40, -20)}
+	fill := &color.RGBA{R: 0, G: 0, B: 0, A: 255}
+
+	base := validatePMTiles(t, runPipeline(t, pipelineConfig{
+		InputPaths: inputs,
+		MinZoom:    2,
+		MaxZoom:    4,
+		FillColor:  fill,
+	}))
+	overlayPath := runPipeline(t, pipelineConfig{
+		InputPaths: inputs,
+		MinZoom:    2,
+		MaxZoom:    4,
+		FillColor:  fill,
+		Overlay:    true,
+	})
+	overlay := validatePMTiles(t, overlayPath)
+
+	if got := overlay.Metadata["type"]; got != "overlay" {
+		t.Errorf("metadata type = %v, want overlay", got)
+	}
+	for z := 2; z <= 4; z++ {
+		if overlay.ZoomCounts[z] == 0 {
+			t.Errorf("zoom %d: overlay has no tiles", z)
+		}
+		if overlay.ZoomCounts[z] >= base.ZoomCounts[z] {
+			t.Errorf("zoom %d: overlay has %d tiles, want fewer than the filled baselayer's %d",
+				z, overlay.ZoomCounts[z], base.ZoomCounts[z])
+		}
+	}
+
+	// Every overlay tile at max zoom holds source data.
+	reader, err := pmtiles.OpenReader(overlayPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	for _, tc := range reader.TilesAtZoom(4) {
+		img := assertTileDecodesAsImage(t, overlayPath, tc[0], tc[1], tc[2])
+		b := img.Bounds()
+		opaque := false
+		for y := b.Min.Y; y < b.Max.Y && !opaque; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, g, bl, a := img.At(x, y).RGBA()
+				if a != 0 && (r|g|bl) != 0 {
+					opaque = true
+					break
+				}
+			}
+		}
+		if !opaque {
+			t.Errorf("overlay tile %v holds no source pixels", tc)
+		}
+	}
+}
+
 // TestDiskSpilling generates a 1024x1024 GeoTIFF with 4x4 tiles and converts
 // with a 1MB memory limit, verifying it completes without crash.
 func TestDiskSpilling(t *testing.T) {
@@ -390,6 +576,13 @@ func TestTransformPassthrough(t *testing.T) {
 	if outResult.Header.TileType != srcResult.Header.TileType {
 		t.Errorf("passthrough tile type changed: src=%d, out=%d", srcResult.Header.TileType, outResult.Header.TileType)
 	}
+	// Shared tile data is kept as it is in the source.
+	if outResult.Header.NumTileContents != srcResult.Header.NumTileContents ||
+		outResult.Header.TileDataLength != srcResult.Header.TileDataLength {
+		t.Errorf("passthrough tile data: %d contents, %d bytes; want %d, %d as in the source",
+			outResult.Header.NumTileContents, outResult.Header.TileDataLength,
+			srcResult.Header.NumTileContents, srcResult.Header.TileDataLength)
+	}
 }
 
 // TestTransformReencode creates a PNG PMTiles and re-encodes to JPEG.
@@ -440,6 +633,33 @@ func TestTransformReencode(t *testing.T) {
 	}
 }
 
+// TestTransformRecordedTileSize checks that a transform keeps the tile
+// size the source archive records in its metadata, without being told.
+func TestTransformRecordedTileSize(t *testing.T) {
+	tiffPath := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512, OriginLon: -25, OriginLat: 70, PixelSizeDeg: 0.1,
+		PixelFunc: func(x, y, band int) uint16 { return uint16((x*5 + y*3 + band) % 256) },
+	})
+	srcPath := runPipeline(t, pipelineConfig{
+		InputPaths: []string{tiffPath}, Format: "png", MinZoom: 0, MaxZoom: 2, TileSize: 512,
+	})
+	src, err := pmtiles.OpenReader(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if got := src.TileSize(); got != 512 {
+		t.Fatalf("source records tile size %d, want 512", got)
+	}
+
+	outPath := runTransform(t, transformConfig{
+		InputPath: srcPath, MinZoom: -1, MaxZoom: -1, Rebuild: true,
+	})
+	if got := assertTileDecodesAsImage(t, outPath, 2, 1, 1).Bounds().Dx(); got != 512 {
+		t.Errorf("rebuilt tile is %d pixels wide, want 512", got)
+	}
+}
+
 // TestTransformRebuild creates a PMTiles at zoom 2-3 and rebuilds with min-zoom 0.
 func TestTransformRebuild(t *testing.T) {
 	tiffPath := writeSyntheticGeoTIFF(t, tiffWriterConfig{
@@ -646,3 +866,2261 @@ func TestWebPEncoding(t *testing.T) {
 		t.Error("expected at least one tile")
 	}
 }
+
+// TestTargetSizeQualityPlan plans per-zoom JPEG quality for a noisy source
+// and verifies that a generous budget keeps full quality, a tight budget
+// degrades the max zoom first, and the planned archive approximately
+// honours the budget.
+func TestTargetSizeQualityPlan(t *testing.T) {
+	tiffPath := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512,
+		SamplesPerPixel: 3,
+		BitsPerSample:   8,
+		OriginLon:       -25.0,
+		OriginLat:       70.0,
+		PixelSizeDeg:    0.1,
+		EPSG:            4326,
+		PixelFunc: func(x, y, band int) uint16 {
+			return uint16((x*x*31 + y*y*17 + x*y*7 + band*101) % 256)
+		},
+	})
+
+	sources, err := cog.OpenAll([]string{tiffPath})
+	if err != nil {
+		t.Fatalf("cog.OpenAll: %v", err)
+	}
+	defer func() {
+		for _, s := range sources {
+			s.Close()
+		}
+	}()
+
+	cfg := tile.Config{
+		MinZoom:         0,
+		MaxZoom:         3,
+		TileSize:        256,
+		Concurrency:     2,
+		Bounds:          cog.MergedBoundsWGS84(sources),
+		Resampling:      tile.ResamplingBilinear,
+		ResamplingGamma: 1.0,
+	}
+
+	generous, err := tile.PlanQuality(cfg, sources, "jpeg", 90, 1<<40)
+	if err != nil {
+		t.Fatalf("PlanQuality: %v", err)
+	}
+	if !generous.Fits {
+		t.Fatal("expected generous budget to fit")
+	}
+	for z, q := range generous.Quality {
+		if q != 90 {
+			t.Errorf("zoom %d: expected quality 90 with generous budget, got %d", z, q)
+		}
+	}
+
+	target := generous.TotalBytes / 2
+	tight, err := tile.PlanQuality(cfg, sources, "jpeg", 90, target)
+	if err != nil {
+		t.Fatalf("PlanQuality: %v", err)
+	}
+	if !tight.Fits {
+		t.Fatalf("expected half budget to be reachable, estimated %d > %d", tight.TotalBytes, target)
+	}
+	if tight.Quality[3] >= 90 {
+		t.Errorf("expected max zoom quality to drop below 90, got %d", tight.Quality[3])
+	}
+	for z := 0; z < 3; z++ {
+		if tight.Quality[z] < tight.Quality[3] {
+			t.Errorf("zoom %d quality %d below max zoom quality %d", z, tight.Quality[z], tight.Quality[3])
+		}
+	}
+
+	encs, err := tight.Encoders("jpeg")
+	if err != nil {
+		t.Fatalf("Encoders: %v", err)
+	}
+	outPath := runPipeline(t, pipelineConfig{
+		InputPaths:   []string{tiffPath},
+		Format:       "jpeg",
+		Quality:      90,
+		MinZoom:      0,
+		MaxZoom:      3,
+		ZoomEncoders: encs,
+	})
+	validatePMTiles(t, outPath)
+
+	fi, err := os.Stat(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Estimates come from 16 samples per zoom; allow generous slack.
+	if fi.Size() > target*3/2 {
+		t.Errorf("archive size %d exceeds 1.5x target %d", fi.Size(), target)
+	}
+
+	if _, err := tile.PlanQuality(cfg, sources, "png", 90, target); err == nil {
+		t.Error("expected error for lossless format")
+	}
+}
+
+// TestMaxTileBytes generates noise, which JPEG encodes to large tiles at
+// high quality, with a limit below the largest tile: every written tile
+// fits, and max zoom tiles that fitted already are unchanged.
+func TestMaxTileBytes(t *testing.T) {
+	tiffPath := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512,
+		SamplesPerPixel: 3,
+		BitsPerSample:   8,
+		OriginLon:       8.0,
+		OriginLat:       47.0,
+		PixelSizeDeg:    0.002,
+		EPSG:            4326,
+		PixelFunc: func(x, y, band int) uint16 {
+			h := uint32(x*73856093) ^ uint32(y*19349663) ^ uint32(band*83492791)
+			return uint16((h * 2654435761) >> 24)
+		},
+	})
+	cfg := pipelineConfig{InputPaths: []string{tiffPath}, Format: "jpeg", Quality: 95, MinZoom: 7, MaxZoom: 8}
+
+	sizes := func(path string) map[[3]int]int {
+		t.Helper()
+		reader, err := pmtiles.OpenReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		got := make(map[[3]int]int)
+		for z := cfg.MinZoom; z <= cfg.MaxZoom; z++ {
+			err := reader.ForEachTileAtZoom(z, func(x, y int) error {
+				data, err := reader.ReadTile(z, x, y)
+				got[[3]int{z, x, y}] = len(data)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return got
+	}
+	full := sizes(runPipeline(t, cfg))
+	largest := 0
+	for _, n := range full {
+		largest = max(largest, n)
+	}
+
+	cfg.MaxTileBytes = largest * 2 / 3
+	limited := sizes(runPipeline(t, cfg))
+	if len(limited) != len(full) {
+		t.Fatalf("%d tiles with the limit, %d without", len(limited), len(full))
+	}
+	shrunk := 0
+	for k, n := range limited {
+		if n > cfg.MaxTileBytes {
+			t.Errorf("tile %v: %d bytes, over the limit of %d", k, n, cfg.MaxTileBytes)
+		}
+		// Parents are downsampled from the children as written, so only
+		// max zoom tiles are comparable.
+		if k[0] == cfg.MaxZoom && full[k] <= cfg.MaxTileBytes && n != full[k] {
+			t.Errorf("tile %v: %d bytes, want %d as without the limit", k, n, full[k])
+		}
+		if n < full[k] {
+			shrunk++
+		}
+	}
+	if shrunk == 0 {
+		t.Error("no tile was re-encoded")
+	}
+}
+
+// TestRendererMatchesPipelineAtMaxZoom renders max-zoom tiles on demand and
+// verifies they are byte-identical to the tiles produced by the full
+// pipeline (both paths render directly from the source at max zoom).
+func TestRendererMatchesPipelineAtMaxZoom(t *testing.T) {
+	tiffPath := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512,
+		SamplesPerPixel: 3,
+		BitsPerSample:   8,
+		OriginLon:       -25.0,
+		OriginLat:       70.0,
+		PixelSizeDeg:    0.1,
+		EPSG:            4326,
+		PixelFunc: func(x, y, band int) uint16 {
+			return uint16((x + y*3 + band*50) % 256)
+		},
+	})
+
+	outPath := runPipeline(t, pipelineConfig{
+		InputPaths: []string{tiffPath},
+		Format:     "png",
+		MinZoom:    1,
+		MaxZoom:    2,
+	})
+
+	sources, err := cog.OpenAll([]string{tiffPath})
+	if err != nil {
+		t.Fatalf("cog.OpenAll: %v", err)
+	}
+	defer func() {
+		for _, s := range sources {
+			s.Close()
+		}
+	}()
+	enc, err := encode.NewEncoder("png", 85)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renderer, err := tile.NewRenderer(tile.Config{
+		MinZoom:         1,
+		MaxZoom:         2,
+		TileSize:        256,
+		Concurrency:     2,
+		Encoder:         enc,
+		Bounds:          cog.MergedBoundsWGS84(sources),
+		Resampling:      tile.ResamplingBilinear,
+		ResamplingGamma: 1.0,
+	}, sources)
+	if err != nil {
+		t.Fatalf("NewRenderer: %v", err)
+	}
+
+	reader, err := pmtiles.OpenReader(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	tiles := reader.TilesAtZoom(2)
+	if len(tiles) == 0 {
+		t.Fatal("expected tiles at zoom 2")
+	}
+	for _, tc := range tiles {
+		want, err := reader.ReadTile(tc[0], tc[1], tc[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := renderer.RenderTile(tc[0], tc[1], tc[2])
+		if err != nil {
+			t.Fatalf("RenderTile(%v): %v", tc, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("tile %v: on-demand render differs from pipeline output", tc)
+		}
+	}
+
+	if data, err := renderer.RenderTile(3, 0, 0); data != nil || err != nil {
+		t.Errorf("RenderTile outside zoom range = %d bytes, %v; want nil, nil", len(data), err)
+	}
+}
+
+// TestDeterminismAcrossConcurrency renders the same inputs with one worker
+// and with many and requires byte-identical archives. Shared COG caches,
+// pooled buffers, disk spilling, and batch scheduling must not leak into tile
+// content; neither may the order in which workers accumulate kernel weights.
+func TestDeterminismAcrossConcurrency(t *testing.T) {
+	// Two overlapping sources with small source tiles, so workers contend
+	// for the COG cache and mosaic priority is exercised. The pattern has
+	// detail at every scale so interpolation differences show up.
+	pattern := func(x, y, band int) uint16 {
+		return uint16((x*x/7 + y*13 + x*y/5 + band*71) % 256)
+	}
+	base := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512,
+		TileWidth: 128, TileHt: 128,
+		SamplesPerPixel: 3,
+		BitsPerSample:   8,
+		OriginLon:       -25.0,
+		OriginLat:       70.0,
+		PixelSizeDeg:    0.1,
+		EPSG:            4326,
+		PixelFunc:       pattern,
+	})
+	overlay := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 384, Height: 384,
+		TileWidth: 128, TileHt: 128,
+		SamplesPerPixel: 4,
+		BitsPerSample:   8,
+		OriginLon:       -5.0,
+		OriginLat:       55.0,
+		PixelSizeDeg:    0.07,
+		EPSG:            4326,
+		NoData:          "0",
+		PixelFunc: func(x, y, band int) uint16 {
+			if (x/40+y/40)%3 == 0 {
+				return 0 // nodata holes reveal the base source
+			}
+			if band == 3 {
+				return 255
+			}
+			return pattern(y, x, band) | 1
+		},
+	})
+
+	fill := &color.RGBA{R: 10, G: 20, B: 30, A: 255}
+	tests := []struct {
+		name string
+		cfg  pipelineConfig
+	}{
+		{"bilinear", pipelineConfig{Resampling: "bilinear"}},
+		{"bicubic", pipelineConfig{Resampling: "bicubic"}},
+		{"lanczos", pipelineConfig{Resampling: "lanczos"}},
+		{"nearest", pipelineConfig{Resampling: "nearest"}},
+		{"mode", pipelineConfig{Resampling: "mode"}},
+		{"fill color", pipelineConfig{Resampling: "bicubic", FillColor: fill}},
+		{"disk spill", pipelineConfig{Resampling: "bilinear", MemLimitMB: 1}},
+		{"raw spill", pipelineConfig{Resampling: "bilinear", MemLimitMB: 1, SpillFormat: "raw"}},
+		{"jpeg", pipelineConfig{Resampling: "bilinear", Format: "jpeg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.InputPaths = []string{base, overlay}
+			cfg.MinZoom = 0
+			cfg.MaxZoom = 6 // ~150 tiles at z6: several scheduling batches
+			cfg.TileSize = 64
+
+			cfg.Concurrency = 1
+			serial := runPipeline(t, cfg)
+			cfg.Concurrency = 8
+			parallel := runPipeline(t, cfg)
+
+			if n := validatePMTiles(t, serial).TileCount; n == 0 {
+				t.Fatal("expected tiles")
+			}
+			assertArchivesIdentical(t, serial, parallel)
+		})
+	}
+}
+
+// TestPipelinedMatchesLevelByLevel requires the zoom-pipelined scheduler,
+// where parents are downsampled while other workers still render and
+// consumed children are dropped from the store, to produce the same archive
+// as finishing each zoom level before the next.
+func TestPipelinedMatchesLevelByLevel(t *testing.T) {
+	src := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 384,
+		TileWidth: 128, TileHt: 128,
+		SamplesPerPixel: 3,
+		BitsPerSample:   8,
+		OriginLon:       -20.0,
+		OriginLat:       60.0,
+		PixelSizeDeg:    0.08,
+		EPSG:            4326,
+		PixelFunc: func(x, y, band int) uint16 {
+			return uint16((x*7 + y*y/11 + band*53) % 256)
+		},
+	})
+
+	fill := &color.RGBA{R: 40, G: 50, B: 60, A: 255}
+	tests := []struct {
+		name string
+		cfg  pipelineConfig
+	}{
+		{"default", pipelineConfig{}},
+		{"fill color", pipelineConfig{FillColor: fill}},
+		{"disk spill", pipelineConfig{MemLimitMB: 1}},
+		{"raw spill", pipelineConfig{MemLimitMB: 1, SpillFormat: "raw"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.InputPaths = []string{src}
+			cfg.MinZoom = 0
+			cfg.MaxZoom = 6
+			cfg.TileSize = 64
+			cfg.Concurrency = 8
+
+			cfg.LevelByLevel = true
+			levels := runPipeline(t, cfg)
+			cfg.LevelByLevel = false
+			pipelined := runPipeline(t, cfg)
+
+			if n := validatePMTiles(t, levels).TileCount; n == 0 {
+				t.Fatal("expected tiles")
+			}
+			assertArchivesIdentical(t, levels, pipelined)
+		})
+	}
+}
+
+// TestResumeMatchesFullRun interrupts a checkpointed run partway down the
+// pyramid and requires the run resumed from its checkpoint to produce the
+// same archive as one that was never interrupted.
+func TestResumeMatchesFullRun(t *testing.T) {
+	src := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 384,
+		TileWidth: 128, TileHt: 128,
+		SamplesPerPixel: 3,
+		BitsPerSample:   8,
+		OriginLon:       -20.0,
+		OriginLat:       60.0,
+		PixelSizeDeg:    0.08,
+		EPSG:            4326,
+		PixelFunc: func(x, y, band int) uint16 {
+			if x < 128 {
+				return 90 // uniform tiles, kept in the store's index
+			}
+			return uint16((x*7 + y*y/11 + band*53) % 256)
+		},
+	})
+
+	for _, memLimitMB := range []int{0, 1} {
+		t.Run(fmt.Sprintf("mem %d MB", memLimitMB), func(t *testing.T) {
+			cfg := pipelineConfig{
+				InputPaths:   []string{src},
+				MinZoom:      0,
+				MaxZoom:      6,
+				TileSize:     64,
+				MemLimitMB:   memLimitMB,
+				LevelByLevel: true,
+			}
+			full := runPipeline(t, cfg)
+
+			cfg.Checkpoint = filepath.Join(t.TempDir(), "output.pmtiles.resume")
+			cfg.InterruptZoom = 3
+			if out := runPipeline(t, cfg); out != "" {
+				t.Fatal("interrupted run produced an archive")
+			}
+			state, err := checkpoint.Load(cfg.Checkpoint)
+			if err != nil || state == nil {
+				t.Fatalf("checkpoint.Load = %v, %v; want the checkpoint of zoom 4", state, err)
+			}
+			if state.Zoom != 4 {
+				t.Fatalf("checkpoint at zoom %d, want 4", state.Zoom)
+			}
+
+			cfg.InterruptZoom = 0
+			resumed := runPipeline(t, cfg)
+			assertArchivesIdentical(t, full, resumed)
+		})
+	}
+}
+
+// TestFailedLevelReleasesFeeder fails a level-by-level run at a downsampled
+// zoom with more batches than the batch channel holds, and requires the
+// goroutine feeding batches to the workers to exit rather than block on a
+// channel nobody reads any more.
+func TestFailedLevelReleasesFeeder(t *testing.T) {
+	src := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 384,
+		TileWidth: 128, TileHt: 128,
+		SamplesPerPixel: 3,
+		BitsPerSample:   8,
+		OriginLon:       -20.0,
+		OriginLat:       60.0,
+		PixelSizeDeg:    0.08,
+		EPSG:            4326,
+		PixelFunc: func(x, y, band int) uint16 {
+			return uint16((x*7 + y*y/11 + band*53) % 256)
+		},
+	})
+	before := runtime.NumGoroutine()
+	out := runPipeline(t, pipelineConfig{
+		InputPaths:    []string{src},
+		MinZoom:       5,
+		MaxZoom:       8,
+		TileSize:      64,
+		Concurrency:   1,
+		LevelByLevel:  true,
+		InterruptZoom: 7,
+	})
+	if out != "" {
+		t.Fatal("interrupted run produced an archive")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines after the failed run, %d before", n, before)
+	}
+}
+
+// TestEncodeCacheMatchesUncached requires runs reusing the encodings of
+// repeated tiles to produce the same archive as runs encoding every tile,
+// TestStreamingClustered generates with --streaming, which writes the max
+// zoom first straight into the archive, and requires a clustered archive
+// with the same tiles as a two-pass run, pipelined and level by level,
+// with and without spilling stores.
+func TestStreamingClustered(t *testing.T) {
+	src := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 384,
+		TileWidth: 128, TileHt: 128,
+		SamplesPerPixel: 3,
+		BitsPerSample:   8,
+		OriginLon:       -20.0,
+		OriginLat:       60.0,
+		PixelSizeDeg:    0.08,
+		EPSG:            4326,
+		PixelFunc: func(x, y, band int) uint16 {
+			if x < 128 {
+				return 90 // uniform tiles, deduplicated
+			}
+			return uint16((x*7 + y*y/11 + band*53) % 256)
+		},
+	})
+
+	// Without range collapsing the lower zooms stay behind the max zoom.
+	probe, err := os.Create(filepath.Join(t.TempDir(), "probe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clustered := prealloc.CollapseBlock(probe) > 0
+	probe.Close()
+
+	for _, levelByLevel := range []bool{false, true} {
+		for _, memLimitMB := range []int{0, 1} {
+			t.Run(fmt.Sprintf("level-by-level=%v mem %d MB", levelByLevel, memLimitMB), func(t *testing.T) {
+				cfg := pipelineConfig{
+					InputPaths:   []string{src},
+					MinZoom:      0,
+					MaxZoom:      6,
+					TileSize:     64,
+					Concurrency:  8,
+					MemLimitMB:   memLimitMB,
+					LevelByLevel: levelByLevel,
+				}
+				twoPass := runPipeline(t, cfg)
+				cfg.Streaming = true
+				streamed := runPipeline(t, cfg)
+
+				r, err := pmtiles.OpenReader(streamed)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer r.Close()
+				if h := r.Header(); h.Clustered != clustered {
+					t.Errorf("Clustered = %v, want %v", h.Clustered, clustered)
+				}
+				assertSameTiles(t, twoPass, streamed)
+			})
+		}
+	}
+}
+
+// with a cache small enough to evict.
+func TestEncodeCacheMatchesUncached(t *testing.T) {
+	// Gray classified data: a few classes in blocks, so that many tiles
+	// at every zoom repeat exactly.
+	src := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512,
+		TileWidth: 128, TileHt: 128,
+		SamplesPerPixel: 1,
+		BitsPerSample:   8,
+		OriginLon:       8.0,
+		OriginLat:       47.0,
+		PixelSizeDeg:    0.01,
+		EPSG:            4326,
+		PixelFunc: func(x, y, band int) uint16 {
+			return uint16(40 * ((x/128 + y/128) % 3))
+		},
+	})
+
+	for _, format := range []string{"png", "jpeg"} {
+		t.Run(format, func(t *testing.T) {
+			cfg := pipelineConfig{
+				InputPaths:  []string{src},
+				Format:      format,
+				Resampling:  "nearest",
+				MinZoom:     5,
+				MaxZoom:     9,
+				Concurrency: 8,
+			}
+			uncached := runPipeline(t, cfg)
+			cfg.EncodeCacheMB = 1
+			cached := runPipeline(t, cfg)
+			assertArchivesIdentical(t, uncached, cached)
+		})
+	}
+}
+
+// TestRemoteSourceMatchesLocal tiles a GeoTIFF served over HTTP, read by
+// range requests, and checks the archive equals the one from the local file.
+func TestRemoteSourceMatchesLocal(t *testing.T) {
+	src := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 1024, Height: 1024,
+		TileWidth: 256, TileHt: 256,
+		SamplesPerPixel: 3,
+		BitsPerSample:   8,
+		OriginLon:       8.0,
+		OriginLat:       47.0,
+		PixelSizeDeg:    0.001,
+		EPSG:            4326,
+		PixelFunc: func(x, y, band int) uint16 {
+			return uint16((x*(band+1) + y) % 256)
+		},
+	})
+	var ranges atomic.Int64
+	files := http.FileServer(http.Dir(filepath.Dir(src)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			ranges.Add(1)
+		}
+		files.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	cfg := pipelineConfig{
+		InputPaths:  []string{src},
+		MinZoom:     6,
+		MaxZoom:     10,
+		Concurrency: 4,
+	}
+	local := runPipeline(t, cfg)
+	cfg.InputPaths = []string{srv.URL + "/" + filepath.Base(src)}
+	remote := runPipeline(t, cfg)
+	assertArchivesIdentical(t, local, remote)
+	if ranges.Load() == 0 {
+		t.Error("no range requests were made")
+	}
+}
+
+// TestShardsMergeToFullRun generates a run as --shard "6-7,8-@2x2" does,
+// merges the shards as pmtransform --merge does, and compares the result
+// with an unsharded run. The grid cells split zooms 8-10 without sharing a
+// tile, so those zooms match byte for byte; the band 6-7 is rendered from
+// the source at zoom 7 instead of downsampled from zoom 8, so it only has
+// the same tiles.
+func TestShardsMergeToFullRun(t *testing.T) {
+	src := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 2048, Height: 2048,
+		TileWidth: 256, TileHt: 256,
+		SamplesPerPixel: 3,
+		BitsPerSample:   8,
+		OriginLon:       8.0,
+		OriginLat:       47.0,
+		PixelSizeDeg:    0.0015,
+		EPSG:            4326,
+		PixelFunc: func(x, y, band int) uint16 {
+			return uint16((x*(band+1) + y) % 256)
+		},
+	})
+	cfg := pipelineConfig{InputPaths: []string{src}, MinZoom: 6, MaxZoom: 10}
+	full := runPipeline(t, cfg)
+
+	sources, err := cog.OpenAll([]string{src})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounds := cog.MergedBoundsWGS84(sources)
+	sources[0].Close()
+	bands, err := shard.Parse("6-7,8-@2x2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards, err := shard.Plan(bands, 6, 10, bounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shards) != 5 {
+		t.Fatalf("%d shards, want the band and 2x2 cells", len(shards))
+	}
+
+	var readers []*pmtiles.Reader
+	for _, s := range shards {
+		scfg := cfg
+		scfg.MinZoom, scfg.MaxZoom, scfg.Bounds = s.MinZoom, s.MaxZoom, &s.Bounds
+		r, err := pmtiles.OpenReader(runPipeline(t, scfg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		readers = append(readers, r)
+	}
+	mergedPath := filepath.Join(t.TempDir(), "merged.pmtiles")
+	w, err := pmtiles.NewWriter(mergedPath, pmtiles.WriterOptions{
+		MinZoom: 6, MaxZoom: 10, Bounds: bounds, TileFormat: pmtiles.TileTypePNG, TileSize: 256,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pmtiles.Merge(w, readers); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if n := w.DuplicateTiles(); n > 0 {
+		t.Errorf("%d tiles are in more than one shard", n)
+	}
+
+	want, err := pmtiles.OpenReader(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer want.Close()
+	got, err := pmtiles.OpenReader(mergedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer got.Close()
+	for z := 6; z <= 10; z++ {
+		wantTiles, gotTiles := want.TilesAtZoom(z), got.TilesAtZoom(z)
+		if !reflect.DeepEqual(gotTiles, wantTiles) {
+			t.Errorf("zoom %d: merged tiles %v, want %v", z, gotTiles, wantTiles)
+			continue
+		}
+		if z < 8 {
+			continue
+		}
+		for _, tc := range wantTiles {
+			a, _ := want.ReadTile(tc[0], tc[1], tc[2])
+			b, _ := got.ReadTile(tc[0], tc[1], tc[2])
+			if !bytes.Equal(a, b) {
+				t.Errorf("tile %v differs from the unsharded run", tc)
+			}
+		}
+	}
+}
+
+// TestEndToEndSpecDecoder runs the full Generate→Finalize pipeline on a tiny
+// two-colour GeoTIFF and reads the result back with specArchive, a decoder
+// written from the PMTiles v3 spec rather than internal/pmtiles. It checks
+// the header, that every addressed tile matches the in-repo reader byte for
+// byte, and pixel colours on both sides of the colour boundary.
+func TestEndToEndSpecDecoder(t *testing.T) {
+	red := color.RGBA{200, 30, 30, 255}
+	blue := color.RGBA{30, 30, 200, 255}
+	tiffPath := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512,
+		SamplesPerPixel: 3,
+		BitsPerSample:   8,
+		OriginLon:       7.0,
+		OriginLat:       47.0,
+		PixelSizeDeg:    0.01,
+		EPSG:            4326,
+		PixelFunc: func(x, y, band int) uint16 {
+			c := red
+			if x >= 256 {
+				c = blue
+			}
+			return uint16([]uint8{c.R, c.G, c.B}[band])
+		},
+	})
+
+	outPath := runPipeline(t, pipelineConfig{
+		InputPaths: []string{tiffPath},
+		Format:     "png",
+		MinZoom:    3,
+		MaxZoom:    7,
+	})
+
+	a := openSpecArchive(t, outPath)
+	if a.tileType != 2 { // png
+		t.Errorf("tile type = %d, want 2 (png)", a.tileType)
+	}
+	if a.minZoom != 3 || a.maxZoom != 7 {
+		t.Errorf("zoom range = %d-%d, want 3-7", a.minZoom, a.maxZoom)
+	}
+	const eps = 1e-5 // E7 fixed point plus float rounding
+	if a.minLon > 7+eps || a.maxLon < 12.12-eps || a.minLat > 41.88+eps || a.maxLat < 47-eps {
+		t.Errorf("header bounds [%f %f %f %f] do not cover the source [7 41.88 12.12 47]",
+			a.minLon, a.minLat, a.maxLon, a.maxLat)
+	}
+	if _, ok := a.metadata(t)["format"]; !ok {
+		t.Error("metadata: missing format")
+	}
+
+	var addressed uint64
+	for _, e := range a.entries(t) {
+		addressed += uint64(e.run)
+	}
+	if addressed != a.addressedTiles {
+		t.Errorf("directory entries address %d tiles, header says %d", addressed, a.addressedTiles)
+	}
+
+	reader, err := pmtiles.OpenReader(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	n := 0
+	for z := 3; z <= 7; z++ {
+		for _, zxy := range reader.TilesAtZoom(z) {
+			want, err := reader.ReadTile(zxy[0], zxy[1], zxy[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := a.tile(t, zxy[0], zxy[1], zxy[2]); !bytes.Equal(got, want) {
+				t.Errorf("tile %v: spec decoder returned %d bytes, in-repo reader %d", zxy, len(got), len(want))
+			}
+			n++
+		}
+	}
+	if uint64(n) != a.addressedTiles {
+		t.Errorf("in-repo reader lists %d tiles, header says %d", n, a.addressedTiles)
+	}
+
+	// Sample one point well inside each colour half at max zoom.
+	for _, p := range []struct {
+		lon, lat float64
+		want     color.RGBA
+	}{
+		{8.0, 44.5, red},
+		{11.0, 44.5, blue},
+	} {
+		const z, size = 7, 256
+		n := math.Exp2(z)
+		fx := (p.lon + 180) / 360 * n
+		latRad := p.lat * math.Pi / 180
+		fy := (1 - math.Log(math.Tan(latRad)+1/math.Cos(latRad))/math.Pi) / 2 * n
+		x, y := int(fx), int(fy)
+
+		data := a.tile(t, z, x, y)
+		if data == nil {
+			t.Fatalf("tile %d/%d/%d not found", z, x, y)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("tile %d/%d/%d: %v", z, x, y, err)
+		}
+		px, py := int((fx-float64(x))*size), int((fy-float64(y))*size)
+		r, g, b, alpha := img.At(px, py).RGBA()
+		got := color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(alpha >> 8)}
+		if got != p.want {
+			t.Errorf("(%.1f, %.1f) → tile %d/%d/%d pixel (%d,%d) = %v, want %v",
+				p.lon, p.lat, z, x, y, px, py, got, p.want)
+		}
+	}
+}
+
+// TestRGBAPoolBalanced runs the full pipeline with the RGBA pool's leak
+// check enabled and verifies every pooled image is returned: rendered tiles,
+// decoded children, and downsampled parents. A missing Release shows up in
+// long runs only as RSS growth, so it is caught here instead.
+func TestRGBAPoolBalanced(t *testing.T) {
+	tiffPath := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512,
+		SamplesPerPixel: 3,
+		BitsPerSample:   8,
+		OriginLon:       7.0,
+		OriginLat:       47.0,
+		PixelSizeDeg:    0.01,
+		EPSG:            4326,
+		PixelFunc: func(x, y, band int) uint16 {
+			// A gradient keeps tiles non-uniform, so they hold pooled images.
+			return uint16((x + 2*y + 50*band) % 256)
+		},
+	})
+
+	tile.SetRGBALeakCheck(true)
+	defer tile.SetRGBALeakCheck(false)
+
+	runPipeline(t, pipelineConfig{
+		InputPaths: []string{tiffPath},
+		Format:     "png",
+		MinZoom:    3,
+		MaxZoom:    7,
+		MemLimitMB: 1, // also exercise spilled and prefetched children
+	})
+
+	leaks, _ := tile.RGBALeaks()
+	for _, l := range leaks {
+		t.Errorf("%d pooled image(s) not returned, taken at %s", l.Count, l.Site)
+	}
+}
+
+// TestAbuttingSourcesNoSeam renders two sources separated by a sub-pixel
+// gap (tie points that differ by rounding). Output pixel centers that fall
+// in the gap must be filled from a neighbor instead of left transparent,
+// while the outer edge of a lone source stays sharp.
+func TestAbuttingSourcesNoSeam(t *testing.T) {
+	const z = 8
+	ps := 360.0 / float64(256<<z) // one output pixel at z8, in degrees
+	solid := func(band0, band2 uint16) func(x, y, band int) uint16 {
+		return func(x, y, band int) uint16 {
+			switch band {
+			case 0:
+				return band0
+			case 2:
+				return band2
+			}
+			return 0
+		}
+	}
+
+	// Tile x=129 at z8 starts at lon 1.40625. The red source ends 0.4 px
+	// past it and the blue one starts 0.6 px past it, so the first column
+	// of tile 129 (center 0.5 px) lies in the gap.
+	red := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256,
+		OriginLon:    0.4 * ps,
+		OriginLat:    1.0,
+		PixelSizeDeg: ps,
+		PixelFunc:    solid(255, 0),
+	})
+	blue := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256,
+		OriginLon:    1.40625 + 0.6*ps,
+		OriginLat:    1.0,
+		PixelSizeDeg: ps,
+		PixelFunc:    solid(0, 255),
+	})
+
+	for _, mode := range []string{"nearest", "bilinear", "bicubic", "lanczos"} {
+		t.Run(mode, func(t *testing.T) {
+			out := runPipeline(t, pipelineConfig{
+				InputPaths: []string{red, blue},
+				MinZoom:    z,
+				MaxZoom:    z,
+				Resampling: mode,
+			})
+			img := assertTileDecodesAsImage(t, out, z, 129, 127)
+			for py := 96; py < 256; py++ {
+				if _, _, _, a := img.At(0, py).RGBA(); a>>8 != 255 {
+					t.Fatalf("seam pixel (0,%d): alpha %d, want 255", py, a>>8)
+				}
+			}
+		})
+	}
+
+	// A lone source ending 0.4 px past the edge of column 200 leaves that
+	// column transparent: only seams between sources are closed.
+	lone := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 200, Height: 256,
+		OriginLon:    0.4 * ps,
+		OriginLat:    1.0,
+		PixelSizeDeg: ps,
+		PixelFunc:    solid(255, 0),
+	})
+	out := runPipeline(t, pipelineConfig{
+		InputPaths: []string{lone},
+		MinZoom:    z,
+		MaxZoom:    z,
+	})
+	assertTilePixel(t, out, z, 128, 127, 199, 128, 255, 0, 0, 255, 0)
+	assertTilePixel(t, out, z, 128, 127, 200, 128, 0, 0, 0, 0, 0)
+}
+
+// TestModeResamplingAtMaxZoom renders a source at four source pixels per
+// output pixel where every fourth column is blue, placed so that it is the
+// column nearest each output pixel center. Nearest therefore renders blue,
+// while mode must vote over the whole footprint and keep the red majority.
+func TestModeResamplingAtMaxZoom(t *testing.T) {
+	const z = 6
+	ps := 360.0 / float64(256<<z) / 4
+	src := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 1024, Height: 1024,
+		OriginLon:    0,
+		OriginLat:    5.0,
+		PixelSizeDeg: ps,
+		PixelFunc: func(x, y, band int) uint16 {
+			blue := x%4 == 2
+			switch {
+			case band == 0 && !blue, band == 2 && blue:
+				return 255
+			}
+			return 0
+		},
+	})
+
+	countBlue := func(mode string) int {
+		out := runPipeline(t, pipelineConfig{
+			InputPaths: []string{src},
+			MinZoom:    z,
+			MaxZoom:    z,
+			Resampling: mode,
+		})
+		img := assertTileDecodesAsImage(t, out, z, 32, 31)
+		blue := 0
+		for py := 64; py < 256; py++ {
+			for px := 0; px < 256; px++ {
+				if r, _, b, a := img.At(px, py).RGBA(); a>>8 == 255 && b>>8 == 255 && r == 0 {
+					blue++
+				}
+			}
+		}
+		return blue
+	}
+
+	if n := countBlue("nearest"); n == 0 {
+		t.Fatal("nearest: no blue pixels; the pattern is not aligned with the output pixels")
+	}
+	if n := countBlue("mode"); n != 0 {
+		t.Errorf("mode: %d blue pixels, want 0 (red is the majority in every footprint)", n)
+	}
+}
+
+// TestTileFilter pipes every tile through a command that logs its z/x/y and
+// replaces it with a solid red PNG. The filter must run once per written
+// tile, at every zoom, and the archive must hold its output.
+func TestTileFilter(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	tiffPath := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256,
+		OriginLon:    -25.0,
+		OriginLat:    70.0,
+		PixelSizeDeg: 0.1,
+	})
+
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+3] = 255, 255
+	}
+	var red bytes.Buffer
+	if err := png.Encode(&red, img); err != nil {
+		t.Fatal(err)
+	}
+	redPath := filepath.Join(t.TempDir(), "red.png")
+	if err := os.WriteFile(redPath, red.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	logPath := filepath.Join(t.TempDir(), "tiles.log")
+
+	outPath := runPipeline(t, pipelineConfig{
+		InputPaths: []string{tiffPath},
+		Format:     "png",
+		MinZoom:    0,
+		MaxZoom:    2,
+		TileFilter: fmt.Sprintf(`cat >/dev/null; echo "$TILE_Z/$TILE_X/$TILE_Y" >>%q; cat %q`, logPath, redPath),
+	})
+
+	result := validatePMTiles(t, outPath)
+	logged, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(logged), "\n"); n != result.TileCount {
+		t.Errorf("filter ran %d times, archive has %d tiles", n, result.TileCount)
+	}
+	for z := 0; z <= 2; z++ {
+		if result.ZoomCounts[z] == 0 {
+			t.Fatalf("zoom %d: no tiles", z)
+		}
+	}
+	assertTilePixel(t, outPath, 0, 0, 0, 0, 0, 255, 0, 0, 255, 0)
+}
+
+// TestOverlapPrefersFinestSource mosaics a coarse red source listed first
+// with a finer blue source inside it. Where they overlap the blue source
+// must win, the red one must still fill the rest of the tile, and
+// InputOrder must restore file-order priority.
+func TestOverlapPrefersFinestSource(t *testing.T) {
+	coarse := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256,
+		OriginLon:    -25.0,
+		OriginLat:    70.0,
+		PixelSizeDeg: 0.1,
+		PixelFunc: func(x, y, band int) uint16 {
+			if band == 0 {
+				return 255
+			}
+			return 0
+		},
+	})
+	fine := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256,
+		OriginLon:    -15.0,
+		OriginLat:    60.0,
+		PixelSizeDeg: 0.025,
+		PixelFunc: func(x, y, band int) uint16 {
+			if band == 2 {
+				return 255
+			}
+			return 0
+		},
+	})
+
+	const z = 5
+	inFine := [2]float64{-11.8, 56.8}
+	inCoarse := [2]float64{-20.0, 48.0}
+	pixelAt := func(lonLat [2]float64) (tx, ty, px, py int) {
+		tx, ty = coord.LonLatToTile(lonLat[0], lonLat[1], z)
+		fx, fy := coord.TilePixelCoords(lonLat[0], lonLat[1], z, tx, ty, 256)
+		return tx, ty, int(fx), int(fy)
+	}
+
+	for _, inputOrder := range []bool{false, true} {
+		out := runPipeline(t, pipelineConfig{
+			InputPaths: []string{coarse, fine},
+			MinZoom:    z,
+			MaxZoom:    z,
+			Resampling: "nearest",
+			InputOrder: inputOrder,
+		})
+		tx, ty, px, py := pixelAt(inFine)
+		if inputOrder {
+			assertTilePixel(t, out, z, tx, ty, px, py, 255, 0, 0, 255, 0)
+		} else {
+			assertTilePixel(t, out, z, tx, ty, px, py, 0, 0, 255, 255, 0)
+		}
+		tx, ty, px, py = pixelAt(inCoarse)
+		assertTilePixel(t, out, z, tx, ty, px, py, 255, 0, 0, 255, 0)
+	}
+}
+
+// TestVRTMosaic converts a GDAL VRT that places two tiles into one grid. The
+// tiles' own georeferencing is deliberately wrong: the VRT's placement wins,
+// and where the sources overlap the later one is drawn on top, as in GDAL.
+func TestVRTMosaic(t *testing.T) {
+	solid := func(band0 int) func(x, y, band int) uint16 {
+		return func(x, y, band int) uint16 {
+			if band == band0 {
+				return 255
+			}
+			return 0
+		}
+	}
+	red := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256, OriginLon: 100, OriginLat: -10, PixelSizeDeg: 1, PixelFunc: solid(0),
+	})
+	blue := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256, OriginLon: 100, OriginLat: -10, PixelSizeDeg: 1, PixelFunc: solid(2),
+	})
+
+	source := func(path string, dstX int) string {
+		return fmt.Sprintf(`    <SimpleSource>
+      <SourceFilename relativeToVRT="0">%s</SourceFilename>
+      <SourceBand>1</SourceBand>
+      <SrcRect xOff="0" yOff="0" xSize="256" ySize="256"/>
+      <DstRect xOff="%d" yOff="0" xSize="256" ySize="256"/>
+    </SimpleSource>
+`, path, dstX)
+	}
+	vrt := `<VRTDataset rasterXSize="384" rasterYSize="256">
+  <SRS>GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563]],AUTHORITY["EPSG","4326"]]</SRS>
+  <GeoTransform>-25.0, 0.1, 0.0, 70.0, 0.0, -0.1</GeoTransform>
+  <VRTRasterBand dataType="Byte" band="1">
+` + source(red, 0) + source(blue, 128) + `  </VRTRasterBand>
+</VRTDataset>
+`
+	vrtPath := filepath.Join(t.TempDir(), "mosaic.vrt")
+	if err := os.WriteFile(vrtPath, []byte(vrt), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	const z = 5
+	out := runPipeline(t, pipelineConfig{
+		InputPaths: []string{vrtPath},
+		MinZoom:    z,
+		MaxZoom:    z,
+		Resampling: "nearest",
+	})
+	assertPlausiblePMTiles(t, out, plausibilityExpectation{
+		MinZoom: z, MaxZoom: z, TileType: pmtiles.TileTypePNG,
+		MinLon: -25, MaxLon: 13.4, MinLat: 44.4, MaxLat: 70, BoundsTol: 0.5,
+		MinTotalTiles: 1,
+	})
+	for _, c := range []struct {
+		lon, lat float64
+		r, b     uint8
+	}{
+		{-20, 60, 255, 0}, // red only
+		{-5, 60, 0, 255},  // overlap: blue is listed later
+		{5, 60, 0, 255},   // blue only
+	} {
+		tx, ty := coord.LonLatToTile(c.lon, c.lat, z)
+		fx, fy := coord.TilePixelCoords(c.lon, c.lat, z, tx, ty, 256)
+		assertTilePixel(t, out, z, tx, ty, int(fx), int(fy), c.r, 0, c.b, 255, 0)
+	}
+}
+
+// TestLayersMatchSeparateRuns checks that generating imagery and Terrarium
+// terrain in one GenerateLayers pass produces the same archives as two
+// separate runs.
+func TestLayersMatchSeparateRuns(t *testing.T) {
+	ortho := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512, OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.01,
+		PixelFunc: func(x, y, band int) uint16 { return uint16((x*(band+1) + y) % 256) },
+	})
+	dem := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256, OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.02,
+		FloatFunc: func(x, y int) float32 { return 400 + float32(x)*3.5 - float32(y)*1.25 },
+	})
+
+	for _, levelByLevel := range []bool{false, true} {
+		imagery := pipelineConfig{InputPaths: []string{ortho}, MinZoom: 5, MaxZoom: 8, LevelByLevel: levelByLevel}
+		terrain := pipelineConfig{InputPaths: []string{dem}, Format: "terrarium", MinZoom: 5, MaxZoom: 8, LevelByLevel: levelByLevel}
+
+		paths := runLayersPipeline(t, imagery, terrain)
+		assertArchivesIdentical(t, runPipeline(t, imagery), paths[0])
+		assertArchivesIdentical(t, runPipeline(t, terrain), paths[1])
+	}
+}
+
+// TestTerrainRGBMatchesTerrarium generates a DEM as Terrain-RGB and as
+// Terrarium: every tile decodes to the same elevations within the rounding
+// to the 0.1 m Terrain-RGB step, which adds up to 0.05 m per level in the
+// parents, as those are averaged in elevation space rather than per channel.
+func TestTerrainRGBMatchesTerrarium(t *testing.T) {
+	dem := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256, OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.02,
+		FloatFunc: func(x, y int) float32 { return 400 + float32(x)*13.7 - float32(y)*5.3 },
+	})
+	cfg := pipelineConfig{InputPaths: []string{dem}, Format: "terrarium", MinZoom: 4, MaxZoom: 7}
+	terrarium, err := pmtiles.OpenReader(runPipeline(t, cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer terrarium.Close()
+	cfg.Format = "terrainrgb"
+	terrainRGB, err := pmtiles.OpenReader(runPipeline(t, cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer terrainRGB.Close()
+
+	decode := func(r *pmtiles.Reader, z, x, y int) image.Image {
+		t.Helper()
+		data, err := r.ReadTile(z, x, y)
+		if err != nil || data == nil {
+			t.Fatalf("tile %d/%d/%d: %v (%d bytes)", z, x, y, err, len(data))
+		}
+		img, err := encode.DecodeImage(data, "png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+	for z := cfg.MinZoom; z <= cfg.MaxZoom; z++ {
+		n, tolerance := 0, 0.05*float64(cfg.MaxZoom-z+1)+0.01
+		err := terrarium.ForEachTileAtZoom(z, func(x, y int) error {
+			n++
+			want, got := decode(terrarium, z, x, y), decode(terrainRGB, z, x, y)
+			b := want.Bounds()
+			for py := b.Min.Y; py < b.Max.Y; py++ {
+				for px := b.Min.X; px < b.Max.X; px++ {
+					wp := color.RGBAModel.Convert(want.At(px, py)).(color.RGBA)
+					gp := color.RGBAModel.Convert(got.At(px, py)).(color.RGBA)
+					if (wp.A == 0) != (gp.A == 0) {
+						t.Fatalf("tile %d/%d/%d pixel (%d,%d): alpha %d, want %d", z, x, y, px, py, gp.A, wp.A)
+					}
+					if wp.A == 0 {
+						continue
+					}
+					we, ge := encode.TerrariumToElevation(wp), encode.TerrainRGBToElevation(gp)
+					if math.Abs(we-ge) > tolerance {
+						t.Fatalf("tile %d/%d/%d pixel (%d,%d): %.3f m, want %.3f m", z, x, y, px, py, ge, we)
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			t.Fatalf("no tiles at zoom %d", z)
+		}
+	}
+}
+
+// TestIncrementalMatchesFullRun replaces one of two abutting sources and
+// regenerates only its footprint, copying the other tiles from the first
+// archive; the result must match a full run over the new inputs.
+func TestIncrementalMatchesFullRun(t *testing.T) {
+	pattern := func(seed int) func(x, y, band int) uint16 {
+		return func(x, y, band int) uint16 { return uint16((x*(band+seed) + y*seed) % 256) }
+	}
+	west := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512, OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.005, PixelFunc: pattern(1),
+	})
+	eastV1 := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512, OriginLon: 10.56, OriginLat: 47, PixelSizeDeg: 0.005, PixelFunc: pattern(2),
+	})
+	eastV2 := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512, OriginLon: 10.56, OriginLat: 47, PixelSizeDeg: 0.005, PixelFunc: pattern(3),
+	})
+
+	const minZoom, maxZoom = 5, 9
+	src, err := cog.Open(eastV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	region := cog.MergedBoundsWGS84([]*cog.Reader{src})
+	src.Close()
+	pad := 360 / math.Exp2(maxZoom)
+	region.MinLon, region.MaxLon = region.MinLon-pad, region.MaxLon+pad
+	region.MinLat, region.MaxLat = region.MinLat-pad, region.MaxLat+pad
+
+	for _, levelByLevel := range []bool{false, true} {
+		before := runPipeline(t, pipelineConfig{
+			InputPaths: []string{west, eastV1}, MinZoom: minZoom, MaxZoom: maxZoom, LevelByLevel: levelByLevel,
+		})
+		after := pipelineConfig{InputPaths: []string{west, eastV2}, MinZoom: minZoom, MaxZoom: maxZoom, LevelByLevel: levelByLevel}
+		full := runPipeline(t, after)
+		after.Previous = before
+		after.Regions = []cog.Bounds{region}
+		assertArchivesIdentical(t, full, runPipeline(t, after))
+	}
+}
+
+// TestDirtySetRegeneratesAncestors checks that marking only the changed
+// max-zoom tiles in a tile.DirtySet regenerates their parents up to the
+// minimum zoom: the patched archive matches a full run.
+func TestDirtySetRegeneratesAncestors(t *testing.T) {
+	pattern := func(seed int) func(x, y, band int) uint16 {
+		return func(x, y, band int) uint16 { return uint16((x*(band+seed) + y*seed) % 256) }
+	}
+	west := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512, OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.005, PixelFunc: pattern(1),
+	})
+	eastV1 := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512, OriginLon: 10.56, OriginLat: 47, PixelSizeDeg: 0.005, PixelFunc: pattern(2),
+	})
+	eastV2 := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512, OriginLon: 10.56, OriginLat: 47, PixelSizeDeg: 0.005, PixelFunc: pattern(3),
+	})
+
+	const minZoom, maxZoom = 5, 9
+	src, err := cog.Open(eastV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	region := cog.MergedBoundsWGS84([]*cog.Reader{src})
+	src.Close()
+	pad := 360 / math.Exp2(maxZoom)
+	region.MinLon, region.MaxLon = region.MinLon-pad, region.MaxLon+pad
+	region.MinLat, region.MaxLat = region.MinLat-pad, region.MaxLat+pad
+	changed := tile.RegionTiles(maxZoom, []cog.Bounds{region})
+
+	for _, levelByLevel := range []bool{false, true} {
+		before := runPipeline(t, pipelineConfig{
+			InputPaths: []string{west, eastV1}, MinZoom: minZoom, MaxZoom: maxZoom, LevelByLevel: levelByLevel,
+		})
+		after := pipelineConfig{InputPaths: []string{west, eastV2}, MinZoom: minZoom, MaxZoom: maxZoom, LevelByLevel: levelByLevel}
+		full := runPipeline(t, after)
+		after.Previous = before
+		after.Dirty = changed
+		assertArchivesIdentical(t, full, runPipeline(t, after))
+	}
+}
+
+// TestSharpenLowZooms checks that --sharpen changes only the parent tiles
+// of its zoom range and gives them more contrast than plain averaging.
+func TestSharpenLowZooms(t *testing.T) {
+	// Coarse bands that survive downsampling plus pixel-level texture that
+	// each 2×2 average removes.
+	input := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512, OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.005,
+		PixelFunc: func(x, y, band int) uint16 { return uint16(80 + 60*((x/16)%2) + 40*((x+y)%2)) },
+	})
+	cfg := pipelineConfig{InputPaths: []string{input}, MinZoom: 5, MaxZoom: 8, Resampling: "bilinear"}
+	plain := runPipeline(t, cfg)
+	cfg.Sharpen = []tile.SharpenRange{{MinZoom: 5, MaxZoom: 6, Strength: 1}}
+	sharp := runPipeline(t, cfg)
+
+	rp, err := pmtiles.OpenReader(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rp.Close()
+	rs, err := pmtiles.OpenReader(sharp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+
+	// stddev returns the standard deviation of the red channel over the
+	// opaque pixels of a tile.
+	stddev := func(path string, z, x, y int) float64 {
+		img := assertTileDecodesAsImage(t, path, z, x, y)
+		var sum, sum2, n float64
+		b := img.Bounds()
+		for py := b.Min.Y; py < b.Max.Y; py++ {
+			for px := b.Min.X; px < b.Max.X; px++ {
+				r, _, _, a := img.At(px, py).RGBA()
+				if a == 0 {
+					continue
+				}
+				v := float64(r >> 8)
+				sum += v
+				sum2 += v * v
+				n++
+			}
+		}
+		mean := sum / n
+		return math.Sqrt(sum2/n - mean*mean)
+	}
+
+	for z := 5; z <= 8; z++ {
+		for _, tc := range rp.TilesAtZoom(z) {
+			dp, err := rp.ReadTile(tc[0], tc[1], tc[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			ds, err := rs.ReadTile(tc[0], tc[1], tc[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if z > 6 {
+				if !bytes.Equal(dp, ds) {
+					t.Errorf("tile %v outside the sharpen range differs", tc)
+				}
+				continue
+			}
+			if p, s := stddev(plain, tc[0], tc[1], tc[2]), stddev(sharp, tc[0], tc[1], tc[2]); s <= p {
+				t.Errorf("tile %v: sharpened stddev %.2f, want more than plain %.2f", tc, s, p)
+			}
+		}
+	}
+}
+
+// TestZoomOffset checks that --zoom-offset stores every tile of a normal
+// run one zoom up, with the header and metadata zooms to match.
+func TestZoomOffset(t *testing.T) {
+	input := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512, OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.005,
+		PixelFunc: func(x, y, band int) uint16 { return uint16((x + 2*y + 50*band) % 256) },
+	})
+	cfg := pipelineConfig{InputPaths: []string{input}, MinZoom: 5, MaxZoom: 8}
+	plain := runPipeline(t, cfg)
+	cfg.ZoomOffset = 1
+	shifted := runPipeline(t, cfg)
+
+	rp, err := pmtiles.OpenReader(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rp.Close()
+	rs, err := pmtiles.OpenReader(shifted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+
+	if h := rs.Header(); h.MinZoom != 6 || h.MaxZoom != 9 {
+		t.Errorf("header zooms %d-%d, want 6-9", h.MinZoom, h.MaxZoom)
+	}
+	meta, err := rs.ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta["minzoom"] != "6" || meta["maxzoom"] != "9" {
+		t.Errorf("metadata zooms %v-%v, want 6-9", meta["minzoom"], meta["maxzoom"])
+	}
+	if n := len(rs.TilesAtZoom(5)); n != 0 {
+		t.Errorf("%d tiles at zoom 5, want none", n)
+	}
+	for z := 5; z <= 8; z++ {
+		tiles := rp.TilesAtZoom(z)
+		if got := rs.TilesAtZoom(z + 1); len(got) != len(tiles) {
+			t.Fatalf("zoom %d: %d tiles stored as zoom %d, want %d", z, len(got), z+1, len(tiles))
+		}
+		for _, tc := range tiles {
+			want, err := rp.ReadTile(tc[0], tc[1], tc[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := rs.ReadTile(tc[0]+1, tc[1], tc[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("tile %v stored as zoom %d differs", tc, z+1)
+			}
+		}
+	}
+}
+
+// TestDateSeriesMatchesSeparateRuns checks that two acquisition dates of
+// overlapping imagery, generated as layers of one pass as --split-by-date
+// does, give the same archives as two separate runs.
+func TestDateSeriesMatchesSeparateRuns(t *testing.T) {
+	y2019 := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512, OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.01,
+		PixelFunc: func(x, y, band int) uint16 { return uint16((x*(band+1) + y) % 256) },
+	})
+	y2022 := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 512, OriginLon: 10, OriginLat: 46, PixelSizeDeg: 0.01,
+		PixelFunc: func(x, y, band int) uint16 { return uint16((2*x + y*(band+2)) % 256) },
+	})
+
+	first := pipelineConfig{InputPaths: []string{y2019}, MinZoom: 5, MaxZoom: 8}
+	second := pipelineConfig{InputPaths: []string{y2022}, MinZoom: 5, MaxZoom: 8}
+	paths := runLayersPipeline(t, first, second)
+	assertArchivesIdentical(t, runPipeline(t, first), paths[0])
+	assertArchivesIdentical(t, runPipeline(t, second), paths[1])
+}
+
+// TestProjectedSources converts rasters in a UTM zone and in LAEA Europe,
+// which come from the EPSG table, and checks that the archive bounds are
+// the projected footprint and that the left (red) half of the raster lands
+// west of the right (blue) half.
+func TestProjectedSources(t *testing.T) {
+	for _, tc := range []struct {
+		epsg             int
+		originX, originY float64
+	}{
+		{25832, 480000, 5400000},  // ETRS89 / UTM 32N, southern Germany
+		{3035, 4300000, 2800000},  // LAEA Europe, Switzerland
+		{5070, -2000000, 2000000}, // Conus Albers, Oregon
+	} {
+		const size, pixel = 512, 20.0 // 10.24 km
+		tiffPath := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+			Width: size, Height: size,
+			SamplesPerPixel: 3,
+			BitsPerSample:   8,
+			OriginLon:       tc.originX,
+			OriginLat:       tc.originY,
+			PixelSizeDeg:    pixel,
+			EPSG:            tc.epsg,
+			PixelFunc: func(x, y, band int) uint16 {
+				if (x < size/2 && band == 0) || (x >= size/2 && band == 2) {
+					return 255
+				}
+				return 0
+			},
+		})
+		outPath := runPipeline(t, pipelineConfig{
+			InputPaths: []string{tiffPath},
+			Format:     "png",
+			MinZoom:    10,
+			MaxZoom:    12,
+		})
+		h := validatePMTiles(t, outPath).Header
+
+		proj := coord.ForEPSG(tc.epsg)
+		var want cog.Bounds
+		want.MinLon, want.MinLat, want.MaxLon, want.MaxLat = 180, 90, -180, -90
+		for _, c := range [][2]float64{{0, 0}, {0, 1}, {1, 0}, {1, 1}} {
+			lon, lat := proj.ToWGS84(tc.originX+c[0]*size*pixel, tc.originY-c[1]*size*pixel)
+			want.MinLon, want.MaxLon = math.Min(want.MinLon, lon), math.Max(want.MaxLon, lon)
+			want.MinLat, want.MaxLat = math.Min(want.MinLat, lat), math.Max(want.MaxLat, lat)
+		}
+		got := [4]float64{float64(h.MinLon), float64(h.MinLat), float64(h.MaxLon), float64(h.MaxLat)}
+		for i, w := range [4]float64{want.MinLon, want.MinLat, want.MaxLon, want.MaxLat} {
+			if math.Abs(got[i]-w) > 1e-3 {
+				t.Errorf("EPSG:%d: bounds %v, want %+v", tc.epsg, got, want)
+				break
+			}
+		}
+
+		// Probe a quarter of the width in from each side, at mid-height.
+		for _, p := range []struct {
+			fx      float64
+			r, g, b uint8
+		}{{0.25, 255, 0, 0}, {0.75, 0, 0, 255}} {
+			lon, lat := proj.ToWGS84(tc.originX+p.fx*size*pixel, tc.originY-0.5*size*pixel)
+			x, y := coord.LonLatToTile(lon, lat, 12)
+			px, py := coord.TilePixelCoords(lon, lat, 12, x, y, 256)
+			assertTilePixel(t, outPath, 12, x, y, int(px), int(py), p.r, p.g, p.b, 255, 8)
+		}
+	}
+}
+
+// TestWKTSource checks that a user-defined CRS (ProjectedCSTypeGeoKey 32767)
+// is read from the ESRI WKT in GeoAsciiParams: the archive matches the one
+// from the same raster tagged with the equivalent EPSG code.
+func TestWKTSource(t *testing.T) {
+	const wkt = `PROJCS["ETRS_1989_UTM_Zone_32N",GEOGCS["GCS_ETRS_1989",DATUM["D_ETRS_1989",` +
+		`SPHEROID["GRS_1980",6378137.0,298.257222101]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]],` +
+		`PROJECTION["Transverse_Mercator"],PARAMETER["False_Easting",500000.0],PARAMETER["False_Northing",0.0],` +
+		`PARAMETER["Central_Meridian",9.0],PARAMETER["Scale_Factor",0.9996],PARAMETER["Latitude_Of_Origin",0.0],` +
+		`UNIT["Meter",1.0]]`
+	var outs []string
+	for _, epsg := range []int{25832, coord.UserDefined} {
+		const size = 512
+		tiffPath := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+			Width: size, Height: size,
+			SamplesPerPixel: 3,
+			BitsPerSample:   8,
+			OriginLon:       480000,
+			OriginLat:       5400000,
+			PixelSizeDeg:    20,
+			EPSG:            epsg,
+			WKT:             wkt,
+			PixelFunc: func(x, y, band int) uint16 {
+				return uint16((x*7 + y*3 + band*50) % 256)
+			},
+		})
+		outs = append(outs, runPipeline(t, pipelineConfig{
+			InputPaths: []string{tiffPath},
+			Format:     "png",
+			MinZoom:    10,
+			MaxZoom:    12,
+		}))
+	}
+	assertArchivesIdentical(t, outs[0], outs[1])
+}
+
+// TestContourOutput generates contour MVT tiles from a float DEM and checks
+// the archive declares gzip-compressed MVT and every tile holds the
+// contour layer.
+func TestContourOutput(t *testing.T) {
+	dem := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256, OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.02,
+		FloatFunc: func(x, y int) float32 { return 400 + float32(x)*3.5 - float32(y)*1.25 },
+	})
+	out := runPipeline(t, pipelineConfig{
+		InputPaths:       []string{dem},
+		Format:           "contours",
+		MinZoom:          5,
+		MaxZoom:          7,
+		ContourIntervals: []vector.IntervalRange{{MinZoom: 0, MaxZoom: 7, Interval: 100}},
+	})
+
+	res := validatePMTiles(t, out)
+	if res.Header.TileType != pmtiles.TileTypeMVT || res.Header.TileCompression != pmtiles.CompressionGzip {
+		t.Fatalf("tile type %d, compression %d; want MVT (%d), gzip (%d)",
+			res.Header.TileType, res.Header.TileCompression, pmtiles.TileTypeMVT, pmtiles.CompressionGzip)
+	}
+	if res.Metadata["format"] != "pbf" {
+		t.Errorf("metadata format = %v, want pbf", res.Metadata["format"])
+	}
+	if res.TileCount == 0 {
+		t.Fatal("no contour tiles written")
+	}
+
+	reader, err := pmtiles.OpenReader(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	for z := 5; z <= 7; z++ {
+		err := reader.ForEachTileAtZoom(z, func(x, y int) error {
+			data, err := reader.ReadTile(z, x, y)
+			if err != nil {
+				return err
+			}
+			zr, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return fmt.Errorf("tile %d/%d/%d: %w", z, x, y, err)
+			}
+			mvt, err := io.ReadAll(zr)
+			if err != nil {
+				return fmt.Errorf("tile %d/%d/%d: %w", z, x, y, err)
+			}
+			// One layer (field 3, bytes) named "contour" with "ele" values.
+			if len(mvt) == 0 || mvt[0] != 3<<3|2 || !bytes.Contains(mvt, []byte(vector.LayerName)) || !bytes.Contains(mvt, []byte("ele")) {
+				return fmt.Errorf("tile %d/%d/%d is not a contour MVT tile", z, x, y)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHillshadeOutput(t *testing.T) {
+	// A DEM falling 400 m per pixel (about 1.5 km) eastwards: every slope
+	// faces east, away from the default northwest light.
+	dem := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256, OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.02,
+		FloatFunc: func(x, y int) float32 { return 120000 - float32(x)*400 },
+	})
+	hs := tile.DefaultHillshade
+	out := runPipeline(t, pipelineConfig{
+		InputPaths: []string{dem},
+		Format:     "png",
+		MinZoom:    5,
+		MaxZoom:    7,
+		Hillshade:  &hs,
+	})
+
+	res := validatePMTiles(t, out)
+	if res.Header.TileType != pmtiles.TileTypePNG {
+		t.Fatalf("tile type %d, want PNG", res.Header.TileType)
+	}
+	flat := uint8(math.Round(255 * math.Sin(math.Pi/4)))
+	for z := 5; z <= 7; z++ {
+		x, y := coord.LonLatToTile(10.5, 44.5, z)
+		img := assertTileDecodesAsImage(t, out, z, x, y)
+		px, py := coord.TilePixelCoords(10.5, 44.5, z, x, y, img.Bounds().Dx())
+		r, g, bl, a := img.At(int(px), int(py)).RGBA()
+		if a>>8 != 255 || r != g || g != bl {
+			t.Fatalf("z%d: pixel = %d,%d,%d,%d, want opaque gray", z, r>>8, g>>8, bl>>8, a>>8)
+		}
+		if v := uint8(r >> 8); v >= flat-10 || v < 60 {
+			t.Errorf("z%d: shade %d, want clearly below flat ground's %d", z, v, flat)
+		}
+	}
+}
+
+func TestColorMap(t *testing.T) {
+	t.Run("discrete classes", func(t *testing.T) {
+		// Land cover: class 10 in the west half, class 20 in the east half.
+		src := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+			Width: 256, Height: 256, SamplesPerPixel: 1, BitsPerSample: 8,
+			OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.02,
+			PixelFunc: func(x, y, band int) uint16 {
+				if x < 128 {
+					return 10
+				}
+				return 20
+			},
+		})
+		cm, err := tile.ParseColorMap([]byte("10 255 0 0\n20 0 0 255\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cm.Discrete = true
+		out := runPipeline(t, pipelineConfig{
+			InputPaths: []string{src}, Format: "png", Resampling: "mode",
+			MinZoom: 5, MaxZoom: 7, ColorMap: cm,
+		})
+		for z := 5; z <= 7; z++ {
+			for _, c := range []struct {
+				lon  float64
+				want color.RGBA
+			}{{9.2, color.RGBA{255, 0, 0, 255}}, {11.8, color.RGBA{0, 0, 255, 255}}} {
+				x, y := coord.LonLatToTile(c.lon, 44.5, z)
+				img := assertTileDecodesAsImage(t, out, z, x, y)
+				px, py := coord.TilePixelCoords(c.lon, 44.5, z, x, y, img.Bounds().Dx())
+				if got := color.RGBAModel.Convert(img.At(int(px), int(py))); got != c.want {
+					t.Errorf("z%d lon %g: pixel %v, want %v", z, c.lon, got, c.want)
+				}
+			}
+		}
+	})
+
+	t.Run("interpolated float", func(t *testing.T) {
+		// NDVI rising from -1 in the west to 1 in the east.
+		src := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+			Width: 256, Height: 256, OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.02,
+			FloatFunc: func(x, y int) float32 { return float32(x)/127.5 - 1 },
+		})
+		cm, err := tile.ParseColorMap([]byte(`[[-1, "#000000"], [1, "#00ff00"]]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := runPipeline(t, pipelineConfig{
+			InputPaths: []string{src}, Format: "png", MinZoom: 6, MaxZoom: 6, ColorMap: cm,
+		})
+		// Three quarters across, NDVI 0.5 → green 191.
+		lon := 8 + 0.75*256*0.02
+		x, y := coord.LonLatToTile(lon, 44.5, 6)
+		img := assertTileDecodesAsImage(t, out, 6, x, y)
+		px, py := coord.TilePixelCoords(lon, 44.5, 6, x, y, img.Bounds().Dx())
+		got := color.RGBAModel.Convert(img.At(int(px), int(py))).(color.RGBA)
+		if got.R != 0 || got.B != 0 || got.A != 255 || got.G < 181 || got.G > 201 {
+			t.Errorf("pixel %v, want green about 191", got)
+		}
+	})
+}
+
+func TestNaNFallbackReport(t *testing.T) {
+	// A DEM with a nodata hole around pixel (130, 130): bilinear samples
+	// on its rim fall back to the nearest pixel.
+	dem := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256, OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.02, NoData: "-9999",
+		FloatFunc: func(x, y int) float32 {
+			if x >= 100 && x < 160 && y >= 100 && y < 160 {
+				return -9999
+			}
+			return 500 + float32(x)
+		},
+	})
+	var stats tile.Stats
+	runPipeline(t, pipelineConfig{
+		InputPaths: []string{dem}, Format: "terrarium", Resampling: "bilinear",
+		MinZoom: 5, MaxZoom: 7, NaNReport: 2, Stats: &stats,
+	})
+	if len(stats.NaNFallbacks) != 1 {
+		t.Fatalf("NaNFallbacks = %+v, want the max zoom only", stats.NaNFallbacks)
+	}
+	f := stats.NaNFallbacks[0]
+	if f.Zoom != 7 || f.Samples == 0 || f.Tiles == 0 || len(f.Worst) == 0 || len(f.Worst) > 2 {
+		t.Fatalf("NaNFallbacks = %+v", f)
+	}
+	if len(f.Worst) == 2 && f.Worst[0].Samples < f.Worst[1].Samples {
+		t.Errorf("Worst not sorted: %+v", f.Worst)
+	}
+	var sum int64
+	for _, w := range f.Worst {
+		sum += w.Samples
+		minLon, minLat, maxLon, maxLat := coord.TileBounds(w.Z, w.X, w.Y)
+		// The hole spans lon 10-11.2, lat 43.8-45.
+		if maxLon < 10 || minLon > 11.2 || maxLat < 43.8 || minLat > 45 {
+			t.Errorf("tile z%d/%d/%d does not touch the hole", w.Z, w.X, w.Y)
+		}
+	}
+	if sum > f.Samples {
+		t.Errorf("worst tiles have %d samples, more than the total %d", sum, f.Samples)
+	}
+
+	// Without nodata there is nothing to fall back from.
+	full := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256, OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.02,
+		FloatFunc: func(x, y int) float32 { return 500 + float32(x) },
+	})
+	stats = tile.Stats{}
+	runPipeline(t, pipelineConfig{
+		InputPaths: []string{full}, Format: "terrarium", Resampling: "bilinear",
+		MinZoom: 5, MaxZoom: 7, NaNReport: 2, Stats: &stats,
+	})
+	if len(stats.NaNFallbacks) != 0 {
+		t.Errorf("NaNFallbacks = %+v, want none", stats.NaNFallbacks)
+	}
+}
+
+func TestBilevelScan(t *testing.T) {
+	// A scanned map sheet: a black road (bit 0) across white paper.
+	src := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256, SamplesPerPixel: 1, BitsPerSample: 1,
+		OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.02,
+		PixelFunc: func(x, y, band int) uint16 {
+			if x >= 120 && x < 136 {
+				return 0
+			}
+			return 1
+		},
+	})
+	road := 8 + 128*0.02
+	for _, tt := range []struct {
+		name       string
+		cfg        cog.BandConfig
+		ink, paper color.RGBA
+	}{
+		{"default colors", cog.BandConfig{}, color.RGBA{0, 0, 0, 255}, color.RGBA{255, 255, 255, 255}},
+		{"red on transparent", cog.BandConfig{HasBilevelColors: true, BilevelColors: [2]color.RGBA{{255, 0, 0, 255}, {255, 255, 255, 0}}},
+			color.RGBA{255, 0, 0, 255}, color.RGBA{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out := runPipeline(t, pipelineConfig{
+				InputPaths: []string{src}, Format: "png", MinZoom: 7, MaxZoom: 7, BandCfg: tt.cfg,
+			})
+			for _, c := range []struct {
+				lon  float64
+				want color.RGBA
+			}{{road, tt.ink}, {9, tt.paper}} {
+				x, y := coord.LonLatToTile(c.lon, 44.5, 7)
+				img := assertTileDecodesAsImage(t, out, 7, x, y)
+				px, py := coord.TilePixelCoords(c.lon, 44.5, 7, x, y, img.Bounds().Dx())
+				if got := color.RGBAModel.Convert(img.At(int(px), int(py))); got != c.want {
+					t.Errorf("lon %g: pixel %v, want %v", c.lon, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSixteenBitBandSelection(t *testing.T) {
+	t.Run("percentile stretch of bands 4,3,2", func(t *testing.T) {
+		// Five 16-bit bands, band b (1-based) holding 1000·b + 10·x: a
+		// Sentinel-2-like stack whose true color is bands 4, 3, 2.
+		src := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+			Width: 256, Height: 256, SamplesPerPixel: 5, BitsPerSample: 16,
+			OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.02,
+			PixelFunc: func(x, y, band int) uint16 { return uint16(1000*(band+1) + 10*x) },
+		})
+		r, err := cog.Open(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := cog.BandConfig{Bands: [3]int{4, 3, 2}, AlphaBand: -1, Rescale: cog.RescaleLinear}
+		cfg.RescaleMin, cfg.RescaleMax, err = cog.Percentiles([]*cog.Reader{r}, cfg, 0, 100)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.RescaleMin != 2000 || cfg.RescaleMax != 6550 {
+			t.Fatalf("p0,p100 = %g,%g, want 2000,6550", cfg.RescaleMin, cfg.RescaleMax)
+		}
+
+		out := runPipeline(t, pipelineConfig{
+			InputPaths: []string{src}, Format: "png", MinZoom: 7, MaxZoom: 7, BandCfg: cfg,
+		})
+		// At x = 128: R = 5280, G = 4280, B = 3280 of [2000, 6550].
+		lon := 8 + 128.5*0.02
+		x, y := coord.LonLatToTile(lon, 44.5, 7)
+		img := assertTileDecodesAsImage(t, out, 7, x, y)
+		px, py := coord.TilePixelCoords(lon, 44.5, 7, x, y, img.Bounds().Dx())
+		got := color.RGBAModel.Convert(img.At(int(px), int(py))).(color.RGBA)
+		for i, c := range []struct {
+			got, want uint8
+		}{{got.R, 184}, {got.G, 128}, {got.B, 72}} {
+			if d := int(c.got) - int(c.want); d < -3 || d > 3 {
+				t.Errorf("channel %d = %d, want about %d (pixel %v)", i, c.got, c.want, got)
+			}
+		}
+	})
+
+	t.Run("signed int16", func(t *testing.T) {
+		// Backscatter-like int16 from -1000 to 1000, with a -9999 nodata
+		// stripe in the west.
+		nodata := int16(-9999)
+		src := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+			Width: 256, Height: 256, SamplesPerPixel: 1, BitsPerSample: 16, Signed: true, NoData: "-9999",
+			OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.02,
+			PixelFunc: func(x, y, band int) uint16 {
+				if x < 64 {
+					return uint16(nodata)
+				}
+				return uint16(int16(x*2000/255 - 1000))
+			},
+		})
+		out := runPipeline(t, pipelineConfig{
+			InputPaths: []string{src}, Format: "png", MinZoom: 7, MaxZoom: 7,
+			BandCfg: cog.BandConfig{Rescale: cog.RescaleLinear, RescaleMin: -1000, RescaleMax: 1000, HasNodata: true, Nodata: -9999},
+		})
+		for _, c := range []struct {
+			lon     float64
+			gray, a uint8
+		}{{8 + 30*0.02, 0, 0}, {8 + 128.5*0.02, 128, 255}} {
+			x, y := coord.LonLatToTile(c.lon, 44.5, 7)
+			img := assertTileDecodesAsImage(t, out, 7, x, y)
+			px, py := coord.TilePixelCoords(c.lon, 44.5, 7, x, y, img.Bounds().Dx())
+			got := color.RGBAModel.Convert(img.At(int(px), int(py))).(color.RGBA)
+			if got.A != c.a || got.R != got.G || got.G != got.B || int(got.R)-int(c.gray) < -3 || int(got.R)-int(c.gray) > 3 {
+				t.Errorf("lon %g: pixel %v, want gray %d alpha %d", c.lon, got, c.gray, c.a)
+			}
+		}
+	})
+}
+
+func TestIntegerNoDataOverride(t *testing.T) {
+	// An RGB scene whose white collar (x >= 128) has no nodata tag.
+	src := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256, OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.02,
+		PixelFunc: func(x, y, band int) uint16 {
+			if x >= 128 {
+				return 255
+			}
+			return 100
+		},
+	})
+	out := runPipeline(t, pipelineConfig{
+		InputPaths: []string{src}, Format: "png", MinZoom: 7, MaxZoom: 7,
+		NoData: map[string]string{src: "0,255"},
+	})
+
+	// The tile holding the collar's edge: the collar is transparent and
+	// no white is blended into the data next to it.
+	lon := 8 + 128*0.02
+	x, y := coord.LonLatToTile(lon, 44.5, 7)
+	img := assertTileDecodesAsImage(t, out, 7, x, y)
+	_, py := coord.TilePixelCoords(lon, 44.5, 7, x, y, img.Bounds().Dx())
+	transparent := 0
+	for px := 0; px < img.Bounds().Dx(); px++ {
+		c := color.NRGBAModel.Convert(img.At(px, int(py))).(color.NRGBA)
+		if c.A == 0 {
+			transparent++
+		} else if c.R > 110 {
+			t.Errorf("pixel %d: %v, want data about 100 without the collar", px, c)
+		}
+	}
+	if transparent == 0 {
+		t.Error("collar not transparent")
+	}
+}
+
+func TestMaskIFD(t *testing.T) {
+	// An RGB scene with a black collar (x >= 128) that only the internal
+	// mask marks as invalid.
+	src := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256, OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.02,
+		PixelFunc: func(x, y, band int) uint16 {
+			if x >= 128 {
+				return 0
+			}
+			return 100
+		},
+		MaskFunc: func(x, y int) bool { return x < 128 },
+	})
+	out := runPipeline(t, pipelineConfig{
+		InputPaths: []string{src}, Format: "png", MinZoom: 7, MaxZoom: 7,
+	})
+
+	lon := 8 + 128*0.02
+	x, y := coord.LonLatToTile(lon, 44.5, 7)
+	img := assertTileDecodesAsImage(t, out, 7, x, y)
+	_, py := coord.TilePixelCoords(lon, 44.5, 7, x, y, img.Bounds().Dx())
+	transparent := 0
+	for px := 0; px < img.Bounds().Dx(); px++ {
+		c := color.NRGBAModel.Convert(img.At(px, int(py))).(color.NRGBA)
+		if c.A == 0 {
+			transparent++
+		} else if c.A == 255 && c.R < 90 {
+			t.Errorf("pixel %d: %v, want data about 100 without the black collar", px, c)
+		}
+	}
+	if transparent == 0 {
+		t.Error("masked collar not transparent")
+	}
+}
+
+// TestZSTDInput checks that a ZSTD-compressed GeoTIFF (compression 50000)
+// tiles exactly like the same raster stored uncompressed.
+func TestZSTDInput(t *testing.T) {
+	cfg := tiffWriterConfig{
+		Width: 600, Height: 300, OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.01,
+		PixelFunc: func(x, y, band int) uint16 { return uint16((x + 2*y + 50*band) % 256) },
+	}
+	plain := writeSyntheticGeoTIFF(t, cfg)
+	cfg.ZSTD = true
+	zstd := writeSyntheticGeoTIFF(t, cfg)
+
+	run := func(src string) string {
+		return runPipeline(t, pipelineConfig{
+			InputPaths: []string{src}, Format: "png", MinZoom: 5, MaxZoom: 8,
+		})
+	}
+	assertArchivesIdentical(t, run(plain), run(zstd))
+}
+
+// TestPlanarInput checks that GeoTIFFs storing their bands in separate
+// tiles (PlanarConfiguration 2) tile exactly like pixel-interleaved ones.
+func TestPlanarInput(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		spp  int
+		bits int
+	}{
+		{"RGB", 3, 8},
+		{"RGBA", 4, 8},
+		{"RGB 16-bit", 3, 16},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := tiffWriterConfig{
+				Width: 600, Height: 300, TileWidth: 128, TileHt: 128,
+				SamplesPerPixel: c.spp, BitsPerSample: c.bits,
+				OriginLon: 8, OriginLat: 47, PixelSizeDeg: 0.01,
+				PixelFunc: func(x, y, band int) uint16 {
+					if band == 3 {
+						return uint16(255 * (x / 50 % 2))
+					}
+					return uint16((x + 2*y + 50*band) % 256 * (c.bits - 7))
+				},
+			}
+			contiguous := writeSyntheticGeoTIFF(t, cfg)
+			cfg.Planar = true
+			planar := writeSyntheticGeoTIFF(t, cfg)
+
+			run := func(src string) string {
+				return runPipeline(t, pipelineConfig{
+					InputPaths: []string{src}, Format: "png", MinZoom: 5, MaxZoom: 8,
+				})
+			}
+			assertArchivesIdentical(t, run(contiguous), run(planar))
+		})
+	}
+}
+
+// TestTileOrderSameOutput checks that processing tiles in Z-order instead
+// of Hilbert order (--tile-order) changes nothing in the archive, with and
+// without spilling and in both scheduling modes.
+func TestTileOrderSameOutput(t *testing.T) {
+	src := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 512, Height: 384, TileWidth: 128, TileHt: 128,
+		OriginLon: -20, OriginLat: 60, PixelSizeDeg: 0.08,
+		PixelFunc: func(x, y, band int) uint16 { return uint16((x*7 + y*y/11 + band*53) % 256) },
+	})
+	for _, levelByLevel := range []bool{false, true} {
+		for _, memLimit := range []int{0, 1} {
+			cfg := pipelineConfig{
+				InputPaths: []string{src}, MinZoom: 0, MaxZoom: 6, TileSize: 64,
+				Concurrency: 8, MemLimitMB: memLimit, LevelByLevel: levelByLevel,
+			}
+			hilbert := runPipeline(t, cfg)
+			cfg.TileOrder = tileorder.ZOrder
+			assertArchivesIdentical(t, hilbert, runPipeline(t, cfg))
+		}
+	}
+}
+
+// TestPMTilesBackfill checks that a PMTiles archive given as an input fills
+// only the pixels the GeoTIFFs leave empty: here the right half of a
+// GeoTIFF is nodata and shows the archive's red, the left half stays blue.
+// The GeoTIFF is in EPSG:4326, which the archive's tiles are reprojected
+// to, and in EPSG:3857, where they are sampled directly.
+func TestPMTilesBackfill(t *testing.T) {
+	red := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256,
+		OriginLon: -20, OriginLat: 60, PixelSizeDeg: 0.2,
+		PixelFunc: func(x, y, band int) uint16 {
+			if band == 0 {
+				return 255
+			}
+			return 0
+		},
+	})
+	archive := runPipeline(t, pipelineConfig{
+		InputPaths: []string{red}, Format: "png", MinZoom: 0, MaxZoom: 7,
+	})
+
+	const z = 7
+	left := [2]float64{-4.5, 47.5}
+	right := [2]float64{-0.5, 47.5}
+	pixelAt := func(lonLat [2]float64) (tx, ty, px, py int) {
+		tx, ty = coord.LonLatToTile(lonLat[0], lonLat[1], z)
+		fx, fy := coord.TilePixelCoords(lonLat[0], lonLat[1], z, tx, ty, 256)
+		return tx, ty, int(fx), int(fy)
+	}
+
+	merc := &coord.WebMercatorProj{}
+	for _, epsg := range []int{4326, 3857} {
+		t.Run(fmt.Sprintf("EPSG:%d", epsg), func(t *testing.T) {
+			// Lon -5 to 0, lat 50 to 45: blue in the left half, nodata
+			// in the right.
+			cfg := tiffWriterConfig{
+				Width: 256, Height: 256,
+				OriginLon: -5, OriginLat: 50, PixelSizeDeg: 5.0 / 256,
+				EPSG:   epsg,
+				NoData: "0",
+				PixelFunc: func(x, y, band int) uint16 {
+					if x < 128 && band == 2 {
+						return 255
+					}
+					return 0
+				},
+			}
+			if epsg == 3857 {
+				x0, y0 := merc.FromWGS84(-5, 50)
+				x1, _ := merc.FromWGS84(0, 50)
+				_, y1 := merc.FromWGS84(0, 45)
+				cfg.OriginLon, cfg.OriginLat = x0, y0
+				cfg.PixelSizeDeg = (x1 - x0) / 256
+				cfg.Height = int((y0 - y1) / cfg.PixelSizeDeg)
+			}
+			blue := writeSyntheticGeoTIFF(t, cfg)
+
+			out := runPipeline(t, pipelineConfig{
+				InputPaths: []string{blue}, Format: "png", MinZoom: z, MaxZoom: z,
+				Resampling: "nearest", Backfill: []string{archive},
+			})
+			tx, ty, px, py := pixelAt(left)
+			assertTilePixel(t, out, z, tx, ty, px, py, 0, 0, 255, 255, 0)
+			tx, ty, px, py = pixelAt(right)
+			assertTilePixel(t, out, z, tx, ty, px, py, 255, 0, 0, 255, 0)
+		})
+	}
+}
+
+// TestMBTilesOutput generates the same tiles into an MBTiles file as into a
+// PMTiles archive, and converts between the two without changing a tile.
+func TestMBTilesOutput(t *testing.T) {
+	input := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256,
+		OriginLon: 5, OriginLat: 48, PixelSizeDeg: 0.01,
+		PixelFunc: func(x, y, band int) uint16 {
+			return uint16((x*(band+1) + y*3) % 256)
+		},
+	})
+	cfg := pipelineConfig{InputPaths: []string{input}, Format: "png", MinZoom: 0, MaxZoom: 8}
+	pm := runPipeline(t, cfg)
+	cfg.MBTiles = true
+	mb := runPipeline(t, cfg)
+
+	assertSameTiles(t, pm, mb)
+	assertSameTiles(t, pm, runTransform(t, transformConfig{InputPath: mb, MinZoom: -1, MaxZoom: -1}))
+	converted := runTransform(t, transformConfig{InputPath: pm, Output: "transform.mbtiles", MinZoom: -1, MaxZoom: -1})
+	assertSameTiles(t, pm, converted)
+
+	r := openTileset(t, converted)
+	defer r.Close()
+	if h := r.Header(); h.TileType != pmtiles.TileTypePNG || h.MinZoom != 0 || h.MaxZoom != 8 {
+		t.Errorf("MBTiles header: type %d, zoom %d-%d; want PNG, 0-8", h.TileType, h.MinZoom, h.MaxZoom)
+	}
+	if got := r.TileSize(); got != 256 {
+		t.Errorf("MBTiles tile size = %d, want 256", got)
+	}
+
+	if _, err := exec.LookPath("sqlite3"); err == nil {
+		out, err := exec.Command("sqlite3", mb, "PRAGMA integrity_check").CombinedOutput()
+		if err != nil || strings.TrimSpace(string(out)) != "ok" {
+			t.Errorf("sqlite3 integrity_check: %v: %s", err, out)
+		}
+	}
+}
+
+// TestPremultipliedAlpha generates an image whose alpha fades out along x,
+// one alpha per column, with premultiplied alpha. Every stored pixel, at
+// the max zoom and in the downsampled parents, must hold the reference
+// color of its alpha multiplied by it, and pmtransform must convert the
+// tiles back to straight alpha.
+func TestPremultipliedAlpha(t *testing.T) {
+	input := writeSyntheticGeoTIFF(t, tiffWriterConfig{
+		Width: 256, Height: 256,
+		SamplesPerPixel: 4,
+		OriginLon:       5, OriginLat: 48, PixelSizeDeg: 0.01,
+		PixelFunc: func(x, y, band int) uint16 {
+			// An anti-aliased edge across the image, its colors stored
+			// multiplied by alpha as the reader takes them.
+			alpha := 255 - x
+			return uint16([]int{200, y, 50, 255}[band] * alpha / 255)
+		},
+	})
+	// Nearest keeps every parent pixel a source pixel: the averaging
+	// methods weigh colors by the opaque pixels only at data edges. The
+	// memory limit spills the tiles, so parents decode their children.
+	cfg := pipelineConfig{InputPaths: []string{input}, Format: "png", MinZoom: 3, MaxZoom: 8, Resampling: "nearest", MemLimitMB: 1}
+	straight := runPipeline(t, cfg)
+	cfg.PremultiplyAlpha = true
+	premultiplied := runPipeline(t, cfg)
+
+	for path, want := range map[string]bool{straight: false, premultiplied: true} {
+		r := openTileset(t, path)
+		if got := r.PremultipliedAlpha(); got != want {
+			t.Errorf("%s: PremultipliedAlpha = %v, want %v", filepath.Base(path), got, want)
+		}
+		r.Close()
+	}
+
+	// The stored colors against the reference: red and blue of the
+	// source column, which the alpha identifies.
+	var translucent int
+	compareTiles(t, premultiplied, straight, func(z, x, y, px, py int, p, s color.NRGBA) {
+		wantR, wantB := uint8(200*int(p.A)/255), uint8(50*int(p.A)/255)
+		if p.R != wantR || p.B != wantB || p.G > p.A {
+			t.Errorf("tile %d/%d/%d pixel (%d,%d): stored %v, want {%d <=%d %d %d}", z, x, y, px, py, p, wantR, p.A, wantB, p.A)
+		}
+		if s.A != p.A {
+			t.Errorf("tile %d/%d/%d pixel (%d,%d): alpha %d, straight alpha %d", z, x, y, px, py, p.A, s.A)
+		}
+		if p.A > 0 && p.A < 255 {
+			translucent++
+		}
+	})
+	if translucent == 0 {
+		t.Fatal("no translucent pixels compared")
+	}
+
+	// Back to straight alpha: multiplied by alpha again, the same colors.
+	off := false
+	back := runTransform(t, transformConfig{InputPath: premultiplied, MinZoom: -1, MaxZoom: -1, PremultiplyAlpha: &off})
+	r := openTileset(t, back)
+	if r.PremultipliedAlpha() {
+		t.Error("converted tileset still records premultiplied alpha")
+	}
+	r.Close()
+	compareTiles(t, premultiplied, back, func(z, x, y, px, py int, p, b color.NRGBA) {
+		rb := color.RGBAModel.Convert(b).(color.RGBA)
+		if absDiff(int(p.R), int(rb.R)) > 1 || absDiff(int(p.G), int(rb.G)) > 1 ||
+			absDiff(int(p.B), int(rb.B)) > 1 || p.A != rb.A {
+			t.Errorf("tile %d/%d/%d pixel (%d,%d): converted back %v, want %v multiplied by alpha", z, x, y, px, py, b, p)
+		}
+	})
+}
+
+// compareTiles calls fn for every pixel of every tile of the PNG tilesets
+// at pathA and pathB, with the colors as stored, and fails if their tiles
+// differ.
+func compareTiles(t *testing.T, pathA, pathB string, fn func(z, x, y, px, py int, a, b color.NRGBA)) {
+	t.Helper()
+	ra, rb := openTileset(t, pathA), openTileset(t, pathB)
+	defer ra.Close()
+	defer rb.Close()
+	decode := func(r tilesetReader, z, x, y int) *image.NRGBA {
+		data, err := r.ReadTile(z, x, y)
+		if err != nil || data == nil {
+			t.Fatalf("tile %d/%d/%d: %d bytes, %v", z, x, y, len(data), err)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("tile %d/%d/%d: %v", z, x, y, err)
+		}
+		n, ok := img.(*image.NRGBA)
+		if !ok {
+			t.Fatalf("tile %d/%d/%d decodes as %T, want *image.NRGBA", z, x, y, img)
+		}
+		return n
+	}
+	h := ra.Header()
+	for z := int(h.MinZoom); z <= int(h.MaxZoom); z++ {
+		if na, nb := ra.TileCountAtZoom(z), rb.TileCountAtZoom(z); na != nb {
+			t.Fatalf("zoom %d: %d vs %d tiles", z, na, nb)
+		}
+		ra.ForEachTileAtZoom(z, func(x, y int) error {
+			a, b := decode(ra, z, x, y), decode(rb, z, x, y)
+			for py := 0; py < a.Rect.Dy(); py++ {
+				for px := 0; px < a.Rect.Dx(); px++ {
+					fn(z, x, y, px, py, a.NRGBAAt(px, py), b.NRGBAAt(px, py))
+				}
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Package buildinfo reports which build of a tool is running. The Makefile
+// stamps version, commit and build date into each command with -ldflags;
+// binaries built without it, e.g. with plain `go install`, fall back to what
+// the Go toolchain embeds (runtime/debug.ReadBuildInfo): the module version
+// and the VCS revision and commit time of the checkout.
+package buildinfo
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+)
+
+// Defaults of the ldflags-stamped variables in each command's main package.
+const (
+	unsetVersion = "dev"
+	unsetValue   = "unknown"
+)
+
+// Info describes a build, for --version and --version --json.
+type Info struct {
+	Program   string          `json:"program"`
+	Version   string          `json:"version"`
+	Commit    string          `json:"commit"`
+	BuildDate string          `json:"build_date"`
+	GoVersion string          `json:"go_version"`
+	Features  map[string]bool `json:"features"`
+}
+
+// New returns the Info of program with the ldflags-stamped version, commit
+// and buildDate, filling in those left at their defaults from the embedded
+// build info where it has them.
+func New(program, version, commit, buildDate string) Info {
+	bi, _ := debug.ReadBuildInfo()
+	return resolve(program, version, commit, buildDate, bi)
+}
+
+func resolve(program, version, commit, buildDate string, bi *debug.BuildInfo) Info {
+	info := Info{
+		Program:   program,
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		Features: map[string]bool{
+			"webp": encode.WebPAvailable(), // native libwebp via cgo
+			"gpu":  false,                  // no GPU code paths
+			"zstd": true,                   // ZSTD-compressed TIFFs are read (pure Go)
+		},
+	}
+	if bi == nil {
+		return info
+	}
+	info.GoVersion = bi.GoVersion
+	if info.Version == unsetVersion && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	var revision, commitTime string
+	var modified bool
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.time":
+			commitTime = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if info.Commit == unsetValue && revision != "" {
+		// Short like `git rev-parse --short` in the Makefile.
+		info.Commit = revision[:min(len(revision), 7)]
+		if modified {
+			info.Commit += "-dirty"
+		}
+	}
+	if info.BuildDate == unsetValue && commitTime != "" {
+		// The toolchain does not record the build time; the commit time is
+		// the closest it has.
+		info.BuildDate = commitTime
+	}
+	return info
+}
+
+// String returns the one-line --version output, e.g.
+// "geotiff2pmtiles v1.4.0 (commit 1a2b3c4, built 2026-10-16T09:00:00Z)".
+func (i Info) String() string {
+	return fmt.Sprintf("%s %s (commit %s, built %s)", i.Program, i.Version, i.Commit, i.BuildDate)
+}
+
+// JSON returns i as indented JSON, for --version --json.
+func (i Info) JSON() ([]byte, error) {
+	return json.MarshalIndent(i, "", "  ")
+}
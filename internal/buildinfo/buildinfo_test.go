@@ -0,0 +1,60 @@
+package buildinfo
+
+import (
+	"encoding/json"
+	"runtime/debug"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	bi := &debug.BuildInfo{
+		GoVersion: "go1.25.5",
+		Main:      debug.Module{Path: "github.com/pspoerri/geotiff2pmtiles", Version: "v1.4.0"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "1a2b3c4d5e6f"},
+			{Key: "vcs.time", Value: "2026-10-16T09:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+
+	// Plain `go install`: everything comes from the build info.
+	got := resolve("geotiff2pmtiles", "dev", "unknown", "unknown", bi)
+	if want := "geotiff2pmtiles v1.4.0 (commit 1a2b3c4-dirty, built 2026-10-16T09:00:00Z)"; got.String() != want {
+		t.Errorf("String() = %q, want %q", got.String(), want)
+	}
+	if got.GoVersion != "go1.25.5" {
+		t.Errorf("GoVersion = %q, want go1.25.5", got.GoVersion)
+	}
+
+	// Stamped by the Makefile: the ldflags values win.
+	got = resolve("pmtransform", "v1.3.0-2-gabcdef0", "abcdef0", "2026-10-15T08:00:00Z", bi)
+	if want := "pmtransform v1.3.0-2-gabcdef0 (commit abcdef0, built 2026-10-15T08:00:00Z)"; got.String() != want {
+		t.Errorf("String() = %q, want %q", got.String(), want)
+	}
+
+	// A `go build` in a checkout without VCS stamping keeps the defaults.
+	got = resolve("pmheader", "dev", "unknown", "unknown", &debug.BuildInfo{Main: debug.Module{Version: "(devel)"}})
+	if want := "pmheader dev (commit unknown, built unknown)"; got.String() != want {
+		t.Errorf("String() = %q, want %q", got.String(), want)
+	}
+
+	data, err := got.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"program", "version", "commit", "build_date", "go_version", "features"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("JSON lacks %q: %s", key, data)
+		}
+	}
+	features, _ := decoded["features"].(map[string]any)
+	for _, key := range []string{"webp", "gpu", "zstd"} {
+		if _, ok := features[key]; !ok {
+			t.Errorf("features lack %q: %s", key, data)
+		}
+	}
+}
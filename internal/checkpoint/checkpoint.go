@@ -0,0 +1,197 @@
+// Package checkpoint keeps the state of --resume runs: after every finished
+// zoom level, the archive writer's entries and temp data and the tile store
+// of that level are saved, so that a run interrupted hours later continues
+// below the last finished level instead of starting over.
+//
+// A checkpoint is a directory next to the archive, <output>.resume:
+//
+//	checkpoint.json      {"version": 1, "settings": "...", "zoom": 7, ...}
+//	z07.writer           writer entries and dedup index (pmtiles.Writer.Checkpoint)
+//	z07.writer.data      writer temp file, hard-linked
+//	z07-store0.index     tile store index and in-memory tiles
+//	z07-store0.spill     tile store spill file, hard-linked
+//
+// The data files are hard links to the files the run keeps appending to,
+// so a checkpoint costs an index write per level rather than a copy of
+// everything generated so far. checkpoint.json is replaced last and
+// names the files it belongs to; files of older levels are removed after.
+package checkpoint
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// stateVersion is bumped when the format changes; older checkpoints are
+// ignored and the run starts over.
+const stateVersion = 1
+
+// stateFile is the name of the state file in a checkpoint directory.
+const stateFile = "checkpoint.json"
+
+// State is the content of checkpoint.json.
+type State struct {
+	Version int `json:"version"`
+	// Settings describes the options and inputs that affect tile content.
+	// A checkpoint is only resumed by a run with equal settings.
+	Settings string `json:"settings"`
+	// Zoom is the lowest zoom level whose tiles are all written.
+	Zoom int `json:"zoom"`
+	// Writer is the writer state file, relative to the directory.
+	Writer string `json:"writer"`
+	// Stores are the tile store files of zoom Zoom, one per layer,
+	// relative to the directory and without extension.
+	Stores []string `json:"stores"`
+	// Counts are the tile counters of each layer up to Zoom.
+	Counts []Counts `json:"counts"`
+}
+
+// Counts are the tile counters of a layer (see tile.Stats).
+type Counts struct {
+	Tiles   int64 `json:"tiles"`
+	Empty   int64 `json:"empty"`
+	Uniform int64 `json:"uniform"`
+	Gray    int64 `json:"gray"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// Dir returns the checkpoint directory of an output archive.
+func Dir(output string) string {
+	return output + ".resume"
+}
+
+// Load reads the state of the checkpoint in dir. A missing directory or
+// state file, or one written by another version, returns nil and no error:
+// there is nothing to resume.
+func Load(dir string) (*State, error) {
+	data, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %w", dir, err)
+	}
+	if s.Version != stateVersion {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+// Save makes s the checkpoint in dir: the state file is replaced
+// atomically, then files of the directory that s does not name are
+// removed. The files s names must already be written.
+func Save(dir string, s State) error {
+	s.Version = stateVersion
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = WriteFile(filepath.Join(dir, stateFile), func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	keep := map[string]bool{stateFile: true, s.Writer: true, s.Writer + ".data": true}
+	for _, st := range s.Stores {
+		keep[st+".index"] = true
+		keep[st+".spill"] = true
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !keep[e.Name()] {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+	return nil
+}
+
+// WriteFile writes path through fn into a temporary file, syncs it, and
+// renames it into place, so a crash leaves either the old file or the
+// complete new one.
+func WriteFile(path string, fn func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriterSize(tmp, 1<<20)
+	err = fn(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// SyncDir fsyncs a directory so that a rename inside it survives a power
+// loss.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Link makes dst a hard link to src, replacing dst. Where hard links are
+// not possible (another file system, or none supported) src is copied.
+// Later appends to src are visible through a link but not in a copy;
+// checkpoint readers use only the length they recorded.
+func Link(src, dst string) error {
+	os.Remove(dst)
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return WriteFile(dst, func(w io.Writer) error {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+}
+
+// Adopt returns path opened for reading and writing under a new temp name
+// in dir (a hard link, or a copy), so that a resumed run can append to and
+// finally remove its file while the checkpoint keeps the original.
+func Adopt(path, dir, pattern string) (*os.File, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	name := f.Name()
+	f.Close()
+	if err := Link(path, name); err != nil {
+		os.Remove(name)
+		return nil, err
+	}
+	return os.OpenFile(name, os.O_RDWR, 0)
+}
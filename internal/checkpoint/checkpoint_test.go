@@ -0,0 +1,80 @@
+package checkpoint
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	if s, err := Load(dir); s != nil || err != nil {
+		t.Fatalf("Load(empty) = %v, %v; want nil, nil", s, err)
+	}
+
+	// Files of the previous level and of the new one.
+	for _, name := range []string{"z08.writer", "z08.writer.data", "z08-store0.index", "z07.writer", "z07.writer.data", "z07-store0.index", "z07-store0.spill"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := State{
+		Settings: "format=png",
+		Zoom:     7,
+		Writer:   "z07.writer",
+		Stores:   []string{"z07-store0"},
+		Counts:   []Counts{{Tiles: 12, Uniform: 3, Bytes: 4096}},
+	}
+	if err := Save(dir, want); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	got, err := Load(dir)
+	if err != nil || got == nil {
+		t.Fatalf("Load = %v, %v", got, err)
+	}
+	if got.Settings != want.Settings || got.Zoom != 7 || got.Writer != want.Writer || len(got.Stores) != 1 || got.Counts[0] != want.Counts[0] {
+		t.Errorf("Load = %+v, want %+v", got, want)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	wantNames := []string{"checkpoint.json", "z07-store0.index", "z07-store0.spill", "z07.writer", "z07.writer.data"}
+	if len(names) != len(wantNames) {
+		t.Fatalf("files after Save = %v, want %v", names, wantNames)
+	}
+	for i := range names {
+		if names[i] != wantNames[i] {
+			t.Fatalf("files after Save = %v, want %v", names, wantNames)
+		}
+	}
+}
+
+func TestAdopt(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "data")
+	if err := os.WriteFile(src, []byte("checkpointed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Adopt(src, dir, "adopted-*.tmp")
+	if err != nil {
+		t.Fatalf("Adopt: %v", err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil || string(data) != "checkpointed" {
+		t.Fatalf("adopted file = %q, %v", data, err)
+	}
+	f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("removing the adopted file removed the original: %v", err)
+	}
+}
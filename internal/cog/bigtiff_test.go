@@ -0,0 +1,191 @@
+package cog
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// bigEntry is a BigTIFF IFD entry with its value inline.
+type bigEntry struct {
+	tag, typ     uint16
+	count, value uint64
+}
+
+// bigTIFFHeader returns a little-endian BigTIFF header followed by one IFD
+// (at offset 16) holding entries, chained to next.
+func bigTIFFHeader(entries []bigEntry, next uint64) []byte {
+	le := binary.LittleEndian
+	b := []byte{'I', 'I', 43, 0, 8, 0, 0, 0}
+	b = le.AppendUint64(b, 16)
+	b = le.AppendUint64(b, uint64(len(entries)))
+	for _, e := range entries {
+		b = le.AppendUint16(b, e.tag)
+		b = le.AppendUint16(b, e.typ)
+		b = le.AppendUint64(b, e.count)
+		b = le.AppendUint64(b, e.value)
+	}
+	return le.AppendUint64(b, next)
+}
+
+// grayEntries describes a 16x16 8-bit gray image, stored as one tile or
+// one strip of 256 bytes at offset.
+func grayEntries(offset uint64, strips bool) []bigEntry {
+	entries := []bigEntry{
+		{tagImageWidth, dtShort, 1, 16},
+		{tagImageLength, dtShort, 1, 16},
+		{tagBitsPerSample, dtShort, 1, 8},
+		{tagCompression, dtShort, 1, 1},
+		{tagPhotometric, dtShort, 1, 1},
+		{tagSamplesPerPixel, dtShort, 1, 1},
+	}
+	if strips {
+		return append(entries,
+			bigEntry{tagStripOffsets, dtLong8, 1, offset},
+			bigEntry{tagRowsPerStrip, dtShort, 1, 16},
+			bigEntry{tagStripByteCounts, dtLong8, 1, 256})
+	}
+	return append(entries,
+		bigEntry{tagTileWidth, dtShort, 1, 16},
+		bigEntry{tagTileLength, dtShort, 1, 16},
+		bigEntry{tagTileOffsets, dtLong8, 1, offset},
+		bigEntry{tagTileByteCounts, dtLong8, 1, 256})
+}
+
+// TestBigTIFFBeyond4GiB reads a sparse BigTIFF whose pixel data starts
+// past 4 GiB, tiled and strip-based, so offsets only fit in 64 bits.
+func TestBigTIFFBeyond4GiB(t *testing.T) {
+	if strconv.IntSize < 64 {
+		t.Skip("a file over 4 GiB cannot be mapped on 32-bit platforms")
+	}
+	const offset = 1<<32 + 4096
+	for _, strips := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "big.tif")
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pix := make([]byte, 256)
+		for i := range pix {
+			pix[i] = byte(i)
+		}
+		_, err = f.Write(bigTIFFHeader(grayEntries(offset, strips), 0))
+		if err == nil {
+			_, err = f.WriteAt(pix, offset) // the gap stays sparse
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			t.Skipf("cannot write a sparse 4 GiB file here: %v", err)
+		}
+
+		r, err := Open(path)
+		if err != nil {
+			t.Fatalf("strips=%v: Open: %v", strips, err)
+		}
+		img, err := r.ReadTile(0, 0, 0)
+		if err != nil {
+			r.Close()
+			t.Fatalf("strips=%v: ReadTile: %v", strips, err)
+		}
+		for _, p := range [][2]int{{0, 0}, {5, 3}, {15, 15}} {
+			want := uint32(pix[p[1]*16+p[0]])
+			if got, _, _, _ := img.At(p[0], p[1]).RGBA(); got>>8 != want {
+				t.Errorf("strips=%v: pixel %v = %d, want %d", strips, p, got>>8, want)
+			}
+		}
+		if b := r.RawBytes(offset+250, 100); len(b) != 6 || b[0] != 250 {
+			t.Errorf("strips=%v: RawBytes at the end of the file = %v, want the last 6 bytes", strips, b)
+		}
+		r.Close()
+	}
+}
+
+// TestTileRangeOverflow requires offsets and byte counts whose sum wraps
+// around 2^64 to fail as out of the file instead of slicing backwards.
+func TestTileRangeOverflow(t *testing.T) {
+	data := make([]byte, 1024)
+	ifd := IFD{
+		Width: 16, Height: 16, TileWidth: 16, TileHeight: 16,
+		SamplesPerPixel: 1, BitsPerSample: []uint16{8}, Compression: 1,
+		TileOffsets: []uint64{math.MaxUint64 - 10}, TileByteCounts: []uint64{256},
+	}
+	r := &Reader{bo: binary.LittleEndian, ifds: []IFD{ifd}, data: data}
+	if _, err := r.ReadTile(0, 0, 0); err == nil || !strings.Contains(err.Error(), "exceeds file size") {
+		t.Errorf("ReadTile with a wrapping range: err = %v, want exceeds file size", err)
+	}
+	if _, _, _, err := r.ReadFloatTile(0, 0, 0); err == nil {
+		t.Error("ReadFloatTile with a wrapping range: no error")
+	}
+
+	// The same through the strip path.
+	r.strip = &stripLayout{offsets: []uint64{512}, byteCounts: []uint64{math.MaxUint64 - 100}, rowsPerStrip: 16, stripsPerTile: 1}
+	if _, err := r.ReadTile(0, 0, 0); err == nil || !strings.Contains(err.Error(), "exceeds file size") {
+		t.Errorf("strip ReadTile with a wrapping range: err = %v, want exceeds file size", err)
+	}
+
+	for _, c := range []struct {
+		offset uint64
+		n      int
+		want   int
+	}{
+		{1000, 100, 24},
+		{math.MaxUint64 - 10, 100, 0},
+		{0, -1, 0},
+		{1024, 10, 0},
+	} {
+		if got := r.RawBytes(c.offset, c.n); len(got) != c.want {
+			t.Errorf("RawBytes(%d, %d) returned %d bytes, want %d", c.offset, c.n, len(got), c.want)
+		}
+	}
+}
+
+func TestPromoteStripsSaturates(t *testing.T) {
+	ifd := IFD{
+		Width: 16, Height: 16, RowsPerStrip: 8,
+		StripOffsets:    []uint64{100, 200},
+		StripByteCounts: []uint64{math.MaxUint64 - 5, 10},
+	}
+	promoteStripsToTiles(&ifd)
+	if got := ifd.TileByteCounts; len(got) != 1 || got[0] != math.MaxUint64 {
+		t.Errorf("virtual tile byte counts = %v, want [MaxUint64]", got)
+	}
+}
+
+// TestMalformedBigTIFF requires corrupt structure to fail Open with an
+// error rather than a panic, a huge allocation, or an endless loop.
+func TestMalformedBigTIFF(t *testing.T) {
+	tile := grayEntries(0, false)
+	for _, c := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"IFD loop", bigTIFFHeader(tile, 16), "loops back"},
+		{"IFD beyond the file", bigTIFFHeader(tile, 1<<40), "beyond file size"},
+		{"huge entry count", append(bigTIFFHeader(nil, 0)[:16], 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f), "entries exceed"},
+		{"huge value count", bigTIFFHeader(append(tile, bigEntry{tagGDAL_NODATA, dtASCII, 1 << 62, 64}), 0), "values exceed"},
+		{"values beyond the file", bigTIFFHeader(append(tile, bigEntry{tagGDAL_NODATA, dtASCII, 16, 1 << 40}), 0), "exceed file size"},
+		{"strip counts missing", bigTIFFHeader(append(grayEntries(0, true)[:6], bigEntry{tagStripOffsets, dtLong8, 1, 64}), 0), "strip byte counts"},
+	} {
+		path := filepath.Join(t.TempDir(), "bad.tif")
+		data := append(c.data, make([]byte, 512)...)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		r, err := Open(path)
+		if err == nil {
+			r.Close()
+			t.Errorf("%s: Open succeeded", c.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: err = %v, want %q", c.name, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package cog
+
+import (
+	"image"
+	"image/color"
+)
+
+// bilevel reports whether the image has a single 1-bit band: a bilevel
+// (black and white) scan or a mask.
+func (ifd *IFD) bilevel() bool {
+	return ifd.SamplesPerPixel <= 1 && len(ifd.BitsPerSample) > 0 && ifd.BitsPerSample[0] == 1
+}
+
+// decodeBilevelTile decodes a tile of 1-bit samples, packed eight to a byte
+// most significant bit first, each row starting on a byte boundary. Bits
+// are black or white as Photometric says (0: white is zero, otherwise black
+// is zero) and drawn in the BandConfig's bilevel colors, black and white
+// by default.
+func (r *Reader) decodeBilevelTile(ifd *IFD, data []byte) (image.Image, error) {
+	w, h := int(ifd.TileWidth), int(ifd.TileHeight)
+	rowBytes := (w + 7) / 8
+
+	ink, paper := color.RGBA{0, 0, 0, 255}, color.RGBA{255, 255, 255, 255}
+	if r.bandCfg.HasBilevelColors {
+		ink = premultiply(r.bandCfg.BilevelColors[0])
+		paper = premultiply(r.bandCfg.BilevelColors[1])
+	}
+	// colors[bit] is the color of a sample.
+	colors := [2]color.RGBA{ink, paper}
+	if ifd.Photometric == 0 {
+		colors = [2]color.RGBA{paper, ink}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		row := y * rowBytes
+		if row+rowBytes > len(data) {
+			break
+		}
+		for x := 0; x < w; x++ {
+			bit := data[row+x/8] >> (7 - x%8) & 1
+			img.SetRGBA(x, y, colors[bit])
+		}
+	}
+	return img, nil
+}
+
+// premultiply converts a non-premultiplied color to the premultiplied
+// form image.RGBA stores.
+func premultiply(c color.RGBA) color.RGBA {
+	return color.RGBAModel.Convert(color.NRGBA(c)).(color.RGBA)
+}
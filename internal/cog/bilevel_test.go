@@ -0,0 +1,49 @@
+package cog
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestDecodeBilevelTile(t *testing.T) {
+	// 10×2 tile: rows are padded to 2 bytes.
+	data := []byte{
+		0b10110000, 0b01000000, // 1 0 1 1 0 0 0 0 | 0 1
+		0b00000000, 0b11000000, // 0 0 0 0 0 0 0 0 | 1 1
+	}
+	black, white := color.RGBA{0, 0, 0, 255}, color.RGBA{255, 255, 255, 255}
+	red, clear := color.RGBA{255, 0, 0, 255}, color.RGBA{}
+	tests := []struct {
+		name        string
+		photometric uint16
+		cfg         BandConfig
+		one, zero   color.RGBA // colors of set and unset bits
+	}{
+		{"black is zero", 1, BandConfig{}, white, black},
+		{"white is zero", 0, BandConfig{}, black, white},
+		{"colors", 1, BandConfig{HasBilevelColors: true, BilevelColors: [2]color.RGBA{{255, 0, 0, 255}, {255, 255, 255, 0}}}, clear, red},
+		{"half-transparent ink", 0, BandConfig{HasBilevelColors: true, BilevelColors: [2]color.RGBA{{0, 0, 255, 128}, {255, 255, 255, 0}}}, color.RGBA{0, 0, 128, 128}, clear},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ifd := IFD{TileWidth: 10, TileHeight: 2, SamplesPerPixel: 1, BitsPerSample: []uint16{1}, Photometric: tt.photometric}
+			r := &Reader{bo: binary.LittleEndian, ifds: []IFD{ifd}, bandCfg: tt.cfg}
+			img, err := r.decodeRawTile(&ifd, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rgba := img.(*image.RGBA)
+			for y := 0; y < 2; y++ {
+				for x := 0; x < 10; x++ {
+					want := tt.zero
+					if data[y*2+x/8]>>(7-x%8)&1 == 1 {
+						want = tt.one
+					}
+					assertPixel(t, rgba, x, y, want)
+				}
+			}
+		})
+	}
+}
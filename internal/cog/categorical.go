@@ -0,0 +1,92 @@
+package cog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Categorical detection thresholds. Land cover and classification products
+// have tens of classes (ESA WorldCover 11, CORINE 44); continuous 8-bit data
+// shows hundreds of values in even a small overview. Below
+// minCategoricalSamples valid pixels the count says nothing.
+const (
+	maxCategoricalValues  = 64
+	minCategoricalSamples = 4096
+	maxCategoricalTiles   = 16 // tiles sampled from the chosen level
+)
+
+// DetectCategorical reports whether the raster looks like classes rather
+// than measurements, so that interpolating resamplers would invent classes
+// between neighbours. Palette images (a ColorMap, or PhotometricInterpretation
+// 3) are categorical. Otherwise an integer single-band raster is, if the
+// smallest overview holds at most maxCategoricalValues distinct values
+// (nodata excluded). Overviews that GDAL_METADATA says were averaged have
+// invented values of their own, so full resolution is sampled instead.
+// reason describes the evidence for the log.
+func (r *Reader) DetectCategorical() (reason string, ok bool) {
+	ifd := &r.ifds[0]
+	if len(ifd.ColorMap) > 0 || ifd.Photometric == 3 {
+		return "color map", true
+	}
+	if r.IsFloat() || ifd.SamplesPerPixel != 1 || ifd.imageCoded() {
+		return "", false
+	}
+	bps := r.BitsPerSample()
+	if bps != 8 && bps != 16 {
+		return "", false
+	}
+
+	level, where := len(r.ifds)-1, "smallest overview"
+	if level == 0 {
+		where = "image"
+	} else if ovr := strings.ToUpper(r.GDALMeta().OverviewResampling()); ovr != "" &&
+		!strings.HasPrefix(ovr, "NEAREST") && !strings.HasPrefix(ovr, "MODE") {
+		level, where = 0, "full resolution"
+	}
+	// Synthesized levels are averaged in memory; sample the real level below.
+	for level > 0 && r.synthFor(level) != nil {
+		level--
+	}
+
+	nodata, hasNodata := -1, false
+	if v, err := strconv.Atoi(strings.TrimSpace(ifd.NoData)); err == nil {
+		nodata, hasNodata = v, true
+	}
+
+	lv := &r.ifds[level]
+	across, down := lv.TilesAcross(), lv.TilesDown()
+	step := max(1, across*down/maxCategoricalTiles)
+	values := make(map[int]struct{})
+	samples := 0
+	for i := 0; i < across*down; i += step {
+		data, _, err := r.readTileRaw(level, i%across, i/across)
+		if err != nil || data == nil {
+			continue
+		}
+		n := len(data)
+		if bps == 16 {
+			n /= 2
+		}
+		for j := 0; j < n; j++ {
+			v := int(data[j])
+			if bps == 16 {
+				v = int(r.bo.Uint16(data[2*j:]))
+			}
+			if hasNodata && v == nodata {
+				continue
+			}
+			samples++
+			if _, seen := values[v]; !seen {
+				values[v] = struct{}{}
+				if len(values) > maxCategoricalValues {
+					return "", false
+				}
+			}
+		}
+	}
+	if samples < minCategoricalSamples {
+		return "", false
+	}
+	return fmt.Sprintf("%d distinct values in %s", len(values), where), true
+}
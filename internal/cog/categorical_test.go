@@ -0,0 +1,69 @@
+package cog
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// categoricalTestReader returns a 128×128 single-tile raster with the value
+// f(x, y) per pixel, 8 or 16 bits per sample.
+func categoricalTestReader(bps int, f func(x, y int) int) *Reader {
+	const size = 128
+	data := make([]byte, size*size*bps/8)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if bps == 16 {
+				binary.LittleEndian.PutUint16(data[2*(y*size+x):], uint16(f(x, y)))
+			} else {
+				data[y*size+x] = byte(f(x, y))
+			}
+		}
+	}
+	ifd := IFD{
+		Width: size, Height: size, TileWidth: size, TileHeight: size,
+		SamplesPerPixel: 1, BitsPerSample: []uint16{uint16(bps)}, Compression: 1,
+		TileOffsets: []uint64{0}, TileByteCounts: []uint64{uint64(len(data))},
+	}
+	return &Reader{bo: binary.LittleEndian, ifds: []IFD{ifd}, data: data}
+}
+
+func TestDetectCategorical(t *testing.T) {
+	classes := func(x, y int) int { return 10 * ((x/16 + y/16) % 7) }
+	gradient := func(x, y int) int { return x + y }
+
+	for _, c := range []struct {
+		name string
+		r    *Reader
+		want string // "" = not categorical
+	}{
+		{"8-bit classes", categoricalTestReader(8, classes), "7 distinct values"},
+		{"16-bit class codes", categoricalTestReader(16, func(x, y int) int { return 1000 + classes(x, y) }), "7 distinct values"},
+		{"8-bit gradient", categoricalTestReader(8, gradient), ""},
+		{"16-bit gradient", categoricalTestReader(16, func(x, y int) int { return 40 * (x + y) }), ""},
+	} {
+		reason, ok := c.r.DetectCategorical()
+		if ok != (c.want != "") || !strings.Contains(reason, c.want) {
+			t.Errorf("%s: DetectCategorical() = %q, %v; want %q", c.name, reason, ok, c.want)
+		}
+	}
+
+	// Nodata does not count as a class; a nearly empty raster has too few
+	// valid samples to tell.
+	r := categoricalTestReader(8, func(x, y int) int {
+		if x < 8 {
+			return classes(x, y)
+		}
+		return 255
+	})
+	r.ifds[0].NoData = "255"
+	if reason, ok := r.DetectCategorical(); ok {
+		t.Errorf("1024 valid samples: DetectCategorical() = %q, true; want false", reason)
+	}
+
+	r = categoricalTestReader(8, gradient)
+	r.ifds[0].ColorMap = make([]uint16, 3*256)
+	if reason, ok := r.DetectCategorical(); !ok || reason != "color map" {
+		t.Errorf("palette image: DetectCategorical() = %q, %v; want color map", reason, ok)
+	}
+}
@@ -49,6 +49,7 @@ func parseEPSG(geoKeys []uint16) int {
 
 	// GeoKey directory header: [KeyDirectoryVersion, KeyRevision, MinorRevision, NumberOfKeys]
 	numKeys := int(geoKeys[3])
+	geographic := 0
 
 	for i := 0; i < numKeys; i++ {
 		base := 4 + i*4
@@ -60,6 +61,8 @@ func parseEPSG(geoKeys []uint16) int {
 		// count := geoKeys[base+2]
 		valueOffset := geoKeys[base+3]
 
+		// A projected CRS also names its base geographic CRS, which
+		// sorts first: the projected code wins.
 		switch keyID {
 		case gkProjectedCSTypeGeoKey:
 			if valueOffset > 0 {
@@ -67,10 +70,10 @@ func parseEPSG(geoKeys []uint16) int {
 			}
 		case gkGeographicTypeGeoKey:
 			if valueOffset > 0 {
-				return int(valueOffset)
+				geographic = int(valueOffset)
 			}
 		}
 	}
 
-	return 0
+	return geographic
 }
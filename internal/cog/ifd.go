@@ -6,18 +6,22 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"sort"
 	"strconv"
 	"strings"
 )
 
 // TIFF tag IDs.
 const (
+	tagNewSubfileType     = 254
+	tagSubfileType        = 255
 	tagImageWidth         = 256
 	tagImageLength        = 257
 	tagBitsPerSample      = 258
 	tagCompression        = 259
 	tagPhotometric        = 262
 	tagStripOffsets       = 273
+	tagOrientation        = 274
 	tagSamplesPerPixel    = 277
 	tagRowsPerStrip       = 278
 	tagStripByteCounts    = 279
@@ -27,6 +31,8 @@ const (
 	tagTileOffsets        = 324
 	tagTileByteCounts     = 325
 	tagPredictor          = 317
+	tagColorMap           = 320
+	tagExtraSamples       = 338
 	tagSampleFormat       = 339
 	tagJPEGTables         = 347
 	tagModelTiepointTag   = 33922
@@ -57,8 +63,17 @@ const (
 	dtIFD8      = 18
 )
 
+// NewSubfileType (tag 254) flag bits.
+const (
+	subfileReduced = 1 << 0 // reduced-resolution version of another image
+	subfilePage    = 1 << 1 // single page of a multi-page image
+	subfileMask    = 1 << 2 // transparency mask for another image
+)
+
 // IFD represents a parsed TIFF Image File Directory.
 type IFD struct {
+	NewSubfileType  uint32 // tag 254 flags (tag 255 SubfileType is mapped onto them)
+	HasSubfileType  bool   // true when tag 254 or 255 is present
 	Width           uint32
 	Height          uint32
 	TileWidth       uint32
@@ -68,6 +83,9 @@ type IFD struct {
 	SampleFormat    []uint16
 	Compression     uint16
 	Photometric     uint16
+	ColorMap        []uint16 // tag 320: R, G, then B entries, 2^BitsPerSample each (palette images)
+	ExtraSamples    []uint16 // tag 338: meaning of each band past the color bands (0 unspecified, 1 associated alpha, 2 unassociated alpha)
+	Orientation     uint16   // TIFF Orientation (274); 0 when absent, meaning 1 (top-left)
 	PlanarConfig    uint16
 	Predictor       uint16
 	TileOffsets     []uint64
@@ -100,6 +118,48 @@ func (ifd *IFD) bytesPerSample() int {
 	return 1
 }
 
+// signedSamples reports whether integer samples are signed (SampleFormat 2).
+func (ifd *IFD) signedSamples() bool {
+	return len(ifd.SampleFormat) > 0 && ifd.SampleFormat[0] == 2
+}
+
+// bandBits returns the bit depth of each band. A single BitsPerSample value
+// applies to all bands.
+func (ifd *IFD) bandBits() []int {
+	spp := max(int(ifd.SamplesPerPixel), 1)
+	bits := make([]int, spp)
+	for i := range bits {
+		switch {
+		case i < len(ifd.BitsPerSample):
+			bits[i] = int(ifd.BitsPerSample[i])
+		case len(ifd.BitsPerSample) > 0:
+			bits[i] = int(ifd.BitsPerSample[len(ifd.BitsPerSample)-1])
+		default:
+			bits[i] = 8
+		}
+	}
+	return bits
+}
+
+// mixedDepth reports whether the bands have different bit depths, e.g. RGB
+// at 8 bits plus a 1-bit or 16-bit mask band.
+func (ifd *IFD) mixedDepth() bool {
+	bits := ifd.bandBits()
+	for _, b := range bits[1:] {
+		if b != bits[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// imageCoded reports whether tiles are whole compressed images (JPEG or
+// WebP) rather than compressed sample bytes, so that they decode to pixels
+// but not to values.
+func (ifd *IFD) imageCoded() bool {
+	return ifd.Compression == 7 || ifd.Compression == 50001
+}
+
 // TilesAcross returns the number of tiles in the horizontal direction.
 func (ifd *IFD) TilesAcross() int {
 	return int((ifd.Width + ifd.TileWidth - 1) / ifd.TileWidth)
@@ -120,6 +180,40 @@ type tiffEntry struct {
 
 // parseTIFF reads all IFDs from a TIFF file.
 func parseTIFF(r io.ReadSeeker) ([]IFD, binary.ByteOrder, error) {
+	dirs, bo, err := readTIFFDirs(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	ifds := make([]IFD, len(dirs))
+	for i, d := range dirs {
+		ifds[i] = buildIFD(d.entries, bo)
+	}
+	return ifds, bo, nil
+}
+
+// tiffDir is one raw IFD: its file offset and resolved entries.
+type tiffDir struct {
+	offset  uint64
+	entries []tiffEntry
+}
+
+// readTIFFDirs reads the header and the raw entries of every IFD in the
+// chain, with out-of-line values resolved.
+//
+// Offsets and counts are checked against the file size before anything is
+// allocated or read, so a malformed (or truncated) file fails with an error
+// instead of a huge allocation, an overflowing int conversion on 32-bit
+// platforms, or an endless IFD loop.
+func readTIFFDirs(r io.ReadSeeker) ([]tiffDir, binary.ByteOrder, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("determining file size: %w", err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	fileSize := uint64(size)
+
 	// Read header.
 	var header [8]byte
 	if _, err := io.ReadFull(r, header[:]); err != nil {
@@ -154,37 +248,45 @@ func parseTIFF(r io.ReadSeeker) ([]IFD, binary.ByteOrder, error) {
 		firstIFDOffset = uint64(bo.Uint32(header[4:8]))
 	}
 
-	var ifds []IFD
+	var dirs []tiffDir
 	offset := firstIFDOffset
+	seen := make(map[uint64]bool)
 
 	for offset != 0 {
-		ifd, nextOffset, err := parseOneIFD(r, bo, offset, isBigTIFF)
+		if seen[offset] {
+			return nil, nil, fmt.Errorf("IFD chain loops back to offset %d", offset)
+		}
+		seen[offset] = true
+		entries, nextOffset, err := readIFDEntries(r, bo, offset, isBigTIFF, fileSize)
 		if err != nil {
 			return nil, nil, fmt.Errorf("parsing IFD at offset %d: %w", offset, err)
 		}
-		ifds = append(ifds, ifd)
+		dirs = append(dirs, tiffDir{offset: offset, entries: entries})
 		offset = nextOffset
 	}
 
-	return ifds, bo, nil
+	return dirs, bo, nil
 }
 
-func parseOneIFD(r io.ReadSeeker, bo binary.ByteOrder, offset uint64, bigTIFF bool) (IFD, uint64, error) {
+func readIFDEntries(r io.ReadSeeker, bo binary.ByteOrder, offset uint64, bigTIFF bool, fileSize uint64) ([]tiffEntry, uint64, error) {
+	if offset >= fileSize {
+		return nil, 0, fmt.Errorf("offset beyond file size %d", fileSize)
+	}
 	if _, err := r.Seek(int64(offset), io.SeekStart); err != nil {
-		return IFD{}, 0, err
+		return nil, 0, err
 	}
 
 	var numEntries uint64
 	if bigTIFF {
 		var buf [8]byte
 		if _, err := io.ReadFull(r, buf[:]); err != nil {
-			return IFD{}, 0, err
+			return nil, 0, err
 		}
 		numEntries = bo.Uint64(buf[:])
 	} else {
 		var buf [2]byte
 		if _, err := io.ReadFull(r, buf[:]); err != nil {
-			return IFD{}, 0, err
+			return nil, 0, err
 		}
 		numEntries = uint64(bo.Uint16(buf[:]))
 	}
@@ -193,12 +295,15 @@ func parseOneIFD(r io.ReadSeeker, bo binary.ByteOrder, offset uint64, bigTIFF bo
 	if bigTIFF {
 		entrySize = 20
 	}
+	if numEntries > (fileSize-offset)/uint64(entrySize) {
+		return nil, 0, fmt.Errorf("%d entries exceed file size %d", numEntries, fileSize)
+	}
 
 	entries := make([]tiffEntry, numEntries)
 	for i := uint64(0); i < numEntries; i++ {
 		buf := make([]byte, entrySize)
 		if _, err := io.ReadFull(r, buf); err != nil {
-			return IFD{}, 0, err
+			return nil, 0, err
 		}
 		entries[i] = parseTiffEntry(buf, bo, bigTIFF)
 	}
@@ -208,26 +313,25 @@ func parseOneIFD(r io.ReadSeeker, bo binary.ByteOrder, offset uint64, bigTIFF bo
 	if bigTIFF {
 		var buf [8]byte
 		if _, err := io.ReadFull(r, buf[:]); err != nil {
-			return IFD{}, 0, err
+			return nil, 0, err
 		}
 		nextOffset = bo.Uint64(buf[:])
 	} else {
 		var buf [4]byte
 		if _, err := io.ReadFull(r, buf[:]); err != nil {
-			return IFD{}, 0, err
+			return nil, 0, err
 		}
 		nextOffset = uint64(bo.Uint32(buf[:]))
 	}
 
 	// Resolve entries that point to external data.
 	for i := range entries {
-		if err := resolveEntry(r, bo, &entries[i], bigTIFF); err != nil {
-			return IFD{}, 0, fmt.Errorf("resolving entry tag %d: %w", entries[i].Tag, err)
+		if err := resolveEntry(r, bo, &entries[i], bigTIFF, fileSize); err != nil {
+			return nil, 0, fmt.Errorf("resolving entry tag %d: %w", entries[i].Tag, err)
 		}
 	}
 
-	ifd := buildIFD(entries, bo)
-	return ifd, nextOffset, nil
+	return entries, nextOffset, nil
 }
 
 func parseTiffEntry(buf []byte, bo binary.ByteOrder, bigTIFF bool) tiffEntry {
@@ -271,10 +375,15 @@ func dataTypeSize(dt uint16) int {
 }
 
 // resolveEntry reads the actual data for an entry if it doesn't fit inline.
-func resolveEntry(r io.ReadSeeker, bo binary.ByteOrder, e *tiffEntry, bigTIFF bool) error {
-	totalSize := int(e.Count) * dataTypeSize(e.DataType)
+// The data must lie within the first fileSize bytes.
+func resolveEntry(r io.ReadSeeker, bo binary.ByteOrder, e *tiffEntry, bigTIFF bool, fileSize uint64) error {
+	typeSize := uint64(dataTypeSize(e.DataType))
+	if e.Count > fileSize/typeSize {
+		return fmt.Errorf("%d values exceed file size %d", e.Count, fileSize)
+	}
+	totalSize := e.Count * typeSize
 
-	inlineSize := 4
+	inlineSize := uint64(4)
 	if bigTIFF {
 		inlineSize = 8
 	}
@@ -291,6 +400,9 @@ func resolveEntry(r io.ReadSeeker, bo binary.ByteOrder, e *tiffEntry, bigTIFF bo
 	} else {
 		dataOffset = uint64(bo.Uint32(e.Value))
 	}
+	if dataOffset > fileSize-totalSize {
+		return fmt.Errorf("%d bytes at offset %d exceed file size %d", totalSize, dataOffset, fileSize)
+	}
 
 	if _, err := r.Seek(int64(dataOffset), io.SeekStart); err != nil {
 		return err
@@ -311,6 +423,21 @@ func buildIFD(entries []tiffEntry, bo binary.ByteOrder) IFD {
 
 	for _, e := range entries {
 		switch e.Tag {
+		case tagNewSubfileType:
+			ifd.NewSubfileType = getUint32(e, bo)
+			ifd.HasSubfileType = true
+		case tagSubfileType:
+			// Deprecated predecessor of tag 254: 1 = full resolution,
+			// 2 = reduced resolution, 3 = page of a multi-page image.
+			if !ifd.HasSubfileType {
+				switch getUint16Val(e, bo) {
+				case 2:
+					ifd.NewSubfileType = subfileReduced
+				case 3:
+					ifd.NewSubfileType = subfilePage
+				}
+				ifd.HasSubfileType = true
+			}
 		case tagImageWidth:
 			ifd.Width = getUint32(e, bo)
 		case tagImageLength:
@@ -327,6 +454,8 @@ func buildIFD(entries []tiffEntry, bo binary.ByteOrder) IFD {
 			ifd.Compression = getUint16Val(e, bo)
 		case tagPhotometric:
 			ifd.Photometric = getUint16Val(e, bo)
+		case tagOrientation:
+			ifd.Orientation = getUint16Val(e, bo)
 		case tagPlanarConfig:
 			ifd.PlanarConfig = getUint16Val(e, bo)
 		case tagTileOffsets:
@@ -352,6 +481,10 @@ func buildIFD(entries []tiffEntry, bo binary.ByteOrder) IFD {
 			ifd.GeoDoubleParams = getFloat64Slice(e, bo)
 		case tagPredictor:
 			ifd.Predictor = getUint16Val(e, bo)
+		case tagColorMap:
+			ifd.ColorMap = getUint16Slice(e, bo)
+		case tagExtraSamples:
+			ifd.ExtraSamples = getUint16Slice(e, bo)
 		case tagSampleFormat:
 			ifd.SampleFormat = getUint16Slice(e, bo)
 		case tagGDAL_NODATA:
@@ -439,6 +572,15 @@ func getUint16Val(e tiffEntry, bo binary.ByteOrder) uint16 {
 	}
 }
 
+// valueCount returns the entry's count, capped at the number of values of
+// size bytes its data holds.
+func valueCount(e tiffEntry, size int) int {
+	if n := uint64(len(e.Value) / size); e.Count > n {
+		return int(n)
+	}
+	return int(e.Count)
+}
+
 func getUint32(e tiffEntry, bo binary.ByteOrder) uint32 {
 	switch e.DataType {
 	case dtShort:
@@ -452,8 +594,10 @@ func getUint32(e tiffEntry, bo binary.ByteOrder) uint32 {
 	}
 }
 
+// getUint16Slice and getUint64Slice return at most as many values as the
+// entry's bytes hold, whatever its count claims.
 func getUint16Slice(e tiffEntry, bo binary.ByteOrder) []uint16 {
-	n := int(e.Count)
+	n := valueCount(e, 2)
 	result := make([]uint16, n)
 	for i := 0; i < n; i++ {
 		result[i] = bo.Uint16(e.Value[i*2 : i*2+2])
@@ -462,7 +606,15 @@ func getUint16Slice(e tiffEntry, bo binary.ByteOrder) []uint16 {
 }
 
 func getUint64Slice(e tiffEntry, bo binary.ByteOrder) []uint64 {
-	n := int(e.Count)
+	var n int
+	switch e.DataType {
+	case dtLong:
+		n = valueCount(e, 4)
+	case dtLong8:
+		n = valueCount(e, 8)
+	case dtShort:
+		n = valueCount(e, 2)
+	}
 	result := make([]uint64, n)
 	switch e.DataType {
 	case dtLong:
@@ -506,3 +658,59 @@ func float64FromBits(bits uint64) float64 {
 func float32FromBits(bits uint32) float32 {
 	return math.Float32frombits(bits)
 }
+
+// classifyIFDs picks the main (full-resolution) image from ifds and returns
+// it followed by its overviews, largest first, plus any transparency masks.
+//
+// When no IFD carries a subfile type, the traditional layout is assumed:
+// IFD 0 is the full-resolution image and every further IFD an overview.
+// Otherwise the main image is the first IFD that is neither reduced nor a
+// mask; later full-resolution pages of multi-page files are ignored. An
+// IFD only counts as an overview when it is reduced, smaller than the main
+// image, tiled, and has the same sample layout, which drops thumbnails
+// (typically small strip-based RGB previews) that would otherwise be read
+// as pixel data of the main image.
+func classifyIFDs(ifds []IFD) (levels, masks []IFD, err error) {
+	tagged := false
+	for i := range ifds {
+		if ifds[i].HasSubfileType {
+			tagged = true
+			break
+		}
+	}
+	if !tagged {
+		return ifds, nil, nil
+	}
+
+	main := -1
+	for i := range ifds {
+		if ifds[i].NewSubfileType&(subfileReduced|subfileMask) == 0 {
+			main = i
+			break
+		}
+	}
+	if main < 0 {
+		return nil, nil, fmt.Errorf("no full-resolution image: all %d IFDs are reduced-resolution or masks", len(ifds))
+	}
+	m := &ifds[main]
+
+	levels = []IFD{*m}
+	for i := range ifds {
+		ifd := &ifds[i]
+		switch {
+		case i == main:
+		case ifd.NewSubfileType&subfileMask != 0:
+			masks = append(masks, *ifd)
+		case ifd.NewSubfileType&subfileReduced != 0 &&
+			ifd.Width < m.Width && ifd.Height < m.Height &&
+			ifd.TileWidth > 0 && ifd.TileHeight > 0 &&
+			ifd.SamplesPerPixel == m.SamplesPerPixel &&
+			ifd.bytesPerSample() == m.bytesPerSample():
+			levels = append(levels, *ifd)
+		}
+	}
+	sort.SliceStable(levels[1:], func(a, b int) bool {
+		return levels[1+a].Width > levels[1+b].Width
+	})
+	return levels, masks, nil
+}
@@ -0,0 +1,145 @@
+package cog
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// matchMasks returns, per level, the transparency mask IFD that covers it,
+// or nil. GDAL writes one mask per level (NewSubfileType bit 2, Photometric
+// 4): 1-bit, or 8-bit with 0 for invalid pixels. A mask covers a level when
+// it has the level's size and tiling, so mask tile (col, row) describes
+// image tile (col, row). Synthesized levels and a strip-based full
+// resolution have no mask: their tiles come from finer masked levels or
+// are composed from strips.
+func matchMasks(levels []IFD, synth []*synthLevel, masks []IFD, strips bool) []*IFD {
+	if len(masks) == 0 {
+		return nil
+	}
+	out := make([]*IFD, len(levels))
+	for i := range levels {
+		if (i < len(synth) && synth[i] != nil) || (i == 0 && strips) {
+			continue
+		}
+		lvl := &levels[i]
+		for j := range masks {
+			m := &masks[j]
+			if m.Width == lvl.Width && m.Height == lvl.Height &&
+				m.TileWidth == lvl.TileWidth && m.TileHeight == lvl.TileHeight &&
+				m.SamplesPerPixel <= 1 && len(m.BitsPerSample) > 0 &&
+				(m.BitsPerSample[0] == 1 || m.BitsPerSample[0] == 8) &&
+				!m.imageCoded() {
+				out[i] = m
+				break
+			}
+		}
+	}
+	return out
+}
+
+// MaskedLevels returns the number of levels whose transparency mask IFD is
+// applied as alpha (see NumMasks).
+func (r *Reader) MaskedLevels() int {
+	n := 0
+	for _, m := range r.levelMasks {
+		if m != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// maskFor returns the mask IFD applied to level, or nil when the level has
+// none or the band config turns alpha off (AlphaBand -1).
+func (r *Reader) maskFor(level int) *IFD {
+	if level < 0 || level >= len(r.levelMasks) || r.bandCfg.AlphaBand < 0 {
+		return nil
+	}
+	return r.levelMasks[level]
+}
+
+// readMaskTile returns the coverage of tile (col, row) of mask, one byte
+// per pixel: 0 where the image is invalid, 255 (or the 8-bit mask value)
+// where it is valid. An empty mask tile masks the whole tile, as GDAL
+// leaves fully invalid tiles of sparse files unwritten.
+func (r *Reader) readMaskTile(mask *IFD, col, row int) ([]uint8, error) {
+	w, h := int(mask.TileWidth), int(mask.TileHeight)
+	cov := make([]uint8, w*h)
+
+	data, err := r.readIFDTile(mask, col, row)
+	if err != nil || data == nil {
+		return cov, err
+	}
+
+	if mask.bilevel() {
+		rowBytes := (w + 7) / 8
+		if len(data) < rowBytes*h {
+			return nil, fmt.Errorf("mask tile data too short: got %d, need %d", len(data), rowBytes*h)
+		}
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				if data[y*rowBytes+x/8]>>(7-x%8)&1 != 0 {
+					cov[y*w+x] = 255
+				}
+			}
+		}
+		return cov, nil
+	}
+
+	if len(data) < w*h {
+		return nil, fmt.Errorf("mask tile data too short: got %d, need %d", len(data), w*h)
+	}
+	copy(cov, data)
+	return cov, nil
+}
+
+// applyMask scales the alpha of a decoded tile by its mask coverage. Fully
+// valid tiles are returned unchanged; otherwise the result is an RGBA copy
+// of img, premultiplied, so masked pixels become transparent black.
+func applyMask(img image.Image, cov []uint8) image.Image {
+	opaque := true
+	for _, c := range cov {
+		if c != 255 {
+			opaque = false
+			break
+		}
+	}
+	if opaque {
+		return img
+	}
+
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+
+	w := b.Dx()
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			if i >= len(cov) {
+				return dst
+			}
+			c := uint32(cov[i])
+			if c == 255 {
+				continue
+			}
+			p := dst.PixOffset(x, y)
+			for k := 0; k < 4; k++ {
+				dst.Pix[p+k] = uint8((uint32(dst.Pix[p+k])*c + 127) / 255)
+			}
+		}
+	}
+	return dst
+}
+
+// applyFloatMask turns the values of masked pixels into NaN.
+func applyFloatMask(vals []float32, cov []uint8) {
+	nan := float32(math.NaN())
+	for i := range vals {
+		if i < len(cov) && cov[i] == 0 {
+			vals[i] = nan
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package cog
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// maskTestReader returns a 16x16 single-tile reader whose image samples
+// come from pixel and whose mask IFD, of maskBits bits, comes from valid.
+func maskTestReader(spp, bps int, pixel func(x, y int) []byte, maskBits int, valid func(x, y int) uint8) *Reader {
+	const size = 16
+	var data []byte
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			data = append(data, pixel(x, y)...)
+		}
+	}
+	img := IFD{
+		Width: size, Height: size, TileWidth: size, TileHeight: size,
+		SamplesPerPixel: uint16(spp), BitsPerSample: []uint16{uint16(bps)}, Compression: 1,
+		TileOffsets: []uint64{0}, TileByteCounts: []uint64{uint64(len(data))},
+	}
+	if bps == 32 {
+		img.SampleFormat = []uint16{3}
+	}
+
+	maskStart := len(data)
+	rowBytes := (size*maskBits + 7) / 8
+	mask := make([]byte, size*rowBytes)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			v := valid(x, y)
+			if maskBits == 8 {
+				mask[y*rowBytes+x] = v
+			} else if v != 0 {
+				mask[y*rowBytes+x/8] |= 0x80 >> (x % 8)
+			}
+		}
+	}
+	data = append(data, mask...)
+	m := IFD{
+		Width: size, Height: size, TileWidth: size, TileHeight: size,
+		SamplesPerPixel: 1, BitsPerSample: []uint16{uint16(maskBits)}, Compression: 1,
+		Photometric: 4, NewSubfileType: subfileMask, HasSubfileType: true,
+		TileOffsets: []uint64{uint64(maskStart)}, TileByteCounts: []uint64{uint64(len(mask))},
+	}
+
+	r := &Reader{bo: binary.LittleEndian, ifds: []IFD{img}, masks: []IFD{m}, data: data}
+	r.levelMasks = matchMasks(r.ifds, nil, r.masks, false)
+	return r
+}
+
+func TestMaskIFD(t *testing.T) {
+	rgb := func(x, y int) []byte { return []byte{200, 100, 50} }
+	leftHalf := func(x, y int) uint8 {
+		if x < 8 {
+			return 255
+		}
+		return 0
+	}
+
+	for _, bits := range []int{1, 8} {
+		r := maskTestReader(3, 8, rgb, bits, leftHalf)
+		if r.MaskedLevels() != 1 {
+			t.Fatalf("%d-bit mask: MaskedLevels() = %d, want 1", bits, r.MaskedLevels())
+		}
+		img, err := r.ReadTile(0, 0, 0)
+		if err != nil {
+			t.Fatalf("%d-bit mask: ReadTile: %v", bits, err)
+		}
+		rgba, ok := img.(*image.RGBA)
+		if !ok {
+			t.Fatalf("%d-bit mask: ReadTile returned %T, want *image.RGBA", bits, img)
+		}
+		assertPixel(t, rgba, 3, 5, color.RGBA{200, 100, 50, 255})
+		assertPixel(t, rgba, 12, 5, color.RGBA{})
+	}
+
+	// Intermediate 8-bit mask values scale the premultiplied pixel.
+	r := maskTestReader(3, 8, rgb, 8, func(x, y int) uint8 { return 128 })
+	img, err := r.ReadTile(0, 0, 0)
+	if err != nil {
+		t.Fatalf("ReadTile: %v", err)
+	}
+	assertPixel(t, img.(*image.RGBA), 0, 0, color.RGBA{100, 50, 25, 128})
+
+	// --alpha-band -1 turns the mask off.
+	r = maskTestReader(3, 8, rgb, 1, leftHalf)
+	r.SetBandConfig(BandConfig{AlphaBand: -1})
+	img, err = r.ReadTile(0, 0, 0)
+	if err != nil {
+		t.Fatalf("ReadTile without alpha: %v", err)
+	}
+	if _, _, _, a := img.At(12, 5).RGBA(); a != 0xffff {
+		t.Errorf("AlphaBand -1: masked pixel alpha = %d, want opaque", a)
+	}
+}
+
+func TestMaskIFDFloat(t *testing.T) {
+	elev := func(x, y int) []byte {
+		return binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(x+y)))
+	}
+	r := maskTestReader(1, 32, elev, 1, func(x, y int) uint8 {
+		if y < 4 {
+			return 0
+		}
+		return 255
+	})
+	vals, w, _, err := r.ReadFloatTile(0, 0, 0)
+	if err != nil {
+		t.Fatalf("ReadFloatTile: %v", err)
+	}
+	if v := vals[2*w+3]; !math.IsNaN(float64(v)) {
+		t.Errorf("masked value = %v, want NaN", v)
+	}
+	if v := vals[10*w+3]; v != 13 {
+		t.Errorf("valid value = %v, want 13", v)
+	}
+}
+
+func TestMatchMasks(t *testing.T) {
+	levels := []IFD{
+		{Width: 512, Height: 512, TileWidth: 256, TileHeight: 256},
+		{Width: 256, Height: 256, TileWidth: 256, TileHeight: 256},
+		{Width: 128, Height: 128, TileWidth: 128, TileHeight: 128},
+	}
+	mask := func(w, h, tile uint32, bps uint16) IFD {
+		return IFD{Width: w, Height: h, TileWidth: tile, TileHeight: tile,
+			SamplesPerPixel: 1, BitsPerSample: []uint16{bps}, Compression: 8}
+	}
+	masks := []IFD{
+		mask(512, 512, 256, 1),
+		mask(256, 256, 128, 1), // different tiling
+		mask(128, 128, 128, 4), // unsupported depth
+	}
+	got := matchMasks(levels, nil, masks, false)
+	if got[0] != &masks[0] || got[1] != nil || got[2] != nil {
+		t.Errorf("matchMasks = %v, want only level 0 masked", got)
+	}
+	// A strip-based full resolution keeps no mask.
+	if got := matchMasks(levels, nil, masks, true); got[0] != nil {
+		t.Errorf("matchMasks with strips: level 0 masked")
+	}
+}
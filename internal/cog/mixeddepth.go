@@ -0,0 +1,173 @@
+package cog
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// colorBands returns how many leading bands carry color: the bands before
+// the ExtraSamples, or else 3 for RGB and 1 for grayscale.
+func (ifd *IFD) colorBands() int {
+	spp := max(int(ifd.SamplesPerPixel), 1)
+	switch {
+	case len(ifd.ExtraSamples) > 0 && len(ifd.ExtraSamples) < spp:
+		return spp - len(ifd.ExtraSamples)
+	case ifd.Photometric == 2 || spp >= 3:
+		return min(3, spp)
+	default:
+		return 1
+	}
+}
+
+// maskBand returns the 0-indexed extra band that holds transparency, or -1:
+// the first one flagged as alpha in ExtraSamples, or else the first 1-bit
+// extra band, which can only be a mask.
+func (ifd *IFD) maskBand() int {
+	base := ifd.colorBands()
+	for i, es := range ifd.ExtraSamples {
+		if es == 1 || es == 2 { // associated or unassociated alpha
+			return base + i
+		}
+	}
+	for i, b := range ifd.bandBits() {
+		if i >= base && b == 1 {
+			return i
+		}
+	}
+	return -1
+}
+
+// decodeMixedDepthTile decodes an uncompressed tile whose bands have
+// different bit depths, e.g. 8-bit RGB plus a 1-bit or 16-bit mask band.
+// Samples are packed most significant bit first at their own width, and
+// every row starts on a byte boundary.
+//
+// Color bands go through the BandConfig rescaler as in decodeRawTile; bands
+// of 16 bits default to linear 0-65535. The alpha band (BandConfig.AlphaBand,
+// or maskBand when that is 0) is scaled by its own depth, so a 1-bit mask
+// becomes 0 or 255 and a 16-bit one keeps its full range.
+func (r *Reader) decodeMixedDepthTile(ifd *IFD, data []byte) (image.Image, error) {
+	w, h := int(ifd.TileWidth), int(ifd.TileHeight)
+	bits := ifd.bandBits()
+	spp := len(bits)
+	offsets := make([]int, spp)
+	pixelBits := 0
+	for i, b := range bits {
+		if b < 1 || b > 16 {
+			return nil, fmt.Errorf("unsupported band bit depths %v (want 1-16 bits per band)", bits)
+		}
+		offsets[i] = pixelBits
+		pixelBits += b
+	}
+	rowBytes := (w*pixelBits + 7) / 8
+
+	cfg := r.bandCfg
+	bands := [3]int{0, 1, 2}
+	if ifd.colorBands() == 1 {
+		bands = [3]int{0, 0, 0}
+	}
+	for i, b := range cfg.Bands {
+		if b > 0 {
+			bands[i] = b - 1
+		}
+	}
+	alpha := -1
+	switch {
+	case cfg.AlphaBand > 0:
+		alpha = cfg.AlphaBand - 1
+	case cfg.AlphaBand == 0:
+		alpha = ifd.maskBand()
+	}
+	if alpha >= spp {
+		alpha = -1
+	}
+
+	var hasNodata bool
+	var nodata uint16
+	intND, hasIntND := r.intNoData, r.hasIntNoData
+	if hasIntND {
+		hasNodata = true // matched against the per-source override below
+	} else if cfg.HasNodata {
+		hasNodata, nodata = true, uint16(cfg.Nodata)
+	} else if nd := r.ifds[0].NoData; nd != "" {
+		if v, err := strconv.ParseFloat(strings.TrimSpace(nd), 64); err == nil && v >= 0 && v <= 65535 && v == math.Floor(v) {
+			hasNodata, nodata = true, uint16(v)
+		}
+	}
+
+	rescale := buildRescaler(cfg.Rescale, cfg.RescaleMin, cfg.RescaleMax)
+	wide := rescale
+	if cfg.Rescale == RescaleNone {
+		wide = buildRescaler(RescaleLinear, 0, 65535)
+	}
+	scale := func(v uint16, n int) uint8 { // full range of n bits to 0-255
+		m := uint32(1)<<n - 1
+		return uint8((uint32(v)*255 + m/2) / m)
+	}
+	toColor := func(v uint16, n int) uint8 {
+		switch {
+		case n == 8:
+			return rescale(v)
+		case n < 8:
+			return scale(v, n)
+		default:
+			return wide(v << (16 - n))
+		}
+	}
+	sample := func(row []byte, x, band int) uint16 {
+		n, off := bits[band], x*pixelBits+offsets[band]
+		switch {
+		case off%8 == 0 && n == 8:
+			return uint16(row[off/8])
+		case off%8 == 0 && n == 16:
+			return r.bo.Uint16(row[off/8:])
+		}
+		var v uint16
+		for i := off; i < off+n; i++ {
+			v = v<<1 | uint16(row[i/8]>>(7-i%8)&1)
+		}
+		return v
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h && (y+1)*rowBytes <= len(data); y++ {
+		row := data[y*rowBytes : (y+1)*rowBytes]
+		pix := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			p := pix[x*4 : x*4+4]
+			if alpha < 0 && hasNodata {
+				isNodata := true
+				for b := 0; b < spp && isNodata; b++ {
+					if v := sample(row, x, b); hasIntND {
+						isNodata = intND.Match(float64(v))
+					} else {
+						isNodata = v == nodata
+					}
+				}
+				if isNodata {
+					continue // transparent
+				}
+			}
+			a := uint8(255)
+			if alpha >= 0 {
+				v := sample(row, x, alpha)
+				if v == 0 {
+					continue // transparent
+				}
+				if a = scale(v, bits[alpha]); a == 0 {
+					a = 1 // Avoid fully transparent for non-zero source alpha
+				}
+			}
+			for c, b := range bands {
+				if b < spp {
+					p[c] = toColor(sample(row, x, b), bits[b])
+				}
+			}
+			p[3] = a
+		}
+	}
+	return img, nil
+}
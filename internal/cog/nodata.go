@@ -0,0 +1,139 @@
+package cog
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// NoDataSpec describes which sample values are nodata: any number of
+// exact values plus open-ended thresholds. Merged national DEMs often mix
+// conventions (-9999 in one tile, -32767 in the next, huge negative fill
+// values elsewhere), which a single GDAL_NODATA value cannot express.
+// NaN is always nodata for float data and need not be listed. Integer
+// sources use the same specs (SetIntegerNoData).
+// The zero value matches only NaN.
+type NoDataSpec struct {
+	Values   []float64
+	Below    float64 // values < Below are nodata if HasBelow
+	Above    float64 // values > Above are nodata if HasAbove
+	HasBelow bool
+	HasAbove bool
+}
+
+// ParseNoDataSpec parses a comma-separated list of nodata terms: plain
+// values ("-9999"), "<v" for everything below v and ">v" for everything
+// above v, e.g. "-9999,-32767" or "<-1000,>9000". "nan" and "" are accepted
+// and add nothing. Repeated thresholds keep the most inclusive one.
+func ParseNoDataSpec(s string) (NoDataSpec, error) {
+	var spec NoDataSpec
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" || strings.EqualFold(term, "nan") {
+			continue
+		}
+		op := term[0]
+		num := term
+		if op == '<' || op == '>' {
+			num = strings.TrimSpace(term[1:])
+		}
+		v, err := strconv.ParseFloat(num, 64)
+		if err != nil || math.IsNaN(v) {
+			return NoDataSpec{}, fmt.Errorf("invalid nodata term %q (want a number, <number or >number)", term)
+		}
+		switch op {
+		case '<':
+			if !spec.HasBelow || v > spec.Below {
+				spec.Below, spec.HasBelow = v, true
+			}
+		case '>':
+			if !spec.HasAbove || v < spec.Above {
+				spec.Above, spec.HasAbove = v, true
+			}
+		default:
+			spec.Values = append(spec.Values, v)
+		}
+	}
+	return spec, nil
+}
+
+// IsEmpty reports whether the spec matches nothing but NaN.
+func (s NoDataSpec) IsEmpty() bool {
+	return len(s.Values) == 0 && !s.HasBelow && !s.HasAbove
+}
+
+// Match reports whether v is nodata under the spec.
+func (s NoDataSpec) Match(v float64) bool {
+	if math.IsNaN(v) || (s.HasBelow && v < s.Below) || (s.HasAbove && v > s.Above) {
+		return true
+	}
+	// Float tiles are decoded to float32, so compare at that precision: a
+	// tag of "-9999.9" must match the float32 sample nearest to it.
+	for _, nd := range s.Values {
+		if float32(v) == float32(nd) {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the spec in the syntax ParseNoDataSpec accepts.
+func (s NoDataSpec) String() string {
+	var terms []string
+	for _, v := range s.Values {
+		terms = append(terms, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	if s.HasBelow {
+		terms = append(terms, "<"+strconv.FormatFloat(s.Below, 'g', -1, 64))
+	}
+	if s.HasAbove {
+		terms = append(terms, ">"+strconv.FormatFloat(s.Above, 'g', -1, 64))
+	}
+	return strings.Join(terms, ",")
+}
+
+// SetFloatNoData replaces the nodata spec applied to float tiles. Samples
+// matching it are turned into NaN as tiles are decoded, so every resampling
+// kernel excludes them instead of averaging -9999 into real elevations.
+// Defaults to the file's GDAL_NODATA value. Call before reading tiles:
+// decoded tiles are cached with the spec that was active.
+func (r *Reader) SetFloatNoData(spec NoDataSpec) {
+	r.floatNoData = spec
+	r.unpin()
+}
+
+// FloatNoData returns the nodata spec applied to float tiles.
+func (r *Reader) FloatNoData() NoDataSpec {
+	return r.floatNoData
+}
+
+// SetIntegerNoData sets the nodata spec of 8 and 16-bit integer tiles,
+// replacing the band config's nodata value and the file's GDAL_NODATA:
+// pixels whose bands all match it decode as transparent. It is the
+// per-source override for files with wrong or missing nodata tags. Call
+// before reading tiles.
+func (r *Reader) SetIntegerNoData(spec NoDataSpec) {
+	r.intNoData, r.hasIntNoData = spec, true
+	r.unpin()
+}
+
+// IntegerNoData returns the integer nodata override, and false when none
+// is set.
+func (r *Reader) IntegerNoData() (NoDataSpec, bool) {
+	return r.intNoData, r.hasIntNoData
+}
+
+// maskFloatNoData sets samples matching the reader's nodata spec to NaN.
+func (r *Reader) maskFloatNoData(data []float32) {
+	spec := r.floatNoData
+	if spec.IsEmpty() {
+		return
+	}
+	nan := float32(math.NaN())
+	for i, v := range data {
+		if spec.Match(float64(v)) {
+			data[i] = nan
+		}
+	}
+}
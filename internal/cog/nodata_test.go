@@ -0,0 +1,110 @@
+package cog
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestParseNoDataSpec(t *testing.T) {
+	spec, err := ParseNoDataSpec(" -9999, -32767 ,<-1000,<-2000,>9000,nan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v, want := range map[float64]bool{
+		-9999:       true,
+		-32767:      true,
+		-1500:       true, // below the most inclusive threshold (-1000)
+		-1000:       false,
+		0:           false,
+		8848:        false,
+		9000.5:      true,
+		math.NaN():  true,
+		-9999.00001: true, // same float32
+	} {
+		if got := spec.Match(v); got != want {
+			t.Errorf("Match(%v) = %v, want %v", v, got, want)
+		}
+	}
+	if got := spec.String(); got != "-9999,-32767,<-1000,>9000" {
+		t.Errorf("String() = %q", got)
+	}
+
+	for _, bad := range []string{"abc", "<", ">x", "1..2"} {
+		if _, err := ParseNoDataSpec(bad); err == nil {
+			t.Errorf("ParseNoDataSpec(%q): expected error", bad)
+		}
+	}
+	if spec, _ := ParseNoDataSpec(""); !spec.IsEmpty() {
+		t.Error("empty string should give an empty spec")
+	}
+}
+
+func TestReadFloatTile_NoDataMasked(t *testing.T) {
+	// 2×2 float tile: two valid samples and two nodata conventions.
+	samples := []float32{100, -9999, -32767, 250}
+	data := make([]byte, 16)
+	for i, v := range samples {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(v))
+	}
+	ifd := IFD{
+		Width: 2, Height: 2, TileWidth: 2, TileHeight: 2,
+		SamplesPerPixel: 1, BitsPerSample: []uint16{32}, SampleFormat: []uint16{3},
+		Compression: 1, TileOffsets: []uint64{0}, TileByteCounts: []uint64{16},
+	}
+	r := &Reader{bo: binary.LittleEndian, ifds: []IFD{ifd}, data: data}
+
+	got, _, _, err := r.ReadFloatTile(0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[1] != -9999 {
+		t.Fatalf("without a spec, -9999 must pass through, got %v", got[1])
+	}
+
+	spec, _ := ParseNoDataSpec("-9999,<-10000")
+	r.SetFloatNoData(spec)
+	got, _, _, err = r.ReadFloatTile(0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != 100 || got[3] != 250 || !math.IsNaN(float64(got[1])) || !math.IsNaN(float64(got[2])) {
+		t.Errorf("masked tile = %v, want [100 NaN NaN 250]", got)
+	}
+}
+
+func TestDecodeRawTile_IntegerNoData(t *testing.T) {
+	black := color.RGBA{0, 0, 0, 255}
+
+	// RGB: black and white fill (all bands match) and a pixel with one
+	// matching band, which stays.
+	rgb := IFD{TileWidth: 3, TileHeight: 1, SamplesPerPixel: 3, BitsPerSample: []uint16{8, 8, 8}, NoData: "7"}
+	r := &Reader{bo: binary.LittleEndian, ifds: []IFD{rgb}, bandCfg: BandConfig{HasNodata: true, Nodata: 7}}
+	spec, _ := ParseNoDataSpec("0,255")
+	r.SetIntegerNoData(spec)
+	img, err := r.decodeRawTile(&rgb, []byte{0, 0, 0, 255, 255, 255, 0, 20, 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPixel(t, img.(*image.RGBA), 0, 0, color.RGBA{})
+	assertPixel(t, img.(*image.RGBA), 1, 0, color.RGBA{})
+	assertPixel(t, img.(*image.RGBA), 2, 0, color.RGBA{0, 20, 30, 255})
+
+	// Gray: the override replaces the file's nodata tag.
+	gray := IFD{TileWidth: 4, TileHeight: 1, SamplesPerPixel: 1, BitsPerSample: []uint16{8}, NoData: "0"}
+	r = &Reader{bo: binary.LittleEndian, ifds: []IFD{gray}}
+	spec, _ = ParseNoDataSpec(">250,3")
+	r.SetIntegerNoData(spec)
+	img, err = r.decodeRawTile(&gray, []byte{0, 3, 251, 255})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPixel(t, img.(*image.RGBA), 0, 0, black)
+	for x := 1; x < 4; x++ {
+		if a := img.(*image.RGBA).RGBAAt(x, 0).A; a != 0 {
+			t.Errorf("gray pixel %d: alpha %d, want transparent", x, a)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package cog
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// TIFF Orientation values 1-4 describe where row 0 and column 0 of the
+// stored raster lie in the displayed image. Values 5-8 transpose rows and
+// columns and are rejected by Open.
+const (
+	orientTopLeft     = 1 // row 0 top, column 0 left (the default)
+	orientTopRight    = 2 // mirrored horizontally
+	orientBottomRight = 3 // rotated 180°
+	orientBottomLeft  = 4 // mirrored vertically (bottom-up scans)
+)
+
+// flips reports how the stored raster of level must be mirrored to obtain
+// the displayed image. Overviews without their own Orientation tag inherit
+// the full-resolution image's.
+func (r *Reader) flips(level int) (flipX, flipY bool) {
+	o := r.ifds[level].Orientation
+	if o == 0 {
+		o = r.ifds[0].Orientation
+	}
+	switch o {
+	case orientTopRight:
+		return true, false
+	case orientBottomRight:
+		return true, true
+	case orientBottomLeft:
+		return false, true
+	}
+	return false, false
+}
+
+// storedSpan maps the displayed pixel range [lo, hi) of an axis of length n
+// to the stored range, mirroring it when flip is set.
+func storedSpan(lo, hi, n int, flip bool) (int, int) {
+	if flip {
+		return n - hi, n - lo
+	}
+	return lo, hi
+}
+
+// orientedTileSpan computes, for displayed tile (col, row) of ifd, the
+// displayed pixel origin and the stored pixel ranges it covers. Because the
+// image size need not be a multiple of the tile size, a mirrored tile
+// usually straddles two stored tiles per flipped axis.
+func orientedTileSpan(ifd *IFD, col, row int, flipX, flipY bool) (x0, y0, sx0, sx1, sy0, sy1 int) {
+	tw, th := int(ifd.TileWidth), int(ifd.TileHeight)
+	w, h := int(ifd.Width), int(ifd.Height)
+	x0, y0 = col*tw, row*th
+	sx0, sx1 = storedSpan(x0, min(x0+tw, w), w, flipX)
+	sy0, sy1 = storedSpan(y0, min(y0+th, h), h, flipY)
+	return
+}
+
+// displayed maps stored coordinate s on an axis of length n to its
+// displayed position.
+func displayed(s, n int, flip bool) int {
+	if flip {
+		return n - 1 - s
+	}
+	return s
+}
+
+// readFlippedTile assembles displayed tile (col, row) of level from the
+// stored tiles that hold its pixels, mirrored according to flipX/flipY.
+// Padding beyond the image edge is left transparent; it is never sampled.
+func (r *Reader) readFlippedTile(level, col, row int, flipX, flipY bool) (image.Image, error) {
+	ifd := &r.ifds[level]
+	tw, th := int(ifd.TileWidth), int(ifd.TileHeight)
+	w, h := int(ifd.Width), int(ifd.Height)
+	x0, y0, sx0, sx1, sy0, sy1 := orientedTileSpan(ifd, col, row, flipX, flipY)
+
+	out := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for srow := sy0 / th; srow <= (sy1-1)/th; srow++ {
+		for scol := sx0 / tw; scol <= (sx1-1)/tw; scol++ {
+			src, err := r.readStoredTile(level, scol, srow)
+			if err != nil {
+				return nil, err
+			}
+			rgba, _ := src.(*image.RGBA)
+			for sy := max(sy0, srow*th); sy < min(sy1, (srow+1)*th); sy++ {
+				dy := displayed(sy, h, flipY) - y0
+				for sx := max(sx0, scol*tw); sx < min(sx1, (scol+1)*tw); sx++ {
+					dx := displayed(sx, w, flipX) - x0
+					lx, ly := sx-scol*tw, sy-srow*th
+					if rgba != nil {
+						si, di := rgba.PixOffset(lx, ly), out.PixOffset(dx, dy)
+						copy(out.Pix[di:di+4], rgba.Pix[si:si+4])
+						continue
+					}
+					out.SetRGBA(dx, dy, color.RGBAModel.Convert(src.At(lx, ly)).(color.RGBA))
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// readFlippedFloatTile is readFlippedTile for float tiles. Pixels from
+// empty stored tiles are NaN (nodata); nil is returned when every stored
+// tile involved is empty.
+func (r *Reader) readFlippedFloatTile(level, col, row int, flipX, flipY bool) ([]float32, int, int, error) {
+	ifd := &r.ifds[level]
+	tw, th := int(ifd.TileWidth), int(ifd.TileHeight)
+	w, h := int(ifd.Width), int(ifd.Height)
+	if col < 0 || col >= ifd.TilesAcross() || row < 0 || row >= ifd.TilesDown() {
+		return r.readStoredFloatTile(level, col, row) // reports the range error
+	}
+	x0, y0, sx0, sx1, sy0, sy1 := orientedTileSpan(ifd, col, row, flipX, flipY)
+
+	var out []float32
+	for srow := sy0 / th; srow <= (sy1-1)/th; srow++ {
+		for scol := sx0 / tw; scol <= (sx1-1)/tw; scol++ {
+			src, _, _, err := r.readStoredFloatTile(level, scol, srow)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			if src == nil {
+				continue
+			}
+			if out == nil {
+				out = make([]float32, tw*th)
+				nan := float32(math.NaN())
+				for i := range out {
+					out[i] = nan
+				}
+			}
+			for sy := max(sy0, srow*th); sy < min(sy1, (srow+1)*th); sy++ {
+				dy := displayed(sy, h, flipY) - y0
+				for sx := max(sx0, scol*tw); sx < min(sx1, (scol+1)*tw); sx++ {
+					dx := displayed(sx, w, flipX) - x0
+					out[dy*tw+dx] = src[(sy-srow*th)*tw+(sx-scol*tw)]
+				}
+			}
+		}
+	}
+	return out, tw, th, nil
+}
@@ -0,0 +1,94 @@
+package cog
+
+import (
+	"encoding/binary"
+	"image"
+	"math"
+	"testing"
+)
+
+// orientedTestReader builds an in-memory 3×3 image stored as 2×2 tiles under
+// the given orientation. The displayed value of pixel (x, y) is y*3+x+1; the
+// stored raster is mirrored so that the orientation restores it. With float
+// set, samples are float32; otherwise 8-bit gray.
+func orientedTestReader(orientation uint16, float bool) *Reader {
+	const w, h, tw, th = 3, 3, 2, 2
+	flipX := orientation == orientTopRight || orientation == orientBottomRight
+	flipY := orientation == orientBottomRight || orientation == orientBottomLeft
+	bps := 1
+	if float {
+		bps = 4
+	}
+
+	var data []byte
+	var offsets, counts []uint64
+	for trow := 0; trow < 2; trow++ {
+		for tcol := 0; tcol < 2; tcol++ {
+			offsets = append(offsets, uint64(len(data)))
+			tile := make([]byte, tw*th*bps)
+			for ly := 0; ly < th; ly++ {
+				for lx := 0; lx < tw; lx++ {
+					sx, sy := tcol*tw+lx, trow*th+ly
+					if sx >= w || sy >= h {
+						continue
+					}
+					v := displayed(sy, h, flipY)*w + displayed(sx, w, flipX) + 1
+					off := (ly*tw + lx) * bps
+					if float {
+						binary.LittleEndian.PutUint32(tile[off:], math.Float32bits(float32(v)))
+					} else {
+						tile[off] = byte(v)
+					}
+				}
+			}
+			data = append(data, tile...)
+			counts = append(counts, uint64(len(tile)))
+		}
+	}
+
+	ifd := IFD{
+		Width: w, Height: h, TileWidth: tw, TileHeight: th,
+		SamplesPerPixel: 1,
+		BitsPerSample:   []uint16{uint16(8 * bps)},
+		Compression:     1,
+		Orientation:     orientation,
+		TileOffsets:     offsets,
+		TileByteCounts:  counts,
+	}
+	return &Reader{bo: binary.LittleEndian, ifds: []IFD{ifd}, data: data}
+}
+
+func TestReadTile_Orientation(t *testing.T) {
+	for _, o := range []uint16{0, orientTopLeft, orientTopRight, orientBottomRight, orientBottomLeft} {
+		r := orientedTestReader(o, false)
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 3; x++ {
+				img, err := r.ReadTile(0, x/2, y/2)
+				if err != nil {
+					t.Fatalf("orientation %d: ReadTile: %v", o, err)
+				}
+				got := img.(*image.RGBA).RGBAAt(x%2, y%2)
+				if want := uint8(y*3 + x + 1); got.R != want || got.A != 255 {
+					t.Errorf("orientation %d: pixel (%d,%d) = %v, want gray %d", o, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestReadFloatTile_Orientation(t *testing.T) {
+	for _, o := range []uint16{orientTopLeft, orientTopRight, orientBottomRight, orientBottomLeft} {
+		r := orientedTestReader(o, true)
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 3; x++ {
+				data, tw, _, err := r.ReadFloatTile(0, x/2, y/2)
+				if err != nil {
+					t.Fatalf("orientation %d: ReadFloatTile: %v", o, err)
+				}
+				if got, want := data[(y%2)*tw+x%2], float32(y*3+x+1); got != want {
+					t.Errorf("orientation %d: pixel (%d,%d) = %v, want %v", o, x, y, got, want)
+				}
+			}
+		}
+	}
+}
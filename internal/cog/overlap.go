@@ -0,0 +1,158 @@
+package cog
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// overlapGrid is the number of sample points per axis laid over each
+// overlap region: 256 samples per pair, enough to tell a misaligned or
+// differently graded delivery from noise.
+const overlapGrid = 16
+
+// OverlapReport describes how much two sources disagree where they overlap.
+type OverlapReport struct {
+	A, B                   int     // indexes into the sources, A < B
+	MinX, MinY, MaxX, MaxY float64 // overlap region in source CRS coordinates
+	Samples                int     // sample points where both sources have data
+	// Per-sample disagreement: the largest channel difference (0-255) for
+	// 8-bit RGB(A) output, the absolute difference for float data.
+	MeanDelta, MaxDelta float64
+}
+
+// CheckOverlaps samples a grid over every region where two sources overlap
+// and compares their pixel values. Each source is read at the overview
+// closest to the grid spacing, so the comparison sees what the sources look
+// like at that scale and stays cheap. Pairs in different CRSs, overlaps
+// narrower than two pixels (abutting files), and pairs without a sample
+// where both have data are skipped. Band config and nodata must be set.
+//
+// Returns the reports sorted by MeanDelta, largest first.
+func CheckOverlaps(sources []*Reader) []OverlapReport {
+	var reports []OverlapReport
+	for i := range sources {
+		aMinX, aMinY, aMaxX, aMaxY := sources[i].BoundsInCRS()
+		for j := i + 1; j < len(sources); j++ {
+			a, b := sources[i], sources[j]
+			if !a.sameCRS(b) || a.IsFloat() != b.IsFloat() {
+				continue
+			}
+			bMinX, bMinY, bMaxX, bMaxY := b.BoundsInCRS()
+			rep := OverlapReport{
+				A: i, B: j,
+				MinX: math.Max(aMinX, bMinX), MinY: math.Max(aMinY, bMinY),
+				MaxX: math.Min(aMaxX, bMaxX), MaxY: math.Min(aMaxY, bMaxY),
+			}
+			minSpan := 2 * math.Max(a.PixelSize(), b.PixelSize())
+			if rep.MaxX-rep.MinX < minSpan || rep.MaxY-rep.MinY < minSpan {
+				continue
+			}
+			compareOverlap(a, b, &rep)
+			if rep.Samples > 0 {
+				reports = append(reports, rep)
+			}
+		}
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].MeanDelta > reports[j].MeanDelta
+	})
+	return reports
+}
+
+// compareOverlap fills in the sample statistics of rep.
+func compareOverlap(a, b *Reader, rep *OverlapReport) {
+	spacing := math.Max(rep.MaxX-rep.MinX, rep.MaxY-rep.MinY) / overlapGrid
+	sa := newOverlapSampler(a, spacing)
+	sb := newOverlapSampler(b, spacing)
+
+	var sum float64
+	for iy := 0; iy < overlapGrid; iy++ {
+		y := rep.MinY + (float64(iy)+0.5)*(rep.MaxY-rep.MinY)/overlapGrid
+		for ix := 0; ix < overlapGrid; ix++ {
+			x := rep.MinX + (float64(ix)+0.5)*(rep.MaxX-rep.MinX)/overlapGrid
+			va, okA := sa.sample(x, y)
+			vb, okB := sb.sample(x, y)
+			if !okA || !okB {
+				continue
+			}
+			var d float64
+			for c := range va {
+				d = math.Max(d, math.Abs(va[c]-vb[c]))
+			}
+			sum += d
+			rep.MaxDelta = math.Max(rep.MaxDelta, d)
+			rep.Samples++
+		}
+	}
+	if rep.Samples > 0 {
+		rep.MeanDelta = sum / float64(rep.Samples)
+	}
+}
+
+// overlapSampler reads single pixels of one source at one level, keeping
+// the decoded tiles of the current pair.
+type overlapSampler struct {
+	r      *Reader
+	level  int
+	pixelX float64 // pixel size of level in CRS units
+	pixelY float64
+	tiles  map[[2]int]image.Image
+	floats map[[2]int][]float32
+}
+
+func newOverlapSampler(r *Reader, spacing float64) *overlapSampler {
+	level := r.OverviewForZoom(spacing)
+	scale := float64(r.ifds[0].Height) / float64(r.ifds[level].Height)
+	return &overlapSampler{
+		r:      r,
+		level:  level,
+		pixelX: r.IFDPixelSize(level),
+		pixelY: r.geo.PixelSizeY * scale,
+		tiles:  make(map[[2]int]image.Image),
+		floats: make(map[[2]int][]float32),
+	}
+}
+
+// sample returns the value at CRS point (x, y): R, G, B for 8-bit output,
+// or the float sample in the first element. ok is false outside the image,
+// for transparent or nodata pixels, and on read errors.
+func (s *overlapSampler) sample(x, y float64) (v [3]float64, ok bool) {
+	geo := s.r.geo
+	ifd := &s.r.ifds[s.level]
+	px := int(math.Floor((x - geo.OriginX) / s.pixelX))
+	py := int(math.Floor((geo.OriginY - y) / s.pixelY))
+	if px < 0 || py < 0 || px >= int(ifd.Width) || py >= int(ifd.Height) {
+		return v, false
+	}
+	tw, th := int(ifd.TileWidth), int(ifd.TileHeight)
+	key := [2]int{px / tw, py / th}
+	lx, ly := px%tw, py%th
+
+	if s.r.IsFloat() {
+		data, cached := s.floats[key]
+		if !cached {
+			data, _, _, _ = s.r.ReadFloatTile(s.level, key[0], key[1])
+			s.floats[key] = data
+		}
+		if data == nil || math.IsNaN(float64(data[ly*tw+lx])) {
+			return v, false
+		}
+		v[0] = float64(data[ly*tw+lx])
+		return v, true
+	}
+
+	img, cached := s.tiles[key]
+	if !cached {
+		img, _ = s.r.ReadTile(s.level, key[0], key[1])
+		s.tiles[key] = img
+	}
+	if img == nil {
+		return v, false
+	}
+	r, g, b, a := img.At(lx, ly).RGBA()
+	if a == 0 {
+		return v, false
+	}
+	return [3]float64{float64(r >> 8), float64(g >> 8), float64(b >> 8)}, true
+}
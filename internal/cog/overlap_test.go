@@ -0,0 +1,52 @@
+package cog
+
+import "testing"
+
+// overlapTestReader is the 3×3 test image (values 1..9 row by row) with its
+// upper-left corner at (x0, 3) in EPSG:2056, one unit per pixel.
+func overlapTestReader(float bool, x0 float64) *Reader {
+	r := orientedTestReader(orientTopLeft, float)
+	if float {
+		r.ifds[0].SampleFormat = []uint16{3}
+	}
+	r.geo = GeoInfo{EPSG: 2056, OriginX: x0, OriginY: 3, PixelSizeX: 1, PixelSizeY: 1}
+	return r
+}
+
+func TestCheckOverlaps(t *testing.T) {
+	for _, float := range []bool{false, true} {
+		same := []*Reader{overlapTestReader(float, 0), overlapTestReader(float, 0)}
+		reports := CheckOverlaps(same)
+		if len(reports) != 1 {
+			t.Fatalf("float=%v identical sources: got %d reports, want 1", float, len(reports))
+		}
+		if r := reports[0]; r.Samples != overlapGrid*overlapGrid || r.MeanDelta != 0 || r.MaxDelta != 0 {
+			t.Errorf("float=%v identical sources: %+v, want all samples with Δ 0", float, r)
+		}
+
+		// Shifted by one pixel, the overlap x ∈ [1, 3] compares column c of
+		// the second source with column c+1 of the first: Δ 1 everywhere.
+		shifted := []*Reader{overlapTestReader(float, 0), overlapTestReader(float, 1)}
+		reports = CheckOverlaps(shifted)
+		if len(reports) != 1 {
+			t.Fatalf("float=%v shifted sources: got %d reports, want 1", float, len(reports))
+		}
+		r := reports[0]
+		if r.MinX != 1 || r.MaxX != 3 || r.MeanDelta != 1 || r.MaxDelta != 1 {
+			t.Errorf("float=%v shifted sources: %+v, want overlap X [1, 3] with Δ 1", float, r)
+		}
+	}
+}
+
+func TestCheckOverlaps_Skipped(t *testing.T) {
+	abutting := []*Reader{overlapTestReader(false, 0), overlapTestReader(false, 2)}
+	if reports := CheckOverlaps(abutting); len(reports) != 0 {
+		t.Errorf("one-pixel overlap: got %+v, want no report", reports)
+	}
+
+	other := overlapTestReader(false, 0)
+	other.geo.EPSG = 4326
+	if reports := CheckOverlaps([]*Reader{overlapTestReader(false, 0), other}); len(reports) != 0 {
+		t.Errorf("different CRS: got %+v, want no report", reports)
+	}
+}
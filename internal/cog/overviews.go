@@ -0,0 +1,193 @@
+package cog
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// synthLevel describes an overview level that is missing from the file and
+// is computed on demand from a finer level by box-averaging factor×factor
+// pixel blocks.
+type synthLevel struct {
+	src    int // index of the real level the tiles are computed from
+	factor int // power of two > 1
+}
+
+// fillOverviewGaps inserts synthetic levels wherever two consecutive levels
+// differ by more than 2× in resolution (e.g. a 1, 4, 16 chain gets 2 and 8),
+// so OverviewForZoom never has to pick a level more than 2× off. ifds must
+// be sorted largest first. Returns the extended level list and, per level,
+// nil for real IFDs or the synthesis recipe.
+func fillOverviewGaps(ifds []IFD) ([]IFD, []*synthLevel) {
+	levels := make([]IFD, 0, len(ifds))
+	synth := make([]*synthLevel, 0, len(ifds))
+	for i := range ifds {
+		levels = append(levels, ifds[i])
+		synth = append(synth, nil)
+		if i+1 == len(ifds) {
+			break
+		}
+		cur, next := &ifds[i], &ifds[i+1]
+		gap := float64(cur.Width) / float64(next.Width)
+		for f := 2; gap/float64(f/2) > 2; f *= 2 {
+			levels = append(levels, IFD{
+				Width:           (cur.Width + uint32(f) - 1) / uint32(f),
+				Height:          (cur.Height + uint32(f) - 1) / uint32(f),
+				TileWidth:       cur.TileWidth,
+				TileHeight:      cur.TileHeight,
+				BitsPerSample:   cur.BitsPerSample,
+				SamplesPerPixel: cur.SamplesPerPixel,
+				SampleFormat:    cur.SampleFormat,
+				ExtraSamples:    cur.ExtraSamples,
+				Photometric:     cur.Photometric,
+				NoData:          cur.NoData,
+			})
+			synth = append(synth, &synthLevel{src: i, factor: f})
+		}
+	}
+	return levels, synth
+}
+
+// SynthesizedOverviews returns the decimation factors (relative to full
+// resolution, rounded) of overview levels that were missing from the file
+// and are computed in memory. Empty for regular overview chains.
+func (r *Reader) SynthesizedOverviews() []int {
+	var factors []int
+	for i, s := range r.synth {
+		if s != nil {
+			factors = append(factors, int(math.Round(float64(r.ifds[0].Width)/float64(r.ifds[i].Width))))
+		}
+	}
+	return factors
+}
+
+// IsSynthesized reports whether level was missing from the file and is
+// computed in memory from a finer level.
+func (r *Reader) IsSynthesized(level int) bool {
+	return r.synthFor(level) != nil
+}
+
+// synthFor returns the synthesis recipe for level, or nil for real levels.
+func (r *Reader) synthFor(level int) *synthLevel {
+	if r.synth == nil {
+		return nil
+	}
+	return r.synth[level]
+}
+
+// readSynthTile computes tile (col, row) of a synthetic level by averaging
+// factor×factor blocks of the source level. The source tiles are read
+// through ReadTile, so orientation is already applied. Averaging the
+// premultiplied RGBA channels, including alpha, weights colours by
+// coverage; source pixels beyond the image edge are excluded.
+func (r *Reader) readSynthTile(level, col, row int, s *synthLevel) (image.Image, error) {
+	ifd := &r.ifds[level]
+	src := &r.ifds[s.src]
+	tw, th := int(ifd.TileWidth), int(ifd.TileHeight)
+	srcW, srcH := int(src.Width), int(src.Height)
+	f := s.factor
+
+	out := image.NewRGBA(image.Rect(0, 0, tw, th))
+	sums := make([]uint32, tw*th*4)
+	counts := make([]uint32, tw*th)
+
+	// The output tile covers f×f source tiles (same tile size).
+	for sr := row * f; sr < (row+1)*f && sr < src.TilesDown(); sr++ {
+		for sc := col * f; sc < (col+1)*f && sc < src.TilesAcross(); sc++ {
+			img, err := r.ReadTile(s.src, sc, sr)
+			if err != nil {
+				return nil, err
+			}
+			rgba := toRGBA(img)
+			for ly := 0; ly < th && sr*th+ly < srcH; ly++ {
+				oy := (sr*th + ly) / f % th
+				for lx := 0; lx < tw && sc*tw+lx < srcW; lx++ {
+					ox := (sc*tw + lx) / f % tw
+					si := rgba.PixOffset(lx, ly)
+					o := oy*tw + ox
+					sums[o*4] += uint32(rgba.Pix[si])
+					sums[o*4+1] += uint32(rgba.Pix[si+1])
+					sums[o*4+2] += uint32(rgba.Pix[si+2])
+					sums[o*4+3] += uint32(rgba.Pix[si+3])
+					counts[o]++
+				}
+			}
+		}
+	}
+
+	for o, n := range counts {
+		if n == 0 {
+			continue
+		}
+		for c := 0; c < 4; c++ {
+			out.Pix[o*4+c] = uint8((sums[o*4+c] + n/2) / n)
+		}
+	}
+	return out, nil
+}
+
+// readSynthFloatTile computes a synthetic float tile by nearest-neighbour
+// decimation (the centre sample of each block). Source tiles come through
+// ReadFloatTile, so nodata is already NaN; picking one sample keeps voids
+// from shrinking or growing the way averaging around them would.
+func (r *Reader) readSynthFloatTile(level, col, row int, s *synthLevel) ([]float32, int, int, error) {
+	ifd := &r.ifds[level]
+	src := &r.ifds[s.src]
+	tw, th := int(ifd.TileWidth), int(ifd.TileHeight)
+	f := s.factor
+	if col < 0 || col >= ifd.TilesAcross() || row < 0 || row >= ifd.TilesDown() {
+		return nil, 0, 0, fmt.Errorf("tile (%d,%d) out of range (%dx%d)", col, row, ifd.TilesAcross(), ifd.TilesDown())
+	}
+
+	var out []float32
+	for sr := row * f; sr < (row+1)*f && sr < src.TilesDown(); sr++ {
+		for sc := col * f; sc < (col+1)*f && sc < src.TilesAcross(); sc++ {
+			data, srcTW, _, err := r.ReadFloatTile(s.src, sc, sr)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			if data == nil {
+				continue
+			}
+			if out == nil {
+				out = make([]float32, tw*th)
+				nan := float32(math.NaN())
+				for i := range out {
+					out[i] = nan
+				}
+			}
+			// Output pixels whose block centre falls in this source tile.
+			for oy := 0; oy < th; oy++ {
+				sy := (row*th+oy)*f + f/2 - sr*th
+				if sy < 0 || sy >= th {
+					continue
+				}
+				for ox := 0; ox < tw; ox++ {
+					sx := (col*tw+ox)*f + f/2 - sc*tw
+					if sx < 0 || sx >= tw {
+						continue
+					}
+					out[oy*tw+ox] = data[sy*srcTW+sx]
+				}
+			}
+		}
+	}
+	return out, tw, th, nil
+}
+
+// toRGBA returns img as *image.RGBA, converting other decoded types (JPEG
+// tiles decode to *image.YCbCr or *image.Gray).
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			out.Set(x, y, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return out
+}
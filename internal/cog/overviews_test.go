@@ -0,0 +1,90 @@
+package cog
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestFillOverviewGaps(t *testing.T) {
+	chain := []IFD{
+		{Width: 1024, Height: 512, TileWidth: 256, TileHeight: 256},
+		{Width: 256, Height: 128, TileWidth: 256, TileHeight: 256},
+		{Width: 16, Height: 8, TileWidth: 256, TileHeight: 256},
+	}
+	levels, synth := fillOverviewGaps(chain)
+	var widths []uint32
+	var srcs []int
+	for i, l := range levels {
+		widths = append(widths, l.Width)
+		if synth[i] != nil {
+			srcs = append(srcs, synth[i].src)
+		}
+	}
+	if want := []uint32{1024, 512, 256, 128, 64, 32, 16}; !reflect.DeepEqual(widths, want) {
+		t.Errorf("widths = %v, want %v", widths, want)
+	}
+	if want := []int{0, 1, 1, 1}; !reflect.DeepEqual(srcs, want) {
+		t.Errorf("synthesized from levels %v, want %v", srcs, want)
+	}
+
+	regular := []IFD{{Width: 1000}, {Width: 500}, {Width: 250}}
+	if levels, _ := fillOverviewGaps(regular); len(levels) != 3 {
+		t.Errorf("regular chain grew to %d levels", len(levels))
+	}
+}
+
+// synthTestReader returns the 3×3 test image from orientedTestReader with a
+// 1×1 overview, so a 2×2 level is synthesized in between.
+func synthTestReader(t *testing.T, orientation uint16, float bool) *Reader {
+	r := orientedTestReader(orientation, float)
+	coarse := r.ifds[0]
+	coarse.Width, coarse.Height = 1, 1
+	r.ifds, r.synth = fillOverviewGaps([]IFD{r.ifds[0], coarse})
+	if len(r.ifds) != 3 || !r.IsSynthesized(1) || r.ifds[1].Width != 2 {
+		t.Fatalf("expected a synthesized 2×2 level, got %d levels", len(r.ifds))
+	}
+	if got := r.SynthesizedOverviews(); !reflect.DeepEqual(got, []int{2}) {
+		t.Fatalf("SynthesizedOverviews() = %v, want [2]", got)
+	}
+	return r
+}
+
+func TestReadTile_Synthesized(t *testing.T) {
+	// Displayed values 1..9 row by row; each output pixel averages the
+	// in-image part of its 2×2 block.
+	r := synthTestReader(t, orientTopRight, false)
+	img, err := r.ReadTile(1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rgba := img.(*image.RGBA)
+	for _, c := range []struct {
+		x, y int
+		want uint8
+	}{
+		{0, 0, 3}, // (1+2+4+5)/4
+		{1, 0, 5}, // (3+6)/2 = 4.5
+		{0, 1, 8}, // (7+8)/2 = 7.5
+		{1, 1, 9},
+	} {
+		if got := rgba.RGBAAt(c.x, c.y); got.R != c.want || got.A != 255 {
+			t.Errorf("pixel (%d,%d) = %v, want gray %d", c.x, c.y, got, c.want)
+		}
+	}
+	if _, err := r.ReadTile(1, 1, 0); err == nil {
+		t.Error("out-of-range tile: expected error")
+	}
+}
+
+func TestReadFloatTile_Synthesized(t *testing.T) {
+	r := synthTestReader(t, orientBottomLeft, true)
+	data, tw, _, err := r.ReadFloatTile(1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nearest neighbour: block (0,0) takes displayed pixel (1,1).
+	if got := data[0*tw+0]; got != 5 {
+		t.Errorf("pixel (0,0) = %v, want 5", got)
+	}
+}
@@ -0,0 +1,112 @@
+package cog
+
+import (
+	"fmt"
+	"image"
+)
+
+// maxPinnedBytes caps the decoded size (4 bytes per pixel) of the level a
+// Reader keeps in memory. The smallest overview of a typical COG is well
+// below this; a file without overviews is pinned only if it is this small.
+const maxPinnedBytes = 16 << 20
+
+// pinnedLevel holds every tile of one level, decoded. Entries are shared by
+// all callers and never modified.
+type pinnedLevel struct {
+	tiles  []image.Image // ReadTile results, row-major
+	floats [][]float32   // ReadFloatTile results, row-major (nil: empty tile)
+	errs   []error       // per-tile decode errors, returned on every read
+}
+
+// PinnedLevel returns the level served from memory, or -1 if even the
+// smallest level is larger than maxPinnedBytes. It is always the last level:
+// the smallest overview, read by every low-zoom tile.
+func (r *Reader) PinnedLevel() int {
+	if len(r.ifds) == 0 || r.lastLevelBytes() > maxPinnedBytes {
+		return -1
+	}
+	return len(r.ifds) - 1
+}
+
+// PinnedBytes returns the decoded size of the pinned level, held in memory
+// once a low-zoom tile has read it; 0 if nothing is pinned.
+func (r *Reader) PinnedBytes() int64 {
+	if r.PinnedLevel() < 0 {
+		return 0
+	}
+	return r.lastLevelBytes()
+}
+
+// lastLevelBytes returns the decoded size of the smallest level.
+func (r *Reader) lastLevelBytes() int64 {
+	ifd := &r.ifds[len(r.ifds)-1]
+	return int64(ifd.TilesAcross()) * int64(ifd.TilesDown()) *
+		int64(ifd.TileWidth) * int64(ifd.TileHeight) * 4
+}
+
+// pinned returns the decoded pinned level for ReadTile (float false) or
+// ReadFloatTile (float true), decoding it on first use. The two are kept
+// apart because they decode the same bytes differently.
+func (r *Reader) pinned(float bool) *pinnedLevel {
+	ptr := &r.pinnedRGBA
+	if float {
+		ptr = &r.pinnedFloat
+	}
+	if p := ptr.Load(); p != nil {
+		return p
+	}
+	r.pinMu.Lock()
+	defer r.pinMu.Unlock()
+	if p := ptr.Load(); p != nil {
+		return p
+	}
+
+	level := r.PinnedLevel()
+	ifd := &r.ifds[level]
+	n := ifd.TilesAcross() * ifd.TilesDown()
+	p := &pinnedLevel{errs: make([]error, n)}
+	if float {
+		p.floats = make([][]float32, n)
+	} else {
+		p.tiles = make([]image.Image, n)
+	}
+	for i := 0; i < n; i++ {
+		col, row := i%ifd.TilesAcross(), i/ifd.TilesAcross()
+		if float {
+			p.floats[i], _, _, p.errs[i] = r.readFloatTileAt(level, col, row)
+		} else {
+			p.tiles[i], p.errs[i] = r.readTileAt(level, col, row)
+		}
+	}
+	ptr.Store(p)
+	return p
+}
+
+// readPinnedTile serves ReadTile for the pinned level. Arguments must be in
+// range.
+func (r *Reader) readPinnedTile(level, col, row int) (image.Image, error) {
+	p := r.pinned(false)
+	i := row*r.ifds[level].TilesAcross() + col
+	return p.tiles[i], p.errs[i]
+}
+
+// readPinnedFloatTile serves ReadFloatTile for the pinned level.
+func (r *Reader) readPinnedFloatTile(level, col, row int) ([]float32, int, int, error) {
+	ifd := &r.ifds[level]
+	if col < 0 || col >= ifd.TilesAcross() || row < 0 || row >= ifd.TilesDown() {
+		return nil, 0, 0, fmt.Errorf("tile (%d,%d) out of range (%dx%d)", col, row, ifd.TilesAcross(), ifd.TilesDown())
+	}
+	p := r.pinned(true)
+	i := row*ifd.TilesAcross() + col
+	if p.errs[i] != nil {
+		return nil, 0, 0, p.errs[i]
+	}
+	return p.floats[i], int(ifd.TileWidth), int(ifd.TileHeight), nil
+}
+
+// unpin drops the decoded pinned level; the next read decodes it again.
+// Called when settings that affect decoding change.
+func (r *Reader) unpin() {
+	r.pinnedRGBA.Store(nil)
+	r.pinnedFloat.Store(nil)
+}
@@ -0,0 +1,148 @@
+package cog
+
+import (
+	"image"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestPinnedLevel(t *testing.T) {
+	r := synthTestReader(t, orientTopLeft, false)
+	if got := r.PinnedLevel(); got != 2 {
+		t.Errorf("PinnedLevel() = %d, want 2 (the smallest overview)", got)
+	}
+
+	big := &Reader{ifds: []IFD{{Width: 4096, Height: 4096, TileWidth: 256, TileHeight: 256}}}
+	if got := big.PinnedLevel(); got != -1 {
+		t.Errorf("PinnedLevel() for a 64 MB level = %d, want -1", got)
+	}
+}
+
+func TestReadTile_PinnedShared(t *testing.T) {
+	r := synthTestReader(t, orientTopLeft, false)
+	a, err := r.ReadTile(2, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := r.ReadTile(2, 0, 0)
+	if a != b {
+		t.Error("pinned tile decoded twice, want the same image")
+	}
+	if _, err := r.ReadTile(2, 1, 0); err == nil {
+		t.Error("out-of-range pinned tile: expected error")
+	}
+
+	// A new band config changes decoding, so the pin is rebuilt.
+	r.SetBandConfig(BandConfig{})
+	if c, _ := r.ReadTile(2, 0, 0); c == a {
+		t.Error("SetBandConfig kept the pinned tile")
+	}
+}
+
+// readAll reads every tile of every level of r.
+func readAll(r *Reader, float bool) []any {
+	var out []any
+	for level := range r.ifds {
+		ifd := &r.ifds[level]
+		for row := 0; row < ifd.TilesDown(); row++ {
+			for col := 0; col < ifd.TilesAcross(); col++ {
+				if float {
+					data, w, h, err := r.ReadFloatTile(level, col, row)
+					out = append(out, [4]any{data, w, h, err})
+				} else {
+					img, err := r.ReadTile(level, col, row)
+					out = append(out, [2]any{img, err})
+				}
+			}
+		}
+	}
+	return out
+}
+
+// testConcurrentReads reads all tiles of a fresh reader from many goroutines
+// at once, including the first decode of the pinned level, and compares the
+// results with a sequential read. Meaningful under -race.
+func testConcurrentReads(t *testing.T, float bool) {
+	t.Helper()
+	want := readAll(synthTestReader(t, orientBottomRight, float), float)
+
+	r := synthTestReader(t, orientBottomRight, float)
+	const goroutines = 16
+	results := make([][]any, goroutines)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = readAll(r, float)
+		}()
+	}
+	wg.Wait()
+
+	for i, got := range results {
+		if !equalTiles(got, want) {
+			t.Fatalf("goroutine %d: tiles differ from a sequential read", i)
+		}
+	}
+}
+
+func TestReadTile_Concurrent(t *testing.T) {
+	testConcurrentReads(t, false)
+}
+
+func TestReadFloatTile_Concurrent(t *testing.T) {
+	testConcurrentReads(t, true)
+}
+
+// equalTiles compares readAll results by pixel content.
+func equalTiles(a, b []any) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		switch x := a[i].(type) {
+		case [2]any:
+			y := b[i].([2]any)
+			if (x[1] == nil) != (y[1] == nil) {
+				return false
+			}
+			xi, _ := x[0].(image.Image)
+			yi, _ := y[0].(image.Image)
+			if (xi == nil) != (yi == nil) || (xi != nil && !equalImages(xi, yi)) {
+				return false
+			}
+		case [4]any:
+			y := b[i].([4]any)
+			if x[1] != y[1] || x[2] != y[2] || (x[3] == nil) != (y[3] == nil) {
+				return false
+			}
+			xd, yd := x[0].([]float32), y[0].([]float32)
+			if len(xd) != len(yd) {
+				return false
+			}
+			for j := range xd {
+				if xd[j] != yd[j] && !(math.IsNaN(float64(xd[j])) && math.IsNaN(float64(yd[j]))) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// equalImages compares two images pixel by pixel.
+func equalImages(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if a.At(x, y) != b.At(x, y) {
+				return false
+			}
+		}
+	}
+	return true
+}
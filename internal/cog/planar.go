@@ -0,0 +1,106 @@
+package cog
+
+import "fmt"
+
+// Band-separate TIFFs (PlanarConfiguration 2) store each band as a plane of
+// its own: all tiles (or strips) of the first band, then all of the second,
+// and so on, each with one sample per pixel. Such tiles are read plane by
+// plane, each decompressed and un-predicted as a single-band tile, and
+// interleaved into the pixel-interleaved layout the decoders expect.
+
+// planar reports whether the bands are stored in separate planes.
+func (ifd *IFD) planar() bool {
+	return ifd.PlanarConfig == 2 && ifd.SamplesPerPixel > 1
+}
+
+// plane returns the single-band IFD of band s of a planar IFD: its bit
+// depth and sample format, and the tiles of its plane.
+func (ifd *IFD) plane(s int) IFD {
+	p := *ifd
+	p.SamplesPerPixel = 1
+	p.PlanarConfig = 1
+	p.BitsPerSample = []uint16{uint16(ifd.bandBits()[s])}
+	if s < len(ifd.SampleFormat) {
+		p.SampleFormat = ifd.SampleFormat[s : s+1]
+	}
+	p.ExtraSamples = nil
+	n := ifd.TilesAcross() * ifd.TilesDown()
+	p.TileOffsets = planeSlice(ifd.TileOffsets, s, n)
+	p.TileByteCounts = planeSlice(ifd.TileByteCounts, s, n)
+	return p
+}
+
+// planeSlice returns entries [s*n, (s+1)*n) of a per-plane array, cut
+// short where the array is; missing tiles are read as out of range.
+func planeSlice(v []uint64, s, n int) []uint64 {
+	lo, hi := min(s*n, len(v)), min((s+1)*n, len(v))
+	return v[lo:hi]
+}
+
+// checkPlanar returns why a planar IFD cannot be read, or "" when it can.
+func (ifd *IFD) checkPlanar() string {
+	if ifd.imageCoded() {
+		return fmt.Sprintf("band-separate (PlanarConfiguration 2) %s tiles are not supported", compressionName(ifd.Compression))
+	}
+	for _, b := range ifd.bandBits() {
+		if b%8 != 0 {
+			return fmt.Sprintf("band-separate (PlanarConfiguration 2) %d-bit bands are not supported", b)
+		}
+	}
+	if ifd.mixedDepth() {
+		return fmt.Sprintf("band-separate (PlanarConfiguration 2) bands of different bit depths %v are not supported", ifd.bandBits())
+	}
+	return ""
+}
+
+// readPlanarTile reads tile (col, row) of a planar level from each band
+// plane and returns the pixel-interleaved bytes, or nil when every plane's
+// tile is empty. An empty plane reads as zeros.
+func (r *Reader) readPlanarTile(level, col, row int) ([]byte, error) {
+	ifd := &r.ifds[level]
+	spp := int(ifd.SamplesPerPixel)
+	planes := make([][]byte, spp)
+	empty := true
+	for s := range planes {
+		p := ifd.plane(s)
+		var err error
+		if r.strip != nil && level == 0 {
+			planes[s], _, err = r.readStripTileRaw(&p, row, s)
+		} else {
+			planes[s], err = r.readIFDTile(&p, col, row)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("band %d: %w", s+1, err)
+		}
+		if planes[s] != nil {
+			empty = false
+		}
+	}
+	if empty {
+		return nil, nil
+	}
+	return interleavePlanes(planes, ifd.bytesPerSample()), nil
+}
+
+// interleavePlanes interleaves band planes of bps-byte samples pixel by
+// pixel. The result covers the pixels of the shortest non-empty plane; nil
+// planes contribute zeros.
+func interleavePlanes(planes [][]byte, bps int) []byte {
+	pixels := -1
+	for _, p := range planes {
+		if p != nil && (pixels < 0 || len(p)/bps < pixels) {
+			pixels = len(p) / bps
+		}
+	}
+	spp := len(planes)
+	out := make([]byte, pixels*spp*bps)
+	for s, p := range planes {
+		if p == nil {
+			continue
+		}
+		for i := 0; i < pixels; i++ {
+			copy(out[(i*spp+s)*bps:(i*spp+s+1)*bps], p[i*bps:(i+1)*bps])
+		}
+	}
+	return out
+}
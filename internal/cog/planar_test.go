@@ -0,0 +1,139 @@
+package cog
+
+import (
+	"encoding/binary"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+// planarRGB returns three 4x4 8-bit band planes: band s of pixel i is
+// 100*s + i.
+func planarRGB() []byte {
+	var data []byte
+	for s := 0; s < 3; s++ {
+		for i := 0; i < 16; i++ {
+			data = append(data, byte(100*s+i))
+		}
+	}
+	return data
+}
+
+func checkPlanarRGB(t *testing.T, r *Reader) {
+	t.Helper()
+	img, err := r.ReadTile(0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range [][2]int{{0, 0}, {3, 0}, {1, 2}, {3, 3}} {
+		x, y := p[0], p[1]
+		i := y*4 + x
+		got := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+		want := color.RGBA{uint8(i), uint8(100 + i), uint8(200 + i), 255}
+		if got != want {
+			t.Errorf("pixel (%d,%d) = %v, want %v", x, y, got, want)
+		}
+	}
+}
+
+func TestReadPlanarTile(t *testing.T) {
+	data := planarRGB()
+	ifd := IFD{
+		Width: 4, Height: 4, TileWidth: 4, TileHeight: 4,
+		SamplesPerPixel: 3, BitsPerSample: []uint16{8, 8, 8}, PlanarConfig: 2, Photometric: 2, Compression: 1,
+		TileOffsets: []uint64{0, 16, 32}, TileByteCounts: []uint64{16, 16, 16},
+	}
+	checkPlanarRGB(t, &Reader{bo: binary.LittleEndian, ifds: []IFD{ifd}, data: data})
+}
+
+func TestReadPlanarStrips(t *testing.T) {
+	// Two 2-row strips per band.
+	data := planarRGB()
+	ifd := IFD{
+		Width: 4, Height: 4, RowsPerStrip: 2,
+		SamplesPerPixel: 3, BitsPerSample: []uint16{8, 8, 8}, PlanarConfig: 2, Photometric: 2, Compression: 1,
+		StripOffsets:    []uint64{0, 8, 16, 24, 32, 40},
+		StripByteCounts: []uint64{8, 8, 8, 8, 8, 8},
+	}
+	sl := promoteStripsToTiles(&ifd)
+	if got := ifd.TileOffsets; len(got) != 3 || got[1] != 16 || got[2] != 32 {
+		t.Errorf("virtual tile offsets = %v, want one tile per band", got)
+	}
+	checkPlanarRGB(t, &Reader{bo: binary.LittleEndian, ifds: []IFD{ifd}, data: data, strip: sl})
+}
+
+// floatPredict applies the floating-point predictor (3) to the rows of a
+// single-band float32 plane in place.
+func floatPredict(data []byte, width int) {
+	rowBytes := width * 4
+	for off := 0; off < len(data); off += rowBytes {
+		row := data[off : off+rowBytes]
+		shuffled := make([]byte, rowBytes)
+		for s := 0; s < width; s++ {
+			for b := 0; b < 4; b++ {
+				shuffled[b*width+s] = row[s*4+b]
+			}
+		}
+		for i := rowBytes - 1; i > 0; i-- {
+			shuffled[i] -= shuffled[i-1]
+		}
+		copy(row, shuffled)
+	}
+}
+
+func TestReadPlanarFloatTile(t *testing.T) {
+	// Two 4x4 float32 bands, each with the floating-point predictor; the
+	// first band is the value read.
+	var data []byte
+	for s := 0; s < 2; s++ {
+		plane := make([]byte, 64)
+		for i := 0; i < 16; i++ {
+			binary.LittleEndian.PutUint32(plane[i*4:], math.Float32bits(float32(1000*s)+float32(i)/4))
+		}
+		floatPredict(plane, 4)
+		data = append(data, plane...)
+	}
+	ifd := IFD{
+		Width: 4, Height: 4, TileWidth: 4, TileHeight: 4,
+		SamplesPerPixel: 2, BitsPerSample: []uint16{32, 32}, SampleFormat: []uint16{3, 3},
+		PlanarConfig: 2, Predictor: 3, Compression: 1,
+		TileOffsets: []uint64{0, 64}, TileByteCounts: []uint64{64, 64},
+	}
+	r := &Reader{bo: binary.LittleEndian, ifds: []IFD{ifd}, data: data}
+
+	vals, _, _, err := r.ReadFloatTile(0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range vals {
+		if want := float32(i) / 4; v != want {
+			t.Errorf("value %d = %v, want %v", i, v, want)
+		}
+	}
+}
+
+func TestInterleavePlanes(t *testing.T) {
+	got := interleavePlanes([][]byte{{1, 2, 3, 4}, nil, {5, 6}}, 2)
+	want := []byte{1, 2, 0, 0, 5, 6}
+	if string(got) != string(want) {
+		t.Errorf("interleavePlanes = %v, want %v", got, want)
+	}
+}
+
+func TestCheckPlanar(t *testing.T) {
+	for _, c := range []struct {
+		ifd  IFD
+		want string
+	}{
+		{IFD{SamplesPerPixel: 3, BitsPerSample: []uint16{8, 8, 8}, Compression: 8}, ""},
+		{IFD{SamplesPerPixel: 3, BitsPerSample: []uint16{8, 8, 8}, Compression: 7}, "JPEG"},
+		{IFD{SamplesPerPixel: 2, BitsPerSample: []uint16{4, 4}, Compression: 1}, "4-bit"},
+		{IFD{SamplesPerPixel: 2, BitsPerSample: []uint16{8, 16}, Compression: 1}, "different bit depths"},
+	} {
+		got := c.ifd.checkPlanar()
+		if (got == "") != (c.want == "") || !strings.Contains(got, c.want) {
+			t.Errorf("checkPlanar(%v, compression %d) = %q, want %q", c.ifd.BitsPerSample, c.ifd.Compression, got, c.want)
+		}
+	}
+}
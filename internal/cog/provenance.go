@@ -0,0 +1,113 @@
+package cog
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BandInfo is per-band provenance from GDAL_METADATA: the band description,
+// unit, and the statistics GDAL stores after gdalinfo -stats or
+// gdal_translate -stats.
+type BandInfo struct {
+	Band        int    // 1-indexed band number
+	Description string // DESCRIPTION item, e.g. "Red" or "B04"
+	Unit        string // UNITTYPE item, e.g. "metre"
+
+	HasStats bool // true when at least minimum and maximum are present
+	Min      float64
+	Max      float64
+	Mean     float64
+	StdDev   float64
+}
+
+// acquisitionDateKeys are dataset-level GDAL metadata item names that carry
+// the acquisition (sensing) time, in order of preference. Matching is
+// case-insensitive. Different producers use different conventions (GDAL
+// IMAGERY domain, Landsat MTL, Sentinel-2 SAFE, GEE exports).
+var acquisitionDateKeys = []string{
+	"ACQUISITIONDATETIME",
+	"ACQUISITION_DATE",
+	"DATE_ACQUIRED",
+	"SENSING_TIME",
+	"SENSING_DATE",
+	"PRODUCT_START_TIME",
+	"SYSTEM:TIME_START",
+}
+
+// overviewResamplingKeys are item names under which GDAL and gdaladdo
+// record the resampling used to build the overviews.
+var overviewResamplingKeys = []string{
+	"OVERVIEW_RESAMPLING",
+	"OVR_RESAMPLING",
+	"RESAMPLING",
+}
+
+// Bands returns the bands that carry a description, unit, or statistics,
+// ordered by band number. Returns nil when md is nil.
+func (md *GDALMeta) Bands() []BandInfo {
+	if md == nil {
+		return nil
+	}
+	var bands []BandInfo
+	for sample, items := range md.BandItems {
+		b := BandInfo{
+			Band:        sample + 1,
+			Description: strings.TrimSpace(items["DESCRIPTION"]),
+			Unit:        strings.TrimSpace(items["UNITTYPE"]),
+		}
+		min, okMin := parseStat(items["STATISTICS_MINIMUM"])
+		max, okMax := parseStat(items["STATISTICS_MAXIMUM"])
+		if okMin && okMax {
+			b.HasStats = true
+			b.Min, b.Max = min, max
+			b.Mean, _ = parseStat(items["STATISTICS_MEAN"])
+			b.StdDev, _ = parseStat(items["STATISTICS_STDDEV"])
+		}
+		if b.Description == "" && b.Unit == "" && !b.HasStats {
+			continue
+		}
+		bands = append(bands, b)
+	}
+	sort.Slice(bands, func(i, j int) bool { return bands[i].Band < bands[j].Band })
+	return bands
+}
+
+// AcquisitionDate returns the acquisition date/time recorded in the
+// dataset-level metadata, or "" if none of the known keys is present.
+func (md *GDALMeta) AcquisitionDate() string {
+	return md.lookup(acquisitionDateKeys)
+}
+
+// OverviewResampling returns the resampling method the overviews were built
+// with (e.g. "AVERAGE", "NEAREST"), or "" if not recorded.
+func (md *GDALMeta) OverviewResampling() string {
+	return md.lookup(overviewResamplingKeys)
+}
+
+// lookup returns the first dataset-level item matching one of keys
+// (case-insensitive), trimmed.
+func (md *GDALMeta) lookup(keys []string) string {
+	if md == nil {
+		return ""
+	}
+	for _, k := range keys {
+		for name, v := range md.Items {
+			if strings.EqualFold(name, k) {
+				if v = strings.TrimSpace(v); v != "" {
+					return v
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func parseStat(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	return v, err == nil
+}
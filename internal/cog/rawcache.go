@@ -0,0 +1,130 @@
+package cog
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// rawKey identifies the compressed bytes [offset, offset+size) of a file,
+// by reader ID (see Reader.ID).
+type rawKey struct {
+	id     int
+	offset uint64
+	size   uint64
+}
+
+// RawCache is the second tier behind the decoded tile caches (TileCache,
+// FloatTileCache): an LRU of compressed tile and strip bytes as stored in
+// the file, keyed by byte range and bounded in bytes. A tile evicted from
+// the decoded tier is decoded again from here instead of being read from
+// storage again. Compressed tiles are several times smaller than decoded
+// ones, so the same memory holds many more of them; this pays off where
+// reads are slow (network file systems, remote inputs) rather than
+// decoding. Readers use it once set with Reader.SetRawCache. Safe for
+// concurrent use; entries are read-only.
+type RawCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	entries  map[rawKey]*list.Element
+	lru      list.List // of *rawEntry, most recent first
+
+	hits, misses atomic.Int64
+}
+
+type rawEntry struct {
+	key  rawKey
+	data []byte
+}
+
+// NewRawCache creates a cache of up to maxBytes of compressed tiles.
+func NewRawCache(maxBytes int64) *RawCache {
+	return &RawCache{
+		maxBytes: maxBytes,
+		entries:  make(map[rawKey]*list.Element),
+	}
+}
+
+// Limit returns the size of the cache in bytes.
+func (c *RawCache) Limit() int64 {
+	return c.maxBytes
+}
+
+// Stats returns the number of lookups that found the bytes cached and that
+// had to read them from the file.
+func (c *RawCache) Stats() (hits, misses int64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+// get returns the bytes of key, or nil.
+func (c *RawCache) get(key rawKey) []byte {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(e)
+	}
+	c.mu.Unlock()
+	if !ok {
+		c.misses.Add(1)
+		return nil
+	}
+	c.hits.Add(1)
+	return e.Value.(*rawEntry).data
+}
+
+// put caches data as key, evicting least recently used entries to stay
+// within the limit. Entries larger than the whole cache are not kept.
+func (c *RawCache) put(key rawKey, data []byte) {
+	n := int64(len(data))
+	if n > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	for c.size+n > c.maxBytes && c.lru.Len() > 0 {
+		old := c.lru.Remove(c.lru.Back()).(*rawEntry)
+		delete(c.entries, old.key)
+		c.size -= int64(len(old.data))
+	}
+	c.entries[key] = c.lru.PushFront(&rawEntry{key: key, data: data})
+	c.size += n
+}
+
+// SetRawCache makes r keep the compressed bytes it reads in c, shared with
+// the other readers given the same cache (nil turns it off). Like
+// SetBandConfig it must not run concurrently with reads.
+func (r *Reader) SetRawCache(c *RawCache) {
+	r.raw = c
+}
+
+// RawCache returns the cache set with SetRawCache, or nil.
+func (r *Reader) RawCache() *RawCache {
+	return r.raw
+}
+
+// compressedAt returns the stored bytes [offset, end) of a tile or strip,
+// through the raw cache if one is set. Bytes of local files are copied out
+// of the mapping before they are cached, so that they stay in memory when
+// the kernel drops the mapped pages.
+func (r *Reader) compressedAt(offset, end uint64) ([]byte, error) {
+	if r.raw == nil {
+		return r.bytesAt(offset, end)
+	}
+	key := rawKey{id: r.id, offset: offset, size: end - offset}
+	if data := r.raw.get(key); data != nil {
+		return data, nil
+	}
+	data, err := r.bytesAt(offset, end)
+	if err != nil {
+		return nil, err
+	}
+	if r.remote == nil {
+		data = append([]byte(nil), data...)
+	}
+	r.raw.put(key, data)
+	return data, nil
+}
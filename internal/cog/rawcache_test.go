@@ -0,0 +1,41 @@
+package cog
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRawCache(t *testing.T) {
+	// Four uncompressed tiles of 4 bytes; the cache holds two of them.
+	r := orientedTestReader(orientTopLeft, false)
+	want := make(map[[2]int][]byte)
+	for _, tl := range [][2]int{{0, 0}, {1, 0}, {0, 1}} {
+		data, _, err := r.readTileRaw(0, tl[0], tl[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[tl] = data
+	}
+
+	c := NewRawCache(8)
+	r.SetRawCache(c)
+	for _, tl := range [][2]int{{0, 0}, {1, 0}, {0, 0}, {1, 0}, {0, 1}, {0, 0}} {
+		data, _, err := r.readTileRaw(0, tl[0], tl[1])
+		if err != nil || !bytes.Equal(data, want[tl]) {
+			t.Fatalf("tile %v = %v, %v; want %v", tl, data, err, want[tl])
+		}
+	}
+	// (0,1) evicts (0,0), the least recently used.
+	if hits, misses := c.Stats(); hits != 2 || misses != 4 {
+		t.Errorf("Stats() = %d hits, %d misses; want 2, 4", hits, misses)
+	}
+
+	// The predictor is undone on a copy: a cached tile reads the same twice.
+	r.ifds[0].Predictor = 2
+	r.SetRawCache(NewRawCache(8))
+	first, _, _ := r.readTileRaw(0, 1, 0)
+	second, _, _ := r.readTileRaw(0, 1, 0)
+	if !bytes.Equal(first, second) {
+		t.Errorf("predictor tile read %v, then %v from the cache", first, second)
+	}
+}
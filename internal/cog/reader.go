@@ -5,6 +5,7 @@ import (
 	"compress/flate"
 	"compress/zlib"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
@@ -15,6 +16,13 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog/remote"
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+	"github.com/pspoerri/geotiff2pmtiles/internal/hint"
 )
 
 // RescaleMode specifies how to rescale sample values to uint8.
@@ -36,6 +44,9 @@ type BandConfig struct {
 	RescaleMax float64     // Input value range maximum
 	HasNodata  bool        // if true, pixels with all bands == Nodata are decoded as transparent (alpha=0)
 	Nodata     float64     // raw (pre-rescale) nodata value; valid when HasNodata is true
+
+	HasBilevelColors bool          // if true, 1-bit sources are drawn in BilevelColors instead of black and white
+	BilevelColors    [2]color.RGBA // 1-bit sources: foreground (black) and background (white) colors, not premultiplied
 }
 
 // String returns a human-readable summary of the band configuration.
@@ -58,6 +69,10 @@ func (cfg BandConfig) String() string {
 	if cfg.HasNodata {
 		fmt.Fprintf(&b, ", nodata %.0f", cfg.Nodata)
 	}
+	if cfg.HasBilevelColors {
+		fg, bg := cfg.BilevelColors[0], cfg.BilevelColors[1]
+		fmt.Fprintf(&b, ", 1-bit colors #%02x%02x%02x%02x on #%02x%02x%02x%02x", fg.R, fg.G, fg.B, fg.A, bg.R, bg.G, bg.B, bg.A)
+	}
 	return b.String()
 }
 
@@ -73,32 +88,74 @@ func (b Bounds) CenterLat() float64 {
 }
 
 // Reader provides tile-level access to a COG/GeoTIFF file.
-// The file is memory-mapped for lock-free concurrent access.
+// Local files are memory-mapped for lock-free concurrent access; remote
+// files (URLs, see Open) are read through the block cache of package remote.
+//
+// ReadTile, ReadFloatTile and every method built on them or on the parsed
+// metadata are safe for concurrent use by any number of goroutines. Returned
+// tiles may be shared with other callers (see PinnedLevel) and must not be
+// modified. The setters (SetBandConfig, SetFloatNoData, SetIntegerNoData) and Close must not
+// run concurrently with reads.
+//
+// The smallest level is pinned: decoded in full on first use and served from
+// memory from then on, so low-zoom tiles never hit the disk.
 type Reader struct {
-	data    []byte // memory-mapped file contents
+	data    []byte       // memory-mapped file contents, nil for remote files
+	remote  *remote.File // remote file, nil for local files
 	bo      binary.ByteOrder
-	ifds    []IFD
+	ifds    []IFD         // full resolution first, then overviews (largest first)
+	synth   []*synthLevel // per level: nil for IFDs in the file, else how to compute it
+	masks   []IFD         // transparency mask IFDs (NewSubfileType bit 2)
 	geo     GeoInfo
+	guess   *EPSGGuess // how geo.EPSG was inferred, nil when GeoKeys define it
+	crs     *wktCRS    // CRS from embedded WKT when the GeoKeys give no EPSG code
+	crsErr  error      // why the embedded WKT could not be used
 	path    string
 	id      int          // unique numeric ID for fast cache keying (set by OpenAll)
+	raw     *RawCache    // compressed tile bytes shared by readers (set via SetRawCache), nil = off
 	strip   *stripLayout // non-nil for strip-based TIFFs promoted to virtual tiles
 	bandCfg BandConfig   // band selection and rescaling config (set via SetBandConfig)
+
+	// levelMasks holds, per level, the mask IFD applied as alpha, nil for
+	// levels without one (see matchMasks).
+	levelMasks  []*IFD
+	floatNoData NoDataSpec // float samples turned into NaN on decode (set via SetFloatNoData)
+	// intNoData replaces the band config's and the file's nodata value for
+	// integer samples when hasIntNoData is set (SetIntegerNoData).
+	intNoData    NoDataSpec
+	hasIntNoData bool
+
+	pinMu       sync.Mutex                  // serializes decoding of the pinned level
+	pinnedRGBA  atomic.Pointer[pinnedLevel] // PinnedLevel as decoded by ReadTile, nil until first use
+	pinnedFloat atomic.Pointer[pinnedLevel] // PinnedLevel as decoded by ReadFloatTile, nil until first use
 }
 
 // stripLayout stores the original strip layout for strip-based TIFFs.
 // Virtual tiles are composed from multiple strips at read time.
 type stripLayout struct {
-	offsets       []uint64
-	byteCounts    []uint64
-	rowsPerStrip  uint32
-	stripsPerTile int // number of original strips per virtual tile
+	offsets        []uint64
+	byteCounts     []uint64
+	rowsPerStrip   uint32
+	stripsPerTile  int // number of original strips per virtual tile
+	stripsPerPlane int // strips of one band plane (PlanarConfig 2), or all
 }
 
 // Open opens a COG/GeoTIFF file by memory-mapping it and parsing its structure.
 // If a TFW (TIFF World File) sidecar is found, it is used for georeferencing
 // when the TIFF lacks embedded GeoTIFF tags. Strip-based TIFFs are supported
 // by converting the strip layout into a virtual tile layout.
+//
+// A path that is an http://, https://, or s3:// URL (see remote.IsURL) opens
+// the remote file, read by range requests; TFW sidecars are not looked for.
 func Open(path string) (*Reader, error) {
+	if remote.IsURL(path) {
+		rf, err := remote.Open(path, remote.Options{})
+		if err != nil {
+			return nil, fmt.Errorf("opening %w", err)
+		}
+		return newReader(path, nil, rf)
+	}
+
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("opening %s: %w", path, err)
@@ -115,70 +172,161 @@ func Open(path string) (*Reader, error) {
 		return nil, fmt.Errorf("%s: empty file", path)
 	}
 
+	if int64(int(size)) != size {
+		return nil, fmt.Errorf("%s: %d bytes are too large to map on this platform", path, size)
+	}
 	data, err := mmapFile(f.Fd(), int(size))
 	if err != nil {
 		return nil, fmt.Errorf("mmap %s: %w", path, err)
 	}
+	return newReader(path, data, nil)
+}
+
+// newReader parses the file at path, memory-mapped as data or remote as
+// rf. The reader owns both; they are released when parsing fails.
+func newReader(path string, data []byte, rf *remote.File) (*Reader, error) {
+	r := &Reader{data: data, remote: rf, path: path}
+	var src io.ReadSeeker = bytes.NewReader(data)
+	if rf != nil {
+		src = io.NewSectionReader(rf, 0, rf.Size())
+	}
 
-	ifds, bo, err := parseTIFF(bytes.NewReader(data))
+	ifds, bo, err := parseTIFF(src)
 	if err != nil {
-		munmapFile(data)
+		r.Close()
 		return nil, fmt.Errorf("parsing %s: %w", path, err)
 	}
 
 	if len(ifds) == 0 {
-		munmapFile(data)
+		r.Close()
 		return nil, fmt.Errorf("%s: no IFDs found", path)
 	}
 
+	// Order IFDs as full resolution, then overviews; set masks aside.
+	ifds, masks, err := classifyIFDs(ifds)
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
 	first := &ifds[0]
 
 	// Strip-based TIFFs: convert the strip layout into virtual tiles.
 	var sl *stripLayout
 	if first.TileWidth == 0 || first.TileHeight == 0 {
+		if n, m := len(first.StripOffsets), len(first.StripByteCounts); n > 0 && m < n {
+			r.Close()
+			return nil, fmt.Errorf("%s: %d strip offsets but %d strip byte counts", path, n, m)
+		}
 		if len(first.StripOffsets) > 0 {
 			sl = promoteStripsToTiles(first)
 		} else {
-			munmapFile(data)
-			return nil, fmt.Errorf("%s: no tile or strip layout found", path)
+			r.Close()
+			return nil, hint.New(path, "no tile or strip layout found (TileOffsets or StripOffsets)", hint.GDALTranslate(path))
 		}
 	}
 
+	if o := first.Orientation; o > 4 {
+		r.Close()
+		return nil, hint.New(path, fmt.Sprintf("TIFF orientation %d (%s, rows and columns transposed) is not supported", o, tagEnums[tagOrientation][int(o)]),
+			"rewrite the file with orientation 1 (top-left) in the software that produced it")
+	}
+
+	if first.mixedDepth() && first.Predictor > 1 {
+		r.Close()
+		return nil, hint.New(path, fmt.Sprintf("predictor %d with bands of different bit depths %v is not supported", first.Predictor, first.bandBits()),
+			hint.GDALTranslate(path, "-co PREDICTOR=NO"))
+	}
+
 	switch first.Compression {
-	case 1, 5, 7, 8, 32946:
-		// Supported: None, LZW, JPEG, Deflate
+	case 1, 5, 7, 8, 32946, 50000:
+		// Supported: None, LZW, JPEG, Deflate, ZSTD
+	case 50001:
+		// WebP tiles decode with libwebp, which needs cgo.
+		if !encode.WebPAvailable() {
+			r.Close()
+			return nil, hint.New(path, "WebP-compressed TIFFs need a build with libwebp (CGO_ENABLED=1)",
+				hint.GDALTranslate(path, "-co COMPRESS=DEFLATE"))
+		}
+		if sl != nil {
+			r.Close()
+			return nil, hint.New(path, "WebP-compressed strips are not supported", hint.GDALTranslate(path, "-co TILED=YES"))
+		}
 	default:
-		munmapFile(data)
-		return nil, fmt.Errorf("%s: unsupported compression type %d", path, first.Compression)
+		r.Close()
+		return nil, hint.New(path, fmt.Sprintf("compression %d (%s) is not supported (supported: none, LZW, JPEG, Deflate, ZSTD, WebP)", first.Compression, compressionName(first.Compression)),
+			hint.GDALTranslate(path, "-co COMPRESS=DEFLATE"))
+	}
+
+	for i := range ifds {
+		if !ifds[i].planar() {
+			continue
+		}
+		if problem := ifds[i].checkPlanar(); problem != "" {
+			r.Close()
+			return nil, hint.New(path, problem, hint.GDALTranslate(path, "-co INTERLEAVE=PIXEL"))
+		}
 	}
 
 	geo := parseGeoInfo(first)
 
 	// If GeoTIFF tags are absent, try a TFW sidecar.
-	if geo.PixelSizeX == 0 && geo.PixelSizeY == 0 {
+	if geo.PixelSizeX == 0 && geo.PixelSizeY == 0 && rf == nil {
 		if tfwPath := findTFW(path); tfwPath != "" {
 			tfw, err := parseTFW(tfwPath)
+			if errors.Is(err, errRotatedTFW) {
+				r.Close()
+				return nil, hint.Wrap(err, tfwPath, "world file", hint.GDALWarp(path, ""))
+			}
 			if err != nil {
-				munmapFile(data)
+				r.Close()
 				return nil, err
 			}
 			geo = tfw.toGeoInfo()
 		}
 	}
 
+	// GeoKeys without an EPSG code, or with the user-defined one: read the
+	// CRS from the WKT that GDAL and ESRI put in GeoAsciiParams.
+	var crs *wktCRS
+	var crsErr error
+	if geo.EPSG == 0 || geo.EPSG == coord.UserDefined {
+		if wkt := embeddedWKT(first.GeoAsciiParams); wkt != "" {
+			crs, crsErr = crsFromWKT(wkt)
+			geo.EPSG = coord.UserDefined
+			if crsErr == nil {
+				geo.EPSG = crs.EPSG
+			}
+		}
+	}
+
 	// Infer EPSG when GeoKeys didn't provide one.
+	var guess *EPSGGuess
 	if geo.EPSG == 0 && geo.PixelSizeX > 0 {
-		geo.EPSG = inferEPSG(geo, first.Width, first.Height)
+		g := inferEPSG(geo, first.Width, first.Height)
+		geo.EPSG = g.EPSG
+		guess = &g
 	}
 
-	return &Reader{
-		data:  data,
-		bo:    bo,
-		ifds:  ifds,
-		geo:   geo,
-		path:  path,
-		strip: sl,
-	}, nil
+	// Fill gaps in irregular overview chains (e.g. 1, 4, 16) with levels
+	// computed in memory, after strip promotion has fixed the tile size.
+	ifds, synth := fillOverviewGaps(ifds)
+
+	// Float nodata defaults to GDAL_NODATA; an unparsable tag masks nothing.
+	floatNoData, _ := ParseNoDataSpec(first.NoData)
+
+	r.floatNoData = floatNoData
+	r.bo = bo
+	r.ifds = ifds
+	r.synth = synth
+	r.masks = masks
+	r.levelMasks = matchMasks(ifds, synth, masks, sl != nil)
+	r.geo = geo
+	r.guess = guess
+	r.crs = crs
+	r.crsErr = crsErr
+	r.strip = sl
+	return r, nil
 }
 
 // promoteStripsToTiles converts a strip-based IFD into a virtual tile layout.
@@ -198,30 +346,41 @@ func promoteStripsToTiles(ifd *IFD) *stripLayout {
 	}
 	virtualTileH := rps * uint32(stripsPerTile)
 
+	// Band-separate files store the strips of each band in turn; the
+	// virtual tiles follow the same layout, one set per plane.
 	totalStrips := len(ifd.StripOffsets)
-	numVirtualTiles := (totalStrips + stripsPerTile - 1) / stripsPerTile
-
-	virtualOffsets := make([]uint64, numVirtualTiles)
-	virtualByteCounts := make([]uint64, numVirtualTiles)
-	for i := 0; i < numVirtualTiles; i++ {
-		startStrip := i * stripsPerTile
+	planes := 1
+	if ifd.planar() {
+		planes = int(ifd.SamplesPerPixel)
+	}
+	stripsPerPlane := totalStrips / planes
+	tilesPerPlane := (stripsPerPlane + stripsPerTile - 1) / stripsPerTile
+
+	virtualOffsets := make([]uint64, planes*tilesPerPlane)
+	virtualByteCounts := make([]uint64, planes*tilesPerPlane)
+	for i := range virtualOffsets {
+		plane, row := i/tilesPerPlane, i%tilesPerPlane
+		startStrip := plane*stripsPerPlane + row*stripsPerTile
 		virtualOffsets[i] = ifd.StripOffsets[startStrip]
 		var totalBytes uint64
-		endStrip := startStrip + stripsPerTile
-		if endStrip > totalStrips {
-			endStrip = totalStrips
-		}
+		endStrip := min(startStrip+stripsPerTile, (plane+1)*stripsPerPlane)
 		for s := startStrip; s < endStrip; s++ {
-			totalBytes += ifd.StripByteCounts[s]
+			// Saturate rather than wrap on malformed counts.
+			if c := ifd.StripByteCounts[s]; c > math.MaxUint64-totalBytes {
+				totalBytes = math.MaxUint64
+			} else {
+				totalBytes += c
+			}
 		}
 		virtualByteCounts[i] = totalBytes
 	}
 
 	sl := &stripLayout{
-		offsets:       ifd.StripOffsets,
-		byteCounts:    ifd.StripByteCounts,
-		rowsPerStrip:  rps,
-		stripsPerTile: stripsPerTile,
+		offsets:        ifd.StripOffsets,
+		byteCounts:     ifd.StripByteCounts,
+		rowsPerStrip:   rps,
+		stripsPerTile:  stripsPerTile,
+		stripsPerPlane: stripsPerPlane,
 	}
 
 	ifd.TileWidth = ifd.Width
@@ -232,8 +391,15 @@ func promoteStripsToTiles(ifd *IFD) *stripLayout {
 	return sl
 }
 
-// Close unmaps the memory-mapped file.
+// Close unmaps the memory-mapped file (or closes the remote one) and drops
+// the pinned level.
 func (r *Reader) Close() error {
+	r.unpin()
+	if r.remote != nil {
+		err := r.remote.Close()
+		r.remote = nil
+		return err
+	}
 	if r.data != nil {
 		err := munmapFile(r.data)
 		r.data = nil
@@ -242,6 +408,40 @@ func (r *Reader) Close() error {
 	return nil
 }
 
+// fileSize returns the size of the file in bytes.
+func (r *Reader) fileSize() uint64 {
+	if r.remote != nil {
+		return uint64(r.remote.Size())
+	}
+	return uint64(len(r.data))
+}
+
+// dataEnd returns the end of the size bytes at offset, or false when they
+// do not lie within the file. The check cannot overflow, so offsets and
+// byte counts near 2^64 in malformed BigTIFFs are refused, as are ranges
+// too large for a slice on 32-bit platforms.
+func (r *Reader) dataEnd(offset, size uint64) (uint64, bool) {
+	fs := r.fileSize()
+	if size > fs || offset > fs-size || size > math.MaxInt {
+		return 0, false
+	}
+	return offset + size, true
+}
+
+// bytesAt returns the file bytes [offset, end), which must lie within the
+// file: a slice of the mapping for local files, a new buffer read from the
+// block cache for remote ones.
+func (r *Reader) bytesAt(offset, end uint64) ([]byte, error) {
+	if r.remote == nil {
+		return r.data[offset:end], nil
+	}
+	buf := make([]byte, end-offset)
+	if _, err := r.remote.ReadAt(buf, int64(offset)); err != nil {
+		return nil, fmt.Errorf("reading %s [%d:%d]: %w", r.path, offset, end, err)
+	}
+	return buf, nil
+}
+
 // Path returns the file path.
 func (r *Reader) Path() string {
 	return r.path
@@ -268,17 +468,37 @@ func (r *Reader) Height() int {
 	return int(r.ifds[0].Height)
 }
 
-// PixelSize returns the pixel size in CRS units (from the first IFD).
+// PixelSize returns the pixel size in CRS units (from the first IFD): the
+// finer of the pixel width and height for non-square pixels, so that the
+// zoom and overview chosen from it keep the detail of both axes.
 func (r *Reader) PixelSize() float64 {
+	if r.NonSquarePixels() {
+		return math.Min(r.geo.PixelSizeX, r.geo.PixelSizeY)
+	}
 	return r.geo.PixelSizeX
 }
 
-// NumOverviews returns the number of overview levels (IFDs beyond the first).
+// NonSquarePixels reports whether the pixel width and height differ by more
+// than 0.1%, as in some satellite products. Sampling handles both sizes;
+// see IFDPixelSizeY.
+func (r *Reader) NonSquarePixels() bool {
+	x, y := r.geo.PixelSizeX, r.geo.PixelSizeY
+	return x > 0 && y > 0 && math.Abs(x-y) > 1e-3*math.Max(x, y)
+}
+
+// NumOverviews returns the number of overview levels beyond full
+// resolution, including levels synthesized to fill gaps in the chain.
 func (r *Reader) NumOverviews() int {
 	return len(r.ifds) - 1
 }
 
-// IFDCount returns the total number of IFDs.
+// NumMasks returns the number of transparency mask IFDs in the file.
+func (r *Reader) NumMasks() int {
+	return len(r.masks)
+}
+
+// IFDCount returns the total number of resolution levels, including
+// synthesized overviews (see SynthesizedOverviews).
 func (r *Reader) IFDCount() int {
 	return len(r.ifds)
 }
@@ -298,6 +518,62 @@ func (r *Reader) EPSG() int {
 	return r.geo.EPSG
 }
 
+// Projection returns the projection of the file's CRS, or an error naming
+// the file and how to reproject it if the CRS is not supported.
+func (r *Reader) Projection() (coord.Projection, error) {
+	if r.geo.EPSG == coord.UserDefined && (r.crs != nil || r.crsErr != nil) {
+		if r.crsErr != nil {
+			return nil, hint.Wrap(r.crsErr, r.path, "CRS from embedded WKT", hint.GDALWarp(r.path, "EPSG:3857"))
+		}
+		return r.crs.Proj, nil
+	}
+	if proj := coord.ForEPSG(r.geo.EPSG); proj != nil {
+		return proj, nil
+	}
+	problem := fmt.Sprintf("CRS EPSG:%d is not supported (see README, Coordinate reference systems)", r.geo.EPSG)
+	if r.geo.EPSG == 0 {
+		problem = "the CRS is not given by an EPSG code"
+	}
+	return nil, hint.New(r.path, problem, hint.GDALWarp(r.path, "EPSG:3857"))
+}
+
+// CRSName returns the name of the source CRS: the registry name of its EPSG
+// code, or the name in the embedded WKT of a user-defined CRS. Empty if
+// neither is known.
+func (r *Reader) CRSName() string {
+	if r.geo.EPSG == coord.UserDefined && r.crs != nil {
+		return r.crs.Name
+	}
+	return coord.EPSGName(r.geo.EPSG)
+}
+
+// sameCRS reports whether r and o are in the same CRS: the same EPSG code,
+// or the same embedded WKT for user-defined CRSs.
+func (r *Reader) sameCRS(o *Reader) bool {
+	if r.geo.EPSG != o.geo.EPSG {
+		return false
+	}
+	if r.geo.EPSG != coord.UserDefined {
+		return true
+	}
+	return r.crs != nil && o.crs != nil && r.crs.WKT == o.crs.WKT
+}
+
+// EPSGGuess returns how the EPSG code was inferred from the coordinates,
+// or nil when the file's GeoKeys define it.
+func (r *Reader) EPSGGuess() *EPSGGuess {
+	return r.guess
+}
+
+// AssumeEPSG replaces an inferred EPSG code (--assume-epsg). It has no
+// effect when the GeoKeys define the CRS. Must be called before bounds are
+// computed or tiles are read.
+func (r *Reader) AssumeEPSG(epsg int) {
+	if r.guess != nil {
+		r.geo.EPSG = epsg
+	}
+}
+
 // readTileRaw reads and decompresses raw tile bytes at the given column and row.
 // Returns the raw (decompressed) bytes and the IFD for that level.
 func (r *Reader) readTileRaw(level, col, row int) ([]byte, *IFD, error) {
@@ -313,65 +589,94 @@ func (r *Reader) readTileRaw(level, col, row int) ([]byte, *IFD, error) {
 		return nil, nil, fmt.Errorf("tile (%d,%d) out of range (%dx%d)", col, row, tilesAcross, tilesDown)
 	}
 
+	if ifd.planar() {
+		data, err := r.readPlanarTile(level, col, row)
+		return data, ifd, err
+	}
+
 	// Strip-based: read individual strips and concatenate.
 	if r.strip != nil && level == 0 {
-		return r.readStripTileRaw(ifd, row)
+		return r.readStripTileRaw(ifd, row, 0)
 	}
 
-	tileIdx := row*tilesAcross + col
+	data, err := r.readIFDTile(ifd, col, row)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, ifd, nil
+}
+
+// readIFDTile returns the decompressed bytes of tile (col, row) of a tiled
+// IFD, with any predictor undone, or nil for an empty tile. JPEG and WebP
+// tiles are returned compressed.
+func (r *Reader) readIFDTile(ifd *IFD, col, row int) ([]byte, error) {
+	tileIdx := row*ifd.TilesAcross() + col
 	if tileIdx >= len(ifd.TileOffsets) || tileIdx >= len(ifd.TileByteCounts) {
-		return nil, nil, fmt.Errorf("tile index %d out of range", tileIdx)
+		return nil, fmt.Errorf("tile index %d out of range", tileIdx)
 	}
 
 	offset := ifd.TileOffsets[tileIdx]
 	size := ifd.TileByteCounts[tileIdx]
 
 	if size == 0 {
-		return nil, ifd, nil // empty tile
+		return nil, nil // empty tile
 	}
 
-	end := offset + size
-	if end > uint64(len(r.data)) {
-		return nil, nil, fmt.Errorf("tile data [%d:%d] exceeds file size %d", offset, end, len(r.data))
+	end, ok := r.dataEnd(offset, size)
+	if !ok {
+		return nil, fmt.Errorf("tile data (%d bytes at offset %d) exceeds file size %d", size, offset, r.fileSize())
 	}
 
-	data := r.data[offset:end]
+	data, err := r.compressedAt(offset, end)
+	if err != nil {
+		return nil, err
+	}
 
 	var decompressed []byte
 	switch ifd.Compression {
-	case 7: // JPEG — not applicable for float tiles
-		return data, ifd, nil
+	case 7, 50001: // JPEG, WebP — not applicable for float tiles
+		return data, nil
 	case 1: // No compression
 		decompressed = data
+		if ifd.Predictor == 2 || ifd.Predictor == 3 {
+			// The predictor is undone in place; keep the mapping and the
+			// raw cache untouched.
+			decompressed = make([]byte, len(data))
+			copy(decompressed, data)
+		}
 	case 8, 32946: // Deflate / zlib
 		dec, err := decompressDeflate(data)
 		if err != nil {
-			return nil, nil, fmt.Errorf("decompressing deflate tile: %w", err)
+			return nil, fmt.Errorf("decompressing deflate tile: %w", err)
 		}
 		decompressed = dec
 	case 5: // LZW
 		dec, err := decompressLZW(data)
 		if err != nil {
-			return nil, nil, fmt.Errorf("decompressing LZW tile: %w", err)
+			return nil, fmt.Errorf("decompressing LZW tile: %w", err)
+		}
+		decompressed = dec
+	case 50000: // ZSTD
+		dec, err := decompressZSTD(data)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing ZSTD tile: %w", err)
 		}
 		decompressed = dec
 	default:
-		return nil, nil, fmt.Errorf("unsupported compression: %d", ifd.Compression)
+		return nil, fmt.Errorf("unsupported compression: %d", ifd.Compression)
 	}
 
 	applyPredictor(ifd, decompressed, int(ifd.TileWidth), r.bo)
-	return decompressed, ifd, nil
+	return decompressed, nil
 }
 
-// readStripTileRaw reads the strips that compose a virtual tile row and
-// returns the concatenated, decompressed bytes.
-func (r *Reader) readStripTileRaw(ifd *IFD, tileRow int) ([]byte, *IFD, error) {
+// readStripTileRaw reads the strips of band plane that compose a virtual
+// tile row and returns the concatenated, decompressed bytes. plane is 0
+// unless the bands are stored separately (PlanarConfig 2).
+func (r *Reader) readStripTileRaw(ifd *IFD, tileRow, plane int) ([]byte, *IFD, error) {
 	sl := r.strip
-	startStrip := tileRow * sl.stripsPerTile
-	endStrip := startStrip + sl.stripsPerTile
-	if endStrip > len(sl.offsets) {
-		endStrip = len(sl.offsets)
-	}
+	startStrip := plane*sl.stripsPerPlane + tileRow*sl.stripsPerTile
+	endStrip := min(min(startStrip+sl.stripsPerTile, (plane+1)*sl.stripsPerPlane), len(sl.offsets))
 
 	var combined []byte
 
@@ -381,12 +686,15 @@ func (r *Reader) readStripTileRaw(ifd *IFD, tileRow int) ([]byte, *IFD, error) {
 		if size == 0 {
 			continue
 		}
-		end := offset + size
-		if end > uint64(len(r.data)) {
-			return nil, nil, fmt.Errorf("strip %d data [%d:%d] exceeds file size %d", s, offset, end, len(r.data))
+		end, ok := r.dataEnd(offset, size)
+		if !ok {
+			return nil, nil, fmt.Errorf("strip %d data (%d bytes at offset %d) exceeds file size %d", s, size, offset, r.fileSize())
 		}
 
-		chunk := r.data[offset:end]
+		chunk, err := r.compressedAt(offset, end)
+		if err != nil {
+			return nil, nil, err
+		}
 
 		switch ifd.Compression {
 		case 1: // No compression
@@ -405,6 +713,12 @@ func (r *Reader) readStripTileRaw(ifd *IFD, tileRow int) ([]byte, *IFD, error) {
 				return nil, nil, fmt.Errorf("decompressing LZW strip %d: %w", s, err)
 			}
 			combined = append(combined, dec...)
+		case 50000: // ZSTD
+			dec, err := decompressZSTD(chunk)
+			if err != nil {
+				return nil, nil, fmt.Errorf("decompressing ZSTD strip %d: %w", s, err)
+			}
+			combined = append(combined, dec...)
 		default:
 			return nil, nil, fmt.Errorf("unsupported compression: %d", ifd.Compression)
 		}
@@ -497,10 +811,31 @@ func undoFloatingPointPredictor(data []byte, width, samplesPerPixel, bytesPerSam
 	}
 }
 
-// ReadFloatTile reads and decodes a single float32 tile.
-// Returns the float32 data and tile dimensions (width, height).
+// ReadFloatTile reads and decodes a single tile as float32 values (integer
+// samples converted). Returns the float32 data and tile dimensions (width, height).
 // For empty tiles, returns nil data.
 func (r *Reader) ReadFloatTile(level, col, row int) ([]float32, int, int, error) {
+	if level >= 0 && level == r.PinnedLevel() {
+		return r.readPinnedFloatTile(level, col, row)
+	}
+	return r.readFloatTileAt(level, col, row)
+}
+
+// readFloatTileAt is ReadFloatTile without the pinned level.
+func (r *Reader) readFloatTileAt(level, col, row int) ([]float32, int, int, error) {
+	if level >= 0 && level < len(r.ifds) {
+		if s := r.synthFor(level); s != nil {
+			return r.readSynthFloatTile(level, col, row, s)
+		}
+		if flipX, flipY := r.flips(level); flipX || flipY {
+			return r.readFlippedFloatTile(level, col, row, flipX, flipY)
+		}
+	}
+	return r.readStoredFloatTile(level, col, row)
+}
+
+// readStoredFloatTile is ReadFloatTile without the Orientation tag applied.
+func (r *Reader) readStoredFloatTile(level, col, row int) ([]float32, int, int, error) {
 	data, ifd, err := r.readTileRaw(level, col, row)
 	if err != nil {
 		return nil, 0, 0, err
@@ -513,10 +848,23 @@ func (r *Reader) ReadFloatTile(level, col, row int) ([]float32, int, int, error)
 		return nil, w, h, nil // empty tile
 	}
 
-	return r.decodeRawFloat32Tile(ifd, data)
+	vals, w, h, err := r.decodeRawFloat32Tile(ifd, data)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if mask := r.maskFor(level); mask != nil {
+		cov, err := r.readMaskTile(mask, col, row)
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("reading mask tile: %w", err)
+		}
+		applyFloatMask(vals, cov)
+	}
+	return vals, w, h, nil
 }
 
-// decodeRawFloat32Tile decodes raw bytes as float32 pixel data.
+// decodeRawFloat32Tile decodes raw bytes as float32 pixel data. Integer
+// samples (8, 16, or 32 bits, signed or unsigned) are converted to their
+// value, so that integer rasters can be read as values too (--color-map).
 func (r *Reader) decodeRawFloat32Tile(ifd *IFD, data []byte) ([]float32, int, int, error) {
 	w := int(ifd.TileWidth)
 	h := int(ifd.TileHeight)
@@ -527,6 +875,20 @@ func (r *Reader) decodeRawFloat32Tile(ifd *IFD, data []byte) ([]float32, int, in
 	if len(ifd.BitsPerSample) > 0 {
 		bps = int(ifd.BitsPerSample[0])
 	}
+	// Without a SampleFormat tag, 32 and 64-bit samples are taken as
+	// floats (this path was float-only), narrower ones as unsigned.
+	sampleFormat := uint16(3)
+	if len(ifd.SampleFormat) > 0 {
+		sampleFormat = ifd.SampleFormat[0]
+	} else if bps < 32 {
+		sampleFormat = 1
+	}
+	if sampleFormat != 3 && ifd.imageCoded() {
+		return nil, 0, 0, fmt.Errorf("%s-compressed tiles cannot be read as values", compressionName(ifd.Compression))
+	}
+	if ifd.mixedDepth() || bps%8 != 0 {
+		return nil, 0, 0, fmt.Errorf("unsupported bits per sample for values: %v", ifd.BitsPerSample)
+	}
 
 	bytesPerSample := bps / 8
 	expectedSize := pixelCount * spp * bytesPerSample
@@ -539,17 +901,30 @@ func (r *Reader) decodeRawFloat32Tile(ifd *IFD, data []byte) ([]float32, int, in
 	result := make([]float32, pixelCount)
 	for i := 0; i < pixelCount; i++ {
 		off := i * spp * bytesPerSample
-		switch bps {
-		case 32:
+		switch {
+		case sampleFormat == 3 && bps == 32:
 			bits := r.bo.Uint32(data[off : off+4])
 			result[i] = math.Float32frombits(bits)
-		case 64:
+		case sampleFormat == 3 && bps == 64:
 			bits := r.bo.Uint64(data[off : off+8])
 			result[i] = float32(math.Float64frombits(bits))
+		case sampleFormat == 1 && bps == 8:
+			result[i] = float32(data[off])
+		case sampleFormat == 1 && bps == 16:
+			result[i] = float32(r.bo.Uint16(data[off : off+2]))
+		case sampleFormat == 1 && bps == 32:
+			result[i] = float32(r.bo.Uint32(data[off : off+4]))
+		case sampleFormat == 2 && bps == 8:
+			result[i] = float32(int8(data[off]))
+		case sampleFormat == 2 && bps == 16:
+			result[i] = float32(int16(r.bo.Uint16(data[off : off+2])))
+		case sampleFormat == 2 && bps == 32:
+			result[i] = float32(int32(r.bo.Uint32(data[off : off+4])))
 		default:
-			return nil, 0, 0, fmt.Errorf("unsupported float bits per sample: %d", bps)
+			return nil, 0, 0, fmt.Errorf("unsupported sample format %d with %d bits per sample", sampleFormat, bps)
 		}
 	}
+	r.maskFloatNoData(result)
 
 	return result, w, h, nil
 }
@@ -557,6 +932,7 @@ func (r *Reader) decodeRawFloat32Tile(ifd *IFD, data []byte) ([]float32, int, in
 // ReadTile reads and decodes a single tile at the given column and row from the specified IFD level.
 // Level 0 is the full resolution; higher levels are overviews.
 // This is safe for concurrent use — the underlying data is memory-mapped read-only.
+// The returned image must not be modified.
 func (r *Reader) ReadTile(level, col, row int) (image.Image, error) {
 	if level < 0 || level >= len(r.ifds) {
 		return nil, fmt.Errorf("invalid IFD level %d (have %d)", level, len(r.ifds))
@@ -570,9 +946,63 @@ func (r *Reader) ReadTile(level, col, row int) (image.Image, error) {
 		return nil, fmt.Errorf("tile (%d,%d) out of range (%dx%d)", col, row, tilesAcross, tilesDown)
 	}
 
+	if level == r.PinnedLevel() {
+		return r.readPinnedTile(level, col, row)
+	}
+	return r.readTileAt(level, col, row)
+}
+
+// readTileAt is ReadTile without the pinned level. Arguments must be in range.
+func (r *Reader) readTileAt(level, col, row int) (image.Image, error) {
+	// Synthesized levels read already-oriented tiles of a finer level.
+	if s := r.synthFor(level); s != nil {
+		return r.readSynthTile(level, col, row, s)
+	}
+	if flipX, flipY := r.flips(level); flipX || flipY {
+		return r.readFlippedTile(level, col, row, flipX, flipY)
+	}
+	return r.readStoredTile(level, col, row)
+}
+
+// readStoredTile decodes tile (col, row) of level as stored in the file,
+// without applying the Orientation tag, and applies the level's
+// transparency mask as alpha. Arguments must be in range.
+func (r *Reader) readStoredTile(level, col, row int) (image.Image, error) {
+	img, err := r.decodeStoredTile(level, col, row)
+	if err != nil {
+		return nil, err
+	}
+	mask := r.maskFor(level)
+	if mask == nil {
+		return img, nil
+	}
+	cov, err := r.readMaskTile(mask, col, row)
+	if err != nil {
+		return nil, fmt.Errorf("reading mask tile: %w", err)
+	}
+	return applyMask(img, cov), nil
+}
+
+// decodeStoredTile decodes tile (col, row) of a level stored in the file,
+// before its transparency mask is applied.
+func (r *Reader) decodeStoredTile(level, col, row int) (image.Image, error) {
+	ifd := &r.ifds[level]
+	tilesAcross := ifd.TilesAcross()
+
+	if ifd.planar() {
+		data, err := r.readPlanarTile(level, col, row)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			return image.NewRGBA(image.Rect(0, 0, int(ifd.TileWidth), int(ifd.TileHeight))), nil
+		}
+		return r.decodeRawTile(ifd, data)
+	}
+
 	// Strip-based: compose virtual tile from individual strips.
 	if r.strip != nil && level == 0 {
-		data, _, err := r.readStripTileRaw(ifd, row)
+		data, _, err := r.readStripTileRaw(ifd, row, 0)
 		if err != nil {
 			return nil, err
 		}
@@ -594,12 +1024,15 @@ func (r *Reader) ReadTile(level, col, row int) (image.Image, error) {
 		return image.NewRGBA(image.Rect(0, 0, int(ifd.TileWidth), int(ifd.TileHeight))), nil
 	}
 
-	end := offset + size
-	if end > uint64(len(r.data)) {
-		return nil, fmt.Errorf("tile data [%d:%d] exceeds file size %d", offset, end, len(r.data))
+	end, ok := r.dataEnd(offset, size)
+	if !ok {
+		return nil, fmt.Errorf("tile data (%d bytes at offset %d) exceeds file size %d", size, offset, r.fileSize())
 	}
 
-	data := r.data[offset:end]
+	data, err := r.compressedAt(offset, end)
+	if err != nil {
+		return nil, err
+	}
 
 	switch ifd.Compression {
 	case 7: // JPEG
@@ -626,6 +1059,15 @@ func (r *Reader) ReadTile(level, col, row int) (image.Image, error) {
 		}
 		applyPredictor(ifd, decompressed, int(ifd.TileWidth), r.bo)
 		return r.decodeRawTile(ifd, decompressed)
+	case 50000: // ZSTD
+		decompressed, err := decompressZSTD(data)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing ZSTD tile: %w", err)
+		}
+		applyPredictor(ifd, decompressed, int(ifd.TileWidth), r.bo)
+		return r.decodeRawTile(ifd, decompressed)
+	case 50001: // WebP
+		return decodeWebPTile(data)
 	default:
 		return nil, fmt.Errorf("unsupported compression: %d", ifd.Compression)
 	}
@@ -688,17 +1130,35 @@ func (r *Reader) decodeJPEGTile(ifd *IFD, data []byte) (image.Image, error) {
 	return img, nil
 }
 
+// decodeWebPTile decodes a WebP-compressed tile, as NRGBA.
+func decodeWebPTile(data []byte) (image.Image, error) {
+	img, err := encode.DecodeWebP(data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding WebP tile: %w", err)
+	}
+	return img, nil
+}
+
 // decodeRawTile decodes an uncompressed tile.
 // Supports 8-bit and 16-bit samples, band reordering, alpha band selection, and rescaling
 // via the reader's BandConfig. For single-band data, pixels matching the GDAL nodata value
 // are set to alpha=0 (transparent) so downstream code treats them as empty.
 // Zero-value BandConfig produces identical behavior to the legacy code path.
 func (r *Reader) decodeRawTile(ifd *IFD, data []byte) (image.Image, error) {
+	if ifd.mixedDepth() {
+		return r.decodeMixedDepthTile(ifd, data)
+	}
+	if ifd.bilevel() {
+		return r.decodeBilevelTile(ifd, data)
+	}
 	w := int(ifd.TileWidth)
 	h := int(ifd.TileHeight)
 	spp := int(ifd.SamplesPerPixel)
 	bps := ifd.bytesPerSample() // 1 for 8-bit, 2 for 16-bit
 	is16 := bps == 2
+	// Signed 16-bit samples are read offset by 32768 (offset binary), so
+	// they sort like their values; the rescale range and nodata follow.
+	signed := is16 && ifd.signedSamples()
 	pixelBytes := spp * bps
 
 	// Resolve band mapping from config (with defaults).
@@ -740,7 +1200,7 @@ func (r *Reader) decodeRawTile(ifd *IFD, data []byte) (image.Image, error) {
 	if spp <= 2 && isDefaultBandCfg && !is16 {
 		useLegacyNodata = true
 		nd := r.ifds[0].NoData
-		if nd != "" {
+		if nd != "" && !r.hasIntNoData {
 			v, err := strconv.ParseFloat(strings.TrimSpace(nd), 64)
 			if err == nil && v >= 0 && v <= 255 && v == math.Floor(v) {
 				nodataVal = uint8(v)
@@ -753,14 +1213,21 @@ func (r *Reader) decodeRawTile(ifd *IFD, data []byte) (image.Image, error) {
 	// Used for multi-band or 16-bit data not handled by the legacy path.
 	var genHasNodata bool
 	var genNodataU16 uint16
-	if !useLegacyNodata {
+	if !useLegacyNodata && !r.hasIntNoData {
+		lo, hi := 0.0, 65535.0
+		if signed {
+			lo, hi = -32768, 32767
+		}
 		if cfg.HasNodata {
 			genHasNodata = true
 			genNodataU16 = uint16(cfg.Nodata)
+			if signed {
+				genNodataU16 = uint16(int(cfg.Nodata) + 32768)
+			}
 		} else if nd := r.ifds[0].NoData; nd != "" {
-			if v, err := strconv.ParseFloat(strings.TrimSpace(nd), 64); err == nil && v >= 0 && v <= 65535 && v == math.Floor(v) {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(nd), 64); err == nil && v >= lo && v <= hi && v == math.Floor(v) {
 				genHasNodata = true
-				genNodataU16 = uint16(v)
+				genNodataU16 = uint16(v - lo)
 			}
 		}
 	}
@@ -775,15 +1242,31 @@ func (r *Reader) decodeRawTile(ifd *IFD, data []byte) (image.Image, error) {
 		rescaleMode = RescaleLinear
 		rescaleMin = 0
 		rescaleMax = 65535
+	} else if signed {
+		rescaleMin += 32768
+		rescaleMax += 32768
 	}
 	rescale := buildRescaler(rescaleMode, rescaleMin, rescaleMax)
 
+	// isNoData checks a sample, as returned by readSample, against the
+	// per-source override.
+	intND, hasIntND := r.intNoData, r.hasIntNoData
+	isNoData := func(v uint16) bool {
+		if signed {
+			return intND.Match(float64(int(v) - 32768))
+		}
+		return intND.Match(float64(v))
+	}
+
 	// readSample reads one sample from the pixel data at the given 0-indexed band.
 	readSample := func(pixelOff, band int) uint16 {
 		off := pixelOff + band*bps
 		if off+bps > len(data) {
 			return 0
 		}
+		if signed {
+			return r.bo.Uint16(data[off:off+2]) ^ 0x8000
+		}
 		if is16 {
 			return r.bo.Uint16(data[off : off+2])
 		}
@@ -809,7 +1292,7 @@ func (r *Reader) decodeRawTile(ifd *IFD, data []byte) (image.Image, error) {
 					pix[pixIdx+0] = v
 					pix[pixIdx+1] = v
 					pix[pixIdx+2] = v
-					if hasNodata && v == nodataVal {
+					if (hasNodata && v == nodataVal) || (hasIntND && isNoData(uint16(v))) {
 						pix[pixIdx+3] = 0
 					} else {
 						pix[pixIdx+3] = 255
@@ -820,7 +1303,7 @@ func (r *Reader) decodeRawTile(ifd *IFD, data []byte) (image.Image, error) {
 					pix[pixIdx+1] = v
 					pix[pixIdx+2] = v
 					a := data[pixelOff+1]
-					if hasNodata && v == nodataVal {
+					if (hasNodata && v == nodataVal) || (hasIntND && isNoData(uint16(v))) {
 						a = 0
 					}
 					pix[pixIdx+3] = a
@@ -839,14 +1322,19 @@ func (r *Reader) decodeRawTile(ifd *IFD, data []byte) (image.Image, error) {
 			if spp > 2 && bandB < spp {
 				bV = readSample(pixelOff, bandB)
 			}
+			if spp == 1 {
+				// Single band (16-bit or rescaled): gray.
+				gV, bV = rV, rV
+			}
 
 			// Nodata check: if all file bands equal the nodata value, emit transparent.
 			// Only applies when there is no explicit alpha band (alpha=0 already handles
 			// transparency for files with an alpha channel).
-			if genHasNodata && effectiveAlpha < 0 {
+			if (genHasNodata || hasIntND) && effectiveAlpha < 0 {
 				isNodata := true
 				for b := 0; b < spp; b++ {
-					if readSample(pixelOff, b) != genNodataU16 {
+					v := readSample(pixelOff, b)
+					if (genHasNodata && v != genNodataU16) || (hasIntND && !isNoData(v)) {
 						isNodata = false
 						break
 					}
@@ -1065,32 +1553,66 @@ func (r *Reader) DebugIFD(level int) IFD {
 	return r.ifds[level]
 }
 
-// RawBytes returns n bytes from the memory-mapped data starting at offset.
+// RawBytes returns n bytes of the file starting at offset, fewer at the end
+// of the file. A failed remote read returns nil.
 func (r *Reader) RawBytes(offset uint64, n int) []byte {
-	end := offset + uint64(n)
-	if end > uint64(len(r.data)) {
-		end = uint64(len(r.data))
+	fs := r.fileSize()
+	if n < 0 || offset > fs {
+		return nil
+	}
+	end := fs
+	if uint64(n) < fs-offset {
+		end = offset + uint64(n)
 	}
-	result := make([]byte, end-offset)
-	copy(result, r.data[offset:end])
-	return result
+	data, err := r.bytesAt(offset, end)
+	if err != nil {
+		return nil
+	}
+	return bytes.Clone(data)
 }
 
 // OpenAll opens multiple COG files and returns their readers.
 // It first validates that all files exist and are readable before opening any,
 // so the user is informed about all missing or inaccessible files upfront.
+//
+// A .vrt path is expanded into the files it references (see ParseVRT), each
+// georeferenced from its place in the mosaic. Later VRT sources are drawn on
+// top in GDAL, so they are returned first: earlier readers take priority.
 func OpenAll(paths []string) ([]*Reader, error) {
+	type input struct {
+		path string
+		vrt  *VRT
+		src  VRTSource
+	}
+	var inputs []input
+	for _, p := range paths {
+		if !IsVRT(p) {
+			inputs = append(inputs, input{path: p})
+			continue
+		}
+		v, err := ParseVRT(p)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(v.Sources) - 1; i >= 0; i-- {
+			inputs = append(inputs, input{path: v.Sources[i].Path, vrt: v, src: v.Sources[i]})
+		}
+	}
+
 	// Pre-validate: check that every file exists and is accessible before
 	// doing any expensive parsing. This ensures the user learns about all
 	// missing files at once instead of discovering them one at a time.
 	var missing []string
-	for _, p := range paths {
-		if _, err := os.Stat(p); err != nil {
-			missing = append(missing, p)
+	for _, in := range inputs {
+		if remote.IsURL(in.path) {
+			continue // checked when opened
+		}
+		if _, err := os.Stat(in.path); err != nil {
+			missing = append(missing, in.path)
 		}
 	}
 	if len(missing) > 0 {
-		msg := fmt.Sprintf("%d of %d input file(s) cannot be accessed:\n", len(missing), len(paths))
+		msg := fmt.Sprintf("%d of %d input file(s) cannot be accessed:\n", len(missing), len(inputs))
 		for _, p := range missing {
 			msg += fmt.Sprintf("  - %s\n", p)
 		}
@@ -1098,15 +1620,30 @@ func OpenAll(paths []string) ([]*Reader, error) {
 		return nil, fmt.Errorf("%s", msg)
 	}
 
-	readers := make([]*Reader, 0, len(paths))
-	for i, p := range paths {
-		r, err := Open(p)
+	readers := make([]*Reader, 0, len(inputs))
+	closeAll := func() {
+		for _, rr := range readers {
+			rr.Close()
+		}
+	}
+	for i, in := range inputs {
+		r, err := Open(in.path)
 		if err != nil {
-			// Close any already-opened readers.
-			for _, rr := range readers {
-				rr.Close()
+			closeAll()
+			return nil, fmt.Errorf("failed to open %s: %w", in.path, err)
+		}
+		if in.vrt != nil {
+			if err := placeVRTSource(r, in.vrt, in.src); err != nil {
+				r.Close()
+				closeAll()
+				return nil, err
+			}
+			// The VRT's nodata applies where the file declares none.
+			if in.vrt.NoData != "" && r.FloatNoData().IsEmpty() {
+				if spec, err := ParseNoDataSpec(in.vrt.NoData); err == nil {
+					r.SetFloatNoData(spec)
+				}
 			}
-			return nil, fmt.Errorf("failed to open %s: %w", p, err)
 		}
 		r.id = i
 		readers = append(readers, r)
@@ -1260,7 +1797,9 @@ func CheckCoverageGaps(sources []*Reader) []CoverageGap {
 }
 
 // MergedBoundsWGS84 computes the WGS84 bounding box that covers all sources.
-// Requires that sources have a known projection (currently supports EPSG:2056).
+// Source CRSs are those of Reader.Projection; coordinates of an unsupported
+// CRS are taken as WGS84. Each edge is sampled at boundsEdgeSamples points, since
+// the edges of conic and azimuthal projections bow out between the corners.
 func MergedBoundsWGS84(sources []*Reader) Bounds {
 	if len(sources) == 0 {
 		return Bounds{}
@@ -1275,41 +1814,21 @@ func MergedBoundsWGS84(sources []*Reader) Bounds {
 
 	for _, src := range sources {
 		minX, minY, maxX, maxY := src.BoundsInCRS()
-		epsg := src.EPSG()
-
-		// Convert corners to WGS84.
-		corners := [][2]float64{
-			{minX, minY},
-			{minX, maxY},
-			{maxX, minY},
-			{maxX, maxY},
-		}
-
-		for _, c := range corners {
-			var lon, lat float64
-			switch epsg {
-			case 2056:
-				lon, lat = lv95ToWGS84(c[0], c[1])
-			case 4326:
-				lon, lat = c[0], c[1]
-			case 3857:
-				lon, lat = webMercatorToWGS84(c[0], c[1])
-			default:
-				// Assume the coordinates are already in WGS84 as a fallback.
-				lon, lat = c[0], c[1]
-			}
+		proj, err := src.Projection()
+		if err != nil {
+			// Assume the coordinates are already in WGS84 as a fallback.
+			proj = &coord.WGS84Identity{}
+		}
 
-			if lon < merged.MinLon {
-				merged.MinLon = lon
-			}
-			if lon > merged.MaxLon {
-				merged.MaxLon = lon
-			}
-			if lat < merged.MinLat {
-				merged.MinLat = lat
-			}
-			if lat > merged.MaxLat {
-				merged.MaxLat = lat
+		for i := 0; i <= boundsEdgeSamples; i++ {
+			f := float64(i) / boundsEdgeSamples
+			x, y := minX+f*(maxX-minX), minY+f*(maxY-minY)
+			for _, c := range [][2]float64{{x, minY}, {x, maxY}, {minX, y}, {maxX, y}} {
+				lon, lat := proj.ToWGS84(c[0], c[1])
+				merged.MinLon = math.Min(merged.MinLon, lon)
+				merged.MaxLon = math.Max(merged.MaxLon, lon)
+				merged.MinLat = math.Min(merged.MinLat, lat)
+				merged.MaxLat = math.Max(merged.MaxLat, lat)
 			}
 		}
 	}
@@ -1317,26 +1836,9 @@ func MergedBoundsWGS84(sources []*Reader) Bounds {
 	return merged
 }
 
-// lv95ToWGS84 converts Swiss LV95 (EPSG:2056) coordinates to WGS84 lon/lat.
-// Uses swisstopo approximate formulas.
-func lv95ToWGS84(easting, northing float64) (lon, lat float64) {
-	y := (easting - 2_600_000) / 1_000_000
-	x := (northing - 1_200_000) / 1_000_000
-
-	lonSec := 2.6779094 + 4.728982*y + 0.791484*y*x + 0.1306*y*x*x - 0.0436*y*y*y
-	latSec := 16.9023892 + 3.238272*x - 0.270978*y*y - 0.002528*x*x - 0.0447*y*y*x - 0.0140*x*x*x
-
-	lon = lonSec * 100 / 36
-	lat = latSec * 100 / 36
-	return
-}
-
-// webMercatorToWGS84 converts Web Mercator (EPSG:3857) to WGS84.
-func webMercatorToWGS84(x, y float64) (lon, lat float64) {
-	lon = x * 180 / 20037508.342789244
-	lat = (math.Atan(math.Exp(y*math.Pi/20037508.342789244))*360/math.Pi - 90)
-	return
-}
+// boundsEdgeSamples is the number of segments each source edge is split into
+// by MergedBoundsWGS84.
+const boundsEdgeSamples = 16
 
 func max(a, b int) int {
 	if a > b {
@@ -1361,7 +1863,7 @@ func (r *Reader) OverviewForZoom(outputPixelSizeCRS float64) int {
 
 	for i, ifd := range r.ifds {
 		// Compute the pixel size at this IFD level (in CRS units).
-		levelPixelSize := r.geo.PixelSizeX * float64(r.ifds[0].Width) / float64(ifd.Width)
+		levelPixelSize := r.PixelSize() * float64(r.ifds[0].Width) / float64(ifd.Width)
 		ratio := math.Abs(levelPixelSize/outputPixelSizeCRS - 1)
 		if ratio < bestRatio {
 			bestRatio = ratio
@@ -1372,10 +1874,20 @@ func (r *Reader) OverviewForZoom(outputPixelSizeCRS float64) int {
 	return bestLevel
 }
 
+// IFDPixelSize returns the pixel width of level in CRS units.
 func (r *Reader) IFDPixelSize(level int) float64 {
 	return r.geo.PixelSizeX * float64(r.ifds[0].Width) / float64(r.ifds[level].Width)
 }
 
+// IFDPixelSizeY returns the pixel height of level in CRS units: the pixel
+// width for sources that give no height.
+func (r *Reader) IFDPixelSizeY(level int) float64 {
+	if r.geo.PixelSizeY <= 0 {
+		return r.IFDPixelSize(level)
+	}
+	return r.geo.PixelSizeY * float64(r.ifds[0].Height) / float64(r.ifds[level].Height)
+}
+
 func (r *Reader) IFDWidth(level int) int {
 	return int(r.ifds[level].Width)
 }
@@ -1404,6 +1916,10 @@ func (r *Reader) FormatDescription() string {
 		comp = "JPEG"
 	case 8, 32946:
 		comp = "Deflate"
+	case 50000:
+		comp = "ZSTD"
+	case 50001:
+		comp = "WebP"
 	}
 
 	spp := int(ifd.SamplesPerPixel)
@@ -1415,9 +1931,22 @@ func (r *Reader) FormatDescription() string {
 	sampleType := "uint"
 	if r.IsFloat() {
 		sampleType = "float"
+	} else if r.IsSigned() {
+		sampleType = "int"
 	}
 
-	return fmt.Sprintf("%s, %dx %s%d", comp, spp, sampleType, bps)
+	desc := fmt.Sprintf("%s, %dx %s%d", comp, spp, sampleType, bps)
+	if ifd.mixedDepth() {
+		depths := make([]string, spp)
+		for i, b := range ifd.bandBits() {
+			depths[i] = strconv.Itoa(b)
+		}
+		desc = fmt.Sprintf("%s, %dx %s%s", comp, spp, sampleType, strings.Join(depths, "/"))
+	}
+	if o := ifd.Orientation; o > orientTopLeft {
+		desc += fmt.Sprintf(", orientation %d", o)
+	}
+	return desc
 }
 
 // IsFloat returns true if the raster data is floating-point (e.g. Float32 elevation data).
@@ -1429,6 +1958,12 @@ func (r *Reader) IsFloat() bool {
 	return false
 }
 
+// IsSigned returns true if the raster has signed integer samples (e.g.
+// int16 radar backscatter or elevation).
+func (r *Reader) IsSigned() bool {
+	return r.ifds[0].signedSamples()
+}
+
 // NoData returns the GDAL nodata string, or "" if not set.
 func (r *Reader) NoData() string {
 	return r.ifds[0].NoData
@@ -1438,6 +1973,7 @@ func (r *Reader) NoData() string {
 // Must be called after OpenAll() and before any ReadTile() calls.
 func (r *Reader) SetBandConfig(cfg BandConfig) {
 	r.bandCfg = cfg
+	r.unpin()
 }
 
 // BitsPerSample returns the bits per sample of the first IFD (e.g. 8, 16).
@@ -1448,6 +1984,14 @@ func (r *Reader) BitsPerSample() int {
 	return 8
 }
 
+// HasMaskBand reports whether the bands have different bit depths and one
+// of them is used as alpha by default: flagged as alpha in ExtraSamples, or
+// 1 bit deep.
+func (r *Reader) HasMaskBand() bool {
+	ifd := &r.ifds[0]
+	return ifd.mixedDepth() && ifd.maskBand() >= 0
+}
+
 // SamplesPerPixel returns the samples per pixel of the first IFD.
 func (r *Reader) SamplesPerPixel() int {
 	return int(r.ifds[0].SamplesPerPixel)
@@ -2,10 +2,19 @@ package cog
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+	"github.com/pspoerri/geotiff2pmtiles/internal/hint"
 )
 
 func TestUndoHorizontalDifferencing8Bit(t *testing.T) {
@@ -440,6 +449,232 @@ func TestDecodeRawTile16BitWithAlphaBand(t *testing.T) {
 	_ = got1
 }
 
+// packSamples packs big-endian samples of the given bit depths MSB first,
+// each row starting on a byte boundary, as TIFF stores mixed-depth pixels.
+func packSamples(w int, bits []int, rows [][][]uint16) []byte {
+	pixelBits := 0
+	for _, b := range bits {
+		pixelBits += b
+	}
+	rowBytes := (w*pixelBits + 7) / 8
+	data := make([]byte, rowBytes*len(rows))
+	for y, row := range rows {
+		bit := y * rowBytes * 8
+		for _, px := range row {
+			for band, v := range px {
+				for i := bits[band] - 1; i >= 0; i-- {
+					if v>>i&1 != 0 {
+						data[bit/8] |= 0x80 >> (bit % 8)
+					}
+					bit++
+				}
+			}
+		}
+	}
+	return data
+}
+
+func TestDecodeMixedDepthTile(t *testing.T) {
+	tests := []struct {
+		name string
+		ifd  IFD
+		cfg  BandConfig
+		bits []int
+		rows [][][]uint16
+		want [][]color.RGBA
+	}{
+		{
+			name: "RGB8 + 1-bit mask",
+			ifd:  IFD{Photometric: 2},
+			bits: []int{8, 8, 8, 1},
+			// 3 pixels of 25 bits: rows are padded to 10 bytes.
+			rows: [][][]uint16{
+				{{10, 20, 30, 1}, {40, 50, 60, 0}, {70, 80, 90, 1}},
+				{{1, 2, 3, 0}, {4, 5, 6, 1}, {7, 8, 9, 1}},
+			},
+			want: [][]color.RGBA{
+				{{10, 20, 30, 255}, {}, {70, 80, 90, 255}},
+				{{}, {4, 5, 6, 255}, {7, 8, 9, 255}},
+			},
+		},
+		{
+			name: "RGB8 + 16-bit alpha",
+			ifd:  IFD{Photometric: 2, ExtraSamples: []uint16{2}},
+			bits: []int{8, 8, 8, 16},
+			rows: [][][]uint16{{{10, 20, 30, 65535}, {40, 50, 60, 0x8000}, {70, 80, 90, 0}}},
+			want: [][]color.RGBA{{{10, 20, 30, 255}, {40, 50, 60, 128}, {}}},
+		},
+		{
+			name: "gray8 + 16-bit band, explicit alpha",
+			ifd:  IFD{Photometric: 1},
+			cfg:  BandConfig{AlphaBand: 2},
+			bits: []int{8, 16},
+			rows: [][][]uint16{{{100, 65535}, {200, 0}, {50, 257}}},
+			want: [][]color.RGBA{{{100, 100, 100, 255}, {}, {50, 50, 50, 1}}},
+		},
+		{
+			name: "gray8 + unflagged 16-bit band is not alpha",
+			ifd:  IFD{Photometric: 1},
+			bits: []int{8, 16},
+			rows: [][][]uint16{{{100, 0}, {200, 7}, {50, 257}}},
+			want: [][]color.RGBA{{{100, 100, 100, 255}, {200, 200, 200, 255}, {50, 50, 50, 255}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ifd := tt.ifd
+			ifd.TileWidth, ifd.TileHeight = uint32(len(tt.rows[0])), uint32(len(tt.rows))
+			ifd.SamplesPerPixel = uint16(len(tt.bits))
+			for _, b := range tt.bits {
+				ifd.BitsPerSample = append(ifd.BitsPerSample, uint16(b))
+			}
+			r := &Reader{bo: binary.BigEndian, ifds: []IFD{ifd}, bandCfg: tt.cfg}
+			img, err := r.decodeRawTile(&ifd, packSamples(len(tt.rows[0]), tt.bits, tt.rows))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for y, row := range tt.want {
+				for x, want := range row {
+					assertPixel(t, img.(*image.RGBA), x, y, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeRawFloat32TileIntegers(t *testing.T) {
+	tests := []struct {
+		name   string
+		format uint16
+		bits   uint16
+		data   []byte
+		want   []float32
+	}{
+		{"uint8", 1, 8, []byte{0, 7, 200, 255}, []float32{0, 7, 200, 255}},
+		{"int8", 2, 8, []byte{0, 7, 0xC8, 0xFF}, []float32{0, 7, -56, -1}},
+		{"uint16", 1, 16, []byte{0, 0, 0, 7, 0xFF, 0xFE, 0xFF, 0xFF}, []float32{0, 7, 65534, 65535}},
+		{"int16", 2, 16, []byte{0, 0, 0, 7, 0xFF, 0xFE, 0x80, 0x00}, []float32{0, 7, -2, -32768}},
+		{"no SampleFormat, 8-bit", 0, 8, []byte{1, 2, 3, 4}, []float32{1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ifd := IFD{TileWidth: 2, TileHeight: 2, SamplesPerPixel: 1, BitsPerSample: []uint16{tt.bits}}
+			if tt.format != 0 {
+				ifd.SampleFormat = []uint16{tt.format}
+			}
+			r := &Reader{bo: binary.BigEndian, ifds: []IFD{ifd}}
+			got, _, _, err := r.decodeRawFloat32Tile(&ifd, tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	ifd := IFD{TileWidth: 1, TileHeight: 1, SamplesPerPixel: 1, BitsPerSample: []uint16{8}, Compression: 7}
+	r := &Reader{bo: binary.BigEndian, ifds: []IFD{ifd}}
+	if _, _, _, err := r.decodeRawFloat32Tile(&ifd, []byte{0}); err == nil {
+		t.Error("JPEG-compressed integer tile: expected error")
+	}
+}
+
+func TestDecodeRawTileSigned16(t *testing.T) {
+	// int16 samples -1000, 0, 1000 and nodata -9999, stretched over
+	// [-1000, 1000].
+	ifd := IFD{TileWidth: 4, TileHeight: 1, SamplesPerPixel: 1, BitsPerSample: []uint16{16}, SampleFormat: []uint16{2}}
+	data := make([]byte, 8)
+	for i, v := range []int16{-1000, 0, 1000, -9999} {
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(v))
+	}
+	cfg := BandConfig{Rescale: RescaleLinear, RescaleMin: -1000, RescaleMax: 1000, HasNodata: true, Nodata: -9999}
+	r := &Reader{bo: binary.LittleEndian, ifds: []IFD{ifd}, bandCfg: cfg}
+	img, err := r.decodeRawTile(&ifd, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rgba := img.(*image.RGBA)
+	assertPixel(t, rgba, 0, 0, color.RGBA{0, 0, 0, 255})
+	assertPixel(t, rgba, 1, 0, color.RGBA{128, 128, 128, 255})
+	assertPixel(t, rgba, 2, 0, color.RGBA{255, 255, 255, 255})
+	assertPixel(t, rgba, 3, 0, color.RGBA{})
+}
+
+// oneTileTIFF writes a 16x16 TIFF of one empty tile with the given
+// compression and returns its path.
+func oneTileTIFF(t *testing.T, compression uint16) string {
+	t.Helper()
+	bo := binary.LittleEndian
+	var b []byte
+	b = append(b, 'I', 'I')
+	b = bo.AppendUint16(b, 42)
+	b = bo.AppendUint32(b, 8)
+	b = bo.AppendUint16(b, 7)
+	entry := func(tag, dt uint16, value uint32) {
+		b = bo.AppendUint16(b, tag)
+		b = bo.AppendUint16(b, dt)
+		b = bo.AppendUint32(b, 1)
+		b = bo.AppendUint32(b, value)
+	}
+	entry(tagImageWidth, dtShort, 16)
+	entry(tagImageLength, dtShort, 16)
+	entry(tagBitsPerSample, dtShort, 8)
+	entry(tagCompression, dtShort, uint32(compression))
+	entry(tagTileWidth, dtShort, 16)
+	entry(tagTileLength, dtShort, 16)
+	entry(tagTileOffsets, dtLong, 0)
+	b = bo.AppendUint32(b, 0) // no next IFD
+
+	path := filepath.Join(t.TempDir(), fmt.Sprintf("compression%d.tif", compression))
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenUnsupportedCompression(t *testing.T) {
+	// LERC (compression 34887).
+	path := oneTileTIFF(t, 34887)
+	r, err := Open(path)
+	if err == nil {
+		r.Close()
+		t.Fatal("Open succeeded, want an unsupported compression error")
+	}
+	var h *hint.Error
+	if !errors.As(err, &h) {
+		t.Fatalf("Open error %q is not a *hint.Error", err)
+	}
+	if h.File != path || !strings.Contains(h.Problem, "34887 (LERC)") || !strings.Contains(h.Fix, "-co COMPRESS=DEFLATE") {
+		t.Errorf("Open error = %+v", h)
+	}
+}
+
+func TestOpenZSTDAndWebP(t *testing.T) {
+	r, err := Open(oneTileTIFF(t, 50000))
+	if err != nil {
+		t.Fatalf("Open ZSTD: %v", err)
+	}
+	if got := r.FormatDescription(); !strings.HasPrefix(got, "ZSTD,") {
+		t.Errorf("FormatDescription = %q, want ZSTD", got)
+	}
+	r.Close()
+
+	path := oneTileTIFF(t, 50001)
+	r, err = Open(path)
+	if encode.WebPAvailable() {
+		if err != nil {
+			t.Fatalf("Open WebP: %v", err)
+		}
+		r.Close()
+		return
+	}
+	var h *hint.Error
+	if !errors.As(err, &h) || !strings.Contains(h.Problem, "libwebp") {
+		t.Errorf("Open WebP without libwebp: %v, want a hint to build with libwebp", err)
+	}
+}
+
 func TestBuildRescalerLogCurveShape(t *testing.T) {
 	fn := buildRescaler(RescaleLog, 0, 10000)
 
@@ -570,6 +805,48 @@ func TestParseGDALMetadataEmpty(t *testing.T) {
 	}
 }
 
+func TestGDALMetaProvenance(t *testing.T) {
+	md := parseGDALMetadataXML(`<GDALMetadata>
+  <Item name="ACQUISITIONDATETIME" domain="IMAGERY">2023-07-14T10:30:21Z</Item>
+  <Item name="OVERVIEW_RESAMPLING" domain="IMAGE_STRUCTURE">AVERAGE</Item>
+  <Item name="DESCRIPTION" sample="1" role="description">Green</Item>
+  <Item name="DESCRIPTION" sample="0" role="description">Red</Item>
+  <Item name="UNITTYPE" sample="0" role="unittype">reflectance</Item>
+  <Item name="STATISTICS_MINIMUM" sample="0">12</Item>
+  <Item name="STATISTICS_MAXIMUM" sample="0">4095</Item>
+  <Item name="STATISTICS_MEAN" sample="0">812.5</Item>
+  <Item name="STATISTICS_STDDEV" sample="0">301.25</Item>
+  <Item name="STATISTICS_MINIMUM" sample="1">7</Item>
+  <Item name="SCALE" sample="2" role="scale">0.0001</Item>
+</GDALMetadata>`)
+
+	if got := md.AcquisitionDate(); got != "2023-07-14T10:30:21Z" {
+		t.Errorf("AcquisitionDate = %q", got)
+	}
+	if got := md.OverviewResampling(); got != "AVERAGE" {
+		t.Errorf("OverviewResampling = %q", got)
+	}
+
+	bands := md.Bands()
+	if len(bands) != 2 {
+		t.Fatalf("got %d bands, want 2 (band 3 has only SCALE)", len(bands))
+	}
+	want0 := BandInfo{Band: 1, Description: "Red", Unit: "reflectance",
+		HasStats: true, Min: 12, Max: 4095, Mean: 812.5, StdDev: 301.25}
+	if bands[0] != want0 {
+		t.Errorf("band 1 = %+v, want %+v", bands[0], want0)
+	}
+	// Minimum without maximum is not a usable statistic.
+	if bands[1].Band != 2 || bands[1].Description != "Green" || bands[1].HasStats {
+		t.Errorf("band 2 = %+v", bands[1])
+	}
+
+	var nilMeta *GDALMeta
+	if nilMeta.Bands() != nil || nilMeta.AcquisitionDate() != "" {
+		t.Error("nil GDALMeta should report no provenance")
+	}
+}
+
 func TestDetectPresetFromBandsString(t *testing.T) {
 	// Dataset-level "bands" item (e.g. ESA WorldCover S2 RGBNIR).
 	// No per-band DESCRIPTION — roles extracted from "Band N: BXX (Role)" format.
@@ -803,3 +1080,82 @@ func assertPixel(t *testing.T, img *image.RGBA, x, y int, want color.RGBA) {
 		t.Errorf("pixel(%d,%d) = %v, want %v", x, y, got, want)
 	}
 }
+
+func TestClassifyIFDs_Untagged(t *testing.T) {
+	ifds := []IFD{{Width: 100}, {Width: 50}, {Width: 25}}
+	levels, masks, err := classifyIFDs(ifds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(levels) != 3 || levels[0].Width != 100 || len(masks) != 0 {
+		t.Errorf("untagged IFDs must keep file order: got %d levels, %d masks", len(levels), len(masks))
+	}
+}
+
+func TestClassifyIFDs_OddOrder(t *testing.T) {
+	tiled := func(subfile uint32, w, h uint32, spp uint16) IFD {
+		return IFD{
+			NewSubfileType: subfile, HasSubfileType: true,
+			Width: w, Height: h, TileWidth: 16, TileHeight: 16,
+			SamplesPerPixel: spp, BitsPerSample: []uint16{8},
+		}
+	}
+	thumb := tiled(subfileReduced, 64, 48, 3)
+	thumb.TileWidth, thumb.TileHeight = 0, 0 // strip-based preview
+	ifds := []IFD{
+		thumb,
+		tiled(subfileReduced, 250, 200, 4),             // overview 2
+		tiled(subfileMask, 1000, 800, 1),               // mask of main
+		tiled(0, 1000, 800, 4),                         // main image
+		tiled(subfileReduced, 500, 400, 4),             // overview 1
+		tiled(subfileReduced|subfileMask, 500, 400, 1), // mask of overview 1
+		tiled(subfilePage, 1000, 800, 4),               // second page: ignored
+		tiled(subfileReduced, 120, 100, 1),             // reduced, wrong band count
+	}
+	levels, masks, err := classifyIFDs(ifds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var widths []uint32
+	for _, l := range levels {
+		widths = append(widths, l.Width)
+	}
+	if want := []uint32{1000, 500, 250}; !reflect.DeepEqual(widths, want) {
+		t.Errorf("level widths = %v, want %v", widths, want)
+	}
+	if len(masks) != 2 {
+		t.Errorf("got %d masks, want 2", len(masks))
+	}
+}
+
+func TestClassifyIFDs_NoMainImage(t *testing.T) {
+	ifds := []IFD{{NewSubfileType: subfileReduced, HasSubfileType: true, Width: 10}}
+	if _, _, err := classifyIFDs(ifds); err == nil {
+		t.Error("expected error when every IFD is reduced-resolution")
+	}
+}
+
+func TestNonSquarePixels(t *testing.T) {
+	r := &Reader{
+		geo:  GeoInfo{PixelSizeX: 10, PixelSizeY: 30},
+		ifds: []IFD{{Width: 400, Height: 200}, {Width: 200, Height: 100}},
+	}
+	if !r.NonSquarePixels() || r.PixelSize() != 10 {
+		t.Errorf("NonSquarePixels() = %v, PixelSize() = %g; want true, the finer 10", r.NonSquarePixels(), r.PixelSize())
+	}
+	if x, y := r.IFDPixelSize(1), r.IFDPixelSizeY(1); x != 20 || y != 60 {
+		t.Errorf("level 1 pixel = %g × %g, want 20 × 60", x, y)
+	}
+	if got := r.OverviewForZoom(20); got != 1 {
+		t.Errorf("OverviewForZoom(20) = %d, want 1 (following the pixel width)", got)
+	}
+
+	r.geo = GeoInfo{PixelSizeX: 10, PixelSizeY: 10.001}
+	if r.NonSquarePixels() {
+		t.Error("pixels 0.01% apart reported non-square")
+	}
+	r.geo = GeoInfo{PixelSizeX: 10}
+	if r.NonSquarePixels() || r.IFDPixelSizeY(1) != 20 {
+		t.Errorf("without a pixel height: NonSquarePixels() = %v, IFDPixelSizeY(1) = %g; want false, the width 20", r.NonSquarePixels(), r.IFDPixelSizeY(1))
+	}
+}
@@ -0,0 +1,158 @@
+package remote
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+// errClosed fails reads of blocks whose file was closed during the read.
+var errClosed = errors.New("remote file closed")
+
+// DefaultCacheBytes is the size of DefaultCache.
+const DefaultCacheBytes = 256 << 20
+
+// DefaultCache holds the blocks of files opened without Options.Cache.
+var DefaultCache = NewCache(DefaultCacheBytes)
+
+// blockKey identifies block index of file.
+type blockKey struct {
+	file  uint64
+	index int64
+}
+
+// block is a cached block. ready is closed once data or err is set; until
+// then the block is being fetched and not in the LRU list.
+type block struct {
+	data  []byte
+	err   error
+	ready chan struct{}
+	elem  *list.Element
+}
+
+// Cache is an LRU cache of remote file blocks, bounded in bytes. Blocks
+// being fetched are shared: a read that needs one waits for the fetch
+// instead of requesting it again. Safe for concurrent use.
+type Cache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	lru      *list.List // of blockKey, most recently used first
+	blocks   map[blockKey]*block
+
+	hits, misses int64
+}
+
+// NewCache returns a cache holding up to maxBytes of blocks.
+func NewCache(maxBytes int64) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		blocks:   make(map[blockKey]*block),
+	}
+}
+
+// SetLimit changes the size of the cache, evicting blocks if it shrinks.
+func (c *Cache) SetLimit(maxBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxBytes = maxBytes
+	c.evict()
+}
+
+// Stats returns the number of block lookups that found the block cached
+// (or being fetched) and that had to fetch it.
+func (c *Cache) Stats() (hits, misses int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// acquire returns blocks first..last of file. Blocks neither cached nor
+// being fetched are added as pending and returned in missing; the caller
+// must fetch them and call complete for each.
+func (c *Cache) acquire(file uint64, first, last int64) (blocks []*block, missing []int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	blocks = make([]*block, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		key := blockKey{file, i}
+		b, ok := c.blocks[key]
+		if ok {
+			c.hits++
+			if b.elem != nil {
+				c.lru.MoveToFront(b.elem)
+			}
+		} else {
+			c.misses++
+			b = &block{ready: make(chan struct{})}
+			c.blocks[key] = b
+			missing = append(missing, i)
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, missing
+}
+
+// complete finishes the pending block key with data, or with err, and
+// wakes its readers. Failed blocks are dropped, so a later read retries.
+func (c *Cache) complete(key blockKey, data []byte, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.blocks[key]
+	if b == nil || b.elem != nil {
+		return
+	}
+	b.data, b.err = data, err
+	close(b.ready)
+	if err != nil {
+		delete(c.blocks, key)
+		return
+	}
+	b.elem = c.lru.PushFront(key)
+	c.bytes += int64(len(data))
+	c.evict()
+}
+
+// add caches data as block key, unless the block is present already.
+func (c *Cache) add(key blockKey, data []byte) {
+	c.mu.Lock()
+	if _, ok := c.blocks[key]; ok {
+		c.mu.Unlock()
+		return
+	}
+	c.blocks[key] = &block{ready: make(chan struct{})}
+	c.mu.Unlock()
+	c.complete(key, data, nil)
+}
+
+// evict drops least recently used blocks until the cache fits its limit.
+// The caller holds c.mu.
+func (c *Cache) evict() {
+	for c.bytes > c.maxBytes && c.lru.Len() > 0 {
+		key := c.lru.Remove(c.lru.Back()).(blockKey)
+		c.bytes -= int64(len(c.blocks[key].data))
+		delete(c.blocks, key)
+	}
+}
+
+// dropFile removes the cached blocks of file. Blocks still being fetched
+// complete for their readers but are not cached.
+func (c *Cache) dropFile(file uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, b := range c.blocks {
+		if key.file != file {
+			continue
+		}
+		if b.elem != nil {
+			c.lru.Remove(b.elem)
+			c.bytes -= int64(len(b.data))
+		} else {
+			// The fetch completes nothing once the block is gone.
+			b.err = errClosed
+			close(b.ready)
+		}
+		delete(c.blocks, key)
+	}
+}
@@ -0,0 +1,273 @@
+// Package remote reads remote files by HTTP range requests, so that
+// cloud-hosted COGs can be tiled without downloading them first. A File
+// serves ReadAt from fixed-size blocks kept in an LRU Cache shared by all
+// open files; missing blocks of one read are fetched in parallel, as one
+// request per run of adjacent blocks.
+//
+// URLs are http://, https://, or s3://bucket/key. S3 objects are read from
+// the bucket's virtual-hosted endpoint in AWS_REGION (default us-east-1),
+// or path-style from AWS_ENDPOINT_URL_S3 / AWS_ENDPOINT_URL (MinIO, Ceph,
+// other providers). Requests are signed (AWS Signature Version 4) when
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are set, and anonymous
+// otherwise, for public buckets.
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of Options.
+const (
+	DefaultBlockSize = 256 << 10
+	DefaultParallel  = 8
+)
+
+// IsURL reports whether path names a remote file rather than a local one.
+func IsURL(path string) bool {
+	for _, scheme := range []string{"http://", "https://", "s3://"} {
+		if len(path) > len(scheme) && strings.EqualFold(path[:len(scheme)], scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Options configure Open. Zero values select the defaults.
+type Options struct {
+	Client    *http.Client
+	Cache     *Cache // blocks of all files; nil uses DefaultCache
+	BlockSize int64  // bytes per block and minimum request size
+	Parallel  int    // concurrent range requests per read
+}
+
+// File is a remote file read by range requests. Safe for concurrent use.
+type File struct {
+	url       string
+	client    *http.Client
+	sign      func(*http.Request) // adds credentials, or nil
+	cache     *Cache
+	id        uint64
+	size      int64
+	blockSize int64
+	parallel  int
+
+	requests atomic.Int64
+}
+
+// fileIDs numbers open files for the cache keys.
+var fileIDs atomic.Uint64
+
+// Open opens the file at rawURL. Its size comes from the response to the
+// first block's request, which fails unless the server supports range
+// requests.
+func Open(rawURL string, opts Options) (*File, error) {
+	u, sign, err := resolve(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{
+		url:       u,
+		client:    opts.Client,
+		sign:      sign,
+		cache:     opts.Cache,
+		id:        fileIDs.Add(1),
+		blockSize: opts.BlockSize,
+		parallel:  opts.Parallel,
+	}
+	if f.client == nil {
+		f.client = http.DefaultClient
+	}
+	if f.cache == nil {
+		f.cache = DefaultCache
+	}
+	if f.blockSize <= 0 {
+		f.blockSize = DefaultBlockSize
+	}
+	if f.parallel <= 0 {
+		f.parallel = DefaultParallel
+	}
+
+	data, size, err := f.fetch(0, f.blockSize)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", rawURL, err)
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("%s: empty file", rawURL)
+	}
+	f.size = size
+	f.cache.add(blockKey{f.id, 0}, data)
+	return f, nil
+}
+
+// Size returns the file size in bytes.
+func (f *File) Size() int64 { return f.size }
+
+// Requests returns the number of range requests made so far.
+func (f *File) Requests() int64 { return f.requests.Load() }
+
+// Close drops the file's cached blocks.
+func (f *File) Close() error {
+	f.cache.dropFile(f.id)
+	return nil
+}
+
+// ReadAt reads len(p) bytes at off, fetching the blocks not in the cache.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	n := len(p)
+	if rem := f.size - off; int64(n) > rem {
+		n = int(rem)
+	}
+	first, last := off/f.blockSize, (off+int64(n)-1)/f.blockSize
+
+	blocks, missing := f.cache.acquire(f.id, first, last)
+	if len(missing) > 0 {
+		f.fetchRuns(missing)
+	}
+	read := 0
+	for i, b := range blocks {
+		<-b.ready
+		if b.err != nil {
+			return read, b.err
+		}
+		start := (first + int64(i)) * f.blockSize
+		lo := max(off, start) - start
+		read += copy(p[read:n], b.data[lo:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fetchRuns fetches the blocks missing (sorted block indexes, claimed by
+// this read) in runs of adjacent blocks of at most maxRunBlocks, up to
+// f.parallel requests at a time, and completes them in the cache. It
+// returns without waiting; readers wait on the blocks.
+func (f *File) fetchRuns(missing []int64) {
+	sem := make(chan struct{}, f.parallel)
+	for len(missing) > 0 {
+		n := 1
+		for n < len(missing) && n < maxRunBlocks && missing[n] == missing[n-1]+1 {
+			n++
+		}
+		run := missing[:n]
+		missing = missing[n:]
+
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem }()
+			off := run[0] * f.blockSize
+			data, _, err := f.fetch(off, min(int64(len(run))*f.blockSize, f.size-off))
+			for i, idx := range run {
+				key := blockKey{f.id, idx}
+				if err != nil {
+					f.cache.complete(key, nil, err)
+					continue
+				}
+				lo := min(int64(i)*f.blockSize, int64(len(data)))
+				hi := min(lo+f.blockSize, int64(len(data)))
+				f.cache.complete(key, data[lo:hi:hi], nil)
+			}
+		}()
+	}
+}
+
+// maxRunBlocks bounds the blocks of one request, so that a large read is
+// split across parallel requests.
+const maxRunBlocks = 16
+
+// fetch requests n bytes at off and returns them with the file size from
+// Content-Range. Transient failures are retried.
+func (f *File) fetch(off, n int64) ([]byte, int64, error) {
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * retryDelay)
+		}
+		data, size, retry, err := f.fetchOnce(off, n)
+		if err == nil {
+			return data, size, nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+	return nil, 0, lastErr
+}
+
+// Retries of failed range requests.
+const (
+	maxAttempts = 4
+	retryDelay  = 250 * time.Millisecond
+)
+
+// fetchOnce makes one range request. retry reports whether the failure
+// may be transient (network error, 5xx, 429).
+func (f *File) fetchOnce(off, n int64) (data []byte, size int64, retry bool, err error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, f.url, nil)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	if f.sign != nil {
+		f.sign(req)
+	}
+	f.requests.Add(1)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, 0, true, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && off == 0:
+		return nil, 0, false, nil // empty file
+	case resp.StatusCode == http.StatusOK:
+		return nil, 0, false, fmt.Errorf("server does not support range requests (200 instead of 206)")
+	default:
+		retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return nil, 0, retry, fmt.Errorf("range request: %s", resp.Status)
+	}
+
+	size, err = contentRangeSize(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, 0, false, err
+	}
+	data, err = io.ReadAll(io.LimitReader(resp.Body, n))
+	if err != nil {
+		return nil, 0, true, err
+	}
+	if want := min(n, size-off); int64(len(data)) != want {
+		return nil, 0, true, fmt.Errorf("range request: got %d bytes, want %d", len(data), want)
+	}
+	return data, size, false, nil
+}
+
+// contentRangeSize returns the complete length from a Content-Range
+// header, "bytes first-last/size".
+func contentRangeSize(h string) (int64, error) {
+	i := strings.LastIndexByte(h, '/')
+	if !strings.HasPrefix(h, "bytes ") || i < 0 {
+		return 0, fmt.Errorf("range request: bad Content-Range %q", h)
+	}
+	size, err := strconv.ParseInt(h[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("range request: unknown size in Content-Range %q", h)
+	}
+	return size, nil
+}
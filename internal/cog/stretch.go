@@ -0,0 +1,132 @@
+package cog
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxStretchTiles bounds the tiles read per source by Percentiles.
+const maxStretchTiles = 64
+
+// Percentiles returns the lo and hi percentiles (0-100) of the samples of
+// the bands cfg maps to R, G and B, across the sources: a rescale range for
+// a percentile stretch (--rescale-range p2,p98). Each source is sampled at
+// its smallest stored level, up to maxStretchTiles tiles. Nodata samples
+// (the source's SetIntegerNoData override, else cfg's nodata, else the
+// file's) and the padding of edge tiles are skipped. Only 8 and 16-bit integer sources are supported.
+func Percentiles(sources []*Reader, cfg BandConfig, lo, hi float64) (float64, float64, error) {
+	if lo < 0 || hi > 100 || lo >= hi {
+		return 0, 0, fmt.Errorf("percentiles %g,%g out of order or outside 0-100", lo, hi)
+	}
+	// hist counts samples by value; signed samples are offset by 32768.
+	var hist [1 << 16]int64
+	var total int64
+	signed := false
+	for _, r := range sources {
+		ifd := &r.ifds[0]
+		if r.IsFloat() || ifd.mixedDepth() || ifd.imageCoded() {
+			return 0, 0, fmt.Errorf("%s: percentiles need 8 or 16-bit integer samples (not JPEG or WebP)", r.Path())
+		}
+		bps := r.BitsPerSample()
+		if bps != 8 && bps != 16 {
+			return 0, 0, fmt.Errorf("%s: percentiles need 8 or 16-bit integer samples, got %d bits", r.Path(), bps)
+		}
+		spp := int(ifd.SamplesPerPixel)
+		// The mapped bands, as decodeRawTile defaults them; a single-band
+		// file only has the first.
+		var bands []int
+		for i, b := range cfg.Bands {
+			if b == 0 {
+				b = i + 1
+			}
+			if b <= spp && !slices.Contains(bands, b) {
+				bands = append(bands, b)
+			}
+		}
+		if len(bands) == 0 {
+			return 0, 0, fmt.Errorf("%s: bands %v out of range (have %d)", r.Path(), cfg.Bands, spp)
+		}
+		signed = ifd.signedSamples()
+
+		intND, hasIntND := r.IntegerNoData()
+		nodata, hasNodata := -1, false
+		switch v, err := strconv.Atoi(strings.TrimSpace(ifd.NoData)); {
+		case hasIntND:
+			// The per-source override replaces both.
+		case cfg.HasNodata:
+			nodata, hasNodata = int(cfg.Nodata), true
+		case err == nil:
+			nodata, hasNodata = v, true
+		}
+
+		level := len(r.ifds) - 1
+		// Synthesized levels are averaged in memory; sample the real level below.
+		for level > 0 && r.synthFor(level) != nil {
+			level--
+		}
+		lv := &r.ifds[level]
+		tw, th := int(lv.TileWidth), int(lv.TileHeight)
+		across, down := lv.TilesAcross(), lv.TilesDown()
+		step := max(1, across*down/maxStretchTiles)
+		bytes := bps / 8
+		for i := 0; i < across*down; i += step {
+			col, row := i%across, i/across
+			data, _, err := r.readTileRaw(level, col, row)
+			if err != nil {
+				return 0, 0, err
+			}
+			if data == nil {
+				continue
+			}
+			w := min(tw, int(lv.Width)-col*tw)
+			h := min(th, int(lv.Height)-row*th)
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					for _, b := range bands {
+						off := ((y*tw+x)*spp + b - 1) * bytes
+						if off+bytes > len(data) {
+							continue
+						}
+						v := int(data[off])
+						if bytes == 2 {
+							v = int(r.bo.Uint16(data[off:]))
+							if signed {
+								v = int(int16(v))
+							}
+						}
+						if (hasNodata && v == nodata) || (hasIntND && intND.Match(float64(v))) {
+							continue
+						}
+						if signed {
+							v += 1 << 15
+						}
+						hist[v]++
+						total++
+					}
+				}
+			}
+		}
+	}
+	if total == 0 {
+		return 0, 0, fmt.Errorf("no valid samples to compute percentiles from")
+	}
+
+	at := func(p float64) float64 {
+		rank := int64(math.Round(p / 100 * float64(total-1)))
+		var seen int64
+		for v, n := range hist {
+			seen += n
+			if seen > rank {
+				if signed {
+					return float64(v - 1<<15)
+				}
+				return float64(v)
+			}
+		}
+		return 0
+	}
+	return at(lo), at(hi), nil
+}
@@ -0,0 +1,50 @@
+package cog
+
+import "testing"
+
+func TestPercentiles(t *testing.T) {
+	r := categoricalTestReader(16, func(x, y int) int { return 100 * x })
+	for _, c := range []struct {
+		lo, hi         float64
+		wantLo, wantHi float64
+	}{
+		{0, 100, 0, 12700},
+		{2, 98, 200, 12500},
+		{50, 100, 6400, 12700},
+	} {
+		lo, hi, err := Percentiles([]*Reader{r}, BandConfig{}, c.lo, c.hi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lo != c.wantLo || hi != c.wantHi {
+			t.Errorf("p%g,p%g = %g,%g, want %g,%g", c.lo, c.hi, lo, hi, c.wantLo, c.wantHi)
+		}
+	}
+
+	// Edge-tile padding past the image width and nodata are skipped.
+	r.ifds[0].Width = 100
+	lo, hi, err := Percentiles([]*Reader{r}, BandConfig{HasNodata: true, Nodata: 0}, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lo != 100 || hi != 9900 {
+		t.Errorf("padded with nodata: %g,%g, want 100,9900", lo, hi)
+	}
+
+	if _, _, err := Percentiles([]*Reader{r}, BandConfig{}, 98, 2); err == nil {
+		t.Error("expected error for percentiles out of order")
+	}
+}
+
+func TestPercentilesSigned(t *testing.T) {
+	r := categoricalTestReader(16, func(x, y int) int { return int(uint16(int16(x - 64))) })
+	r.ifds[0].SampleFormat = []uint16{2}
+	r.ifds[0].NoData = "-64"
+	lo, hi, err := Percentiles([]*Reader{r}, BandConfig{}, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lo != -63 || hi != 63 {
+		t.Errorf("signed: %g,%g, want -63,63", lo, hi)
+	}
+}
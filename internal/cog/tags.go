@@ -0,0 +1,352 @@
+package cog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxTagValues is how many values of an array tag are shown before the
+// rest is abbreviated (tile offsets run into the thousands).
+const maxTagValues = 16
+
+// Tag is one TIFF directory entry, decoded for display.
+type Tag struct {
+	ID    uint16
+	Name  string // e.g. "ImageWidth"; "" for tags this package does not know
+	Type  string // TIFF data type, e.g. "SHORT"
+	Count uint64
+	Value string // decoded value; long arrays are abbreviated
+}
+
+// GeoKey is one entry of the GeoKeyDirectoryTag (34735).
+type GeoKey struct {
+	ID       uint16
+	Name     string // e.g. "ProjectedCSTypeGeoKey"; "" if unknown
+	Location string // "" for inline values, else the tag holding them
+	Count    int
+	Value    string
+}
+
+// TagDir is the raw content of one IFD, in file order.
+type TagDir struct {
+	Offset  uint64 // file offset of the IFD
+	Tags    []Tag
+	GeoKeys []GeoKey // nil without a GeoKeyDirectoryTag
+}
+
+// ReadTags reads every IFD of the TIFF at path without interpreting it,
+// like tiffinfo/listgeo: all tags with type, count, and decoded value, plus
+// the GeoKey directory. It works on files Open rejects, which is what makes
+// it useful for diagnosing misdetected projections or nodata.
+func ReadTags(path string) ([]TagDir, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dirs, bo, err := readTIFFDirs(f)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]TagDir, len(dirs))
+	for i, d := range dirs {
+		out[i] = decodeTagDir(d, bo)
+	}
+	return out, nil
+}
+
+// decodeTagDir decodes the entries of d for display.
+func decodeTagDir(d tiffDir, bo binary.ByteOrder) TagDir {
+	td := TagDir{Offset: d.offset}
+	var geoKeys []uint16
+	var geoDoubles []float64
+	var geoASCII string
+	for _, e := range d.entries {
+		td.Tags = append(td.Tags, Tag{
+			ID:    e.Tag,
+			Name:  tagNames[e.Tag],
+			Type:  dataTypeName(e.DataType),
+			Count: e.Count,
+			Value: tagValue(e, bo),
+		})
+		switch {
+		case e.Tag == tagGeoKeyDirectoryTag && e.DataType == dtShort:
+			geoKeys = getUint16Slice(e, bo)
+		case e.Tag == tagGeoDoubleParamsTag:
+			geoDoubles = getFloat64Slice(e, bo)
+		case e.Tag == tagGeoAsciiParamsTag:
+			geoASCII = string(e.Value[:min(int(e.Count), len(e.Value))])
+		}
+	}
+	td.GeoKeys = decodeGeoKeys(geoKeys, geoDoubles, geoASCII)
+	return td
+}
+
+// tagValue formats the value of e: ASCII quoted, numbers space-separated,
+// with the meaning of well-known enum values appended.
+func tagValue(e tiffEntry, bo binary.ByteOrder) string {
+	size := dataTypeSize(e.DataType)
+	n := len(e.Value) / size
+	if e.Count < uint64(n) {
+		n = int(e.Count)
+	}
+
+	if e.DataType == dtASCII {
+		return strconv.Quote(strings.TrimRight(string(e.Value[:n]), "\x00"))
+	}
+
+	shown := min(n, maxTagValues)
+	vals := make([]string, shown)
+	for i := range vals {
+		v := e.Value[i*size : (i+1)*size]
+		switch e.DataType {
+		case dtShort:
+			vals[i] = strconv.FormatUint(uint64(bo.Uint16(v)), 10)
+		case dtSShort:
+			vals[i] = strconv.FormatInt(int64(int16(bo.Uint16(v))), 10)
+		case dtLong, dtIFD8:
+			vals[i] = strconv.FormatUint(uint64(bo.Uint32(v)), 10)
+		case dtSLong:
+			vals[i] = strconv.FormatInt(int64(int32(bo.Uint32(v))), 10)
+		case dtLong8:
+			vals[i] = strconv.FormatUint(bo.Uint64(v), 10)
+		case dtSLong8:
+			vals[i] = strconv.FormatInt(int64(bo.Uint64(v)), 10)
+		case dtRational:
+			vals[i] = fmt.Sprintf("%d/%d", bo.Uint32(v), bo.Uint32(v[4:]))
+		case dtSRational:
+			vals[i] = fmt.Sprintf("%d/%d", int32(bo.Uint32(v)), int32(bo.Uint32(v[4:])))
+		case dtFloat:
+			vals[i] = strconv.FormatFloat(float64(float32FromBits(bo.Uint32(v))), 'f', -1, 32)
+		case dtDouble:
+			vals[i] = strconv.FormatFloat(float64FromBits(bo.Uint64(v)), 'f', -1, 64)
+		case dtSByte:
+			vals[i] = strconv.Itoa(int(int8(v[0])))
+		default: // BYTE, UNDEFINED, unknown types
+			vals[i] = strconv.Itoa(int(v[0]))
+		}
+	}
+	s := strings.Join(vals, " ")
+	if n > shown {
+		s += fmt.Sprintf(" ... (%d values)", n)
+	}
+	if n == 1 {
+		if names, ok := tagEnums[e.Tag]; ok {
+			if v, err := strconv.Atoi(s); err == nil && names[v] != "" {
+				s += " (" + names[v] + ")"
+			}
+		}
+	}
+	return s
+}
+
+// decodeGeoKeys decodes a GeoKeyDirectoryTag, resolving values stored in
+// GeoDoubleParams and GeoAsciiParams. Returns nil for a missing or
+// truncated directory header.
+func decodeGeoKeys(dir []uint16, doubles []float64, ascii string) []GeoKey {
+	if len(dir) < 4 {
+		return nil
+	}
+	numKeys := int(dir[3])
+	keys := make([]GeoKey, 0, numKeys)
+	for i := 0; i < numKeys; i++ {
+		base := 4 + i*4
+		if base+3 >= len(dir) {
+			break
+		}
+		k := GeoKey{ID: dir[base], Name: geoKeyNames[dir[base]], Count: int(dir[base+2])}
+		loc, off := dir[base+1], int(dir[base+3])
+		switch loc {
+		case 0:
+			k.Value = strconv.Itoa(off)
+			if names, ok := geoKeyEnums[k.ID]; ok && names[off] != "" {
+				k.Value += " (" + names[off] + ")"
+			} else if off == 32767 {
+				k.Value += " (user-defined)"
+			}
+		case tagGeoDoubleParamsTag:
+			k.Location = "GeoDoubleParams"
+			var vals []string
+			for j := off; j < off+k.Count && j < len(doubles); j++ {
+				vals = append(vals, strconv.FormatFloat(doubles[j], 'f', -1, 64))
+			}
+			k.Value = strings.Join(vals, " ")
+		case tagGeoAsciiParamsTag:
+			k.Location = "GeoAsciiParams"
+			end := min(off+k.Count, len(ascii))
+			if off <= end {
+				// Strings in GeoAsciiParams end with "|".
+				k.Value = strconv.Quote(strings.TrimSuffix(ascii[off:end], "|"))
+			}
+		default:
+			k.Location = fmt.Sprintf("tag %d", loc)
+			k.Value = fmt.Sprintf("offset %d", off)
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// dataTypeName returns the TIFF name of data type dt.
+func dataTypeName(dt uint16) string {
+	switch dt {
+	case dtByte:
+		return "BYTE"
+	case dtASCII:
+		return "ASCII"
+	case dtShort:
+		return "SHORT"
+	case dtLong:
+		return "LONG"
+	case dtRational:
+		return "RATIONAL"
+	case dtSByte:
+		return "SBYTE"
+	case dtUndef:
+		return "UNDEFINED"
+	case dtSShort:
+		return "SSHORT"
+	case dtSLong:
+		return "SLONG"
+	case dtSRational:
+		return "SRATIONAL"
+	case dtFloat:
+		return "FLOAT"
+	case dtDouble:
+		return "DOUBLE"
+	case dtLong8:
+		return "LONG8"
+	case dtSLong8:
+		return "SLONG8"
+	case dtIFD8:
+		return "IFD8"
+	default:
+		return fmt.Sprintf("type %d", dt)
+	}
+}
+
+// tagNames names the TIFF, GeoTIFF, and GDAL tags commonly found in COGs.
+var tagNames = map[uint16]string{
+	tagNewSubfileType:     "NewSubfileType",
+	tagSubfileType:        "SubfileType",
+	tagImageWidth:         "ImageWidth",
+	tagImageLength:        "ImageLength",
+	tagBitsPerSample:      "BitsPerSample",
+	tagCompression:        "Compression",
+	tagPhotometric:        "PhotometricInterpretation",
+	270:                   "ImageDescription",
+	tagStripOffsets:       "StripOffsets",
+	tagOrientation:        "Orientation",
+	tagSamplesPerPixel:    "SamplesPerPixel",
+	tagRowsPerStrip:       "RowsPerStrip",
+	tagStripByteCounts:    "StripByteCounts",
+	282:                   "XResolution",
+	283:                   "YResolution",
+	tagPlanarConfig:       "PlanarConfiguration",
+	296:                   "ResolutionUnit",
+	305:                   "Software",
+	306:                   "DateTime",
+	tagPredictor:          "Predictor",
+	tagColorMap:           "ColorMap",
+	tagTileWidth:          "TileWidth",
+	tagTileLength:         "TileLength",
+	tagTileOffsets:        "TileOffsets",
+	tagTileByteCounts:     "TileByteCounts",
+	tagExtraSamples:       "ExtraSamples",
+	tagSampleFormat:       "SampleFormat",
+	340:                   "SMinSampleValue",
+	341:                   "SMaxSampleValue",
+	tagJPEGTables:         "JPEGTables",
+	33432:                 "Copyright",
+	tagModelPixelScaleTag: "ModelPixelScaleTag",
+	tagModelTiepointTag:   "ModelTiepointTag",
+	34264:                 "ModelTransformationTag",
+	tagGeoKeyDirectoryTag: "GeoKeyDirectoryTag",
+	tagGeoDoubleParamsTag: "GeoDoubleParamsTag",
+	tagGeoAsciiParamsTag:  "GeoAsciiParamsTag",
+	tagGDALMetadata:       "GDAL_METADATA",
+	tagGDAL_NODATA:        "GDAL_NODATA",
+	50000:                 "ZSTD_LEVEL",
+	65000:                 "JPEG_QUALITY",
+}
+
+// tagEnums names the values of enumerated tags.
+var tagEnums = map[uint16]map[int]string{
+	tagCompression: {
+		1: "none", 5: "LZW", 6: "old-style JPEG", 7: "JPEG", 8: "Deflate",
+		32773: "PackBits", 32946: "Deflate (obsolete code)", 34887: "LERC",
+		34925: "LZMA", 50000: "ZSTD", 50001: "WebP", 50002: "JPEG XL",
+	},
+	tagPhotometric: {
+		0: "min-is-white", 1: "min-is-black", 2: "RGB", 3: "palette",
+		4: "transparency mask", 5: "CMYK", 6: "YCbCr", 8: "CIELab",
+	},
+	tagPlanarConfig: {1: "contiguous", 2: "separate"},
+	tagPredictor:    {1: "none", 2: "horizontal differencing", 3: "floating point"},
+	tagSampleFormat: {1: "unsigned integer", 2: "signed integer", 3: "IEEE float", 4: "undefined"},
+	tagOrientation: {
+		1: "top-left", 2: "top-right", 3: "bottom-right", 4: "bottom-left",
+		5: "left-top", 6: "right-top", 7: "right-bottom", 8: "left-bottom",
+	},
+	tagNewSubfileType: {0: "full resolution", 1: "reduced resolution", 4: "mask", 5: "reduced-resolution mask"},
+}
+
+// compressionName names a TIFF compression code, or returns "unknown".
+func compressionName(c uint16) string {
+	if name, ok := tagEnums[tagCompression][int(c)]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// geoKeyNames names the GeoKeys of GeoTIFF 1.1.
+var geoKeyNames = map[uint16]string{
+	gkModelTypeGeoKey:       "GTModelTypeGeoKey",
+	gkRasterTypeGeoKey:      "GTRasterTypeGeoKey",
+	1026:                    "GTCitationGeoKey",
+	gkGeographicTypeGeoKey:  "GeographicTypeGeoKey",
+	2049:                    "GeogCitationGeoKey",
+	2050:                    "GeogGeodeticDatumGeoKey",
+	2051:                    "GeogPrimeMeridianGeoKey",
+	2052:                    "GeogLinearUnitsGeoKey",
+	2053:                    "GeogLinearUnitSizeGeoKey",
+	2054:                    "GeogAngularUnitsGeoKey",
+	2055:                    "GeogAngularUnitSizeGeoKey",
+	2056:                    "GeogEllipsoidGeoKey",
+	2057:                    "GeogSemiMajorAxisGeoKey",
+	2058:                    "GeogSemiMinorAxisGeoKey",
+	2059:                    "GeogInvFlatteningGeoKey",
+	2060:                    "GeogAzimuthUnitsGeoKey",
+	2061:                    "GeogPrimeMeridianLongGeoKey",
+	gkProjectedCSTypeGeoKey: "ProjectedCSTypeGeoKey",
+	3073:                    "PCSCitationGeoKey",
+	3074:                    "ProjectionGeoKey",
+	3075:                    "ProjCoordTransGeoKey",
+	3076:                    "ProjLinearUnitsGeoKey",
+	3077:                    "ProjLinearUnitSizeGeoKey",
+	3078:                    "ProjStdParallel1GeoKey",
+	3079:                    "ProjStdParallel2GeoKey",
+	3080:                    "ProjNatOriginLongGeoKey",
+	3081:                    "ProjNatOriginLatGeoKey",
+	3082:                    "ProjFalseEastingGeoKey",
+	3083:                    "ProjFalseNorthingGeoKey",
+	3088:                    "ProjCenterLongGeoKey",
+	3089:                    "ProjCenterLatGeoKey",
+	3092:                    "ProjScaleAtNatOriginGeoKey",
+	4096:                    "VerticalCSTypeGeoKey",
+	4097:                    "VerticalCitationGeoKey",
+	4098:                    "VerticalDatumGeoKey",
+	4099:                    "VerticalUnitsGeoKey",
+}
+
+// geoKeyEnums names the values of enumerated GeoKeys.
+var geoKeyEnums = map[uint16]map[int]string{
+	gkModelTypeGeoKey:  {1: "projected", 2: "geographic", 3: "geocentric"},
+	gkRasterTypeGeoKey: {1: "PixelIsArea", 2: "PixelIsPoint"},
+	3076:               {9001: "metre", 9002: "foot", 9003: "US survey foot"},
+	2054:               {9101: "radian", 9102: "degree"},
+}
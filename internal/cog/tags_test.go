@@ -0,0 +1,140 @@
+package cog
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTagValue(t *testing.T) {
+	bo := binary.LittleEndian
+	le16 := func(vs ...uint16) []byte {
+		b := make([]byte, 2*len(vs))
+		for i, v := range vs {
+			bo.PutUint16(b[2*i:], v)
+		}
+		return b
+	}
+	offsets := make([]byte, 4*20)
+	for i := 0; i < 20; i++ {
+		bo.PutUint32(offsets[4*i:], uint32(1000+i))
+	}
+	scale := make([]byte, 16)
+	bo.PutUint64(scale, math.Float64bits(0.5))
+	bo.PutUint64(scale[8:], math.Float64bits(-2))
+
+	for _, c := range []struct {
+		name string
+		e    tiffEntry
+		want string
+	}{
+		{"enum", tiffEntry{Tag: tagCompression, DataType: dtShort, Count: 1, Value: le16(5, 0)}, "5 (LZW)"},
+		{"unknown enum value", tiffEntry{Tag: tagCompression, DataType: dtShort, Count: 1, Value: le16(999, 0)}, "999"},
+		{"array", tiffEntry{Tag: tagBitsPerSample, DataType: dtShort, Count: 3, Value: le16(8, 8, 8)}, "8 8 8"},
+		{"ascii", tiffEntry{Tag: tagGDAL_NODATA, DataType: dtASCII, Count: 6, Value: []byte("-9999\x00")}, `"-9999"`},
+		{"double", tiffEntry{Tag: tagModelPixelScaleTag, DataType: dtDouble, Count: 2, Value: scale}, "0.5 -2"},
+		{"abbreviated", tiffEntry{Tag: tagTileOffsets, DataType: dtLong, Count: 20, Value: offsets},
+			"1000 1001 1002 1003 1004 1005 1006 1007 1008 1009 1010 1011 1012 1013 1014 1015 ... (20 values)"},
+	} {
+		if got := tagValue(c.e, bo); got != c.want {
+			t.Errorf("%s: tagValue = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDecodeGeoKeys(t *testing.T) {
+	dir := []uint16{
+		1, 1, 0, 4,
+		gkModelTypeGeoKey, 0, 1, 1,
+		gkProjectedCSTypeGeoKey, 0, 1, 2056,
+		3073, tagGeoAsciiParamsTag, 7, 0,
+		2057, tagGeoDoubleParamsTag, 1, 1,
+	}
+	keys := decodeGeoKeys(dir, []float64{0, 6378137}, "CH1903+|")
+	want := []GeoKey{
+		{ID: gkModelTypeGeoKey, Name: "GTModelTypeGeoKey", Count: 1, Value: "1 (projected)"},
+		{ID: gkProjectedCSTypeGeoKey, Name: "ProjectedCSTypeGeoKey", Count: 1, Value: "2056"},
+		{ID: 3073, Name: "PCSCitationGeoKey", Location: "GeoAsciiParams", Count: 7, Value: `"CH1903+"`},
+		{ID: 2057, Name: "GeogSemiMajorAxisGeoKey", Location: "GeoDoubleParams", Count: 1, Value: "6378137"},
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("got %d keys, want %d: %+v", len(keys), len(want), keys)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Errorf("key %d = %+v, want %+v", i, keys[i], want[i])
+		}
+	}
+
+	if keys := decodeGeoKeys([]uint16{1, 1}, nil, ""); keys != nil {
+		t.Errorf("truncated directory: got %+v, want nil", keys)
+	}
+}
+
+func TestParseEPSG(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  []uint16
+		want int
+	}{
+		{"projected", []uint16{1, 1, 0, 1, gkProjectedCSTypeGeoKey, 0, 1, 25832}, 25832},
+		{"geographic", []uint16{1, 1, 0, 1, gkGeographicTypeGeoKey, 0, 1, 4326}, 4326},
+		// The base geographic CRS sorts before the projected one.
+		{"both", []uint16{1, 1, 0, 2, gkGeographicTypeGeoKey, 0, 1, 4258, gkProjectedCSTypeGeoKey, 0, 1, 25832}, 25832},
+		{"none", []uint16{1, 1, 0, 1, gkModelTypeGeoKey, 0, 1, 1}, 0},
+	}
+	for _, tt := range tests {
+		if got := parseEPSG(tt.dir); got != tt.want {
+			t.Errorf("%s: parseEPSG = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadTags(t *testing.T) {
+	// Minimal little-endian TIFF: header, then one IFD with two inline
+	// entries and an out-of-line ASCII value.
+	bo := binary.LittleEndian
+	var b []byte
+	b = append(b, 'I', 'I')
+	b = bo.AppendUint16(b, 42)
+	b = bo.AppendUint32(b, 8)
+	b = bo.AppendUint16(b, 3)
+	entry := func(tag, dt uint16, count, value uint32) {
+		b = bo.AppendUint16(b, tag)
+		b = bo.AppendUint16(b, dt)
+		b = bo.AppendUint32(b, count)
+		b = bo.AppendUint32(b, value)
+	}
+	entry(tagImageWidth, dtLong, 1, 512)
+	entry(tagSampleFormat, dtShort, 1, 3)
+	entry(tagGDAL_NODATA, dtASCII, 6, 8+2+3*12+4)
+	b = bo.AppendUint32(b, 0) // no next IFD
+	b = append(b, "-9999\x00"...)
+
+	path := filepath.Join(t.TempDir(), "tags.tif")
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dirs, err := ReadTags(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 || dirs[0].Offset != 8 || len(dirs[0].Tags) != 3 {
+		t.Fatalf("got %+v, want one IFD at offset 8 with 3 tags", dirs)
+	}
+	want := []Tag{
+		{ID: tagImageWidth, Name: "ImageWidth", Type: "LONG", Count: 1, Value: "512"},
+		{ID: tagSampleFormat, Name: "SampleFormat", Type: "SHORT", Count: 1, Value: "3 (IEEE float)"},
+		{ID: tagGDAL_NODATA, Name: "GDAL_NODATA", Type: "ASCII", Count: 6, Value: `"-9999"`},
+	}
+	for i := range want {
+		if dirs[0].Tags[i] != want[i] {
+			t.Errorf("tag %d = %+v, want %+v", i, dirs[0].Tags[i], want[i])
+		}
+	}
+	if dirs[0].GeoKeys != nil {
+		t.Errorf("GeoKeys = %+v, want nil", dirs[0].GeoKeys)
+	}
+}
@@ -1,6 +1,7 @@
 package cog
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"os"
@@ -9,6 +10,9 @@ import (
 	"strings"
 )
 
+// errRotatedTFW is returned by parseTFW for world files with rotation terms.
+var errRotatedTFW = errors.New("rotated world files are not supported")
+
 // TFW holds the six parameters from a TIFF World File (.tfw).
 //
 // Line 1: pixel width (x-component of pixel size)
@@ -57,8 +61,7 @@ func parseTFW(path string) (*TFW, error) {
 	}
 
 	if tfw.RotationX != 0 || tfw.RotationY != 0 {
-		return nil, fmt.Errorf("TFW %s: rotated world files are not supported (rotation: %f, %f)",
-			path, tfw.RotationX, tfw.RotationY)
+		return nil, fmt.Errorf("%w (rotation: %f, %f)", errRotatedTFW, tfw.RotationX, tfw.RotationY)
 	}
 
 	return tfw, nil
@@ -92,26 +95,54 @@ func (tfw *TFW) toGeoInfo() GeoInfo {
 	}
 }
 
+// EPSGGuess records how inferEPSG chose the CRS of a source without
+// GeoKeys, for the audit log and the --assume-epsg check.
+type EPSGGuess struct {
+	EPSG int
+	// Confident is false when the coordinates fit other CRSs as well; such
+	// a guess must be confirmed before tiles are generated from it.
+	Confident bool
+	Reason    string // the heuristic that matched
+}
+
 // inferEPSG guesses the EPSG code from the coordinate ranges.
 // Falls back to EPSG:4326 (WGS84) when coordinates look like geographic lon/lat.
-func inferEPSG(info GeoInfo, width, height uint32) int {
+//
+// Only two ranges are trusted: lon/lat with degree-sized pixels, and the
+// Swiss LV95 box, whose false easting and northing put it where no other
+// supported CRS has data. Everything else in meters is guessed as Web
+// Mercator with low confidence, since UTM and most national grids produce
+// the same magnitudes.
+func inferEPSG(info GeoInfo, width, height uint32) EPSGGuess {
 	maxX := info.OriginX + float64(width)*info.PixelSizeX
 	minY := info.OriginY - float64(height)*info.PixelSizeY
 
 	if info.OriginX >= -180 && maxX <= 360 &&
 		minY >= -90 && info.OriginY <= 90 {
-		return 4326
+		if info.PixelSizeX <= 1 && info.PixelSizeY <= 1 {
+			return EPSGGuess{EPSG: 4326, Confident: true,
+				Reason: "coordinates within lon/lat range, pixels of at most 1°"}
+		}
+		return EPSGGuess{EPSG: 4326,
+			Reason: fmt.Sprintf("coordinates within lon/lat range, but %g-unit pixels look like a projected grid near its origin", info.PixelSizeX)}
 	}
 
 	if math.Abs(info.OriginX) > 100000 || math.Abs(info.OriginY) > 100000 {
 		if info.OriginX >= 2400000 && info.OriginX <= 2900000 &&
 			info.OriginY >= 1000000 && info.OriginY <= 1400000 {
-			return 2056
+			return EPSGGuess{EPSG: 2056, Confident: true,
+				Reason: "coordinates within the Swiss LV95 range (E 2.4–2.9M, N 1.0–1.4M)"}
+		}
+		if info.OriginX >= 480000 && info.OriginX <= 840000 &&
+			info.OriginY >= 60000 && info.OriginY <= 300000 {
+			return EPSGGuess{EPSG: 3857,
+				Reason: "coordinates match Swiss LV03 (EPSG:21781), which is not supported; reproject to LV95 (EPSG:2056) first"}
 		}
 		if math.Abs(info.OriginX) <= 20037508.34 && math.Abs(info.OriginY) <= 20048966.10 {
-			return 3857
+			return EPSGGuess{EPSG: 3857,
+				Reason: "projected coordinates within the Web Mercator range, which UTM and national grids share"}
 		}
 	}
 
-	return 4326
+	return EPSGGuess{EPSG: 4326, Reason: "coordinates match no known CRS range"}
 }
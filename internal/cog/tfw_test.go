@@ -0,0 +1,25 @@
+package cog
+
+import "testing"
+
+func TestInferEPSG(t *testing.T) {
+	tests := []struct {
+		name      string
+		geo       GeoInfo
+		epsg      int
+		confident bool
+	}{
+		{"natural earth", GeoInfo{OriginX: -180, OriginY: 90, PixelSizeX: 0.1, PixelSizeY: 0.1}, 4326, true},
+		{"lon/lat range, meter pixels", GeoInfo{OriginX: 0, OriginY: 50, PixelSizeX: 2, PixelSizeY: 2}, 4326, false},
+		{"lv95", GeoInfo{OriginX: 2600000, OriginY: 1200000, PixelSizeX: 0.1, PixelSizeY: 0.1}, 2056, true},
+		{"lv03", GeoInfo{OriginX: 600000, OriginY: 200000, PixelSizeX: 0.1, PixelSizeY: 0.1}, 3857, false},
+		{"utm", GeoInfo{OriginX: 500000, OriginY: 5200000, PixelSizeX: 10, PixelSizeY: 10}, 3857, false},
+		{"out of range", GeoInfo{OriginX: 5e7, OriginY: 5e7, PixelSizeX: 1, PixelSizeY: 1}, 4326, false},
+	}
+	for _, tt := range tests {
+		g := inferEPSG(tt.geo, 100, 100)
+		if g.EPSG != tt.epsg || g.Confident != tt.confident || g.Reason == "" {
+			t.Errorf("%s: inferEPSG = %+v, want EPSG:%d confident=%v with a reason", tt.name, g, tt.epsg, tt.confident)
+		}
+	}
+}
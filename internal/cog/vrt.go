@@ -0,0 +1,211 @@
+package cog
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/hint"
+)
+
+// VRT is a GDAL virtual raster (.vrt) mosaic: a pixel grid with a geotransform
+// and a list of source rasters placed into it.
+type VRT struct {
+	Path    string
+	Width   int
+	Height  int
+	EPSG    int        // from the SRS element (code or WKT); coord.UserDefined for a WKT CRS given by parameters; 0 if absent or not recognized
+	GeoT    [6]float64 // GDAL geotransform of the VRT grid
+	NoData  string     // NoDataValue of the first band, "" if unset
+	Sources []VRTSource
+
+	crs *wktCRS // CRS read from a WKT SRS without a supported EPSG code
+}
+
+// VRTSource is one referenced file. Sources listed later are drawn on top of
+// earlier ones, as in GDAL.
+type VRTSource struct {
+	Path    string     // resolved file path
+	SrcRect [4]float64 // xoff, yoff, xsize, ysize in source pixels; zero if absent
+	DstRect [4]float64 // xoff, yoff, xsize, ysize in VRT pixels; zero if absent
+}
+
+type vrtXML struct {
+	RasterXSize  int    `xml:"rasterXSize,attr"`
+	RasterYSize  int    `xml:"rasterYSize,attr"`
+	SRS          string `xml:"SRS"`
+	GeoTransform string `xml:"GeoTransform"`
+	Bands        []struct {
+		Band        int            `xml:"band,attr"`
+		NoData      string         `xml:"NoDataValue"`
+		Simple      []vrtSourceXML `xml:"SimpleSource"`
+		Complex     []vrtSourceXML `xml:"ComplexSource"`
+		Averaged    []vrtSourceXML `xml:"AveragedSource"`
+		KernelFiltd []vrtSourceXML `xml:"KernelFilteredSource"`
+	} `xml:"VRTRasterBand"`
+}
+
+type vrtSourceXML struct {
+	Filename struct {
+		Relative int    `xml:"relativeToVRT,attr"`
+		Path     string `xml:",chardata"`
+	} `xml:"SourceFilename"`
+	SourceBand int         `xml:"SourceBand"`
+	SrcRect    *vrtRectXML `xml:"SrcRect"`
+	DstRect    *vrtRectXML `xml:"DstRect"`
+}
+
+type vrtRectXML struct {
+	XOff  float64 `xml:"xOff,attr"`
+	YOff  float64 `xml:"yOff,attr"`
+	XSize float64 `xml:"xSize,attr"`
+	YSize float64 `xml:"ySize,attr"`
+}
+
+func (r *vrtRectXML) array() [4]float64 {
+	if r == nil {
+		return [4]float64{}
+	}
+	return [4]float64{r.XOff, r.YOff, r.XSize, r.YSize}
+}
+
+// IsVRT reports whether path names a GDAL VRT file.
+func IsVRT(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".vrt")
+}
+
+// ParseVRT reads a VRT mosaic. Only simple and complex sources are
+// supported (complex-source scaling and LUTs are ignored); every band must
+// reference the same files, since each file is read as one multi-band
+// raster. Source paths with relativeToVRT="1" are resolved against the
+// VRT's directory.
+func ParseVRT(path string) (*VRT, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading VRT %s: %w", path, err)
+	}
+	var x vrtXML
+	if err := xml.Unmarshal(data, &x); err != nil {
+		return nil, fmt.Errorf("VRT %s: %w", path, err)
+	}
+
+	v := &VRT{Path: path, Width: x.RasterXSize, Height: x.RasterYSize, EPSG: epsgFromSRS(x.SRS)}
+	if v.EPSG == 0 {
+		// A WKT that cannot be used leaves the sources their own CRS.
+		if crs, err := crsFromWKT(embeddedWKT(x.SRS)); err == nil {
+			v.EPSG = crs.EPSG
+			if crs.Proj != nil {
+				v.crs = crs
+			}
+		}
+	}
+	fields := strings.Split(x.GeoTransform, ",")
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("VRT %s: missing or malformed GeoTransform", path)
+	}
+	for i, f := range fields {
+		if v.GeoT[i], err = strconv.ParseFloat(strings.TrimSpace(f), 64); err != nil {
+			return nil, fmt.Errorf("VRT %s: GeoTransform: %w", path, err)
+		}
+	}
+	if v.GeoT[2] != 0 || v.GeoT[4] != 0 {
+		return nil, hint.New(path, "rotated geotransforms are not supported", hint.GDALWarp(path, ""))
+	}
+	if len(x.Bands) == 0 {
+		return nil, fmt.Errorf("VRT %s: no VRTRasterBand", path)
+	}
+
+	var first []string
+	for bi, b := range x.Bands {
+		if len(b.Averaged) > 0 || len(b.KernelFiltd) > 0 {
+			return nil, hint.New(path, fmt.Sprintf("band %d: only SimpleSource and ComplexSource are supported", b.Band),
+				hint.GDALTranslate(path))
+		}
+		var files []string
+		for _, s := range append(b.Simple, b.Complex...) {
+			p := strings.TrimSpace(s.Filename.Path)
+			if strings.HasPrefix(p, "/vsi") {
+				return nil, hint.New(path, "GDAL virtual file system paths are not supported: "+p, hint.GDALTranslate(path))
+			}
+			if s.Filename.Relative == 1 && !filepath.IsAbs(p) {
+				p = filepath.Join(filepath.Dir(path), p)
+			}
+			files = append(files, p)
+			if bi == 0 {
+				v.Sources = append(v.Sources, VRTSource{Path: p, SrcRect: s.SrcRect.array(), DstRect: s.DstRect.array()})
+			}
+		}
+		if bi == 0 {
+			first = files
+			v.NoData = strings.TrimSpace(b.NoData)
+		} else if strings.Join(files, "\x00") != strings.Join(first, "\x00") {
+			return nil, fmt.Errorf("VRT %s: band %d references other files than band 1 "+
+				"(one file per band, e.g. gdalbuildvrt -separate, is not supported)", path, b.Band)
+		}
+	}
+	if len(v.Sources) == 0 {
+		return nil, fmt.Errorf("VRT %s: no sources", path)
+	}
+	return v, nil
+}
+
+var (
+	wkt1EPSG = regexp.MustCompile(`AUTHORITY\["EPSG",\s*"(\d+)"\]\s*\]\s*$`)
+	wkt2EPSG = regexp.MustCompile(`ID\["EPSG",\s*(\d+)\]\s*\]\s*$`)
+	codeEPSG = regexp.MustCompile(`^EPSG:(\d+)$`)
+)
+
+// epsgFromSRS returns the EPSG code of the outermost CRS in a VRT SRS
+// element (WKT1, WKT2, or "EPSG:n"), or 0.
+func epsgFromSRS(srs string) int {
+	srs = strings.TrimSpace(srs)
+	for _, re := range []*regexp.Regexp{wkt1EPSG, wkt2EPSG, codeEPSG} {
+		if m := re.FindStringSubmatch(srs); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			return n
+		}
+	}
+	return 0
+}
+
+// placeVRTSource georeferences r from its placement in v, replacing the
+// file's own georeferencing as GDAL does. The source window must cover the
+// whole file: cropping a source is not supported.
+func placeVRTSource(r *Reader, v *VRT, s VRTSource) error {
+	w, h := float64(r.Width()), float64(r.Height())
+	src := s.SrcRect
+	if src[2] == 0 || src[3] == 0 {
+		src = [4]float64{0, 0, w, h}
+	}
+	dst := s.DstRect
+	if dst[2] == 0 || dst[3] == 0 {
+		dst = src
+	}
+	if src[0] != 0 || src[1] != 0 || src[2] != w || src[3] != h {
+		return hint.New(v.Path, fmt.Sprintf("%s: SrcRect %v does not cover the whole %gx%g file (cropped sources are not supported)",
+			s.Path, src, w, h), hint.GDALTranslate(v.Path))
+	}
+
+	sx, sy := dst[2]/src[2], dst[3]/src[3]
+	geo := r.geo
+	geo.PixelSizeX = v.GeoT[1] * sx
+	geo.PixelSizeY = -v.GeoT[5] * sy
+	geo.OriginX = v.GeoT[0] + dst[0]*v.GeoT[1]
+	geo.OriginY = v.GeoT[3] + dst[1]*v.GeoT[5]
+	if v.EPSG != 0 {
+		geo.EPSG = v.EPSG
+		r.guess = nil
+		r.crs, r.crsErr = v.crs, nil
+	} else if r.guess != nil {
+		// Guess from the mosaic coordinates, not the file's own.
+		g := inferEPSG(geo, r.ifds[0].Width, r.ifds[0].Height)
+		geo.EPSG = g.EPSG
+		r.guess = &g
+	}
+	r.geo = geo
+	return nil
+}
@@ -0,0 +1,121 @@
+package cog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeVRT(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mosaic.vrt")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseVRT(t *testing.T) {
+	path := writeVRT(t, `<VRTDataset rasterXSize="200" rasterYSize="100">
+  <SRS dataAxisToSRSAxisMapping="1,2">PROJCS["CH1903+ / LV95",GEOGCS["CH1903+",AUTHORITY["EPSG","4150"]],AUTHORITY["EPSG","2056"]]</SRS>
+  <GeoTransform> 2.6e+06,  0.5,  0,  1.2e+06,  0, -0.5</GeoTransform>
+  <VRTRasterBand dataType="Byte" band="1">
+    <NoDataValue>0</NoDataValue>
+    <SimpleSource>
+      <SourceFilename relativeToVRT="1">tiles/a.tif</SourceFilename>
+      <SourceBand>1</SourceBand>
+      <SrcRect xOff="0" yOff="0" xSize="100" ySize="100"/>
+      <DstRect xOff="0" yOff="0" xSize="100" ySize="100"/>
+    </SimpleSource>
+    <ComplexSource>
+      <SourceFilename relativeToVRT="0">/data/b.tif</SourceFilename>
+      <SourceBand>1</SourceBand>
+      <DstRect xOff="100" yOff="0" xSize="100" ySize="100"/>
+    </ComplexSource>
+  </VRTRasterBand>
+  <VRTRasterBand dataType="Byte" band="2">
+    <SimpleSource><SourceFilename relativeToVRT="1">tiles/a.tif</SourceFilename><SourceBand>2</SourceBand></SimpleSource>
+    <ComplexSource><SourceFilename relativeToVRT="0">/data/b.tif</SourceFilename><SourceBand>2</SourceBand></ComplexSource>
+  </VRTRasterBand>
+</VRTDataset>`)
+
+	v, err := ParseVRT(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Width != 200 || v.Height != 100 || v.EPSG != 2056 || v.NoData != "0" {
+		t.Errorf("got %dx%d EPSG %d nodata %q, want 200x100 EPSG 2056 nodata \"0\"", v.Width, v.Height, v.EPSG, v.NoData)
+	}
+	if v.GeoT != [6]float64{2.6e6, 0.5, 0, 1.2e6, 0, -0.5} {
+		t.Errorf("GeoT = %v", v.GeoT)
+	}
+	want := []VRTSource{
+		{Path: filepath.Join(filepath.Dir(path), "tiles/a.tif"), SrcRect: [4]float64{0, 0, 100, 100}, DstRect: [4]float64{0, 0, 100, 100}},
+		{Path: "/data/b.tif", DstRect: [4]float64{100, 0, 100, 100}},
+	}
+	if len(v.Sources) != len(want) {
+		t.Fatalf("got %d sources, want %d", len(v.Sources), len(want))
+	}
+	for i := range want {
+		if v.Sources[i] != want[i] {
+			t.Errorf("source %d = %+v, want %+v", i, v.Sources[i], want[i])
+		}
+	}
+}
+
+func TestParseVRT_Unsupported(t *testing.T) {
+	const geo = `<GeoTransform>0, 1, 0, 0, 0, -1</GeoTransform>`
+	src := func(name string) string {
+		return `<SimpleSource><SourceFilename relativeToVRT="1">` + name + `</SourceFilename></SimpleSource>`
+	}
+	for _, c := range []struct {
+		name, body, want string
+	}{
+		{"rotated", `<VRTDataset><GeoTransform>0, 1, 0.1, 0, 0, -1</GeoTransform><VRTRasterBand band="1">` + src("a.tif") + `</VRTRasterBand></VRTDataset>`, "rotated"},
+		{"no geotransform", `<VRTDataset><VRTRasterBand band="1">` + src("a.tif") + `</VRTRasterBand></VRTDataset>`, "GeoTransform"},
+		{"separate", `<VRTDataset>` + geo + `<VRTRasterBand band="1">` + src("r.tif") + `</VRTRasterBand><VRTRasterBand band="2">` + src("g.tif") + `</VRTRasterBand></VRTDataset>`, "-separate"},
+		{"averaged", `<VRTDataset>` + geo + `<VRTRasterBand band="1"><AveragedSource/></VRTRasterBand></VRTDataset>`, "SimpleSource"},
+		{"vsi", `<VRTDataset>` + geo + `<VRTRasterBand band="1">` + src("/vsicurl/https://x/a.tif") + `</VRTRasterBand></VRTDataset>`, "virtual file system"},
+		{"empty", `<VRTDataset>` + geo + `<VRTRasterBand band="1"/></VRTDataset>`, "no sources"},
+	} {
+		_, err := ParseVRT(writeVRT(t, c.body))
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: err = %v, want it to mention %q", c.name, err, c.want)
+		}
+	}
+}
+
+func TestEPSGFromSRS(t *testing.T) {
+	for srs, want := range map[string]int{
+		`GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]]],AUTHORITY["EPSG","4326"]]`: 4326,
+		`PROJCRS["WGS 84 / Pseudo-Mercator",BASEGEOGCRS["WGS 84",ID["EPSG",4326]],ID["EPSG",3857]]`:                                     3857,
+		`EPSG:2056`:           2056,
+		`LOCAL_CS["unnamed"]`: 0,
+		``:                    0,
+	} {
+		if got := epsgFromSRS(srs); got != want {
+			t.Errorf("epsgFromSRS(%.40q) = %d, want %d", srs, got, want)
+		}
+	}
+}
+
+func TestPlaceVRTSource(t *testing.T) {
+	r := orientedTestReader(orientTopLeft, false) // 3×3
+	v := &VRT{Path: "m.vrt", EPSG: 2056, GeoT: [6]float64{1000, 2, 0, 5000, 0, -2}}
+
+	// Placed at VRT pixel (10, 4), stretched to 6×6: pixels are twice as large.
+	s := VRTSource{Path: "a.tif", SrcRect: [4]float64{0, 0, 3, 3}, DstRect: [4]float64{10, 4, 6, 6}}
+	if err := placeVRTSource(r, v, s); err != nil {
+		t.Fatal(err)
+	}
+	want := GeoInfo{EPSG: 2056, OriginX: 1020, OriginY: 4992, PixelSizeX: 4, PixelSizeY: 4}
+	if r.GeoInfo() != want {
+		t.Errorf("GeoInfo = %+v, want %+v", r.GeoInfo(), want)
+	}
+
+	s.SrcRect = [4]float64{1, 0, 2, 3}
+	if err := placeVRTSource(r, v, s); err == nil || !strings.Contains(err.Error(), "cropped") {
+		t.Errorf("cropped source: err = %v, want an error", err)
+	}
+}
@@ -0,0 +1,517 @@
+package cog
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+)
+
+// wktCRS is a CRS read from a WKT definition, e.g. the one GDAL and ESRI
+// write into GeoAsciiParams for a user-defined (32767) CRS.
+type wktCRS struct {
+	Name string // name of the CRS element
+	WKT  string // the definition
+	// EPSG is the supported EPSG code the definition names or is equal to,
+	// or coord.UserDefined when Proj is built from its parameters.
+	EPSG int
+	Proj coord.Projection
+}
+
+// wktRoots are the keywords that start a CRS definition.
+var wktRoots = []string{
+	"PROJCS[", "PROJCRS[", "PROJECTEDCRS[", "GEOGCS[", "GEOGCRS[", "GEOGRAPHICCRS[",
+	"GEODCRS[", "GEODETICCRS[", "COMPD_CS[", "COMPOUNDCRS[", "BOUNDCRS[",
+}
+
+// embeddedWKT returns the CRS definition in s, e.g. after the citation
+// prefix "ESRI PE String = " in GeoAsciiParams, or "" if there is none.
+func embeddedWKT(s string) string {
+	start := -1
+	for _, k := range wktRoots {
+		if i := strings.Index(s, k); i >= 0 && (start < 0 || i < start) {
+			start = i
+		}
+	}
+	if start < 0 {
+		return ""
+	}
+	return strings.TrimRight(s[start:], "|\x00 \n")
+}
+
+// crsFromWKT reads a CRS from OGC WKT1, ESRI WKT or WKT2. A definition
+// that carries a supported EPSG code, or is geographic on a WGS84-
+// compatible datum, maps to that code. Otherwise the projection is built
+// from the method and parameters: Transverse Mercator, Lambert Conformal
+// Conic, Albers and Lambert Azimuthal Equal Area, on the same datums as the
+// EPSG table (see coord/epsg.go), in metres. Pseudo-Mercator and CH1903+ /
+// LV95 definitions map to 3857 and 2056.
+func crsFromWKT(wkt string) (*wktCRS, error) {
+	root, err := parseWKT(wkt)
+	if err != nil {
+		return nil, err
+	}
+	root = horizontalCRS(root)
+	if root == nil {
+		return nil, errors.New("no horizontal CRS in WKT")
+	}
+	crs := &wktCRS{Name: root.name(), WKT: wkt}
+	if code := root.epsgAuthority(); code != 0 && coord.ForEPSG(code) != nil {
+		crs.EPSG = code
+		return crs, nil
+	}
+
+	datum := root.find("DATUM", "GEODETICDATUM", "TRF", "ENSEMBLE")
+	if datum == nil {
+		return crs, fmt.Errorf("%q has no datum", crs.Name)
+	}
+	datumName := normalizeWKTName(datum.name())
+	datumOK := wgs84CompatibleDatum(datumName)
+	if t := datum.child("TOWGS84"); t != nil {
+		// An explicit shift decides: translations of at most 2 m are
+		// used without a shift, like the datums above.
+		datumOK = true
+		for i := 0; i < 3 && i < len(t.Args); i++ {
+			if v, _ := strconv.ParseFloat(t.Args[i], 64); math.Abs(v) > 2 {
+				datumOK = false
+			}
+		}
+	}
+	el, err := root.ellipsoid()
+	if err != nil {
+		return crs, err
+	}
+
+	switch root.Keyword {
+	case "GEOGCS", "GEOGCRS", "GEOGRAPHICCRS", "GEODCRS", "GEODETICCRS":
+		if !datumOK {
+			return crs, unsupportedDatum(datum.name())
+		}
+		if u := root.child("UNIT", "ANGLEUNIT"); u != nil && !wktNear(u.num(1), math.Pi/180) {
+			return crs, fmt.Errorf("angular unit %q is not supported (only degrees)", u.name())
+		}
+		crs.EPSG = 4326
+		return crs, nil
+	}
+
+	// Projected: WKT1 has PROJECTION and PARAMETERs at the top level, WKT2
+	// a CONVERSION with METHOD and PARAMETERs.
+	params, method := root, root.child("PROJECTION")
+	if conv := root.child("CONVERSION"); conv != nil {
+		params, method = conv, conv.child("METHOD", "PROJECTION")
+	}
+	if method == nil {
+		return crs, fmt.Errorf("%q has no projection method", crs.Name)
+	}
+	if u := root.linearUnit(); u != nil && !wktNear(u.num(1), 1) {
+		return crs, fmt.Errorf("linear unit %q is not supported (only metres)", u.name())
+	}
+	angle := 1.0 // WKT1 angles are in the unit of the geographic CRS
+	if g := root.child("GEOGCS"); g != nil {
+		if u := g.child("UNIT"); u != nil && u.num(1) > 0 {
+			angle = u.num(1) * 180 / math.Pi
+		}
+	}
+	p := wktParameters(params, angle)
+	get := func(key string, def float64) float64 {
+		if v, ok := p[key]; ok {
+			return v
+		}
+		return def
+	}
+	lat0, lon0, k0 := get("lat0", 0), get("lon0", 0), get("k0", 1)
+	fe, fn := get("fe", 0), get("fn", 0)
+
+	m := normalizeWKTName(method.name())
+	switch m {
+	case "popularvisualisationpseudomercator", "mercatorauxiliarysphere", "pseudomercator":
+		if lon0 != 0 || fe != 0 || fn != 0 {
+			return crs, fmt.Errorf("%q: Pseudo-Mercator with a false origin is not supported", crs.Name)
+		}
+		crs.EPSG = 3857
+		return crs, nil
+	case "hotineobliquemercator", "hotineobliquemercatorazimuthcenter", "hotineobliquemercatorvariantb",
+		"obliquemercator", "swissobliquecylindrical", "swissobliquemercator":
+		// Only Swiss LV95, which the built-in projection covers with its datum.
+		if strings.HasPrefix(datumName, "ch1903+") && wktNear(fe, 2600000) && wktNear(fn, 1200000) {
+			crs.EPSG = 2056
+			return crs, nil
+		}
+		return crs, fmt.Errorf("%q: oblique Mercator is only supported as CH1903+ / LV95", crs.Name)
+	}
+	if !datumOK {
+		return crs, unsupportedDatum(datum.name())
+	}
+	if el.InvF == 0 {
+		return crs, fmt.Errorf("%q: projections on a sphere are not supported", crs.Name)
+	}
+
+	code := coord.UserDefined
+	switch m {
+	case "transversemercator", "gausskruger":
+		crs.Proj = coord.NewTransverseMercator(code, el, lat0, lon0, k0, fe, fn)
+	case "lambertconformalconic", "lambertconformalconic1sp", "lambertconformalconic2sp",
+		"lambertconicconformal1sp", "lambertconicconformal2sp":
+		// 1SP definitions (and ESRI's with one standard parallel) are the
+		// tangent cone at that parallel, which only matches 2SP without scale.
+		lat1, ok1 := p["lat1"]
+		if !ok1 {
+			lat1 = lat0
+		}
+		lat2, ok2 := p["lat2"]
+		if !ok2 {
+			lat2 = lat1
+		}
+		if !wktNear(k0, 1) {
+			return crs, fmt.Errorf("%q: Lambert Conformal Conic with scale factor %g is not supported", crs.Name, k0)
+		}
+		crs.Proj = coord.NewLambertConformalConic(code, el, lat0, lon0, lat1, lat2, fe, fn)
+	case "albersequalarea", "albersconicequalarea", "albers":
+		crs.Proj = coord.NewAlbersEqualArea(code, el, lat0, lon0, get("lat1", lat0), get("lat2", lat0), fe, fn)
+	case "lambertazimuthalequalarea":
+		crs.Proj = coord.NewLambertAzimuthalEqualArea(code, el, lat0, lon0, fe, fn)
+	default:
+		return crs, fmt.Errorf("%q: projection method %q is not supported", crs.Name, method.name())
+	}
+	crs.EPSG = code
+	return crs, nil
+}
+
+func unsupportedDatum(name string) error {
+	return fmt.Errorf("datum %q is not supported (only datums within about 2 m of WGS84: "+
+		"WGS 84, ETRS89, NAD83, GDA94/GDA2020, NZGD2000, RGF93, SWEREF99, ITRF)", name)
+}
+
+// wgs84Datums are the normalized name prefixes of the datums used without a
+// shift, the same ones as in the EPSG table.
+var wgs84Datums = []string{
+	"wgs84", "wgs1984", "worldgeodeticsystem1984",
+	"etrs89", "etrs1989", "europeanterrestrialreferencesystem1989", "europeanterrestrialreferenceframe",
+	"nad83", "nad1983", "northamericandatum1983", "northamerican1983",
+	"gda94", "gda1994", "gda2020", "geocentricdatumofaustralia",
+	"nzgd2000", "newzealandgeodeticdatum2000",
+	"rgf93", "rgf1993", "reseaugeodesiquefrancais1993",
+	"sweref99", "swedishreferenceframe1999",
+	"itrf", "internationalterrestrialreferenceframe",
+}
+
+func wgs84CompatibleDatum(name string) bool {
+	for _, d := range wgs84Datums {
+		if strings.HasPrefix(name, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// wktParamKeys maps normalized WKT1, ESRI and WKT2 parameter names to the
+// keys used by crsFromWKT.
+var wktParamKeys = map[string]string{
+	"latitudeoforigin":              "lat0",
+	"latitudeofnaturalorigin":       "lat0",
+	"latitudeoffalseorigin":         "lat0",
+	"latitudeofcenter":              "lat0",
+	"latitudeofcentre":              "lat0",
+	"latitudeofprojectioncenter":    "lat0",
+	"latitudeofprojectioncentre":    "lat0",
+	"centralmeridian":               "lon0",
+	"longitudeoforigin":             "lon0",
+	"longitudeofnaturalorigin":      "lon0",
+	"longitudeoffalseorigin":        "lon0",
+	"longitudeofcenter":             "lon0",
+	"longitudeofcentre":             "lon0",
+	"longitudeofprojectioncenter":   "lon0",
+	"longitudeofprojectioncentre":   "lon0",
+	"scalefactor":                   "k0",
+	"scalefactoratnaturalorigin":    "k0",
+	"scalefactoroninitialline":      "k0",
+	"falseeasting":                  "fe",
+	"eastingatfalseorigin":          "fe",
+	"eastingatprojectioncentre":     "fe",
+	"falsenorthing":                 "fn",
+	"northingatfalseorigin":         "fn",
+	"northingatprojectioncentre":    "fn",
+	"standardparallel1":             "lat1",
+	"latitudeof1ststandardparallel": "lat1",
+	"standardparallel2":             "lat2",
+	"latitudeof2ndstandardparallel": "lat2",
+}
+
+// wktParameters returns the known PARAMETERs of n in degrees and metres.
+// WKT2 parameters carry their unit; WKT1 angles are multiplied by angle.
+func wktParameters(n *wktNode, angle float64) map[string]float64 {
+	p := make(map[string]float64)
+	for _, c := range n.Children {
+		key, ok := wktParamKeys[normalizeWKTName(c.name())]
+		if c.Keyword != "PARAMETER" || !ok || len(c.Args) < 2 {
+			continue
+		}
+		v := c.num(1)
+		switch {
+		case c.child("ANGLEUNIT") != nil:
+			v *= c.child("ANGLEUNIT").num(1) * 180 / math.Pi
+		case c.child("LENGTHUNIT") != nil:
+			v *= c.child("LENGTHUNIT").num(1)
+		case key != "k0" && key != "fe" && key != "fn":
+			v *= angle
+		}
+		p[key] = v
+	}
+	return p
+}
+
+// normalizeWKTName lowercases name and keeps letters, digits and "+",
+// dropping ESRI's "D_" datum prefix: "D_ETRS_1989" becomes "etrs1989".
+func normalizeWKTName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.TrimPrefix(name, "d_")
+	var b strings.Builder
+	for _, c := range name {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '+' {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func wktNear(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Abs(b))
+}
+
+// wktNode is one KEYWORD[...] element of a WKT string. Args holds the
+// quoted strings, numbers and bare enums in order; nested elements are in
+// Children.
+type wktNode struct {
+	Keyword  string
+	Args     []string
+	Children []*wktNode
+}
+
+func (n *wktNode) name() string {
+	if n == nil || len(n.Args) == 0 {
+		return ""
+	}
+	return n.Args[0]
+}
+
+// num returns argument i as a number, or 0.
+func (n *wktNode) num(i int) float64 {
+	if n == nil || i >= len(n.Args) {
+		return 0
+	}
+	v, _ := strconv.ParseFloat(n.Args[i], 64)
+	return v
+}
+
+// child returns the first direct child with one of the keywords.
+func (n *wktNode) child(keywords ...string) *wktNode {
+	for _, c := range n.Children {
+		for _, k := range keywords {
+			if c.Keyword == k {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// find returns the first element with one of the keywords, depth first.
+func (n *wktNode) find(keywords ...string) *wktNode {
+	if c := n.child(keywords...); c != nil {
+		return c
+	}
+	for _, c := range n.Children {
+		if f := c.find(keywords...); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// epsgAuthority returns the EPSG code of the element's own AUTHORITY (WKT1)
+// or ID (WKT2), or 0.
+func (n *wktNode) epsgAuthority() int {
+	for _, c := range n.Children {
+		if (c.Keyword == "AUTHORITY" || c.Keyword == "ID") && len(c.Args) >= 2 && strings.EqualFold(c.Args[0], "EPSG") {
+			code, _ := strconv.Atoi(c.Args[1])
+			return code
+		}
+	}
+	return 0
+}
+
+// ellipsoid returns the SPHEROID (WKT1) or ELLIPSOID (WKT2) of a CRS.
+func (n *wktNode) ellipsoid() (coord.Ellipsoid, error) {
+	s := n.find("SPHEROID", "ELLIPSOID")
+	if s == nil || s.num(1) <= 0 {
+		return coord.Ellipsoid{}, fmt.Errorf("%q has no ellipsoid", n.name())
+	}
+	a := s.num(1)
+	if u := s.child("LENGTHUNIT"); u != nil {
+		a *= u.num(1)
+	}
+	return coord.Ellipsoid{A: a, InvF: s.num(2)}, nil
+}
+
+// linearUnit returns the unit of a projected CRS: its UNIT (WKT1), its
+// LENGTHUNIT (WKT2), or that of its first axis.
+func (n *wktNode) linearUnit() *wktNode {
+	if u := n.child("UNIT", "LENGTHUNIT"); u != nil {
+		return u
+	}
+	if cs := n.child("CS"); cs != nil {
+		if u := cs.find("LENGTHUNIT", "UNIT"); u != nil {
+			return u
+		}
+	}
+	if axis := n.child("AXIS"); axis != nil {
+		return axis.child("LENGTHUNIT", "UNIT")
+	}
+	return nil
+}
+
+// horizontalCRS unwraps compound and bound CRSs to their horizontal part.
+func horizontalCRS(n *wktNode) *wktNode {
+	switch n.Keyword {
+	case "COMPD_CS", "COMPOUNDCRS":
+		for _, c := range n.Children {
+			if h := horizontalCRS(c); h != nil {
+				return h
+			}
+		}
+		return nil
+	case "BOUNDCRS":
+		if src := n.child("SOURCECRS"); src != nil && len(src.Children) > 0 {
+			return horizontalCRS(src.Children[0])
+		}
+		return nil
+	case "PROJCS", "PROJCRS", "PROJECTEDCRS", "GEOGCS", "GEOGCRS", "GEOGRAPHICCRS", "GEODCRS", "GEODETICCRS":
+		return n
+	}
+	return nil
+}
+
+// maxWKTDepth bounds the nesting of a WKT string.
+const maxWKTDepth = 32
+
+// parseWKT parses the first element of s. Brackets may be [] or (); text
+// after the element is ignored.
+func parseWKT(s string) (*wktNode, error) {
+	p := wktParser{s: s}
+	return p.node(0)
+}
+
+type wktParser struct {
+	s   string
+	pos int
+}
+
+func (p *wktParser) skipSpace() {
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *wktParser) word() string {
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_') {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *wktParser) node(depth int) (*wktNode, error) {
+	if depth > maxWKTDepth {
+		return nil, errors.New("WKT: nested too deeply")
+	}
+	p.skipSpace()
+	kw := p.word()
+	if kw == "" {
+		return nil, fmt.Errorf("WKT: keyword expected at offset %d", p.pos)
+	}
+	n := &wktNode{Keyword: strings.ToUpper(kw)}
+	p.skipSpace()
+	if p.pos >= len(p.s) || (p.s[p.pos] != '[' && p.s[p.pos] != '(') {
+		return nil, fmt.Errorf("WKT: '[' expected after %s", kw)
+	}
+	p.pos++
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.s) {
+			return nil, fmt.Errorf("WKT: unterminated %s", kw)
+		}
+		switch c := p.s[p.pos]; {
+		case c == '"':
+			str, err := p.quoted()
+			if err != nil {
+				return nil, err
+			}
+			n.Args = append(n.Args, str)
+		case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			// A nested element, or a bare enum such as NORTH.
+			start := p.pos
+			w := p.word()
+			p.skipSpace()
+			if p.pos < len(p.s) && (p.s[p.pos] == '[' || p.s[p.pos] == '(') {
+				p.pos = start
+				child, err := p.node(depth + 1)
+				if err != nil {
+					return nil, err
+				}
+				n.Children = append(n.Children, child)
+			} else {
+				n.Args = append(n.Args, w)
+			}
+		default:
+			start := p.pos
+			for p.pos < len(p.s) && strings.IndexByte(",])( \t\r\n", p.s[p.pos]) < 0 {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, fmt.Errorf("WKT: unexpected %q at offset %d", c, p.pos)
+			}
+			n.Args = append(n.Args, p.s[start:p.pos])
+		}
+		p.skipSpace()
+		if p.pos >= len(p.s) {
+			return nil, fmt.Errorf("WKT: unterminated %s", kw)
+		}
+		switch p.s[p.pos] {
+		case ',':
+			p.pos++
+		case ']', ')':
+			p.pos++
+			return n, nil
+		default:
+			return nil, fmt.Errorf("WKT: unexpected %q at offset %d", p.s[p.pos], p.pos)
+		}
+	}
+}
+
+// quoted reads a quoted string; "" inside it is a literal quote.
+func (p *wktParser) quoted() (string, error) {
+	var b strings.Builder
+	p.pos++ // opening quote
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		if c != '"' {
+			b.WriteByte(c)
+			continue
+		}
+		if p.pos < len(p.s) && p.s[p.pos] == '"' {
+			b.WriteByte('"')
+			p.pos++
+			continue
+		}
+		return b.String(), nil
+	}
+	return "", errors.New("WKT: unterminated string")
+}
@@ -0,0 +1,137 @@
+package cog
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+)
+
+func TestCRSFromWKT(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		wkt   string
+		like  int // EPSG code the projection must agree with
+		epsg  int // expected code; coord.UserDefined for a built projection
+		lon   float64
+		lat   float64
+		label string
+	}{
+		{
+			name: "WKT1 LAEA without authority",
+			wkt: `PROJCS["ETRS89-extended / LAEA Europe",GEOGCS["ETRS89",DATUM["European_Terrestrial_Reference_System_1989",` +
+				`SPHEROID["GRS 1980",6378137,298.257222101]],PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],` +
+				`PROJECTION["Lambert_Azimuthal_Equal_Area"],PARAMETER["latitude_of_center",52],PARAMETER["longitude_of_center",10],` +
+				`PARAMETER["false_easting",4321000],PARAMETER["false_northing",3210000],UNIT["metre",1],AXIS["Northing",NORTH],AXIS["Easting",EAST]]`,
+			like: 3035, epsg: coord.UserDefined, lon: 8.5, lat: 47.4,
+			label: "ETRS89-extended / LAEA Europe",
+		},
+		{
+			name: "WKT2 LCC without ID",
+			wkt: `PROJCRS["RGF93 v1 / Lambert-93",BASEGEOGCRS["RGF93 v1",DATUM["Reseau Geodesique Francais 1993 v1",` +
+				`ELLIPSOID["GRS 1980",6378137,298.257222101,LENGTHUNIT["metre",1]]],PRIMEM["Greenwich",0,ANGLEUNIT["degree",0.0174532925199433]]],` +
+				`CONVERSION["Lambert-93",METHOD["Lambert Conic Conformal (2SP)"],` +
+				`PARAMETER["Latitude of false origin",46.5,ANGLEUNIT["degree",0.0174532925199433]],` +
+				`PARAMETER["Longitude of false origin",3,ANGLEUNIT["degree",0.0174532925199433]],` +
+				`PARAMETER["Latitude of 1st standard parallel",49,ANGLEUNIT["degree",0.0174532925199433]],` +
+				`PARAMETER["Latitude of 2nd standard parallel",44,ANGLEUNIT["degree",0.0174532925199433]],` +
+				`PARAMETER["Easting at false origin",700,LENGTHUNIT["kilometre",1000]],` +
+				`PARAMETER["Northing at false origin",6600000,LENGTHUNIT["metre",1]]],` +
+				`CS[Cartesian,2],AXIS["easting (X)",east,ORDER[1],LENGTHUNIT["metre",1]],AXIS["northing (Y)",north,ORDER[2],LENGTHUNIT["metre",1]]]`,
+			like: 2154, epsg: coord.UserDefined, lon: 2.35, lat: 48.85,
+			label: "RGF93 v1 / Lambert-93",
+		},
+		{
+			name: "ESRI Albers",
+			wkt: `PROJCS["NAD_1983_Contiguous_USA_Albers",GEOGCS["GCS_North_American_1983",DATUM["D_North_American_1983",` +
+				`SPHEROID["GRS_1980",6378137.0,298.257222101]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]],` +
+				`PROJECTION["Albers"],PARAMETER["False_Easting",0.0],PARAMETER["False_Northing",0.0],PARAMETER["Central_Meridian",-96.0],` +
+				`PARAMETER["Standard_Parallel_1",29.5],PARAMETER["Standard_Parallel_2",45.5],PARAMETER["Latitude_Of_Origin",23.0],UNIT["Meter",1.0]]`,
+			like: 5070, epsg: coord.UserDefined, lon: -122.7, lat: 45.5,
+			label: "NAD_1983_Contiguous_USA_Albers",
+		},
+		{
+			name: "authority code",
+			wkt: `PROJCS["WGS 84 / UTM zone 33N",GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563]],` +
+				`UNIT["degree",0.0174532925199433]],PROJECTION["Transverse_Mercator"],UNIT["metre",1],AUTHORITY["EPSG","32633"]]`,
+			like: 32633, epsg: 32633, lon: 15, lat: 50,
+			label: "WGS 84 / UTM zone 33N",
+		},
+		{
+			name: "geographic ETRS89",
+			wkt:  `GEOGCS["ETRS89",DATUM["European_Terrestrial_Reference_System_1989",SPHEROID["GRS 1980",6378137,298.257222101]],UNIT["degree",0.0174532925199433]]`,
+			like: 4326, epsg: 4326, lon: 7, lat: 46,
+			label: "ETRS89",
+		},
+		{
+			name: "ESRI CH1903+ LV95",
+			wkt: `PROJCS["CH1903+_LV95",GEOGCS["GCS_CH1903+",DATUM["D_CH1903+",SPHEROID["Bessel_1841",6377397.155,299.1528128]],` +
+				`UNIT["Degree",0.0174532925199433]],PROJECTION["Hotine_Oblique_Mercator_Azimuth_Center"],PARAMETER["False_Easting",2600000.0],` +
+				`PARAMETER["False_Northing",1200000.0],PARAMETER["Scale_Factor",1.0],PARAMETER["Azimuth",90.0],` +
+				`PARAMETER["Longitude_Of_Center",7.439583333333333],PARAMETER["Latitude_Of_Center",46.95240555555556],UNIT["Meter",1.0]]`,
+			like: 2056, epsg: 2056, lon: 8.54, lat: 47.37,
+			label: "CH1903+_LV95",
+		},
+	} {
+		crs, err := crsFromWKT(tc.wkt)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if crs.EPSG != tc.epsg || crs.Name != tc.label {
+			t.Errorf("%s: EPSG %d, name %q; want %d, %q", tc.name, crs.EPSG, crs.Name, tc.epsg, tc.label)
+			continue
+		}
+		proj := crs.Proj
+		if proj == nil {
+			proj = coord.ForEPSG(crs.EPSG)
+		}
+		wx, wy := coord.ForEPSG(tc.like).FromWGS84(tc.lon, tc.lat)
+		x, y := proj.FromWGS84(tc.lon, tc.lat)
+		if math.Abs(x-wx) > 1e-3 || math.Abs(y-wy) > 1e-3 {
+			t.Errorf("%s: (%g, %g) -> (%.3f, %.3f), want (%.3f, %.3f) as EPSG:%d", tc.name, tc.lon, tc.lat, x, y, wx, wy, tc.like)
+		}
+	}
+}
+
+func TestCRSFromWKTUnsupported(t *testing.T) {
+	for _, tc := range []struct {
+		wkt, want string
+	}{
+		{
+			`PROJCS["DHDN / 3-degree Gauss-Kruger zone 3",GEOGCS["DHDN",DATUM["Deutsches_Hauptdreiecksnetz",` +
+				`SPHEROID["Bessel 1841",6377397.155,299.1528128],TOWGS84[598.1,73.7,418.2,0.202,0.045,-2.455,6.7]],` +
+				`UNIT["degree",0.0174532925199433]],PROJECTION["Transverse_Mercator"],PARAMETER["central_meridian",9],` +
+				`PARAMETER["scale_factor",1],PARAMETER["false_easting",3500000],UNIT["metre",1]]`,
+			`datum "Deutsches_Hauptdreiecksnetz"`,
+		},
+		{
+			`PROJCS["NAD83 / Texas Central (ftUS)",GEOGCS["NAD83",DATUM["North_American_Datum_1983",SPHEROID["GRS 1980",6378137,298.257222101]],` +
+				`UNIT["degree",0.0174532925199433]],PROJECTION["Lambert_Conformal_Conic_2SP"],UNIT["US survey foot",0.304800609601219]]`,
+			`linear unit "US survey foot"`,
+		},
+		{
+			`PROJCS["custom",GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563]]],PROJECTION["Polyconic"],UNIT["metre",1]]`,
+			`method "Polyconic"`,
+		},
+		{`PROJCS["broken",GEOGCS[`, "unterminated"},
+	} {
+		if _, err := crsFromWKT(tc.wkt); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("crsFromWKT(%.40s...) error = %v, want one containing %q", tc.wkt, err, tc.want)
+		}
+	}
+}
+
+func TestEmbeddedWKT(t *testing.T) {
+	const wkt = `PROJCS["x",GEOGCS["y"]]`
+	for in, want := range map[string]string{
+		"ESRI PE String = " + wkt + "|":            wkt,
+		"WGS 84 / UTM zone 32N|":                   "",
+		"IMAGINE GeoTIFF Support|" + wkt + "|\x00": wkt,
+	} {
+		if got := embeddedWKT(in); got != want {
+			t.Errorf("embeddedWKT(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
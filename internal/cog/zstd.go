@@ -0,0 +1,871 @@
+package cog
+
+// Zstandard decoder for TIFF compression 50000.
+//
+// GDAL writes ZSTD tiles as single frames without a dictionary, one per
+// tile or strip. This implementation follows RFC 8878 and decodes such
+// frames into memory: the whole tile is the window, so matches may reach
+// back to its first byte. Dictionaries are not supported and the optional
+// content checksum is skipped.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+const (
+	zstdMagic         = 0xFD2FB528
+	zstdSkippableMask = 0xFFFFFFF0
+	zstdSkippableBase = 0x184D2A50
+	zstdMaxBlockSize  = 128 << 10
+	zstdMaxWindowLog  = 31
+	zstdMaxHuffBits   = 11
+	zstdMaxLLSymbol   = 35
+	zstdMaxMLSymbol   = 52
+	zstdMaxOFSymbol   = 31
+	zstdMaxLLLog      = 9
+	zstdMaxMLLog      = 9
+	zstdMaxOFLog      = 8
+)
+
+var errZstdCorrupt = errors.New("zstd: corrupt input")
+
+// Literals length and match length codes: baseline value and number of
+// extra bits (RFC 8878, section 3.1.1.3.2.1.1).
+var (
+	zstdLLBase = [zstdMaxLLSymbol + 1]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	zstdLLBits = [zstdMaxLLSymbol + 1]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	zstdMLBase = [zstdMaxMLSymbol + 1]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	zstdMLBits = [zstdMaxMLSymbol + 1]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+// Predefined FSE distributions (RFC 8878, section 3.1.1.3.2.2).
+var (
+	zstdLLDefault = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	zstdMLDefault = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	zstdOFDefault = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+)
+
+// decompressZSTD decompresses one or more concatenated Zstandard frames,
+// skipping skippable frames.
+func decompressZSTD(data []byte) ([]byte, error) {
+	var out []byte
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("zstd: %d trailing bytes", len(data))
+		}
+		magic := binary.LittleEndian.Uint32(data)
+		if magic&zstdSkippableMask == zstdSkippableBase {
+			if len(data) < 8 {
+				return nil, errZstdCorrupt
+			}
+			n := uint64(binary.LittleEndian.Uint32(data[4:]))
+			if n > uint64(len(data)-8) {
+				return nil, errZstdCorrupt
+			}
+			data = data[8+n:]
+			continue
+		}
+		if magic != zstdMagic {
+			return nil, fmt.Errorf("zstd: bad magic number %#08x", magic)
+		}
+		var d zstdFrame
+		var err error
+		out, data, err = d.decode(out, data[4:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// zstdFrame holds the state that carries over between the blocks of a
+// frame: the repeat offsets and the tables of the previous block, for the
+// "repeat" and "treeless" modes.
+type zstdFrame struct {
+	start int // start of the frame's output
+	rep   [3]int
+
+	huff      zstdHuffTable
+	hasHuff   bool
+	ll, of, m zstdFSETable
+	hasFSE    [3]bool
+
+	literals []byte
+}
+
+// decode decodes the frame at data, after the magic number, appending to
+// out, and returns the bytes that follow it.
+func (d *zstdFrame) decode(out, data []byte) ([]byte, []byte, error) {
+	if len(data) < 1 {
+		return nil, nil, errZstdCorrupt
+	}
+	desc := data[0]
+	data = data[1:]
+	if desc&0x08 != 0 {
+		return nil, nil, errors.New("zstd: reserved frame header bit set")
+	}
+	singleSegment := desc&0x20 != 0
+	hasChecksum := desc&0x04 != 0
+
+	if !singleSegment {
+		if len(data) < 1 {
+			return nil, nil, errZstdCorrupt
+		}
+		if windowLog := 10 + int(data[0]>>3); windowLog > zstdMaxWindowLog {
+			return nil, nil, fmt.Errorf("zstd: window log %d too large", windowLog)
+		}
+		data = data[1:]
+	}
+
+	dictIDSize := [4]int{0, 1, 2, 4}[desc&3]
+	if len(data) < dictIDSize {
+		return nil, nil, errZstdCorrupt
+	}
+	var dictID uint32
+	for i := 0; i < dictIDSize; i++ {
+		dictID |= uint32(data[i]) << (8 * i)
+	}
+	if dictID != 0 {
+		return nil, nil, fmt.Errorf("zstd: dictionary %d is not supported", dictID)
+	}
+	data = data[dictIDSize:]
+
+	fcsSize := [4]int{0, 2, 4, 8}[desc>>6]
+	if fcsSize == 0 && singleSegment {
+		fcsSize = 1
+	}
+	if len(data) < fcsSize {
+		return nil, nil, errZstdCorrupt
+	}
+	var contentSize uint64
+	for i := 0; i < fcsSize; i++ {
+		contentSize |= uint64(data[i]) << (8 * i)
+	}
+	if fcsSize == 2 {
+		contentSize += 256
+	}
+	data = data[fcsSize:]
+	if fcsSize > 0 && contentSize <= 1<<26 && cap(out)-len(out) < int(contentSize) {
+		grown := make([]byte, len(out), len(out)+int(contentSize))
+		copy(grown, out)
+		out = grown
+	}
+
+	d.start = len(out)
+	d.rep = [3]int{1, 4, 8}
+	for {
+		if len(data) < 3 {
+			return nil, nil, errZstdCorrupt
+		}
+		header := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16
+		data = data[3:]
+		last := header&1 != 0
+		size := int(header >> 3)
+
+		switch (header >> 1) & 3 {
+		case 0: // raw
+			if len(data) < size {
+				return nil, nil, errZstdCorrupt
+			}
+			out = append(out, data[:size]...)
+			data = data[size:]
+		case 1: // RLE
+			if len(data) < 1 {
+				return nil, nil, errZstdCorrupt
+			}
+			for i := 0; i < size; i++ {
+				out = append(out, data[0])
+			}
+			data = data[1:]
+		case 2: // compressed
+			if size > zstdMaxBlockSize || len(data) < size {
+				return nil, nil, errZstdCorrupt
+			}
+			var err error
+			out, err = d.decodeBlock(out, data[:size])
+			if err != nil {
+				return nil, nil, err
+			}
+			data = data[size:]
+		default:
+			return nil, nil, errors.New("zstd: reserved block type")
+		}
+		if last {
+			break
+		}
+	}
+
+	if fcsSize > 0 && uint64(len(out)-d.start) != contentSize {
+		return nil, nil, fmt.Errorf("zstd: frame decoded to %d bytes, header says %d", len(out)-d.start, contentSize)
+	}
+	if hasChecksum {
+		if len(data) < 4 {
+			return nil, nil, errZstdCorrupt
+		}
+		data = data[4:]
+	}
+	return out, data, nil
+}
+
+// decodeBlock decodes a compressed block, appending to out.
+func (d *zstdFrame) decodeBlock(out, block []byte) ([]byte, error) {
+	n, err := d.decodeLiterals(block)
+	if err != nil {
+		return nil, err
+	}
+	return d.decodeSequences(out, block[n:])
+}
+
+// decodeLiterals decodes the literals section at the start of a block into
+// d.literals and returns its size.
+func (d *zstdFrame) decodeLiterals(block []byte) (int, error) {
+	if len(block) < 1 {
+		return 0, errZstdCorrupt
+	}
+	litType := block[0] & 3
+	sizeFormat := (block[0] >> 2) & 3
+
+	if litType < 2 { // raw or RLE
+		var regen, hdr int
+		switch sizeFormat {
+		case 0, 2:
+			regen, hdr = int(block[0]>>3), 1
+		case 1:
+			if len(block) < 2 {
+				return 0, errZstdCorrupt
+			}
+			regen, hdr = int(block[0]>>4)+int(block[1])<<4, 2
+		case 3:
+			if len(block) < 3 {
+				return 0, errZstdCorrupt
+			}
+			regen, hdr = int(block[0]>>4)+int(block[1])<<4+int(block[2])<<12, 3
+		}
+		if regen > zstdMaxBlockSize {
+			return 0, errZstdCorrupt
+		}
+		if litType == 0 {
+			if len(block) < hdr+regen {
+				return 0, errZstdCorrupt
+			}
+			d.literals = append(d.literals[:0], block[hdr:hdr+regen]...)
+			return hdr + regen, nil
+		}
+		if len(block) < hdr+1 {
+			return 0, errZstdCorrupt
+		}
+		d.literals = d.literals[:0]
+		for i := 0; i < regen; i++ {
+			d.literals = append(d.literals, block[hdr])
+		}
+		return hdr + 1, nil
+	}
+
+	// Huffman-coded literals: header sizes by size format.
+	hdr, fieldBits, streams := 3, 10, 4
+	switch sizeFormat {
+	case 0:
+		streams = 1
+	case 2:
+		hdr, fieldBits = 4, 14
+	case 3:
+		hdr, fieldBits = 5, 18
+	}
+	if len(block) < hdr {
+		return 0, errZstdCorrupt
+	}
+	var v uint64
+	for i := hdr - 1; i >= 0; i-- {
+		v = v<<8 | uint64(block[i])
+	}
+	mask := uint64(1)<<fieldBits - 1
+	regen := int((v >> 4) & mask)
+	compSize := int((v >> (4 + fieldBits)) & mask)
+	if regen > zstdMaxBlockSize || len(block) < hdr+compSize {
+		return 0, errZstdCorrupt
+	}
+	src := block[hdr : hdr+compSize]
+
+	if litType == 2 {
+		n, err := d.huff.read(src)
+		if err != nil {
+			return 0, err
+		}
+		src = src[n:]
+		d.hasHuff = true
+	} else if !d.hasHuff {
+		return 0, errors.New("zstd: treeless literals without a previous Huffman table")
+	}
+
+	if cap(d.literals) < regen {
+		d.literals = make([]byte, regen)
+	}
+	d.literals = d.literals[:regen]
+	if streams == 1 {
+		if err := d.huff.decodeStream(d.literals, src); err != nil {
+			return 0, err
+		}
+		return hdr + compSize, nil
+	}
+
+	if len(src) < 6 {
+		return 0, errZstdCorrupt
+	}
+	sizes := [4]int{
+		int(binary.LittleEndian.Uint16(src)),
+		int(binary.LittleEndian.Uint16(src[2:])),
+		int(binary.LittleEndian.Uint16(src[4:])),
+	}
+	sizes[3] = len(src) - 6 - sizes[0] - sizes[1] - sizes[2]
+	if sizes[3] < 0 {
+		return 0, errZstdCorrupt
+	}
+	src = src[6:]
+	seg := (regen + 3) / 4
+	if 3*seg > regen {
+		return 0, errZstdCorrupt
+	}
+	dst := d.literals
+	for i, size := range sizes {
+		n := seg
+		if i == 3 {
+			n = len(dst)
+		}
+		if err := d.huff.decodeStream(dst[:n], src[:size]); err != nil {
+			return 0, err
+		}
+		dst, src = dst[n:], src[size:]
+	}
+	return hdr + compSize, nil
+}
+
+// decodeSequences decodes the sequences section of a block and executes
+// the sequences against d.literals, appending to out.
+func (d *zstdFrame) decodeSequences(out, src []byte) ([]byte, error) {
+	if len(src) < 1 {
+		return nil, errZstdCorrupt
+	}
+	nbSeq := int(src[0])
+	switch {
+	case nbSeq == 0:
+		return append(out, d.literals...), nil
+	case nbSeq < 128:
+		src = src[1:]
+	case nbSeq < 255:
+		if len(src) < 2 {
+			return nil, errZstdCorrupt
+		}
+		nbSeq = (nbSeq-128)<<8 + int(src[1])
+		src = src[2:]
+	default:
+		if len(src) < 3 {
+			return nil, errZstdCorrupt
+		}
+		nbSeq = int(src[1]) + int(src[2])<<8 + 0x7F00
+		src = src[3:]
+	}
+
+	if len(src) < 1 {
+		return nil, errZstdCorrupt
+	}
+	modes := src[0]
+	if modes&3 != 0 {
+		return nil, errors.New("zstd: reserved sequence mode bits set")
+	}
+	src = src[1:]
+	tables := [3]struct {
+		t        *zstdFSETable
+		mode     byte
+		def      []int16
+		defLog   uint8
+		maxSym   int
+		maxLog   uint8
+		previous *bool
+	}{
+		{&d.ll, modes >> 6, zstdLLDefault, 6, zstdMaxLLSymbol, zstdMaxLLLog, &d.hasFSE[0]},
+		{&d.of, (modes >> 4) & 3, zstdOFDefault, 5, zstdMaxOFSymbol, zstdMaxOFLog, &d.hasFSE[1]},
+		{&d.m, (modes >> 2) & 3, zstdMLDefault, 6, zstdMaxMLSymbol, zstdMaxMLLog, &d.hasFSE[2]},
+	}
+	for _, tb := range tables {
+		switch tb.mode {
+		case 0: // predefined
+			if err := tb.t.build(tb.def, tb.defLog); err != nil {
+				return nil, err
+			}
+		case 1: // RLE
+			if len(src) < 1 || int(src[0]) > tb.maxSym {
+				return nil, errZstdCorrupt
+			}
+			tb.t.rle(src[0])
+			src = src[1:]
+		case 2: // FSE-compressed
+			norm, log, n, err := readFSENorm(src, tb.maxSym, tb.maxLog)
+			if err != nil {
+				return nil, err
+			}
+			if err := tb.t.build(norm, log); err != nil {
+				return nil, err
+			}
+			src = src[n:]
+		case 3: // repeat
+			if !*tb.previous {
+				return nil, errors.New("zstd: repeated sequence table without a previous one")
+			}
+		}
+		*tb.previous = true
+	}
+
+	br, err := newZstdBackReader(src)
+	if err != nil {
+		return nil, err
+	}
+	llState := int(br.read(d.ll.log))
+	ofState := int(br.read(d.of.log))
+	mlState := int(br.read(d.m.log))
+
+	lits := d.literals
+	for i := 0; i < nbSeq; i++ {
+		llCode := d.ll.entries[llState].symbol
+		ofCode := d.of.entries[ofState].symbol
+		mlCode := d.m.entries[mlState].symbol
+		if llCode > zstdMaxLLSymbol || mlCode > zstdMaxMLSymbol || ofCode > zstdMaxOFSymbol {
+			return nil, errZstdCorrupt
+		}
+
+		offsetValue := int(1)<<ofCode + int(br.read(ofCode))
+		matchLen := int(zstdMLBase[mlCode]) + int(br.read(zstdMLBits[mlCode]))
+		litLen := int(zstdLLBase[llCode]) + int(br.read(zstdLLBits[llCode]))
+
+		var offset int
+		if offsetValue > 3 {
+			offset = offsetValue - 3
+			d.rep = [3]int{offset, d.rep[0], d.rep[1]}
+		} else {
+			idx := offsetValue - 1
+			if litLen == 0 {
+				idx++
+			}
+			switch idx {
+			case 0:
+				offset = d.rep[0]
+			case 1:
+				offset = d.rep[1]
+				d.rep = [3]int{offset, d.rep[0], d.rep[2]}
+			case 2:
+				offset = d.rep[2]
+				d.rep = [3]int{offset, d.rep[0], d.rep[1]}
+			case 3:
+				offset = d.rep[0] - 1
+				d.rep = [3]int{offset, d.rep[0], d.rep[1]}
+			}
+		}
+
+		if i < nbSeq-1 {
+			llState = int(d.ll.entries[llState].base) + int(br.read(d.ll.entries[llState].bits))
+			mlState = int(d.m.entries[mlState].base) + int(br.read(d.m.entries[mlState].bits))
+			ofState = int(d.of.entries[ofState].base) + int(br.read(d.of.entries[ofState].bits))
+		}
+
+		if litLen > len(lits) {
+			return nil, errZstdCorrupt
+		}
+		out = append(out, lits[:litLen]...)
+		lits = lits[litLen:]
+
+		if offset <= 0 || offset > len(out)-d.start {
+			return nil, fmt.Errorf("zstd: match offset %d beyond start of frame", offset)
+		}
+		from := len(out) - offset
+		for j := 0; j < matchLen; j++ {
+			out = append(out, out[from+j])
+		}
+	}
+	if br.pos != 0 {
+		return nil, errZstdCorrupt
+	}
+	return append(out, lits...), nil
+}
+
+// zstdFSETable is an FSE decoding table.
+type zstdFSETable struct {
+	log     uint8
+	entries []zstdFSEEntry
+}
+
+type zstdFSEEntry struct {
+	symbol uint8
+	bits   uint8
+	base   uint16
+}
+
+// rle sets t to a table that always decodes sym without reading bits.
+func (t *zstdFSETable) rle(sym byte) {
+	t.log = 0
+	t.entries = append(t.entries[:0], zstdFSEEntry{symbol: sym})
+}
+
+// build sets t to the decoding table of the normalized distribution norm,
+// in which -1 stands for a "less than 1" probability.
+func (t *zstdFSETable) build(norm []int16, log uint8) error {
+	size := 1 << log
+	if cap(t.entries) < size {
+		t.entries = make([]zstdFSEEntry, size)
+	}
+	t.entries = t.entries[:size]
+	t.log = log
+
+	next := make([]int, len(norm))
+	high := size - 1
+	for s, n := range norm {
+		if n == -1 {
+			t.entries[high].symbol = uint8(s)
+			high--
+			next[s] = 1
+		} else {
+			next[s] = int(n)
+		}
+	}
+	step := size>>1 + size>>3 + 3
+	pos := 0
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			t.entries[pos].symbol = uint8(s)
+			pos = (pos + step) & (size - 1)
+			for pos > high {
+				pos = (pos + step) & (size - 1)
+			}
+		}
+	}
+	if pos != 0 {
+		return errZstdCorrupt
+	}
+	for u := range t.entries {
+		s := t.entries[u].symbol
+		state := next[s]
+		next[s]++
+		nb := int(log) - (bits.Len(uint(state)) - 1)
+		t.entries[u].bits = uint8(nb)
+		t.entries[u].base = uint16(state<<nb - size)
+	}
+	return nil
+}
+
+// readFSENorm reads an FSE table description from src and returns the
+// normalized distribution, its accuracy log and the bytes it took.
+func readFSENorm(src []byte, maxSym int, maxLog uint8) ([]int16, uint8, int, error) {
+	br := zstdForwardReader{data: src}
+	log := uint8(br.read(4)) + 5
+	if log > maxLog {
+		return nil, 0, 0, fmt.Errorf("zstd: FSE accuracy log %d exceeds %d", log, maxLog)
+	}
+	norm := make([]int16, 0, maxSym+1)
+	remaining := 1<<log + 1
+	threshold := 1 << log
+	nbBits := uint(log) + 1
+	previous0 := false
+	for remaining > 1 && len(norm) <= maxSym {
+		if previous0 {
+			for {
+				repeat := int(br.read(2))
+				for i := 0; i < repeat; i++ {
+					norm = append(norm, 0)
+				}
+				if repeat != 3 {
+					break
+				}
+			}
+			if len(norm) > maxSym {
+				break
+			}
+		}
+		max := 2*threshold - 1 - remaining
+		var count int
+		if low := int(br.peek(nbBits - 1)); low < max {
+			count = low
+			br.skip(nbBits - 1)
+		} else {
+			count = int(br.peek(nbBits))
+			if count >= threshold {
+				count -= max
+			}
+			br.skip(nbBits)
+		}
+		count--
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		norm = append(norm, int16(count))
+		previous0 = count == 0
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if remaining != 1 || len(norm) > maxSym+1 || br.pos > 8*len(src) {
+		return nil, 0, 0, errZstdCorrupt
+	}
+	return norm, log, (br.pos + 7) / 8, nil
+}
+
+// zstdHuffTable is a Huffman decoding table indexed by the next maxBits
+// bits of the stream.
+type zstdHuffTable struct {
+	maxBits uint8
+	entries []zstdHuffEntry
+}
+
+type zstdHuffEntry struct {
+	symbol uint8
+	bits   uint8
+}
+
+// read reads a Huffman tree description from src and returns the bytes it
+// took.
+func (h *zstdHuffTable) read(src []byte) (int, error) {
+	if len(src) < 1 {
+		return 0, errZstdCorrupt
+	}
+	var weights []uint8
+	var n int
+	if hb := int(src[0]); hb < 128 {
+		// FSE-compressed weights.
+		if len(src) < 1+hb {
+			return 0, errZstdCorrupt
+		}
+		w, err := decodeHuffWeights(src[1 : 1+hb])
+		if err != nil {
+			return 0, err
+		}
+		weights, n = w, 1+hb
+	} else {
+		// Weights stored directly, four bits each.
+		count := hb - 127
+		n = 1 + (count+1)/2
+		if len(src) < n {
+			return 0, errZstdCorrupt
+		}
+		weights = make([]uint8, count)
+		for i := range weights {
+			b := src[1+i/2]
+			if i%2 == 0 {
+				weights[i] = b >> 4
+			} else {
+				weights[i] = b & 15
+			}
+		}
+	}
+
+	// The weight of the last symbol is implied: it completes the sum of
+	// 2^(weight-1) to the next power of two.
+	var sum int
+	for _, w := range weights {
+		if w > zstdMaxHuffBits {
+			return 0, errZstdCorrupt
+		}
+		if w > 0 {
+			sum += 1 << (w - 1)
+		}
+	}
+	if sum == 0 || len(weights) > 255 {
+		return 0, errZstdCorrupt
+	}
+	maxBits := bits.Len(uint(sum))
+	left := 1<<maxBits - sum
+	if left&(left-1) != 0 || maxBits > zstdMaxHuffBits {
+		return 0, errZstdCorrupt
+	}
+	weights = append(weights, uint8(bits.Len(uint(left))))
+
+	size := 1 << maxBits
+	if cap(h.entries) < size {
+		h.entries = make([]zstdHuffEntry, size)
+	}
+	h.entries = h.entries[:size]
+	h.maxBits = uint8(maxBits)
+	pos := 0
+	for w := 1; w <= maxBits; w++ {
+		for s, sw := range weights {
+			if int(sw) != w {
+				continue
+			}
+			span := 1 << (w - 1)
+			if pos+span > size {
+				return 0, errZstdCorrupt
+			}
+			e := zstdHuffEntry{symbol: uint8(s), bits: uint8(maxBits + 1 - w)}
+			for i := 0; i < span; i++ {
+				h.entries[pos+i] = e
+			}
+			pos += span
+		}
+	}
+	if pos != size {
+		return 0, errZstdCorrupt
+	}
+	return n, nil
+}
+
+// decodeStream fills dst with the symbols of one Huffman-coded stream.
+func (h *zstdHuffTable) decodeStream(dst, src []byte) error {
+	br, err := newZstdBackReader(src)
+	if err != nil {
+		return err
+	}
+	for i := range dst {
+		e := h.entries[br.peek(h.maxBits)]
+		dst[i] = e.symbol
+		br.skip(e.bits)
+	}
+	if br.pos != 0 {
+		return errZstdCorrupt
+	}
+	return nil
+}
+
+// decodeHuffWeights decodes FSE-compressed Huffman weights.
+func decodeHuffWeights(src []byte) ([]uint8, error) {
+	norm, log, n, err := readFSENorm(src, zstdMaxHuffBits+1, 6)
+	if err != nil {
+		return nil, err
+	}
+	var t zstdFSETable
+	if err := t.build(norm, log); err != nil {
+		return nil, err
+	}
+	br, err := newZstdBackReader(src[n:])
+	if err != nil {
+		return nil, err
+	}
+	s1 := int(br.read(log))
+	s2 := int(br.read(log))
+	var weights []uint8
+	// The two states take turns; once the stream is exhausted, the other
+	// state yields the final weight.
+	for len(weights) < 255 {
+		weights = append(weights, t.entries[s1].symbol)
+		s1 = int(t.entries[s1].base) + int(br.read(t.entries[s1].bits))
+		if br.overflowed() {
+			weights = append(weights, t.entries[s2].symbol)
+			break
+		}
+		weights = append(weights, t.entries[s2].symbol)
+		s2 = int(t.entries[s2].base) + int(br.read(t.entries[s2].bits))
+		if br.overflowed() {
+			weights = append(weights, t.entries[s1].symbol)
+			break
+		}
+	}
+	return weights, nil
+}
+
+// zstdForwardReader reads a little-endian bit stream from the front.
+type zstdForwardReader struct {
+	data []byte
+	pos  int // bits read
+}
+
+func (b *zstdForwardReader) peek(n uint) uint64 {
+	var v uint64
+	for i := uint(0); i < n; i++ {
+		p := b.pos + int(i)
+		if p/8 < len(b.data) && b.data[p/8]>>(p%8)&1 != 0 {
+			v |= 1 << i
+		}
+	}
+	return v
+}
+
+func (b *zstdForwardReader) skip(n uint) { b.pos += int(n) }
+
+func (b *zstdForwardReader) read(n uint) uint64 {
+	v := b.peek(n)
+	b.skip(n)
+	return v
+}
+
+// zstdBackReader reads a bit stream from its end towards its start, the
+// way FSE and Huffman streams are consumed. Bits before the start read as
+// zero.
+type zstdBackReader struct {
+	data []byte
+	pos  int // bits left before the read position
+}
+
+// newZstdBackReader positions a reader below the stream's padding marker,
+// the highest set bit of its last byte.
+func newZstdBackReader(src []byte) (*zstdBackReader, error) {
+	if len(src) == 0 || src[len(src)-1] == 0 {
+		return nil, errZstdCorrupt
+	}
+	return &zstdBackReader{data: src, pos: 8*(len(src)-1) + bits.Len8(src[len(src)-1]) - 1}, nil
+}
+
+// peek returns the n bits below the read position, most significant first.
+func (b *zstdBackReader) peek(n uint8) uint64 {
+	if n == 0 {
+		return 0
+	}
+	lo := b.pos - int(n)
+	shift := 0
+	if lo < 0 {
+		shift, lo = -lo, 0
+	}
+	var v uint64
+	first := lo / 8
+	for i := 0; i < 8 && first+i < len(b.data); i++ {
+		v |= uint64(b.data[first+i]) << (8 * i)
+	}
+	v >>= uint(lo % 8)
+	avail := int(n) - shift
+	if avail <= 0 {
+		return 0
+	}
+	v &= 1<<uint(avail) - 1
+	return v << uint(shift)
+}
+
+func (b *zstdBackReader) skip(n uint8) { b.pos -= int(n) }
+
+func (b *zstdBackReader) read(n uint8) uint64 {
+	v := b.peek(n)
+	b.skip(n)
+	return v
+}
+
+// overflowed reports whether more bits were read than the stream holds.
+func (b *zstdBackReader) overflowed() bool { return b.pos < 0 }
@@ -0,0 +1,158 @@
+package cog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// Frames written by the zstd command-line tool (zstd -19 --no-check).
+const (
+	// "the quick brown fox jumps over the lazy dog, the quick brown fox":
+	// raw literals and one sequence with the predefined tables.
+	zstdFox = "28b52ffd2040ad0100d40274686520717569636b2062726f776e20666f78" +
+		"206a756d7073206f76657220746865206c617a7920646f672c2001008589" +
+		"2a03"
+	// zstdLines(60): Huffman literals in four streams with FSE-compressed
+	// weights, and FSE-compressed sequence tables.
+	zstdLines60 = "28b52ffd60f101cd060086502313a0a5a03967f40800fc3511112122b3e9" +
+		"171e561e001c001c007c78736a0bf31c25472fea3615973ee1e032572f17" +
+		"4e411cc7004020602087c08b76db43dc647269e13b9ad8edbea1493a4be1" +
+		"bb7a6426a35b44f3b426e9b67a422edae61ee1519a2dd8d2e1c1d2d5b088" +
+		"1c76b54b58029b8572182f5a2a1caa77cf0e6553d9e5d9d79beeaef0d164" +
+		"9ca57c163ba810108142afe6203348c13110122468be0609ef681c4ce3b6" +
+		"bc9da400ebb5af8c0e9abed5dc329162d490daf0985105d7d6a06564c721" +
+		"c74423c838b1c539022acd77fd2fdbe0a7"
+	// A 16x16 8-bit tile of value 16x+y, horizontally differenced
+	// (predictor 2): each row is y followed by fifteen 16s.
+	zstdGradientTile = "28b52ffd600000fd00000282058f11111111111111115fb7655735454fb3" +
+		"2447310401105402000b01"
+)
+
+func zstdLines(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "tile %d/%d/%d\n", i%7, i*13%29, i*i%101)
+	}
+	return b.String()
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecompressZSTD(t *testing.T) {
+	fox := mustHex(t, zstdFox)
+	lines := mustHex(t, zstdLines60)
+	skippable := []byte{0x50, 0x2A, 0x4D, 0x18, 3, 0, 0, 0, 'a', 'b', 'c'}
+	// A frame of one raw and one RLE block: "tiff" then five 'x'.
+	blocks := []byte{
+		0x28, 0xB5, 0x2F, 0xFD, 0x20, 9, // single segment, 9 bytes
+		4 << 3, 0, 0, 't', 'i', 'f', 'f', // raw block
+		5<<3 | 1<<1 | 1, 0, 0, 'x', // last, RLE block
+	}
+
+	tests := []struct {
+		name string
+		in   []byte
+		want string
+	}{
+		{"predefined tables", fox, "the quick brown fox jumps over the lazy dog, the quick brown fox"},
+		{"compressed tables", lines, zstdLines(60)},
+		{"raw and RLE blocks", blocks, "tiffxxxxx"},
+		{"skippable frame", append(append([]byte{}, skippable...), blocks...), "tiffxxxxx"},
+		{"two frames", append(append([]byte{}, blocks...), fox...), "tiffxxxxxthe quick brown fox jumps over the lazy dog, the quick brown fox"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decompressZSTD(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecompressZSTDCorrupt(t *testing.T) {
+	lines := mustHex(t, zstdLines60)
+
+	tests := []struct {
+		name string
+		in   []byte
+		want string
+	}{
+		{"bad magic", []byte{1, 2, 3, 4, 5}, "bad magic"},
+		{"truncated", lines[:len(lines)-10], "corrupt"},
+		{"dictionary", []byte{0x28, 0xB5, 0x2F, 0xFD, 0x21, 7, 1, 0, 0, 0}, "dictionary 7"},
+		{"reserved block", []byte{0x28, 0xB5, 0x2F, 0xFD, 0x20, 0, 3<<1 | 1, 0, 0}, "reserved block type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decompressZSTD(tt.in)
+			if err == nil {
+				t.Fatalf("decoded %d bytes, want an error", len(got))
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestDecompressZSTDDamaged decodes every truncation and single-byte
+// corruption of a frame: each must fail or decode, never panic.
+func TestDecompressZSTDDamaged(t *testing.T) {
+	lines := mustHex(t, zstdLines60)
+	for n := range lines {
+		decompressZSTD(lines[:n])
+	}
+	for i := range lines {
+		for _, flip := range []byte{0x01, 0x80, 0xFF} {
+			damaged := bytes.Clone(lines)
+			damaged[i] ^= flip
+			decompressZSTD(damaged)
+		}
+	}
+}
+
+func TestReadZSTDTile(t *testing.T) {
+	data := mustHex(t, zstdGradientTile)
+	ifd := IFD{
+		Width: 16, Height: 16, TileWidth: 16, TileHeight: 16,
+		SamplesPerPixel: 1, BitsPerSample: []uint16{8}, Compression: 50000, Predictor: 2,
+		TileOffsets: []uint64{0}, TileByteCounts: []uint64{uint64(len(data))},
+	}
+	r := &Reader{bo: binary.LittleEndian, ifds: []IFD{ifd}, data: data}
+
+	img, err := r.ReadTile(0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range [][2]int{{0, 0}, {1, 0}, {15, 3}, {7, 15}} {
+		x, y := p[0], p[1]
+		got := color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+		if want := uint8(16*x + y); got != want {
+			t.Errorf("pixel (%d,%d) = %d, want %d", x, y, got, want)
+		}
+	}
+
+	vals, _, _, err := r.ReadFloatTile(0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := vals[5*16+9]; got != 16*9+5 {
+		t.Errorf("value (9,5) = %v, want %d", got, 16*9+5)
+	}
+}
@@ -60,54 +60,6 @@ func BenchmarkTileBounds(b *testing.B) {
 	_, _ = sinkA, sinkB
 }
 
-// --- SortTilesByHilbert benchmarks ---
-
-// makeTileList builds a slice of [3]int tiles covering all tiles at zoom z.
-func makeTileList(z int) [][3]int {
-	n := 1 << uint(z)
-	tiles := make([][3]int, 0, n*n)
-	for y := 0; y < n; y++ {
-		for x := 0; x < n; x++ {
-			tiles = append(tiles, [3]int{z, x, y})
-		}
-	}
-	return tiles
-}
-
-// BenchmarkSortTilesByHilbert_Z8 measures sorting 256×256 = 65536 tiles.
-// This is a realistic zoom-level batch size for a moderate-resolution dataset.
-func BenchmarkSortTilesByHilbert_Z8(b *testing.B) {
-	for i := 0; i < b.N; i++ {
-		// Rebuild the slice each iteration so we sort an unsorted list.
-		b.StopTimer()
-		tiles := makeTileList(8)
-		b.StartTimer()
-		SortTilesByHilbert(tiles)
-	}
-}
-
-// BenchmarkSortTilesByHilbert_Z10 measures sorting 1024×1024 = 1M tiles.
-// Representative of a high-resolution global dataset at z10.
-func BenchmarkSortTilesByHilbert_Z10(b *testing.B) {
-	for i := 0; i < b.N; i++ {
-		b.StopTimer()
-		tiles := makeTileList(10)
-		b.StartTimer()
-		SortTilesByHilbert(tiles)
-	}
-}
-
-// BenchmarkSortTilesByHilbert_Z6 measures sorting 4096 tiles.
-// Representative of a small regional dataset or a single zoom batch.
-func BenchmarkSortTilesByHilbert_Z6(b *testing.B) {
-	for i := 0; i < b.N; i++ {
-		b.StopTimer()
-		tiles := makeTileList(6)
-		b.StartTimer()
-		SortTilesByHilbert(tiles)
-	}
-}
-
 // --- ResolutionAtLat benchmark ---
 
 // BenchmarkResolutionAtLat measures the per-tile resolution calculation
@@ -0,0 +1,125 @@
+package coord
+
+import "math"
+
+// LambertConformalConic implements the Projection interface for Lambert
+// Conformal Conic CRSs with two standard parallels (EPSG method 9802), such
+// as Lambert-93 or ETRS89-LCC Europe.
+//
+// Reference: IOGP Guidance Note 7-2, Coordinate Conversions and
+// Transformations including Formulas.
+type LambertConformalConic struct {
+	code             int
+	lon0, fe, fn     float64
+	a, e, n, f, rhoF float64
+}
+
+// NewLambertConformalConic returns a Lambert Conformal Conic projection with
+// the given EPSG code and parameters: latitude of false origin, longitude
+// of false origin and the two standard parallels in degrees, and false
+// easting/northing in meters.
+func NewLambertConformalConic(epsg int, el Ellipsoid, lat0, lon0, lat1, lat2, falseEasting, falseNorthing float64) *LambertConformalConic {
+	p := &LambertConformalConic{code: epsg, lon0: radians(lon0), fe: falseEasting, fn: falseNorthing, a: el.A, e: math.Sqrt(el.e2())}
+	phi1, phi2 := radians(lat1), radians(lat2)
+	m1, m2 := p.m(phi1), p.m(phi2)
+	t1, t2 := isometricT(phi1, p.e), isometricT(phi2, p.e)
+	if lat1 == lat2 {
+		p.n = math.Sin(phi1)
+	} else {
+		p.n = (math.Log(m1) - math.Log(m2)) / (math.Log(t1) - math.Log(t2))
+	}
+	p.f = m1 / (p.n * math.Pow(t1, p.n))
+	p.rhoF = p.a * p.f * math.Pow(isometricT(radians(lat0), p.e), p.n)
+	return p
+}
+
+func (p *LambertConformalConic) EPSG() int { return p.code }
+
+func (p *LambertConformalConic) m(phi float64) float64 {
+	s := p.e * math.Sin(phi)
+	return math.Cos(phi) / math.Sqrt(1-s*s)
+}
+
+// FromWGS84 converts WGS84 longitude/latitude (degrees) to easting/northing.
+func (p *LambertConformalConic) FromWGS84(lon, lat float64) (x, y float64) {
+	rho := p.a * p.f * math.Pow(isometricT(radians(lat), p.e), p.n)
+	theta := p.n * (radians(lon) - p.lon0)
+	return p.fe + rho*math.Sin(theta), p.fn + p.rhoF - rho*math.Cos(theta)
+}
+
+// ToWGS84 converts easting/northing to WGS84 longitude/latitude (degrees).
+func (p *LambertConformalConic) ToWGS84(x, y float64) (lon, lat float64) {
+	dx, dy := x-p.fe, p.rhoF-(y-p.fn)
+	sign := math.Copysign(1, p.n)
+	rho := sign * math.Hypot(dx, dy)
+	t := math.Pow(rho/(p.a*p.f), 1/p.n)
+	theta := math.Atan2(sign*dx, sign*dy)
+	phi := math.Pi/2 - 2*math.Atan(t)
+	for i := 0; i < 10; i++ {
+		s := p.e * math.Sin(phi)
+		next := math.Pi/2 - 2*math.Atan(t*math.Pow((1-s)/(1+s), p.e/2))
+		if math.Abs(next-phi) < 1e-12 {
+			phi = next
+			break
+		}
+		phi = next
+	}
+	return degrees(theta/p.n + p.lon0), degrees(phi)
+}
+
+// AlbersEqualArea implements the Projection interface for Albers Equal Area
+// conic CRSs (EPSG method 9822), such as CONUS Albers or Australian Albers.
+type AlbersEqualArea struct {
+	code         int
+	lon0, fe, fn float64
+	a, e, n, c   float64
+	rho0, qP     float64
+}
+
+// NewAlbersEqualArea returns an Albers Equal Area projection with the given
+// EPSG code and parameters: latitude and longitude of false origin and the
+// two standard parallels in degrees, and false easting/northing in meters.
+func NewAlbersEqualArea(epsg int, el Ellipsoid, lat0, lon0, lat1, lat2, falseEasting, falseNorthing float64) *AlbersEqualArea {
+	e2 := el.e2()
+	p := &AlbersEqualArea{code: epsg, lon0: radians(lon0), fe: falseEasting, fn: falseNorthing, a: el.A, e: math.Sqrt(e2)}
+	m := func(phi float64) float64 {
+		s := math.Sin(phi)
+		return math.Cos(phi) / math.Sqrt(1-e2*s*s)
+	}
+	phi1, phi2 := radians(lat1), radians(lat2)
+	m1, m2 := m(phi1), m(phi2)
+	q1, q2 := authalicQ(math.Sin(phi1), p.e), authalicQ(math.Sin(phi2), p.e)
+	if lat1 == lat2 {
+		p.n = math.Sin(phi1)
+	} else {
+		p.n = (m1*m1 - m2*m2) / (q2 - q1)
+	}
+	p.c = m1*m1 + p.n*q1
+	p.rho0 = p.rho(radians(lat0))
+	p.qP = authalicQ(1, p.e)
+	return p
+}
+
+func (p *AlbersEqualArea) EPSG() int { return p.code }
+
+func (p *AlbersEqualArea) rho(phi float64) float64 {
+	return p.a * math.Sqrt(p.c-p.n*authalicQ(math.Sin(phi), p.e)) / p.n
+}
+
+// FromWGS84 converts WGS84 longitude/latitude (degrees) to easting/northing.
+func (p *AlbersEqualArea) FromWGS84(lon, lat float64) (x, y float64) {
+	rho := p.rho(radians(lat))
+	theta := p.n * (radians(lon) - p.lon0)
+	return p.fe + rho*math.Sin(theta), p.fn + p.rho0 - rho*math.Cos(theta)
+}
+
+// ToWGS84 converts easting/northing to WGS84 longitude/latitude (degrees).
+func (p *AlbersEqualArea) ToWGS84(x, y float64) (lon, lat float64) {
+	dx, dy := x-p.fe, p.rho0-(y-p.fn)
+	sign := math.Copysign(1, p.n)
+	rho := math.Hypot(dx, dy)
+	theta := math.Atan2(sign*dx, sign*dy)
+	q := (p.c - rho*rho*p.n*p.n/(p.a*p.a)) / p.n
+	beta := math.Asin(math.Max(-1, math.Min(1, q/p.qP)))
+	return degrees(theta/p.n + p.lon0), degrees(authalicToGeodetic(beta, p.e))
+}
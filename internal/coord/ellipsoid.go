@@ -0,0 +1,64 @@
+package coord
+
+import "math"
+
+// Ellipsoid is a reference ellipsoid given by its semi-major axis (meters)
+// and inverse flattening.
+type Ellipsoid struct {
+	A    float64
+	InvF float64
+}
+
+// Reference ellipsoids of the datums in the EPSG table. GRS80 (ETRS89,
+// NAD83, GDA94, NZGD2000) differs from WGS84 by 0.1 mm in the semi-minor
+// axis; the datums themselves are within about 2 m of WGS84 and are used
+// without a datum shift.
+var (
+	WGS84Ellipsoid = Ellipsoid{A: 6378137, InvF: 298.257223563}
+	GRS80Ellipsoid = Ellipsoid{A: 6378137, InvF: 298.257222101}
+)
+
+// e2 returns the first eccentricity squared.
+func (el Ellipsoid) e2() float64 {
+	f := 1 / el.InvF
+	return f * (2 - f)
+}
+
+// authalicQ returns q(φ) of the equal-area projections (EPSG guidance
+// note 7-2, Albers and Lambert Azimuthal Equal Area).
+func authalicQ(sinPhi, e float64) float64 {
+	e2 := e * e
+	return (1 - e2) * (sinPhi/(1-e2*sinPhi*sinPhi) - math.Log((1-e*sinPhi)/(1+e*sinPhi))/(2*e))
+}
+
+// authalicToGeodetic converts an authalic latitude β to the geodetic
+// latitude (radians): the series of EPSG guidance note 7-2, refined by
+// Newton steps on q (Snyder, eq. 3-16) to well below a millimeter.
+func authalicToGeodetic(beta, e float64) float64 {
+	e2 := e * e
+	e4, e6 := e2*e2, e2*e2*e2
+	phi := beta +
+		(e2/3+31*e4/180+517*e6/5040)*math.Sin(2*beta) +
+		(23*e4/360+251*e6/3780)*math.Sin(4*beta) +
+		(761*e6/45360)*math.Sin(6*beta)
+	q := authalicQ(1, e) * math.Sin(beta)
+	for i := 0; i < 2; i++ {
+		s, c := math.Sincos(phi)
+		if c < 1e-12 {
+			break
+		}
+		w := 1 - e2*s*s
+		phi += w * w / (2 * c) * (q - authalicQ(s, e)) / (1 - e2)
+	}
+	return phi
+}
+
+// isometricT returns t(φ) of the conformal conic projections.
+func isometricT(phi, e float64) float64 {
+	s := e * math.Sin(phi)
+	return math.Tan(math.Pi/4-phi/2) / math.Pow((1-s)/(1+s), e/2)
+}
+
+// radians and degrees convert angles.
+func radians(deg float64) float64 { return deg * math.Pi / 180 }
+func degrees(rad float64) float64 { return rad * 180 / math.Pi }
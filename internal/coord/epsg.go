@@ -0,0 +1,91 @@
+package coord
+
+import "fmt"
+
+// epsgDef is an entry of the EPSG parameter table.
+type epsgDef struct {
+	name  string
+	build func(epsg int) Projection
+}
+
+// epsgTable holds the projected CRSs supported besides the built-in ones,
+// with their parameters from the EPSG registry. Only datums within about
+// 2 m of WGS84 (ETRS89, NAD83, GDA94, NZGD2000, RGF93, SWEREF99) are
+// listed: they are used without a datum shift, which is below a pixel at
+// the resolutions tiled here. CRSs on older datums (OSGB36, DHDN, MGI,
+// Amersfoort, ...) need a shift of tens to hundreds of meters and are left
+// out rather than placed wrongly.
+var epsgTable = map[int]epsgDef{
+	2154: {"RGF93 v1 / Lambert-93", lcc(GRS80Ellipsoid, 46.5, 3, 49, 44, 700000, 6600000)},
+	2180: {"ETRS89 / Poland CS92", tm(GRS80Ellipsoid, 0, 19, 0.9993, 500000, -5300000)},
+	2193: {"NZGD2000 / New Zealand Transverse Mercator 2000", tm(GRS80Ellipsoid, 0, 173, 0.9996, 1600000, 10000000)},
+	3006: {"SWEREF99 TM", tm(GRS80Ellipsoid, 0, 15, 0.9996, 500000, 0)},
+	3034: {"ETRS89-extended / LCC Europe", lcc(GRS80Ellipsoid, 52, 10, 35, 65, 4000000, 2800000)},
+	3035: {"ETRS89-extended / LAEA Europe", laea(GRS80Ellipsoid, 52, 10, 4321000, 3210000)},
+	3067: {"ETRS89 / TM35FIN(E,N)", tm(GRS80Ellipsoid, 0, 27, 0.9996, 500000, 0)},
+	3338: {"NAD83 / Alaska Albers", albers(GRS80Ellipsoid, 50, -154, 55, 65, 0, 0)},
+	3416: {"ETRS89 / Austria Lambert", lcc(GRS80Ellipsoid, 47.5, 13+1.0/3, 49, 46, 400000, 400000)},
+	3577: {"GDA94 / Australian Albers", albers(GRS80Ellipsoid, 0, 132, -18, -36, 0, 0)},
+	3763: {"ETRS89 / Portugal TM06", tm(GRS80Ellipsoid, 39.66825833, -8.13310833, 1, 0, 0)},
+	3812: {"ETRS89 / Belgian Lambert 2008", lcc(GRS80Ellipsoid, 50.797815, 4.359215833, 49+5.0/6, 51+1.0/6, 649328, 665262)},
+	5070: {"NAD83 / Conus Albers", albers(GRS80Ellipsoid, 23, -96, 29.5, 45.5, 0, 0)},
+}
+
+func tm(el Ellipsoid, lat0, lon0, k0, fe, fn float64) func(int) Projection {
+	return func(epsg int) Projection { return NewTransverseMercator(epsg, el, lat0, lon0, k0, fe, fn) }
+}
+
+func lcc(el Ellipsoid, lat0, lon0, lat1, lat2, fe, fn float64) func(int) Projection {
+	return func(epsg int) Projection { return NewLambertConformalConic(epsg, el, lat0, lon0, lat1, lat2, fe, fn) }
+}
+
+func albers(el Ellipsoid, lat0, lon0, lat1, lat2, fe, fn float64) func(int) Projection {
+	return func(epsg int) Projection { return NewAlbersEqualArea(epsg, el, lat0, lon0, lat1, lat2, fe, fn) }
+}
+
+func laea(el Ellipsoid, lat0, lon0, fe, fn float64) func(int) Projection {
+	return func(epsg int) Projection { return NewLambertAzimuthalEqualArea(epsg, el, lat0, lon0, fe, fn) }
+}
+
+// lookupEPSG returns the table entry of epsg, deriving the UTM zones of
+// WGS84 (326zz north, 327zz south), ETRS89 (258zz, zones 28-38) and NAD83
+// (269zz, zones 1-23) from their zone number.
+func lookupEPSG(epsg int) (epsgDef, bool) {
+	utm := func(datum string, el Ellipsoid, zone int, south bool) epsgDef {
+		fn, hemi := 0.0, "N"
+		if south {
+			fn, hemi = 10000000, "S"
+		}
+		return epsgDef{
+			name:  fmt.Sprintf("%s / UTM zone %d%s", datum, zone, hemi),
+			build: tm(el, 0, float64(zone*6-183), 0.9996, 500000, fn),
+		}
+	}
+	switch {
+	case epsg >= 32601 && epsg <= 32660:
+		return utm("WGS 84", WGS84Ellipsoid, epsg-32600, false), true
+	case epsg >= 32701 && epsg <= 32760:
+		return utm("WGS 84", WGS84Ellipsoid, epsg-32700, true), true
+	case epsg >= 25828 && epsg <= 25838:
+		return utm("ETRS89", GRS80Ellipsoid, epsg-25800, false), true
+	case epsg >= 26901 && epsg <= 26923:
+		return utm("NAD83", GRS80Ellipsoid, epsg-26900, false), true
+	}
+	def, ok := epsgTable[epsg]
+	return def, ok
+}
+
+// EPSGName returns the registry name of a supported EPSG code, or "" if the
+// code is not supported.
+func EPSGName(epsg int) string {
+	switch epsg {
+	case 2056:
+		return "CH1903+ / LV95"
+	case 4326:
+		return "WGS 84"
+	case 3857:
+		return "WGS 84 / Pseudo-Mercator"
+	}
+	def, _ := lookupEPSG(epsg)
+	return def.name
+}
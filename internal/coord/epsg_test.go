@@ -0,0 +1,92 @@
+package coord
+
+import (
+	"math"
+	"testing"
+)
+
+// Worked examples of IOGP Guidance Note 7-2 and Snyder's Map Projections:
+// A Working Manual, on the ellipsoids they use.
+var (
+	airy1830    = Ellipsoid{A: 6377563.396, InvF: 299.3249646}
+	clarke1866  = Ellipsoid{A: 6378206.4, InvF: 294.9786982}
+	usSurveyFt  = 0.3048006096012192
+	examplesTol = 0.02 // meters
+)
+
+func TestProjectionKnownValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		proj     Projection
+		lon, lat float64
+		x, y     float64
+	}{
+		{"TM (OSGB)", NewTransverseMercator(27700, airy1830, 49, -2, 0.9996012717, 400000, -100000),
+			0.5, 50.5, 577274.99, 69740.50},
+		{"LCC (Texas South Central)", NewLambertConformalConic(32040, clarke1866, 27+50.0/60, -99, 28+23.0/60, 30+17.0/60, 2000000*usSurveyFt, 0),
+			-96, 28.5, 2963503.91 * usSurveyFt, 254759.80 * usSurveyFt},
+		{"Albers (Snyder)", NewAlbersEqualArea(0, clarke1866, 23, -96, 29.5, 45.5, 0, 0),
+			-75, 35, 1885472.7, 1535925.0},
+		{"LAEA (EPSG:3035)", ForEPSG(3035), 5, 50, 3962799.45, 2999718.85},
+		{"UTM 32N origin", ForEPSG(25832), 9, 0, 500000, 0},
+		{"UTM 33S origin", ForEPSG(32733), 15, 0, 500000, 10000000},
+		{"Lambert-93 origin", ForEPSG(2154), 3, 46.5, 700000, 6600000},
+	}
+	for _, tt := range tests {
+		tol := examplesTol
+		if tt.name == "Albers (Snyder)" {
+			tol = 0.1 // published to a decimeter
+		}
+		x, y := tt.proj.FromWGS84(tt.lon, tt.lat)
+		if math.Abs(x-tt.x) > tol || math.Abs(y-tt.y) > tol {
+			t.Errorf("%s: FromWGS84(%v, %v) = (%.3f, %.3f), want (%.3f, %.3f)", tt.name, tt.lon, tt.lat, x, y, tt.x, tt.y)
+		}
+		lon, lat := tt.proj.ToWGS84(tt.x, tt.y)
+		if math.Abs(lon-tt.lon) > 1e-6 || math.Abs(lat-tt.lat) > 1e-6 {
+			t.Errorf("%s: ToWGS84(%.3f, %.3f) = (%.8f, %.8f), want (%v, %v)", tt.name, tt.x, tt.y, lon, lat, tt.lon, tt.lat)
+		}
+	}
+}
+
+// TestEPSGTableRoundTrip checks every table entry at a point in its area of
+// use, and 300 km off the central meridian for UTM.
+func TestEPSGTableRoundTrip(t *testing.T) {
+	points := map[int][2]float64{
+		2154: {2.35, 48.85}, 2180: {21.01, 52.23}, 2193: {174.78, -41.29},
+		3006: {18.07, 59.33}, 3034: {-9.14, 38.72}, 3035: {24.94, 60.17},
+		3067: {24.94, 60.17}, 3338: {-149.9, 61.22}, 3416: {16.37, 48.21},
+		3577: {151.21, -33.87}, 3763: {-9.14, 38.72}, 3812: {4.35, 50.85},
+		5070:  {-122.42, 37.77},
+		32632: {12.9, 47.8}, 32733: {11.2, -20}, 25832: {5.1, 60.4}, 26910: {-124.9, 49.3},
+	}
+	for code := range epsgTable {
+		if _, ok := points[code]; !ok {
+			t.Errorf("EPSG:%d has no test point", code)
+		}
+	}
+	for code, pt := range points {
+		p := ForEPSG(code)
+		if p == nil || EPSGName(code) == "" {
+			t.Fatalf("EPSG:%d not supported", code)
+		}
+		x, y := p.FromWGS84(pt[0], pt[1])
+		lon, lat := p.ToWGS84(x, y)
+		if math.Abs(lon-pt[0]) > 1e-9 || math.Abs(lat-pt[1]) > 1e-9 {
+			t.Errorf("EPSG:%d round trip of %v = (%.12f, %.12f)", code, pt, lon, lat)
+		}
+	}
+}
+
+func TestEPSGName(t *testing.T) {
+	for code, want := range map[int]string{
+		25832: "ETRS89 / UTM zone 32N",
+		32733: "WGS 84 / UTM zone 33S",
+		3035:  "ETRS89-extended / LAEA Europe",
+		2056:  "CH1903+ / LV95",
+		31467: "",
+	} {
+		if got := EPSGName(code); got != want {
+			t.Errorf("EPSGName(%d) = %q, want %q", code, got, want)
+		}
+	}
+}
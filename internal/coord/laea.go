@@ -0,0 +1,60 @@
+package coord
+
+import "math"
+
+// LambertAzimuthalEqualArea implements the Projection interface for oblique
+// Lambert Azimuthal Equal Area CRSs (EPSG method 9820), such as ETRS89-LAEA
+// Europe (EPSG:3035).
+//
+// Reference: IOGP Guidance Note 7-2, Coordinate Conversions and
+// Transformations including Formulas.
+type LambertAzimuthalEqualArea struct {
+	code               int
+	lon0, fe, fn       float64
+	e, qP, rq, d       float64
+	sinBeta0, cosBeta0 float64
+}
+
+// NewLambertAzimuthalEqualArea returns a Lambert Azimuthal Equal Area
+// projection with the given EPSG code and parameters: latitude and
+// longitude of natural origin in degrees, and false easting/northing in
+// meters.
+func NewLambertAzimuthalEqualArea(epsg int, el Ellipsoid, lat0, lon0, falseEasting, falseNorthing float64) *LambertAzimuthalEqualArea {
+	e2 := el.e2()
+	p := &LambertAzimuthalEqualArea{code: epsg, lon0: radians(lon0), fe: falseEasting, fn: falseNorthing, e: math.Sqrt(e2)}
+	phi0 := radians(lat0)
+	p.qP = authalicQ(1, p.e)
+	p.rq = el.A * math.Sqrt(p.qP/2)
+	beta0 := math.Asin(authalicQ(math.Sin(phi0), p.e) / p.qP)
+	p.sinBeta0, p.cosBeta0 = math.Sincos(beta0)
+	s := math.Sin(phi0)
+	p.d = el.A * (math.Cos(phi0) / math.Sqrt(1-e2*s*s)) / (p.rq * p.cosBeta0)
+	return p
+}
+
+func (p *LambertAzimuthalEqualArea) EPSG() int { return p.code }
+
+// FromWGS84 converts WGS84 longitude/latitude (degrees) to easting/northing.
+func (p *LambertAzimuthalEqualArea) FromWGS84(lon, lat float64) (x, y float64) {
+	beta := math.Asin(authalicQ(math.Sin(radians(lat)), p.e) / p.qP)
+	sinBeta, cosBeta := math.Sincos(beta)
+	sinL, cosL := math.Sincos(radians(lon) - p.lon0)
+	b := p.rq * math.Sqrt(2/(1+p.sinBeta0*sinBeta+p.cosBeta0*cosBeta*cosL))
+	x = p.fe + b*p.d*cosBeta*sinL
+	y = p.fn + (b/p.d)*(p.cosBeta0*sinBeta-p.sinBeta0*cosBeta*cosL)
+	return x, y
+}
+
+// ToWGS84 converts easting/northing to WGS84 longitude/latitude (degrees).
+func (p *LambertAzimuthalEqualArea) ToWGS84(x, y float64) (lon, lat float64) {
+	dx, dy := (x-p.fe)/p.d, p.d*(y-p.fn)
+	rho := math.Hypot(dx, dy)
+	if rho == 0 {
+		return degrees(p.lon0), degrees(authalicToGeodetic(math.Asin(p.sinBeta0), p.e))
+	}
+	c := 2 * math.Asin(math.Min(1, rho/(2*p.rq)))
+	sinC, cosC := math.Sincos(c)
+	beta := math.Asin(cosC*p.sinBeta0 + dy*sinC*p.cosBeta0/rho)
+	dLon := math.Atan2((x-p.fe)*sinC, p.d*rho*p.cosBeta0*cosC-p.d*p.d*(y-p.fn)*p.sinBeta0*sinC)
+	return degrees(p.lon0 + dLon), degrees(authalicToGeodetic(beta, p.e))
+}
@@ -35,18 +35,42 @@ func pow2(z int) float64 {
 	return float64(uint64(1) << uint(z))
 }
 
-// maxMercatorLat is the maximum latitude representable in Web Mercator.
+// MaxMercatorLat is the maximum latitude representable in Web Mercator.
 // Beyond this, the Mercator projection diverges to infinity.
-const maxMercatorLat = 85.0511287798
+const MaxMercatorLat = 85.0511287798
+
+// ClampMercatorLat clamps the latitude range [minLat, maxLat] to the Web
+// Mercator limit. ok is false if the range lies entirely beyond it.
+func ClampMercatorLat(minLat, maxLat float64) (lo, hi float64, ok bool) {
+	lo = math.Max(minLat, -MaxMercatorLat)
+	hi = math.Min(maxLat, MaxMercatorLat)
+	return lo, hi, lo < hi || (lo == hi && minLat == maxLat)
+}
+
+// LonLatArea returns the area in km² of a lon/lat rectangle on a sphere with
+// the WGS84 equatorial radius. minLon > maxLon is taken to cross the
+// antimeridian. Good enough for reporting; not geodesic.
+func LonLatArea(minLon, minLat, maxLon, maxLat float64) float64 {
+	if maxLat <= minLat {
+		return 0
+	}
+	dLon := maxLon - minLon
+	if dLon < 0 {
+		dLon += 360 // crosses the antimeridian
+	}
+	const r = OriginShift / math.Pi / 1000 // km
+	dLon *= math.Pi / 180
+	return r * r * dLon * (math.Sin(maxLat*math.Pi/180) - math.Sin(minLat*math.Pi/180))
+}
 
 // LonLatToTile converts WGS84 lon/lat to tile coordinates at the given zoom level.
 func LonLatToTile(lon, lat float64, zoom int) (x, y int) {
 	// Clamp latitude to the valid Web Mercator range to avoid Inf/NaN
 	// from the Mercator projection at the poles.
-	if lat > maxMercatorLat {
-		lat = maxMercatorLat
-	} else if lat < -maxMercatorLat {
-		lat = -maxMercatorLat
+	if lat > MaxMercatorLat {
+		lat = MaxMercatorLat
+	} else if lat < -MaxMercatorLat {
+		lat = -MaxMercatorLat
 	}
 
 	n := pow2(zoom)
@@ -182,12 +206,111 @@ func MinZoomForSingleTile(minLon, minLat, maxLon, maxLat float64) int {
 	return 28 // extremely small or point region
 }
 
-// TilesInBounds returns all tile coordinates at the given zoom level that intersect the given WGS84 bounds.
+// EdgePolicy controls whether a tile that touches the bounds only along an
+// edge (or corner) is part of the tile range.
+type EdgePolicy int
+
+const (
+	// EdgeExclusive skips tiles that only touch the bounds, so bounds that
+	// fall exactly on tile boundaries do not pick up an empty extra row or
+	// column. Degenerate (zero-area) bounds still yield one tile.
+	EdgeExclusive EdgePolicy = iota
+	// EdgeInclusive includes every tile whose closed extent intersects the
+	// bounds, including tiles that only share an edge with them.
+	EdgeInclusive
+)
+
+// tileEdgeEpsilon is the tolerance, in tiles, within which a bound is
+// treated as lying exactly on a tile boundary. It absorbs float noise from
+// reprojected bounds (e.g. 7.4999999999 instead of 7.5) while staying far
+// below one pixel even for 4096 px tiles.
+const tileEdgeEpsilon = 1e-6
+
+// lonToTileF returns the fractional tile x coordinate of lon at zoom n=2^z.
+func lonToTileF(lon, n float64) float64 {
+	return (lon + 180.0) / 360.0 * n
+}
+
+// latToTileF returns the fractional tile y coordinate of lat at zoom n=2^z.
+func latToTileF(lat, n float64) float64 {
+	if lat > MaxMercatorLat {
+		lat = MaxMercatorLat
+	} else if lat < -MaxMercatorLat {
+		lat = -MaxMercatorLat
+	}
+	latRad := lat * math.Pi / 180.0
+	return (1.0 - math.Log(math.Tan(latRad)+1.0/math.Cos(latRad))/math.Pi) / 2.0 * n
+}
+
+// edgeRange converts a fractional interval [lo, hi] (in tiles) to an
+// inclusive integer tile range under the given policy, clamped to [0, n-1].
+func edgeRange(lo, hi float64, n int, policy EdgePolicy) (int, int) {
+	var a, b int
+	if policy == EdgeInclusive {
+		a = int(math.Ceil(lo-tileEdgeEpsilon)) - 1
+		b = int(math.Floor(hi + tileEdgeEpsilon))
+	} else {
+		a = int(math.Floor(lo + tileEdgeEpsilon))
+		b = int(math.Ceil(hi-tileEdgeEpsilon)) - 1
+	}
+	if a < 0 {
+		a = 0
+	}
+	if b > n-1 {
+		b = n - 1
+	}
+	if a > n-1 {
+		a = n - 1
+	}
+	if b < a {
+		// Zero-width interval on a tile boundary (or an epsilon-thin sliver):
+		// keep the single tile containing lo.
+		b = a
+	}
+	return a, b
+}
+
+// TileRange returns the inclusive tile x/y range at zoom covering the WGS84
+// bounds under the given edge policy. minLon must not exceed maxLon; use
+// TilesInBoundsPolicy for bounds crossing the antimeridian.
+func TileRange(zoom int, minLon, minLat, maxLon, maxLat float64, policy EdgePolicy) (minX, minY, maxX, maxY int) {
+	nf := pow2(zoom)
+	n := int(nf)
+	minX, maxX = edgeRange(lonToTileF(minLon, nf), lonToTileF(maxLon, nf), n, policy)
+	minY, maxY = edgeRange(latToTileF(maxLat, nf), latToTileF(minLat, nf), n, policy) // maxLat -> minY
+	return
+}
+
+// TilesInBounds returns all tile coordinates at the given zoom level that
+// intersect the given WGS84 bounds, skipping tiles that only touch them
+// (EdgeExclusive). Bounds with minLon > maxLon cross the antimeridian.
 func TilesInBounds(zoom int, minLon, minLat, maxLon, maxLat float64) [][3]int {
-	minTX, minTY := LonLatToTile(minLon, maxLat, zoom) // note: maxLat -> minTY
-	maxTX, maxTY := LonLatToTile(maxLon, minLat, zoom)
+	return TilesInBoundsPolicy(zoom, minLon, minLat, maxLon, maxLat, EdgeExclusive)
+}
+
+// TilesInBoundsPolicy is TilesInBounds with an explicit edge policy.
+// Bounds with minLon > maxLon are split at the antimeridian into
+// [minLon, 180] and [-180, maxLon].
+func TilesInBoundsPolicy(zoom int, minLon, minLat, maxLon, maxLat float64, policy EdgePolicy) [][3]int {
+	if minLon > maxLon {
+		tiles := TilesInBoundsPolicy(zoom, minLon, minLat, 180, maxLat, policy)
+		west := TilesInBoundsPolicy(zoom, -180, minLat, maxLon, maxLat, policy)
+		// The halves can only overlap at low zooms where one tile spans
+		// both sides; drop duplicates.
+		seen := make(map[[3]int]bool, len(tiles))
+		for _, t := range tiles {
+			seen[t] = true
+		}
+		for _, t := range west {
+			if !seen[t] {
+				tiles = append(tiles, t)
+			}
+		}
+		return tiles
+	}
 
-	var tiles [][3]int
+	minTX, minTY, maxTX, maxTY := TileRange(zoom, minLon, minLat, maxLon, maxLat, policy)
+	tiles := make([][3]int, 0, (maxTX-minTX+1)*(maxTY-minTY+1))
 	for ty := minTY; ty <= maxTY; ty++ {
 		for tx := minTX; tx <= maxTX; tx++ {
 			tiles = append(tiles, [3]int{zoom, tx, ty})
@@ -195,3 +318,36 @@ func TilesInBounds(zoom int, minLon, minLat, maxLon, maxLat float64) [][3]int {
 	}
 	return tiles
 }
+
+// ForEachTileInBounds calls fn with the coordinates of every tile of
+// TilesInBounds, in the same order, without building the list. An error
+// from fn stops the walk and is returned.
+func ForEachTileInBounds(zoom int, minLon, minLat, maxLon, maxLat float64, fn func(x, y int) error) error {
+	if minLon <= maxLon {
+		return forEachTileInRange(zoom, minLon, minLat, maxLon, maxLat, fn)
+	}
+	// Antimeridian: the east half, then the west half without the tiles
+	// the halves share at low zooms.
+	if err := forEachTileInRange(zoom, minLon, minLat, 180, maxLat, fn); err != nil {
+		return err
+	}
+	eMinX, eMinY, eMaxX, eMaxY := TileRange(zoom, minLon, minLat, 180, maxLat, EdgeExclusive)
+	return forEachTileInRange(zoom, -180, minLat, maxLon, maxLat, func(x, y int) error {
+		if x >= eMinX && x <= eMaxX && y >= eMinY && y <= eMaxY {
+			return nil
+		}
+		return fn(x, y)
+	})
+}
+
+func forEachTileInRange(zoom int, minLon, minLat, maxLon, maxLat float64, fn func(x, y int) error) error {
+	minTX, minTY, maxTX, maxTY := TileRange(zoom, minLon, minLat, maxLon, maxLat, EdgeExclusive)
+	for ty := minTY; ty <= maxTY; ty++ {
+		for tx := minTX; tx <= maxTX; tx++ {
+			if err := fn(tx, ty); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
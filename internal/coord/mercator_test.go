@@ -1,7 +1,9 @@
 package coord
 
 import (
+	"errors"
 	"math"
+	"reflect"
 	"testing"
 )
 
@@ -288,3 +290,173 @@ func TestLonLatToTile_Clamping(t *testing.T) {
 	}
 	_ = y
 }
+
+func TestTileRange_EdgePolicy(t *testing.T) {
+	tests := []struct {
+		name                   string
+		zoom                   int
+		minLon, minLat         float64
+		maxLon, maxLat         float64
+		policy                 EdgePolicy
+		minX, minY, maxX, maxY int
+	}{
+		// z2 tiles are 90° wide; [0, 90] is exactly tile x=2.
+		{"aligned exclusive", 2, 0, 0, 90, 66.51326044311186, EdgeExclusive, 2, 1, 2, 1},
+		{"aligned inclusive", 2, 0, 0, 90, 66.51326044311186, EdgeInclusive, 1, 0, 3, 2},
+		{"interior", 2, 10, 10, 80, 60, EdgeExclusive, 2, 1, 2, 1},
+		{"noise below edge", 2, 0, 0, 89.99999999999, 66.5132604431, EdgeExclusive, 2, 1, 2, 1},
+		{"noise above edge", 2, -0.00000000001, 0, 90, 66.51326044312, EdgeExclusive, 2, 1, 2, 1},
+		{"point on corner", 2, 0, 0, 0, 0, EdgeExclusive, 2, 2, 2, 2},
+		{"point on corner inclusive", 2, 0, 0, 0, 0, EdgeInclusive, 1, 1, 2, 2},
+		{"world", 3, -180, -85.0511287798, 180, 85.0511287798, EdgeExclusive, 0, 0, 7, 7},
+		{"world inclusive clamped", 3, -180, -90, 180, 90, EdgeInclusive, 0, 0, 7, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			minX, minY, maxX, maxY := TileRange(tt.zoom, tt.minLon, tt.minLat, tt.maxLon, tt.maxLat, tt.policy)
+			if minX != tt.minX || minY != tt.minY || maxX != tt.maxX || maxY != tt.maxY {
+				t.Errorf("TileRange = x[%d,%d] y[%d,%d], want x[%d,%d] y[%d,%d]",
+					minX, maxX, minY, maxY, tt.minX, tt.maxX, tt.minY, tt.maxY)
+			}
+		})
+	}
+}
+
+// TestTileRange_ExhaustiveAlignedBounds checks every tile-aligned bounding
+// box at low zooms: exclusive ranges cover exactly the enclosed tiles,
+// inclusive ranges add the touching ring (clamped to the world).
+func TestTileRange_ExhaustiveAlignedBounds(t *testing.T) {
+	for z := 0; z <= 5; z++ {
+		n := 1 << uint(z)
+		for x0 := 0; x0 < n; x0++ {
+			for x1 := x0 + 1; x1 <= n; x1++ {
+				for _, ys := range [][2]int{{0, n}, {n / 2, n/2 + 1}, {0, 1}} {
+					y0, y1 := ys[0], ys[1]
+					if y1 > n || y0 >= y1 {
+						continue
+					}
+					minLon, _ := TileCornerLonLat(z, x0, 0)
+					maxLon, _ := TileCornerLonLat(z, x1, 0)
+					_, maxLat := TileCornerLonLat(z, 0, y0)
+					_, minLat := TileCornerLonLat(z, 0, y1)
+
+					gx0, gy0, gx1, gy1 := TileRange(z, minLon, minLat, maxLon, maxLat, EdgeExclusive)
+					if gx0 != x0 || gx1 != x1-1 || gy0 != y0 || gy1 != y1-1 {
+						t.Fatalf("z%d exclusive x[%d,%d) y[%d,%d): got x[%d,%d] y[%d,%d]",
+							z, x0, x1, y0, y1, gx0, gx1, gy0, gy1)
+					}
+
+					clamp := func(v int) int {
+						if v < 0 {
+							return 0
+						}
+						if v > n-1 {
+							return n - 1
+						}
+						return v
+					}
+					gx0, gy0, gx1, gy1 = TileRange(z, minLon, minLat, maxLon, maxLat, EdgeInclusive)
+					if gx0 != clamp(x0-1) || gx1 != clamp(x1) || gy0 != clamp(y0-1) || gy1 != clamp(y1) {
+						t.Fatalf("z%d inclusive x[%d,%d) y[%d,%d): got x[%d,%d] y[%d,%d]",
+							z, x0, x1, y0, y1, gx0, gx1, gy0, gy1)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestTilesInBounds_Antimeridian(t *testing.T) {
+	// 170°E to 170°W at z3: tiles are 45° wide, so x=7 (135..180) and x=0 (-180..-135).
+	tiles := TilesInBounds(3, 170, 10, -170, 20)
+	xs := make(map[int]bool)
+	for _, tile := range tiles {
+		xs[tile[1]] = true
+	}
+	if len(xs) != 2 || !xs[7] || !xs[0] {
+		t.Errorf("antimeridian x columns = %v, want {0, 7}", xs)
+	}
+
+	// At z0 both halves map to the single world tile; no duplicates.
+	if tiles := TilesInBounds(0, 170, 10, -170, 20); len(tiles) != 1 {
+		t.Errorf("z0 antimeridian: got %d tiles, want 1", len(tiles))
+	}
+}
+
+func TestForEachTileInBounds(t *testing.T) {
+	for _, b := range [][4]float64{
+		{5.9, 45.8, 10.5, 47.8}, // Switzerland
+		{170, 10, -170, 20},     // antimeridian
+		{-180, -85, 180, 85},    // world
+	} {
+		for z := 0; z <= 6; z++ {
+			var got [][3]int
+			err := ForEachTileInBounds(z, b[0], b[1], b[2], b[3], func(x, y int) error {
+				got = append(got, [3]int{z, x, y})
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := TilesInBounds(z, b[0], b[1], b[2], b[3]); !reflect.DeepEqual(got, want) {
+				t.Errorf("bounds %v z%d: got %v, want %v", b, z, got, want)
+			}
+		}
+	}
+
+	// An error stops the walk.
+	stop := errors.New("stop")
+	n := 0
+	err := ForEachTileInBounds(4, -180, -85, 180, 85, func(x, y int) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("ForEachTileInBounds = %v after %d tiles, want stop after 1", err, n)
+	}
+}
+
+func TestClampMercatorLat(t *testing.T) {
+	tests := []struct {
+		name           string
+		minLat, maxLat float64
+		wantLo, wantHi float64
+		wantOK         bool
+	}{
+		{"inside", 40, 50, 40, 50, true},
+		{"arctic", 70, 90, 70, MaxMercatorLat, true},
+		{"global", -90, 90, -MaxMercatorLat, MaxMercatorLat, true},
+		{"point", 10, 10, 10, 10, true},
+		{"beyond north", 86, 90, 0, 0, false},
+		{"beyond south", -90, -86, 0, 0, false},
+		{"touches limit", MaxMercatorLat, 90, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lo, hi, ok := ClampMercatorLat(tt.minLat, tt.maxLat)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && (lo != tt.wantLo || hi != tt.wantHi) {
+				t.Errorf("got [%v, %v], want [%v, %v]", lo, hi, tt.wantLo, tt.wantHi)
+			}
+		})
+	}
+}
+
+func TestLonLatArea(t *testing.T) {
+	// Whole sphere: 4πr².
+	r := OriginShift / math.Pi / 1000
+	want := 4 * math.Pi * r * r
+	if got := LonLatArea(-180, -90, 180, 90); math.Abs(got-want)/want > 1e-12 {
+		t.Errorf("sphere area = %.0f, want %.0f", got, want)
+	}
+	// The polar caps beyond the Mercator limit are a small fraction (~0.37%).
+	caps := 2 * LonLatArea(-180, MaxMercatorLat, 180, 90)
+	if frac := caps / want; frac < 0.003 || frac > 0.004 {
+		t.Errorf("polar cap fraction = %.5f, want ~0.0037", frac)
+	}
+	if got := LonLatArea(10, 10, 10, 20); got != 0 {
+		t.Errorf("zero-width area = %v, want 0", got)
+	}
+}
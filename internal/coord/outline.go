@@ -0,0 +1,222 @@
+package coord
+
+import "sort"
+
+// TileRing is a closed ring of tile-grid corner coordinates (x right, y down)
+// at a single zoom level. The first point is repeated at the end.
+type TileRing [][2]int
+
+// TilePolygon is an outer ring followed by zero or more hole rings.
+type TilePolygon []TileRing
+
+// gridDirs are the unit steps east, south, west, north in tile-grid
+// coordinates, in clockwise order (y points down).
+var gridDirs = [4][2]int{{1, 0}, {0, 1}, {-1, 0}, {0, -1}}
+
+// TileOutline traces the outline of the area covered by tiles, which must all
+// be at the same zoom level. Edges shared by two tiles cancel out; the
+// remaining boundary edges are chained into rings and grouped into polygons.
+//
+// Outer rings run counter-clockwise as seen on a north-up map and holes
+// clockwise, matching the GeoJSON (RFC 7946) winding convention. Tiles
+// touching only at a corner form separate rings. Polygons are ordered by
+// their top-left corner for deterministic output.
+func TileOutline(tiles [][3]int) []TilePolygon {
+	if len(tiles) == 0 {
+		return nil
+	}
+
+	covered := make(map[[2]int]bool, len(tiles))
+	for _, t := range tiles {
+		covered[[2]int{t[1], t[2]}] = true
+	}
+
+	// Outgoing boundary edges per start vertex, as direction indices.
+	// Each tile contributes an edge for every side without a neighbour,
+	// oriented so the tile lies on the right of the edge.
+	out := make(map[[2]int][]int)
+	for c := range covered {
+		x, y := c[0], c[1]
+		if !covered[[2]int{x, y - 1}] {
+			out[[2]int{x, y}] = append(out[[2]int{x, y}], 0)
+		}
+		if !covered[[2]int{x + 1, y}] {
+			out[[2]int{x + 1, y}] = append(out[[2]int{x + 1, y}], 1)
+		}
+		if !covered[[2]int{x, y + 1}] {
+			out[[2]int{x + 1, y + 1}] = append(out[[2]int{x + 1, y + 1}], 2)
+		}
+		if !covered[[2]int{x - 1, y}] {
+			out[[2]int{x, y + 1}] = append(out[[2]int{x, y + 1}], 3)
+		}
+	}
+
+	// Visit start vertices in a fixed order so output is deterministic.
+	starts := make([][2]int, 0, len(out))
+	for v := range out {
+		starts = append(starts, v)
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		if starts[i][1] != starts[j][1] {
+			return starts[i][1] < starts[j][1]
+		}
+		return starts[i][0] < starts[j][0]
+	})
+
+	var rings []TileRing
+	for _, start := range starts {
+		for len(out[start]) > 0 {
+			rings = append(rings, traceRing(start, out))
+		}
+	}
+
+	// Split into outer rings and holes by winding, then attach each hole
+	// to the smallest outer ring containing it.
+	var polys []TilePolygon
+	var areas []int
+	var holes []TileRing
+	for _, r := range rings {
+		if a := ringArea2(r); a > 0 {
+			polys = append(polys, TilePolygon{r})
+			areas = append(areas, a)
+		} else {
+			holes = append(holes, r)
+		}
+	}
+	for _, h := range holes {
+		px, py := holeSamplePoint(h)
+		best := -1
+		for i, p := range polys {
+			if (best < 0 || areas[i] < areas[best]) && ringContains(p[0], px, py) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			polys[best] = append(polys[best], h)
+		}
+	}
+
+	// Tracing keeps tiles on the right (clockwise outer rings); reverse to
+	// the GeoJSON orientation.
+	for _, p := range polys {
+		for _, r := range p {
+			for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+				r[i], r[j] = r[j], r[i]
+			}
+		}
+	}
+	return polys
+}
+
+// traceRing follows boundary edges from start until it returns there,
+// consuming the edges it uses. At a vertex with two outgoing edges (tiles
+// touching at a corner) it takes the sharpest right turn, which keeps the
+// ring hugging the tiles on its right and so separates corner-touching
+// regions. Collinear vertices are dropped.
+func traceRing(start [2]int, out map[[2]int][]int) TileRing {
+	ring := TileRing{start}
+	v := start
+	prev := -1
+	for {
+		edges := out[v]
+		pick := 0
+		if len(edges) > 1 && prev >= 0 {
+			for i, d := range edges {
+				if turnRank(prev, d) < turnRank(prev, edges[pick]) {
+					pick = i
+				}
+			}
+		}
+		d := edges[pick]
+		edges[pick] = edges[len(edges)-1]
+		out[v] = edges[:len(edges)-1]
+		if len(out[v]) == 0 {
+			delete(out, v)
+		}
+
+		if d == prev {
+			ring = ring[:len(ring)-1] // collinear: extend the previous edge
+		}
+		v = [2]int{v[0] + gridDirs[d][0], v[1] + gridDirs[d][1]}
+		ring = append(ring, v)
+		prev = d
+		if v == start {
+			break
+		}
+	}
+	// The start vertex may itself be collinear with its neighbours.
+	if len(ring) > 3 && collinear(ring[len(ring)-2], ring[0], ring[1]) {
+		ring = append(ring[1:len(ring)-1], ring[1])
+	}
+	return ring
+}
+
+// turnRank orders a step from direction from to direction to: right turn
+// first, then straight, then left.
+func turnRank(from, to int) int {
+	switch (to - from + 4) % 4 {
+	case 1:
+		return 0 // right
+	case 0:
+		return 1 // straight
+	default:
+		return 2 // left
+	}
+}
+
+func collinear(a, b, c [2]int) bool {
+	return (b[0]-a[0])*(c[1]-b[1])-(b[1]-a[1])*(c[0]-b[0]) == 0
+}
+
+// ringArea2 returns twice the signed area of a closed ring in tile-grid
+// coordinates; positive for rings running clockwise on a north-up map.
+func ringArea2(r TileRing) int {
+	a := 0
+	for i := 0; i+1 < len(r); i++ {
+		a += r[i][0]*r[i+1][1] - r[i+1][0]*r[i][1]
+	}
+	return a
+}
+
+// holeSamplePoint returns the centre of the uncovered tile to the left of
+// the hole's first edge. The point lies strictly inside a tile cell, so it
+// is never on another ring's boundary.
+func holeSamplePoint(h TileRing) (float64, float64) {
+	dx, dy := h[1][0]-h[0][0], h[1][1]-h[0][1]
+	if dx != 0 {
+		dx /= abs(dx)
+	}
+	if dy != 0 {
+		dy /= abs(dy)
+	}
+	// Left of (dx, dy) with y pointing down is (dy, -dx).
+	return float64(h[0][0]) + 0.5*float64(dx) + 0.5*float64(dy),
+		float64(h[0][1]) + 0.5*float64(dy) - 0.5*float64(dx)
+}
+
+// ringContains reports whether (px, py) lies inside r (even-odd rule).
+func ringContains(r TileRing, px, py float64) bool {
+	in := false
+	for i := 0; i+1 < len(r); i++ {
+		x1, y1 := float64(r[i][0]), float64(r[i][1])
+		x2, y2 := float64(r[i+1][0]), float64(r[i+1][1])
+		if (y1 > py) != (y2 > py) && px < x1+(py-y1)*(x2-x1)/(y2-y1) {
+			in = !in
+		}
+	}
+	return in
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// TileCornerLonLat returns the WGS84 position of tile-grid corner (x, y) at
+// zoom z, i.e. the north-west corner of tile z/x/y.
+func TileCornerLonLat(z, x, y int) (lon, lat float64) {
+	minLon, _, _, maxLat := TileBounds(z, x, y)
+	return minLon, maxLat
+}
@@ -0,0 +1,93 @@
+package coord
+
+import (
+	"reflect"
+	"testing"
+)
+
+func tilesFromGrid(z int, rows ...string) [][3]int {
+	var tiles [][3]int
+	for y, row := range rows {
+		for x, c := range row {
+			if c == '#' {
+				tiles = append(tiles, [3]int{z, x, y})
+			}
+		}
+	}
+	return tiles
+}
+
+func TestTileOutline_Rectangle(t *testing.T) {
+	polys := TileOutline(tilesFromGrid(5,
+		"###",
+		"###",
+	))
+	want := []TilePolygon{{TileRing{{0, 0}, {0, 2}, {3, 2}, {3, 0}, {0, 0}}}}
+	if !reflect.DeepEqual(polys, want) {
+		t.Errorf("TileOutline = %v, want %v", polys, want)
+	}
+}
+
+func TestTileOutline_Hole(t *testing.T) {
+	polys := TileOutline(tilesFromGrid(5,
+		"###",
+		"#.#",
+		"###",
+	))
+	if len(polys) != 1 {
+		t.Fatalf("got %d polygons, want 1", len(polys))
+	}
+	if len(polys[0]) != 2 {
+		t.Fatalf("got %d rings, want outer + 1 hole", len(polys[0]))
+	}
+	// Counter-clockwise outer ring, clockwise hole (GeoJSON winding).
+	if a := ringArea2(polys[0][0]); a != -2*9 {
+		t.Errorf("outer area*2 = %d, want -18", a)
+	}
+	if a := ringArea2(polys[0][1]); a != 2 {
+		t.Errorf("hole area*2 = %d, want 2", a)
+	}
+}
+
+func TestTileOutline_CornerTouchingTilesStaySeparate(t *testing.T) {
+	polys := TileOutline(tilesFromGrid(5,
+		"#.",
+		".#",
+	))
+	if len(polys) != 2 {
+		t.Fatalf("got %d polygons, want 2", len(polys))
+	}
+	for _, p := range polys {
+		if len(p) != 1 || len(p[0]) != 5 {
+			t.Errorf("polygon %v: want a single 4-corner ring", p)
+		}
+	}
+}
+
+func TestTileOutline_LShapeAreaMatchesTileCount(t *testing.T) {
+	tiles := tilesFromGrid(6,
+		"#....",
+		"#....",
+		"#####",
+		"..#..",
+	)
+	polys := TileOutline(tiles)
+	total := 0
+	for _, p := range polys {
+		for _, r := range p {
+			if r[0] != r[len(r)-1] {
+				t.Errorf("ring %v is not closed", r)
+			}
+			total -= ringArea2(r)
+		}
+	}
+	if total != 2*len(tiles) {
+		t.Errorf("outline area*2 = %d, want %d", total, 2*len(tiles))
+	}
+}
+
+func TestTileOutline_Empty(t *testing.T) {
+	if polys := TileOutline(nil); polys != nil {
+		t.Errorf("TileOutline(nil) = %v, want nil", polys)
+	}
+}
@@ -12,7 +12,13 @@ type Projection interface {
 	EPSG() int
 }
 
-// ForEPSG returns a Projection for the given EPSG code.
+// UserDefined is the GeoTIFF code for a CRS given by its parameters instead
+// of an EPSG code. Projections built from such a definition report it as
+// their EPSG code.
+const UserDefined = 32767
+
+// ForEPSG returns a Projection for the given EPSG code: one of the built-in
+// ones, or a parameterized projection from the EPSG table (see epsg.go).
 // Returns nil if the EPSG code is not supported.
 func ForEPSG(epsg int) Projection {
 	switch epsg {
@@ -22,9 +28,11 @@ func ForEPSG(epsg int) Projection {
 		return &WGS84Identity{}
 	case 3857:
 		return &WebMercatorProj{}
-	default:
-		return nil
 	}
+	if def, ok := lookupEPSG(epsg); ok {
+		return def.build(epsg)
+	}
+	return nil
 }
 
 // WGS84Identity is a no-op projection for data already in EPSG:4326.
@@ -14,7 +14,10 @@ func TestForEPSG(t *testing.T) {
 		{2056, false, 2056},
 		{4326, false, 4326},
 		{3857, false, 3857},
-		{32632, true, 0}, // UTM 32N — unsupported
+		{32632, false, 32632}, // WGS 84 / UTM 32N
+		{25832, false, 25832}, // ETRS89 / UTM 32N
+		{3035, false, 3035},   // LAEA Europe
+		{31467, true, 0},      // DHDN: needs a datum shift — unsupported
 		{0, true, 0},
 	}
 	for _, tt := range tests {
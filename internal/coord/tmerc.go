@@ -0,0 +1,95 @@
+package coord
+
+import "math"
+
+// TransverseMercator implements the Projection interface for Transverse
+// Mercator CRSs such as UTM (EPSG method 9807). It uses Krüger's series to
+// the fourth order in n, accurate to about a millimeter within 4000 km of
+// the central meridian, far beyond a UTM zone.
+//
+// Reference: C. F. F. Karney, "Transverse Mercator with an accuracy of a few
+// nanometers", J. Geodesy 85 (2011).
+type TransverseMercator struct {
+	code                int
+	lon0, k0, fe, fn, a float64 // a is the rectifying radius A
+	e, xi0              float64
+	alpha, beta         [4]float64
+	e2                  float64
+}
+
+// NewTransverseMercator returns a Transverse Mercator projection with the
+// given EPSG code and parameters: origin latitude and central meridian in
+// degrees, scale factor, and false easting/northing in meters.
+func NewTransverseMercator(epsg int, el Ellipsoid, lat0, lon0, k0, falseEasting, falseNorthing float64) *TransverseMercator {
+	f := 1 / el.InvF
+	n := f / (2 - f)
+	n2, n3, n4 := n*n, n*n*n, n*n*n*n
+	p := &TransverseMercator{
+		code: epsg,
+		lon0: radians(lon0),
+		k0:   k0,
+		fe:   falseEasting,
+		fn:   falseNorthing,
+		a:    el.A / (1 + n) * (1 + n2/4 + n4/64),
+		e2:   el.e2(),
+		alpha: [4]float64{
+			n/2 - 2*n2/3 + 5*n3/16 + 41*n4/180,
+			13*n2/48 - 3*n3/5 + 557*n4/1440,
+			61*n3/240 - 103*n4/140,
+			49561 * n4 / 161280,
+		},
+		beta: [4]float64{
+			n/2 - 2*n2/3 + 37*n3/96 - n4/360,
+			n2/48 + n3/15 - 437*n4/1440,
+			17*n3/480 - 37*n4/840,
+			4397 * n4 / 161280,
+		},
+	}
+	p.e = math.Sqrt(p.e2)
+	p.xi0, _ = p.forward(radians(lat0), 0)
+	return p
+}
+
+func (p *TransverseMercator) EPSG() int { return p.code }
+
+// forward returns the normalized northing ξ and easting η of (φ, Δλ).
+func (p *TransverseMercator) forward(phi, dLon float64) (xi, eta float64) {
+	sinPhi := math.Sin(phi)
+	t := math.Sinh(math.Atanh(sinPhi) - p.e*math.Atanh(p.e*sinPhi))
+	xi1 := math.Atan2(t, math.Cos(dLon))
+	eta1 := math.Atanh(math.Sin(dLon) / math.Sqrt(1+t*t))
+	xi, eta = xi1, eta1
+	for j, a := range p.alpha {
+		k := float64(2 * (j + 1))
+		xi += a * math.Sin(k*xi1) * math.Cosh(k*eta1)
+		eta += a * math.Cos(k*xi1) * math.Sinh(k*eta1)
+	}
+	return xi, eta
+}
+
+// FromWGS84 converts WGS84 longitude/latitude (degrees) to easting/northing.
+func (p *TransverseMercator) FromWGS84(lon, lat float64) (x, y float64) {
+	xi, eta := p.forward(radians(lat), radians(lon)-p.lon0)
+	return p.fe + p.k0*p.a*eta, p.fn + p.k0*p.a*(xi-p.xi0)
+}
+
+// ToWGS84 converts easting/northing to WGS84 longitude/latitude (degrees).
+func (p *TransverseMercator) ToWGS84(x, y float64) (lon, lat float64) {
+	xi := (y-p.fn)/(p.k0*p.a) + p.xi0
+	eta := (x - p.fe) / (p.k0 * p.a)
+	xi1, eta1 := xi, eta
+	for j, b := range p.beta {
+		k := float64(2 * (j + 1))
+		xi1 -= b * math.Sin(k*xi) * math.Cosh(k*eta)
+		eta1 -= b * math.Cos(k*xi) * math.Sinh(k*eta)
+	}
+	chi := math.Asin(math.Sin(xi1) / math.Cosh(eta1))
+	e2 := p.e2
+	e4, e6, e8 := e2*e2, e2*e2*e2, e2*e2*e2*e2
+	phi := chi +
+		(e2/2+5*e4/24+e6/12+13*e8/360)*math.Sin(2*chi) +
+		(7*e4/48+29*e6/240+811*e8/11520)*math.Sin(4*chi) +
+		(7*e6/120+81*e8/1120)*math.Sin(6*chi) +
+		(4279*e8/161280)*math.Sin(8*chi)
+	return degrees(p.lon0 + math.Atan2(math.Sinh(eta1), math.Cos(xi1))), degrees(phi)
+}
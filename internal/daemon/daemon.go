@@ -0,0 +1,330 @@
+// Package daemon runs conversion jobs one after another against a set of
+// sources that stays open between jobs. Jobs are submitted over a small JSON
+// API on a TCP address or a Unix socket, or read from a batch file
+// (LoadJobs), so batch pipelines that cut many extracts from the same large
+// mosaic pay for opening the sources (and warm up the COG tile cache) only
+// once.
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job describes one extract to generate. Zero values fall back to the
+// daemon's defaults (the settings it was started with).
+type Job struct {
+	Output  string      `json:"output"`             // .pmtiles path to write (required)
+	Bounds  *[4]float64 `json:"bounds,omitempty"`   // minLon, minLat, maxLon, maxLat (default: all sources)
+	MinZoom *int        `json:"min_zoom,omitempty"` // default: auto from Bounds
+	MaxZoom *int        `json:"max_zoom,omitempty"` // default: the daemon's max zoom
+	Format  string      `json:"format,omitempty"`   // default: the daemon's format
+	Quality int         `json:"quality,omitempty"`  // default: the daemon's quality
+	Name    string      `json:"name,omitempty"`     // metadata name (default: the daemon's --name)
+	Version string      `json:"version,omitempty"`  // metadata version (default: the daemon's --tileset-version)
+	LayerID string      `json:"layer_id,omitempty"` // metadata id (default: the daemon's --layer-id)
+}
+
+// Validate checks the fields that do not depend on the sources.
+func (j Job) Validate() error {
+	if !strings.HasSuffix(j.Output, ".pmtiles") {
+		return fmt.Errorf("output must be a .pmtiles path, got %q", j.Output)
+	}
+	if b := j.Bounds; b != nil {
+		if b[0] >= b[2] || b[1] >= b[3] {
+			return fmt.Errorf("bounds must be minLon,minLat,maxLon,maxLat with min < max, got %v", *b)
+		}
+	}
+	if j.MinZoom != nil && j.MaxZoom != nil && *j.MinZoom > *j.MaxZoom {
+		return fmt.Errorf("min_zoom %d > max_zoom %d", *j.MinZoom, *j.MaxZoom)
+	}
+	if j.Quality < 0 || j.Quality > 100 {
+		return fmt.Errorf("quality must be 1-100, got %d", j.Quality)
+	}
+	return nil
+}
+
+// LoadJobs reads a batch file (--batch): a JSON array of jobs, each
+// validated, with no two writing the same output.
+func LoadJobs(path string) ([]Job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []Job
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&jobs); err != nil {
+		return nil, fmt.Errorf("%s: %v (want a JSON array of jobs)", path, err)
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("%s: no jobs", path)
+	}
+	outputs := make(map[string]int, len(jobs))
+	for i, j := range jobs {
+		if err := j.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: job %d: %v", path, i+1, err)
+		}
+		if prev, dup := outputs[j.Output]; dup {
+			return nil, fmt.Errorf("%s: jobs %d and %d both write %s", path, prev, i+1, j.Output)
+		}
+		outputs[j.Output] = i + 1
+	}
+	return jobs, nil
+}
+
+// Result summarises a finished job.
+type Result struct {
+	Tiles int64 `json:"tiles"`
+	Bytes int64 `json:"bytes"` // size of the output archive
+}
+
+// Runner generates the archive for one job. Runs are never concurrent.
+type Runner func(Job) (Result, error)
+
+// State is a job's position in its lifecycle.
+type State string
+
+const (
+	StateQueued  State = "queued"
+	StateRunning State = "running"
+	StateDone    State = "done"
+	StateFailed  State = "failed"
+)
+
+// Status is the externally visible state of a submitted job.
+type Status struct {
+	ID       int       `json:"id"`
+	State    State     `json:"state"`
+	Job      Job       `json:"job"`
+	Result   *Result   `json:"result,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Queued   time.Time `json:"queued"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+}
+
+// queueSize bounds the number of jobs waiting to run; further submissions
+// are rejected with ErrQueueFull rather than buffered without limit.
+const queueSize = 256
+
+// ErrQueueFull is returned by Submit when queueSize jobs are already waiting.
+var ErrQueueFull = errors.New("job queue is full")
+
+// Daemon queues jobs and runs them sequentially with its Runner.
+type Daemon struct {
+	run     Runner
+	verbose bool
+
+	queue chan int
+
+	mu     sync.Mutex
+	nextID int
+	jobs   map[int]*Status
+}
+
+// New creates a Daemon that executes jobs with run.
+func New(run Runner, verbose bool) *Daemon {
+	return &Daemon{
+		run:     run,
+		verbose: verbose,
+		queue:   make(chan int, queueSize),
+		jobs:    make(map[int]*Status),
+	}
+}
+
+// Submit validates and queues a job, returning its initial status.
+func (d *Daemon) Submit(j Job) (Status, error) {
+	if err := j.Validate(); err != nil {
+		return Status{}, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nextID++
+	st := &Status{ID: d.nextID, State: StateQueued, Job: j, Queued: time.Now()}
+	select {
+	case d.queue <- st.ID:
+	default:
+		d.nextID--
+		return Status{}, ErrQueueFull
+	}
+	d.jobs[st.ID] = st
+	return *st, nil
+}
+
+// Status returns a snapshot of job id.
+func (d *Daemon) Status(id int) (Status, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	st, ok := d.jobs[id]
+	if !ok {
+		return Status{}, false
+	}
+	return *st, true
+}
+
+// List returns snapshots of all jobs, oldest first.
+func (d *Daemon) List() []Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]Status, 0, len(d.jobs))
+	for _, st := range d.jobs {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Work runs queued jobs until ctx is cancelled. A job that is running when
+// ctx is cancelled is finished first; jobs still queued stay queued.
+func (d *Daemon) Work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-d.queue:
+			d.runJob(id)
+		}
+	}
+}
+
+func (d *Daemon) runJob(id int) {
+	d.mu.Lock()
+	st := d.jobs[id]
+	st.State = StateRunning
+	st.Started = time.Now()
+	job := st.Job
+	d.mu.Unlock()
+
+	log.Printf("Job %d: %s", id, job.Output)
+	res, err := d.run(job)
+
+	d.mu.Lock()
+	st.Finished = time.Now()
+	if err != nil {
+		st.State = StateFailed
+		st.Error = err.Error()
+	} else {
+		st.State = StateDone
+		st.Result = &res
+	}
+	elapsed := st.Finished.Sub(st.Started).Round(time.Millisecond)
+	d.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Job %d failed after %v: %v", id, elapsed, err)
+	} else if d.verbose {
+		log.Printf("Job %d done: %d tiles in %v → %s", id, res.Tiles, elapsed, job.Output)
+	}
+}
+
+// ServeHTTP implements the job API:
+//
+//	POST /jobs       submit a Job (JSON body); 202 with its Status
+//	GET  /jobs       list all job statuses
+//	GET  /jobs/{id}  one job's status
+func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	switch {
+	case path == "jobs" && r.Method == http.MethodPost:
+		var j Job
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&j); err != nil {
+			http.Error(w, "invalid job: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		st, err := d.Submit(j)
+		if errors.Is(err, ErrQueueFull) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, st)
+	case path == "jobs" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, d.List())
+	case strings.HasPrefix(path, "jobs/") && r.Method == http.MethodGet:
+		id, err := strconv.Atoi(strings.TrimPrefix(path, "jobs/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		st, ok := d.Status(id)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, st)
+	case path == "jobs" || strings.HasPrefix(path, "jobs/"):
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Listen opens addr, which is either a TCP address (":8090") or a Unix
+// socket path prefixed with "unix:" ("unix:/tmp/g2p.sock"). A stale socket
+// file left by a previous run is removed first.
+func Listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("removing stale socket %s: %w", path, err)
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Run serves the job API on ln and runs jobs until ctx is cancelled, then
+// stops accepting requests and waits for the running job to finish.
+func (d *Daemon) Run(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{Handler: d}
+
+	errCh := make(chan error, 1)
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+
+	workCtx, stopWork := context.WithCancel(context.Background())
+	workDone := make(chan struct{})
+	go func() {
+		d.Work(workCtx)
+		close(workDone)
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		srv.Shutdown(shutdownCtx)
+		cancel()
+	}
+	stopWork()
+	<-workDone
+	return err
+}
@@ -0,0 +1,223 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitState(t *testing.T, d *Daemon, id int, want State) Status {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if st, _ := d.Status(id); st.State == want {
+			return st
+		}
+		time.Sleep(time.Millisecond)
+	}
+	st, _ := d.Status(id)
+	t.Fatalf("job %d: state %q, want %q", id, st.State, want)
+	return st
+}
+
+func TestDaemon_RunsJobsSequentially(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	d := New(func(j Job) (Result, error) {
+		n := running.Add(1)
+		if n > maxRunning.Load() {
+			maxRunning.Store(n)
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+		if j.Output == "fail.pmtiles" {
+			return Result{}, errors.New("boom")
+		}
+		return Result{Tiles: 7}, nil
+	}, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Work(ctx)
+
+	var ids []int
+	for _, out := range []string{"a.pmtiles", "fail.pmtiles", "b.pmtiles"} {
+		st, err := d.Submit(Job{Output: out})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, st.ID)
+	}
+
+	if st := waitState(t, d, ids[0], StateDone); st.Result == nil || st.Result.Tiles != 7 {
+		t.Errorf("job a: result %+v, want 7 tiles", st.Result)
+	}
+	if st := waitState(t, d, ids[1], StateFailed); st.Error != "boom" {
+		t.Errorf("job fail: error %q, want boom", st.Error)
+	}
+	waitState(t, d, ids[2], StateDone)
+	if m := maxRunning.Load(); m != 1 {
+		t.Errorf("max concurrent jobs = %d, want 1", m)
+	}
+	if n := len(d.List()); n != 3 {
+		t.Errorf("List() = %d jobs, want 3", n)
+	}
+}
+
+func TestJob_Validate(t *testing.T) {
+	zero, two := 0, 2
+	tests := []struct {
+		name string
+		job  Job
+		ok   bool
+	}{
+		{"minimal", Job{Output: "x.pmtiles"}, true},
+		{"bounds", Job{Output: "x.pmtiles", Bounds: &[4]float64{5, 45, 6, 46}}, true},
+		{"no output", Job{}, false},
+		{"wrong extension", Job{Output: "x.mbtiles"}, false},
+		{"inverted bounds", Job{Output: "x.pmtiles", Bounds: &[4]float64{6, 45, 5, 46}}, false},
+		{"inverted zooms", Job{Output: "x.pmtiles", MinZoom: &two, MaxZoom: &zero}, false},
+		{"bad quality", Job{Output: "x.pmtiles", Quality: 101}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.job.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestLoadJobs(t *testing.T) {
+	write := func(body string) string {
+		path := filepath.Join(t.TempDir(), "jobs.json")
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	jobs, err := LoadJobs(write(`[
+		{"output": "west.pmtiles", "bounds": [5, 45, 7, 47], "max_zoom": 10},
+		{"output": "east.pmtiles", "bounds": [7, 45, 9, 47], "format": "png"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].Output != "west.pmtiles" || *jobs[0].MaxZoom != 10 || jobs[1].Format != "png" {
+		t.Errorf("jobs = %+v", jobs)
+	}
+
+	for _, bad := range []string{
+		`[]`,
+		`{"output": "x.pmtiles"}`,
+		`[{"output": "x.pmtiles", "maxzoom": 3}]`,
+		`[{"output": "x.mbtiles"}]`,
+		`[{"output": "x.pmtiles"}, {"output": "x.pmtiles"}]`,
+	} {
+		if _, err := LoadJobs(write(bad)); err == nil {
+			t.Errorf("LoadJobs(%s): expected error", bad)
+		}
+	}
+}
+
+func TestDaemon_HTTP(t *testing.T) {
+	d := New(func(Job) (Result, error) { return Result{Tiles: 1}, nil }, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Work(ctx)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		d.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", bytes.NewBufferString(body)))
+		return rec
+	}
+
+	rec := post(`{"output":"out.pmtiles","bounds":[5,45,6,46],"max_zoom":10}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("POST /jobs = %d: %s", rec.Code, rec.Body)
+	}
+	var st Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Job.MaxZoom == nil || *st.Job.MaxZoom != 10 {
+		t.Errorf("max_zoom not decoded: %+v", st.Job)
+	}
+	waitState(t, d, st.ID, StateDone)
+
+	if rec := post(`{"output":"out.pmtiles","maxzoom":10}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown field: got %d, want 400", rec.Code)
+	}
+	if rec := post(`{"output":"out.tif"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid job: got %d, want 400", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	d.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/1", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /jobs/1 = %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	d.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/99", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /jobs/99 = %d, want 404", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	d.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/jobs/1", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE /jobs/1 = %d, want 405", rec.Code)
+	}
+}
+
+func TestDaemon_QueueFull(t *testing.T) {
+	d := New(func(Job) (Result, error) { return Result{}, nil }, false) // no worker
+	for i := 0; i < queueSize; i++ {
+		if _, err := d.Submit(Job{Output: "x.pmtiles"}); err != nil {
+			t.Fatalf("submit %d: %v", i, err)
+		}
+	}
+	if _, err := d.Submit(Job{Output: "x.pmtiles"}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("submit beyond queue: err = %v, want ErrQueueFull", err)
+	}
+}
+
+func TestDaemon_RunOverUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "d.sock")
+	ln, err := Listen("unix:" + sock)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	d := New(func(Job) (Result, error) { return Result{Tiles: 3}, nil }, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx, ln) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", sock)
+		},
+	}}
+	resp, err := client.Post("http://daemon/jobs", "application/json",
+		bytes.NewBufferString(`{"output":"x.pmtiles"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("POST /jobs = %d", resp.StatusCode)
+	}
+	waitState(t, d, 1, StateDone)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run: %v", err)
+	}
+}
@@ -52,6 +52,20 @@ func BenchmarkPNGEncode(b *testing.B) {
 	}
 }
 
+// BenchmarkPNGEncodeTo measures PNG encoding into a reused buffer, as the
+// generator does for tiles that are only written. Compare allocs/op with
+// BenchmarkPNGEncode.
+func BenchmarkPNGEncodeTo(b *testing.B) {
+	enc := &PNGEncoder{}
+	img := gradientImage(256)
+	var buf []byte
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _ = enc.EncodeTo(buf[:0], img)
+	}
+}
+
 // BenchmarkPNGEncode_Gray measures PNG encoding of a gray (single-channel)
 // image, which is the common case for classification rasters.
 func BenchmarkPNGEncode_Gray(b *testing.B) {
@@ -141,3 +155,20 @@ func BenchmarkTerrariumToElevation(b *testing.B) {
 	}
 	_ = sink
 }
+
+// BenchmarkPNGEncode_GrayNative measures PNG encoding of an *image.Gray
+// (8-bit gray PNG), as produced by TileData.AsImage for gray tiles.
+func BenchmarkPNGEncode_GrayNative(b *testing.B) {
+	enc := &PNGEncoder{}
+	img := image.NewGray(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8((x + y) % 256)})
+		}
+	}
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = enc.Encode(img)
+	}
+}
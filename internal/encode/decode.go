@@ -9,10 +9,11 @@ import (
 )
 
 // DecodeImage decodes image bytes in the specified format back to an image.Image.
-// Supported formats: "png", "terrarium" (PNG-encoded), "jpeg"/"jpg", "webp".
+// Supported formats: "png", "terrarium" and "terrainrgb" (PNG-encoded),
+// "jpeg"/"jpg", "webp".
 func DecodeImage(data []byte, format string) (image.Image, error) {
 	switch format {
-	case "png", "terrarium":
+	case "png", "terrarium", "terrainrgb":
 		return png.Decode(bytes.NewReader(data))
 	case "jpeg", "jpg":
 		return jpeg.Decode(bytes.NewReader(data))
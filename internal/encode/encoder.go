@@ -3,6 +3,7 @@ package encode
 import (
 	"fmt"
 	"image"
+	"strconv"
 )
 
 // TileType constants matching PMTiles v3 spec.
@@ -17,7 +18,13 @@ const (
 
 // Encoder encodes an image into tile bytes.
 type Encoder interface {
-	// Encode encodes an image to bytes in the tile format.
+	// EncodeTo appends the encoded tile to dst and returns the extended
+	// slice, like the strconv.Append functions. Passing a reused buffer
+	// (buf[:0]) or one pre-sized from a size hint avoids growing and
+	// copying the output while it is written.
+	EncodeTo(dst []byte, img image.Image) ([]byte, error)
+
+	// Encode is EncodeTo(nil, img): the encoded tile in a new slice.
 	Encode(img image.Image) ([]byte, error)
 
 	// Format returns the format name (e.g. "jpeg", "png", "webp").
@@ -30,10 +37,27 @@ type Encoder interface {
 	FileExtension() string
 }
 
-// NewEncoder creates an encoder for the given format and quality.
+// Lossless is the quality value that selects lossless WebP encoding
+// (--quality lossless). It sorts above every lossy quality.
+const Lossless = 101
+
+// QualityName formats a quality for summaries and metadata: the number, or
+// "lossless".
+func QualityName(quality int) string {
+	if quality == Lossless {
+		return "lossless"
+	}
+	return strconv.Itoa(quality)
+}
+
+// NewEncoder creates an encoder for the given format and quality, 1-100 or
+// Lossless (WebP only; PNG ignores quality).
 func NewEncoder(format string, quality int) (Encoder, error) {
 	switch format {
 	case "jpeg", "jpg":
+		if quality == Lossless {
+			return nil, fmt.Errorf("jpeg has no lossless mode (use webp or png)")
+		}
 		return &JPEGEncoder{Quality: quality}, nil
 	case "png":
 		return &PNGEncoder{}, nil
@@ -41,7 +65,59 @@ func NewEncoder(format string, quality int) (Encoder, error) {
 		return newWebPEncoder(quality)
 	case "terrarium":
 		return &TerrariumEncoder{}, nil
+	case "terrainrgb":
+		return &TerrainRGBEncoder{}, nil
 	default:
-		return nil, fmt.Errorf("unsupported tile format: %q (supported: jpeg, png, webp, terrarium)", format)
+		return nil, fmt.Errorf("unsupported tile format: %q (supported: jpeg, png, webp, terrarium, terrainrgb)", format)
 	}
 }
+
+// qualityEncoder is implemented by the encoders with a quality setting.
+type qualityEncoder interface {
+	quality() int
+	withQuality(q int) Encoder
+}
+
+// QualityOf returns the quality of enc, 1-100 or Lossless, and false for
+// encoders without one (PNG, Terrarium, Terrain-RGB).
+func QualityOf(enc Encoder) (int, bool) {
+	switch w := enc.(type) {
+	case *flattenEncoder:
+		return QualityOf(w.Encoder)
+	case *premultiplyEncoder:
+		return QualityOf(w.Encoder)
+	}
+	if q, ok := enc.(qualityEncoder); ok {
+		return q.quality(), true
+	}
+	return 0, false
+}
+
+// WithQuality returns an encoder like enc at quality q, and false for
+// encoders without a quality.
+func WithQuality(enc Encoder, q int) (Encoder, bool) {
+	switch w := enc.(type) {
+	case *flattenEncoder:
+		inner, ok := WithQuality(w.Encoder, q)
+		if !ok {
+			return nil, false
+		}
+		return &flattenEncoder{Encoder: inner, bg: w.bg}, true
+	case *premultiplyEncoder:
+		inner, ok := WithQuality(w.Encoder, q)
+		if !ok {
+			return nil, false
+		}
+		return &premultiplyEncoder{Encoder: inner}, true
+	}
+	if qe, ok := enc.(qualityEncoder); ok {
+		return qe.withQuality(q), true
+	}
+	return nil, false
+}
+
+// WebPAvailable reports whether the binary was built with the native libwebp
+// encoder and decoder (CGO_ENABLED=1 with libwebp-dev installed).
+func WebPAvailable() bool {
+	return webpCGOAvailable
+}
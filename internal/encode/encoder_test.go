@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"image"
 	"image/color"
+	"image/draw"
 	"image/jpeg"
 	"image/png"
+	"math"
 	"testing"
 )
 
@@ -37,6 +39,7 @@ func TestNewEncoder(t *testing.T) {
 		{"jpg", "jpeg", TileTypeJPEG, ".jpg", false},
 		{"png", "png", TileTypePNG, ".png", false},
 		{"webp", "webp", TileTypeWebP, ".webp", false},
+		{"terrainrgb", "terrainrgb", TileTypePNG, ".png", false},
 		{"bmp", "", 0, "", true},
 		{"", "", 0, "", true},
 	}
@@ -69,6 +72,64 @@ func TestNewEncoder(t *testing.T) {
 	}
 }
 
+func TestNewEncoder_Lossless(t *testing.T) {
+	if _, err := NewEncoder("jpeg", Lossless); err == nil {
+		t.Error("lossless jpeg: expected an error")
+	}
+	if _, err := NewEncoder("png", Lossless); err != nil {
+		t.Errorf("png ignores quality, got %v", err)
+	}
+	if got := QualityName(Lossless); got != "lossless" {
+		t.Errorf("QualityName(Lossless) = %q", got)
+	}
+	if got := QualityName(85); got != "85" {
+		t.Errorf("QualityName(85) = %q", got)
+	}
+	if !webpCGOAvailable {
+		t.Skip("webp encoder requires CGO with libwebp")
+	}
+
+	enc, err := NewEncoder("webp", Lossless)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := testImage(64)
+	data, err := enc.Encode(img)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	decoded, err := DecodeWebP(data)
+	if err != nil {
+		t.Fatalf("DecodeWebP: %v", err)
+	}
+	if !bytes.Equal(decoded.(*image.RGBA).Pix, img.Pix) {
+		t.Error("lossless WebP did not round-trip the pixels exactly")
+	}
+}
+
+func TestWithQuality(t *testing.T) {
+	jpg := Flatten(&JPEGEncoder{Quality: 90}, color.RGBA{255, 255, 255, 255})
+	if q, ok := QualityOf(jpg); !ok || q != 90 {
+		t.Errorf("QualityOf(flattened jpeg) = %d, %v, want 90, true", q, ok)
+	}
+	lower, ok := WithQuality(jpg, 40)
+	if !ok {
+		t.Fatal("WithQuality(flattened jpeg) not supported")
+	}
+	if q, _ := QualityOf(lower); q != 40 {
+		t.Errorf("quality after WithQuality = %d, want 40", q)
+	}
+	if _, ok := lower.(*flattenEncoder); !ok {
+		t.Error("WithQuality dropped the background")
+	}
+	if _, ok := QualityOf(&PNGEncoder{}); ok {
+		t.Error("QualityOf(png) reported a quality")
+	}
+	if _, ok := WithQuality(Flatten(&PNGEncoder{}, color.RGBA{}), 40); ok {
+		t.Error("WithQuality(flattened png) succeeded")
+	}
+}
+
 func TestPNGEncoder_RoundTrip(t *testing.T) {
 	enc := &PNGEncoder{}
 	img := testImage(256)
@@ -213,3 +274,347 @@ func TestPNGEncoder_TransparentImage(t *testing.T) {
 		t.Errorf("transparent pixel alpha = %d, want 0", a>>8)
 	}
 }
+
+// grayTestImage creates a single-channel gradient image.
+func grayTestImage(size int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8((x*3 + y*5) % 256)})
+		}
+	}
+	return img
+}
+
+// grayAsRGBA expands a gray image to RGBA (R=G=B, A=255).
+func grayAsRGBA(g *image.Gray) *image.RGBA {
+	b := g.Bounds()
+	img := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			v := g.GrayAt(x, y).Y
+			img.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func TestGrayEncoding_NativeSingleChannel(t *testing.T) {
+	gray := grayTestImage(256)
+	rgba := grayAsRGBA(gray)
+
+	tests := []struct {
+		name string
+		enc  Encoder
+	}{
+		{"jpeg", &JPEGEncoder{Quality: 85}},
+		{"png", &PNGEncoder{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grayData, err := tt.enc.Encode(gray)
+			if err != nil {
+				t.Fatalf("Encode(gray): %v", err)
+			}
+			rgbaData, err := tt.enc.Encode(rgba)
+			if err != nil {
+				t.Fatalf("Encode(rgba): %v", err)
+			}
+
+			decoded, err := DecodeImage(grayData, tt.name)
+			if err != nil {
+				t.Fatalf("DecodeImage: %v", err)
+			}
+			if _, ok := decoded.(*image.Gray); !ok {
+				t.Errorf("decoded gray %s is %T, want *image.Gray", tt.name, decoded)
+			}
+			if len(grayData) >= len(rgbaData) {
+				t.Errorf("gray %s (%d bytes) not smaller than RGBA (%d bytes)", tt.name, len(grayData), len(rgbaData))
+			}
+		})
+	}
+}
+
+func TestTerrariumEncoder_GrayInputStaysRGB(t *testing.T) {
+	data, err := (&TerrariumEncoder{}).Encode(grayTestImage(16))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+	if _, ok := decoded.(*image.Gray); ok {
+		t.Error("terrarium output decoded as *image.Gray, want RGB(A)")
+	}
+}
+
+func TestTerrainRGB_RoundTrip(t *testing.T) {
+	for _, h := range []float64{-10000, -412.3, 0, 1234.5, 8848.9} {
+		c := ElevationToTerrainRGB(h)
+		if c.A != 255 {
+			t.Fatalf("%v m: alpha %d, want 255", h, c.A)
+		}
+		if got := TerrainRGBToElevation(c); math.Abs(got-h) > 0.05+1e-9 {
+			t.Errorf("%v m round-trips to %v", h, got)
+		}
+	}
+	// 1234.5 m is (1234.5+10000)*10 = 112345 = 0x01B6D9.
+	if c := ElevationToTerrainRGB(1234.5); c != (color.RGBA{0x01, 0xB6, 0xD9, 255}) {
+		t.Errorf("1234.5 m = %v, want {1 182 217 255}", c)
+	}
+	if c := ElevationToTerrainRGB(math.NaN()); c.A != 0 {
+		t.Errorf("NaN: alpha %d, want 0 (nodata)", c.A)
+	}
+	if !math.IsNaN(TerrainRGBToElevation(color.RGBA{})) {
+		t.Error("transparent pixel should decode to NaN")
+	}
+	if got := TerrainRGBToElevation(ElevationToTerrainRGB(-20000)); got != -10000 {
+		t.Errorf("-20000 m clamps to %v, want -10000", got)
+	}
+	if c := ElevationToTerrainRGB(1e9); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("1e9 m = %v, want the largest value", c)
+	}
+}
+
+func TestDEMEncodingFor(t *testing.T) {
+	for _, tt := range []struct {
+		format string
+		want   DEMEncoding
+		ok     bool
+	}{
+		{"terrarium", Terrarium, true},
+		{"terrainrgb", TerrainRGB, true},
+		{"png", Terrarium, false},
+	} {
+		got, ok := DEMEncodingFor(tt.format)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("DEMEncodingFor(%q) = %v, %v, want %v, %v", tt.format, got, ok, tt.want, tt.ok)
+		}
+		if ok && (got.Format() != tt.format || got.Encoder().Format() != tt.format) {
+			t.Errorf("%q: Format() = %q, Encoder().Format() = %q", tt.format, got.Format(), got.Encoder().Format())
+		}
+	}
+}
+
+func TestFlattenImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(0, 0, color.RGBA{0, 0, 0, 0})     // transparent
+	img.SetRGBA(1, 0, color.RGBA{100, 0, 0, 128}) // half-transparent red, premultiplied
+	white := color.RGBA{255, 255, 255, 255}
+
+	out := FlattenImage(img, white).(*image.RGBA)
+	if c := out.RGBAAt(0, 0); c != white {
+		t.Errorf("transparent pixel = %v, want %v", c, white)
+	}
+	c := out.RGBAAt(1, 0)
+	if c.A != 255 || c.R < 225 || c.G < 120 || c.G > 135 {
+		t.Errorf("half-transparent pixel = %v, want ~{227 127 127 255}", c)
+	}
+	if img.RGBAAt(0, 0).A != 0 {
+		t.Error("FlattenImage modified its input")
+	}
+
+	opaque := testImage(4)
+	if FlattenImage(opaque, white) != image.Image(opaque) {
+		t.Error("opaque image should be returned unchanged")
+	}
+}
+
+func TestFlatten_JPEGBackground(t *testing.T) {
+	enc := Flatten(&JPEGEncoder{Quality: 90}, color.RGBA{255, 255, 255, 0})
+	if enc.Format() != "jpeg" {
+		t.Errorf("Format() = %q, want jpeg", enc.Format())
+	}
+	data, err := enc.Encode(image.NewRGBA(image.Rect(0, 0, 16, 16)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := out.At(8, 8).RGBA(); r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+		t.Errorf("transparent tile encoded as (%d,%d,%d), want white", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestEncodeTo_AppendsAndMatchesEncode(t *testing.T) {
+	img := testImage(64)
+	for _, enc := range []Encoder{
+		&PNGEncoder{},
+		&JPEGEncoder{Quality: 80},
+		&TerrariumEncoder{},
+		Flatten(&JPEGEncoder{Quality: 80}, color.RGBA{255, 255, 255, 255}),
+	} {
+		want, err := enc.Encode(img)
+		if err != nil {
+			t.Fatalf("%s: Encode: %v", enc.Format(), err)
+		}
+		prefix := []byte("prefix")
+		dst := make([]byte, len(prefix), 8)
+		copy(dst, prefix)
+		got, err := enc.EncodeTo(dst, img)
+		if err != nil {
+			t.Fatalf("%s: EncodeTo: %v", enc.Format(), err)
+		}
+		if !bytes.HasPrefix(got, prefix) || !bytes.Equal(got[len(prefix):], want) {
+			t.Errorf("%s: EncodeTo did not append the Encode output to dst", enc.Format())
+		}
+
+		// Reusing the returned buffer must give the same bytes again.
+		again, err := enc.EncodeTo(got[:0], img)
+		if err != nil || !bytes.Equal(again, want) {
+			t.Errorf("%s: EncodeTo into a reused buffer differs (err %v)", enc.Format(), err)
+		}
+	}
+}
+
+// edgeImage returns a 6x1 anti-aliased edge of straight color
+// (200, 100, 50) fading from opaque to transparent.
+func edgeImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 6, 1))
+	for x, a := range []uint8{255, 192, 128, 64, 1, 0} {
+		img.SetNRGBA(x, 0, color.NRGBA{200, 100, 50, a})
+	}
+	return img
+}
+
+func TestPremultiply_EdgePixels(t *testing.T) {
+	// Reference: the colors multiplied by alpha as image/draw does,
+	// stored as they are.
+	want := []color.NRGBA{
+		{200, 100, 50, 255},
+		{151, 75, 37, 192},
+		{100, 50, 25, 128},
+		{50, 25, 12, 64},
+		{0, 0, 0, 1},
+		{0, 0, 0, 0},
+	}
+	enc := Premultiply(&PNGEncoder{})
+	if enc.Format() != "png" {
+		t.Errorf("Format() = %q, want png", enc.Format())
+	}
+	rgba := image.NewRGBA(edgeImage().Bounds())
+	draw.Draw(rgba, rgba.Bounds(), edgeImage(), image.Point{}, draw.Src)
+	for _, src := range []image.Image{edgeImage(), rgba} {
+		data, err := enc.Encode(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, ok := raw.(*image.NRGBA)
+		if !ok {
+			t.Fatalf("decoded %T, want *image.NRGBA", raw)
+		}
+		for x, w := range want {
+			if c := n.NRGBAAt(x, 0); c != w {
+				t.Errorf("%T input: stored pixel %d = %v, want %v", src, x, c, w)
+			}
+		}
+
+		// Reading the tile back as premultiplied gives the input again.
+		back, ok := FromPremultiplied(raw).(*image.RGBA)
+		if !ok {
+			t.Fatalf("FromPremultiplied returned %T, want *image.RGBA", back)
+		}
+		for x := range want {
+			if got, w := back.RGBAAt(x, 0), color.RGBAModel.Convert(edgeImage().At(x, 0)); got != w {
+				t.Errorf("%T input: decoded pixel %d = %v, want %v", src, x, got, w)
+			}
+		}
+	}
+}
+
+func TestPremultiply_OpaqueUnchanged(t *testing.T) {
+	img := testImage(16)
+	want, _ := (&PNGEncoder{}).Encode(img)
+	got, err := Premultiply(&PNGEncoder{}).Encode(img)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("opaque tile encoded differently with Premultiply (err %v)", err)
+	}
+}
+
+func TestFromPremultiplied_Clamps(t *testing.T) {
+	// Lossy encoding can leave colors above their alpha.
+	n := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	n.SetNRGBA(0, 0, color.NRGBA{90, 40, 10, 64})
+	if c := FromPremultiplied(n).(*image.RGBA).RGBAAt(0, 0); c != (color.RGBA{64, 40, 10, 64}) {
+		t.Errorf("NRGBA pixel = %v, want {64 40 10 64}", c)
+	}
+
+	n16 := image.NewNRGBA64(image.Rect(0, 0, 1, 1))
+	n16.SetNRGBA64(0, 0, color.NRGBA64{0x9000, 0x4000, 0x8100, 0x8000})
+	if c := FromPremultiplied(n16).(*image.RGBA64).RGBA64At(0, 0); c != (color.RGBA64{0x8000, 0x4000, 0x8000, 0x8000}) {
+		t.Errorf("NRGBA64 pixel = %v, want {0x8000 0x4000 0x8000 0x8000}", c)
+	}
+
+	p := image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.NRGBA{90, 40, 10, 64}})
+	if c := FromPremultiplied(p).At(0, 0); c != (color.RGBA{64, 40, 10, 64}) {
+		t.Errorf("paletted pixel = %v, want {64 40 10 64}", c)
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, 1, 1))
+	if FromPremultiplied(gray) != image.Image(gray) {
+		t.Error("image without alpha should be returned unchanged")
+	}
+}
+
+func TestPremultiply_Quality(t *testing.T) {
+	enc := Flatten(Premultiply(&JPEGEncoder{Quality: 90}), color.RGBA{255, 255, 255, 255})
+	if q, ok := QualityOf(enc); !ok || q != 90 {
+		t.Errorf("QualityOf = %d, %v, want 90, true", q, ok)
+	}
+	lower, ok := WithQuality(enc, 40)
+	if !ok {
+		t.Fatal("WithQuality not supported through Premultiply")
+	}
+	if q, _ := QualityOf(lower); q != 40 {
+		t.Errorf("quality after WithQuality = %d, want 40", q)
+	}
+	f, ok := lower.(*flattenEncoder)
+	if !ok {
+		t.Fatal("WithQuality dropped the background")
+	}
+	if _, ok := f.Encoder.(*premultiplyEncoder); !ok {
+		t.Error("WithQuality dropped the premultiplication")
+	}
+}
+
+func TestStraightRGBA(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	m.SetRGBA(0, 0, color.RGBA{128, 64, 0, 128})
+	m.SetRGBA(1, 0, color.RGBA{151, 75, 37, 192})
+	want := []color.NRGBA{{255, 128, 0, 128}, {201, 100, 49, 192}, {0, 0, 0, 0}}
+	out := straightRGBA(m)
+	for x, w := range want {
+		if c := out.NRGBAAt(x, 0); c != w {
+			t.Errorf("pixel %d = %v, want %v", x, c, w)
+		}
+	}
+	n := edgeImage()
+	if straightRGBA(n) != n {
+		t.Error("NRGBA input should be returned as is")
+	}
+	opaque := testImage(4)
+	if o := straightRGBA(opaque); &o.Pix[0] != &opaque.Pix[0] {
+		t.Error("opaque RGBA should share its pixels")
+	}
+}
+
+func TestPremultipliedImage_ClampsInCopy(t *testing.T) {
+	// Averaging colors over the opaque pixels only, as downsampling
+	// does at data edges, can leave colors above alpha.
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Pix = []uint8{90, 40, 10, 64, 20, 10, 5, 32}
+	out := PremultipliedImage(img).(*image.NRGBA)
+	if got := out.Pix; !bytes.Equal(got, []uint8{64, 40, 10, 64, 20, 10, 5, 32}) {
+		t.Errorf("pixels = %v, want the colors clamped to alpha", got)
+	}
+	if img.Pix[0] != 90 {
+		t.Error("PremultipliedImage modified its input")
+	}
+}
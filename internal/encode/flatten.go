@@ -0,0 +1,42 @@
+package encode
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Flatten returns an encoder that composites each image over the opaque
+// color bg before encoding it with enc. Formats without an alpha channel
+// (JPEG) would otherwise show transparent pixels as black, because
+// image.RGBA is alpha-premultiplied.
+func Flatten(enc Encoder, bg color.RGBA) Encoder {
+	bg.A = 255
+	return &flattenEncoder{Encoder: enc, bg: bg}
+}
+
+type flattenEncoder struct {
+	Encoder
+	bg color.RGBA
+}
+
+func (e *flattenEncoder) Encode(img image.Image) ([]byte, error) { return e.EncodeTo(nil, img) }
+
+func (e *flattenEncoder) EncodeTo(dst []byte, img image.Image) ([]byte, error) {
+	return e.Encoder.EncodeTo(dst, FlattenImage(img, e.bg))
+}
+
+// FlattenImage composites img over bg (treated as opaque) with the
+// "over" operator, so partially transparent edge pixels blend into bg
+// instead of darkening. Opaque images are returned unchanged.
+func FlattenImage(img image.Image, bg color.RGBA) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	bg.A = 255
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	draw.Draw(out, b, image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(out, b, img, b.Min, draw.Over)
+	return out
+}
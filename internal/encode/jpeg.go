@@ -6,24 +6,36 @@ import (
 	"image/jpeg"
 )
 
-// JPEGEncoder encodes tiles as JPEG.
+// JPEGEncoder encodes tiles as JPEG. *image.Gray input produces a
+// single-component (grayscale) JPEG.
 type JPEGEncoder struct {
 	Quality int // 1-100, default 85
 }
 
-func (e *JPEGEncoder) Encode(img image.Image) ([]byte, error) {
-	var buf bytes.Buffer
+func (e *JPEGEncoder) Encode(img image.Image) ([]byte, error) { return e.EncodeTo(nil, img) }
+
+func (e *JPEGEncoder) EncodeTo(dst []byte, img image.Image) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
 	quality := e.Quality
 	if quality <= 0 {
 		quality = 85
 	}
-	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	err := jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
 	if err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
 }
 
+func (e *JPEGEncoder) quality() int {
+	if e.Quality <= 0 {
+		return 85
+	}
+	return e.Quality
+}
+
+func (e *JPEGEncoder) withQuality(q int) Encoder { return &JPEGEncoder{Quality: q} }
+
 func (e *JPEGEncoder) Format() string        { return "jpeg" }
 func (e *JPEGEncoder) PMTileType() uint8     { return TileTypeJPEG }
 func (e *JPEGEncoder) FileExtension() string { return ".jpg" }
@@ -4,16 +4,38 @@ import (
 	"bytes"
 	"image"
 	"image/png"
+	"sync"
 )
 
-// PNGEncoder encodes tiles as PNG.
+// PNGEncoder encodes tiles as PNG. *image.Gray input produces an 8-bit
+// grayscale PNG (color type 0).
 type PNGEncoder struct{}
 
-func (e *PNGEncoder) Encode(img image.Image) ([]byte, error) {
-	var buf bytes.Buffer
-	enc := &png.Encoder{CompressionLevel: png.BestSpeed}
-	err := enc.Encode(&buf, img)
-	if err != nil {
+func (e *PNGEncoder) Encode(img image.Image) ([]byte, error) { return e.EncodeTo(nil, img) }
+
+func (e *PNGEncoder) EncodeTo(dst []byte, img image.Image) ([]byte, error) {
+	return encodePNG(dst, img)
+}
+
+// pngEncoder is shared by all PNG and Terrarium encoding. Its BufferPool
+// recycles the zlib writer and row buffers between tiles, which otherwise
+// account for most of png.Encode's allocations. png.Encoder is safe for
+// concurrent use as long as its fields are not modified.
+var pngEncoder = &png.Encoder{CompressionLevel: png.BestSpeed, BufferPool: &pngBufferPool{}}
+
+type pngBufferPool struct{ pool sync.Pool }
+
+func (p *pngBufferPool) Get() *png.EncoderBuffer {
+	b, _ := p.pool.Get().(*png.EncoderBuffer)
+	return b
+}
+
+func (p *pngBufferPool) Put(b *png.EncoderBuffer) { p.pool.Put(b) }
+
+// encodePNG appends the PNG encoding of img to dst.
+func encodePNG(dst []byte, img image.Image) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	if err := pngEncoder.Encode(buf, img); err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
@@ -0,0 +1,137 @@
+package encode
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Premultiply returns an encoder that stores the colors of each image
+// multiplied by their alpha, for renderers that blend tiles as
+// premultiplied textures without converting them. PNG and WebP define
+// their pixels with straight alpha, so such tiles are only correct for
+// clients that know; the archive records it as "premultiplied_alpha".
+// FromPremultiplied reverses it on decoded tiles.
+func Premultiply(enc Encoder) Encoder {
+	return &premultiplyEncoder{Encoder: enc}
+}
+
+type premultiplyEncoder struct {
+	Encoder
+}
+
+func (e *premultiplyEncoder) Encode(img image.Image) ([]byte, error) { return e.EncodeTo(nil, img) }
+
+func (e *premultiplyEncoder) EncodeTo(dst []byte, img image.Image) ([]byte, error) {
+	return e.Encoder.EncodeTo(dst, PremultipliedImage(img))
+}
+
+// PremultipliedImage returns img with its premultiplied colors presented
+// as straight ones, as an *image.NRGBA sharing the pixels of an
+// *image.RGBA, so that encoders store them as they are. Colors above their
+// alpha, which are no valid premultiplied color, are clamped to it in a
+// copy. Opaque images are returned unchanged: multiplying by full alpha
+// changes nothing.
+func PremultipliedImage(img image.Image) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		b := img.Bounds()
+		rgba = image.NewRGBA(b)
+		draw.Draw(rgba, b, img, b.Min, draw.Src)
+	}
+	pix := rgba.Pix
+	for i := 0; i+3 < len(pix); i += 4 {
+		if a := pix[i+3]; pix[i] > a || pix[i+1] > a || pix[i+2] > a {
+			pix = clampToAlpha(pix)
+			break
+		}
+	}
+	return &image.NRGBA{Pix: pix, Stride: rgba.Stride, Rect: rgba.Rect}
+}
+
+// clampToAlpha returns a copy of the RGBA samples pix with every color
+// clamped to its alpha.
+func clampToAlpha(pix []uint8) []uint8 {
+	out := make([]uint8, len(pix))
+	for i := 0; i+3 < len(pix); i += 4 {
+		a := pix[i+3]
+		out[i], out[i+1], out[i+2], out[i+3] = min(pix[i], a), min(pix[i+1], a), min(pix[i+2], a), a
+	}
+	return out
+}
+
+// FromPremultiplied reverses PremultipliedImage on a decoded tile: the
+// colors a decoder returns as straight are taken as premultiplied, which
+// is how image.RGBA holds them. Colors above their alpha, which lossy
+// encoding can produce, are clamped to it. Images without alpha are
+// returned unchanged; NRGBA pixels are reused in place.
+func FromPremultiplied(img image.Image) image.Image {
+	switch m := img.(type) {
+	case *image.NRGBA:
+		for i := 0; i+3 < len(m.Pix); i += 4 {
+			a := m.Pix[i+3]
+			m.Pix[i] = min(m.Pix[i], a)
+			m.Pix[i+1] = min(m.Pix[i+1], a)
+			m.Pix[i+2] = min(m.Pix[i+2], a)
+		}
+		return &image.RGBA{Pix: m.Pix, Stride: m.Stride, Rect: m.Rect}
+	case *image.NRGBA64:
+		for i := 0; i+7 < len(m.Pix); i += 8 {
+			a0, a1 := m.Pix[i+6], m.Pix[i+7]
+			for c := i; c < i+6; c += 2 {
+				if m.Pix[c] > a0 || m.Pix[c] == a0 && m.Pix[c+1] > a1 {
+					m.Pix[c], m.Pix[c+1] = a0, a1
+				}
+			}
+		}
+		return &image.RGBA64{Pix: m.Pix, Stride: m.Stride, Rect: m.Rect}
+	case *image.Paletted:
+		// Quantizers such as pngquant (--tile-filter) write palettes.
+		p := make(color.Palette, len(m.Palette))
+		for i, c := range m.Palette {
+			n := color.NRGBAModel.Convert(c).(color.NRGBA)
+			p[i] = color.RGBA{min(n.R, n.A), min(n.G, n.A), min(n.B, n.A), n.A}
+		}
+		return &image.Paletted{Pix: m.Pix, Stride: m.Stride, Rect: m.Rect, Palette: p}
+	}
+	return img
+}
+
+// straightRGBA returns the pixels of img as 8-bit RGBA with straight
+// (not premultiplied) alpha, as libwebp takes them. An *image.NRGBA
+// already has them; the premultiplied colors of a translucent
+// *image.RGBA are divided by their alpha.
+func straightRGBA(img image.Image) *image.NRGBA {
+	switch m := img.(type) {
+	case *image.NRGBA:
+		return m
+	case *image.RGBA:
+		if m.Opaque() {
+			return &image.NRGBA{Pix: m.Pix, Stride: m.Stride, Rect: m.Rect}
+		}
+		b := m.Bounds()
+		out := image.NewNRGBA(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			src := m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)]
+			dst := out.Pix[out.PixOffset(b.Min.X, y):out.PixOffset(b.Max.X, y)]
+			for i := 0; i+3 < len(src); i += 4 {
+				a := uint32(src[i+3])
+				dst[i+3] = src[i+3]
+				if a == 0 {
+					continue
+				}
+				for c := 0; c < 3; c++ {
+					dst[i+c] = uint8(min((uint32(src[i+c])*255+a/2)/a, 255))
+				}
+			}
+		}
+		return out
+	}
+	b := img.Bounds()
+	out := image.NewNRGBA(b)
+	draw.Draw(out, b, img, b.Min, draw.Src)
+	return out
+}
@@ -0,0 +1,107 @@
+package encode
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// TerrainRGBEncoder encodes tiles as Mapbox Terrain-RGB PNG.
+// The input image should already have Terrain-RGB-encoded RGB values.
+type TerrainRGBEncoder struct{}
+
+func (e *TerrainRGBEncoder) Encode(img image.Image) ([]byte, error) { return e.EncodeTo(nil, img) }
+
+func (e *TerrainRGBEncoder) EncodeTo(dst []byte, img image.Image) ([]byte, error) {
+	// As for Terrarium, clients decode R, G and B separately.
+	if _, ok := img.(*image.Gray); ok {
+		img = imageToRGBA(img)
+	}
+	return encodePNG(dst, img)
+}
+
+func (e *TerrainRGBEncoder) Format() string        { return "terrainrgb" }
+func (e *TerrainRGBEncoder) PMTileType() uint8     { return TileTypePNG }
+func (e *TerrainRGBEncoder) FileExtension() string { return ".png" }
+
+// maxTerrainRGB is the largest 24-bit Terrain-RGB value.
+const maxTerrainRGB = 1<<24 - 1
+
+// ElevationToTerrainRGB converts a float64 elevation value to Terrain-RGB.
+// Terrain-RGB formula: elevation = -10000 + (R*65536 + G*256 + B) * 0.1
+// Range: -10000 to +1667721.5 meters in 0.1 m steps.
+func ElevationToTerrainRGB(elevation float64) color.RGBA {
+	if math.IsNaN(elevation) || math.IsInf(elevation, 0) {
+		return color.RGBA{0, 0, 0, 0} // nodata → transparent
+	}
+	v := math.Round((elevation + 10000) * 10)
+	if v < 0 {
+		v = 0
+	}
+	if v > maxTerrainRGB {
+		v = maxTerrainRGB
+	}
+	n := uint32(v)
+	return color.RGBA{R: uint8(n >> 16), G: uint8(n >> 8), B: uint8(n), A: 255}
+}
+
+// TerrainRGBToElevation converts Terrain-RGB values back to elevation.
+// Returns NaN if the pixel is transparent (nodata).
+func TerrainRGBToElevation(c color.RGBA) float64 {
+	if c.A == 0 {
+		return math.NaN()
+	}
+	return -10000 + float64(uint32(c.R)<<16|uint32(c.G)<<8|uint32(c.B))*0.1
+}
+
+// DEMEncoding selects how elevation tiles pack an elevation into RGB.
+type DEMEncoding uint8
+
+const (
+	Terrarium  DEMEncoding = iota // Mapzen/AWS Terrarium, 1/256 m steps
+	TerrainRGB                    // Mapbox Terrain-RGB, 0.1 m steps
+)
+
+// DEMEncodingFor returns the elevation encoding of a tile format, and false
+// for formats that are not elevation encodings.
+func DEMEncodingFor(format string) (DEMEncoding, bool) {
+	switch format {
+	case "terrarium":
+		return Terrarium, true
+	case "terrainrgb":
+		return TerrainRGB, true
+	}
+	return Terrarium, false
+}
+
+// Format returns the tile format name ("terrarium", "terrainrgb").
+func (e DEMEncoding) Format() string {
+	if e == TerrainRGB {
+		return "terrainrgb"
+	}
+	return "terrarium"
+}
+
+// Encoder returns the tile encoder of the encoding.
+func (e DEMEncoding) Encoder() Encoder {
+	if e == TerrainRGB {
+		return &TerrainRGBEncoder{}
+	}
+	return &TerrariumEncoder{}
+}
+
+// FromElevation converts an elevation to a pixel (NaN → transparent).
+func (e DEMEncoding) FromElevation(elevation float64) color.RGBA {
+	if e == TerrainRGB {
+		return ElevationToTerrainRGB(elevation)
+	}
+	return ElevationToTerrarium(elevation)
+}
+
+// ToElevation converts a pixel to an elevation (transparent → NaN).
+func (e DEMEncoding) ToElevation(c color.RGBA) float64 {
+	if e == TerrainRGB {
+		return TerrainRGBToElevation(c)
+	}
+	return TerrariumToElevation(c)
+}
@@ -1,10 +1,8 @@
 package encode
 
 import (
-	"bytes"
 	"image"
 	"image/color"
-	"image/png"
 	"math"
 )
 
@@ -12,14 +10,15 @@ import (
 // The input image should already have Terrarium-encoded RGB values.
 type TerrariumEncoder struct{}
 
-func (e *TerrariumEncoder) Encode(img image.Image) ([]byte, error) {
-	var buf bytes.Buffer
-	enc := &png.Encoder{CompressionLevel: png.BestSpeed}
-	err := enc.Encode(&buf, img)
-	if err != nil {
-		return nil, err
+func (e *TerrariumEncoder) Encode(img image.Image) ([]byte, error) { return e.EncodeTo(nil, img) }
+
+func (e *TerrariumEncoder) EncodeTo(dst []byte, img image.Image) ([]byte, error) {
+	// Terrarium consumers decode R, G and B separately; never emit a gray
+	// PNG even when a tile happens to have R=G=B everywhere.
+	if _, ok := img.(*image.Gray); ok {
+		img = imageToRGBA(img)
 	}
-	return buf.Bytes(), nil
+	return encodePNG(dst, img)
 }
 
 func (e *TerrariumEncoder) Format() string        { return "terrarium" }
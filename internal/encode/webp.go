@@ -17,19 +17,26 @@ import (
 // WebPEncoder encodes tiles as WebP using native libwebp via CGo.
 // Requires libwebp to be installed (brew install webp / apt-get install libwebp-dev).
 type WebPEncoder struct {
-	Quality int
+	Quality  int
+	Lossless bool // VP8L: exact pixels, larger tiles; Quality is ignored
 }
 
 func newWebPEncoder(quality int) (Encoder, error) {
+	if quality == Lossless {
+		return &WebPEncoder{Lossless: true}, nil
+	}
 	if quality <= 0 {
 		quality = 85
 	}
 	return &WebPEncoder{Quality: quality}, nil
 }
 
-func (e *WebPEncoder) Encode(img image.Image) ([]byte, error) {
-	rgba := imageToRGBA(img)
-	bounds := rgba.Bounds()
+func (e *WebPEncoder) Encode(img image.Image) ([]byte, error) { return e.EncodeTo(nil, img) }
+
+func (e *WebPEncoder) EncodeTo(dst []byte, img image.Image) ([]byte, error) {
+	// libwebp takes straight alpha; image.RGBA holds premultiplied colors.
+	nrgba := straightRGBA(img)
+	bounds := nrgba.Bounds()
 	width := bounds.Dx()
 	height := bounds.Dy()
 	if width == 0 || height == 0 {
@@ -37,27 +44,51 @@ func (e *WebPEncoder) Encode(img image.Image) ([]byte, error) {
 	}
 
 	var output *C.uint8_t
-	size := C.WebPEncodeRGBA(
-		(*C.uint8_t)(unsafe.Pointer(&rgba.Pix[0])),
-		C.int(width),
-		C.int(height),
-		C.int(rgba.Stride),
-		C.float(e.Quality),
-		&output,
-	)
+	var size C.size_t
+	if e.Lossless {
+		size = C.WebPEncodeLosslessRGBA(
+			(*C.uint8_t)(unsafe.Pointer(&nrgba.Pix[0])),
+			C.int(width),
+			C.int(height),
+			C.int(nrgba.Stride),
+			&output,
+		)
+	} else {
+		size = C.WebPEncodeRGBA(
+			(*C.uint8_t)(unsafe.Pointer(&nrgba.Pix[0])),
+			C.int(width),
+			C.int(height),
+			C.int(nrgba.Stride),
+			C.float(e.Quality),
+			&output,
+		)
+	}
 	if size == 0 || output == nil {
 		return nil, fmt.Errorf("webp: encode failed")
 	}
 	defer C.WebPFree(unsafe.Pointer(output))
 
-	return C.GoBytes(unsafe.Pointer(output), C.int(size)), nil
+	return append(dst, unsafe.Slice((*byte)(unsafe.Pointer(output)), int(size))...), nil
+}
+
+func (e *WebPEncoder) quality() int {
+	if e.Lossless {
+		return Lossless
+	}
+	return e.Quality
+}
+
+func (e *WebPEncoder) withQuality(q int) Encoder {
+	return &WebPEncoder{Quality: q, Lossless: q == Lossless}
 }
 
 func (e *WebPEncoder) Format() string        { return "webp" }
 func (e *WebPEncoder) PMTileType() uint8     { return TileTypeWebP }
 func (e *WebPEncoder) FileExtension() string { return ".webp" }
 
-// DecodeWebP decodes WebP image bytes using native libwebp.
+// DecodeWebP decodes WebP image bytes using native libwebp. libwebp
+// returns straight (not premultiplied) alpha, so the result is an
+// *image.NRGBA.
 func DecodeWebP(data []byte) (image.Image, error) {
 	if len(data) == 0 {
 		return nil, fmt.Errorf("webp: empty data")
@@ -79,7 +110,7 @@ func DecodeWebP(data []byte) (image.Image, error) {
 	h := int(height)
 	totalBytes := w * 4 * h
 
-	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
 	src := unsafe.Slice((*byte)(unsafe.Pointer(ptr)), totalBytes)
 	copy(img.Pix, src)
 	return img, nil
@@ -0,0 +1,90 @@
+// Package hint formats errors about inputs the tools cannot handle. Such an
+// error names the file, says which feature is unsupported, and gives a
+// concrete way out, usually the GDAL command that converts the file into
+// something the tools can read:
+//
+//	scan.tif: compression 34887 (LERC) is not supported (supported: none, LZW, JPEG, Deflate, ZSTD, WebP)
+//	  to fix: gdal_translate -of COG -co COMPRESS=DEFLATE scan.tif scan_cog.tif
+package hint
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Error is an error with a remediation hint.
+type Error struct {
+	File    string // offending input; may be empty
+	Problem string // what is wrong, e.g. "compression 34887 (LERC) is not supported"
+	Fix     string // how to get past it, e.g. a gdal_translate command
+	Err     error  // underlying error, if any
+}
+
+// New returns an Error for file.
+func New(file, problem, fix string) *Error {
+	return &Error{File: file, Problem: problem, Fix: fix}
+}
+
+// Wrap returns an Error for file around err, or nil if err is nil.
+func Wrap(err error, file, problem, fix string) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{File: file, Problem: problem, Fix: fix, Err: err}
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File)
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Problem)
+	if e.Err != nil {
+		b.WriteString(": ")
+		b.WriteString(e.Err.Error())
+	}
+	if e.Fix != "" {
+		b.WriteString("\n  to fix: ")
+		b.WriteString(e.Fix)
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// GDALTranslate returns a gdal_translate command that rewrites file as a
+// Cloud Optimized GeoTIFF next to it, with extra options such as
+// "-co COMPRESS=DEFLATE" before the file names.
+func GDALTranslate(file string, options ...string) string {
+	return command("gdal_translate", file, "_cog.tif", append([]string{"-of COG"}, options...))
+}
+
+// GDALWarp returns a gdalwarp command that reprojects file to srs (e.g.
+// "EPSG:3857") as a Cloud Optimized GeoTIFF next to it. With srs empty it
+// only resamples the file north-up in its own CRS.
+func GDALWarp(file, srs string, options ...string) string {
+	if srs == "" {
+		return command("gdalwarp", file, "_cog.tif", append([]string{"-of COG"}, options...))
+	}
+	return command("gdalwarp", file, "_"+strings.ReplaceAll(strings.ToLower(srs), ":", "")+".tif",
+		append([]string{"-t_srs " + srs, "-of COG"}, options...))
+}
+
+// command formats a GDAL command reading file and writing it with suffix in
+// place of its extension.
+func command(tool, file, suffix string, options []string) string {
+	out := strings.TrimSuffix(file, filepath.Ext(file)) + suffix
+	return strings.Join(append(append([]string{tool}, options...), quote(file), quote(out)), " ")
+}
+
+// quote quotes s for a POSIX shell if it contains anything but safe
+// characters.
+func quote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:,+@%=", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,41 @@
+package hint
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	err := New("scan.tif", "compression 34887 (LERC) is not supported", GDALTranslate("scan.tif", "-co COMPRESS=DEFLATE"))
+	want := "scan.tif: compression 34887 (LERC) is not supported\n" +
+		"  to fix: gdal_translate -of COG -co COMPRESS=DEFLATE scan.tif scan_cog.tif"
+	if got := err.Error(); got != want {
+		t.Errorf("Error() = %q\nwant %q", got, want)
+	}
+
+	wrapped := Wrap(io.ErrUnexpectedEOF, "a.pmtiles", "reading header", "")
+	if got := wrapped.Error(); got != "a.pmtiles: reading header: unexpected EOF" {
+		t.Errorf("Error() = %q", got)
+	}
+	var h *Error
+	if !errors.Is(wrapped, io.ErrUnexpectedEOF) || !errors.As(wrapped, &h) || h.File != "a.pmtiles" {
+		t.Errorf("wrapped error does not unwrap: %#v", wrapped)
+	}
+	if Wrap(nil, "a", "b", "c") != nil {
+		t.Error("Wrap(nil) != nil")
+	}
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct{ got, want string }{
+		{GDALTranslate("data/ortho.tiff"), "gdal_translate -of COG data/ortho.tiff data/ortho_cog.tif"},
+		{GDALTranslate("my scan's.tif"), `gdal_translate -of COG 'my scan'\''s.tif' 'my scan'\''s_cog.tif'`},
+		{GDALWarp("dem.tif", "EPSG:3857"), "gdalwarp -t_srs EPSG:3857 -of COG dem.tif dem_epsg3857.tif"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got  %s\nwant %s", tt.got, tt.want)
+		}
+	}
+}
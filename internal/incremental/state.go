@@ -0,0 +1,168 @@
+// Package incremental keeps the sidecar state of --incremental runs: a
+// content digest and footprint per input file, plus the settings the archive
+// was built with, so that a re-run regenerates only the tiles affected by
+// inputs that changed and copies everything else from the previous archive.
+//
+// The state lives next to the archive as <output>.state.json:
+//
+//	{
+//	  "version": 1,
+//	  "settings": "format=webp quality=85 ...",
+//	  "files": {
+//	    "ortho/2056_1200.tif": {
+//	      "sha256": "…", "size": 104857600,
+//	      "bounds": [7.41, 46.93, 7.45, 46.96],
+//	      "tiles": [18, 136981, 92032, 137010, 92060]
+//	    }
+//	  }
+//	}
+package incremental
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// stateVersion is bumped when the file format changes; older states are
+// ignored and the next run is a full one.
+const stateVersion = 1
+
+// State is the content of a state file.
+type State struct {
+	Version int `json:"version"`
+	// Settings describes every option that affects tile content. A
+	// previous archive is only reused if it was built with equal settings.
+	Settings string               `json:"settings"`
+	Files    map[string]FileState `json:"files"`
+}
+
+// FileState records one input file.
+type FileState struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	// Bounds is the footprint in WGS84: min lon, min lat, max lon, max lat.
+	Bounds [4]float64 `json:"bounds"`
+	// Tiles is the range of tiles the file influenced at the max zoom:
+	// zoom, min x, min y, max x, max y.
+	Tiles [5]int `json:"tiles"`
+}
+
+// New returns an empty state for the given settings.
+func New(settings string) *State {
+	return &State{Version: stateVersion, Settings: settings, Files: make(map[string]FileState)}
+}
+
+// StatePath returns the state file path of an output archive.
+func StatePath(output string) string {
+	return output + ".state.json"
+}
+
+// Load reads a state file. A missing file, or one written by another
+// version, returns nil and no error: the run is simply not incremental.
+func Load(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("state file %s: %w", path, err)
+	}
+	if s.Version != stateVersion {
+		return nil, nil
+	}
+	if s.Files == nil {
+		s.Files = make(map[string]FileState)
+	}
+	return &s, nil
+}
+
+// Save writes the state next to a temporary name and renames it into place,
+// so an interrupted run leaves the previous state intact.
+func (s *State) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// HashFile returns the hex SHA-256 digest and size of a file.
+func HashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("hashing %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// Change kinds reported by Diff.
+const (
+	Added    = "added"
+	Modified = "modified"
+	Removed  = "removed"
+)
+
+// Change is an input file that differs between two states.
+type Change struct {
+	Path string
+	Kind string // Added, Modified, or Removed
+	// Regions are the footprints whose tiles must be regenerated: the new
+	// one for added files, the old one for removed files, and both for
+	// modified files (a moved file leaves tiles behind at the old place).
+	Regions [][4]float64
+}
+
+// Diff returns the files that were added, removed, or modified (different
+// digest or footprint) between prev and cur, sorted by path.
+func Diff(prev, cur *State) []Change {
+	var changes []Change
+	for path, f := range cur.Files {
+		old, ok := prev.Files[path]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Path: path, Kind: Added, Regions: [][4]float64{f.Bounds}})
+		case old.SHA256 != f.SHA256 || old.Size != f.Size || old.Bounds != f.Bounds:
+			changes = append(changes, Change{Path: path, Kind: Modified, Regions: [][4]float64{old.Bounds, f.Bounds}})
+		}
+	}
+	for path, old := range prev.Files {
+		if _, ok := cur.Files[path]; !ok {
+			changes = append(changes, Change{Path: path, Kind: Removed, Regions: [][4]float64{old.Bounds}})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
@@ -0,0 +1,75 @@
+package incremental
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	path := StatePath(filepath.Join(t.TempDir(), "out.pmtiles"))
+	if s, err := Load(path); s != nil || err != nil {
+		t.Fatalf("Load(missing) = %v, %v; want nil, nil", s, err)
+	}
+
+	s := New("format=png")
+	s.Files["a.tif"] = FileState{SHA256: "ab", Size: 3, Bounds: [4]float64{7, 46, 8, 47}, Tiles: [5]int{10, 530, 360, 534, 363}}
+	if err := s.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, s) {
+		t.Errorf("Load = %+v, want %+v", got, s)
+	}
+
+	// A state of another version is ignored.
+	if err := os.WriteFile(path, []byte(`{"version": 99, "files": {}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := Load(path); s != nil || err != nil {
+		t.Errorf("Load(version 99) = %v, %v; want nil, nil", s, err)
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.tif")
+	if err := os.WriteFile(path, []byte("abc"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum, n, err := HashFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if sum != want || n != 3 {
+		t.Errorf("HashFile = %s, %d; want %s, 3", sum, n, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	box := func(lon float64) [4]float64 { return [4]float64{lon, 46, lon + 1, 47} }
+	prev := New("")
+	prev.Files["same.tif"] = FileState{SHA256: "1", Bounds: box(0)}
+	prev.Files["edited.tif"] = FileState{SHA256: "2", Bounds: box(1)}
+	prev.Files["moved.tif"] = FileState{SHA256: "3", Bounds: box(2)}
+	prev.Files["gone.tif"] = FileState{SHA256: "4", Bounds: box(3)}
+	cur := New("")
+	cur.Files["same.tif"] = FileState{SHA256: "1", Bounds: box(0)}
+	cur.Files["edited.tif"] = FileState{SHA256: "2b", Bounds: box(1)}
+	cur.Files["moved.tif"] = FileState{SHA256: "3", Bounds: box(5)}
+	cur.Files["new.tif"] = FileState{SHA256: "5", Bounds: box(4)}
+
+	want := []Change{
+		{Path: "edited.tif", Kind: Modified, Regions: [][4]float64{box(1), box(1)}},
+		{Path: "gone.tif", Kind: Removed, Regions: [][4]float64{box(3)}},
+		{Path: "moved.tif", Kind: Modified, Regions: [][4]float64{box(2), box(5)}},
+		{Path: "new.tif", Kind: Added, Regions: [][4]float64{box(4)}},
+	}
+	if got := Diff(prev, cur); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff = %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,125 @@
+// Package manifest reads the per-source settings file given with --manifest.
+// Settings that differ between inputs of one run (e.g. the nodata convention
+// of each national DEM tile in a merged mosaic) are listed per path pattern:
+//
+//	{
+//	  "sources": [
+//	    {"path": "dem/ch/*.tif", "nodata": "-9999"},
+//	    {"path": "dem/fr/*.tif", "nodata": "-32767,<-1000"}
+//	  ]
+//	}
+//
+// For --split-by-date, entries can also assign an acquisition date, e.g.
+// {"path": "ortho/2019/*.tif", "date": "2019"}.
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Manifest is the parsed settings file.
+type Manifest struct {
+	Sources []Source `json:"sources"`
+}
+
+// Source holds the settings for inputs matching Path.
+type Source struct {
+	// Path is a filepath.Match pattern, matched against the input path as
+	// given on the command line (or found by scanning a directory) and
+	// against its base name.
+	Path string `json:"path"`
+	// NoData overrides the nodata values of the sources, in the syntax of
+	// cog.ParseNoDataSpec (values, <v, >v, comma-separated).
+	NoData string `json:"nodata,omitempty"`
+	// Date is the acquisition date of the inputs, e.g. "2019" or
+	// "2019-06-14". It names the per-date archive of --split-by-date, so it
+	// is restricted to letters, digits, '.', '_', and '-'.
+	Date string `json:"date,omitempty"`
+}
+
+// validDate matches the dates accepted in Source.Date.
+var validDate = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z._-]*$`)
+
+// Load reads and validates a manifest. Unknown fields are rejected so typos
+// do not silently drop a setting.
+func Load(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var m Manifest
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, s := range m.Sources {
+		if s.Path == "" {
+			return nil, fmt.Errorf("%s: sources[%d]: missing path", path, i)
+		}
+		if _, err := filepath.Match(s.Path, ""); err != nil {
+			return nil, fmt.Errorf("%s: sources[%d]: bad pattern %q: %w", path, i, s.Path, err)
+		}
+		if s.Date != "" && !validDate.MatchString(s.Date) {
+			return nil, fmt.Errorf("%s: sources[%d]: bad date %q (letters, digits, '.', '_', '-')", path, i, s.Date)
+		}
+	}
+	return &m, nil
+}
+
+// Lookup returns the first entry whose pattern matches path, or nil.
+func (m *Manifest) Lookup(path string) *Source {
+	if m == nil {
+		return nil
+	}
+	for i := range m.Sources {
+		pattern := m.Sources[i].Path
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return &m.Sources[i]
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return &m.Sources[i]
+		}
+	}
+	return nil
+}
+
+// HasNoData reports whether any entry sets a nodata spec.
+func (m *Manifest) HasNoData() bool {
+	if m == nil {
+		return false
+	}
+	for _, s := range m.Sources {
+		if s.NoData != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Unused returns the patterns that match none of paths, which usually
+// indicates a typo in the manifest.
+func (m *Manifest) Unused(paths []string) []string {
+	if m == nil {
+		return nil
+	}
+	used := make(map[*Source]bool)
+	for _, p := range paths {
+		if s := m.Lookup(p); s != nil {
+			used[s] = true
+		}
+	}
+	var unused []string
+	for i := range m.Sources {
+		if !used[&m.Sources[i]] {
+			unused = append(unused, m.Sources[i].Path)
+		}
+	}
+	return unused
+}
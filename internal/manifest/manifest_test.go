@@ -0,0 +1,62 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeManifest(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadAndLookup(t *testing.T) {
+	m, err := Load(writeManifest(t, `{"sources": [
+		{"path": "dem/ch/*.tif", "nodata": "-9999"},
+		{"path": "fr_*.tif", "nodata": "<-1000"},
+		{"path": "unused/*.tif", "nodata": "0"},
+		{"path": "ortho/2019/*.tif", "date": "2019-06"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := m.Lookup("dem/ch/a.tif"); s == nil || s.NoData != "-9999" {
+		t.Errorf("Lookup(dem/ch/a.tif) = %+v", s)
+	}
+	if s := m.Lookup("/data/dem/fr_12.tif"); s == nil || s.NoData != "<-1000" {
+		t.Errorf("Lookup by base name = %+v", s)
+	}
+	if s := m.Lookup("ortho/2019/a.tif"); s == nil || s.Date != "2019-06" || s.NoData != "" {
+		t.Errorf("Lookup(ortho/2019/a.tif) = %+v", s)
+	}
+	if !m.HasNoData() {
+		t.Error("HasNoData = false, want true")
+	}
+	if s := m.Lookup("dem/it/b.tif"); s != nil {
+		t.Errorf("Lookup(dem/it/b.tif) = %+v, want nil", s)
+	}
+	got := m.Unused([]string{"dem/ch/a.tif", "x/fr_1.tif"})
+	if want := []string{"unused/*.tif", "ortho/2019/*.tif"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unused = %v, want %v", got, want)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	for name, body := range map[string]string{
+		"unknown field": `{"sources": [{"path": "*.tif", "nodta": "0"}]}`,
+		"missing path":  `{"sources": [{"nodata": "0"}]}`,
+		"bad pattern":   `{"sources": [{"path": "[", "nodata": "0"}]}`,
+		"not json":      `sources: []`,
+		"bad date":      `{"sources": [{"path": "*.tif", "date": "2019/06"}]}`,
+	} {
+		if _, err := Load(writeManifest(t, body)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,613 @@
+package mbtiles
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+	"github.com/pspoerri/geotiff2pmtiles/internal/hint"
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+)
+
+// Reader provides read access to an MBTiles tileset through the methods of
+// pmtiles.Reader that the tile pipeline and pmtransform use, so a tileset
+// can be read wherever an archive can. Tiles are addressed in the XYZ
+// scheme of PMTiles, rows flipped from the TMS rows MBTiles stores.
+//
+// OpenReader scans the tile addresses once and keeps them sorted by tile
+// ID (16 bytes per tile); tile data is read on demand. All methods are
+// safe for concurrent use.
+type Reader struct {
+	file     *os.File
+	db       *database
+	header   pmtiles.Header
+	meta     map[string]interface{}
+	tiles    []tileRef // sorted by tile ID
+	dataRoot uint32    // table holding the tile data: tiles or images
+	dataCol  int       // column of tile_data in its rows
+}
+
+// tileRef is a tile and the rowid of its data in the data table.
+type tileRef struct {
+	tileID uint64
+	row    int64
+}
+
+// textKeys are metadata keys whose values are text even when they look
+// like JSON numbers, e.g. a version "1.2".
+var textKeys = map[string]bool{
+	"name": true, "description": true, "attribution": true, "version": true, "id": true,
+	"format": true, "type": true, "bounds": true, "center": true, "minzoom": true, "maxzoom": true,
+}
+
+// OpenReader opens the MBTiles tileset at path for reading. Both the plain
+// layout (a tiles table) and the deduplicating one of mbutil and
+// tippecanoe (map and images tables joined by a tiles view) are read.
+func OpenReader(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	r, err := newReader(path, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func newReader(path string, f *os.File) (*Reader, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	db, err := openDatabase(f, fi.Size())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if wal, err := os.Stat(path + "-wal"); err == nil && wal.Size() > 0 {
+		return nil, hint.New(path, "has changes in its write-ahead log that are not in the database file",
+			"sqlite3 "+path+" 'PRAGMA wal_checkpoint(TRUNCATE)'")
+	}
+	schema, err := db.readSchema()
+	if err != nil {
+		return nil, fmt.Errorf("%s: reading schema: %w", path, err)
+	}
+	objects := make(map[string]schemaEntry)
+	for _, e := range schema {
+		objects[strings.ToLower(e.name)] = e
+	}
+
+	r := &Reader{file: f, db: db}
+	if e, ok := objects["metadata"]; ok && e.typ == "table" {
+		if r.meta, err = readMetadata(db, e); err != nil {
+			return nil, fmt.Errorf("%s: reading metadata: %w", path, err)
+		}
+	}
+	tiles, mapTable, images := objects["tiles"], objects["map"], objects["images"]
+	switch {
+	case tiles.typ == "table":
+		err = r.indexTiles(tiles)
+	case mapTable.typ == "table" && images.typ == "table":
+		err = r.indexMap(mapTable, images)
+	default:
+		return nil, fmt.Errorf("%s: no tiles table, nor map and images tables: not an MBTiles tileset", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	sort.SliceStable(r.tiles, func(i, j int) bool { return r.tiles[i].tileID < r.tiles[j].tileID })
+	r.tiles = lastPerTile(r.tiles)
+
+	if r.header, err = r.buildHeader(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// indexTiles lists the tiles of the plain layout, whose tiles table holds
+// the data itself.
+func (r *Reader) indexTiles(t schemaEntry) error {
+	cols, err := columnsOf(t, "zoom_level", "tile_column", "tile_row", "tile_data")
+	if err != nil {
+		return err
+	}
+	r.dataRoot, r.dataCol = t.root, cols[3].index
+	return r.db.scanTable(t.root, func(rowid int64, record []byte) error {
+		vals, err := parseRecord(record)
+		if err != nil {
+			return err
+		}
+		id, err := tileID(vals, rowid, cols)
+		if err != nil {
+			return err
+		}
+		r.tiles = append(r.tiles, tileRef{tileID: id, row: rowid})
+		return nil
+	})
+}
+
+// indexMap lists the tiles of the deduplicating layout: the map gives each
+// tile an image ID, the key of its data in images. When tile_id is the
+// images table's rowid the ID is the row; otherwise images is scanned once
+// to find the row of each ID.
+func (r *Reader) indexMap(m, images schemaEntry) error {
+	mcols, err := columnsOf(m, "zoom_level", "tile_column", "tile_row", "tile_id")
+	if err != nil {
+		return err
+	}
+	icols, err := columnsOf(images, "tile_data", "tile_id")
+	if err != nil {
+		return err
+	}
+	r.dataRoot, r.dataCol = images.root, icols[0].index
+
+	var rows map[string]int64
+	if !icols[1].rowid {
+		rows = make(map[string]int64)
+		err := r.db.scanTable(images.root, func(rowid int64, record []byte) error {
+			vals, err := parseRecord(record)
+			if err != nil {
+				return err
+			}
+			rows[fmt.Sprint(column(vals, rowid, icols[1]))] = rowid
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return r.db.scanTable(m.root, func(rowid int64, record []byte) error {
+		vals, err := parseRecord(record)
+		if err != nil {
+			return err
+		}
+		id, err := tileID(vals, rowid, mcols)
+		if err != nil {
+			return err
+		}
+		image := column(vals, rowid, mcols[3])
+		var row int64
+		var ok bool
+		if rows == nil {
+			if row, ok = image.(int64); !ok {
+				return nil // no image row: the join drops the tile too
+			}
+		} else if row, ok = rows[fmt.Sprint(image)]; !ok {
+			return nil
+		}
+		r.tiles = append(r.tiles, tileRef{tileID: id, row: row})
+		return nil
+	})
+}
+
+// lastPerTile keeps the last of each run of refs with the same tile ID,
+// as a table without a unique index may hold a tile twice.
+func lastPerTile(refs []tileRef) []tileRef {
+	if len(refs) < 2 {
+		return refs
+	}
+	out := refs[:1]
+	for _, t := range refs[1:] {
+		if t.tileID == out[len(out)-1].tileID {
+			out[len(out)-1] = t
+			continue
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// tileID returns the XYZ tile ID of a row whose first three cols are its
+// zoom, column, and TMS row.
+func tileID(vals []any, rowid int64, cols []tableColumn) (uint64, error) {
+	var zxy [3]int64
+	for i := range zxy {
+		v, ok := column(vals, rowid, cols[i]).(int64)
+		if !ok {
+			return 0, fmt.Errorf("%w: %s of row %d is not an integer", errCorrupt, cols[i].name, rowid)
+		}
+		zxy[i] = v
+	}
+	z, x, row := zxy[0], zxy[1], zxy[2]
+	if z < 0 || z > 31 || x < 0 || x >= 1<<z || row < 0 || row >= 1<<z {
+		return 0, fmt.Errorf("%w: tile %d/%d/%d (TMS) is outside the tile grid", errCorrupt, z, x, row)
+	}
+	return pmtiles.ZXYToTileID(int(z), int(x), int(1<<z-1-row)), nil
+}
+
+// tableColumn is a column of a table: its position in the records, or
+// rowid for an INTEGER PRIMARY KEY, which is the rowid and stored as NULL.
+type tableColumn struct {
+	name  string
+	index int
+	rowid bool
+}
+
+// column returns the value of col in vals, a record of the row rowid.
+// Columns beyond the record (added by ALTER TABLE) are NULL.
+func column(vals []any, rowid int64, col tableColumn) any {
+	if col.rowid {
+		return rowid
+	}
+	if col.index >= len(vals) {
+		return nil
+	}
+	return vals[col.index]
+}
+
+// columnsOf returns the named columns of table t.
+func columnsOf(t schemaEntry, names ...string) ([]tableColumn, error) {
+	defs, err := parseColumns(t.sql)
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %w", t.name, err)
+	}
+	cols := make([]tableColumn, len(names))
+	for i, name := range names {
+		j := columnIndex(defs, name)
+		if j < 0 {
+			return nil, fmt.Errorf("table %s has no column %s", t.name, name)
+		}
+		cols[i] = defs[j]
+	}
+	return cols, nil
+}
+
+// columnIndex returns the index of the column called name, ignoring case,
+// or -1.
+func columnIndex(defs []tableColumn, name string) int {
+	for i, d := range defs {
+		if strings.EqualFold(d.name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseColumns returns the columns a CREATE TABLE statement defines.
+func parseColumns(sql string) ([]tableColumn, error) {
+	open, end := strings.Index(sql, "("), strings.LastIndex(sql, ")")
+	if open < 0 || end < open {
+		return nil, fmt.Errorf("cannot parse %q", sql)
+	}
+	if strings.Contains(strings.ToUpper(sql[end:]), "WITHOUT ROWID") {
+		return nil, fmt.Errorf("WITHOUT ROWID tables are not supported")
+	}
+	var cols []tableColumn
+	for _, def := range splitTopLevel(sql[open+1 : end]) {
+		fields := strings.Fields(def)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue // a table constraint
+		}
+		name := strings.Trim(fields[0], "\"`[]'")
+		upper := strings.ToUpper(strings.Join(fields[1:], " "))
+		rowid := strings.HasPrefix(upper, "INTEGER PRIMARY KEY") && !strings.HasPrefix(upper, "INTEGER PRIMARY KEY DESC")
+		cols = append(cols, tableColumn{name: name, index: len(cols), rowid: rowid})
+	}
+	return cols, nil
+}
+
+// splitTopLevel splits s at the commas outside parentheses and quotes.
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// readMetadata reads the name/value rows of the metadata table. Values
+// that are JSON other than strings (numbers, arrays, objects) are decoded
+// unless their key holds text, and the keys of the json row are merged in,
+// so the map matches the metadata JSON of a PMTiles archive.
+func readMetadata(db *database, t schemaEntry) (map[string]interface{}, error) {
+	cols, err := columnsOf(t, "name", "value")
+	if err != nil {
+		return nil, err
+	}
+	meta := make(map[string]interface{})
+	var jsonRow string
+	err = db.scanTable(t.root, func(rowid int64, record []byte) error {
+		vals, err := parseRecord(record)
+		if err != nil {
+			return err
+		}
+		name, ok := column(vals, rowid, cols[0]).(string)
+		if !ok {
+			return nil
+		}
+		value := fmt.Sprint(column(vals, rowid, cols[1]))
+		if b, ok := column(vals, rowid, cols[1]).([]byte); ok {
+			value = string(b)
+		}
+		if name == "json" {
+			jsonRow = value
+			return nil
+		}
+		meta[name] = value
+		var v interface{}
+		if !textKeys[name] && json.Unmarshal([]byte(value), &v) == nil {
+			if _, isString := v.(string); !isString && v != nil {
+				meta[name] = v
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if jsonRow != "" {
+		var extra map[string]interface{}
+		if err := json.Unmarshal([]byte(jsonRow), &extra); err == nil {
+			for k, v := range extra {
+				if _, ok := meta[k]; !ok {
+					meta[k] = v
+				}
+			}
+		}
+	}
+	return meta, nil
+}
+
+// buildHeader describes the tileset as a PMTiles header: the tile type
+// from the metadata format or else the first tile's bytes, the zoom range
+// of the tiles, and the bounds and center from the metadata or else the
+// extent of the tiles at the max zoom.
+func (r *Reader) buildHeader() (pmtiles.Header, error) {
+	h := pmtiles.Header{
+		TileCompression:   pmtiles.CompressionNone,
+		NumAddressedTiles: uint64(len(r.tiles)),
+		NumTileEntries:    uint64(len(r.tiles)),
+	}
+	rows := make(map[int64]struct{}, len(r.tiles))
+	for _, t := range r.tiles {
+		rows[t.row] = struct{}{}
+	}
+	h.NumTileContents = uint64(len(rows))
+	var first []byte
+	if len(r.tiles) > 0 {
+		z, x, y := pmtiles.TileIDToZXY(r.tiles[0].tileID)
+		data, err := r.ReadTile(z, x, y)
+		if err != nil {
+			return h, err
+		}
+		first = data
+	}
+
+	format, _ := r.meta["format"].(string)
+	switch strings.ToLower(format) {
+	case "png":
+		h.TileType = pmtiles.TileTypePNG
+	case "jpg", "jpeg":
+		h.TileType = pmtiles.TileTypeJPEG
+	case "webp":
+		h.TileType = pmtiles.TileTypeWebP
+	case "pbf", "mvt":
+		h.TileType = pmtiles.TileTypeMVT
+	default:
+		h.TileType = sniffTileType(first)
+	}
+	if h.TileType == pmtiles.TileTypeMVT && bytes.HasPrefix(first, []byte{0x1f, 0x8b}) {
+		h.TileCompression = pmtiles.CompressionGzip
+	}
+
+	if len(r.tiles) > 0 {
+		minZoom, _, _ := pmtiles.TileIDToZXY(r.tiles[0].tileID)
+		maxZoom, _, _ := pmtiles.TileIDToZXY(r.tiles[len(r.tiles)-1].tileID)
+		h.MinZoom, h.MaxZoom = uint8(minZoom), uint8(maxZoom)
+	} else {
+		h.MinZoom, h.MaxZoom = uint8(r.metaInt("minzoom", 0)), uint8(r.metaInt("maxzoom", 0))
+	}
+
+	if b, ok := r.metaFloats("bounds", 4); ok {
+		h.MinLon, h.MinLat, h.MaxLon, h.MaxLat = float32(b[0]), float32(b[1]), float32(b[2]), float32(b[3])
+	} else if len(r.tiles) > 0 {
+		h.MinLon, h.MinLat, h.MaxLon, h.MaxLat = r.tileExtent(int(h.MaxZoom))
+	} else {
+		h.MinLon, h.MinLat, h.MaxLon, h.MaxLat = -180, -85.05113, 180, 85.05113
+	}
+	h.CenterLon, h.CenterLat = (h.MinLon+h.MaxLon)/2, (h.MinLat+h.MaxLat)/2
+	h.CenterZoom = uint8((int(h.MinZoom) + int(h.MaxZoom)) / 2)
+	if c, ok := r.metaFloats("center", 3); ok {
+		h.CenterLon, h.CenterLat = float32(c[0]), float32(c[1])
+		h.CenterZoom = uint8(min(max(c[2], float64(h.MinZoom)), float64(h.MaxZoom)))
+	}
+	return h, nil
+}
+
+// sniffTileType recognizes the tile type of data by its first bytes;
+// gzip-compressed data is taken for vector tiles.
+func sniffTileType(data []byte) uint8 {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG")):
+		return pmtiles.TileTypePNG
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return pmtiles.TileTypeJPEG
+	case len(data) >= 12 && bytes.HasPrefix(data, []byte("RIFF")) && string(data[8:12]) == "WEBP":
+		return pmtiles.TileTypeWebP
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		return pmtiles.TileTypeMVT
+	}
+	return pmtiles.TileTypeUnknown
+}
+
+// tileExtent returns the bounds of the tiles at zoom z.
+func (r *Reader) tileExtent(z int) (minLon, minLat, maxLon, maxLat float32) {
+	minX, minY, maxX, maxY := math.MaxInt, math.MaxInt, -1, -1
+	r.ForEachTileAtZoom(z, func(x, y int) error {
+		minX, maxX = min(minX, x), max(maxX, x)
+		minY, maxY = min(minY, y), max(maxY, y)
+		return nil
+	})
+	w, _, _, n := coord.TileBounds(z, minX, minY)
+	_, s, e, _ := coord.TileBounds(z, maxX, maxY)
+	return float32(w), float32(s), float32(e), float32(n)
+}
+
+// metaFloats parses the comma-separated numbers of metadata key, which
+// must have n of them.
+func (r *Reader) metaFloats(key string, n int) ([]float64, bool) {
+	s, ok := r.meta[key].(string)
+	if !ok {
+		return nil, false
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, false
+	}
+	vals := make([]float64, n)
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, false
+		}
+		vals[i] = v
+	}
+	return vals, true
+}
+
+// metaInt returns metadata key as an integer in 0..31, or def.
+func (r *Reader) metaInt(key string, def int) int {
+	s, _ := r.meta[key].(string)
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || v < 0 || v > 31 {
+		return def
+	}
+	return v
+}
+
+// Header returns a PMTiles header describing the tileset (see OpenReader);
+// its offsets and lengths are zero.
+func (r *Reader) Header() pmtiles.Header {
+	return r.header
+}
+
+// ReadTile returns the data of the tile at z/x/y, or nil, nil if the
+// tileset has none.
+func (r *Reader) ReadTile(z, x, y int) ([]byte, error) {
+	ref, ok := r.find(pmtiles.ZXYToTileID(z, x, y))
+	if !ok {
+		return nil, nil
+	}
+	record, err := r.db.lookupRow(r.dataRoot, ref.row)
+	if err != nil {
+		return nil, fmt.Errorf("reading tile z%d/%d/%d: %w", z, x, y, err)
+	}
+	if record == nil {
+		return nil, nil
+	}
+	vals, err := parseRecord(record)
+	if err != nil {
+		return nil, fmt.Errorf("reading tile z%d/%d/%d: %w", z, x, y, err)
+	}
+	switch v := column(vals, ref.row, tableColumn{index: r.dataCol}).(type) {
+	case []byte:
+		return bytes.Clone(v), nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, nil
+}
+
+// find returns the ref of tile ID id.
+func (r *Reader) find(id uint64) (tileRef, bool) {
+	i := sort.Search(len(r.tiles), func(i int) bool { return r.tiles[i].tileID >= id })
+	if i == len(r.tiles) || r.tiles[i].tileID != id {
+		return tileRef{}, false
+	}
+	return r.tiles[i], true
+}
+
+// HasTile reports whether the tileset has a tile at z/x/y.
+func (r *Reader) HasTile(z, x, y int) bool {
+	_, ok := r.find(pmtiles.ZXYToTileID(z, x, y))
+	return ok
+}
+
+// zoomTiles returns the refs of zoom z, in tile ID order.
+func (r *Reader) zoomTiles(z int) []tileRef {
+	lo, hi := pmtiles.ZXYToTileID(z, 0, 0), pmtiles.ZXYToTileID(z+1, 0, 0)
+	i := sort.Search(len(r.tiles), func(i int) bool { return r.tiles[i].tileID >= lo })
+	j := sort.Search(len(r.tiles), func(i int) bool { return r.tiles[i].tileID >= hi })
+	return r.tiles[i:j]
+}
+
+// TileCountAtZoom returns the number of tiles at zoom z.
+func (r *Reader) TileCountAtZoom(z int) int {
+	return len(r.zoomTiles(z))
+}
+
+// ForEachTileAtZoom calls fn with the coordinates of every tile at zoom z,
+// in tile ID order. An error from fn stops the walk and is returned.
+func (r *Reader) ForEachTileAtZoom(z int, fn func(x, y int) error) error {
+	for _, t := range r.zoomTiles(z) {
+		_, x, y := pmtiles.TileIDToZXY(t.tileID)
+		if err := fn(x, y); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NumTiles returns the total number of tiles in the tileset.
+func (r *Reader) NumTiles() int {
+	return len(r.tiles)
+}
+
+// TileSize returns the raster tile size recorded as "tile_size" in the
+// metadata, or 0 when it is not recorded.
+func (r *Reader) TileSize() int {
+	v, ok := r.meta["tile_size"].(float64)
+	if !ok || v < 1 || v != math.Trunc(v) || v > math.MaxInt32 {
+		return 0
+	}
+	return int(v)
+}
+
+// PremultipliedAlpha reports whether the metadata records the tiles as
+// storing premultiplied colors (see pmtiles.Reader.PremultipliedAlpha).
+func (r *Reader) PremultipliedAlpha() bool {
+	v, _ := r.meta["premultiplied_alpha"].(bool)
+	return v
+}
+
+// ReadMetadata returns the metadata table as a map (see readMetadata), or
+// nil if the tileset has none.
+func (r *Reader) ReadMetadata() (map[string]interface{}, error) {
+	return maps.Clone(r.meta), nil
+}
+
+// Close closes the underlying file.
+func (r *Reader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,136 @@
+package mbtiles
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+)
+
+// sqliteTileset creates an MBTiles file with the sqlite3 command-line
+// tool from the given SQL, skipping the test if sqlite3 is missing.
+func sqliteTileset(t *testing.T, sql string) string {
+	t.Helper()
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not available")
+	}
+	path := filepath.Join(t.TempDir(), "in.mbtiles")
+	cmd := exec.Command("sqlite3", path)
+	cmd.Stdin = strings.NewReader(sql)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("sqlite3: %v\n%s", err, out)
+	}
+	return path
+}
+
+// tilesSQL fills the tiles from zoom 0 to 5 with blobs whose length
+// depends on the tile, some of them large enough to overflow.
+const tilesSQL = `
+WITH RECURSIVE
+  z(z) AS (SELECT 0 UNION ALL SELECT z + 1 FROM z WHERE z < 5),
+  t(z, x, y) AS (
+    SELECT z, 0, 0 FROM z
+    UNION ALL
+    SELECT z, CASE WHEN y + 1 < (1 << z) THEN x ELSE x + 1 END,
+              CASE WHEN y + 1 < (1 << z) THEN y + 1 ELSE 0 END
+    FROM t WHERE x < (1 << z) - 1 OR y < (1 << z) - 1)
+`
+
+func TestReaderPlainTiles(t *testing.T) {
+	path := sqliteTileset(t, `
+CREATE TABLE metadata (name text, value text);
+CREATE TABLE tiles (zoom_level integer, tile_column integer, tile_row integer, tile_data blob);
+CREATE UNIQUE INDEX tile_index ON tiles (zoom_level, tile_column, tile_row);
+INSERT INTO metadata VALUES ('name', 'plain'), ('format', 'png'), ('bounds', '5.9,45.8,10.5,47.8'), ('minzoom', '0');
+`+tilesSQL+`
+INSERT INTO tiles SELECT z, x, (1 << z) - 1 - y,
+  CAST(printf('%d/%d/%d', z, x, y) AS BLOB) || zeroblob((x * 997 + y * 131) % 9000) FROM t;
+`)
+	r, err := OpenReader(path)
+	if err != nil {
+		t.Fatalf("OpenReader: %v", err)
+	}
+	defer r.Close()
+
+	if n := r.NumTiles(); n != 1365 {
+		t.Errorf("NumTiles = %d, want 1365", n)
+	}
+	for _, c := range [][3]int{{0, 0, 0}, {3, 2, 1}, {5, 31, 0}, {5, 17, 29}} {
+		data, err := r.ReadTile(c[0], c[1], c[2])
+		if err != nil {
+			t.Fatalf("ReadTile%v: %v", c, err)
+		}
+		prefix := fmt.Sprintf("%d/%d/%d", c[0], c[1], c[2])
+		if want := len(prefix) + (c[1]*997+c[2]*131)%9000; len(data) != want || !strings.HasPrefix(string(data), prefix) {
+			t.Errorf("tile %v: %d bytes starting %q, want %d starting %q", c, len(data), data[:min(len(data), 10)], want, prefix)
+		}
+	}
+	h := r.Header()
+	if h.TileType != pmtiles.TileTypePNG || h.MaxZoom != 5 || h.MinLon != 5.9 || h.MaxLat != 47.8 {
+		t.Errorf("header type %d max zoom %d bounds %v..%v", h.TileType, h.MaxZoom, h.MinLon, h.MaxLat)
+	}
+	var n int
+	r.ForEachTileAtZoom(5, func(x, y int) error {
+		n++
+		return nil
+	})
+	if n != 1024 {
+		t.Errorf("ForEachTileAtZoom(5) visited %d tiles, want 1024", n)
+	}
+}
+
+func TestReaderMapImages(t *testing.T) {
+	// tile_id as TEXT, the mbutil layout: images are found by a scan.
+	path := sqliteTileset(t, `
+CREATE TABLE metadata (name text, value text);
+CREATE TABLE map (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, tile_id TEXT);
+CREATE TABLE images (tile_data blob, tile_id text);
+CREATE VIEW tiles AS SELECT map.zoom_level AS zoom_level, map.tile_column AS tile_column,
+  map.tile_row AS tile_row, images.tile_data AS tile_data FROM map JOIN images ON images.tile_id = map.tile_id;
+INSERT INTO metadata VALUES ('format', 'jpg'), ('json', '{"attribution_extra": 1}');
+INSERT INTO images VALUES (CAST('even' AS BLOB), 'a'), (CAST('odd' AS BLOB) || zeroblob(20000), 'b');
+`+tilesSQL+`
+INSERT INTO map SELECT z, x, (1 << z) - 1 - y, CASE (x + y) % 2 WHEN 0 THEN 'a' ELSE 'b' END FROM t;
+`)
+	r, err := OpenReader(path)
+	if err != nil {
+		t.Fatalf("OpenReader: %v", err)
+	}
+	defer r.Close()
+
+	if got, _ := r.ReadTile(4, 3, 5); string(got) != "even" {
+		t.Errorf("tile 4/3/5 = %q, want even", got)
+	}
+	if got, _ := r.ReadTile(4, 3, 6); len(got) != 20003 || string(got[:3]) != "odd" {
+		t.Errorf("tile 4/3/6: %d bytes, want 20003", len(got))
+	}
+	if r.Header().TileType != pmtiles.TileTypeJPEG {
+		t.Errorf("TileType = %d, want JPEG", r.Header().TileType)
+	}
+	meta, _ := r.ReadMetadata()
+	if meta["attribution_extra"] != float64(1) {
+		t.Errorf("json metadata not merged: %v", meta)
+	}
+}
+
+func TestReaderRejectsWAL(t *testing.T) {
+	path := writeTestTileset(t, 0, []int{10}, pmtiles.WriterOptions{TileFormat: pmtiles.TileTypePNG})
+	if err := os.WriteFile(path+"-wal", []byte("pending"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenReader(path); err == nil || !strings.Contains(err.Error(), "wal_checkpoint") {
+		t.Errorf("OpenReader with a WAL file: err = %v, want a checkpoint hint", err)
+	}
+}
+
+func TestReaderRejectsNonSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.mbtiles")
+	os.WriteFile(path, []byte(strings.Repeat("not a database ", 20)), 0o644)
+	if _, err := OpenReader(path); err == nil {
+		t.Error("OpenReader accepted a non-SQLite file")
+	}
+}
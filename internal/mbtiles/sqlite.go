@@ -0,0 +1,788 @@
+package mbtiles
+
+// This file reads and writes the SQLite database file format
+// (https://www.sqlite.org/fileformat2.html), as much of it as MBTiles
+// needs: table and index b-trees with overflow pages, records, and the
+// schema table. There is no SQL engine; the writer lays out pages for
+// rows it is given in key order, and the reader walks b-trees.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// pageSize is the page size of written databases, SQLite's default.
+const pageSize = 4096
+
+// B-tree page types.
+const (
+	pageIndexInterior = 0x02
+	pageTableInterior = 0x05
+	pageIndexLeaf     = 0x0a
+	pageTableLeaf     = 0x0d
+)
+
+// fileHeaderSize is the size of the database header at the start of page 1.
+const fileHeaderSize = 100
+
+// sqliteMagic starts every SQLite database file.
+const sqliteMagic = "SQLite format 3\x00"
+
+// applicationID is the MBTiles application ID ("MPBX") of the header.
+const applicationID = 0x4d504258
+
+// errCorrupt is wrapped by the errors of malformed databases.
+var errCorrupt = errors.New("corrupt database")
+
+// appendVarint appends v as an SQLite varint: big-endian groups of 7 bits
+// with the high bit set on all but the last, and a full last byte when all
+// 9 bytes are needed.
+func appendVarint(b []byte, v uint64) []byte {
+	if v > 1<<56-1 {
+		var tmp [9]byte
+		tmp[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			tmp[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(b, tmp[:]...)
+	}
+	var tmp [8]byte
+	n := 0
+	for {
+		tmp[n] = byte(v & 0x7f)
+		n++
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		c := tmp[i]
+		if i > 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// readVarint decodes the varint at the start of b and returns it with its
+// length, or a length of 0 when b ends inside it.
+func readVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 8 && i < len(b); i++ {
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	if len(b) < 9 {
+		return 0, 0
+	}
+	return v<<8 | uint64(b[8]), 9
+}
+
+// varintLen returns the encoded length of v.
+func varintLen(v uint64) int {
+	n := 1
+	for v > 0x7f && n < 9 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+// appendRecord appends the record of vals: nil, int64, float64, string
+// (TEXT), or []byte (BLOB).
+func appendRecord(b []byte, vals ...any) []byte {
+	var types []byte
+	for _, v := range vals {
+		types = appendVarint(types, serialType(v))
+	}
+	hdr := len(types) + 1
+	for varintLen(uint64(hdr))+len(types) != hdr {
+		hdr = varintLen(uint64(hdr)) + len(types)
+	}
+	b = appendVarint(b, uint64(hdr))
+	b = append(b, types...)
+	for _, v := range vals {
+		switch v := v.(type) {
+		case int64:
+			n := intSize(v)
+			for i := n - 1; i >= 0; i-- {
+				b = append(b, byte(v>>(8*i)))
+			}
+		case float64:
+			b = binary.BigEndian.AppendUint64(b, math.Float64bits(v))
+		case string:
+			b = append(b, v...)
+		case []byte:
+			b = append(b, v...)
+		}
+	}
+	return b
+}
+
+// serialType returns the record serial type of v.
+func serialType(v any) uint64 {
+	switch v := v.(type) {
+	case int64:
+		switch v {
+		case 0:
+			return 8
+		case 1:
+			return 9
+		}
+		switch intSize(v) {
+		case 1, 2, 3, 4:
+			return uint64(intSize(v))
+		case 6:
+			return 5
+		}
+		return 6
+	case float64:
+		return 7
+	case string:
+		return uint64(2*len(v) + 13)
+	case []byte:
+		return uint64(2*len(v) + 12)
+	}
+	return 0
+}
+
+// intSize returns the bytes of the smallest integer serial type holding v,
+// 0 for the constants 0 and 1.
+func intSize(v int64) int {
+	switch {
+	case v == 0 || v == 1:
+		return 0
+	case v >= -1<<7 && v < 1<<7:
+		return 1
+	case v >= -1<<15 && v < 1<<15:
+		return 2
+	case v >= -1<<23 && v < 1<<23:
+		return 3
+	case v >= -1<<31 && v < 1<<31:
+		return 4
+	case v >= -1<<47 && v < 1<<47:
+		return 6
+	}
+	return 8
+}
+
+// parseRecord decodes a record into nil, int64, float64, string, and
+// []byte values; the strings and slices alias p.
+func parseRecord(p []byte) ([]any, error) {
+	hdr, n := readVarint(p)
+	if n == 0 || hdr < uint64(n) || hdr > uint64(len(p)) {
+		return nil, fmt.Errorf("%w: bad record header", errCorrupt)
+	}
+	types := p[n:hdr]
+	body := p[hdr:]
+	var vals []any
+	for len(types) > 0 {
+		t, n := readVarint(types)
+		if n == 0 {
+			return nil, fmt.Errorf("%w: bad record header", errCorrupt)
+		}
+		types = types[n:]
+		size := serialSize(t)
+		if size < 0 || size > len(body) {
+			return nil, fmt.Errorf("%w: record value overruns the record", errCorrupt)
+		}
+		v := body[:size]
+		body = body[size:]
+		switch {
+		case t == 0:
+			vals = append(vals, nil)
+		case t <= 6:
+			x := int64(int8(v[0]))
+			for _, c := range v[1:] {
+				x = x<<8 | int64(c)
+			}
+			vals = append(vals, x)
+		case t == 7:
+			vals = append(vals, math.Float64frombits(binary.BigEndian.Uint64(v)))
+		case t == 8:
+			vals = append(vals, int64(0))
+		case t == 9:
+			vals = append(vals, int64(1))
+		case t%2 == 0:
+			vals = append(vals, v)
+		default:
+			vals = append(vals, string(v))
+		}
+	}
+	return vals, nil
+}
+
+// serialSize returns the body size of serial type t, or -1 for the
+// reserved types 10 and 11.
+func serialSize(t uint64) int {
+	switch {
+	case t <= 4:
+		return [...]int{0, 1, 2, 3, 4}[t]
+	case t == 5:
+		return 6
+	case t == 6, t == 7:
+		return 8
+	case t == 8, t == 9:
+		return 0
+	case t == 10, t == 11:
+		return -1
+	case t > math.MaxInt32:
+		return -1
+	}
+	return int(t-12) / 2
+}
+
+// localPayload returns how many bytes of an n-byte payload a cell keeps on
+// its page, of usable size u; the rest goes to overflow pages. Index cells
+// keep less than table leaf cells, so that several fit on a page.
+func localPayload(n, u int, index bool) int {
+	x := u - 35
+	if index {
+		x = (u-12)*64/255 - 23
+	}
+	if n <= x {
+		return n
+	}
+	m := (u-12)*32/255 - 23
+	if k := m + (n-m)%(u-4); k <= x {
+		return k
+	}
+	return m
+}
+
+// pager allocates and writes the pages of a database being written.
+// Page 1 is reserved for the schema and written last.
+type pager struct {
+	w     io.WriterAt
+	pages uint32
+	buf   []byte
+}
+
+func newPager(w io.WriterAt) *pager {
+	return &pager{w: w, pages: 1, buf: make([]byte, pageSize)}
+}
+
+func (p *pager) alloc() uint32 {
+	p.pages++
+	return p.pages
+}
+
+func (p *pager) write(n uint32, page []byte) error {
+	if _, err := p.w.WriteAt(page, int64(n-1)*pageSize); err != nil {
+		return fmt.Errorf("writing page %d: %w", n, err)
+	}
+	return nil
+}
+
+// cell appends to prefix (the cell's child pointer, payload size, and
+// rowid, as its kind has them) the part of payload that stays on the page,
+// writing the rest to a chain of overflow pages.
+func (p *pager) cell(prefix, payload []byte, index bool) ([]byte, error) {
+	local := localPayload(len(payload), pageSize, index)
+	c := append(prefix, payload[:local]...)
+	rest := payload[local:]
+	if len(rest) == 0 {
+		return c, nil
+	}
+	per := pageSize - 4
+	first := p.pages + 1
+	for len(rest) > 0 {
+		n := p.alloc()
+		chunk := rest[:min(per, len(rest))]
+		rest = rest[len(chunk):]
+		page := p.buf
+		clear(page)
+		if len(rest) > 0 {
+			binary.BigEndian.PutUint32(page, n+1)
+		}
+		copy(page[4:], chunk)
+		if err := p.write(n, page); err != nil {
+			return nil, err
+		}
+	}
+	return binary.BigEndian.AppendUint32(c, first), nil
+}
+
+// writeBtreePage writes b-tree page n of kind with cells, and right as the
+// right-most child of interior pages. hdrOff is where the page header
+// starts: 100 on page 1, behind the database header, else 0.
+func (p *pager) writeBtreePage(n uint32, kind byte, cells [][]byte, right uint32, hdrOff int, page []byte) error {
+	hsize := 8
+	if kind == pageIndexInterior || kind == pageTableInterior {
+		hsize = 12
+	}
+	if pageBytes(cells, hdrOff+hsize) > pageSize {
+		return fmt.Errorf("page %d overflows", n)
+	}
+	clear(page[hdrOff:])
+	h := page[hdrOff:]
+	h[0] = kind
+	binary.BigEndian.PutUint16(h[3:], uint16(len(cells)))
+	if hsize == 12 {
+		binary.BigEndian.PutUint32(h[8:], right)
+	}
+	off := pageSize
+	for i, c := range cells {
+		off -= len(c)
+		copy(page[off:], c)
+		binary.BigEndian.PutUint16(h[hsize+2*i:], uint16(off))
+	}
+	binary.BigEndian.PutUint16(h[5:], uint16(off))
+	return p.write(n, page)
+}
+
+// pageBytes returns the bytes a page needs for cells behind a header
+// ending at hdrEnd.
+func pageBytes(cells [][]byte, hdrEnd int) int {
+	n := hdrEnd
+	for _, c := range cells {
+		n += 2 + len(c)
+	}
+	return n
+}
+
+// btreeLevel is the page being filled on one level of a b-tree.
+type btreeLevel struct {
+	cells [][]byte
+	size  int
+}
+
+func (l *btreeLevel) fits(c []byte, hsize int) bool {
+	return hsize+l.size+2+len(c) <= pageSize
+}
+
+func (l *btreeLevel) add(c []byte) {
+	l.cells = append(l.cells, c)
+	l.size += 2 + len(c)
+}
+
+// pop removes and returns the last cell.
+func (l *btreeLevel) pop() []byte {
+	c := l.cells[len(l.cells)-1]
+	l.cells = l.cells[:len(l.cells)-1]
+	l.size -= 2 + len(c)
+	return c
+}
+
+func (l *btreeLevel) reset() {
+	l.cells = l.cells[:0]
+	l.size = 0
+}
+
+// btreeWriter builds a table or index b-tree bottom-up from entries added
+// in key order: full leaves are written as they fill, and each level above
+// collects a cell per page of the level below.
+//
+// An interior cell holds a child and the key that bounds it: for tables
+// the largest rowid in the child, for indexes an entry that is stored in
+// the interior cell only and sorts between the child and the next one.
+// When a page fills, its last cell is popped: the page's right-most child
+// is that cell's child, and the cell's key goes up with the page.
+type btreeWriter struct {
+	p        *pager
+	index    bool
+	leaf     btreeLevel
+	lastKey  []byte // table: varint of the last rowid added
+	interior []btreeLevel
+	page     []byte
+}
+
+func newBtreeWriter(p *pager, index bool) *btreeWriter {
+	return &btreeWriter{p: p, index: index, page: make([]byte, pageSize)}
+}
+
+// addRow adds a table row; rowids must ascend.
+func (b *btreeWriter) addRow(rowid int64, record []byte) error {
+	prefix := appendVarint(nil, uint64(len(record)))
+	prefix = appendVarint(prefix, uint64(rowid))
+	c, err := b.p.cell(prefix, record, false)
+	if err != nil {
+		return err
+	}
+	if !b.leaf.fits(c, 8) {
+		n := b.p.alloc()
+		if err := b.p.writeBtreePage(n, pageTableLeaf, b.leaf.cells, 0, 0, b.page); err != nil {
+			return err
+		}
+		b.leaf.reset()
+		if err := b.addChild(0, n, b.lastKey); err != nil {
+			return err
+		}
+	}
+	b.leaf.add(c)
+	b.lastKey = appendVarint(nil, uint64(rowid))
+	return nil
+}
+
+// addEntry adds an index entry; entries must ascend.
+func (b *btreeWriter) addEntry(record []byte) error {
+	c, err := b.p.cell(appendVarint(nil, uint64(len(record))), record, true)
+	if err != nil {
+		return err
+	}
+	if !b.leaf.fits(c, 8) {
+		sep := b.leaf.pop()
+		n := b.p.alloc()
+		if err := b.p.writeBtreePage(n, pageIndexLeaf, b.leaf.cells, 0, 0, b.page); err != nil {
+			return err
+		}
+		b.leaf.reset()
+		if err := b.addChild(0, n, sep); err != nil {
+			return err
+		}
+	}
+	b.leaf.add(c)
+	return nil
+}
+
+// addChild adds page child, bounded by key, to interior level i.
+func (b *btreeWriter) addChild(i int, child uint32, key []byte) error {
+	if i == len(b.interior) {
+		b.interior = append(b.interior, btreeLevel{})
+	}
+	c := binary.BigEndian.AppendUint32(nil, child)
+	c = append(c, key...)
+	l := &b.interior[i]
+	if !l.fits(c, 12) {
+		last := l.pop()
+		n := b.p.alloc()
+		if err := b.p.writeBtreePage(n, b.interiorKind(), l.cells, binary.BigEndian.Uint32(last), 0, b.page); err != nil {
+			return err
+		}
+		l.reset()
+		if err := b.addChild(i+1, n, last[4:]); err != nil {
+			return err
+		}
+		l = &b.interior[i]
+	}
+	l.add(c)
+	return nil
+}
+
+func (b *btreeWriter) interiorKind() byte {
+	if b.index {
+		return pageIndexInterior
+	}
+	return pageTableInterior
+}
+
+// finish writes the pages still being filled and returns the root page.
+// The last page of each level becomes the right-most child of the level
+// above.
+func (b *btreeWriter) finish() (uint32, error) {
+	kind := byte(pageTableLeaf)
+	if b.index {
+		kind = pageIndexLeaf
+	}
+	n := b.p.alloc()
+	if err := b.p.writeBtreePage(n, kind, b.leaf.cells, 0, 0, b.page); err != nil {
+		return 0, err
+	}
+	for i := range b.interior {
+		right := n
+		n = b.p.alloc()
+		if err := b.p.writeBtreePage(n, b.interiorKind(), b.interior[i].cells, right, 0, b.page); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// schemaEntry is a row of the sqlite_schema table.
+type schemaEntry struct {
+	typ, name, table string
+	root             uint32
+	sql              string
+}
+
+// writeSchema writes page 1: the database header and the schema table,
+// which must fit on the page.
+func (p *pager) writeSchema(entries []schemaEntry) error {
+	var cells [][]byte
+	for i, e := range entries {
+		rec := appendRecord(nil, e.typ, e.name, e.table, int64(e.root), e.sql)
+		prefix := appendVarint(nil, uint64(len(rec)))
+		prefix = appendVarint(prefix, uint64(i+1))
+		if localPayload(len(rec), pageSize, false) < len(rec) {
+			return fmt.Errorf("schema entry %s is too long", e.name)
+		}
+		cells = append(cells, append(prefix, rec...))
+	}
+	page := make([]byte, pageSize)
+	h := page[:fileHeaderSize]
+	copy(h, sqliteMagic)
+	binary.BigEndian.PutUint16(h[16:], pageSize)
+	h[18], h[19] = 1, 1 // legacy (rollback journal) file format
+	h[21], h[22], h[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(h[24:], 1) // file change counter
+	binary.BigEndian.PutUint32(h[28:], p.pages)
+	binary.BigEndian.PutUint32(h[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(h[44:], 4) // schema format
+	binary.BigEndian.PutUint32(h[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(h[68:], applicationID)
+	binary.BigEndian.PutUint32(h[92:], 1) // version-valid-for: the change counter
+	binary.BigEndian.PutUint32(h[96:], 3046000)
+	return p.writeBtreePage(1, pageTableLeaf, cells, 0, fileHeaderSize, page)
+}
+
+// database reads the b-trees of an SQLite database.
+type database struct {
+	r      io.ReaderAt
+	size   int    // page size
+	usable int    // page size less the reserved bytes
+	pages  uint32 // pages in the file
+}
+
+// openDatabase checks the database header of r, a file of size bytes.
+func openDatabase(r io.ReaderAt, size int64) (*database, error) {
+	h := make([]byte, fileHeaderSize)
+	if _, err := r.ReadAt(h, 0); err != nil {
+		return nil, fmt.Errorf("reading database header: %w", err)
+	}
+	if !bytes.HasPrefix(h, []byte(sqliteMagic)) {
+		return nil, fmt.Errorf("not an SQLite database")
+	}
+	ps := int(binary.BigEndian.Uint16(h[16:]))
+	if ps == 1 {
+		ps = 65536
+	}
+	if ps < 512 || ps&(ps-1) != 0 {
+		return nil, fmt.Errorf("%w: page size %d", errCorrupt, ps)
+	}
+	usable := ps - int(h[20])
+	if usable < 480 {
+		return nil, fmt.Errorf("%w: %d reserved bytes per page", errCorrupt, h[20])
+	}
+	if enc := binary.BigEndian.Uint32(h[56:]); enc > 1 {
+		return nil, fmt.Errorf("text encoding %d is not supported (want UTF-8)", enc)
+	}
+	return &database{r: r, size: ps, usable: usable, pages: uint32(size / int64(ps))}, nil
+}
+
+// page reads page n.
+func (d *database) page(n uint32) ([]byte, error) {
+	if n < 1 || n > d.pages {
+		return nil, fmt.Errorf("%w: page %d out of range (%d pages)", errCorrupt, n, d.pages)
+	}
+	buf := make([]byte, d.size)
+	if _, err := d.r.ReadAt(buf, int64(n-1)*int64(d.size)); err != nil {
+		return nil, fmt.Errorf("reading page %d: %w", n, err)
+	}
+	return buf, nil
+}
+
+// btreePage is a parsed b-tree page.
+type btreePage struct {
+	n     uint32
+	data  []byte
+	kind  byte
+	cells []int // cell offsets
+	right uint32
+}
+
+func (d *database) btreePage(n uint32) (*btreePage, error) {
+	data, err := d.page(n)
+	if err != nil {
+		return nil, err
+	}
+	off := 0
+	if n == 1 {
+		off = fileHeaderSize
+	}
+	p := &btreePage{n: n, data: data, kind: data[off]}
+	hsize := 8
+	switch p.kind {
+	case pageTableLeaf, pageIndexLeaf:
+	case pageTableInterior, pageIndexInterior:
+		hsize = 12
+		p.right = binary.BigEndian.Uint32(data[off+8:])
+	default:
+		return nil, fmt.Errorf("%w: page %d has type %d", errCorrupt, n, p.kind)
+	}
+	count := int(binary.BigEndian.Uint16(data[off+3:]))
+	ptrs := off + hsize
+	if ptrs+2*count > d.usable {
+		return nil, fmt.Errorf("%w: page %d has %d cells", errCorrupt, n, count)
+	}
+	p.cells = make([]int, count)
+	for i := range p.cells {
+		c := int(binary.BigEndian.Uint16(data[ptrs+2*i:]))
+		if c < ptrs+2*count || c >= d.usable {
+			return nil, fmt.Errorf("%w: page %d cell %d at offset %d", errCorrupt, n, i, c)
+		}
+		p.cells[i] = c
+	}
+	return p, nil
+}
+
+// payload returns the size-byte payload that starts at off on page p,
+// following its overflow chain.
+func (d *database) payload(p *btreePage, off int, size uint64, index bool) ([]byte, error) {
+	if size > 1<<30 {
+		return nil, fmt.Errorf("%w: %d-byte payload on page %d", errCorrupt, size, p.n)
+	}
+	local := localPayload(int(size), d.usable, index)
+	if off+local > d.usable {
+		return nil, fmt.Errorf("%w: cell overruns page %d", errCorrupt, p.n)
+	}
+	if local == int(size) {
+		return p.data[off : off+local], nil
+	}
+	if off+local+4 > d.usable {
+		return nil, fmt.Errorf("%w: cell overruns page %d", errCorrupt, p.n)
+	}
+	out := make([]byte, 0, size)
+	out = append(out, p.data[off:off+local]...)
+	next := binary.BigEndian.Uint32(p.data[off+local:])
+	for uint64(len(out)) < size {
+		if next == 0 || len(out) > int(size) {
+			return nil, fmt.Errorf("%w: overflow chain of page %d ends early", errCorrupt, p.n)
+		}
+		page, err := d.page(next)
+		if err != nil {
+			return nil, err
+		}
+		chunk := min(int(size)-len(out), d.usable-4)
+		out = append(out, page[4:4+chunk]...)
+		next = binary.BigEndian.Uint32(page)
+	}
+	return out, nil
+}
+
+// maxDepth bounds b-tree descents, so that a cycle in a corrupt file
+// fails instead of recursing forever.
+const maxDepth = 40
+
+// scanTable calls fn with the rowid and record of every row of the table
+// b-tree at root, in rowid order. An error from fn stops the scan and is
+// returned.
+func (d *database) scanTable(root uint32, fn func(rowid int64, record []byte) error) error {
+	return d.scanTablePage(root, 0, fn)
+}
+
+func (d *database) scanTablePage(n uint32, depth int, fn func(rowid int64, record []byte) error) error {
+	if depth > maxDepth {
+		return fmt.Errorf("%w: b-tree deeper than %d pages", errCorrupt, maxDepth)
+	}
+	p, err := d.btreePage(n)
+	if err != nil {
+		return err
+	}
+	switch p.kind {
+	case pageTableInterior:
+		for _, off := range p.cells {
+			if off+4 > d.usable {
+				return fmt.Errorf("%w: cell overruns page %d", errCorrupt, n)
+			}
+			if err := d.scanTablePage(binary.BigEndian.Uint32(p.data[off:]), depth+1, fn); err != nil {
+				return err
+			}
+		}
+		return d.scanTablePage(p.right, depth+1, fn)
+	case pageTableLeaf:
+		for _, off := range p.cells {
+			rowid, record, err := d.leafCell(p, off)
+			if err != nil {
+				return err
+			}
+			if err := fn(rowid, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: page %d is not a table page", errCorrupt, n)
+}
+
+// leafCell decodes the table leaf cell at off on p.
+func (d *database) leafCell(p *btreePage, off int) (int64, []byte, error) {
+	size, n := readVarint(p.data[off:d.usable])
+	if n == 0 {
+		return 0, nil, fmt.Errorf("%w: cell overruns page %d", errCorrupt, p.n)
+	}
+	off += n
+	rowid, n := readVarint(p.data[off:d.usable])
+	if n == 0 {
+		return 0, nil, fmt.Errorf("%w: cell overruns page %d", errCorrupt, p.n)
+	}
+	record, err := d.payload(p, off+n, size, false)
+	return int64(rowid), record, err
+}
+
+// lookupRow returns the record of the row with rowid in the table b-tree
+// at root, or nil if there is none.
+func (d *database) lookupRow(root uint32, rowid int64) ([]byte, error) {
+	n := root
+	for depth := 0; depth <= maxDepth; depth++ {
+		p, err := d.btreePage(n)
+		if err != nil {
+			return nil, err
+		}
+		switch p.kind {
+		case pageTableInterior:
+			n = p.right
+			for _, off := range p.cells {
+				if off+5 > d.usable {
+					return nil, fmt.Errorf("%w: cell overruns page %d", errCorrupt, p.n)
+				}
+				key, k := readVarint(p.data[off+4 : d.usable])
+				if k == 0 {
+					return nil, fmt.Errorf("%w: cell overruns page %d", errCorrupt, p.n)
+				}
+				if rowid <= int64(key) {
+					n = binary.BigEndian.Uint32(p.data[off:])
+					break
+				}
+			}
+		case pageTableLeaf:
+			for _, off := range p.cells {
+				id, record, err := d.leafCell(p, off)
+				if err != nil {
+					return nil, err
+				}
+				if id == rowid {
+					return record, nil
+				}
+			}
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("%w: page %d is not a table page", errCorrupt, n)
+		}
+	}
+	return nil, fmt.Errorf("%w: b-tree deeper than %d pages", errCorrupt, maxDepth)
+}
+
+// readSchema returns the rows of the schema table.
+func (d *database) readSchema() ([]schemaEntry, error) {
+	var entries []schemaEntry
+	err := d.scanTable(1, func(_ int64, record []byte) error {
+		vals, err := parseRecord(record)
+		if err != nil {
+			return err
+		}
+		if len(vals) < 5 {
+			return fmt.Errorf("%w: schema row has %d columns", errCorrupt, len(vals))
+		}
+		var e schemaEntry
+		e.typ, _ = vals[0].(string)
+		e.name, _ = vals[1].(string)
+		e.table, _ = vals[2].(string)
+		if root, ok := vals[3].(int64); ok && root > 0 && root <= math.MaxUint32 {
+			e.root = uint32(root)
+		}
+		e.sql, _ = vals[4].(string)
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
@@ -0,0 +1,372 @@
+// Package mbtiles reads and writes MBTiles tilesets: SQLite databases of
+// tiles (https://github.com/mapbox/mbtiles-spec). The database file format
+// is implemented directly, without an SQL engine, so the package keeps the
+// build free of cgo and third-party modules.
+//
+// Written tilesets use the deduplicating layout of mbutil and tippecanoe:
+// a map table addresses tiles to rows of an images table that holds each
+// distinct tile once, and a tiles view joins the two for clients that
+// query tiles. The reader also accepts the plain layout with a tiles
+// table.
+package mbtiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/checkpoint"
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+)
+
+// The schema of written tilesets.
+const (
+	sqlMetadata      = "CREATE TABLE metadata (name TEXT, value TEXT)"
+	sqlMetadataIndex = "CREATE UNIQUE INDEX name ON metadata (name)"
+	sqlMap           = "CREATE TABLE map (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, tile_id INTEGER)"
+	sqlMapIndex      = "CREATE UNIQUE INDEX map_index ON map (zoom_level, tile_column, tile_row)"
+	sqlImages        = "CREATE TABLE images (tile_data BLOB, tile_id INTEGER PRIMARY KEY)"
+	sqlTiles         = "CREATE VIEW tiles AS SELECT map.zoom_level AS zoom_level, map.tile_column AS tile_column, " +
+		"map.tile_row AS tile_row, images.tile_data AS tile_data FROM map JOIN images ON images.tile_id = map.tile_id"
+)
+
+// entry is a written tile: its position and where its data is in the temp
+// file.
+type entry struct {
+	tileID uint64
+	offset int64
+	length int
+}
+
+// dedupEntry records the location of tile data in the temp file.
+type dedupEntry struct {
+	offset int64
+	length int
+}
+
+// Writer writes tiles to an MBTiles tileset. Like pmtiles.Writer, it
+// appends tile data to a temp file, storing identical tiles once, and
+// builds the database in Finalize; the options are pmtiles.WriterOptions,
+// of which it uses the zoom range, bounds, tile format and size, TempDir,
+// the metadata fields, FirstWriteWins, Sync, SyncDir, and ZoomOffset.
+//
+// Writing the same z/x/y more than once is allowed: Finalize keeps one
+// tile (the last write, or the first with FirstWriteWins) and counts the
+// rest in DuplicateTiles.
+type Writer struct {
+	outputPath string
+	opts       pmtiles.WriterOptions
+
+	mu         sync.Mutex
+	tmpFile    *os.File
+	tmpOffset  int64
+	entries    []entry
+	dedup      map[uint64]dedupEntry
+	duplicates int64
+	finalized  bool
+}
+
+// NewWriter creates a writer of the MBTiles tileset outputPath.
+func NewWriter(outputPath string, opts pmtiles.WriterOptions) (*Writer, error) {
+	tmpDir := opts.TempDir
+	if tmpDir == "" {
+		tmpDir = filepath.Dir(outputPath)
+	}
+	tmpFile, err := os.CreateTemp(tmpDir, "mbtiles-tiles-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("creating temp file: %w", err)
+	}
+	return &Writer{
+		outputPath: outputPath,
+		opts:       opts,
+		tmpFile:    tmpFile,
+		entries:    make([]entry, 0, 65536),
+		dedup:      make(map[uint64]dedupEntry),
+	}, nil
+}
+
+// WriteTile writes a single tile. Safe for concurrent use.
+func (w *Writer) WriteTile(z, x, y int, data []byte) error {
+	_, err := w.WriteTileLocated(z, x, y, data)
+	return err
+}
+
+// WriteTileLocated is WriteTile, also returning where data is in the tile
+// data written so far (see DataReader), or -1 for empty data, which is not
+// written.
+func (w *Writer) WriteTileLocated(z, x, y int, data []byte) (int64, error) {
+	if len(data) == 0 {
+		return -1, nil
+	}
+	tileID := pmtiles.ZXYToTileID(z, x, y)
+	h := fnv.New64a()
+	h.Write(data)
+	hash := h.Sum64()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if de, ok := w.dedup[hash]; ok && de.length == len(data) {
+		w.entries = append(w.entries, entry{tileID: tileID, offset: de.offset, length: de.length})
+		return de.offset, nil
+	}
+	offset := w.tmpOffset
+	if _, err := w.tmpFile.Write(data); err != nil {
+		return -1, fmt.Errorf("writing tile data: %w", err)
+	}
+	w.tmpOffset += int64(len(data))
+	w.dedup[hash] = dedupEntry{offset: offset, length: len(data)}
+	w.entries = append(w.entries, entry{tileID: tileID, offset: offset, length: len(data)})
+	return offset, nil
+}
+
+// DataReader returns a reader of the tile data written so far, at the
+// offsets WriteTileLocated returns. It is valid until Finalize or Abort.
+func (w *Writer) DataReader() io.ReaderAt {
+	return w.tmpFile
+}
+
+// DuplicateTiles returns the number of writes that were discarded because
+// the same z/x/y was written more than once. Valid after Finalize.
+func (w *Writer) DuplicateTiles() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.duplicates
+}
+
+// Finalize writes the tileset to the output path + pmtiles.PartialSuffix
+// and renames it into place.
+func (w *Writer) Finalize() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.finalized {
+		return fmt.Errorf("already finalized")
+	}
+	w.finalized = true
+
+	sort.SliceStable(w.entries, func(i, j int) bool {
+		return w.entries[i].tileID < w.entries[j].tileID
+	})
+	w.removeDuplicates()
+
+	partialPath := w.outputPath + pmtiles.PartialSuffix
+	f, err := os.Create(partialPath)
+	if err != nil {
+		return fmt.Errorf("creating output file: %w", err)
+	}
+	err = w.writeDatabase(f)
+	if err == nil && w.opts.Sync {
+		if err = f.Sync(); err != nil {
+			err = fmt.Errorf("syncing output file: %w", err)
+		}
+	}
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("closing output file: %w", cerr)
+	}
+	if err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+	if err := os.Rename(partialPath, w.outputPath); err != nil {
+		os.Remove(partialPath)
+		return fmt.Errorf("renaming output file: %w", err)
+	}
+	if w.opts.SyncDir {
+		if err := checkpoint.SyncDir(filepath.Dir(w.outputPath)); err != nil {
+			return fmt.Errorf("syncing output directory: %w", err)
+		}
+	}
+	w.Abort()
+	return nil
+}
+
+// Abort removes the temp file without writing the tileset.
+func (w *Writer) Abort() {
+	if w.tmpFile != nil {
+		w.tmpFile.Close()
+		os.Remove(w.tmpFile.Name())
+		w.tmpFile = nil
+	}
+}
+
+// removeDuplicates keeps one entry per tile ID (entries must be stably
+// sorted by tile ID): the last write unless FirstWriteWins is set.
+func (w *Writer) removeDuplicates() {
+	if len(w.entries) < 2 {
+		return
+	}
+	out := w.entries[:1]
+	for _, e := range w.entries[1:] {
+		last := &out[len(out)-1]
+		if e.tileID != last.tileID {
+			out = append(out, e)
+			continue
+		}
+		w.duplicates++
+		if !w.opts.FirstWriteWins {
+			*last = e
+		}
+	}
+	w.entries = out
+}
+
+// writeDatabase writes the tables, their indexes, and the schema to f.
+// The map rows follow tile ID order, and the images are numbered in the
+// order the map first refers to them, so tiles near each other on the
+// Hilbert curve are stored near each other.
+func (w *Writer) writeDatabase(f *os.File) error {
+	p := newPager(f)
+
+	metaRoot, metaIndexRoot, err := w.writeMetadata(p)
+	if err != nil {
+		return err
+	}
+
+	// The map, numbering each distinct tile as the map first uses it.
+	imageIDs := make(map[int64]int64)
+	var images []dedupEntry
+	mapTable := newBtreeWriter(p, false)
+	keys := make([][3]int64, len(w.entries))
+	var rec []byte
+	for i, e := range w.entries {
+		id, ok := imageIDs[e.offset]
+		if !ok {
+			images = append(images, dedupEntry{offset: e.offset, length: e.length})
+			id = int64(len(images))
+			imageIDs[e.offset] = id
+		}
+		z, x, y := pmtiles.TileIDToZXY(e.tileID)
+		row := int64(1)<<z - 1 - int64(y) // TMS rows count from the south
+		keys[i] = [3]int64{int64(z), int64(x), row}
+		rec = appendRecord(rec[:0], int64(z), int64(x), row, id)
+		if err := mapTable.addRow(int64(i+1), rec); err != nil {
+			return err
+		}
+	}
+	mapRoot, err := mapTable.finish()
+	if err != nil {
+		return err
+	}
+
+	// The map index, in (zoom, column, row) order.
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		ka, kb := keys[order[a]], keys[order[b]]
+		if ka[0] != kb[0] {
+			return ka[0] < kb[0]
+		}
+		if ka[1] != kb[1] {
+			return ka[1] < kb[1]
+		}
+		return ka[2] < kb[2]
+	})
+	mapIndex := newBtreeWriter(p, true)
+	for _, i := range order {
+		k := keys[i]
+		if err := mapIndex.addEntry(appendRecord(nil, k[0], k[1], k[2], int64(i+1))); err != nil {
+			return err
+		}
+	}
+	mapIndexRoot, err := mapIndex.finish()
+	if err != nil {
+		return err
+	}
+
+	// The images; tile_id is the rowid.
+	imageTable := newBtreeWriter(p, false)
+	var data []byte
+	for i, im := range images {
+		if cap(data) < im.length {
+			data = make([]byte, im.length)
+		}
+		data = data[:im.length]
+		if _, err := w.tmpFile.ReadAt(data, im.offset); err != nil {
+			return fmt.Errorf("reading tile data at offset %d: %w", im.offset, err)
+		}
+		rec = appendRecord(rec[:0], data, nil)
+		if err := imageTable.addRow(int64(i+1), rec); err != nil {
+			return err
+		}
+	}
+	imagesRoot, err := imageTable.finish()
+	if err != nil {
+		return err
+	}
+
+	return p.writeSchema([]schemaEntry{
+		{"table", "metadata", "metadata", metaRoot, sqlMetadata},
+		{"index", "name", "metadata", metaIndexRoot, sqlMetadataIndex},
+		{"table", "map", "map", mapRoot, sqlMap},
+		{"index", "map_index", "map", mapIndexRoot, sqlMapIndex},
+		{"table", "images", "images", imagesRoot, sqlImages},
+		{"view", "tiles", "tiles", 0, sqlTiles},
+	})
+}
+
+// writeMetadata writes the metadata table and its index on name.
+func (w *Writer) writeMetadata(p *pager) (root, indexRoot uint32, err error) {
+	rows := w.metadata()
+	names := make([]string, 0, len(rows))
+	for name := range rows {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	table := newBtreeWriter(p, false)
+	index := newBtreeWriter(p, true)
+	for i, name := range names {
+		if err := table.addRow(int64(i+1), appendRecord(nil, name, rows[name])); err != nil {
+			return 0, 0, err
+		}
+		if err := index.addEntry(appendRecord(nil, name, int64(i+1))); err != nil {
+			return 0, 0, err
+		}
+	}
+	if root, err = table.finish(); err != nil {
+		return 0, 0, err
+	}
+	indexRoot, err = index.finish()
+	return root, indexRoot, err
+}
+
+// metadata returns the rows of the metadata table: the keys a PMTiles
+// archive written with the same options would have, in the form the
+// MBTiles specification gives them. Values that are not strings are
+// stored as JSON, and vector_layers goes into the json row.
+func (w *Writer) metadata() map[string]string {
+	h := pmtiles.NewHeader(w.opts)
+	ents := make([]pmtiles.Entry, len(w.entries))
+	for i, e := range w.entries {
+		ents[i] = pmtiles.Entry{TileID: e.tileID, RunLength: 1}
+	}
+	pmtiles.PlaceCenter(&h, ents, w.opts)
+
+	meta := pmtiles.Metadata(w.opts, h)
+	rows := make(map[string]string, len(meta))
+	for k, v := range meta {
+		if s, ok := v.(string); ok {
+			rows[k] = s
+			continue
+		}
+		if k == "vector_layers" {
+			data, _ := json.Marshal(map[string]interface{}{k: v})
+			rows["json"] = string(data)
+			continue
+		}
+		data, _ := json.Marshal(v)
+		rows[k] = string(data)
+	}
+	if rows["format"] == "jpeg" {
+		rows["format"] = "jpg"
+	}
+	return rows
+}
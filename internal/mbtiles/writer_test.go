@@ -0,0 +1,229 @@
+package mbtiles
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+)
+
+// testTile returns distinct tile data for z/x/y, size bytes long, or
+// shared data for tiles on the diagonal, to exercise deduplication.
+func testTile(z, x, y, size int) []byte {
+	if x == y {
+		return bytes.Repeat([]byte{0xAB}, size)
+	}
+	b := []byte(fmt.Sprintf("tile %d/%d/%d ", z, x, y))
+	for len(b) < size {
+		b = append(b, byte(len(b)*7+x))
+	}
+	return b[:size]
+}
+
+// writeTestTileset writes zooms 0..maxZoom in full, tile sizes cycling
+// through sizes, and returns its path.
+func writeTestTileset(t *testing.T, maxZoom int, sizes []int, opts pmtiles.WriterOptions) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "out.mbtiles")
+	opts.MaxZoom = maxZoom
+	w, err := NewWriter(path, opts)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	i := 0
+	for z := maxZoom; z >= 0; z-- {
+		for x := 0; x < 1<<z; x++ {
+			for y := 0; y < 1<<z; y++ {
+				if err := w.WriteTile(z, x, y, testTile(z, x, y, sizes[i%len(sizes)])); err != nil {
+					t.Fatalf("WriteTile: %v", err)
+				}
+				i++
+			}
+		}
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+	return path
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	// Sizes on both sides of the overflow thresholds, and tiles spanning
+	// several overflow pages; z6 has enough rows for three-level b-trees.
+	sizes := []int{20, 1500, 4061, 4062, 9000, 70000}
+	path := writeTestTileset(t, 6, sizes, pmtiles.WriterOptions{
+		TileFormat: pmtiles.TileTypePNG,
+		TileSize:   512,
+		Bounds:     cog.Bounds{MinLon: -180, MinLat: -85, MaxLon: 180, MaxLat: 85},
+		Name:       "roundtrip",
+		Version:    "1.2",
+		Extra:      map[string]interface{}{"zoom_offset": 2},
+	})
+
+	r, err := OpenReader(path)
+	if err != nil {
+		t.Fatalf("OpenReader: %v", err)
+	}
+	defer r.Close()
+
+	i := 0
+	for z := 6; z >= 0; z-- {
+		if n := r.TileCountAtZoom(z); n != 1<<(2*z) {
+			t.Errorf("zoom %d: %d tiles, want %d", z, n, 1<<(2*z))
+		}
+		for x := 0; x < 1<<z; x++ {
+			for y := 0; y < 1<<z; y++ {
+				got, err := r.ReadTile(z, x, y)
+				if err != nil {
+					t.Fatalf("ReadTile(%d, %d, %d): %v", z, x, y, err)
+				}
+				if want := testTile(z, x, y, sizes[i%len(sizes)]); !bytes.Equal(got, want) {
+					t.Fatalf("tile %d/%d/%d: got %d bytes, want %d", z, x, y, len(got), len(want))
+				}
+				i++
+			}
+		}
+	}
+	if data, err := r.ReadTile(7, 0, 0); data != nil || err != nil {
+		t.Errorf("ReadTile of a missing tile = %d bytes, %v; want nil, nil", len(data), err)
+	}
+
+	h := r.Header()
+	if h.TileType != pmtiles.TileTypePNG || h.MinZoom != 0 || h.MaxZoom != 6 {
+		t.Errorf("header type %d zoom %d-%d, want PNG 0-6", h.TileType, h.MinZoom, h.MaxZoom)
+	}
+	if h.MinLon != -180 || h.MaxLat != 85 {
+		t.Errorf("header bounds %v,%v,%v,%v", h.MinLon, h.MinLat, h.MaxLon, h.MaxLat)
+	}
+	if got := r.TileSize(); got != 512 {
+		t.Errorf("TileSize = %d, want 512", got)
+	}
+	meta, _ := r.ReadMetadata()
+	if meta["name"] != "roundtrip" || meta["version"] != "1.2" || meta["format"] != "png" {
+		t.Errorf("metadata name, version, format = %v, %v, %v", meta["name"], meta["version"], meta["format"])
+	}
+	if v, ok := meta["zoom_offset"].(float64); !ok || v != 2 {
+		t.Errorf("metadata zoom_offset = %#v, want 2", meta["zoom_offset"])
+	}
+}
+
+func TestWriterDuplicates(t *testing.T) {
+	for _, firstWins := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "dup.mbtiles")
+		w, err := NewWriter(path, pmtiles.WriterOptions{TileFormat: pmtiles.TileTypeJPEG, FirstWriteWins: firstWins})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.WriteTile(1, 1, 0, []byte("first"))
+		w.WriteTile(1, 1, 0, []byte("second"))
+		w.WriteTile(1, 0, 0, nil) // empty: not written
+		if err := w.Finalize(); err != nil {
+			t.Fatal(err)
+		}
+		if n := w.DuplicateTiles(); n != 1 {
+			t.Errorf("DuplicateTiles = %d, want 1", n)
+		}
+		r, err := OpenReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "second"
+		if firstWins {
+			want = "first"
+		}
+		if got, _ := r.ReadTile(1, 1, 0); string(got) != want {
+			t.Errorf("FirstWriteWins %v: tile = %q, want %q", firstWins, got, want)
+		}
+		if r.NumTiles() != 1 || r.Header().TileType != pmtiles.TileTypeJPEG {
+			t.Errorf("NumTiles = %d, type %d; want 1 JPEG", r.NumTiles(), r.Header().TileType)
+		}
+		meta, _ := r.ReadMetadata()
+		if meta["format"] != "jpg" {
+			t.Errorf("format = %v, want jpg", meta["format"])
+		}
+		r.Close()
+	}
+}
+
+func TestWriterVectorLayers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "contours.mbtiles")
+	layers := []map[string]interface{}{{"id": "contour", "fields": map[string]string{"ele": "Number"}}}
+	w, err := NewWriter(path, pmtiles.WriterOptions{
+		TileFormat: pmtiles.TileTypeMVT,
+		Extra:      map[string]interface{}{"vector_layers": layers},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteTile(0, 0, 0, []byte{0x1f, 0x8b, 8, 0})
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	meta, _ := r.ReadMetadata()
+	if _, ok := meta["json"]; ok {
+		t.Error("json row was not merged into the metadata")
+	}
+	got, ok := meta["vector_layers"].([]interface{})
+	if !ok || len(got) != 1 {
+		t.Fatalf("vector_layers = %#v", meta["vector_layers"])
+	}
+	if h := r.Header(); h.TileType != pmtiles.TileTypeMVT || h.TileCompression != pmtiles.CompressionGzip {
+		t.Errorf("header type %d compression %d, want gzip MVT", h.TileType, h.TileCompression)
+	}
+}
+
+// TestWriterSQLite checks written tilesets with the sqlite3 command-line
+// tool: the database passes its integrity check, and the tiles view
+// returns the tiles in TMS rows.
+func TestWriterSQLite(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not available")
+	}
+	path := writeTestTileset(t, 6, []int{20, 5000, 70000}, pmtiles.WriterOptions{TileFormat: pmtiles.TileTypePNG})
+
+	query := func(sql string) string {
+		t.Helper()
+		out, err := exec.Command("sqlite3", path, sql).CombinedOutput()
+		if err != nil {
+			t.Fatalf("sqlite3 %q: %v\n%s", sql, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if got := query("PRAGMA integrity_check"); got != "ok" {
+		t.Fatalf("integrity_check: %s", got)
+	}
+	if got := query("SELECT count(*) FROM tiles"); got != "5461" {
+		t.Errorf("tiles: %s rows, want 5461", got)
+	}
+	// Tile 3/2/1 (XYZ) is row 6 in TMS.
+	want := fmt.Sprintf("%x", testTile(3, 2, 1, []int{20, 5000, 70000}[tileIndex(6, 3, 2, 1)%3]))
+	if got := query("SELECT lower(hex(tile_data)) FROM tiles WHERE zoom_level = 3 AND tile_column = 2 AND tile_row = 6"); got != want {
+		t.Errorf("tile 3/2/1: got %d hex digits, want %d", len(got), len(want))
+	}
+	if got := query("SELECT value FROM metadata WHERE name = 'format'"); got != "png" {
+		t.Errorf("format = %q", got)
+	}
+	if got := query("PRAGMA application_id"); got != fmt.Sprint(applicationID) {
+		t.Errorf("application_id = %s", got)
+	}
+}
+
+// tileIndex returns the position of z/x/y in the write order of
+// writeTestTileset.
+func tileIndex(maxZoom, z, x, y int) int {
+	i := 0
+	for zz := maxZoom; zz > z; zz-- {
+		i += 1 << (2 * zz)
+	}
+	return i + x<<z + y
+}
@@ -0,0 +1,137 @@
+package pmtiles
+
+import (
+	"math"
+	"sort"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+)
+
+// centerSampleTiles is the number of tiles the center is computed from: the
+// lowest zoom with at least this many tiles, or the highest zoom present, so
+// the cost does not grow with the archive.
+const centerSampleTiles = 1024
+
+// centerViewTiles is how many tiles across the default view should span: a
+// viewport of about 1024 pixels at 256 pixels per tile.
+const centerViewTiles = 4
+
+// coverageCenter returns a center point and default zoom that lie in the
+// coverage of entries (sorted by tile ID), instead of the bounding box
+// midpoint, which can fall into an empty gap. ok is false without tiles.
+// Zooms are stored labels; zoomOffset is subtracted to find the tile grid.
+//
+// The sample zoom's tiles are grouped by their ancestor at the default
+// zoom: the deepest zoom at which the covered extent spans at most
+// centerViewTiles tiles each way. The ancestor with the most tiles wins,
+// ties going to the one nearest the centroid of all tiles. The center is
+// the centroid of the winner's tiles, moved to the nearest of them when it
+// falls into a gap between them.
+func coverageCenter(entries []Entry, minZoom, zoomOffset int) (lon, lat float64, zoom int, ok bool) {
+	if len(entries) == 0 {
+		return 0, 0, 0, false
+	}
+	maxZoom, _, _ := TileIDToZXY(entries[len(entries)-1].TileID)
+	var sz int
+	var sample []Entry
+	for sz = 0; sz <= maxZoom; sz++ {
+		if sample = zoomRange(entries, sz); len(sample) >= centerSampleTiles || sz == maxZoom {
+			break
+		}
+	}
+
+	tiles := make([][2]int, len(sample))
+	minX, minY, maxX, maxY := math.MaxInt, math.MaxInt, -1, -1
+	var sumX, sumY float64
+	for i, e := range sample {
+		_, x, y := TileIDToZXY(e.TileID)
+		tiles[i] = [2]int{x, y}
+		minX, maxX = min(minX, x), max(maxX, x)
+		minY, maxY = min(minY, y), max(maxY, y)
+		sumX += float64(x) + 0.5
+		sumY += float64(y) + 0.5
+	}
+	cx, cy := sumX/float64(len(tiles)), sumY/float64(len(tiles))
+
+	// Default zoom: walk up from the sample zoom until the extent fits.
+	zoom = max(minZoom, zoomOffset)
+	for z := sz; z >= max(minZoom, zoomOffset); z-- {
+		s := sz - z
+		if (maxX>>s)-(minX>>s)+1 <= centerViewTiles && (maxY>>s)-(minY>>s)+1 <= centerViewTiles {
+			zoom = z
+			break
+		}
+	}
+	shift := max(sz-zoom, 0)
+
+	counts := make(map[[2]int]int)
+	for _, t := range tiles {
+		counts[[2]int{t[0] >> shift, t[1] >> shift}]++
+	}
+	size := float64(int(1) << shift)
+	dist := func(a [2]int) float64 {
+		dx := (float64(a[0])+0.5)*size - cx
+		dy := (float64(a[1])+0.5)*size - cy
+		return dx*dx + dy*dy
+	}
+	var best [2]int
+	bestN := 0
+	for a, n := range counts {
+		// Map order is random: break every tie so the archive is stable.
+		switch {
+		case n != bestN:
+			if n < bestN {
+				continue
+			}
+		case dist(a) != dist(best):
+			if dist(a) > dist(best) {
+				continue
+			}
+		case a[1] != best[1]:
+			if a[1] > best[1] {
+				continue
+			}
+		case a[0] > best[0]:
+			continue
+		}
+		best, bestN = a, n
+	}
+
+	// Centroid of the winning ancestor's tiles.
+	var in [][2]int
+	sumX, sumY = 0, 0
+	for _, t := range tiles {
+		if t[0]>>shift == best[0] && t[1]>>shift == best[1] {
+			in = append(in, t)
+			sumX += float64(t[0]) + 0.5
+			sumY += float64(t[1]) + 0.5
+		}
+	}
+	px, py := sumX/float64(len(in)), sumY/float64(len(in))
+	covered := false
+	nearest, nearestDist := in[0], math.Inf(1)
+	for _, t := range in {
+		if t[0] == int(px) && t[1] == int(py) {
+			covered = true
+			break
+		}
+		dx, dy := float64(t[0])+0.5-px, float64(t[1])+0.5-py
+		if d := dx*dx + dy*dy; d < nearestDist {
+			nearest, nearestDist = t, d
+		}
+	}
+	if !covered {
+		px, py = float64(nearest[0])+0.5, float64(nearest[1])+0.5
+	}
+
+	lon, lat = coord.PixelToLonLat(sz-zoomOffset, 0, 0, 1, px, py)
+	return lon, lat, zoom, true
+}
+
+// zoomRange returns the entries of zoom z from entries sorted by tile ID.
+func zoomRange(entries []Entry, z int) []Entry {
+	lo, hi := ZXYToTileID(z, 0, 0), ZXYToTileID(z+1, 0, 0)
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].TileID >= lo })
+	j := sort.Search(len(entries), func(i int) bool { return entries[i].TileID >= hi })
+	return entries[i:j]
+}
@@ -0,0 +1,66 @@
+package pmtiles
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+)
+
+// centerTestEntries returns sorted entries for tiles (z, x, y).
+func centerTestEntries(tiles [][3]int) []Entry {
+	entries := make([]Entry, len(tiles))
+	for i, t := range tiles {
+		entries[i] = Entry{TileID: ZXYToTileID(t[0], t[1], t[2]), Length: 1, RunLength: 1}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].TileID < entries[j].TileID })
+	return entries
+}
+
+func TestCoverageCenter_LShape(t *testing.T) {
+	// An L of zoom-6 tiles: a column x 0-1, y 0-15 and a row x 0-15,
+	// y 14-15. The bounding box midpoint (8, 8) lies in the empty corner.
+	covered := make(map[[2]int]bool)
+	var tiles [][3]int
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			if x < 2 || y >= 14 {
+				covered[[2]int{x, y}] = true
+				tiles = append(tiles, [3]int{6, x, y})
+			}
+		}
+	}
+	lon, lat, zoom, ok := coverageCenter(centerTestEntries(tiles), 0, 0)
+	if !ok {
+		t.Fatal("coverageCenter: not ok")
+	}
+	x, y := coord.LonLatToTile(lon, lat, 6)
+	if !covered[[2]int{x, y}] {
+		t.Errorf("center %.4f,%.4f lies in tile 6/%d/%d, which has no data", lon, lat, x, y)
+	}
+	// 16 tiles across at zoom 6 fit 4 across at zoom 4.
+	if zoom != 4 {
+		t.Errorf("zoom = %d, want 4", zoom)
+	}
+}
+
+func TestCoverageCenter_SingleTile(t *testing.T) {
+	lon, lat, zoom, ok := coverageCenter(centerTestEntries([][3]int{{0, 0, 0}, {10, 533, 358}}), 0, 0)
+	if !ok || zoom != 10 {
+		t.Fatalf("coverageCenter = zoom %d, ok %v; want zoom 10", zoom, ok)
+	}
+	wantLon, wantLat := coord.PixelToLonLat(10, 533, 358, 1, 0.5, 0.5)
+	if lon != wantLon || lat != wantLat {
+		t.Errorf("center = %f,%f, want the tile center %f,%f", lon, lat, wantLon, wantLat)
+	}
+
+	// With a zoom offset of 1, zoom label 10 is grid zoom 9.
+	lon, _, _, _ = coverageCenter(centerTestEntries([][3]int{{10, 266, 179}}), 0, 1)
+	if wantLon, _ := coord.PixelToLonLat(9, 266, 179, 1, 0.5, 0.5); lon != wantLon {
+		t.Errorf("zoom offset 1: lon = %f, want %f", lon, wantLon)
+	}
+
+	if _, _, _, ok := coverageCenter(nil, 0, 0); ok {
+		t.Error("coverageCenter(nil) ok, want not ok")
+	}
+}
@@ -0,0 +1,188 @@
+package pmtiles
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/checkpoint"
+)
+
+// checkpointMagic starts a writer checkpoint file.
+const checkpointMagic = "PMTWCKP1"
+
+// Checkpoint saves what the writer has written so far to path, for
+// ResumeWriter: the temp file is synced and linked to path+".data" (see
+// checkpoint.Link), and its length, the entries, the dedup index, and the
+// completed zooms are written to path.
+//
+// Format (little-endian): magic, temp length (uint64), dedup hits (uint64),
+// entry count (uint64) + [tile ID (uint64) offset (uint64) length (uint32)
+// run length (uint32)] × count, dedup count (uint64) + [hash (uint64)
+// offset (uint64) length (uint32)] × count, completed zoom count (uint32) +
+// [zoom (uint32)] × count.
+//
+// Ranges copied by CopyTile are not saved; a resumed writer writes such
+// data again instead of sharing it.
+func (w *Writer) Checkpoint(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.finalized {
+		return fmt.Errorf("writer already finalized")
+	}
+	if w.opts.Streaming {
+		return fmt.Errorf("a streaming writer cannot be checkpointed")
+	}
+
+	if err := w.tmpFile.Sync(); err != nil {
+		return fmt.Errorf("syncing tile data: %w", err)
+	}
+	if err := checkpoint.Link(w.tmpFile.Name(), path+".data"); err != nil {
+		return fmt.Errorf("linking tile data: %w", err)
+	}
+
+	return checkpoint.WriteFile(path, func(out io.Writer) error {
+		b := make([]byte, 0, 64)
+		b = append(b, checkpointMagic...)
+		b = binary.LittleEndian.AppendUint64(b, w.tmpOffset)
+		b = binary.LittleEndian.AppendUint64(b, uint64(w.dedupHits))
+		b = binary.LittleEndian.AppendUint64(b, uint64(len(w.entries)))
+		if _, err := out.Write(b); err != nil {
+			return err
+		}
+		for _, e := range w.entries {
+			b = binary.LittleEndian.AppendUint64(b[:0], e.TileID)
+			b = binary.LittleEndian.AppendUint64(b, e.Offset)
+			b = binary.LittleEndian.AppendUint32(b, e.Length)
+			b = binary.LittleEndian.AppendUint32(b, e.RunLength)
+			if _, err := out.Write(b); err != nil {
+				return err
+			}
+		}
+
+		b = binary.LittleEndian.AppendUint64(b[:0], uint64(len(w.dedup)))
+		if _, err := out.Write(b); err != nil {
+			return err
+		}
+		for hash, de := range w.dedup {
+			b = binary.LittleEndian.AppendUint64(b[:0], hash)
+			b = binary.LittleEndian.AppendUint64(b, de.offset)
+			b = binary.LittleEndian.AppendUint32(b, de.length)
+			if _, err := out.Write(b); err != nil {
+				return err
+			}
+		}
+
+		b = binary.LittleEndian.AppendUint32(b[:0], uint32(len(w.complete)))
+		for z := range w.complete {
+			b = binary.LittleEndian.AppendUint32(b, uint32(z))
+		}
+		_, err := out.Write(b)
+		return err
+	})
+}
+
+// ResumeWriter creates a writer that continues from a checkpoint written by
+// Writer.Checkpoint at path. Tile data written after the checkpoint is cut
+// off. The writer appends to a new link to the checkpoint's data file, so
+// the checkpoint stays usable if this run is interrupted too, and Abort or
+// Finalize remove only the link.
+func ResumeWriter(outputPath string, opts WriterOptions, path string) (*Writer, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening writer checkpoint: %w", err)
+	}
+	defer in.Close()
+	r := bufio.NewReaderSize(in, 1<<20)
+
+	tmpDir := opts.TempDir
+	if tmpDir == "" {
+		tmpDir = filepath.Dir(outputPath)
+	}
+	tmpFile, err := checkpoint.Adopt(path+".data", tmpDir, "pmtiles-tiles-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("opening checkpoint tile data: %w", err)
+	}
+	w := newWriter(outputPath, opts, tmpDir, tmpFile)
+	if err := w.readCheckpoint(r); err != nil {
+		w.Abort()
+		return nil, fmt.Errorf("reading writer checkpoint %s: %w", path, err)
+	}
+
+	if err := tmpFile.Truncate(int64(w.tmpOffset)); err != nil {
+		w.Abort()
+		return nil, fmt.Errorf("truncating checkpoint tile data: %w", err)
+	}
+	if _, err := tmpFile.Seek(int64(w.tmpOffset), io.SeekStart); err != nil {
+		w.Abort()
+		return nil, fmt.Errorf("seeking checkpoint tile data: %w", err)
+	}
+	if w.index != nil {
+		for _, e := range w.entries {
+			w.indexTile(e.TileID, dedupEntry{offset: e.Offset, length: e.Length})
+		}
+	}
+	return w, nil
+}
+
+// readCheckpoint reads the state written by Checkpoint into w.
+func (w *Writer) readCheckpoint(r io.Reader) error {
+	b := make([]byte, 24)
+	if _, err := io.ReadFull(r, b[:len(checkpointMagic)]); err != nil {
+		return err
+	}
+	if string(b[:len(checkpointMagic)]) != checkpointMagic {
+		return fmt.Errorf("not a writer checkpoint")
+	}
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	w.tmpOffset = binary.LittleEndian.Uint64(b[0:8])
+	w.dedupHits = int64(binary.LittleEndian.Uint64(b[8:16]))
+	n := binary.LittleEndian.Uint64(b[16:24])
+
+	w.entries = make([]Entry, 0, n)
+	for i := uint64(0); i < n; i++ {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return err
+		}
+		w.entries = append(w.entries, Entry{
+			TileID:    binary.LittleEndian.Uint64(b[0:8]),
+			Offset:    binary.LittleEndian.Uint64(b[8:16]),
+			Length:    binary.LittleEndian.Uint32(b[16:20]),
+			RunLength: binary.LittleEndian.Uint32(b[20:24]),
+		})
+	}
+
+	if _, err := io.ReadFull(r, b[:8]); err != nil {
+		return err
+	}
+	n = binary.LittleEndian.Uint64(b[:8])
+	w.dedup = make(map[uint64]dedupEntry, n)
+	for i := uint64(0); i < n; i++ {
+		if _, err := io.ReadFull(r, b[:20]); err != nil {
+			return err
+		}
+		w.dedup[binary.LittleEndian.Uint64(b[0:8])] = dedupEntry{
+			offset: binary.LittleEndian.Uint64(b[8:16]),
+			length: binary.LittleEndian.Uint32(b[16:20]),
+		}
+	}
+
+	if _, err := io.ReadFull(r, b[:4]); err != nil {
+		return err
+	}
+	zooms := binary.LittleEndian.Uint32(b[:4])
+	for i := uint32(0); i < zooms; i++ {
+		if _, err := io.ReadFull(r, b[:4]); err != nil {
+			return err
+		}
+		if w.complete != nil {
+			w.complete[int(binary.LittleEndian.Uint32(b[:4]))] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package pmtiles
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+)
+
+func TestWriter_CheckpointResume(t *testing.T) {
+	tmpDir := t.TempDir()
+	outPath := filepath.Join(tmpDir, "resume.pmtiles")
+	ckPath := filepath.Join(tmpDir, "z01.writer")
+	opts := WriterOptions{
+		MinZoom:    0,
+		MaxZoom:    2,
+		Bounds:     cog.Bounds{MinLon: -10, MinLat: -10, MaxLon: 10, MaxLat: 10},
+		TileFormat: TileTypePNG,
+		TileSize:   256,
+		TempDir:    tmpDir,
+		Readable:   true,
+	}
+
+	w, err := NewWriter(outPath, opts)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	shared := []byte("shared-tile")
+	for _, tile := range [][3]int{{2, 0, 0}, {2, 1, 0}, {1, 0, 0}} {
+		if err := w.WriteTile(tile[0], tile[1], tile[2], shared); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.WriteTile(2, 2, 1, []byte("unique-z2")); err != nil {
+		t.Fatal(err)
+	}
+	w.CompleteZoom(2)
+	if err := w.Checkpoint(ckPath); err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	// Work after the checkpoint is lost with the crash.
+	if err := w.WriteTile(0, 0, 0, []byte("lost")); err != nil {
+		t.Fatal(err)
+	}
+	w.Abort()
+
+	r, err := ResumeWriter(outPath, opts, ckPath)
+	if err != nil {
+		t.Fatalf("ResumeWriter: %v", err)
+	}
+	if got, err := r.ReadTile(2, 2, 1); err != nil || string(got) != "unique-z2" {
+		t.Errorf("ReadTile(2/2/1) after resume = %q, %v; want unique-z2", got, err)
+	}
+	if err := r.WriteTile(1, 1, 0, shared); err != nil {
+		t.Fatal(err)
+	}
+	if r.dedupHits != 3 {
+		t.Errorf("dedupHits = %d, want 3 (the checkpoint's dedup index is kept)", r.dedupHits)
+	}
+	if err := r.WriteTile(0, 0, 0, []byte("root")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Finalize(); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+	if _, err := os.Stat(ckPath + ".data"); err != nil {
+		t.Errorf("checkpoint data removed by Finalize: %v", err)
+	}
+
+	reader, err := OpenReader(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	for tile, want := range map[[3]int]string{
+		{2, 0, 0}: "shared-tile", {2, 1, 0}: "shared-tile", {2, 2, 1}: "unique-z2",
+		{1, 0, 0}: "shared-tile", {1, 1, 0}: "shared-tile", {0, 0, 0}: "root",
+	} {
+		got, err := reader.ReadTile(tile[0], tile[1], tile[2])
+		if err != nil || !bytes.Equal(got, []byte(want)) {
+			t.Errorf("tile %v = %q, %v; want %q", tile, got, err, want)
+		}
+	}
+	if n := reader.NumTiles(); n != 6 {
+		t.Errorf("NumTiles = %d, want 6", n)
+	}
+}
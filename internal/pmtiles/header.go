@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
 )
@@ -72,6 +73,9 @@ func NewHeader(opts WriterOptions) Header {
 		CenterLon:           float32((opts.Bounds.MinLon + opts.Bounds.MaxLon) / 2),
 		CenterLat:           float32((opts.Bounds.MinLat + opts.Bounds.MaxLat) / 2),
 	}
+	if opts.TileCompression != 0 {
+		h.TileCompression = opts.TileCompression
+	}
 	return h
 }
 
@@ -197,12 +201,80 @@ type WriterOptions struct {
 	// Name is the archive name for the metadata JSON.
 	// Defaults to "geotiff2pmtiles" when empty.
 	Name string
+	// Version is the tileset version for the metadata JSON (TileJSON
+	// "version", e.g. "1.2.0"). Omitted when empty.
+	Version string
+	// LayerID is a stable identifier for the tileset, stored as "id" in the
+	// metadata JSON, for catalogs and servers that key layers by ID.
+	// Omitted when empty.
+	LayerID string
 	// Description is stored in the PMTiles metadata JSON.
 	// When empty, defaults to "Generated from GeoTIFF files".
 	Description string
 	// Attribution is a string crediting data sources, displayed by map renderers.
 	Attribution string
+	// GeneratedAt is stored as "generated_at" (RFC 3339, UTC) in the
+	// metadata JSON: when the archive's content was first produced.
+	// Omitted when zero, which keeps test archives byte-identical.
+	GeneratedAt time.Time
+	// UpdatedAt is stored as "updated_at": when an archive rewritten in
+	// place (e.g. a --serve flush) last changed. Omitted when zero.
+	UpdatedAt time.Time
 	// Type categorizes the tileset: "baselayer" or "overlay".
 	// Defaults to "baselayer" when empty.
 	Type string
+	// Readable keeps an in-memory tile index so ReadTile can serve tiles
+	// from completed zoom levels while generation is still running.
+	Readable bool
+	// Extra holds additional keys for the metadata JSON (e.g. source
+	// provenance). Keys the writer sets itself take precedence.
+	Extra map[string]interface{}
+	// FirstWriteWins keeps the first write when the same z/x/y is written
+	// more than once. By default the last write wins.
+	FirstWriteWins bool
+	// Sync fsyncs the archive before it is renamed into place. Finalize
+	// always writes to the output path + ".partial" and renames, so the
+	// output path never holds a half-written archive; Sync additionally
+	// makes the contents durable across a power loss.
+	Sync bool
+	// SyncDir fsyncs the output directory after the rename, making the
+	// rename itself durable.
+	SyncDir bool
+	// StableLayout places the tile data at the fixed offset
+	// StableTileDataOffset, right after the root directory, and moves the
+	// metadata and leaf directories behind it. Rebuilds of a mostly
+	// unchanged area then keep unchanged tiles at the same byte offsets,
+	// which delta-sync tools (rsync, zsync, S3 multipart copy) can reuse.
+	// Readers need a second range request for the metadata.
+	StableLayout bool
+	// EstimatedTiles is the expected number of tiles, e.g. the tile count
+	// of the bounds over the zoom range. It sizes the extents in which the
+	// temp file is preallocated; 0 uses the smallest extent.
+	EstimatedTiles int64
+	// ZoomOffset is added to the tile grid's zoom in the stored zoom
+	// levels (--zoom-offset). The writer subtracts it to place the center.
+	ZoomOffset int
+	// Streaming writes the tile data straight into the archive instead of
+	// a temp file: the output's .partial file is created with the writer,
+	// tiles are appended at StableTileDataOffset as they arrive, and
+	// Finalize adds only the metadata and directories (the StableLayout
+	// layout). Finalize then costs the directory instead of a copy of all
+	// tile data, and the disk holds the data once instead of twice. Where
+	// the file system can collapse ranges, the max zoom is appended behind
+	// a sparse gap that the lower zooms are copied into as they complete,
+	// and Finalize collapses what is left of it: the archive is clustered
+	// if the max zoom arrives in tile-ID order (tile.Config.OrderedWrites),
+	// and its tile data starts within a block of StableTileDataOffset.
+	// Elsewhere the data stays in write order and the archive is clustered
+	// only when that is tile-ID order. TempDir is unused: the lower zooms'
+	// segment files are kept next to the output.
+	Streaming bool
+	// TileCompression is the compression of the tile data as written, for
+	// the header (e.g. CompressionGzip for MVT tiles). 0 means
+	// CompressionNone: image tiles are already compressed.
+	TileCompression uint8
+	// PremultipliedAlpha records in the metadata that the tiles store
+	// their colors multiplied by alpha (--premultiply-alpha), as
+	// "premultiplied_alpha": true. Omitted when false.
+	PremultipliedAlpha bool
 }
@@ -0,0 +1,30 @@
+package pmtiles
+
+import "fmt"
+
+// Merge writes the tiles of archives into w, one archive after the other,
+// e.g. the shards of a geotiff2pmtiles --shard run. The archives must store
+// w's tile type and tile compression; tiles are copied as they are. Where
+// archives overlap, the tile of the later one is kept (see
+// Writer.DuplicateTiles). Returns the number of tiles written.
+func Merge(w *Writer, archives []*Reader) (int64, error) {
+	for i, r := range archives {
+		h := r.Header()
+		if h.TileType != w.header.TileType || h.TileCompression != w.header.TileCompression {
+			return 0, fmt.Errorf("archive %d: %s tiles with compression %d, want %s with compression %d",
+				i, TileTypeString(h.TileType), h.TileCompression, TileTypeString(w.header.TileType), w.header.TileCompression)
+		}
+	}
+
+	var n int64
+	for i, r := range archives {
+		err := r.Stream(func(z, x, y int, data []byte) error {
+			n++
+			return w.WriteTile(z, x, y, data)
+		})
+		if err != nil {
+			return n, fmt.Errorf("archive %d: %w", i, err)
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,93 @@
+package pmtiles
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+)
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	opts := WriterOptions{
+		MinZoom:    0,
+		MaxZoom:    2,
+		Bounds:     cog.Bounds{MinLon: -10, MinLat: -10, MaxLon: 10, MaxLat: 10},
+		TileFormat: TileTypePNG,
+		TileSize:   256,
+	}
+	tileData := func(z, x, y int) []byte { return []byte(fmt.Sprintf("tile %d/%d/%d", z, x, y)) }
+	write := func(name string, opts WriterOptions, tiles [][3]int) *Reader {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		w, err := NewWriter(path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tl := range tiles {
+			if err := w.WriteTile(tl[0], tl[1], tl[2], tileData(tl[0], tl[1], tl[2])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Finalize(); err != nil {
+			t.Fatal(err)
+		}
+		r, err := OpenReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { r.Close() })
+		return r
+	}
+
+	// Shards of a zoom band 0-1 and of two cells of zoom 2.
+	low := write("low.pmtiles", opts, [][3]int{{0, 0, 0}, {1, 0, 0}, {1, 1, 1}})
+	west := write("west.pmtiles", opts, [][3]int{{2, 0, 0}, {2, 1, 1}})
+	east := write("east.pmtiles", opts, [][3]int{{2, 2, 1}, {2, 3, 3}})
+
+	outPath := filepath.Join(dir, "merged.pmtiles")
+	w, err := NewWriter(outPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := Merge(w, []*Reader{low, west, east})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 7 {
+		t.Errorf("merged %d tiles, want 7", n)
+	}
+
+	r, err := OpenReader(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.NumTiles() != 7 || !r.Header().Clustered {
+		t.Errorf("merged archive: %d tiles, clustered %v; want 7, clustered", r.NumTiles(), r.Header().Clustered)
+	}
+	for _, tl := range [][3]int{{0, 0, 0}, {1, 1, 1}, {2, 1, 1}, {2, 3, 3}} {
+		got, err := r.ReadTile(tl[0], tl[1], tl[2])
+		if err != nil || !bytes.Equal(got, tileData(tl[0], tl[1], tl[2])) {
+			t.Errorf("tile %v = %q, %v", tl, got, err)
+		}
+	}
+
+	// Archives of another tile type are rejected before anything is written.
+	jpegOpts := opts
+	jpegOpts.TileFormat = TileTypeJPEG
+	jpeg := write("jpeg.pmtiles", jpegOpts, [][3]int{{0, 0, 0}})
+	w, err = NewWriter(filepath.Join(dir, "mixed.pmtiles"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Abort()
+	if n, err := Merge(w, []*Reader{low, jpeg}); err == nil || n != 0 {
+		t.Errorf("Merge of png and jpeg = %d, %v; want an error", n, err)
+	}
+}
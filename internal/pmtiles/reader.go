@@ -6,11 +6,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/hint"
 )
 
-// Reader provides read access to an existing PMTiles v3 archive.
+// Reader provides read access to an existing PMTiles v3 archive. Its index
+// is not modified after OpenReader and tile data is read with ReadAt, so all
+// methods are safe for concurrent use, and ReadTile may be called from the
+// callbacks of ForEachTileAtZoom.
 type Reader struct {
 	file    *os.File
 	header  Header
@@ -24,6 +32,26 @@ type tileRef struct {
 	length uint32
 }
 
+// headerError adds a remediation hint to an error reading the header of
+// path, whose first bytes are buf: other tile containers and older PMTiles
+// versions can be converted with the pmtiles CLI.
+func headerError(path string, buf []byte, err error) error {
+	out := strings.TrimSuffix(path, filepath.Ext(path))
+	switch {
+	case bytes.HasPrefix(buf, []byte("SQLite format 3\x00")) && filepath.Ext(path) == ".mbtiles":
+		return hint.Wrap(err, path, "not a PMTiles archive (this is an MBTiles file)",
+			"pmtransform "+path+" "+out+".pmtiles")
+	case bytes.HasPrefix(buf, []byte("SQLite format 3\x00")):
+		return hint.Wrap(err, path, "not a PMTiles archive (this is an SQLite file, e.g. MBTiles)",
+			"pmtiles convert "+path+" "+out+".pmtiles")
+	case len(buf) >= 3 && bytes.HasPrefix(buf, []byte("PM")) && !bytes.HasPrefix(buf, []byte("PMTiles")):
+		// Versions 1 and 2 start with "PM" and a little-endian uint16 version.
+		return hint.Wrap(err, path, fmt.Sprintf("PMTiles version %d archives are not supported", buf[2]),
+			"pmtiles convert "+path+" "+out+"_v3.pmtiles")
+	}
+	return fmt.Errorf("%s: %w", path, err)
+}
+
 // OpenReader opens a PMTiles v3 archive for reading.
 func OpenReader(path string) (*Reader, error) {
 	f, err := os.Open(path)
@@ -33,15 +61,15 @@ func OpenReader(path string) (*Reader, error) {
 
 	// Read header.
 	headerBuf := make([]byte, HeaderSize)
-	if _, err := io.ReadFull(f, headerBuf); err != nil {
+	if n, err := io.ReadFull(f, headerBuf); err != nil {
 		f.Close()
-		return nil, fmt.Errorf("reading header: %w", err)
+		return nil, headerError(path, headerBuf[:n], fmt.Errorf("reading header: %w", err))
 	}
 
 	header, err := DeserializeHeader(headerBuf)
 	if err != nil {
 		f.Close()
-		return nil, err
+		return nil, headerError(path, headerBuf, err)
 	}
 
 	// Read root directory.
@@ -132,32 +160,124 @@ func (r *Reader) ReadTile(z, x, y int) ([]byte, error) {
 	return data, nil
 }
 
-// TilesAtZoom returns all [z, x, y] coordinates that have tiles at the given zoom level.
-func (r *Reader) TilesAtZoom(z int) [][3]int {
-	// Compute the tile ID range for this zoom level.
-	var minID uint64
-	for i := 0; i < z; i++ {
-		n := uint64(1) << uint(i)
-		minID += n * n
+// HasTile reports whether the archive has a tile at z/x/y.
+func (r *Reader) HasTile(z, x, y int) bool {
+	_, ok := r.tileIdx[ZXYToTileID(z, x, y)]
+	return ok
+}
+
+// TileCountAtZoom returns the number of tiles at zoom z without listing
+// them.
+func (r *Reader) TileCountAtZoom(z int) int {
+	return len(r.zoomEntries(z, z))
+}
+
+// ForEachTileAtZoom calls fn with the coordinates of every tile at zoom z,
+// in tileID order, without building a list of them. An error from fn stops
+// the walk and is returned.
+func (r *Reader) ForEachTileAtZoom(z int, fn func(x, y int) error) error {
+	for _, e := range r.zoomEntries(z, z) {
+		_, x, y := TileIDToZXY(e.TileID)
+		if err := fn(x, y); err != nil {
+			return err
+		}
 	}
-	n := uint64(1) << uint(z)
-	maxID := minID + n*n // exclusive
+	return nil
+}
 
-	// Binary search for the first entry >= minID.
+// TilesAtZoom returns all [z, x, y] coordinates that have tiles at the given
+// zoom level. The list takes 24 bytes per tile, hundreds of MB at z18 for a
+// country: prefer ForEachTileAtZoom and TileCountAtZoom on large archives.
+func (r *Reader) TilesAtZoom(z int) [][3]int {
+	tiles := make([][3]int, 0, r.TileCountAtZoom(z))
+	r.ForEachTileAtZoom(z, func(x, y int) error {
+		tiles = append(tiles, [3]int{z, x, y})
+		return nil
+	})
+	return tiles
+}
+
+// zoomEntries returns the entries of zooms minZoom..maxZoom, in tileID order.
+func (r *Reader) zoomEntries(minZoom, maxZoom int) []Entry {
+	minID := ZXYToTileID(minZoom, 0, 0)
+	maxID := ZXYToTileID(maxZoom+1, 0, 0) // first ID of the next zoom
 	start := sort.Search(len(r.entries), func(i int) bool {
 		return r.entries[i].TileID >= minID
 	})
+	end := sort.Search(len(r.entries), func(i int) bool {
+		return r.entries[i].TileID >= maxID
+	})
+	return r.entries[start:end]
+}
 
-	var tiles [][3]int
-	for i := start; i < len(r.entries); i++ {
-		e := r.entries[i]
-		if e.TileID >= maxID {
-			break
+// streamChunk is the size of the sequential reads Stream makes (a variable
+// so tests can force refills).
+var streamChunk = 4 << 20
+
+// Stream calls fn for every tile of the archive in tileID order, which in a
+// clustered archive is file order: tile data is read in large sequential
+// chunks instead of one seek per tile. Deduplicated tiles that point back
+// to earlier data, and unclustered archives, fall back to single reads.
+//
+// data is only valid until fn returns; copy it to keep it. An error from fn
+// stops the walk and is returned.
+func (r *Reader) Stream(fn func(z, x, y int, data []byte) error) error {
+	return r.StreamZooms(0, int(r.header.MaxZoom), fn)
+}
+
+// StreamZooms is Stream restricted to zooms minZoom..maxZoom. Tiles of other
+// zooms are not read.
+func (r *Reader) StreamZooms(minZoom, maxZoom int, fn func(z, x, y int, data []byte) error) error {
+	return r.StreamZoomRefs(minZoom, maxZoom, func(z, x, y int, _ uint64, data []byte) error {
+		return fn(z, x, y, data)
+	})
+}
+
+// StreamZoomRefs is StreamZooms that also passes the file offset of each
+// tile's data. Deduplicated tiles share an offset, so a copy can keep the
+// sharing without hashing the data (see Writer.CopyTile).
+func (r *Reader) StreamZoomRefs(minZoom, maxZoom int, fn func(z, x, y int, offset uint64, data []byte) error) error {
+	var (
+		window  []byte // file bytes [winOff, winOff+len(window))
+		winOff  uint64
+		scratch []byte // for reads behind the window
+	)
+	for _, e := range r.zoomEntries(minZoom, maxZoom) {
+		end := e.Offset + uint64(e.Length)
+		var data []byte
+		switch {
+		case e.Offset >= winOff && end <= winOff+uint64(len(window)):
+			data = window[e.Offset-winOff : end-winOff]
+		case e.Offset >= winOff:
+			// Ahead of the window: read the next chunk from here.
+			size := max(streamChunk, int(e.Length))
+			if cap(window) < size {
+				window = make([]byte, size)
+			}
+			window = window[:size]
+			n, err := r.file.ReadAt(window, int64(e.Offset))
+			if n < int(e.Length) {
+				z, x, y := TileIDToZXY(e.TileID)
+				return fmt.Errorf("reading tile z%d/%d/%d: %w", z, x, y, err)
+			}
+			window, winOff = window[:n], e.Offset
+			data = window[:e.Length]
+		default:
+			if cap(scratch) < int(e.Length) {
+				scratch = make([]byte, e.Length)
+			}
+			data = scratch[:e.Length]
+			if _, err := r.file.ReadAt(data, int64(e.Offset)); err != nil {
+				z, x, y := TileIDToZXY(e.TileID)
+				return fmt.Errorf("reading tile z%d/%d/%d: %w", z, x, y, err)
+			}
+		}
+		z, x, y := TileIDToZXY(e.TileID)
+		if err := fn(z, x, y, e.Offset, data); err != nil {
+			return err
 		}
-		_, x, y := TileIDToZXY(e.TileID)
-		tiles = append(tiles, [3]int{z, x, y})
 	}
-	return tiles
+	return nil
 }
 
 // NumTiles returns the total number of tiles in the archive.
@@ -165,6 +285,33 @@ func (r *Reader) NumTiles() int {
 	return len(r.entries)
 }
 
+// TileSize returns the raster tile size recorded as "tile_size" in the
+// archive metadata, or 0 when it is not recorded, as in archives written
+// by other tools or before the key was written.
+func (r *Reader) TileSize() int {
+	meta, err := r.ReadMetadata()
+	if err != nil {
+		return 0
+	}
+	v, ok := meta["tile_size"].(float64)
+	if !ok || v < 1 || v != math.Trunc(v) || v > math.MaxInt32 {
+		return 0
+	}
+	return int(v)
+}
+
+// PremultipliedAlpha reports whether the metadata records the tiles as
+// storing premultiplied colors ("premultiplied_alpha": true, written for
+// --premultiply-alpha). Decode such tiles with encode.DecodePremultiplied.
+func (r *Reader) PremultipliedAlpha() bool {
+	meta, err := r.ReadMetadata()
+	if err != nil {
+		return false
+	}
+	v, _ := meta["premultiplied_alpha"].(bool)
+	return v
+}
+
 // ReadMetadata reads and decompresses the JSON metadata from the archive.
 // Returns nil if the archive has no metadata.
 func (r *Reader) ReadMetadata() (map[string]interface{}, error) {
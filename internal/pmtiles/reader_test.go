@@ -0,0 +1,227 @@
+package pmtiles
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/hint"
+)
+
+// writeStreamTestArchive writes every tile of zooms 0-3, with a duplicate
+// content every third tile, and returns the expected data by tile.
+func writeStreamTestArchive(t *testing.T) (string, map[[3]int][]byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stream.pmtiles")
+	w, err := NewWriter(path, WriterOptions{
+		MinZoom: 0, MaxZoom: 3,
+		Bounds:     cog.Bounds{MinLon: -180, MaxLon: 180, MinLat: -85, MaxLat: 85},
+		TileFormat: TileTypePNG, TileSize: 256,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[[3]int][]byte)
+	for z := 0; z <= 3; z++ {
+		for x := 0; x < 1<<z; x++ {
+			for y := 0; y < 1<<z; y++ {
+				data := []byte(fmt.Sprintf("tile %d/%d/%d %s", z, x, y, bytes.Repeat([]byte{'.'}, x*7+y)))
+				if (x+y)%3 == 0 {
+					data = []byte("shared")
+				}
+				if err := w.WriteTile(z, x, y, data); err != nil {
+					t.Fatal(err)
+				}
+				want[[3]int{z, x, y}] = data
+			}
+		}
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	return path, want
+}
+
+func TestReader_ForEachTileAtZoom(t *testing.T) {
+	path, want := writeStreamTestArchive(t)
+	r, err := OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for z := 0; z <= 3; z++ {
+		var lastID uint64
+		n := 0
+		err := r.ForEachTileAtZoom(z, func(x, y int) error {
+			id := ZXYToTileID(z, x, y)
+			if n > 0 && id <= lastID {
+				t.Errorf("z%d: tile %d/%d out of tileID order", z, x, y)
+			}
+			if _, ok := want[[3]int{z, x, y}]; !ok || !r.HasTile(z, x, y) {
+				t.Errorf("z%d: unexpected tile %d/%d", z, x, y)
+			}
+			lastID = id
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1<<(2*z) || r.TileCountAtZoom(z) != n || len(r.TilesAtZoom(z)) != n {
+			t.Errorf("z%d: walked %d tiles, TileCountAtZoom %d, want %d", z, n, r.TileCountAtZoom(z), 1<<(2*z))
+		}
+	}
+	if r.HasTile(4, 0, 0) || r.TileCountAtZoom(4) != 0 {
+		t.Error("HasTile/TileCountAtZoom report tiles beyond the max zoom")
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	if err := r.ForEachTileAtZoom(3, func(x, y int) error { n++; return stop }); err != stop || n != 1 {
+		t.Errorf("ForEachTileAtZoom = %v after %d tiles, want stop after 1", err, n)
+	}
+}
+
+func TestReader_Stream(t *testing.T) {
+	path, want := writeStreamTestArchive(t)
+	r, err := OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	defer func(n int) { streamChunk = n }(streamChunk)
+	for _, chunk := range []int{4 << 20, 64} { // one read; many refills
+		streamChunk = chunk
+		got := make(map[[3]int][]byte)
+		var lastID uint64
+		err := r.Stream(func(z, x, y int, data []byte) error {
+			id := ZXYToTileID(z, x, y)
+			if len(got) > 0 && id <= lastID {
+				t.Errorf("chunk %d: tile %d/%d/%d out of tileID order", chunk, z, x, y)
+			}
+			lastID = id
+			got[[3]int{z, x, y}] = bytes.Clone(data)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("chunk %d: Stream: %v", chunk, err)
+		}
+		if len(got) != len(want) {
+			t.Errorf("chunk %d: streamed %d tiles, want %d", chunk, len(got), len(want))
+		}
+		for k, data := range want {
+			if !bytes.Equal(got[k], data) {
+				t.Errorf("chunk %d: tile %v = %q, want %q", chunk, k, got[k], data)
+			}
+		}
+	}
+}
+
+func TestReader_StreamZooms(t *testing.T) {
+	path, _ := writeStreamTestArchive(t)
+	r, err := OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	n := 0
+	err = r.StreamZooms(2, 2, func(z, x, y int, data []byte) error {
+		if z != 2 {
+			t.Errorf("StreamZooms(2, 2) visited zoom %d", z)
+		}
+		n++
+		return nil
+	})
+	if err != nil || n != 16 {
+		t.Errorf("StreamZooms(2, 2) = %d tiles, %v; want 16, nil", n, err)
+	}
+
+	stop := errors.New("stop")
+	n = 0
+	err = r.Stream(func(z, x, y int, data []byte) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("Stream with failing callback = %v after %d tiles, want stop after 1", err, n)
+	}
+}
+
+func TestOpenReaderHints(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name, data, fix string
+	}{
+		{"tiles.mbtiles", "SQLite format 3\x00" + string(make([]byte, 200)), "pmtransform"},
+		{"old.pmtiles", "PM\x02\x00" + string(make([]byte, 200)), "_v3.pmtiles"},
+		{"short.pmtiles", "SQLite format 3\x00", "pmtiles convert"},
+	} {
+		path := filepath.Join(dir, tc.name)
+		if err := os.WriteFile(path, []byte(tc.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := OpenReader(path)
+		var h *hint.Error
+		if !errors.As(err, &h) {
+			t.Errorf("%s: error %v is not a *hint.Error", tc.name, err)
+			continue
+		}
+		if h.File != path || !strings.Contains(h.Fix, tc.fix) {
+			t.Errorf("%s: error = %+v, want a fix containing %q", tc.name, h, tc.fix)
+		}
+	}
+
+	// Other garbage keeps a plain error naming the file.
+	path := filepath.Join(dir, "garbage.pmtiles")
+	if err := os.WriteFile(path, make([]byte, 200), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenReader(path); err == nil || !strings.HasPrefix(err.Error(), path) {
+		t.Errorf("OpenReader(garbage) error = %v, want one starting with the path", err)
+	}
+}
+
+func TestReader_TileSize(t *testing.T) {
+	tests := []struct {
+		name string
+		opts WriterOptions
+		want int
+	}{
+		{"raster", WriterOptions{TileFormat: TileTypePNG, TileSize: 512}, 512},
+		{"vector", WriterOptions{TileFormat: TileTypeMVT, TileSize: 256}, 0},
+		{"not recorded", WriterOptions{TileFormat: TileTypePNG}, 0},
+		{"not a number", WriterOptions{TileFormat: TileTypePNG, Extra: map[string]interface{}{"tile_size": "512"}}, 0},
+		{"fractional", WriterOptions{TileFormat: TileTypePNG, Extra: map[string]interface{}{"tile_size": 256.5}}, 0},
+		{"over Extra", WriterOptions{TileFormat: TileTypePNG, TileSize: 256, Extra: map[string]interface{}{"tile_size": 512}}, 256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "size.pmtiles")
+			tt.opts.TempDir = filepath.Dir(path)
+			w, err := NewWriter(path, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.WriteTile(0, 0, 0, []byte("tile"))
+			if err := w.Finalize(); err != nil {
+				t.Fatal(err)
+			}
+			r, err := OpenReader(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if got := r.TileSize(); got != tt.want {
+				t.Errorf("TileSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package pmtiles
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
@@ -10,13 +11,35 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"sync"
+	"time"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/checkpoint"
+	"github.com/pspoerri/geotiff2pmtiles/internal/prealloc"
 )
 
 // dedupEntry records the location of a previously written tile in the temp file.
 type dedupEntry struct {
 	offset uint64
 	length uint32
+	low    bool // in the zoom's segment file instead (index entries only)
+}
+
+// zoomSegment holds the tiles of one lower zoom that a streaming writer
+// receives after max-zoom tiles, until placeZoom copies them into the
+// archive in front of the zooms above.
+type zoomSegment struct {
+	file    *os.File
+	size    uint64
+	entries []Entry               // offsets in file
+	dedup   map[uint64]dedupEntry // FNV-64a hash → first occurrence in file
+}
+
+// copySpan maps a contiguous range of a source archive's tile data, copied
+// by CopyTile, to its place in the temp file.
+type copySpan struct {
+	src, tmp, length uint64
 }
 
 // Writer writes tiles to a PMTiles v3 archive using a two-pass approach.
@@ -26,6 +49,10 @@ type dedupEntry struct {
 // Identical tile data is automatically deduplicated: when multiple tiles produce
 // the same encoded bytes (e.g. uniform single-color tiles), the data is written
 // to disk only once and all entries share the same offset.
+//
+// Writing the same z/x/y more than once is allowed: Finalize keeps one entry
+// per tile (the last write, or the first with WriterOptions.FirstWriteWins)
+// and counts the rest in DuplicateTiles.
 type Writer struct {
 	outputPath string
 	opts       WriterOptions
@@ -34,16 +61,38 @@ type Writer struct {
 	tmpFile   *os.File
 	tmpDir    string // directory for temp files
 	tmpOffset uint64
+	base      int64               // file position of tile data offset 0: StableTileDataOffset with Streaming, else 0
+	tmpAlloc  *prealloc.Allocator // reserves temp file space in extents
 	entries   []Entry
 	dedup     map[uint64]dedupEntry // FNV-64a hash → first occurrence (for dedup)
+	copied    []copySpan            // source ranges copied by CopyTile, ascending
 	mu        sync.Mutex
 	finalized bool
 
-	dedupHits int64 // number of tiles that reused existing data
+	dedupHits  int64 // number of tiles that reused existing data
+	duplicates int64 // entries dropped by Finalize because their tile ID was written again
+	contents   int64 // unique tile contents in the clustered output
+
+	// Read-while-writing support (only when opts.Readable).
+	index    map[uint64]dedupEntry // tileID → file position in the temp file
+	complete map[int]bool          // zoom levels marked complete via CompleteZoom (also with segments)
+
+	// Streaming layout with a gap for the lower zooms (see newStreamingWriter).
+	segments  map[int]*zoomSegment // lower zooms written after max-zoom tiles; nil without a gap
+	placed    []Entry              // entries of placed segments, at file positions
+	blockSize int64                // block size the gap is collapsed in
+	gapStart  int64                // file position where the gap begins
+	low       int64                // file position of the lowest placed data (base when none)
+	nextPlace int                  // zoom placeZoom handles next as zooms complete
+	sawMax    bool                 // a max-zoom tile has been written
+	placeErr  error                // first error of placeZoom in CompleteZoom
 }
 
 // NewWriter creates a new PMTiles writer.
 func NewWriter(outputPath string, opts WriterOptions) (*Writer, error) {
+	if opts.Streaming {
+		return newStreamingWriter(outputPath, opts)
+	}
 	tmpDir := opts.TempDir
 	if tmpDir == "" {
 		tmpDir = filepath.Dir(outputPath)
@@ -53,16 +102,93 @@ func NewWriter(outputPath string, opts WriterOptions) (*Writer, error) {
 	if err != nil {
 		return nil, fmt.Errorf("creating temp file: %w", err)
 	}
+	return newWriter(outputPath, opts, tmpDir, tmpFile), nil
+}
 
-	return &Writer{
+// newWriter returns a writer appending tile data to tmpFile.
+func newWriter(outputPath string, opts WriterOptions, tmpDir string, tmpFile *os.File) *Writer {
+	w := &Writer{
 		outputPath: outputPath,
 		opts:       opts,
 		header:     NewHeader(opts),
 		tmpFile:    tmpFile,
 		tmpDir:     tmpDir,
+		tmpAlloc:   prealloc.New(tmpFile, prealloc.ExtentFor(estimatedTileBytes(opts))),
 		entries:    make([]Entry, 0, 65536),
 		dedup:      make(map[uint64]dedupEntry),
-	}, nil
+	}
+	if opts.Readable {
+		w.index = make(map[uint64]dedupEntry)
+		w.complete = make(map[int]bool)
+	}
+	return w
+}
+
+// newStreamingWriter returns a writer appending tile data to the output's
+// .partial file, behind the space kept for the header and root directory.
+//
+// The tile pipeline writes the max zoom first, but its tile IDs come last.
+// Where the file system can collapse ranges (prealloc.Collapse), the writer
+// therefore appends the data behind a sparse gap: lower-zoom tiles that
+// arrive after max-zoom ones go to a segment file per zoom, and each
+// completed zoom is copied in tile-ID order into the gap, right in front of
+// the zooms above it (placeZoom). Finalize collapses the rest of the gap.
+// With the max zoom written in tile-ID order the archive is clustered,
+// and the max-zoom data, most of the archive, is never moved.
+func newStreamingWriter(outputPath string, opts WriterOptions) (*Writer, error) {
+	f, err := os.Create(outputPath + PartialSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("creating output file: %w", err)
+	}
+	w := newWriter(outputPath, opts, filepath.Dir(outputPath), f)
+	w.base = StableTileDataOffset
+	if blk := prealloc.CollapseBlock(f); blk > 0 {
+		gapStart := alignUp(StableTileDataOffset, blk)
+		base := alignUp(gapStart+streamingGap(opts), blk)
+		// The file system must allow a file of that size; the gap itself
+		// takes no space.
+		if f.Truncate(base) == nil {
+			w.blockSize, w.gapStart, w.base = blk, gapStart, base
+			w.segments = make(map[int]*zoomSegment)
+			if w.complete == nil {
+				w.complete = make(map[int]bool)
+			}
+		}
+	}
+	w.tmpAlloc.Skip(w.base)
+	w.low = w.base
+	w.nextPlace = opts.MaxZoom - 1
+	if _, err := f.Seek(w.base, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("seeking output file: %w", err)
+	}
+	return w, nil
+}
+
+// Bounds of the gap a streaming writer leaves for the lower zooms.
+const (
+	minStreamingGap = 1 << 30
+	maxStreamingGap = 4 << 40
+)
+
+// streamingGap returns the size of the gap a streaming writer leaves for
+// the lower zooms: the estimate of all tile data, about three times what
+// the lower zooms take, within [minStreamingGap, maxStreamingGap].
+func streamingGap(opts WriterOptions) int64 {
+	return min(max(estimatedTileBytes(opts), minStreamingGap), maxStreamingGap)
+}
+
+// alignUp rounds n up to a multiple of blk.
+func alignUp(n, blk int64) int64 {
+	return (n + blk - 1) / blk * blk
+}
+
+// estimatedTileBytes is an upper estimate of the unique tile data the
+// archive will hold: 1 byte per pixel, the high end of PNG, JPEG, and WebP
+// for imagery. It only sizes the temp file's preallocation extents.
+func estimatedTileBytes(opts WriterOptions) int64 {
+	return opts.EstimatedTiles * int64(opts.TileSize) * int64(opts.TileSize)
 }
 
 // tileHash computes a FNV-64a hash of tile data for deduplication.
@@ -78,8 +204,18 @@ func tileHash(data []byte) uint64 {
 // already been written, the new entry reuses the existing offset on disk.
 // This dramatically reduces temp file size for datasets with many uniform tiles.
 func (w *Writer) WriteTile(z, x, y int, data []byte) error {
+	_, err := w.WriteTileLocated(z, x, y, data)
+	return err
+}
+
+// WriteTileLocated is WriteTile, also returning where data is in the
+// tile data written so far (see DataReader): at a new offset, or at that
+// of an identical tile written before. Returns -1 for empty data, which
+// is not written, and for tiles a streaming writer keeps in a zoom
+// segment, which DataReader does not cover.
+func (w *Writer) WriteTileLocated(z, x, y int, data []byte) (int64, error) {
 	if len(data) == 0 {
-		return nil
+		return -1, nil
 	}
 
 	tileID := ZXYToTileID(z, x, y)
@@ -88,6 +224,14 @@ func (w *Writer) WriteTile(z, x, y int, data []byte) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	seg, err := w.segment(z)
+	if err != nil {
+		return -1, err
+	}
+	if seg != nil {
+		return -1, w.writeSegment(seg, tileID, hash, data)
+	}
+
 	// Check for a dedup hit: reuse the existing data on disk.
 	if de, ok := w.dedup[hash]; ok && de.length == uint32(len(data)) {
 		w.entries = append(w.entries, Entry{
@@ -97,18 +241,21 @@ func (w *Writer) WriteTile(z, x, y int, data []byte) error {
 			RunLength: 1,
 		})
 		w.dedupHits++
-		return nil
+		w.indexTile(tileID, de)
+		return int64(de.offset), nil
 	}
 
 	// New unique tile: write to temp file.
 	offset := w.tmpOffset
+	w.tmpAlloc.Grow(w.base + int64(offset) + int64(len(data)))
 	n, err := w.tmpFile.Write(data)
 	if err != nil {
-		return fmt.Errorf("writing tile data: %w", err)
+		return -1, fmt.Errorf("writing tile data: %w", err)
 	}
 	w.tmpOffset += uint64(n)
 
 	w.dedup[hash] = dedupEntry{offset: offset, length: uint32(n)}
+	w.indexTile(tileID, dedupEntry{offset: offset, length: uint32(n)})
 
 	w.entries = append(w.entries, Entry{
 		TileID:    tileID,
@@ -117,9 +264,216 @@ func (w *Writer) WriteTile(z, x, y int, data []byte) error {
 		RunLength: 1,
 	})
 
+	return int64(offset), nil
+}
+
+// segment returns the segment a streaming writer keeps zoom z's tiles in
+// until placeZoom, creating it on first use, or nil if the tile is
+// appended to the archive: max-zoom tiles, and lower-zoom tiles before the
+// first max-zoom one (a copy in tile-ID order). Caller must hold w.mu.
+func (w *Writer) segment(z int) (*zoomSegment, error) {
+	if w.segments == nil {
+		return nil, nil
+	}
+	if z >= w.opts.MaxZoom {
+		w.sawMax = true
+		return nil, nil
+	}
+	if !w.sawMax {
+		return nil, nil
+	}
+	seg := w.segments[z]
+	if seg == nil {
+		f, err := os.CreateTemp(w.tmpDir, "pmtiles-zoom-*.tmp")
+		if err != nil {
+			return nil, fmt.Errorf("creating zoom %d segment: %w", z, err)
+		}
+		seg = &zoomSegment{file: f, dedup: make(map[uint64]dedupEntry)}
+		w.segments[z] = seg
+	}
+	return seg, nil
+}
+
+// writeSegment appends a tile to seg, deduplicated within the zoom: each
+// zoom gets its own copy of shared data, so that placed zooms only point
+// back into themselves. Caller must hold w.mu.
+func (w *Writer) writeSegment(seg *zoomSegment, tileID, hash uint64, data []byte) error {
+	de, ok := seg.dedup[hash]
+	if ok && de.length == uint32(len(data)) {
+		w.dedupHits++
+	} else {
+		n, err := seg.file.Write(data)
+		if err != nil {
+			return fmt.Errorf("writing tile data: %w", err)
+		}
+		de = dedupEntry{offset: seg.size, length: uint32(n)}
+		seg.size += uint64(n)
+		seg.dedup[hash] = de
+	}
+	seg.entries = append(seg.entries, Entry{TileID: tileID, Offset: de.offset, Length: de.length, RunLength: 1})
+	w.indexTile(tileID, dedupEntry{offset: de.offset, length: de.length, low: true})
 	return nil
 }
 
+// DataReader returns a reader of the tile data written so far, at the
+// offsets WriteTileLocated returns, for the tile stores of a run to read
+// tiles back instead of keeping a copy of their own. Reads may run
+// concurrently with writes; the reader is valid until Finalize or Abort.
+func (w *Writer) DataReader() io.ReaderAt {
+	return io.NewSectionReader(w.tmpFile, w.base, 1<<62)
+}
+
+// CopyTile writes a tile streamed from another archive, where offset is
+// the position of its data (Reader.StreamZoomRefs). A tile whose source
+// data was already copied shares it without hashing, so a passthrough copy
+// keeps the source's deduplication while holding only one span per
+// contiguous copied range instead of a hash per unique tile. Tiles must
+// arrive in source file order, as they do from a clustered archive; data
+// behind the copied ranges (a zoom that was skipped, or an unclustered
+// source) is written again. Safe for concurrent use, but mixing CopyTile
+// with WriteTile does not deduplicate across the two.
+func (w *Writer) CopyTile(z, x, y int, offset uint64, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	tileID := ZXYToTileID(z, x, y)
+	length := uint64(len(data))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if tmp, ok := w.copiedOffset(offset, length); ok {
+		de := dedupEntry{offset: tmp, length: uint32(length)}
+		w.entries = append(w.entries, Entry{TileID: tileID, Offset: tmp, Length: uint32(length), RunLength: 1})
+		w.dedupHits++
+		w.indexTile(tileID, de)
+		return nil
+	}
+
+	tmp := w.tmpOffset
+	w.tmpAlloc.Grow(w.base + int64(tmp) + int64(length))
+	n, err := w.tmpFile.Write(data)
+	if err != nil {
+		return fmt.Errorf("writing tile data: %w", err)
+	}
+	w.tmpOffset += uint64(n)
+
+	// Only data ahead of everything copied so far keeps the spans sorted.
+	if k := len(w.copied); k == 0 || offset >= w.copied[k-1].src+w.copied[k-1].length {
+		if k > 0 && w.copied[k-1].src+w.copied[k-1].length == offset && w.copied[k-1].tmp+w.copied[k-1].length == tmp {
+			w.copied[k-1].length += length
+		} else {
+			w.copied = append(w.copied, copySpan{src: offset, tmp: tmp, length: length})
+		}
+	}
+	w.indexTile(tileID, dedupEntry{offset: tmp, length: uint32(n)})
+	w.entries = append(w.entries, Entry{TileID: tileID, Offset: tmp, Length: uint32(length), RunLength: 1})
+	return nil
+}
+
+// copiedOffset returns the temp file offset of source data
+// [offset, offset+length) if CopyTile has already copied all of it.
+// Caller must hold w.mu.
+func (w *Writer) copiedOffset(offset, length uint64) (uint64, bool) {
+	i := sort.Search(len(w.copied), func(i int) bool {
+		return w.copied[i].src+w.copied[i].length > offset
+	})
+	if i == len(w.copied) {
+		return 0, false
+	}
+	sp := w.copied[i]
+	if offset < sp.src || offset+length > sp.src+sp.length {
+		return 0, false
+	}
+	return sp.tmp + offset - sp.src, true
+}
+
+// indexTile records a tile for ReadTile, honouring FirstWriteWins so that
+// previews agree with the finalized archive. de is at a tile data offset,
+// or in the zoom's segment. Caller must hold w.mu.
+func (w *Writer) indexTile(tileID uint64, de dedupEntry) {
+	if w.index == nil {
+		return
+	}
+	if _, ok := w.index[tileID]; ok && w.opts.FirstWriteWins {
+		return
+	}
+	if !de.low {
+		de.offset += uint64(w.base)
+	}
+	w.index[tileID] = de
+}
+
+// DuplicateTiles returns the number of writes that were discarded because
+// the same z/x/y was written more than once. Valid after Finalize.
+func (w *Writer) DuplicateTiles() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.duplicates
+}
+
+// CompleteZoom marks zoom level z as fully written, making its tiles
+// visible to ReadTile. Called by the tile pipeline after each zoom level.
+// A streaming writer places the segments of completed zooms, highest
+// first (see newStreamingWriter). No-op unless the writer was created with
+// WriterOptions.Readable or is streaming.
+func (w *Writer) CompleteZoom(z int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.complete == nil {
+		return
+	}
+	w.complete[z] = true
+	for w.segments != nil && w.placeErr == nil && w.nextPlace >= w.opts.MinZoom && w.complete[w.nextPlace] {
+		w.placeErr = w.placeZoom(w.nextPlace)
+		w.nextPlace--
+	}
+}
+
+// CompletedZooms returns the zoom levels marked complete, in ascending order.
+func (w *Writer) CompletedZooms() []int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	zooms := make([]int, 0, len(w.complete))
+	for z := range w.complete {
+		zooms = append(zooms, z)
+	}
+	sort.Ints(zooms)
+	return zooms
+}
+
+// ReadTile returns a tile written so far, for previewing an archive while
+// it is being generated. Only tiles at zoom levels marked complete are
+// returned, so a viewer always sees whole levels. Returns nil, nil for
+// missing tiles or incomplete zooms. Requires WriterOptions.Readable.
+func (w *Writer) ReadTile(z, x, y int) ([]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.finalized {
+		return nil, fmt.Errorf("writer already finalized")
+	}
+	if w.index == nil {
+		return nil, fmt.Errorf("writer not opened with Readable")
+	}
+	if !w.complete[z] {
+		return nil, nil
+	}
+	de, ok := w.index[ZXYToTileID(z, x, y)]
+	if !ok {
+		return nil, nil
+	}
+	f := w.tmpFile
+	if de.low {
+		f = w.segments[z].file
+	}
+	data := make([]byte, de.length)
+	if _, err := f.ReadAt(data, int64(de.offset)); err != nil {
+		return nil, fmt.Errorf("reading tile z%d/%d/%d: %w", z, x, y, err)
+	}
+	return data, nil
+}
+
 // Finalize builds the directory, metadata, and writes the final PMTiles file.
 func (w *Writer) Finalize() error {
 	w.mu.Lock()
@@ -129,16 +483,43 @@ func (w *Writer) Finalize() error {
 		return fmt.Errorf("already finalized")
 	}
 	w.finalized = true
+	w.index = nil // preview reads end at finalization
+
+	if w.opts.Streaming {
+		if err := w.placeSegments(); err != nil {
+			return fmt.Errorf("placing lower zooms: %w", err)
+		}
+		if err := w.closeGap(); err != nil {
+			return err
+		}
+	}
 
-	// Sort entries by tile ID for the directory.
-	sort.Slice(w.entries, func(i, j int) bool {
+	// Sort entries by tile ID for the directory. The sort is stable so
+	// repeated writes of a tile stay in write order for removeDuplicates.
+	sort.SliceStable(w.entries, func(i, j int) bool {
 		return w.entries[i].TileID < w.entries[j].TileID
 	})
+	w.entries = w.removeDuplicates(w.entries)
+	PlaceCenter(&w.header, w.entries, w.opts)
 
 	// Rewrite tile data in tile-ID order so the archive is properly clustered.
 	// This ensures tile data on disk follows the same Hilbert order as the directory,
-	// which enables readers to optimize range requests.
-	if err := w.clusterTileData(); err != nil {
+	// which enables readers to optimize range requests. Data copied from a
+	// clustered archive is already in that order and stays where it is.
+	// A streaming writer's data is in the archive already; it is clustered
+	// when the max zoom arrived in tile-ID order (see newStreamingWriter).
+	w.copied = nil
+	if w.opts.Streaming {
+		n, ok := w.clusteredContents()
+		if !ok {
+			n = w.countContents()
+		}
+		w.contents = n
+		w.header.Clustered = ok
+	} else if n, ok := w.clusteredContents(); ok {
+		w.contents = n
+		w.tmpAlloc = nil
+	} else if err := w.clusterTileData(); err != nil {
 		return fmt.Errorf("clustering tile data: %w", err)
 	}
 
@@ -157,6 +538,7 @@ func (w *Writer) Finalize() error {
 
 	// Compute offsets.
 	// Layout: [Header (127)] [Root Dir] [Metadata] [Leaf Dirs] [Tile Data]
+	// StableLayout: [Header (127)] [Root Dir] [padding] [Tile Data] [Metadata] [Leaf Dirs]
 	rootDirOffset := uint64(HeaderSize)
 	rootDirLength := uint64(len(rootDir))
 	metadataOffset := rootDirOffset + rootDirLength
@@ -164,6 +546,17 @@ func (w *Writer) Finalize() error {
 	leafDirOffset := metadataOffset + metadataLength
 	leafDirLength := uint64(len(leafDirs))
 	tileDataOffset := leafDirOffset + leafDirLength
+	if w.opts.StableLayout || w.opts.Streaming {
+		if rootDirOffset+rootDirLength > StableTileDataOffset {
+			return fmt.Errorf("root directory (%d bytes) does not fit before the stable tile data offset", rootDirLength)
+		}
+		tileDataOffset = StableTileDataOffset
+		if w.opts.Streaming {
+			tileDataOffset = uint64(w.base)
+		}
+		metadataOffset = tileDataOffset + w.tmpOffset
+		leafDirOffset = metadataOffset + metadataLength
+	}
 
 	// Update header.
 	w.header.RootDirOffset = rootDirOffset
@@ -176,52 +569,375 @@ func (w *Writer) Finalize() error {
 	w.header.TileDataLength = w.tmpOffset
 	w.header.NumAddressedTiles = uint64(len(w.entries))
 	w.header.NumTileEntries = uint64(numTileEntries)
-	w.header.NumTileContents = uint64(len(w.entries) - int(w.dedupHits))
+	w.header.NumTileContents = uint64(w.contents)
 
-	// Write the final file.
-	outFile, err := os.Create(w.outputPath)
+	if w.opts.Streaming {
+		return w.finishStreaming(rootDir, metadataBytes, leafDirs)
+	}
+
+	// Write the final file next to the output and rename it into place, so
+	// a crash never leaves a truncated archive at the output path.
+	partialPath := w.outputPath + PartialSuffix
+	outFile, err := os.Create(partialPath)
 	if err != nil {
 		return fmt.Errorf("creating output file: %w", err)
 	}
-	defer outFile.Close()
+	err = w.writeArchive(outFile, rootDir, metadataBytes, leafDirs)
+	if cerr := outFile.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("closing output file: %w", cerr)
+	}
+	if err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+	if err := os.Rename(partialPath, w.outputPath); err != nil {
+		os.Remove(partialPath)
+		return fmt.Errorf("renaming output file: %w", err)
+	}
+	if w.opts.SyncDir {
+		if err := checkpoint.SyncDir(filepath.Dir(w.outputPath)); err != nil {
+			return fmt.Errorf("syncing output directory: %w", err)
+		}
+	}
+
+	// Cleanup temp file.
+	tmpPath := w.tmpFile.Name()
+	w.tmpFile.Close()
+	os.Remove(tmpPath)
+
+	return nil
+}
+
+// finishStreaming completes the archive a Streaming writer has appended
+// the tile data to: the metadata and leaf directories go behind the data,
+// the header and root directory in front, and the file is renamed into
+// place.
+func (w *Writer) finishStreaming(rootDir, metadataBytes, leafDirs []byte) error {
+	f := w.tmpFile
+	w.tmpFile = nil
+	err := func() error {
+		tail := append(metadataBytes, leafDirs...)
+		if _, err := f.WriteAt(tail, int64(w.header.MetadataOffset)); err != nil {
+			return fmt.Errorf("writing metadata and leaf directories: %w", err)
+		}
+		if _, err := f.WriteAt(append(w.header.Serialize(), rootDir...), 0); err != nil {
+			return fmt.Errorf("writing header: %w", err)
+		}
+		// Truncating frees preallocated blocks past the end.
+		if err := f.Truncate(int64(w.header.MetadataOffset) + int64(len(tail))); err != nil {
+			return fmt.Errorf("truncating output file: %w", err)
+		}
+		if w.opts.Sync {
+			if err := f.Sync(); err != nil {
+				return fmt.Errorf("syncing output file: %w", err)
+			}
+		}
+		return nil
+	}()
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("closing output file: %w", cerr)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), w.outputPath); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("renaming output file: %w", err)
+	}
+	if w.opts.SyncDir {
+		if err := checkpoint.SyncDir(filepath.Dir(w.outputPath)); err != nil {
+			return fmt.Errorf("syncing output directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// placeSegments places the segments CompleteZoom has not placed, highest
+// zoom first, and rebases all entries on the start of the tile data.
+// Caller must hold w.mu.
+func (w *Writer) placeSegments() error {
+	if w.placeErr != nil {
+		return w.placeErr
+	}
+	zooms := make([]int, 0, len(w.segments))
+	for z := range w.segments {
+		zooms = append(zooms, z)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(zooms)))
+	for _, z := range zooms {
+		if err := w.placeZoom(z); err != nil {
+			return err
+		}
+	}
+
+	shift := uint64(w.base - w.low)
+	for i := range w.entries {
+		w.entries[i].Offset += shift
+	}
+	for _, e := range w.placed {
+		e.Offset -= uint64(w.low)
+		w.entries = append(w.entries, e)
+	}
+	w.placed = nil
+	w.tmpOffset += shift
+	w.base = w.low
+	return nil
+}
+
+// placeZoom copies the tiles of zoom z's segment into the archive in
+// tile-ID order, each content once: into the gap, right in front of the
+// data placed so far, or behind all data once the gap is used up (the
+// archive is then not clustered). The segment is removed. Caller must hold
+// w.mu.
+func (w *Writer) placeZoom(z int) error {
+	seg := w.segments[z]
+	if seg == nil {
+		return nil
+	}
+	delete(w.segments, z)
+	defer func() {
+		seg.file.Close()
+		os.Remove(seg.file.Name())
+	}()
+
+	sort.SliceStable(seg.entries, func(i, j int) bool {
+		return seg.entries[i].TileID < seg.entries[j].TileID
+	})
+	entries := w.removeDuplicates(seg.entries)
+
+	// Lay out the contents in the order of their first tile.
+	pos := make(map[uint64]uint64, len(entries)) // segment offset → offset in the zoom's data
+	var size uint64
+	for _, e := range entries {
+		if _, ok := pos[e.Offset]; !ok {
+			pos[e.Offset] = size
+			size += uint64(e.Length)
+		}
+	}
+	at := w.low - int64(size)
+	front := at >= w.gapStart
+	if !front {
+		at = w.base + int64(w.tmpOffset)
+		w.tmpAlloc.Grow(at + int64(size))
+	}
+
+	bw := bufio.NewWriterSize(io.NewOffsetWriter(w.tmpFile, at), 1<<20)
+	var buf []byte
+	var next uint64
+	for i := range entries {
+		e := &entries[i]
+		p := pos[e.Offset]
+		if p == next {
+			if int(e.Length) > len(buf) {
+				buf = make([]byte, e.Length)
+			}
+			if _, err := seg.file.ReadAt(buf[:e.Length], int64(e.Offset)); err != nil {
+				return fmt.Errorf("reading zoom %d segment: %w", z, err)
+			}
+			if _, err := bw.Write(buf[:e.Length]); err != nil {
+				return fmt.Errorf("writing zoom %d: %w", z, err)
+			}
+			next += uint64(e.Length)
+		}
+		e.Offset = uint64(at) + p
+		if de, ok := w.index[e.TileID]; ok && de.low {
+			w.index[e.TileID] = dedupEntry{offset: e.Offset, length: e.Length}
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("writing zoom %d: %w", z, err)
+	}
+
+	if front {
+		w.low = at
+		w.placed = append(w.placed, entries...)
+		return nil
+	}
+	for i := range entries {
+		entries[i].Offset -= uint64(w.base)
+	}
+	w.entries = append(w.entries, entries...)
+	w.tmpOffset += size
+	if _, err := w.tmpFile.Seek(w.base+int64(w.tmpOffset), io.SeekStart); err != nil {
+		return fmt.Errorf("seeking output file: %w", err)
+	}
+	return nil
+}
+
+// closeGap moves a streaming writer's tile data down behind the space kept
+// for the header and root directory. Collapsing the unused whole blocks
+// of the gap moves no data; less than a block of it stays as padding,
+// unless StableLayout wants the data at exactly StableTileDataOffset. If
+// collapsing fails, the data is copied down instead.
+func (w *Writer) closeGap() error {
+	if w.blockSize > 0 {
+		n := (w.base - w.gapStart) / w.blockSize * w.blockSize
+		if n > 0 && prealloc.Collapse(w.tmpFile, w.gapStart, n) == nil {
+			w.base -= n
+		}
+	}
+	if w.base == StableTileDataOffset || !w.opts.StableLayout && w.base-StableTileDataOffset < w.blockSize {
+		return nil
+	}
+	buf := make([]byte, 1<<20)
+	for done := int64(0); done < int64(w.tmpOffset); {
+		n := min(int64(len(buf)), int64(w.tmpOffset)-done)
+		if _, err := w.tmpFile.ReadAt(buf[:n], w.base+done); err != nil {
+			return fmt.Errorf("reading tile data: %w", err)
+		}
+		// Front to back: the destination is below the source.
+		if _, err := w.tmpFile.WriteAt(buf[:n], StableTileDataOffset+done); err != nil {
+			return fmt.Errorf("moving tile data: %w", err)
+		}
+		done += n
+	}
+	w.base = StableTileDataOffset
+	return nil
+}
+
+// PlaceCenter sets the center of h, a header of an archive written with
+// opts, to a point in the coverage of entries (coverageCenter), clamped to
+// the bounds and zoom range. Without tiles it stays where NewHeader put
+// it, at the bounds midpoint. Entries must be sorted by tile ID.
+func PlaceCenter(h *Header, entries []Entry, opts WriterOptions) {
+	lon, lat, zoom, ok := coverageCenter(entries, opts.MinZoom, opts.ZoomOffset)
+	if !ok {
+		return
+	}
+	b := opts.Bounds
+	h.CenterLon = float32(min(max(lon, b.MinLon), b.MaxLon))
+	h.CenterLat = float32(min(max(lat, b.MinLat), b.MaxLat))
+	h.CenterZoom = uint8(min(max(zoom, opts.MinZoom), opts.MaxZoom))
+}
+
+// StableTileDataOffset is where WriterOptions.StableLayout puts the tile
+// data: the end of the 16 KiB initial fetch that holds the header and root
+// directory, so it does not move when the directories change size.
+const StableTileDataOffset = 16384
+
+// PartialSuffix is appended to the output path while Finalize writes the
+// archive (with WriterOptions.Streaming, from NewWriter on). A file with
+// this suffix is left behind only if the process dies before Finalize
+// returns.
+const PartialSuffix = ".partial"
 
+// writeArchive writes header, directories, metadata, and the clustered tile
+// data to f in the order given by the header offsets, and fsyncs it when
+// WriterOptions.Sync is set.
+func (w *Writer) writeArchive(f *os.File, rootDir, metadataBytes, leafDirs []byte) error {
 	// Write header.
-	if _, err := outFile.Write(w.header.Serialize()); err != nil {
+	if _, err := f.Write(w.header.Serialize()); err != nil {
 		return fmt.Errorf("writing header: %w", err)
 	}
 
 	// Write root directory.
-	if _, err := outFile.Write(rootDir); err != nil {
+	if _, err := f.Write(rootDir); err != nil {
 		return fmt.Errorf("writing root directory: %w", err)
 	}
 
+	if w.opts.StableLayout {
+		pad := w.header.TileDataOffset - (w.header.RootDirOffset + w.header.RootDirLength)
+		if _, err := f.Write(make([]byte, pad)); err != nil {
+			return fmt.Errorf("writing padding: %w", err)
+		}
+		if err := w.copyTileData(f); err != nil {
+			return err
+		}
+	}
+
 	// Write metadata.
-	if _, err := outFile.Write(metadataBytes); err != nil {
+	if _, err := f.Write(metadataBytes); err != nil {
 		return fmt.Errorf("writing metadata: %w", err)
 	}
 
 	// Write leaf directories.
 	if len(leafDirs) > 0 {
-		if _, err := outFile.Write(leafDirs); err != nil {
+		if _, err := f.Write(leafDirs); err != nil {
 			return fmt.Errorf("writing leaf directories: %w", err)
 		}
 	}
 
-	// Copy tile data from temp file (now in clustered order).
+	if !w.opts.StableLayout {
+		if err := w.copyTileData(f); err != nil {
+			return err
+		}
+	}
+
+	if w.opts.Sync {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("syncing output file: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyTileData copies the tile data from the temp file (in clustered
+// order after clusterTileData) to f.
+func (w *Writer) copyTileData(f *os.File) error {
 	if _, err := w.tmpFile.Seek(0, io.SeekStart); err != nil {
 		return fmt.Errorf("seeking temp file: %w", err)
 	}
-
-	if _, err := io.Copy(outFile, w.tmpFile); err != nil {
+	if _, err := io.Copy(f, w.tmpFile); err != nil {
 		return fmt.Errorf("copying tile data: %w", err)
 	}
+	return nil
+}
 
-	// Cleanup temp file.
-	tmpPath := w.tmpFile.Name()
-	w.tmpFile.Close()
-	os.Remove(tmpPath)
+// removeDuplicates collapses runs of entries with the same tile ID (entries
+// must be stably sorted by tile ID) down to one, keeping the last write
+// unless FirstWriteWins is set, and returns the shortened entries. A
+// directory with repeated tile IDs violates the PMTiles spec. Data of
+// dropped entries is not copied by clusterTileData or placeZoom, so it
+// does not end up in the archive.
+func (w *Writer) removeDuplicates(entries []Entry) []Entry {
+	if len(entries) < 2 {
+		return entries
+	}
+	out := entries[:1]
+	for _, e := range entries[1:] {
+		last := &out[len(out)-1]
+		if e.TileID != last.TileID {
+			out = append(out, e)
+			continue
+		}
+		w.duplicates++
+		if !w.opts.FirstWriteWins {
+			*last = e
+		}
+	}
+	return out
+}
 
-	return nil
+// clusteredContents reports whether the temp file is already clustered:
+// walking the sorted entries, each one either starts at the end of the data
+// seen so far or points back into it, and no data is left unreferenced. It
+// returns the number of unique contents. Needs no memory beyond the entries.
+func (w *Writer) clusteredContents() (int64, bool) {
+	var next uint64
+	var n int64
+	for _, e := range w.entries {
+		switch end := e.Offset + uint64(e.Length); {
+		case e.Offset == next:
+			next = end
+			n++
+		case end <= next:
+			// Shared with an earlier tile.
+		default:
+			return 0, false
+		}
+	}
+	return n, next == w.tmpOffset
+}
+
+// countContents returns the number of unique tile contents: entries
+// sharing data have the same offset.
+func (w *Writer) countContents() int64 {
+	seen := make(map[uint64]struct{}, len(w.entries))
+	for _, e := range w.entries {
+		seen[e.Offset] = struct{}{}
+	}
+	return int64(len(seen))
 }
 
 // clusterTileData rewrites the temp file so tile data is in the same order
@@ -236,6 +952,8 @@ func (w *Writer) clusterTileData() error {
 	if err != nil {
 		return fmt.Errorf("creating clustered temp file: %w", err)
 	}
+	// The clustered data is at most as large as the current temp file.
+	prealloc.New(newTmp, 0).Grow(int64(w.tmpOffset))
 
 	buf := make([]byte, 256*1024) // 256 KiB read buffer
 	var newOffset uint64
@@ -278,6 +996,7 @@ func (w *Writer) clusterTileData() error {
 		e.Offset = newOffset
 		seen[oldOffset] = remap{newOffset: newOffset, length: e.Length}
 		newOffset += uint64(tileLen)
+		w.contents++
 	}
 
 	// Replace old temp file with the new clustered one.
@@ -287,12 +1006,17 @@ func (w *Writer) clusterTileData() error {
 
 	w.tmpFile = newTmp
 	w.tmpOffset = newOffset
+	w.tmpAlloc = nil
 
 	return nil
 }
 
 // Abort cleans up resources without writing the output file.
 func (w *Writer) Abort() {
+	for _, seg := range w.segments {
+		seg.file.Close()
+		os.Remove(seg.file.Name())
+	}
 	if w.tmpFile != nil {
 		tmpPath := w.tmpFile.Name()
 		w.tmpFile.Close()
@@ -302,26 +1026,36 @@ func (w *Writer) Abort() {
 
 // buildMetadata creates the JSON metadata for the PMTiles archive.
 func (w *Writer) buildMetadata() []byte {
+	data, _ := json.Marshal(Metadata(w.opts, w.header))
+	return data
+}
+
+// Metadata returns the metadata of an archive written with opts whose
+// header is h (for the center): the keys Writer stores as JSON, also used
+// by writers of other containers.
+func Metadata(opts WriterOptions, h Header) map[string]interface{} {
 	tileFormatStr := "unknown"
-	switch w.opts.TileFormat {
+	switch opts.TileFormat {
 	case TileTypeJPEG:
 		tileFormatStr = "jpeg"
 	case TileTypePNG:
 		tileFormatStr = "png"
 	case TileTypeWebP:
 		tileFormatStr = "webp"
+	case TileTypeMVT:
+		tileFormatStr = "pbf"
 	}
 
-	name := w.opts.Name
+	name := opts.Name
 	if name == "" {
 		name = "geotiff2pmtiles"
 	}
-	description := w.opts.Description
+	description := opts.Description
 	if description == "" {
 		description = "Generated from GeoTIFF files"
 	}
 
-	layerType := w.opts.Type
+	layerType := opts.Type
 	if layerType == "" {
 		layerType = "baselayer"
 	}
@@ -331,23 +1065,58 @@ func (w *Writer) buildMetadata() []byte {
 		"description": description,
 		"format":      tileFormatStr,
 		"type":        layerType,
-		"minzoom":     fmt.Sprintf("%d", w.opts.MinZoom),
-		"maxzoom":     fmt.Sprintf("%d", w.opts.MaxZoom),
+		"minzoom":     fmt.Sprintf("%d", opts.MinZoom),
+		"maxzoom":     fmt.Sprintf("%d", opts.MaxZoom),
 		"bounds": fmt.Sprintf("%.6f,%.6f,%.6f,%.6f",
-			w.opts.Bounds.MinLon, w.opts.Bounds.MinLat,
-			w.opts.Bounds.MaxLon, w.opts.Bounds.MaxLat),
+			opts.Bounds.MinLon, opts.Bounds.MinLat,
+			opts.Bounds.MaxLon, opts.Bounds.MaxLat),
 		"center": fmt.Sprintf("%.6f,%.6f,%d",
-			(w.opts.Bounds.MinLon+w.opts.Bounds.MaxLon)/2,
-			(w.opts.Bounds.MinLat+w.opts.Bounds.MaxLat)/2,
-			(w.opts.MinZoom+w.opts.MaxZoom)/2),
+			h.CenterLon, h.CenterLat, h.CenterZoom),
 	}
 
-	if w.opts.Attribution != "" {
-		meta["attribution"] = w.opts.Attribution
+	if opts.Attribution != "" {
+		meta["attribution"] = opts.Attribution
+	}
+	if opts.Version != "" {
+		meta["version"] = opts.Version
+	}
+	if opts.LayerID != "" {
+		meta["id"] = opts.LayerID
+	}
+	if !opts.GeneratedAt.IsZero() {
+		meta["generated_at"] = opts.GeneratedAt.UTC().Format(time.RFC3339)
+	}
+	if !opts.UpdatedAt.IsZero() {
+		meta["updated_at"] = opts.UpdatedAt.UTC().Format(time.RFC3339)
+	}
+	// The header does not record the size of raster tiles; store it so
+	// readers need not decode a tile to learn it (see Reader.TileSize).
+	if opts.TileFormat != TileTypeMVT && opts.TileSize > 0 {
+		meta["tile_size"] = opts.TileSize
+	}
+	if opts.PremultipliedAlpha {
+		meta["premultiplied_alpha"] = true
 	}
 
-	data, _ := json.Marshal(meta)
-	return data
+	for k, v := range opts.Extra {
+		if _, ok := meta[k]; !ok {
+			meta[k] = v
+		}
+	}
+
+	return meta
+}
+
+// GenerationTime returns the time to record as GeneratedAt: now, or
+// SOURCE_DATE_EPOCH (seconds since the epoch) when set, so reproducible
+// builds of the same inputs stay byte-identical.
+func GenerationTime() time.Time {
+	if s := os.Getenv("SOURCE_DATE_EPOCH"); s != "" {
+		if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.Unix(sec, 0).UTC()
+		}
+	}
+	return time.Now().UTC().Truncate(time.Second)
 }
 
 func compressGzip(data []byte) ([]byte, error) {
@@ -1,12 +1,17 @@
 package pmtiles
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+	"github.com/pspoerri/geotiff2pmtiles/internal/prealloc"
 )
 
 func TestWriter_WriteAndFinalize(t *testing.T) {
@@ -200,6 +205,56 @@ func TestWriter_Abort(t *testing.T) {
 	}
 }
 
+// TestWriter_FinalizeAtomic verifies that Finalize replaces an existing
+// archive only once the new one is complete, and leaves no .partial file.
+func TestWriter_FinalizeAtomic(t *testing.T) {
+	tmpDir := t.TempDir()
+	outPath := filepath.Join(tmpDir, "atomic.pmtiles")
+	if err := os.WriteFile(outPath, []byte("previous archive"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory in the way of the .partial file makes Finalize fail
+	// before anything is renamed: the previous archive must survive.
+	if err := os.Mkdir(outPath+PartialSuffix, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWriter(outPath, WriterOptions{TileFormat: TileTypePNG})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	w.WriteTile(0, 0, 0, []byte("data"))
+	if err := w.Finalize(); err == nil {
+		t.Fatal("Finalize should fail when the .partial path is a directory")
+	}
+	w.Abort()
+	if got, _ := os.ReadFile(outPath); string(got) != "previous archive" {
+		t.Fatalf("previous archive was modified: %q", got)
+	}
+	os.Remove(outPath + PartialSuffix)
+
+	// A successful Finalize (with both fsyncs) replaces it.
+	w, err = NewWriter(outPath, WriterOptions{TileFormat: TileTypePNG, Sync: true, SyncDir: true})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	w.WriteTile(0, 0, 0, []byte("data"))
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+	r, err := OpenReader(outPath)
+	if err != nil {
+		t.Fatalf("OpenReader: %v", err)
+	}
+	defer r.Close()
+	if data, err := r.ReadTile(0, 0, 0); err != nil || string(data) != "data" {
+		t.Errorf("ReadTile = %q, %v; want \"data\"", data, err)
+	}
+	if _, err := os.Stat(outPath + PartialSuffix); !os.IsNotExist(err) {
+		t.Errorf(".partial file left behind (stat err = %v)", err)
+	}
+}
+
 func TestWriter_Deduplication(t *testing.T) {
 	tmpDir := t.TempDir()
 	outPath := filepath.Join(tmpDir, "dedup.pmtiles")
@@ -313,3 +368,628 @@ func TestWriter_ConcurrentWrites(t *testing.T) {
 		t.Errorf("NumAddressedTiles = %d, want %d", numAddressed, totalTiles)
 	}
 }
+
+func TestWriter_ReadTileDuringGeneration(t *testing.T) {
+	outPath := filepath.Join(t.TempDir(), "test.pmtiles")
+	w, err := NewWriter(outPath, WriterOptions{
+		MinZoom:    0,
+		MaxZoom:    1,
+		TileFormat: TileTypePNG,
+		Readable:   true,
+	})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+
+	w.WriteTile(1, 0, 0, []byte("a"))
+	w.WriteTile(1, 1, 0, []byte("b"))
+	w.WriteTile(1, 1, 1, []byte("a")) // deduplicated
+
+	// Zoom 1 not complete yet: tiles are hidden.
+	if data, err := w.ReadTile(1, 0, 0); data != nil || err != nil {
+		t.Errorf("ReadTile before CompleteZoom = %q, %v; want nil, nil", data, err)
+	}
+
+	w.CompleteZoom(1)
+	for _, tc := range []struct {
+		x, y int
+		want string
+	}{{0, 0, "a"}, {1, 0, "b"}, {1, 1, "a"}} {
+		data, err := w.ReadTile(1, tc.x, tc.y)
+		if err != nil || string(data) != tc.want {
+			t.Errorf("ReadTile(1,%d,%d) = %q, %v; want %q", tc.x, tc.y, data, err, tc.want)
+		}
+	}
+	if data, _ := w.ReadTile(1, 0, 1); data != nil {
+		t.Errorf("ReadTile of unwritten tile = %q, want nil", data)
+	}
+	if got := w.CompletedZooms(); len(got) != 1 || got[0] != 1 {
+		t.Errorf("CompletedZooms = %v, want [1]", got)
+	}
+
+	w.WriteTile(0, 0, 0, []byte("c"))
+	w.CompleteZoom(0)
+	if data, _ := w.ReadTile(0, 0, 0); string(data) != "c" {
+		t.Errorf("ReadTile(0,0,0) = %q, want c", data)
+	}
+
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+	if _, err := w.ReadTile(0, 0, 0); err == nil {
+		t.Error("expected error reading after Finalize")
+	}
+}
+
+func TestWriter_ReadTileRequiresReadable(t *testing.T) {
+	w, err := NewWriter(filepath.Join(t.TempDir(), "test.pmtiles"), WriterOptions{TileFormat: TileTypePNG})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	defer w.Abort()
+	w.CompleteZoom(0)
+	if _, err := w.ReadTile(0, 0, 0); err == nil {
+		t.Error("expected error without Readable")
+	}
+}
+
+func TestWriter_DuplicateWrites(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		firstWriteWins bool
+		want           string
+	}{
+		{"last-write-wins", false, "third"},
+		{"first-write-wins", true, "first"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			outPath := filepath.Join(tmpDir, "dup.pmtiles")
+			w, err := NewWriter(outPath, WriterOptions{
+				MinZoom:        0,
+				MaxZoom:        1,
+				Bounds:         cog.Bounds{MinLon: -10, MaxLon: 10, MinLat: -10, MaxLat: 10},
+				TileFormat:     TileTypePNG,
+				TempDir:        tmpDir,
+				Readable:       true,
+				FirstWriteWins: tc.firstWriteWins,
+			})
+			if err != nil {
+				t.Fatalf("NewWriter: %v", err)
+			}
+
+			w.WriteTile(1, 0, 0, []byte("first"))
+			w.WriteTile(1, 1, 0, []byte("other"))
+			w.WriteTile(1, 0, 0, []byte("second"))
+			w.WriteTile(1, 0, 0, []byte("third"))
+			w.CompleteZoom(1)
+
+			// The preview agrees with what the archive will contain.
+			if got, _ := w.ReadTile(1, 0, 0); string(got) != tc.want {
+				t.Errorf("ReadTile before Finalize = %q, want %q", got, tc.want)
+			}
+
+			if err := w.Finalize(); err != nil {
+				t.Fatalf("Finalize: %v", err)
+			}
+			if n := w.DuplicateTiles(); n != 2 {
+				t.Errorf("DuplicateTiles = %d, want 2", n)
+			}
+
+			r, err := OpenReader(outPath)
+			if err != nil {
+				t.Fatalf("OpenReader: %v", err)
+			}
+			defer r.Close()
+			if n := r.NumTiles(); n != 2 {
+				t.Errorf("NumTiles = %d, want 2", n)
+			}
+			if got, _ := r.ReadTile(1, 0, 0); string(got) != tc.want {
+				t.Errorf("ReadTile(1,0,0) = %q, want %q", got, tc.want)
+			}
+			h := r.Header()
+			if h.NumAddressedTiles != 2 || h.NumTileContents != 2 {
+				t.Errorf("addressed=%d contents=%d, want 2/2", h.NumAddressedTiles, h.NumTileContents)
+			}
+			// Dropped writes are not copied into the archive.
+			if h.TileDataLength != uint64(len(tc.want)+len("other")) {
+				t.Errorf("TileDataLength = %d, want %d", h.TileDataLength, len(tc.want)+len("other"))
+			}
+		})
+	}
+}
+
+func TestWriter_ExtraMetadata(t *testing.T) {
+	tmpDir := t.TempDir()
+	outPath := filepath.Join(tmpDir, "extra.pmtiles")
+	w, err := NewWriter(outPath, WriterOptions{
+		MaxZoom:    0,
+		TileFormat: TileTypePNG,
+		TempDir:    tmpDir,
+		Extra: map[string]interface{}{
+			"acquisition_dates": []string{"2023-07-14"},
+			"name":              "ignored", // writer-owned key
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	w.WriteTile(0, 0, 0, []byte("tile"))
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	r, err := OpenReader(outPath)
+	if err != nil {
+		t.Fatalf("OpenReader: %v", err)
+	}
+	defer r.Close()
+	meta, err := r.ReadMetadata()
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if meta["name"] != "geotiff2pmtiles" {
+		t.Errorf("name = %v, Extra must not override writer keys", meta["name"])
+	}
+	dates, ok := meta["acquisition_dates"].([]interface{})
+	if !ok || len(dates) != 1 || dates[0] != "2023-07-14" {
+		t.Errorf("acquisition_dates = %v", meta["acquisition_dates"])
+	}
+}
+
+func TestWriter_NameVersionLayerID(t *testing.T) {
+	tmpDir := t.TempDir()
+	outPath := filepath.Join(tmpDir, "named.pmtiles")
+	w, err := NewWriter(outPath, WriterOptions{
+		TileFormat: TileTypePNG,
+		TempDir:    tmpDir,
+		Name:       "SWISSIMAGE 10 cm",
+		Version:    "2.1.0",
+		LayerID:    "swissimage-dop10",
+	})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	w.WriteTile(0, 0, 0, []byte("tile"))
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	r, err := OpenReader(outPath)
+	if err != nil {
+		t.Fatalf("OpenReader: %v", err)
+	}
+	defer r.Close()
+	meta, err := r.ReadMetadata()
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	for key, want := range map[string]string{
+		"name": "SWISSIMAGE 10 cm", "version": "2.1.0", "id": "swissimage-dop10",
+	} {
+		if meta[key] != want {
+			t.Errorf("%s = %v, want %q", key, meta[key], want)
+		}
+	}
+}
+
+func TestWriter_Timestamps(t *testing.T) {
+	tmpDir := t.TempDir()
+	outPath := filepath.Join(tmpDir, "dated.pmtiles")
+	w, err := NewWriter(outPath, WriterOptions{
+		TileFormat:  TileTypePNG,
+		TempDir:     tmpDir,
+		GeneratedAt: time.Date(2024, 5, 1, 14, 0, 0, 0, time.FixedZone("CEST", 2*3600)),
+	})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	w.WriteTile(0, 0, 0, []byte("tile"))
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	r, err := OpenReader(outPath)
+	if err != nil {
+		t.Fatalf("OpenReader: %v", err)
+	}
+	defer r.Close()
+	meta, err := r.ReadMetadata()
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if got := meta["generated_at"]; got != "2024-05-01T12:00:00Z" {
+		t.Errorf("generated_at = %v, want 2024-05-01T12:00:00Z", got)
+	}
+	if _, ok := meta["updated_at"]; ok {
+		t.Errorf("updated_at = %v, want it omitted when zero", meta["updated_at"])
+	}
+}
+
+func TestGenerationTime_SourceDateEpoch(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1714564800")
+	if got, want := GenerationTime(), time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("GenerationTime() = %v, want %v", got, want)
+	}
+}
+
+func TestWriter_StableLayout(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// build writes the z0-z3 pyramid; lastTile replaces the content of the
+	// tile with the highest tile ID.
+	build := func(name, archiveName, lastTile string) string {
+		outPath := filepath.Join(tmpDir, name)
+		w, err := NewWriter(outPath, WriterOptions{
+			MaxZoom:      3,
+			TileFormat:   TileTypePNG,
+			TempDir:      tmpDir,
+			Name:         archiveName,
+			StableLayout: true,
+		})
+		if err != nil {
+			t.Fatalf("NewWriter: %v", err)
+		}
+		for z := 0; z <= 3; z++ {
+			for x := 0; x < 1<<z; x++ {
+				for y := 0; y < 1<<z; y++ {
+					w.WriteTile(z, x, y, []byte(fmt.Sprintf("tile %d/%d/%d", z, x, y)))
+				}
+			}
+		}
+		w.WriteTile(3, 7, 0, []byte(lastTile)) // Hilbert ID of 3/7/0 is the last at z3
+		if err := w.Finalize(); err != nil {
+			t.Fatalf("Finalize: %v", err)
+		}
+		return outPath
+	}
+	a := build("a.pmtiles", "first build", "old")
+	b := build("b.pmtiles", "second build with a longer name", "a changed last tile")
+
+	r, err := OpenReader(b)
+	if err != nil {
+		t.Fatalf("OpenReader: %v", err)
+	}
+	defer r.Close()
+	h := r.Header()
+	if h.TileDataOffset != StableTileDataOffset {
+		t.Errorf("TileDataOffset = %d, want %d", h.TileDataOffset, StableTileDataOffset)
+	}
+	if h.MetadataOffset != h.TileDataOffset+h.TileDataLength || h.LeafDirOffset != h.MetadataOffset+h.MetadataLength {
+		t.Errorf("metadata/leaf dirs not behind the tile data: %+v", h)
+	}
+	if data, err := r.ReadTile(2, 1, 3); err != nil || string(data) != "tile 2/1/3" {
+		t.Errorf("ReadTile(2,1,3) = %q, %v", data, err)
+	}
+	if data, err := r.ReadTile(3, 7, 0); err != nil || string(data) != "a changed last tile" {
+		t.Errorf("ReadTile(3,7,0) = %q, %v", data, err)
+	}
+	if meta, err := r.ReadMetadata(); err != nil || meta["name"] != "second build with a longer name" {
+		t.Errorf("metadata name = %v, %v", meta["name"], err)
+	}
+
+	// Everything but the changed tile sits at the same offset in both
+	// builds, although the metadata changed size.
+	dataA, _ := os.ReadFile(a)
+	dataB, _ := os.ReadFile(b)
+	tilesA, tilesB := dataA[StableTileDataOffset:], dataB[StableTileDataOffset:]
+	same := 0
+	for same < len(tilesA) && same < len(tilesB) && tilesA[same] == tilesB[same] {
+		same++
+	}
+	if want := int(h.TileDataLength) - len("a changed last tile"); same < want {
+		t.Errorf("tile data shares %d leading bytes, want at least %d", same, want)
+	}
+}
+
+func TestWriter_Streaming(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Where ranges cannot be collapsed, a pyramid written max zoom first
+	// stays in write order, unclustered.
+	probe, err := os.Create(filepath.Join(tmpDir, "probe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk := prealloc.CollapseBlock(probe)
+	probe.Close()
+	os.Remove(probe.Name())
+
+	// build writes the z0-z3 pyramid into a streaming writer, one tile ID
+	// at a time in the order of ids, with all tiles of z3 x=0 sharing data.
+	// With complete, each zoom is completed once the next one starts, as
+	// the tile pipeline does.
+	build := func(name string, ids []uint64, complete, stable bool) (*Reader, string) {
+		outPath := filepath.Join(tmpDir, name)
+		w, err := NewWriter(outPath, WriterOptions{
+			MaxZoom:        3,
+			TileFormat:     TileTypePNG,
+			TileSize:       256,
+			Streaming:      true,
+			StableLayout:   stable,
+			Readable:       true,
+			EstimatedTiles: 10_000_000,
+		})
+		if err != nil {
+			t.Fatalf("NewWriter: %v", err)
+		}
+		last := -1
+		for _, id := range ids {
+			z, x, y := TileIDToZXY(id)
+			if complete && last >= 0 && z != last {
+				w.CompleteZoom(last)
+			}
+			last = z
+			data := fmt.Sprintf("tile %d/%d/%d", z, x, y)
+			if z == 3 && x == 0 {
+				data = "shared"
+			}
+			if err := w.WriteTile(z, x, y, []byte(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if complete {
+			w.CompleteZoom(last)
+			if data, err := w.ReadTile(2, 1, 3); err != nil || string(data) != "tile 2/1/3" {
+				t.Errorf("%s: preview ReadTile(2,1,3) = %q, %v", name, data, err)
+			}
+			// The data is in the archive: no temp files are left.
+			if names, _ := filepath.Glob(filepath.Join(tmpDir, "*")); len(names) != 1 || names[0] != outPath+PartialSuffix {
+				t.Errorf("files while writing = %v, want only %s", names, outPath+PartialSuffix)
+			}
+		}
+		if err := w.Finalize(); err != nil {
+			t.Fatalf("Finalize: %v", err)
+		}
+		if names, _ := filepath.Glob(filepath.Join(tmpDir, "*")); len(names) != 1 || names[0] != outPath {
+			t.Errorf("files after Finalize = %v, want only %s", names, outPath)
+		}
+		r, err := OpenReader(outPath)
+		if err != nil {
+			t.Fatalf("OpenReader: %v", err)
+		}
+		t.Cleanup(func() { r.Close() })
+		return r, outPath
+	}
+
+	var ascending []uint64
+	for id := uint64(0); id < ZXYToTileID(4, 0, 0); id++ {
+		ascending = append(ascending, id)
+	}
+	// Max zoom first, as the tile pipeline writes.
+	var pyramid []uint64
+	for z := 3; z >= 0; z-- {
+		for id := ZXYToTileID(z, 0, 0); id < ZXYToTileID(z+1, 0, 0); id++ {
+			pyramid = append(pyramid, id)
+		}
+	}
+
+	for _, tc := range []struct {
+		name             string
+		ids              []uint64
+		complete, stable bool
+		clustered        bool
+		maxOffset        uint64 // highest TileDataOffset allowed
+	}{
+		{"ascending.pmtiles", ascending, true, false, true, StableTileDataOffset},
+		{"pyramid.pmtiles", pyramid, true, false, blk > 0, StableTileDataOffset + uint64(max(blk-1, 0))},
+		{"pyramid-finalize.pmtiles", pyramid, false, false, blk > 0, StableTileDataOffset + uint64(max(blk-1, 0))},
+		{"pyramid-stable.pmtiles", pyramid, true, true, blk > 0, StableTileDataOffset},
+	} {
+		r, outPath := build(tc.name, tc.ids, tc.complete, tc.stable)
+		h := r.Header()
+		if h.Clustered != tc.clustered {
+			t.Errorf("%s: Clustered = %v, want %v", tc.name, h.Clustered, tc.clustered)
+		}
+		if h.TileDataOffset < StableTileDataOffset || h.TileDataOffset > tc.maxOffset {
+			t.Errorf("%s: TileDataOffset = %d, want %d to %d", tc.name, h.TileDataOffset, StableTileDataOffset, tc.maxOffset)
+		}
+		if want := uint64(len(tc.ids) - 8 + 1); h.NumTileContents != want || h.NumAddressedTiles != uint64(len(tc.ids)) {
+			t.Errorf("%s: %d contents of %d tiles, want %d of %d", tc.name, h.NumTileContents, h.NumAddressedTiles, want, len(tc.ids))
+		}
+		fi, err := os.Stat(outPath)
+		if err != nil || uint64(fi.Size()) != h.LeafDirOffset+h.LeafDirLength {
+			t.Errorf("%s: size %v, want %d (ending with the leaf directories)", tc.name, fi.Size(), h.LeafDirOffset+h.LeafDirLength)
+		}
+		for _, id := range tc.ids {
+			z, x, y := TileIDToZXY(id)
+			want := fmt.Sprintf("tile %d/%d/%d", z, x, y)
+			if z == 3 && x == 0 {
+				want = "shared"
+			}
+			if data, err := r.ReadTile(z, x, y); err != nil || string(data) != want {
+				t.Errorf("%s: ReadTile(%d,%d,%d) = %q, %v; want %q", tc.name, z, x, y, data, err, want)
+			}
+		}
+		if meta, err := r.ReadMetadata(); err != nil || meta["format"] != "png" {
+			t.Errorf("%s: metadata format = %v, %v", tc.name, meta["format"], err)
+		}
+		os.Remove(outPath)
+	}
+}
+
+func TestWriter_EstimatedTilesSameArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// The estimate only sizes the temp file preallocation; the archive
+	// must be byte-identical with and without it.
+	build := func(name string, estimated int64) []byte {
+		outPath := filepath.Join(tmpDir, name)
+		w, err := NewWriter(outPath, WriterOptions{
+			MaxZoom:        3,
+			TileFormat:     TileTypePNG,
+			TileSize:       256,
+			TempDir:        tmpDir,
+			EstimatedTiles: estimated,
+		})
+		if err != nil {
+			t.Fatalf("NewWriter: %v", err)
+		}
+		for z := 0; z <= 3; z++ {
+			for x := 0; x < 1<<z; x++ {
+				for y := 0; y < 1<<z; y++ {
+					w.WriteTile(z, x, y, []byte(fmt.Sprintf("tile %d/%d/%d", z, x, y)))
+				}
+			}
+		}
+		if err := w.Finalize(); err != nil {
+			t.Fatalf("Finalize: %v", err)
+		}
+		data, err := os.ReadFile(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	plain := build("plain.pmtiles", 0)
+	prealloc := build("prealloc.pmtiles", 10_000_000)
+	if !bytes.Equal(plain, prealloc) {
+		t.Errorf("archive with EstimatedTiles differs: %d vs %d bytes", len(prealloc), len(plain))
+	}
+}
+
+func TestWriter_CopyTile(t *testing.T) {
+	srcPath, want := writeStreamTestArchive(t)
+	r, err := OpenReader(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Copying zooms 2-3 skips the zoom-0 tile that holds "shared", so the
+	// first shared tile of zoom 2 writes its data again.
+	for _, minZoom := range []int{0, 2} {
+		outPath := filepath.Join(t.TempDir(), "copy.pmtiles")
+		w, err := NewWriter(outPath, WriterOptions{
+			MinZoom: 0, MaxZoom: 3,
+			Bounds:     cog.Bounds{MinLon: -180, MaxLon: 180, MinLat: -85, MaxLat: 85},
+			TileFormat: TileTypePNG, TileSize: 256,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = r.StreamZoomRefs(minZoom, 3, func(z, x, y int, offset uint64, data []byte) error {
+			return w.CopyTile(z, x, y, offset, data)
+		})
+		if err != nil {
+			t.Fatalf("minZoom %d: %v", minZoom, err)
+		}
+		if len(w.dedup) != 0 {
+			t.Errorf("minZoom %d: CopyTile hashed %d tiles", minZoom, len(w.dedup))
+		}
+		if err := w.Finalize(); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := OpenReader(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unique := make(map[string]bool)
+		for k, data := range want {
+			if k[0] < minZoom {
+				continue
+			}
+			unique[string(data)] = true
+			got, err := out.ReadTile(k[0], k[1], k[2])
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("minZoom %d: tile %v = %q, %v; want %q", minZoom, k, got, err, data)
+			}
+		}
+		if got := out.Header().NumTileContents; got != uint64(len(unique)) {
+			t.Errorf("minZoom %d: %d tile contents, want %d", minZoom, got, len(unique))
+		}
+		out.Close()
+
+		if minZoom == 0 {
+			// A full copy with the same options reproduces the source.
+			src, _ := os.ReadFile(srcPath)
+			dst, _ := os.ReadFile(outPath)
+			if !bytes.Equal(src, dst) {
+				t.Errorf("full copy differs from the source: %d vs %d bytes", len(dst), len(src))
+			}
+		}
+	}
+}
+
+func TestWriter_CenterInCoverage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "center.pmtiles")
+	// Two tiles at opposite corners: the bounds midpoint lies between them.
+	w, err := NewWriter(path, WriterOptions{
+		MinZoom: 3, MaxZoom: 3,
+		Bounds:     cog.Bounds{MinLon: -180, MinLat: -85, MaxLon: 180, MaxLat: 85},
+		TileFormat: TileTypePNG, TileSize: 256,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteTile(3, 1, 1, []byte("a"))
+	w.WriteTile(3, 6, 6, []byte("b"))
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	h := r.Header()
+	if h.CenterZoom != 3 {
+		t.Errorf("CenterZoom = %d, want 3", h.CenterZoom)
+	}
+	minLon, minLat, maxLon, maxLat := coord.TileBounds(3, 1, 1)
+	if float64(h.CenterLon) < minLon || float64(h.CenterLon) > maxLon || float64(h.CenterLat) < minLat || float64(h.CenterLat) > maxLat {
+		t.Errorf("center %f,%f is not in tile 3/1/1", h.CenterLon, h.CenterLat)
+	}
+	meta, err := r.ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%.6f,%.6f,3", h.CenterLon, h.CenterLat); meta["center"] != want {
+		t.Errorf("metadata center = %v, want %s as in the header", meta["center"], want)
+	}
+}
+
+func TestWriter_WriteTileLocated(t *testing.T) {
+	for _, streaming := range []bool{false, true} {
+		t.Run(fmt.Sprintf("streaming=%v", streaming), func(t *testing.T) {
+			tmpDir := t.TempDir()
+			w, err := NewWriter(filepath.Join(tmpDir, "out.pmtiles"), WriterOptions{
+				MinZoom:    0,
+				MaxZoom:    1,
+				TileFormat: TileTypePNG,
+				TempDir:    tmpDir,
+				Streaming:  streaming,
+			})
+			if err != nil {
+				t.Fatalf("NewWriter: %v", err)
+			}
+			defer w.Abort()
+
+			a, b := []byte("tile-a"), []byte("tile-bb")
+			offA, err := w.WriteTileLocated(1, 0, 0, a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			offB, _ := w.WriteTileLocated(1, 1, 0, b)
+			offDup, _ := w.WriteTileLocated(1, 0, 1, a)
+			if offA != 0 || offB != int64(len(a)) || offDup != offA {
+				t.Errorf("offsets %d, %d, %d; want 0, %d, 0 (deduplicated)", offA, offB, offDup, len(a))
+			}
+			if off, _ := w.WriteTileLocated(0, 0, 0, nil); off != -1 {
+				t.Errorf("empty tile offset %d, want -1", off)
+			}
+
+			r := w.DataReader()
+			for _, c := range []struct {
+				off  int64
+				want []byte
+			}{{offA, a}, {offB, b}} {
+				got := make([]byte, len(c.want))
+				if _, err := r.ReadAt(got, c.off); err != nil || !bytes.Equal(got, c.want) {
+					t.Errorf("ReadAt(%d) = %q, %v; want %q", c.off, got, err, c.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Package prealloc reserves disk space for large append-only temp files in
+// big extents ahead of the write position. Millions of small appends make
+// ext4 and XFS allocate blocks a few at a time, which fragments the file and
+// churns filesystem metadata; reserving tens of megabytes at once keeps the
+// file in few extents and the allocator out of the write path.
+//
+// The reservation does not change the file size, so readers that stop at
+// EOF (io.Copy, Seek(0, io.SeekEnd)) see only the written data. Where the
+// platform cannot reserve space this way, or the filesystem refuses (e.g.
+// no fallocate support, disk full), preallocation is silently switched off
+// for the file and writes proceed as usual.
+//
+// Collapse is the reverse for files that reserve room ahead of their data:
+// it cuts unused blocks out of the middle of a file without copying what
+// follows, where the file system supports it (ext4 and XFS on Linux).
+package prealloc
+
+import "os"
+
+// Extent size bounds. ExtentFor picks a size between them.
+const (
+	MinExtent = 8 << 20
+	MaxExtent = 256 << 20
+)
+
+// ExtentFor returns the extent size for a file expected to grow to about
+// expectedBytes: a sixteenth of the expected size, so a file ends up in
+// roughly 16 extents, clamped to [MinExtent, MaxExtent]. An unknown size
+// (≤ 0) gets MinExtent.
+func ExtentFor(expectedBytes int64) int64 {
+	return min(max(expectedBytes/16, MinExtent), MaxExtent)
+}
+
+// Allocator reserves space for one file. It is not safe for concurrent
+// use; callers already serialize writes to the file.
+type Allocator struct {
+	f        *os.File
+	extent   int64
+	reserved int64
+	off      bool
+}
+
+// New returns an Allocator for f that reserves extent bytes at a time.
+// With extent 0, Grow reserves exactly up to the requested end.
+func New(f *os.File, extent int64) *Allocator {
+	return &Allocator{f: f, extent: extent}
+}
+
+// Grow makes sure the first end bytes of the file are reserved, reserving
+// the next extent when a write would cross the current reservation.
+// Call it before writing up to offset end.
+func (a *Allocator) Grow(end int64) {
+	if a == nil || a.off || end <= a.reserved {
+		return
+	}
+	next := max(end, a.reserved+a.extent)
+	if err := reserve(a.f, a.reserved, next-a.reserved); err != nil {
+		a.off = true
+		return
+	}
+	a.reserved = next
+}
+
+// Skip treats the first off bytes of the file as reserved, for a file
+// whose writes start at off and leave the space before it sparse.
+func (a *Allocator) Skip(off int64) {
+	if a != nil && off > a.reserved {
+		a.reserved = off
+	}
+}
+
+// Trim releases the space reserved past end, the final size of a file
+// that is kept after its last write (a removed temp file frees it
+// anyway). Truncating to the size frees blocks kept past EOF on ext4 and
+// XFS; a failure only leaves them allocated and is ignored.
+func (a *Allocator) Trim(end int64) {
+	if a == nil || a.reserved <= end {
+		return
+	}
+	a.f.Truncate(end)
+	a.reserved = end
+}
+
+// CollapseBlock returns the block size at which Collapse works on the file
+// system of f, or 0 where it does not. It tries on f, which must be empty:
+// it writes two blocks, collapses the first, and truncates f back to empty.
+func CollapseBlock(f *os.File) int64 {
+	blk := blockSize(f)
+	if blk <= 0 {
+		return 0
+	}
+	defer f.Truncate(0)
+	if _, err := f.WriteAt(make([]byte, 2*blk), 0); err != nil {
+		return 0
+	}
+	if collapse(f, 0, blk) != nil {
+		return 0
+	}
+	return blk
+}
+
+// Collapse removes length bytes at off from f, moving everything behind
+// them down without copying it. off and length must be multiples of the
+// block size CollapseBlock returns, and the range must end before EOF.
+func Collapse(f *os.File, off, length int64) error {
+	return collapse(f, off, length)
+}
+
+// Reserved returns the number of bytes reserved so far; 0 when
+// preallocation is unsupported or was switched off before the first
+// reservation.
+func (a *Allocator) Reserved() int64 {
+	if a == nil {
+		return 0
+	}
+	return a.reserved
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package prealloc
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE: allocate blocks without changing
+// the file size.
+const fallocKeepSize = 0x1
+
+// fallocCollapseRange is FALLOC_FL_COLLAPSE_RANGE: remove a range of
+// blocks and shift the rest of the file down (ext4 and XFS).
+const fallocCollapseRange = 0x8
+
+// reserve allocates length bytes at off with fallocate(2), keeping the
+// file size.
+func reserve(f *os.File, off, length int64) error {
+	return fallocate(f, fallocKeepSize, off, length)
+}
+
+// collapse removes length bytes at off with fallocate(2).
+func collapse(f *os.File, off, length int64) error {
+	return fallocate(f, fallocCollapseRange, off, length)
+}
+
+// fallocate calls fallocate(2), retrying when interrupted.
+func fallocate(f *os.File, mode uint32, off, length int64) error {
+	for {
+		err := syscall.Fallocate(int(f.Fd()), mode, off, length)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// blockSize returns the block size of f's file system.
+func blockSize(f *os.File) int64 {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(f.Fd()), &st); err != nil {
+		return 0
+	}
+	return int64(st.Blksize)
+}
@@ -0,0 +1,36 @@
+package prealloc
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestTrim(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "archive.pmtiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	a := New(f, 8<<20)
+	a.Grow(4096)
+	if a.Reserved() == 0 {
+		t.Skip("preallocation not supported here")
+	}
+	if _, err := f.Write(make([]byte, 4096)); err != nil {
+		t.Fatal(err)
+	}
+	a.Trim(4096)
+	if a.Reserved() != 4096 {
+		t.Errorf("Reserved after Trim = %d, want 4096", a.Reserved())
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := fi.Sys().(*syscall.Stat_t).Blocks * 512; n > 1<<20 {
+		t.Errorf("%d bytes still allocated after Trim, want about 4096", n)
+	}
+}
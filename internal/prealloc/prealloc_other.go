@@ -0,0 +1,24 @@
+//go:build !linux
+
+package prealloc
+
+import (
+	"errors"
+	"os"
+)
+
+// reserve is unsupported on this platform: ftruncate would change the
+// file size, which readers stopping at EOF rely on.
+func reserve(f *os.File, off, length int64) error {
+	return errors.New("preallocation not supported on this platform")
+}
+
+// collapse is unsupported on this platform.
+func collapse(f *os.File, off, length int64) error {
+	return errors.New("collapsing ranges not supported on this platform")
+}
+
+// blockSize is 0: nothing can be collapsed.
+func blockSize(f *os.File) int64 {
+	return 0
+}
@@ -0,0 +1,101 @@
+package prealloc
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtentFor(t *testing.T) {
+	for _, tc := range []struct{ expected, want int64 }{
+		{0, MinExtent},
+		{-1, MinExtent},
+		{16 * MinExtent, MinExtent},
+		{16 * 20 << 20, 20 << 20},
+		{1 << 40, MaxExtent},
+	} {
+		if got := ExtentFor(tc.expected); got != tc.want {
+			t.Errorf("ExtentFor(%d) = %d, want %d", tc.expected, got, tc.want)
+		}
+	}
+}
+
+func TestGrowKeepsSize(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "spill.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	const extent = 1 << 20
+	a := New(f, extent)
+	data := make([]byte, 300<<10)
+	var off int64
+	for i := 0; i < 5; i++ {
+		a.Grow(off + int64(len(data)))
+		if _, err := f.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		off += int64(len(data))
+	}
+	if fi, err := f.Stat(); err != nil || fi.Size() != off {
+		t.Fatalf("size after writes = %v, %v; want %d", fi.Size(), err, off)
+	}
+	if a.Reserved() == 0 {
+		t.Skip("preallocation not supported here")
+	}
+	// 1500 KiB written: the first extent was crossed once.
+	if a.Reserved() != 2*extent {
+		t.Errorf("Reserved = %d, want %d", a.Reserved(), 2*extent)
+	}
+
+	// Extent 0 reserves exactly the requested end.
+	exact := New(f, 0)
+	exact.Grow(12345)
+	if exact.Reserved() != 12345 {
+		t.Errorf("exact Reserved = %d, want 12345", exact.Reserved())
+	}
+}
+
+func TestNilAllocator(t *testing.T) {
+	var a *Allocator
+	a.Grow(1 << 20)
+	a.Trim(0)
+	if a.Reserved() != 0 {
+		t.Errorf("nil Reserved = %d", a.Reserved())
+	}
+}
+
+func TestCollapse(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "archive.pmtiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	blk := CollapseBlock(f)
+	if blk == 0 {
+		t.Skip("collapsing ranges not supported here")
+	}
+	if fi, _ := f.Stat(); fi.Size() != 0 {
+		t.Fatalf("size after CollapseBlock = %d, want 0", fi.Size())
+	}
+	// Three blocks of 'a', 'b', 'c'; collapsing the second leaves a, c.
+	for i, c := range []byte("abc") {
+		if _, err := f.WriteAt(bytes.Repeat([]byte{c}, int(blk)), int64(i)*blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Collapse(f, blk, blk); err != nil {
+		t.Fatalf("Collapse: %v", err)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(bytes.Repeat([]byte{'a'}, int(blk)), bytes.Repeat([]byte{'c'}, int(blk))...)
+	if !bytes.Equal(data, want) {
+		t.Errorf("after Collapse: %d bytes, want %d: a block of 'a' then one of 'c'", len(data), len(want))
+	}
+}
@@ -0,0 +1,108 @@
+// Package profile holds output presets for common map clients. A profile
+// picks tile size, format, and quality that suit the client stack, plus the
+// metadata and client settings needed to display the archive correctly.
+package profile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Profile is a named set of output defaults for one client stack.
+// Explicit command-line flags always override a profile's choices.
+type Profile struct {
+	Name     string
+	TileSize int
+	// Format is used for opaque sources, AlphaFormat for sources that may
+	// have transparent pixels (alpha band or nodata). Float sources keep
+	// Terrarium regardless of profile.
+	Format      string
+	AlphaFormat string
+	Quality     int
+	// hint is a one-line client configuration snippet with a %d verb for
+	// the tile size; see ClientHint.
+	hint string
+}
+
+var profiles = map[string]Profile{
+	// MapLibre GL renders raster sources at 512 px per tile by default, so
+	// 512 px tiles display at their native zoom (the "@2x" grid of the
+	// 256 px scheme: same pixels, one zoom level lower). WebP keeps alpha
+	// and is well supported by WebGL clients.
+	"maplibre": {
+		Name:        "maplibre",
+		TileSize:    512,
+		Format:      "webp",
+		AlphaFormat: "webp",
+		Quality:     80,
+		hint:        `{"type": "raster", "url": "pmtiles://<url>", "tileSize": %d}`,
+	},
+	// Leaflet's grid is 256 px; larger tiles need tileSize/zoomOffset
+	// options that are easy to get wrong. JPEG keeps archives small; PNG
+	// is used when the source can be transparent, which JPEG cannot hold.
+	"leaflet": {
+		Name:        "leaflet",
+		TileSize:    256,
+		Format:      "jpeg",
+		AlphaFormat: "png",
+		Quality:     85,
+		hint:        `pmtiles.leafletRasterLayer(new pmtiles.PMTiles("<url>"), {tileSize: %d})`,
+	},
+	// QGIS is used for analysis: lossless PNG keeps pixel values intact,
+	// and 256 px matches its "Standard" XYZ tile resolution.
+	"qgis": {
+		Name:        "qgis",
+		TileSize:    256,
+		Format:      "png",
+		AlphaFormat: "png",
+		Quality:     85,
+		hint:        "add as an XYZ Tiles connection with tile resolution %d px",
+	},
+}
+
+// Lookup returns the profile with the given name (case-insensitive).
+func Lookup(name string) (Profile, error) {
+	p, ok := profiles[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown profile %q (supported: %s)", name, strings.Join(Names(), ", "))
+	}
+	return p, nil
+}
+
+// Names returns the supported profile names, sorted.
+func Names() []string {
+	names := make([]string, 0, len(profiles))
+	for n := range profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FormatFor returns the tile format for a source that may (transparent) or
+// cannot have transparent pixels.
+func (p Profile) FormatFor(transparent bool) string {
+	if transparent {
+		return p.AlphaFormat
+	}
+	return p.Format
+}
+
+// ClientHint returns the client-side setting needed to display an archive
+// with the given tile size, printed after generation. Clients must be told
+// the tile size when it differs from their default, or tiles are drawn at
+// the wrong zoom.
+func (p Profile) ClientHint(tileSize int) string {
+	return fmt.Sprintf(p.hint, tileSize)
+}
+
+// Metadata returns the keys recorded in the archive metadata so viewers
+// and later tools can tell which client the archive was built for and
+// which tile size to request.
+func (p Profile) Metadata(tileSize int) map[string]interface{} {
+	return map[string]interface{}{
+		"client_profile": p.Name,
+		"tile_size":      tileSize,
+	}
+}
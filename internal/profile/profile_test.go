@@ -0,0 +1,56 @@
+package profile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	for _, name := range []string{"maplibre", "Leaflet", " qgis "} {
+		p, err := Lookup(name)
+		if err != nil {
+			t.Fatalf("Lookup(%q): %v", name, err)
+		}
+		if p.TileSize != 256 && p.TileSize != 512 {
+			t.Errorf("%s: tile size %d", p.Name, p.TileSize)
+		}
+		if p.Format == "" || p.AlphaFormat == "" || p.Quality < 1 || p.Quality > 100 {
+			t.Errorf("%s: incomplete profile %+v", p.Name, p)
+		}
+	}
+	if _, err := Lookup("openlayers"); err == nil {
+		t.Error("Lookup(openlayers): expected error")
+	}
+}
+
+func TestNames(t *testing.T) {
+	want := []string{"leaflet", "maplibre", "qgis"}
+	if got := Names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %v, want %v", got, want)
+	}
+}
+
+func TestFormatFor(t *testing.T) {
+	p, _ := Lookup("leaflet")
+	if f := p.FormatFor(false); f != "jpeg" {
+		t.Errorf("leaflet opaque format = %s, want jpeg", f)
+	}
+	if f := p.FormatFor(true); f != "png" {
+		t.Errorf("leaflet transparent format = %s, want png", f)
+	}
+	p, _ = Lookup("maplibre")
+	if f := p.FormatFor(true); f != "webp" {
+		t.Errorf("maplibre transparent format = %s, want webp", f)
+	}
+}
+
+func TestClientHint(t *testing.T) {
+	p, _ := Lookup("maplibre")
+	if h := p.ClientHint(512); !strings.Contains(h, `"tileSize": 512`) {
+		t.Errorf("maplibre hint = %s, want tileSize 512", h)
+	}
+	if md := p.Metadata(256); md["tile_size"] != 256 || md["client_profile"] != "maplibre" {
+		t.Errorf("Metadata(256) = %v", md)
+	}
+}
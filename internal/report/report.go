@@ -0,0 +1,242 @@
+// Package report writes the machine-readable summary of a run, by default
+// <output>.run-report.json next to the archive, so that orchestration
+// systems can archive and compare runs without parsing the log.
+//
+// Field names follow Prometheus conventions: snake_case with the unit as
+// suffix (_seconds, _bytes). Counters are totals over the run.
+package report
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/mbtiles"
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+	"github.com/pspoerri/geotiff2pmtiles/internal/tile"
+)
+
+// Report is the content of a run report.
+type Report struct {
+	Tool    string   `json:"tool"`
+	Version string   `json:"version"`
+	Command []string `json:"command"`
+	// Settings holds every flag of the run, set or defaulted, by name.
+	Settings map[string]string `json:"settings"`
+	Inputs   []string          `json:"inputs"`
+	Output   string            `json:"output"`
+
+	StartTime       time.Time `json:"start_time"`
+	EndTime         time.Time `json:"end_time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// GenerateSeconds and FinalizeSeconds split the run's main phases;
+	// the rest of DurationSeconds is opening and checking the sources.
+	GenerateSeconds float64 `json:"generate_seconds"`
+	FinalizeSeconds float64 `json:"finalize_seconds"`
+	PeakRSSBytes    int64   `json:"peak_rss_bytes,omitempty"`
+
+	Tiles   Tiles   `json:"tiles"`
+	Archive Archive `json:"archive"`
+	Zooms   []Zoom  `json:"zooms"`
+
+	CoverageGaps []Gap    `json:"coverage_gaps"`
+	Warnings     []string `json:"warnings"`
+}
+
+// Tiles are the tile counters of the generation (see tile.Stats).
+type Tiles struct {
+	Written         int64 `json:"written"`
+	Empty           int64 `json:"empty"`
+	Uniform         int64 `json:"uniform"`
+	Bytes           int64 `json:"bytes"`
+	EncodeCacheHits int64 `json:"encode_cache_hits"`
+	// Duplicates are writes of a z/x/y written before, dropped by the
+	// archive writer.
+	Duplicates int64 `json:"duplicates"`
+	// Oversized tiles encoded to more than --max-tile-bytes; OversizedLeft
+	// are still larger after re-encoding at lower quality.
+	Oversized     int64 `json:"oversized"`
+	OversizedLeft int64 `json:"oversized_left"`
+	// NaNFallbacks are float samples that fell back to nearest-neighbour
+	// because of nodata neighbours (see tile.NaNFallbacks).
+	NaNFallbacks int64 `json:"nan_fallbacks"`
+}
+
+// Archive describes the finished archive, from its header.
+type Archive struct {
+	Bytes          int64  `json:"bytes"`
+	AddressedTiles uint64 `json:"addressed_tiles"`
+	TileEntries    uint64 `json:"tile_entries"`
+	TileContents   uint64 `json:"tile_contents"`
+	// DedupRatio is the number of addressed tiles per stored tile
+	// content: 1 without repeated tiles, 4 when on average four tiles
+	// share their bytes.
+	DedupRatio float64 `json:"dedup_ratio"`
+}
+
+// Zoom is the tile count and timing of one zoom level (see
+// tile.ZoomTiming). Phase times are summed over workers.
+type Zoom struct {
+	Zoom              int     `json:"zoom"`
+	Tiles             int64   `json:"tiles"`
+	WallSeconds       float64 `json:"wall_seconds"`
+	RenderSeconds     float64 `json:"render_seconds"`
+	DownsampleSeconds float64 `json:"downsample_seconds"`
+	EncodeSeconds     float64 `json:"encode_seconds"`
+	WriteSeconds      float64 `json:"write_seconds"`
+	StoreSeconds      float64 `json:"store_seconds"`
+}
+
+// Gap is a hole in the input coverage, in source CRS coordinates.
+type Gap struct {
+	MinX float64 `json:"min_x"`
+	MinY float64 `json:"min_y"`
+	MaxX float64 `json:"max_x"`
+	MaxY float64 `json:"max_y"`
+}
+
+// Path returns the default report path of an output archive or MBTiles
+// file.
+func Path(output string) string {
+	output = strings.TrimSuffix(output, ".pmtiles")
+	return strings.TrimSuffix(output, ".mbtiles") + ".run-report.json"
+}
+
+// New starts the report of a run of tool that started at start, with the
+// command line and the flags of fs.
+func New(tool, version string, start time.Time, fs *flag.FlagSet) *Report {
+	r := &Report{
+		Tool:      tool,
+		Version:   version,
+		Command:   os.Args,
+		Settings:  make(map[string]string),
+		StartTime: start,
+	}
+	fs.VisitAll(func(f *flag.Flag) { r.Settings[f.Name] = f.Value.String() })
+	return r
+}
+
+// SetStats fills in the tile counters and per-zoom timing of a generation
+// whose zoom levels are written zoomOffset levels deeper (--zoom-offset).
+func (r *Report) SetStats(stats tile.Stats, zoomOffset int) {
+	r.Tiles.Written = stats.TileCount
+	r.Tiles.Empty = stats.EmptyTiles
+	r.Tiles.Uniform = stats.UniformTiles
+	r.Tiles.Bytes = stats.TotalBytes
+	r.Tiles.EncodeCacheHits = stats.EncodeCacheHits
+	r.Tiles.Oversized = stats.OversizedTiles
+	r.Tiles.OversizedLeft = stats.OversizedLeft
+	for _, f := range stats.NaNFallbacks {
+		r.Tiles.NaNFallbacks += f.Samples
+	}
+	r.FinalizeSeconds = stats.Timing.Finalize.Seconds()
+
+	r.Zooms = make([]Zoom, 0, len(stats.Timing.Zooms))
+	for _, zt := range stats.Timing.Zooms {
+		r.Zooms = append(r.Zooms, Zoom{
+			Zoom:              zt.Zoom + zoomOffset,
+			Tiles:             zt.Tiles,
+			WallSeconds:       zt.Wall.Seconds(),
+			RenderSeconds:     zt.Render.Seconds(),
+			DownsampleSeconds: zt.Downsample.Seconds(),
+			EncodeSeconds:     zt.Encode.Seconds(),
+			WriteSeconds:      zt.Write.Seconds(),
+			StoreSeconds:      zt.Store.Seconds(),
+		})
+	}
+}
+
+// SetGaps records the coverage gaps found in the inputs.
+func (r *Report) SetGaps(gaps []cog.CoverageGap) {
+	r.CoverageGaps = make([]Gap, len(gaps))
+	for i, g := range gaps {
+		r.CoverageGaps[i] = Gap{MinX: g.MinX, MinY: g.MinY, MaxX: g.MaxX, MaxY: g.MaxY}
+	}
+}
+
+// SetArchive records the finished archive at path, a PMTiles archive or,
+// by its .mbtiles extension, an MBTiles file.
+func (r *Report) SetArchive(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	var h pmtiles.Header
+	if strings.HasSuffix(path, ".mbtiles") {
+		mr, err := mbtiles.OpenReader(path)
+		if err != nil {
+			return err
+		}
+		h = mr.Header()
+		mr.Close()
+	} else {
+		pr, err := pmtiles.OpenReader(path)
+		if err != nil {
+			return err
+		}
+		h = pr.Header()
+		pr.Close()
+	}
+
+	r.Output = path
+	r.Archive = Archive{
+		Bytes:          fi.Size(),
+		AddressedTiles: h.NumAddressedTiles,
+		TileEntries:    h.NumTileEntries,
+		TileContents:   h.NumTileContents,
+	}
+	if h.NumTileContents > 0 {
+		r.Archive.DedupRatio = float64(h.NumAddressedTiles) / float64(h.NumTileContents)
+	}
+	return nil
+}
+
+// Write sets the end time to end and writes the report to path as
+// indented JSON.
+func (r *Report) Write(path string, end time.Time) error {
+	r.EndTime = end
+	r.DurationSeconds = end.Sub(r.StartTime).Seconds()
+	if r.CoverageGaps == nil {
+		r.CoverageGaps = []Gap{}
+	}
+	if r.Warnings == nil {
+		r.Warnings = []string{}
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Warnings collects the warnings written to a log: used as (part of) the
+// log output, it keeps the text after "WARNING: " of every line that has
+// it. Safe for concurrent use.
+type Warnings struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+// Write implements io.Writer.
+func (w *Warnings) Write(p []byte) (int, error) {
+	const marker = "WARNING: "
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if i := strings.Index(line, marker); i >= 0 {
+			w.lines = append(w.lines, line[i+len(marker):])
+		}
+	}
+	return len(p), nil
+}
+
+// Lines returns the warnings collected so far.
+func (w *Warnings) Lines() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.lines...)
+}
@@ -0,0 +1,97 @@
+package report
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+	"github.com/pspoerri/geotiff2pmtiles/internal/tile"
+)
+
+func TestWarnings(t *testing.T) {
+	var w Warnings
+	l := log.New(&w, "", log.LstdFlags)
+	l.Printf("Found %d GeoTIFF file(s)", 2)
+	l.Printf("WARNING: tile size %d is not a power of two", 300)
+	l.Printf("WARNING: %s", "sources disagree")
+
+	got := w.Lines()
+	want := []string{"tile size 300 is not a power of two", "sources disagree"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Lines = %q, want %q", got, want)
+	}
+}
+
+func TestReport(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "out.pmtiles")
+	w, err := pmtiles.NewWriter(output, pmtiles.WriterOptions{TileFormat: pmtiles.TileTypePNG, MaxZoom: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Three tiles, two of them with the same bytes.
+	for i, data := range []string{"a", "b", "b"} {
+		if err := w.WriteTile(1, i%2, i/2, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("quality", 85, "")
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	r := New("geotiff2pmtiles", "1.0", start, fs)
+	r.SetStats(tile.Stats{
+		TileCount: 3,
+		Timing: tile.Timing{
+			Zooms:    []tile.ZoomTiming{{Zoom: 0, Tiles: 3, Wall: 2 * time.Second}},
+			Finalize: time.Second,
+		},
+	}, 1)
+	r.SetGaps([]cog.CoverageGap{{MinX: 1, MinY: 2, MaxX: 3, MaxY: 4}})
+	if err := r.SetArchive(output); err != nil {
+		t.Fatal(err)
+	}
+	path := Path(output)
+	if err := r.Write(path, start.Add(90*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "out.run-report.json"); path != want {
+		t.Errorf("Path = %s, want %s", path, want)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Report
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Settings["quality"] != "85" {
+		t.Errorf("settings = %v, want quality 85", got.Settings)
+	}
+	if got.DurationSeconds != 90 || got.FinalizeSeconds != 1 {
+		t.Errorf("duration %gs, finalize %gs; want 90s, 1s", got.DurationSeconds, got.FinalizeSeconds)
+	}
+	if len(got.Zooms) != 1 || got.Zooms[0].Zoom != 1 || got.Zooms[0].WallSeconds != 2 {
+		t.Errorf("zooms = %+v, want zoom 1 (offset applied) with 2s wall", got.Zooms)
+	}
+	if a := got.Archive; a.AddressedTiles != 3 || a.TileContents != 2 || a.DedupRatio != 1.5 {
+		t.Errorf("archive = %+v, want 3 addressed tiles, 2 contents, dedup ratio 1.5", a)
+	}
+	if len(got.CoverageGaps) != 1 || got.CoverageGaps[0].MaxY != 4 {
+		t.Errorf("coverage gaps = %+v", got.CoverageGaps)
+	}
+	if got.Warnings == nil {
+		t.Error("warnings should be an empty list, not null")
+	}
+}
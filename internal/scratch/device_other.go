@@ -0,0 +1,8 @@
+//go:build !unix
+
+package scratch
+
+// device is unsupported on this platform.
+func device(path string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package scratch
+
+import (
+	"os"
+	"syscall"
+)
+
+// device returns the ID of the device holding path.
+func device(path string) (uint64, bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
@@ -0,0 +1,69 @@
+// Package scratch decides where a run keeps its temporary files. Tile store
+// spill files are read and written throughout generation and belong on the
+// fastest disk. The archive writer's temp file holds all tile data and is
+// copied into the archive by Finalize: on the output's file system that
+// copy can be a cheap in-kernel copy (or a reflink), across file systems
+// every byte is read and written once more.
+package scratch
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Dirs are the directories of a run's temporary files.
+type Dirs struct {
+	Spill  string // tile store spill files
+	Writer string // the archive writer's temp file
+	// Colocated reports that the writer's temp file was kept next to the
+	// output instead of in the temp dir, which is on another file system.
+	Colocated bool
+	// Warning describes a layout that costs an extra copy of all tile
+	// data in Finalize, or is empty.
+	Warning string
+}
+
+// sameFileSystem is SameFileSystem, replaced in tests.
+var sameFileSystem = SameFileSystem
+
+// Plan returns the temporary directories for an archive written to output.
+// tempDir (default: the output's directory) holds the spill files, and the
+// writer's temp file too when it is on the output's file system; otherwise
+// that file stays next to the output. writerDir, when set, places the
+// writer's temp file explicitly, with a warning if that costs a copy.
+func Plan(output, tempDir, writerDir string) Dirs {
+	outDir := filepath.Dir(output)
+	d := Dirs{Spill: outDir, Writer: outDir}
+	if tempDir != "" {
+		d.Spill = tempDir
+	}
+	switch {
+	case writerDir != "":
+		d.Writer = writerDir
+		if same, known := sameFileSystem(writerDir, outDir); known && !same {
+			d.Warning = fmt.Sprintf("writer temp dir %s is on another file system than %s: finalizing copies all tile data across", writerDir, outDir)
+		}
+	case tempDir != "":
+		if same, known := sameFileSystem(tempDir, outDir); known && !same {
+			d.Colocated = true
+		} else {
+			d.Writer = tempDir
+		}
+	}
+	return d
+}
+
+// SameFileSystem reports whether paths a and b are on the same file
+// system; known is false where that cannot be determined (a path does not
+// exist, or the platform does not tell).
+func SameFileSystem(a, b string) (same, known bool) {
+	da, ok := device(a)
+	if !ok {
+		return false, false
+	}
+	db, ok := device(b)
+	if !ok {
+		return false, false
+	}
+	return da == db, true
+}
@@ -0,0 +1,54 @@
+package scratch
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSameFileSystem(t *testing.T) {
+	dir := t.TempDir()
+	if same, known := SameFileSystem(dir, filepath.Join(dir, ".")); !known || !same {
+		t.Errorf("SameFileSystem(dir, dir) = %v, %v; want true, true", same, known)
+	}
+	if _, known := SameFileSystem(dir, filepath.Join(dir, "missing")); known {
+		t.Error("known for a missing path")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	var cross bool // whether the fake scratch disk is another file system
+	sameFileSystem = func(a, b string) (bool, bool) {
+		if a == "/scratch" || b == "/scratch" {
+			return !cross, true
+		}
+		return true, true
+	}
+	defer func() { sameFileSystem = SameFileSystem }()
+
+	tests := []struct {
+		name            string
+		cross           bool
+		tempDir, writer string
+		want            Dirs
+		wantWarning     bool
+	}{
+		{"defaults", false, "", "", Dirs{Spill: "/out", Writer: "/out"}, false},
+		{"temp dir on the output's file system", false, "/scratch", "", Dirs{Spill: "/scratch", Writer: "/scratch"}, false},
+		{"temp dir elsewhere", true, "/scratch", "", Dirs{Spill: "/scratch", Writer: "/out", Colocated: true}, false},
+		{"explicit writer dir elsewhere", true, "/scratch", "/scratch", Dirs{Spill: "/scratch", Writer: "/scratch"}, true},
+		{"explicit writer dir", true, "/scratch", "/out/tmp", Dirs{Spill: "/scratch", Writer: "/out/tmp"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cross = tt.cross
+			got := Plan("/out/a.pmtiles", tt.tempDir, tt.writer)
+			if (got.Warning != "") != tt.wantWarning {
+				t.Errorf("warning %q, want one: %v", got.Warning, tt.wantWarning)
+			}
+			got.Warning = ""
+			if got != tt.want {
+				t.Errorf("Plan = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package serve
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+)
+
+// ArchiveReader reads a finished archive (implemented by pmtiles.Reader).
+type ArchiveReader interface {
+	TileReader
+	Header() pmtiles.Header
+	ReadMetadata() (map[string]interface{}, error)
+}
+
+//go:embed viewer.html
+var viewerHTML []byte
+
+// Archive returns a read-only handler for previewing a finished archive of
+// tileSize pixel raster tiles (ignored for vector tiles):
+//
+//	GET /             MapLibre viewer of the archive
+//	GET /tiles.json   TileJSON 3.0 describing it
+//	GET /{z}/{x}/{y}  tiles, with an optional extension on y
+//
+// Gzip-compressed tiles (usually MVT) are sent as stored, with
+// Content-Encoding: gzip. The viewer loads MapLibre GL JS from a CDN, so the
+// browser, not the server, needs internet access.
+func Archive(r ArchiveReader, tileSize int) (http.Handler, error) {
+	h := r.Header()
+	meta, err := r.ReadMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(viewerHTML)
+	})
+	mux.HandleFunc("GET /tiles.json", func(w http.ResponseWriter, req *http.Request) {
+		scheme := "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+		base := scheme + "://" + req.Host
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tileJSON(h, meta, base, tileSize))
+	})
+	mux.HandleFunc("GET /", func(w http.ResponseWriter, req *http.Request) {
+		z, x, y, ok := parseTilePath(req.URL.Path)
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		data, err := r.ReadTile(z, x, y)
+		if err != nil {
+			log.Printf("Reading tile z%d/%d/%d: %v", z, x, y, err)
+			http.Error(w, "read failed", http.StatusInternalServerError)
+			return
+		}
+		if data == nil {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", contentType(h.TileType))
+		if h.TileCompression == pmtiles.CompressionGzip {
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(data))
+	})
+	return mux, nil
+}
+
+// tileJSON describes the archive with header h and metadata meta, its tiles
+// served under base. The viewer reads "format" (png, jpeg, webp, pbf) to
+// pick a raster or vector source, and "tile_size" (as in client profile
+// metadata) to display raster tiles at their size.
+func tileJSON(h pmtiles.Header, meta map[string]interface{}, base string, tileSize int) map[string]interface{} {
+	format := pmtiles.TileTypeString(h.TileType)
+	if h.TileType == pmtiles.TileTypeMVT {
+		format = "pbf"
+	}
+	tj := map[string]interface{}{
+		"tilejson": "3.0.0",
+		"tiles":    []string{fmt.Sprintf("%s/{z}/{x}/{y}.%s", base, format)},
+		"format":   format,
+		"minzoom":  h.MinZoom,
+		"maxzoom":  h.MaxZoom,
+		"bounds":   []float32{h.MinLon, h.MinLat, h.MaxLon, h.MaxLat},
+		"center":   []float32{h.CenterLon, h.CenterLat, float32(h.CenterZoom)},
+	}
+	if h.TileType != pmtiles.TileTypeMVT {
+		tj["tile_size"] = tileSize
+	}
+	for _, k := range []string{"name", "description", "attribution", "version", "vector_layers"} {
+		if v, ok := meta[k]; ok {
+			tj[k] = v
+		}
+	}
+	return tj
+}
@@ -0,0 +1,75 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+)
+
+func TestArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.pmtiles")
+	w, err := pmtiles.NewWriter(path, pmtiles.WriterOptions{
+		MinZoom:    0,
+		MaxZoom:    1,
+		TileFormat: pmtiles.TileTypePNG,
+		Name:       "ortho",
+		Bounds:     cog.Bounds{MinLon: 5, MinLat: 45, MaxLon: 11, MaxLat: 48},
+	})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	w.WriteTile(1, 1, 0, []byte("tile"))
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+	r, err := pmtiles.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	h, err := Archive(r, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	if rec := get("/"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "maplibre") {
+		t.Errorf("viewer: status %d, body %.60q", rec.Code, rec.Body.String())
+	}
+	if rec := get("/1/1/0.png"); rec.Code != http.StatusOK || rec.Body.String() != "tile" || rec.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("tile: status %d, type %q, body %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	if rec := get("/1/0/0.png"); rec.Code != http.StatusNotFound {
+		t.Errorf("missing tile: status %d, want 404", rec.Code)
+	}
+
+	rec := get("/tiles.json")
+	var tj struct {
+		Tiles    []string  `json:"tiles"`
+		Format   string    `json:"format"`
+		Name     string    `json:"name"`
+		MaxZoom  int       `json:"maxzoom"`
+		Bounds   []float64 `json:"bounds"`
+		TileSize int       `json:"tile_size"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tj); err != nil {
+		t.Fatalf("tiles.json: %v: %s", err, rec.Body)
+	}
+	if len(tj.Tiles) != 1 || tj.Tiles[0] != "http://example.com/{z}/{x}/{y}.png" {
+		t.Errorf("tiles = %q", tj.Tiles)
+	}
+	if tj.Format != "png" || tj.Name != "ortho" || tj.MaxZoom != 1 || tj.TileSize != 512 || len(tj.Bounds) != 4 || tj.Bounds[0] != 5 {
+		t.Errorf("tiles.json = %+v", tj)
+	}
+}
@@ -0,0 +1,63 @@
+package serve
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// TileReader reads encoded tiles (implemented by pmtiles.Writer opened with
+// WriterOptions.Readable, and by pmtiles.Reader).
+type TileReader interface {
+	// ReadTile returns the encoded tile, or nil, nil if it does not exist.
+	ReadTile(z, x, y int) ([]byte, error)
+}
+
+// zoomLister is optionally implemented by a TileReader to report which zoom
+// levels are complete (pmtiles.Writer.CompletedZooms).
+type zoomLister interface {
+	CompletedZooms() []int
+}
+
+// Preview returns a read-only handler serving GET /{z}/{x}/{y} from r.
+// Missing tiles (including tiles of zoom levels still being generated)
+// return 404. If r reports completed zooms, GET /zooms returns them as a
+// JSON array so a viewer can restrict itself to finished levels.
+func Preview(r TileReader, tileType uint8) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if req.URL.Path == "/zooms" {
+			zl, ok := r.(zoomLister)
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(zl.CompletedZooms())
+			return
+		}
+
+		z, x, y, ok := parseTilePath(req.URL.Path)
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		data, err := r.ReadTile(z, x, y)
+		if err != nil {
+			log.Printf("Preview tile z%d/%d/%d: %v", z, x, y, err)
+			http.Error(w, "read failed", http.StatusServiceUnavailable)
+			return
+		}
+		if data == nil {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", contentType(tileType))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+	})
+}
@@ -0,0 +1,52 @@
+package serve
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+)
+
+func TestPreview_ServesCompletedZooms(t *testing.T) {
+	w, err := pmtiles.NewWriter(filepath.Join(t.TempDir(), "out.pmtiles"), pmtiles.WriterOptions{
+		MinZoom:    0,
+		MaxZoom:    1,
+		TileFormat: pmtiles.TileTypeJPEG,
+		Readable:   true,
+	})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	defer w.Abort()
+
+	w.WriteTile(1, 1, 1, []byte("tile"))
+	h := Preview(w, pmtiles.TileTypeJPEG)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	if rec := get("/1/1/1.jpg"); rec.Code != http.StatusNotFound {
+		t.Errorf("incomplete zoom: status %d, want 404", rec.Code)
+	}
+
+	w.CompleteZoom(1)
+	rec := get("/1/1/1.jpg")
+	if rec.Code != http.StatusOK || rec.Body.String() != "tile" {
+		t.Errorf("completed zoom: status %d body %q", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if rec := get("/1/0/0"); rec.Code != http.StatusNotFound {
+		t.Errorf("missing tile: status %d, want 404", rec.Code)
+	}
+	if rec := get("/zooms"); strings.TrimSpace(rec.Body.String()) != "[1]" {
+		t.Errorf("/zooms = %q, want [1]", rec.Body.String())
+	}
+}
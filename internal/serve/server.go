@@ -0,0 +1,545 @@
+// Package serve implements an on-demand tile server that renders tiles from
+// source COGs as they are requested, caches the encoded results, and
+// periodically merges the new tiles into a PMTiles archive.
+package serve
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+)
+
+// TileRenderer renders encoded tiles on demand (implemented by tile.Renderer).
+type TileRenderer interface {
+	// InRange reports whether z/x/y can produce a tile at all.
+	InRange(z, x, y int) bool
+	// RenderTile returns the encoded tile, or nil for an empty tile.
+	RenderTile(z, x, y int) ([]byte, error)
+}
+
+// Options configures a Server.
+type Options struct {
+	OutputPath    string                // PMTiles archive the cache is flushed into
+	FlushInterval time.Duration         // how often to flush new tiles (0 = only on shutdown)
+	CacheBytes    int64                 // new tiles kept before a flush is forced (0 = DefaultCacheBytes)
+	Writer        pmtiles.WriterOptions // header/metadata options for flushed archives
+	Verbose       bool
+}
+
+// DefaultCacheBytes is the size of rendered tiles a Server keeps in memory
+// before it flushes them into the archive ahead of FlushInterval.
+const DefaultCacheBytes = 256 << 20
+
+// emptyTileBytes is what an empty result counts against CacheBytes: it
+// holds no data, but its map entry is not free.
+const emptyTileBytes = 64
+
+// Server serves /{z}/{x}/{y} tiles, rendering cache misses on demand.
+//
+// Tiles of the archive at OutputPath are read from it on request; only
+// tiles rendered since the last flush (including empty results) are kept
+// in memory, so memory grows with what was visited since then, not with
+// the archive. Once they exceed CacheBytes the server flushes early.
+// Concurrent requests for the same uncached tile wait for a single render
+// instead of duplicating work. Empty results are forgotten on flush and
+// rendered again if asked for.
+//
+// Every tile is served with an ETag and a Last-Modified time (when it was
+// rendered, or the archive's update time for tiles read from disk), and
+// conditional requests are answered with 304 Not Modified, so a caching
+// reverse proxy can revalidate instead of refetching.
+type Server struct {
+	renderer TileRenderer
+	opts     Options
+
+	mu          sync.Mutex
+	fresh       map[uint64]cachedTile // rendered since the last flush
+	flushing    map[uint64]cachedTile // fresh tiles of a flush in progress
+	freshBytes  int64                 // size of fresh, see emptyTileBytes
+	inflight    map[uint64]*renderCall
+	dirty       bool      // non-empty tiles in fresh
+	flushQueued bool      // an early flush has been started
+	generatedAt time.Time // recorded as generated_at in flushed archives
+
+	baseMu       sync.RWMutex
+	base         *pmtiles.Reader // archive at OutputPath; nil before the first flush
+	baseModified time.Time       // Last-Modified of tiles read from base
+
+	flushMu sync.Mutex // serializes Flush calls
+}
+
+// cachedTile is a rendered tile with its validators.
+type cachedTile struct {
+	data     []byte // encoded bytes (nil = rendered, empty)
+	modified time.Time
+	etag     string
+}
+
+func newCachedTile(data []byte, modified time.Time) cachedTile {
+	t := cachedTile{data: data, modified: modified}
+	if data != nil {
+		h := fnv.New64a()
+		h.Write(data)
+		t.etag = fmt.Sprintf(`"%016x"`, h.Sum64())
+	}
+	return t
+}
+
+// renderCall tracks a render in progress so concurrent requests share it.
+type renderCall struct {
+	done chan struct{}
+	tile cachedTile
+	err  error
+}
+
+// New creates a Server that renders via r and flushes according to opts.
+func New(r TileRenderer, opts Options) *Server {
+	if opts.CacheBytes <= 0 {
+		opts.CacheBytes = DefaultCacheBytes
+	}
+	return &Server{
+		renderer:    r,
+		opts:        opts,
+		fresh:       make(map[uint64]cachedTile),
+		inflight:    make(map[uint64]*renderCall),
+		generatedAt: pmtiles.GenerationTime(),
+	}
+}
+
+// Load serves the tiles of an existing PMTiles archive from disk, so that
+// previously visited regions survive restarts, and merges them into later
+// flushes. Returns the number of tiles in the archive. A missing archive
+// is not an error. The archive stays open until Close.
+//
+// Its tiles are dated by the archive's updated_at (or generated_at)
+// metadata, falling back to the file's modification time, and the archive's
+// generated_at is carried over into later flushes.
+func (s *Server) Load(path string) (int, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	reader, err := pmtiles.OpenReader(path)
+	if err != nil {
+		return 0, err
+	}
+
+	h := reader.Header()
+	if h.TileType != s.opts.Writer.TileFormat {
+		reader.Close()
+		return 0, fmt.Errorf("existing archive %s has tile type %s, expected %s",
+			path, pmtiles.TileTypeString(h.TileType), pmtiles.TileTypeString(s.opts.Writer.TileFormat))
+	}
+	meta, err := reader.ReadMetadata()
+	if err != nil {
+		reader.Close()
+		return 0, err
+	}
+	generated := metadataTime(meta, "generated_at")
+	modified := metadataTime(meta, "updated_at")
+	if modified.IsZero() {
+		modified = generated
+	}
+	if modified.IsZero() {
+		if fi, err := os.Stat(path); err == nil {
+			modified = fi.ModTime().UTC().Truncate(time.Second)
+		}
+	}
+
+	s.mu.Lock()
+	if !generated.IsZero() {
+		s.generatedAt = generated
+	}
+	s.mu.Unlock()
+	s.setBase(reader, modified)
+	return reader.NumTiles(), nil
+}
+
+// setBase makes r the archive tiles are read from, closing the previous one.
+func (s *Server) setBase(r *pmtiles.Reader, modified time.Time) {
+	s.baseMu.Lock()
+	old := s.base
+	s.base, s.baseModified = r, modified
+	s.baseMu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+}
+
+// Close releases the archive tiles are read from. Call it after Run or the
+// last Flush.
+func (s *Server) Close() error {
+	s.baseMu.Lock()
+	defer s.baseMu.Unlock()
+	if s.base == nil {
+		return nil
+	}
+	err := s.base.Close()
+	s.base = nil
+	return err
+}
+
+// metadataTime parses an RFC 3339 timestamp from archive metadata, or
+// returns the zero time.
+func metadataTime(meta map[string]interface{}, key string) time.Time {
+	v, _ := meta[key].(string)
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}
+	}
+	return t.UTC()
+}
+
+// Tile returns the encoded tile at z/x/y, rendering and caching it on a
+// miss. Returns nil, nil for empty or out-of-range tiles.
+func (s *Server) Tile(z, x, y int) ([]byte, error) {
+	t, err := s.tile(z, x, y)
+	return t.data, err
+}
+
+func (s *Server) tile(z, x, y int) (cachedTile, error) {
+	if !s.renderer.InRange(z, x, y) {
+		return cachedTile{}, nil
+	}
+	id := pmtiles.ZXYToTileID(z, x, y)
+
+	s.mu.Lock()
+	t, ok := s.cached(id)
+	s.mu.Unlock()
+	if ok {
+		return t, nil
+	}
+	if t, ok, err := s.baseTile(z, x, y); ok || err != nil {
+		return t, err
+	}
+
+	// Look again: the tile may have been rendered while base was read.
+	s.mu.Lock()
+	if t, ok := s.cached(id); ok {
+		s.mu.Unlock()
+		return t, nil
+	}
+	if c, ok := s.inflight[id]; ok {
+		s.mu.Unlock()
+		<-c.done
+		return c.tile, c.err
+	}
+	c := &renderCall{done: make(chan struct{})}
+	s.inflight[id] = c
+	s.mu.Unlock()
+
+	var data []byte
+	data, c.err = s.renderer.RenderTile(z, x, y)
+	c.tile = newCachedTile(data, time.Now().UTC().Truncate(time.Second))
+
+	s.mu.Lock()
+	delete(s.inflight, id)
+	if c.err == nil {
+		s.fresh[id] = c.tile
+		s.freshBytes += int64(len(data)) + emptyTileBytes
+		if data != nil {
+			s.dirty = true
+		}
+		if s.freshBytes >= s.opts.CacheBytes && !s.flushQueued {
+			s.flushQueued = true
+			go s.flushEarly()
+		}
+	}
+	s.mu.Unlock()
+	close(c.done)
+
+	return c.tile, c.err
+}
+
+// cached returns tile id if it was rendered since the last flush. Caller
+// must hold mu.
+func (s *Server) cached(id uint64) (cachedTile, bool) {
+	if t, ok := s.fresh[id]; ok {
+		return t, true
+	}
+	t, ok := s.flushing[id]
+	return t, ok
+}
+
+// baseTile reads tile z/x/y from the archive; ok is false if the archive
+// does not have it.
+func (s *Server) baseTile(z, x, y int) (t cachedTile, ok bool, err error) {
+	s.baseMu.RLock()
+	defer s.baseMu.RUnlock()
+	if s.base == nil {
+		return cachedTile{}, false, nil
+	}
+	data, err := s.base.ReadTile(z, x, y)
+	if err != nil || data == nil {
+		return cachedTile{}, false, err
+	}
+	return newCachedTile(data, s.baseModified), true, nil
+}
+
+// flushEarly flushes once the fresh tiles exceed CacheBytes.
+func (s *Server) flushEarly() {
+	if err := s.Flush(); err != nil {
+		log.Printf("WARNING: flush failed: %v", err)
+	}
+	s.mu.Lock()
+	s.flushQueued = false
+	s.mu.Unlock()
+}
+
+// NumTiles returns the number of non-empty tiles in the archive and the
+// cache.
+func (s *Server) NumTiles() int {
+	s.baseMu.RLock()
+	defer s.baseMu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	if s.base != nil {
+		n = s.base.NumTiles()
+	}
+	for _, m := range []map[uint64]cachedTile{s.fresh, s.flushing} {
+		for id, t := range m {
+			if t.data == nil {
+				continue
+			}
+			if z, x, y := pmtiles.TileIDToZXY(id); s.base == nil || !s.base.HasTile(z, x, y) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// ServeHTTP handles GET /{z}/{x}/{y} with an optional file extension on y.
+// Empty tiles return 204 No Content; tiles outside the range return 404.
+// If-None-Match and If-Modified-Since are honoured with 304 Not Modified.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	z, x, y, ok := parseTilePath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.renderer.InRange(z, x, y) {
+		http.NotFound(w, r)
+		return
+	}
+
+	t, err := s.tile(z, x, y)
+	if err != nil {
+		log.Printf("Rendering tile z%d/%d/%d: %v", z, x, y, err)
+		http.Error(w, "render failed", http.StatusInternalServerError)
+		return
+	}
+	if t.data == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType(s.opts.Writer.TileFormat))
+	w.Header().Set("ETag", t.etag)
+	http.ServeContent(w, r, "", t.modified, bytes.NewReader(t.data))
+}
+
+// parseTilePath parses "/z/x/y" or "/z/x/y.ext".
+func parseTilePath(p string) (z, x, y int, ok bool) {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	if len(parts) != 3 {
+		return 0, 0, 0, false
+	}
+	if i := strings.IndexByte(parts[2], '.'); i >= 0 {
+		parts[2] = parts[2][:i]
+	}
+	var vals [3]int
+	for i, s := range parts {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			return 0, 0, 0, false
+		}
+		vals[i] = v
+	}
+	z, x, y = vals[0], vals[1], vals[2]
+	if z > 30 || x >= 1<<uint(z) || y >= 1<<uint(z) {
+		return 0, 0, 0, false
+	}
+	return z, x, y, true
+}
+
+// contentType maps a PMTiles tile type to its MIME type.
+func contentType(t uint8) string {
+	switch t {
+	case pmtiles.TileTypeJPEG:
+		return "image/jpeg"
+	case pmtiles.TileTypePNG:
+		return "image/png"
+	case pmtiles.TileTypeWebP:
+		return "image/webp"
+	case pmtiles.TileTypeMVT:
+		return "application/vnd.mapbox-vector-tile"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Flush merges the tiles rendered since the last flush into the output
+// archive: the tiles of the previous archive are copied as stored, the new
+// ones added. The archive is written to a temporary file next to the
+// output and renamed into place, so readers never observe a half-written
+// file. Afterwards the new tiles are read from the archive and dropped
+// from memory, as are empty results. No-op if no tile was rendered.
+func (s *Server) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	// Move the fresh tiles aside so rendering continues during the flush;
+	// they are served from flushing until the new archive is in place.
+	s.mu.Lock()
+	if !s.dirty {
+		s.fresh = make(map[uint64]cachedTile)
+		s.freshBytes = 0
+		s.mu.Unlock()
+		return nil
+	}
+	snapshot := s.fresh
+	s.flushing = snapshot
+	s.fresh = make(map[uint64]cachedTile)
+	s.freshBytes = 0
+	s.dirty = false
+	generatedAt := s.generatedAt
+	s.mu.Unlock()
+
+	s.baseMu.RLock()
+	base := s.base
+	s.baseMu.RUnlock()
+	updatedAt := time.Now().UTC().Truncate(time.Second)
+	n, err := s.writeArchive(base, snapshot, generatedAt, updatedAt)
+	var reader *pmtiles.Reader
+	if err == nil {
+		reader, err = pmtiles.OpenReader(s.opts.OutputPath)
+	}
+	if err != nil {
+		// Keep the tiles for the next flush; ones rendered again in the
+		// meantime are newer.
+		s.mu.Lock()
+		for id, t := range snapshot {
+			if _, ok := s.fresh[id]; !ok {
+				s.fresh[id] = t
+				s.freshBytes += int64(len(t.data)) + emptyTileBytes
+			}
+		}
+		s.flushing = nil
+		s.dirty = true
+		s.mu.Unlock()
+		return err
+	}
+	s.setBase(reader, updatedAt)
+	s.mu.Lock()
+	s.flushing = nil
+	s.mu.Unlock()
+
+	if s.opts.Verbose {
+		log.Printf("Flushed %d new tiles (%d in total) → %s", n, reader.NumTiles(), s.opts.OutputPath)
+	}
+	return nil
+}
+
+// writeArchive writes the output archive from the tiles of base that are
+// not in tiles, copied as stored, and the non-empty tiles. Returns the
+// number of tiles written from tiles.
+func (s *Server) writeArchive(base *pmtiles.Reader, tiles map[uint64]cachedTile, generatedAt, updatedAt time.Time) (int, error) {
+	// Finalize writes next to the output and renames, so readers of the
+	// archive always see the previous or the new flush, never a mix.
+	opts := s.opts.Writer
+	opts.GeneratedAt = generatedAt
+	opts.UpdatedAt = updatedAt
+	if opts.TempDir == "" {
+		opts.TempDir = filepath.Dir(s.opts.OutputPath)
+	}
+	writer, err := pmtiles.NewWriter(s.opts.OutputPath, opts)
+	if err != nil {
+		return 0, err
+	}
+	if base != nil {
+		h := base.Header()
+		err := base.StreamZoomRefs(int(h.MinZoom), int(h.MaxZoom), func(z, x, y int, offset uint64, data []byte) error {
+			if _, ok := tiles[pmtiles.ZXYToTileID(z, x, y)]; ok {
+				return nil
+			}
+			return writer.CopyTile(z, x, y, offset, data)
+		})
+		if err != nil {
+			writer.Abort()
+			return 0, fmt.Errorf("copying cached tiles: %w", err)
+		}
+	}
+	n := 0
+	for id, t := range tiles {
+		if t.data == nil {
+			continue
+		}
+		z, x, y := pmtiles.TileIDToZXY(id)
+		if err := writer.WriteTile(z, x, y, t.data); err != nil {
+			writer.Abort()
+			return 0, err
+		}
+		n++
+	}
+	if err := writer.Finalize(); err != nil {
+		return 0, fmt.Errorf("replacing %s: %w", s.opts.OutputPath, err)
+	}
+	return n, nil
+}
+
+// Run serves tiles on addr until ctx is cancelled, flushing every
+// FlushInterval and once more on shutdown.
+func (s *Server) Run(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: s}
+
+	errCh := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+
+	var tick <-chan time.Time
+	if s.opts.FlushInterval > 0 {
+		ticker := time.NewTicker(s.opts.FlushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case err := <-errCh:
+			if err != nil {
+				return err
+			}
+			return s.Flush()
+		case <-tick:
+			if err := s.Flush(); err != nil {
+				log.Printf("WARNING: flush failed: %v", err)
+			}
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			srv.Shutdown(shutdownCtx)
+			cancel()
+			return s.Flush()
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package serve
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+)
+
+// fakeRenderer renders tiles at zoom 0-2; tiles with x == 0 are empty.
+type fakeRenderer struct {
+	renders atomic.Int64
+}
+
+func (f *fakeRenderer) InRange(z, x, y int) bool { return z <= 2 }
+
+func (f *fakeRenderer) RenderTile(z, x, y int) ([]byte, error) {
+	f.renders.Add(1)
+	if x == 0 {
+		return nil, nil
+	}
+	return []byte{byte(z), byte(x), byte(y)}, nil
+}
+
+func newTestServer(t *testing.T) (*Server, *fakeRenderer, string) {
+	t.Helper()
+	r := &fakeRenderer{}
+	out := filepath.Join(t.TempDir(), "cache.pmtiles")
+	s := New(r, Options{
+		OutputPath: out,
+		Writer: pmtiles.WriterOptions{
+			MinZoom:    0,
+			MaxZoom:    2,
+			TileFormat: pmtiles.TileTypePNG,
+		},
+	})
+	return s, r, out
+}
+
+func TestParseTilePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		z, x, y int
+		ok      bool
+	}{
+		{"/1/0/1", 1, 0, 1, true},
+		{"/2/3/1.png", 2, 3, 1, true},
+		{"/1/2/0", 0, 0, 0, false}, // x out of range for z1
+		{"/1/0", 0, 0, 0, false},
+		{"/a/b/c", 0, 0, 0, false},
+		{"/-1/0/0", 0, 0, 0, false},
+	}
+	for _, tc := range tests {
+		z, x, y, ok := parseTilePath(tc.path)
+		if ok != tc.ok || (ok && (z != tc.z || x != tc.x || y != tc.y)) {
+			t.Errorf("parseTilePath(%q) = %d,%d,%d,%v; want %d,%d,%d,%v",
+				tc.path, z, x, y, ok, tc.z, tc.x, tc.y, tc.ok)
+		}
+	}
+}
+
+func TestServeHTTP_StatusCodes(t *testing.T) {
+	s, _, _ := newTestServer(t)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/1/1/0.png", http.StatusOK},
+		{"/1/0/0.png", http.StatusNoContent},
+		{"/3/1/1.png", http.StatusNotFound},
+		{"/nope", http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.want {
+			t.Errorf("GET %s: status %d, want %d", tc.path, rec.Code, tc.want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/2/3/1", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", ct)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), []byte{2, 3, 1}) {
+		t.Errorf("body = %v, want [2 3 1]", rec.Body.Bytes())
+	}
+}
+
+func TestTile_CachesRenders(t *testing.T) {
+	s, r, _ := newTestServer(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Tile(2, 1, 1); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	s.Tile(2, 0, 1) // empty
+	s.Tile(2, 0, 1)
+
+	if got := r.renders.Load(); got != 2 {
+		t.Errorf("renders = %d, want 2 (one per distinct tile)", got)
+	}
+	if got := s.NumTiles(); got != 1 {
+		t.Errorf("NumTiles = %d, want 1", got)
+	}
+}
+
+func TestFlushAndLoad(t *testing.T) {
+	s, _, out := newTestServer(t)
+	s.Tile(1, 1, 0)
+	s.Tile(2, 3, 2)
+	s.Tile(2, 0, 0) // empty, not persisted
+
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	reader, err := pmtiles.OpenReader(out)
+	if err != nil {
+		t.Fatalf("OpenReader: %v", err)
+	}
+	if n := reader.NumTiles(); n != 2 {
+		t.Errorf("archive has %d tiles, want 2", n)
+	}
+	if len(s.fresh) != 0 {
+		t.Errorf("%d tiles still in memory after Flush, want 0", len(s.fresh))
+	}
+	data, err := reader.ReadTile(2, 3, 2)
+	if err != nil || !bytes.Equal(data, []byte{2, 3, 2}) {
+		t.Errorf("ReadTile(2,3,2) = %v, %v", data, err)
+	}
+	reader.Close()
+
+	// A new server seeded from the archive serves without re-rendering.
+	s2, r2, _ := newTestServer(t)
+	n, err := s2.Load(out)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer s2.Close()
+	if n != 2 {
+		t.Errorf("Load returned %d tiles, want 2", n)
+	}
+	if data, _ := s2.Tile(1, 1, 0); !bytes.Equal(data, []byte{1, 1, 0}) {
+		t.Errorf("Tile(1,1,0) = %v after Load", data)
+	}
+	if got := r2.renders.Load(); got != 0 {
+		t.Errorf("renders after Load = %d, want 0", got)
+	}
+}
+
+func TestLoad_MissingArchive(t *testing.T) {
+	s, _, out := newTestServer(t)
+	n, err := s.Load(out)
+	if err != nil || n != 0 {
+		t.Errorf("Load(missing) = %d, %v; want 0, nil", n, err)
+	}
+}
+
+func TestServeHTTP_ConditionalRequests(t *testing.T) {
+	s, _, _ := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/2/3/1.png", nil))
+	etag := rec.Header().Get("ETag")
+	lastModified := rec.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("ETag = %q, Last-Modified = %q; want both set", etag, lastModified)
+	}
+
+	tests := []struct {
+		name, header, value string
+		want                int
+	}{
+		{"matching etag", "If-None-Match", etag, http.StatusNotModified},
+		{"other etag", "If-None-Match", `"0000000000000000"`, http.StatusOK},
+		{"not modified since", "If-Modified-Since", lastModified, http.StatusNotModified},
+		{"modified since", "If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT", http.StatusOK},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/2/3/1.png", nil)
+		req.Header.Set(tc.header, tc.value)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+
+	// Different tiles have different ETags.
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/2/3/2.png", nil))
+	if other := rec.Header().Get("ETag"); other == etag {
+		t.Errorf("tiles 2/3/1 and 2/3/2 share ETag %s", etag)
+	}
+}
+
+func TestFlushAndLoad_Timestamps(t *testing.T) {
+	s, _, out := newTestServer(t)
+	generated := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.generatedAt = generated
+	s.Tile(1, 1, 0)
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	reader, err := pmtiles.OpenReader(out)
+	if err != nil {
+		t.Fatalf("OpenReader: %v", err)
+	}
+	meta, err := reader.ReadMetadata()
+	reader.Close()
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if got := meta["generated_at"]; got != "2024-05-01T12:00:00Z" {
+		t.Errorf("generated_at = %v, want 2024-05-01T12:00:00Z", got)
+	}
+	updated := metadataTime(meta, "updated_at")
+	if updated.Before(generated) {
+		t.Errorf("updated_at = %v, want a flush time", meta["updated_at"])
+	}
+
+	// A reloaded server keeps generated_at and dates tiles by updated_at.
+	s2, _, _ := newTestServer(t)
+	if _, err := s2.Load(out); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	defer s2.Close()
+	if !s2.generatedAt.Equal(generated) {
+		t.Errorf("generatedAt after Load = %v, want %v", s2.generatedAt, generated)
+	}
+	rec := httptest.NewRecorder()
+	s2.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/1/1/0.png", nil))
+	if got, want := rec.Header().Get("Last-Modified"), updated.Format(http.TimeFormat); got != want {
+		t.Errorf("Last-Modified = %q, want %q", got, want)
+	}
+}
+
+func TestFlush_MergesIntoArchive(t *testing.T) {
+	s, r, out := newTestServer(t)
+	defer s.Close()
+	s.Tile(1, 1, 0)
+	if err := s.Flush(); err != nil {
+		t.Fatalf("first Flush: %v", err)
+	}
+	s.Tile(2, 3, 2)
+	if err := s.Flush(); err != nil {
+		t.Fatalf("second Flush: %v", err)
+	}
+
+	// The first flush's tile is read back from the archive, not rendered.
+	if data, _ := s.Tile(1, 1, 0); !bytes.Equal(data, []byte{1, 1, 0}) {
+		t.Errorf("Tile(1,1,0) = %v after two flushes", data)
+	}
+	if got := r.renders.Load(); got != 2 {
+		t.Errorf("renders = %d, want 2", got)
+	}
+	if got := s.NumTiles(); got != 2 {
+		t.Errorf("NumTiles = %d, want 2", got)
+	}
+
+	reader, err := pmtiles.OpenReader(out)
+	if err != nil {
+		t.Fatalf("OpenReader: %v", err)
+	}
+	defer reader.Close()
+	for _, c := range [][3]int{{1, 1, 0}, {2, 3, 2}} {
+		if data, _ := reader.ReadTile(c[0], c[1], c[2]); !bytes.Equal(data, []byte{byte(c[0]), byte(c[1]), byte(c[2])}) {
+			t.Errorf("archive tile %v = %v", c, data)
+		}
+	}
+	if n := reader.NumTiles(); n != 2 {
+		t.Errorf("archive has %d tiles, want 2", n)
+	}
+}
+
+func TestTile_FlushesWhenCacheFull(t *testing.T) {
+	r := &fakeRenderer{}
+	out := filepath.Join(t.TempDir(), "cache.pmtiles")
+	s := New(r, Options{
+		OutputPath: out,
+		CacheBytes: 2 * emptyTileBytes,
+		Writer:     pmtiles.WriterOptions{MaxZoom: 2, TileFormat: pmtiles.TileTypePNG},
+	})
+	defer s.Close()
+	s.Tile(2, 1, 1)
+	s.Tile(2, 2, 1) // over CacheBytes: flushed in the background
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.NumTiles() != 2 || s.inMemory() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("no early flush: %d tiles, %d in memory", s.NumTiles(), s.inMemory())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	reader, err := pmtiles.OpenReader(out)
+	if err != nil {
+		t.Fatalf("OpenReader: %v", err)
+	}
+	defer reader.Close()
+	if n := reader.NumTiles(); n != 2 {
+		t.Errorf("archive has %d tiles, want 2", n)
+	}
+}
+
+// inMemory returns the number of tiles rendered since the last flush.
+func (s *Server) inMemory() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.fresh) + len(s.flushing)
+}
@@ -0,0 +1,144 @@
+// Package shard splits a run into several archives (--shard) that can be
+// generated independently: concurrently in one process, or one per machine
+// with --shard-index. Shards are zoom bands, each optionally cut into a
+// grid of regions, and no tile belongs to two of them, so pmtransform
+// --merge recombines them by copying tiles.
+//
+// A band is rendered from the sources at its own max zoom, like a run with
+// that zoom range would be, instead of downsampled from the band above.
+// Grid cells are aligned to the tiles of the band's min zoom, so every tile
+// of the band has all its children in the same cell and the pyramid inside
+// a cell is complete.
+package shard
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+)
+
+// Band is one item of a --shard spec: a zoom range, cut into a grid of
+// Cols × Rows cells at MinZoom.
+type Band struct {
+	MinZoom int
+	MaxZoom int // -1: up to the run's max zoom
+	Cols    int
+	Rows    int
+}
+
+// Shard is one archive of a sharded run.
+type Shard struct {
+	Name    string // "z8-14", or "z8-14-c1r0" for grid cell column 1, row 0
+	MinZoom int
+	MaxZoom int
+	Bounds  cog.Bounds // the run's bounds clipped to the grid cell
+}
+
+// Output returns the archive path of the shard for a run writing output:
+// <output>-<name>.pmtiles.
+func (s Shard) Output(output string) string {
+	return strings.TrimSuffix(output, ".pmtiles") + "-" + s.Name + ".pmtiles"
+}
+
+// Parse parses a --shard spec: comma-separated zoom bands "MIN-MAX", each
+// optionally followed by "@COLSxROWS" to cut it into a grid, e.g.
+// "0-7,8-14@4x4". The last band may leave MAX out ("8-") to run up to the
+// max zoom.
+func Parse(spec string) ([]Band, error) {
+	var bands []Band
+	items := strings.Split(spec, ",")
+	for i, item := range items {
+		item = strings.TrimSpace(item)
+		b := Band{Cols: 1, Rows: 1}
+		zooms, grid, hasGrid := strings.Cut(item, "@")
+		lo, hi, ok := strings.Cut(zooms, "-")
+		if !ok {
+			return nil, fmt.Errorf("%q: want a zoom range MIN-MAX", item)
+		}
+		var err error
+		if b.MinZoom, err = strconv.Atoi(lo); err != nil || b.MinZoom < 0 {
+			return nil, fmt.Errorf("%q: invalid min zoom %q", item, lo)
+		}
+		b.MaxZoom = -1
+		if hi != "" {
+			if b.MaxZoom, err = strconv.Atoi(hi); err != nil || b.MaxZoom < b.MinZoom {
+				return nil, fmt.Errorf("%q: invalid max zoom %q", item, hi)
+			}
+		} else if i != len(items)-1 {
+			return nil, fmt.Errorf("%q: only the last band may leave out its max zoom", item)
+		}
+		if hasGrid {
+			cols, rows, ok := strings.Cut(grid, "x")
+			if !ok {
+				return nil, fmt.Errorf("%q: want a grid COLSxROWS, e.g. 4x4", item)
+			}
+			if b.Cols, err = strconv.Atoi(cols); err != nil || b.Cols < 1 {
+				return nil, fmt.Errorf("%q: invalid grid columns %q", item, cols)
+			}
+			if b.Rows, err = strconv.Atoi(rows); err != nil || b.Rows < 1 {
+				return nil, fmt.Errorf("%q: invalid grid rows %q", item, rows)
+			}
+		}
+		bands = append(bands, b)
+	}
+	return bands, nil
+}
+
+// Plan returns the shards of bands for a run over zooms minZoom..maxZoom
+// and bounds. The bands must cover the zoom range in ascending order,
+// without gaps or overlaps. A grid is never finer than the tiles of its
+// band's min zoom: a 4x4 grid over a band starting where the bounds span
+// 2×3 tiles becomes 2x3.
+func Plan(bands []Band, minZoom, maxZoom int, b cog.Bounds) ([]Shard, error) {
+	var shards []Shard
+	next := minZoom
+	for _, band := range bands {
+		if band.MaxZoom < 0 {
+			band.MaxZoom = maxZoom
+		}
+		if band.MinZoom != next || band.MaxZoom < band.MinZoom {
+			return nil, fmt.Errorf("zoom bands must cover zoom %d-%d in ascending order without gaps or overlaps; band %d-%d does not follow",
+				minZoom, maxZoom, band.MinZoom, band.MaxZoom)
+		}
+		next = band.MaxZoom + 1
+
+		name := fmt.Sprintf("z%d-%d", band.MinZoom, band.MaxZoom)
+		if band.Cols*band.Rows == 1 {
+			shards = append(shards, Shard{Name: name, MinZoom: band.MinZoom, MaxZoom: band.MaxZoom, Bounds: b})
+			continue
+		}
+		if b.MinLon > b.MaxLon {
+			return nil, fmt.Errorf("band %s: grids do not support bounds across the antimeridian", name)
+		}
+		z := band.MinZoom
+		minX, minY, maxX, maxY := coord.TileRange(z, b.MinLon, b.MinLat, b.MaxLon, b.MaxLat, coord.EdgeExclusive)
+		nx, ny := maxX-minX+1, maxY-minY+1
+		cols, rows := min(band.Cols, nx), min(band.Rows, ny)
+		for r := 0; r < rows; r++ {
+			y0, y1 := minY+r*ny/rows, minY+(r+1)*ny/rows-1
+			for c := 0; c < cols; c++ {
+				x0, x1 := minX+c*nx/cols, minX+(c+1)*nx/cols-1
+				west, _, _, north := coord.TileBounds(z, x0, y0)
+				_, south, east, _ := coord.TileBounds(z, x1, y1)
+				shards = append(shards, Shard{
+					Name:    fmt.Sprintf("%s-c%dr%d", name, c, r),
+					MinZoom: band.MinZoom,
+					MaxZoom: band.MaxZoom,
+					Bounds: cog.Bounds{
+						MinLon: max(west, b.MinLon),
+						MinLat: max(south, b.MinLat),
+						MaxLon: min(east, b.MaxLon),
+						MaxLat: min(north, b.MaxLat),
+					},
+				})
+			}
+		}
+	}
+	if next != maxZoom+1 {
+		return nil, fmt.Errorf("zoom bands end at zoom %d, but the run goes up to zoom %d", next-1, maxZoom)
+	}
+	return shards, nil
+}
@@ -0,0 +1,86 @@
+package shard
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+)
+
+func TestParse(t *testing.T) {
+	got, err := Parse("0-7, 8-14@4x2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Band{{0, 7, 1, 1}, {8, 14, 4, 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse = %+v, want %+v", got, want)
+	}
+	if got, err := Parse("5-@2x2"); err != nil || got[0].MaxZoom != -1 {
+		t.Errorf("Parse open band = %+v, %v; want max zoom -1", got, err)
+	}
+
+	for _, spec := range []string{"", "7", "8-5", "a-5", "0--1", "0-,6-9", "0-5@4", "0-5@0x2", "0-5@2x"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	b := cog.Bounds{MinLon: 5.9, MinLat: 45.8, MaxLon: 10.5, MaxLat: 47.8}
+
+	shards, err := Plan([]Band{{0, 7, 1, 1}, {8, 11, 3, 2}}, 0, 11, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shards) != 7 {
+		t.Fatalf("%d shards, want 1 band + 3x2 cells", len(shards))
+	}
+	if s := shards[0]; s.Name != "z0-7" || s.Bounds != b || s.Output("/out/ch.pmtiles") != "/out/ch-z0-7.pmtiles" {
+		t.Errorf("band shard %+v, output %s", s, s.Output("/out/ch.pmtiles"))
+	}
+	if shards[6].Name != "z8-11-c2r1" {
+		t.Errorf("last cell %q, want z8-11-c2r1", shards[6].Name)
+	}
+
+	// The cells split every zoom of the band: each tile of the run is in
+	// exactly one cell.
+	for z := 8; z <= 11; z++ {
+		owner := make(map[[3]int]string)
+		for _, s := range shards[1:] {
+			for _, tl := range coord.TilesInBounds(z, s.Bounds.MinLon, s.Bounds.MinLat, s.Bounds.MaxLon, s.Bounds.MaxLat) {
+				if prev, ok := owner[tl]; ok {
+					t.Fatalf("tile %v in %s and %s", tl, prev, s.Name)
+				}
+				owner[tl] = s.Name
+			}
+		}
+		if all := coord.TilesInBounds(z, b.MinLon, b.MinLat, b.MaxLon, b.MaxLat); len(owner) != len(all) {
+			t.Errorf("zoom %d: cells hold %d tiles, the run %d", z, len(owner), len(all))
+		}
+	}
+
+	// A grid finer than the min zoom's tiles is clamped to them.
+	shards, err = Plan([]Band{{2, -1, 4, 4}}, 2, 5, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shards) != 1 || shards[0].MaxZoom != 5 {
+		t.Errorf("shards %+v, want one cell up to zoom 5", shards)
+	}
+
+	for _, bands := range [][]Band{
+		{{0, 5, 1, 1}},                  // stops short
+		{{0, 5, 1, 1}, {7, 11, 1, 1}},   // gap
+		{{0, 8, 1, 1}, {8, 11, 1, 1}},   // overlap
+		{{1, 11, 1, 1}},                 // starts late
+		{{8, 11, 1, 1}, {0, 7, 1, 1}},   // descending
+		{{0, 11, 1, 1}, {12, 14, 1, 1}}, // beyond the max zoom
+	} {
+		if _, err := Plan(bands, 0, 11, b); err == nil {
+			t.Errorf("Plan(%+v) succeeded, want an error", bands)
+		}
+	}
+}
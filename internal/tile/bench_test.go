@@ -6,6 +6,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
 	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
 )
 
@@ -623,7 +624,7 @@ func BenchmarkDownsample_TerrariumChildren_Bilinear(b *testing.B) {
 	b.ResetTimer()
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		downsampleTileTerrarium(tl, tr, bl, br, tileSize, ResamplingBilinear)
+		downsampleTileTerrarium(tl, tr, bl, br, tileSize, ResamplingBilinear, encode.Terrarium)
 	}
 }
 
@@ -638,7 +639,7 @@ func BenchmarkDownsample_TerrariumChildren_Lanczos(b *testing.B) {
 	b.ResetTimer()
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		downsampleTileTerrarium(tl, tr, bl, br, tileSize, ResamplingLanczos)
+		downsampleTileTerrarium(tl, tr, bl, br, tileSize, ResamplingLanczos, encode.Terrarium)
 	}
 }
 
@@ -653,7 +654,7 @@ func BenchmarkDownsample_TerrariumChildren_Nearest(b *testing.B) {
 	b.ResetTimer()
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		downsampleTileTerrarium(tl, tr, bl, br, tileSize, ResamplingNearest)
+		downsampleTileTerrarium(tl, tr, bl, br, tileSize, ResamplingNearest, encode.Terrarium)
 	}
 }
 
@@ -705,6 +706,35 @@ func BenchmarkBicubicDirect(b *testing.B) {
 	_ = sink
 }
 
+// --- sampling benchmarks ---
+
+// benchmarkSample measures one kernel sampling a 2×2 grid of cached YCbCr
+// tiles (JPEG COGs) with the worker's scratch. Reports allocations, which
+// must stay at 0 (see TestSampleTileSource_NoAllocs).
+func benchmarkSample(b *testing.B, mode Resampling) {
+	tile := allocTestTiles()["ycbcr"]
+	src := &cog.Reader{}
+	cache := cog.NewTileCache(16)
+	for row := 0; row < 2; row++ {
+		for col := 0; col < 2; col++ {
+			cache.Put(src.ID(), 0, col, row, tile)
+		}
+	}
+	ts := tileSource{reader: src, imgW: 128, imgH: 128, tileW: 64, tileH: 64, footprint: 5}
+	var scratch sampleScratch
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := allocTestPoints[i%len(allocTestPoints)]
+		sampleTileSource(&ts, p[0], p[1], cache, mode, nil, &scratch)
+	}
+}
+
+func BenchmarkSample_Bilinear(b *testing.B) { benchmarkSample(b, ResamplingBilinear) }
+func BenchmarkSample_Bicubic(b *testing.B)  { benchmarkSample(b, ResamplingBicubic) }
+func BenchmarkSample_Lanczos(b *testing.B)  { benchmarkSample(b, ResamplingLanczos) }
+func BenchmarkSample_Mode(b *testing.B)     { benchmarkSample(b, ResamplingMode) }
+
 // --- RGBA pool benchmarks ---
 
 // BenchmarkGetPutRGBA measures pool throughput for 256×256 images.
@@ -0,0 +1,269 @@
+package tile
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+	"github.com/pspoerri/geotiff2pmtiles/tileorder"
+)
+
+const (
+	// budgetSamplesPerZoom is the number of tiles rendered per zoom level to
+	// estimate encoded sizes. Samples are spread evenly along the Hilbert
+	// curve so they cover the whole extent, including empty edge regions.
+	budgetSamplesPerZoom = 16
+
+	// budgetQualityStep is the quality decrement per planning step.
+	budgetQualityStep = 5
+
+	// MinBudgetQuality is the lowest quality PlanQuality will choose.
+	// Below this JPEG/WebP artifacts dominate and size savings flatten out.
+	MinBudgetQuality = 10
+)
+
+// QualityPlan is the per-zoom quality selection produced by PlanQuality.
+type QualityPlan struct {
+	Quality        map[int]int   // chosen quality per zoom level
+	EstimatedBytes map[int]int64 // estimated encoded bytes per zoom at the chosen quality
+	TotalBytes     int64         // sum of EstimatedBytes
+	Fits           bool          // false when the target is not reachable even at MinBudgetQuality
+}
+
+// Encoders builds one encoder per zoom level from the plan, suitable for
+// Config.ZoomEncoders.
+func (p QualityPlan) Encoders(format string) (map[int]encode.Encoder, error) {
+	encs := make(map[int]encode.Encoder, len(p.Quality))
+	for z, q := range p.Quality {
+		enc, err := encode.NewEncoder(format, q)
+		if err != nil {
+			return nil, err
+		}
+		encs[z] = enc
+	}
+	return encs, nil
+}
+
+// Zooms returns the planned zoom levels in ascending order.
+func (p QualityPlan) Zooms() []int {
+	zooms := make([]int, 0, len(p.Quality))
+	for z := range p.Quality {
+		zooms = append(zooms, z)
+	}
+	sort.Ints(zooms)
+	return zooms
+}
+
+// PlanQuality chooses a JPEG/WebP quality per zoom level so that the
+// estimated archive size stays within targetBytes.
+//
+// For every zoom level a small set of representative tiles is rendered
+// directly from the sources and encoded at each candidate quality (from
+// maxQuality down to MinBudgetQuality in budgetQualityStep steps). The mean
+// encoded size times the tile count gives the per-zoom estimate; empty and
+// uniform samples count as zero bytes since they are skipped or
+// deduplicated by the writer.
+//
+// Starting with every zoom at maxQuality, the planner repeatedly lowers the
+// quality of the zoom level where one step saves the most bytes. Since the
+// maximum zoom holds roughly three quarters of all tiles, it is degraded
+// first and overview levels keep their quality as long as possible.
+func PlanQuality(cfg Config, sources []*cog.Reader, format string, maxQuality int, targetBytes int64) (QualityPlan, error) {
+	if len(sources) == 0 {
+		return QualityPlan{}, fmt.Errorf("no source files")
+	}
+	if format != "jpeg" && format != "webp" {
+		return QualityPlan{}, fmt.Errorf("target size requires a lossy format (jpeg, webp), got %q", format)
+	}
+	if cfg.IsTerrarium {
+		return QualityPlan{}, fmt.Errorf("target size is not supported for terrarium output")
+	}
+	if cfg.Hillshade != nil {
+		return QualityPlan{}, fmt.Errorf("target size is not supported for hillshade output")
+	}
+	if cfg.ColorMap != nil {
+		return QualityPlan{}, fmt.Errorf("target size is not supported with a color map")
+	}
+	if targetBytes <= 0 {
+		return QualityPlan{}, fmt.Errorf("target size must be positive")
+	}
+	if maxQuality < MinBudgetQuality {
+		maxQuality = MinBudgetQuality
+	}
+
+	proj, err := sources[0].Projection()
+	if err != nil {
+		return QualityPlan{}, err
+	}
+
+	// Candidate qualities, highest first.
+	var qualities []int
+	for q := maxQuality; q > MinBudgetQuality; q -= budgetQualityStep {
+		qualities = append(qualities, q)
+	}
+	qualities = append(qualities, MinBudgetQuality)
+
+	encoders := make([]encode.Encoder, len(qualities))
+	for i, q := range qualities {
+		enc, err := encode.NewEncoder(format, q)
+		if err != nil {
+			return QualityPlan{}, err
+		}
+		encoders[i] = cfg.outputEncoder(enc)
+	}
+
+	// Pick sample tiles per zoom, evenly spaced along the Hilbert curve.
+	type sampleJob struct {
+		z, x, y int
+	}
+	var jobs []sampleJob
+	tileCounts := make(map[int]int)
+	for z := cfg.MinZoom; z <= cfg.MaxZoom; z++ {
+		tiles := coord.TilesInBounds(z,
+			cfg.Bounds.MinLon, cfg.Bounds.MinLat,
+			cfg.Bounds.MaxLon, cfg.Bounds.MaxLat)
+		tileCounts[z] = len(tiles)
+		if len(tiles) == 0 {
+			continue
+		}
+		tileorder.Sort(tiles, tileorder.Hilbert)
+		n := budgetSamplesPerZoom
+		if n > len(tiles) {
+			n = len(tiles)
+		}
+		for i := 0; i < n; i++ {
+			t := tiles[(2*i+1)*len(tiles)/(2*n)]
+			jobs = append(jobs, sampleJob{t[0], t[1], t[2]})
+		}
+	}
+
+	// sampleBytes[z][qi] accumulates encoded bytes over all samples at zoom z.
+	sampleBytes := make(map[int][]int64)
+	sampleCount := make(map[int]int)
+	for z := range tileCounts {
+		sampleBytes[z] = make([]int64, len(qualities))
+	}
+
+	cogCache := cog.NewTileCache(cfg.sourceCacheSize())
+	luts := buildGammaLUTs(cfg.ResamplingGamma)
+
+	nWorkers := cfg.Concurrency
+	if nWorkers < 1 {
+		nWorkers = 1
+	}
+	if nWorkers > len(jobs) {
+		nWorkers = len(jobs)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errCh  = make(chan error, nWorkers)
+		jobsCh = make(chan sampleJob, len(jobs))
+	)
+	for _, j := range jobs {
+		jobsCh <- j
+	}
+	close(jobsCh)
+
+	for w := 0; w < nWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			srcInfos := buildSourceInfos(sources, cfg.backfill(), proj, !cfg.InputOrder)
+			scratch := new(sampleScratch)
+			sizes := make([]int64, len(qualities))
+			for j := range jobsCh {
+				for i := range sizes {
+					sizes[i] = 0
+				}
+				img := renderTile(j.z, j.x, j.y, cfg.TileSize, srcInfos, proj, nil, scratch, cogCache, cfg.Resampling, luts)
+				if img != nil {
+					if cfg.FillColor != nil {
+						applyFillColorTransform(img, *cfg.FillColor)
+					}
+					td := newTileData(img, cfg.TileSize)
+					if !td.IsUniform() {
+						for i, enc := range encoders {
+							data, err := enc.Encode(td.AsImage())
+							if err != nil {
+								select {
+								case errCh <- fmt.Errorf("encoding sample tile z%d/%d/%d: %w", j.z, j.x, j.y, err):
+								default:
+								}
+								td.Release()
+								return
+							}
+							sizes[i] = int64(len(data))
+						}
+					}
+					td.Release()
+				}
+
+				mu.Lock()
+				for i, s := range sizes {
+					sampleBytes[j.z][i] += s
+				}
+				sampleCount[j.z]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case err := <-errCh:
+		return QualityPlan{}, err
+	default:
+	}
+
+	// estimate[z][qi] is the projected byte total of zoom z at quality qi.
+	estimate := make(map[int][]int64)
+	for z, n := range sampleCount {
+		est := make([]int64, len(qualities))
+		for i, b := range sampleBytes[z] {
+			est[i] = b * int64(tileCounts[z]) / int64(n)
+		}
+		estimate[z] = est
+	}
+
+	// Greedy descent: lower the zoom whose next quality step saves the most.
+	level := make(map[int]int, len(estimate))
+	var total int64
+	for z, est := range estimate {
+		level[z] = 0
+		total += est[0]
+	}
+	for total > targetBytes {
+		bestZ, bestSave := -1, int64(0)
+		for z, est := range estimate {
+			i := level[z]
+			if i+1 >= len(est) {
+				continue
+			}
+			if save := est[i] - est[i+1]; save > bestSave || (save == bestSave && z > bestZ) {
+				bestZ, bestSave = z, save
+			}
+		}
+		if bestZ < 0 || bestSave <= 0 {
+			break
+		}
+		level[bestZ]++
+		total -= bestSave
+	}
+
+	plan := QualityPlan{
+		Quality:        make(map[int]int, len(level)),
+		EstimatedBytes: make(map[int]int64, len(level)),
+		TotalBytes:     total,
+		Fits:           total <= targetBytes,
+	}
+	for z, i := range level {
+		plan.Quality[z] = qualities[i]
+		plan.EstimatedBytes[z] = estimate[z][i]
+	}
+	return plan, nil
+}
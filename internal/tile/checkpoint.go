@@ -0,0 +1,98 @@
+package tile
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/checkpoint"
+)
+
+// Checkpoint configures checkpoints of a run (Config.Checkpoint). After
+// every finished zoom level, the tile store holding that level and,
+// through SaveWriter, the writer's state are saved to Dir and recorded
+// with checkpoint.Save. A run given the recorded state as Resume continues
+// below its zoom level.
+//
+// Only level-by-level runs of a single layer have such a point: in a
+// pipelined run the levels below are under way before a level finishes,
+// and its tiles are deleted as their parents consume them.
+type Checkpoint struct {
+	Dir string
+	// Settings is recorded in the state; callers compare it with the
+	// run's own before resuming.
+	Settings string
+	// SaveWriter saves the writer's state to path
+	// (pmtiles.Writer.Checkpoint). No tiles are written meanwhile.
+	SaveWriter func(path string) error
+	// Resume, when set, is the state to continue from (checkpoint.Load).
+	// The writer must have been restored from the same state.
+	Resume *checkpoint.State
+}
+
+// saveCheckpoint saves the state after zoom z: the stores holding z's
+// tiles, the writer, and then the state naming them, which replaces the
+// previous level's.
+func (p *pass) saveCheckpoint(z int, stores []*DiskTileStore) error {
+	ck := p.cfg.Checkpoint
+	if err := os.MkdirAll(ck.Dir, 0o755); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	prefix := fmt.Sprintf("z%02d", z)
+	state := checkpoint.State{Settings: ck.Settings, Zoom: z, Writer: prefix + ".writer"}
+	for i, st := range stores {
+		name := fmt.Sprintf("%s-store%d", prefix, i)
+		if err := st.SaveCheckpoint(filepath.Join(ck.Dir, name)); err != nil {
+			return fmt.Errorf("checkpoint: %w", err)
+		}
+		state.Stores = append(state.Stores, name)
+	}
+	for _, g := range p.layers {
+		state.Counts = append(state.Counts, checkpoint.Counts{
+			Tiles:   g.tileCount.Load(),
+			Empty:   g.emptyCount.Load(),
+			Uniform: g.uniformCount.Load(),
+			Gray:    g.grayCount.Load(),
+			Bytes:   g.totalBytes.Load(),
+		})
+	}
+	if err := ck.SaveWriter(filepath.Join(ck.Dir, state.Writer)); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	if err := checkpoint.Save(ck.Dir, state); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	if p.cfg.Verbose {
+		log.Printf("Zoom %d: checkpoint saved to %s", z, ck.Dir)
+	}
+	return nil
+}
+
+// resumeStores loads the stores of the checkpoint's zoom level and the
+// tile counters up to it.
+func (p *pass) resumeStores(s *checkpoint.State) ([]*DiskTileStore, error) {
+	if s.Zoom < p.cfg.MinZoom || s.Zoom > p.cfg.MaxZoom {
+		return nil, fmt.Errorf("checkpoint at zoom %d is outside zoom range %d-%d", s.Zoom, p.cfg.MinZoom, p.cfg.MaxZoom)
+	}
+	if len(s.Stores) != len(p.layers) || len(s.Counts) != len(p.layers) {
+		return nil, fmt.Errorf("checkpoint has %d layer(s), run has %d", len(s.Stores), len(p.layers))
+	}
+	stores := make([]*DiskTileStore, 0, len(p.layers))
+	for i, g := range p.layers {
+		st, err := LoadDiskTileStore(g.storeConfig(64, false), filepath.Join(p.cfg.Checkpoint.Dir, s.Stores[i]))
+		if err != nil {
+			closeStores(stores)
+			return nil, err
+		}
+		stores = append(stores, st)
+
+		c := s.Counts[i]
+		g.tileCount.Store(c.Tiles)
+		g.emptyCount.Store(c.Empty)
+		g.uniformCount.Store(c.Uniform)
+		g.grayCount.Store(c.Gray)
+		g.totalBytes.Store(c.Bytes)
+	}
+	return stores, nil
+}
@@ -0,0 +1,227 @@
+package tile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+)
+
+// ColorMap colors the values of single-band sources (--color-map): class
+// codes of a land-cover raster, NDVI, or any other quantity that has no
+// colors of its own. Instead of being read as image bands, each max-zoom
+// pixel is sampled as a value and written as its color. Lower zooms are
+// downsampled from the colored tiles like imagery.
+type ColorMap struct {
+	Stops []ColorStop // sorted by Value, at least one
+	// Discrete gives each stop's color to the values from it up to the
+	// next stop (values below the first stop are transparent) instead of
+	// interpolating between the stops.
+	Discrete bool
+}
+
+// ColorStop is the color of one value of a ColorMap.
+type ColorStop struct {
+	Value float64
+	Color color.RGBA
+}
+
+// LoadColorMap reads a --color-map value: inline JSON (starting with '[')
+// or the path of a file, which holds JSON or a GDAL color-relief text file
+// (see ParseColorMap).
+func LoadColorMap(spec string) (*ColorMap, error) {
+	if s := strings.TrimSpace(spec); strings.HasPrefix(s, "[") {
+		return ParseColorMap([]byte(s))
+	}
+	data, err := os.ReadFile(spec)
+	if err != nil {
+		return nil, err
+	}
+	return ParseColorMap(data)
+}
+
+// ParseColorMap parses a color map in one of two forms:
+//
+//   - JSON: an array of [value, color] pairs, the color a "#rrggbb" or
+//     "#rrggbbaa" string, e.g. [[0, "#d7191c"], [0.5, "#ffffbf"], [1, "#1a9641"]].
+//   - A GDAL color-relief file (as for gdaldem color-relief): one
+//     "value R G B [A]" entry per line, separated by spaces, tabs, commas,
+//     or colons, with '#' comments. "nv" (nodata) entries are accepted and
+//     ignored: nodata pixels stay transparent. Percentages are not
+//     supported, since the value range is not known before rendering.
+//
+// Stops may come in any order.
+func ParseColorMap(data []byte) (*ColorMap, error) {
+	var stops []ColorStop
+	var err error
+	if t := bytes.TrimSpace(data); len(t) > 0 && t[0] == '[' {
+		stops, err = parseColorMapJSON(t)
+	} else {
+		stops, err = parseColorRelief(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(stops) == 0 {
+		return nil, fmt.Errorf("color map has no entries")
+	}
+	sort.SliceStable(stops, func(i, j int) bool { return stops[i].Value < stops[j].Value })
+	return &ColorMap{Stops: stops}, nil
+}
+
+func parseColorMapJSON(data []byte) ([]ColorStop, error) {
+	var pairs [][2]json.RawMessage
+	if err := json.Unmarshal(data, &pairs); err != nil {
+		return nil, fmt.Errorf("color map JSON: %v (want [[value, \"#rrggbb\"], ...])", err)
+	}
+	stops := make([]ColorStop, len(pairs))
+	for i, p := range pairs {
+		var hex string
+		if err := json.Unmarshal(p[0], &stops[i].Value); err != nil {
+			return nil, fmt.Errorf("color map entry %d: value %s is not a number", i+1, p[0])
+		}
+		if err := json.Unmarshal(p[1], &hex); err != nil {
+			return nil, fmt.Errorf("color map entry %d: color %s is not a string", i+1, p[1])
+		}
+		c, err := parseHexColor(hex)
+		if err != nil {
+			return nil, fmt.Errorf("color map entry %d: %v", i+1, err)
+		}
+		stops[i].Color = c
+	}
+	return stops, nil
+}
+
+// parseHexColor parses "#rrggbb" or "#rrggbbaa".
+func parseHexColor(s string) (color.RGBA, error) {
+	h := strings.TrimPrefix(s, "#")
+	if len(h) != 6 && len(h) != 8 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q (want #rrggbb or #rrggbbaa)", s)
+	}
+	v, err := strconv.ParseUint(h, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q (want #rrggbb or #rrggbbaa)", s)
+	}
+	if len(h) == 6 {
+		v = v<<8 | 0xff
+	}
+	return color.RGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
+
+func parseColorRelief(data []byte) ([]ColorStop, error) {
+	var stops []ColorStop
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		text, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.FieldsFunc(text, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ',' || r == ':'
+		})
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.EqualFold(fields[0], "nv") {
+			continue
+		}
+		if strings.HasSuffix(fields[0], "%") {
+			return nil, fmt.Errorf("line %d: percentage values are not supported (use absolute values)", line)
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid value %q", line, fields[0])
+		}
+		if len(fields) != 4 && len(fields) != 5 {
+			return nil, fmt.Errorf("line %d: want \"value R G B [A]\", got %d fields", line, len(fields))
+		}
+		var ch [4]uint8
+		ch[3] = 255
+		for i, f := range fields[1:] {
+			n, err := strconv.Atoi(f)
+			if err != nil || n < 0 || n > 255 {
+				return nil, fmt.Errorf("line %d: invalid color component %q (want 0-255)", line, f)
+			}
+			ch[i] = uint8(n)
+		}
+		stops = append(stops, ColorStop{Value: v, Color: color.RGBA{ch[0], ch[1], ch[2], ch[3]}})
+	}
+	return stops, sc.Err()
+}
+
+// Color returns the color of value v: transparent for NaN (nodata). In
+// interpolated maps, values outside the stops take the color of the
+// nearest end.
+func (m *ColorMap) Color(v float64) color.RGBA {
+	if math.IsNaN(v) {
+		return color.RGBA{}
+	}
+	stops := m.Stops
+	// i is the first stop above v.
+	i := sort.Search(len(stops), func(i int) bool { return stops[i].Value > v })
+	if m.Discrete {
+		if i == 0 {
+			return color.RGBA{}
+		}
+		return stops[i-1].Color
+	}
+	if i == 0 {
+		return stops[0].Color
+	}
+	if i == len(stops) {
+		return stops[len(stops)-1].Color
+	}
+	a, b := stops[i-1], stops[i]
+	t := (v - a.Value) / (b.Value - a.Value)
+	mix := func(x, y uint8) uint8 { return uint8(math.Round(float64(x) + t*(float64(y)-float64(x)))) }
+	return color.RGBA{mix(a.Color.R, b.Color.R), mix(a.Color.G, b.Color.G), mix(a.Color.B, b.Color.B), mix(a.Color.A, b.Color.A)}
+}
+
+// renderTileColorMap renders tile z/tx/ty by sampling the first band of
+// the sources as values and coloring them with cm. Colors with alpha are
+// stored premultiplied, as image.RGBA requires. Returns nil when the tile
+// has no data.
+func renderTileColorMap(z, tx, ty, tileSize int, srcInfos []sourceInfo, proj coord.Projection, scratch *sampleScratch, cache *cog.FloatTileCache, mode Resampling, cm *ColorMap) *image.RGBA {
+	_, midLat, _, _ := coord.TileBounds(z, tx, ty)
+	outputResMeters := coord.ResolutionAtLat(midLat, z, tileSize)
+	outputResCRS := coord.MetersToPixelSizeCRS(outputResMeters, proj.EPSG(), midLat)
+
+	if scratch == nil {
+		scratch = new(sampleScratch)
+	}
+	defer scratch.release()
+
+	minX, minY, maxX, maxY := paddedTileCRSBounds(z, tx, ty, tileSize, 0, proj)
+	tileSrcs := prepareTileSources(scratch.srcs, srcInfos, outputResCRS, minX, minY, maxX, maxY)
+	scratch.srcs = tileSrcs
+	if len(tileSrcs) == 0 {
+		return nil
+	}
+
+	grid := renderElevations(z, tx, ty, tileSize, 0, tileSrcs, proj, scratch, cache, mode)
+	img := GetRGBA(tileSize, tileSize)
+	hasData := false
+	for i, v := range grid {
+		c := cm.Color(v)
+		if c.A == 0 {
+			continue
+		}
+		if c.A < 255 {
+			c = color.RGBAModel.Convert(color.NRGBA(c)).(color.RGBA)
+		}
+		img.SetRGBA(i%tileSize, i/tileSize, c)
+		hasData = true
+	}
+	if !hasData {
+		PutRGBA(img)
+		return nil
+	}
+	return img
+}
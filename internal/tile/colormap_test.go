@@ -0,0 +1,90 @@
+package tile
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestParseColorMap_ColorRelief(t *testing.T) {
+	cm, err := ParseColorMap([]byte(`# gdaldem color-relief
+nv 0 0 0 0
+1000 255,255,255
+0:0:128:0 200
+500	10 20 30
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ColorStop{
+		{0, color.RGBA{0, 128, 0, 200}},
+		{500, color.RGBA{10, 20, 30, 255}},
+		{1000, color.RGBA{255, 255, 255, 255}},
+	}
+	if len(cm.Stops) != len(want) {
+		t.Fatalf("stops = %v, want %v", cm.Stops, want)
+	}
+	for i := range want {
+		if cm.Stops[i] != want[i] {
+			t.Errorf("stop %d = %v, want %v", i, cm.Stops[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{"", "# only a comment\n", "50% 1 2 3", "x 1 2 3", "1 2 3", "1 2 3 256"} {
+		if _, err := ParseColorMap([]byte(bad)); err == nil {
+			t.Errorf("ParseColorMap(%q): expected error", bad)
+		}
+	}
+}
+
+func TestParseColorMap_JSON(t *testing.T) {
+	cm, err := ParseColorMap([]byte(` [[1, "#1a9641"], [-1, "#d7191c80"]]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm.Stops[0] != (ColorStop{-1, color.RGBA{0xd7, 0x19, 0x1c, 0x80}}) || cm.Stops[1] != (ColorStop{1, color.RGBA{0x1a, 0x96, 0x41, 255}}) {
+		t.Errorf("stops = %v", cm.Stops)
+	}
+	for _, bad := range []string{`[[1, "#12345"]]`, `[["a", "#123456"]]`, `[[1, 2]]`, `[]`} {
+		if _, err := ParseColorMap([]byte(bad)); err == nil {
+			t.Errorf("ParseColorMap(%q): expected error", bad)
+		}
+	}
+}
+
+func TestColorMap_Color(t *testing.T) {
+	black, white := color.RGBA{0, 0, 0, 255}, color.RGBA{255, 255, 255, 255}
+	cm := &ColorMap{Stops: []ColorStop{{0, black}, {100, white}}}
+	for _, tt := range []struct {
+		v    float64
+		want color.RGBA
+	}{
+		{-5, black}, // clamped
+		{0, black},
+		{50, color.RGBA{128, 128, 128, 255}},
+		{100, white},
+		{200, white},
+		{math.NaN(), color.RGBA{}},
+	} {
+		if got := cm.Color(tt.v); got != tt.want {
+			t.Errorf("interpolated Color(%v) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+
+	cm.Discrete = true
+	for _, tt := range []struct {
+		v    float64
+		want color.RGBA
+	}{
+		{-5, color.RGBA{}}, // below the first stop
+		{0, black},
+		{99.9, black},
+		{100, white},
+		{200, white},
+		{math.NaN(), color.RGBA{}},
+	} {
+		if got := cm.Color(tt.v); got != tt.want {
+			t.Errorf("discrete Color(%v) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package tile
+
+import (
+	"image"
+	"image/draw"
+)
+
+// bayer4 is the 4×4 ordered dither matrix. Tile sizes are multiples of 4,
+// so the pattern continues seamlessly across tile edges.
+var bayer4 = [4][4]uint32{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// imageToRGBA converts a decoded tile to *image.RGBA. 16-bit images, such
+// as 16-bit PNG tiles written by other tools, are rounded to 8 bits, or
+// with dither ordered-dithered, instead of cut to their high byte as
+// draw.Draw does, which is off by up to a step and bands smooth gradients.
+func imageToRGBA(img image.Image, dither bool) *image.RGBA {
+	switch src := img.(type) {
+	case *image.RGBA:
+		return src
+	case *image.NRGBA64:
+		return rgba16To8(src.Bounds(), src.Pix, src.Stride, false, dither)
+	case *image.RGBA64:
+		return rgba16To8(src.Bounds(), src.Pix, src.Stride, true, dither)
+	case *image.Gray16:
+		return gray16To8(src, dither)
+	}
+	bounds := img.Bounds()
+	rgba := GetRGBA(bounds.Dx(), bounds.Dy())
+	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// quantize16 maps a 16-bit sample to 8 bits: rounded, or with dither
+// offset by the ordered dither threshold of pixel (x, y).
+func quantize16(v uint32, x, y int, dither bool) uint8 {
+	if !dither {
+		return uint8((v*255 + 32767) / 65535)
+	}
+	t := (2*bayer4[y&3][x&3] + 1) * 65535 / 32 // threshold in (0, 1) steps
+	return uint8(min((v*255+t)/65535, 255))
+}
+
+// rgba16To8 converts big-endian 16-bit RGBA samples, premultiplied or not,
+// to a pooled 8-bit premultiplied *image.RGBA. Alpha is rounded, never
+// dithered, and colour is kept at or below it.
+func rgba16To8(b image.Rectangle, pix []uint8, stride int, premultiplied, dither bool) *image.RGBA {
+	dst := GetRGBA(b.Dx(), b.Dy())
+	for y := 0; y < b.Dy(); y++ {
+		row := pix[y*stride:]
+		out := dst.Pix[y*dst.Stride:]
+		for x := 0; x < b.Dx(); x++ {
+			s := row[x*8 : x*8+8]
+			a := uint32(s[6])<<8 | uint32(s[7])
+			a8 := quantize16(a, x, y, false)
+			for c := 0; c < 3; c++ {
+				v := uint32(s[2*c])<<8 | uint32(s[2*c+1])
+				if !premultiplied {
+					v = v * a / 65535
+				}
+				out[x*4+c] = min(quantize16(v, x, y, dither), a8)
+			}
+			out[x*4+3] = a8
+		}
+	}
+	return dst
+}
+
+// gray16To8 converts a 16-bit gray image to a pooled opaque *image.RGBA.
+func gray16To8(src *image.Gray16, dither bool) *image.RGBA {
+	b := src.Bounds()
+	dst := GetRGBA(b.Dx(), b.Dy())
+	for y := 0; y < b.Dy(); y++ {
+		row := src.Pix[y*src.Stride:]
+		out := dst.Pix[y*dst.Stride:]
+		for x := 0; x < b.Dx(); x++ {
+			g := quantize16(uint32(row[2*x])<<8|uint32(row[2*x+1]), x, y, dither)
+			out[x*4], out[x*4+1], out[x*4+2], out[x*4+3] = g, g, g, 255
+		}
+	}
+	return dst
+}
@@ -0,0 +1,67 @@
+package tile
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestImageToRGBA_Rounds16Bit(t *testing.T) {
+	// Keeping the high byte, as draw.Draw does, maps 0x00ff (0.99 steps)
+	// to 0 and 0xfe00 (253.01 steps) to 254; rounding gives 1 and 253.
+	img := image.NewNRGBA64(image.Rect(0, 0, 4, 4))
+	img.SetNRGBA64(0, 0, color.NRGBA64{R: 0x00ff, G: 0xfe00, B: 0xffff, A: 0xffff})
+	// Half transparent: colour is premultiplied at 16 bits before rounding.
+	img.SetNRGBA64(1, 0, color.NRGBA64{R: 0xffff, G: 0x8000, A: 0x8000})
+
+	rgba := imageToRGBA(img, false)
+	if got, want := rgba.RGBAAt(0, 0), (color.RGBA{1, 253, 255, 255}); got != want {
+		t.Errorf("opaque pixel = %v, want %v", got, want)
+	}
+	if got, want := rgba.RGBAAt(1, 0), (color.RGBA{128, 64, 0, 128}); got != want {
+		t.Errorf("translucent pixel = %v, want %v", got, want)
+	}
+
+	gray := image.NewGray16(image.Rect(0, 0, 4, 4))
+	gray.SetGray16(0, 0, color.Gray16{Y: 0x00ff})
+	td := newTileData(imageToRGBA(gray, false), 4)
+	if td.gray == nil && !td.IsUniform() {
+		t.Error("16-bit gray tile was not kept gray")
+	}
+	if got := td.RGBAAt(0, 0); got != (color.RGBA{1, 1, 1, 255}) {
+		t.Errorf("gray pixel = %v, want 1", got)
+	}
+}
+
+func TestImageToRGBA_DitherKeepsMean(t *testing.T) {
+	// A level a quarter step above 100: rounding flattens it to 100, the
+	// dither spreads it so the tile mean stays at 100.25.
+	const size = 16
+	v := uint16((100*65535 + 65535/4) / 255)
+	img := image.NewRGBA64(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.SetRGBA64(x, y, color.RGBA64{R: v, G: v, B: v, A: 0xffff})
+		}
+	}
+
+	mean := func(rgba *image.RGBA) float64 {
+		var sum int
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				p := rgba.RGBAAt(x, y)
+				if p.A != 255 || p.R < 100 || p.R > 101 {
+					t.Fatalf("pixel (%d,%d) = %v, want 100 or 101 opaque", x, y, p)
+				}
+				sum += int(p.R)
+			}
+		}
+		return float64(sum) / (size * size)
+	}
+	if got := mean(imageToRGBA(img, false)); got != 100 {
+		t.Errorf("rounded mean = %v, want 100", got)
+	}
+	if got := mean(imageToRGBA(img, true)); got != 100.25 {
+		t.Errorf("dithered mean = %v, want 100.25", got)
+	}
+}
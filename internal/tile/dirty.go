@@ -0,0 +1,98 @@
+package tile
+
+import (
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/tileorder"
+)
+
+// DirtySet tracks the tiles of a pyramid that must be regenerated. Marking
+// a tile marks its ancestors down to the set's minimum zoom as well, since
+// every parent is downsampled from its children: a run that patches a few
+// max-zoom tiles regenerates exactly the tiles those edits reach and
+// reuses the rest from the previous archive (Config.Previous).
+//
+// A DirtySet is not safe for concurrent marking; Contains may be called
+// concurrently once marking is done.
+type DirtySet struct {
+	minZoom int
+	tiles   map[[3]int]struct{}
+}
+
+// NewDirtySet returns an empty set whose marks propagate down to minZoom.
+func NewDirtySet(minZoom int) *DirtySet {
+	return &DirtySet{minZoom: minZoom, tiles: make(map[[3]int]struct{})}
+}
+
+// MinZoom returns the lowest zoom that marks propagate to.
+func (d *DirtySet) MinZoom() int { return d.minZoom }
+
+// Mark marks tile z/x/y and all its ancestors down to MinZoom. Tiles below
+// MinZoom are not tracked.
+func (d *DirtySet) Mark(z, x, y int) {
+	for ; z >= d.minZoom; z, x, y = z-1, x>>1, y>>1 {
+		t := [3]int{z, x, y}
+		if _, ok := d.tiles[t]; ok {
+			// Marked before, and its ancestors with it.
+			return
+		}
+		d.tiles[t] = struct{}{}
+	}
+}
+
+// MarkRegions marks the tiles intersecting any of regions at every zoom
+// from MinZoom to maxZoom (see RegionTiles). Parents are marked at their
+// own zoom too: a region on a tile edge can touch a parent that none of
+// its max-zoom tiles lie in.
+func (d *DirtySet) MarkRegions(maxZoom int, regions []cog.Bounds) {
+	if len(regions) == 0 {
+		return
+	}
+	for z := d.minZoom; z <= maxZoom; z++ {
+		for _, t := range RegionTiles(z, regions) {
+			d.Mark(t[0], t[1], t[2])
+		}
+	}
+}
+
+// Merge marks every tile of other in d.
+func (d *DirtySet) Merge(other *DirtySet) {
+	if other == nil {
+		return
+	}
+	for t := range other.tiles {
+		d.Mark(t[0], t[1], t[2])
+	}
+}
+
+// Contains reports whether tile z/x/y is marked. A nil set contains no
+// tiles.
+func (d *DirtySet) Contains(z, x, y int) bool {
+	if d == nil {
+		return false
+	}
+	_, ok := d.tiles[[3]int{z, x, y}]
+	return ok
+}
+
+// Len returns the number of marked tiles over all zooms.
+func (d *DirtySet) Len() int {
+	if d == nil {
+		return 0
+	}
+	return len(d.tiles)
+}
+
+// Tiles returns the marked tiles of zoom z in Hilbert order.
+func (d *DirtySet) Tiles(z int) [][3]int {
+	if d == nil {
+		return nil
+	}
+	var tiles [][3]int
+	for t := range d.tiles {
+		if t[0] == z {
+			tiles = append(tiles, t)
+		}
+	}
+	tileorder.Sort(tiles, tileorder.Hilbert)
+	return tiles
+}
@@ -0,0 +1,76 @@
+package tile
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+)
+
+func TestDirtySetMarksAncestors(t *testing.T) {
+	d := NewDirtySet(3)
+	d.Mark(6, 37, 21)
+	for _, c := range [][3]int{{6, 37, 21}, {5, 18, 10}, {4, 9, 5}, {3, 4, 2}} {
+		if !d.Contains(c[0], c[1], c[2]) {
+			t.Errorf("tile %v not marked", c)
+		}
+	}
+	if d.Contains(2, 2, 1) || d.Contains(6, 36, 21) || d.Len() != 4 {
+		t.Errorf("marked %d tiles, want the tile and its 3 ancestors down to zoom 3", d.Len())
+	}
+
+	// A sibling shares all ancestors: only the tile itself is added.
+	d.Mark(6, 36, 21)
+	if d.Len() != 5 {
+		t.Errorf("after marking a sibling: %d tiles, want 5", d.Len())
+	}
+	// A cousin adds its parent, then meets the shared grandparent.
+	d.Mark(6, 38, 21)
+	if d.Len() != 7 || !d.Contains(5, 19, 10) {
+		t.Errorf("after marking a cousin: %d tiles, want 7", d.Len())
+	}
+	// Tiles below the minimum zoom are not tracked.
+	d.Mark(2, 0, 0)
+	if d.Contains(2, 0, 0) || d.Len() != 7 {
+		t.Errorf("tile below the minimum zoom was marked")
+	}
+}
+
+func TestDirtySetTiles(t *testing.T) {
+	d := NewDirtySet(0)
+	for _, c := range [][3]int{{2, 3, 3}, {2, 0, 0}, {2, 1, 1}, {2, 3, 0}} {
+		d.Mark(c[0], c[1], c[2])
+	}
+	want := [][3]int{{2, 0, 0}, {2, 1, 1}, {2, 3, 3}, {2, 3, 0}}
+	if got := d.Tiles(2); !reflect.DeepEqual(got, want) {
+		t.Errorf("Tiles(2) = %v, want %v (Hilbert order)", got, want)
+	}
+	if got := d.Tiles(1); len(got) != 3 {
+		t.Errorf("Tiles(1) = %v, want the three distinct parents", got)
+	}
+	if got := d.Tiles(0); !reflect.DeepEqual(got, [][3]int{{0, 0, 0}}) {
+		t.Errorf("Tiles(0) = %v", got)
+	}
+
+	var nilSet *DirtySet
+	if nilSet.Contains(0, 0, 0) || nilSet.Len() != 0 || nilSet.Tiles(0) != nil {
+		t.Error("nil set is not empty")
+	}
+}
+
+func TestDirtySetMarkRegions(t *testing.T) {
+	regions := []cog.Bounds{{MinLon: 8, MinLat: 46, MaxLon: 9, MaxLat: 47}}
+	d := NewDirtySet(4)
+	d.MarkRegions(10, regions)
+	for z := 4; z <= 10; z++ {
+		if got, want := d.Tiles(z), RegionTiles(z, regions); !reflect.DeepEqual(got, want) {
+			t.Errorf("zoom %d: %d tiles, want the %d region tiles", z, len(got), len(want))
+		}
+	}
+
+	m := NewDirtySet(2)
+	m.Merge(d)
+	if m.Len() != d.Len()+2 || !m.Contains(2, 2, 1) {
+		t.Errorf("Merge into a lower minimum zoom: %d tiles, want %d", m.Len(), d.Len()+2)
+	}
+}
@@ -1,26 +1,35 @@
 package tile
 
 import (
+	"bufio"
 	"encoding/binary"
 	"fmt"
-	"image"
-	"image/draw"
+	"hash/crc32"
+	"image/color"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"sync/atomic"
 
-	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+	"github.com/pspoerri/geotiff2pmtiles/internal/checkpoint"
+	"github.com/pspoerri/geotiff2pmtiles/internal/prealloc"
 )
 
-// diskEntry records the location of an encoded tile on disk.
+// diskEntry records the location of an encoded tile on disk and the CRC32
+// (Castagnoli) of its bytes, checked on every read-back.
 type diskEntry struct {
 	offset int64
 	length int32
+	crc    uint32
 }
 
+// spillCRC is the CRC32 table for spill records. Castagnoli has hardware
+// support on amd64 and arm64, so checksumming costs far less than the decode.
+var spillCRC = crc32.MakeTable(crc32.Castagnoli)
+
 // Estimated per-entry Go map overhead including bucket metadata, hash table
 // load factor (~6.5 entries/bucket), and key/value storage. These are
 // conservative estimates to keep the memory limit honest.
@@ -55,24 +64,37 @@ type ioRequest struct {
 //   - Get() checks uniform tiles first, then decodes in-memory encoded
 //     bytes, then falls back to reading from disk.
 //
+// The stored form is up to DiskTileStoreConfig.Codec: with a RawCodec the
+// store keeps serialized pixels instead, several times larger, and Get()
+// copies them back instead of decoding.
+//
 // The continuous I/O design means disk writes are spread evenly over the
-// processing time rather than occurring in large blocking flushes.
+// processing time rather than occurring in large blocking flushes. With
+// DiskTileStoreConfig.SpillOnPressure, tiles are instead only spilled once
+// memory fills up, oldest first, and Delete drops tiles that are no longer
+// needed before they ever reach the disk.
 //
 // The temp file is owned exclusively by the I/O goroutine for writing.
 // Readers access it via an atomic pointer (lock-free ReadAt), so file I/O
 // never contends with the map mutex.
+//
+// With DiskTileStoreConfig.Backing, the tiles are on disk already, in the
+// PMTiles writer's temp file: PutAt records where, and the store neither
+// keeps their bytes in memory nor writes them again.
 type DiskTileStore struct {
 	mu       sync.RWMutex
 	uniforms map[[3]int]*TileData // uniform tiles (tiny, never spilled)
-	encoded  map[[3]int][]byte    // encoded non-uniform tiles in memory
+	encoded  map[[3]int][]byte    // non-uniform tiles in memory, as stored by codec
 	index    map[[3]int]diskEntry // disk index (populated by I/O goroutine)
 	tileSize int
-	format   string // encoder format for decode path ("png", "jpeg", "webp", "terrarium")
+	codec    TileCodec // converts tiles to the bytes kept and spilled, and back
 
 	// Read-only file handle for Get(). Set once by ioLoop on first write,
 	// never reassigned. Readers use atomic load + ReadAt (pread, no locking).
-	readFile atomic.Pointer[os.File]
-	dir      string // directory for temp files
+	readFile    atomic.Pointer[os.File]
+	backing     io.ReaderAt // holds the bytes of tiles put with PutAt; see DiskTileStoreConfig.Backing
+	dir         string      // directory for temp files
+	spillExtent int64       // preallocation extent of the spill file
 
 	// Memory tracking.
 	memBytes    atomic.Int64 // estimated bytes of in-memory encoded tile data
@@ -80,19 +102,72 @@ type DiskTileStore struct {
 	memoryLimit int64        // max total memory before blocking Put(); 0 = no limit
 	spillMu     sync.Mutex   // protects memCond waits (separate from mu to avoid contention)
 	memCond     *sync.Cond   // signaled by ioLoop when memBytes decreases; nil when spilling is off
+	queued      atomic.Int64 // bytes of memBytes sent to ioLoop but not yet picked up
+
+	// SpillOnPressure mode: non-uniform keys in Put order that have not been
+	// sent to ioLoop yet (protected by mu). May contain deleted keys, which
+	// are skipped when popped.
+	lazy      bool
+	unspilled [][3]int
 
 	// Dedicated I/O goroutine.
 	ioCh      chan ioRequest // tiles to write to disk
 	ioWg      sync.WaitGroup // for Drain()
 	drainOnce sync.Once      // ensures Drain() is idempotent
 
-	// Stats (updated by I/O goroutine only, read after Drain).
-	totalDiskTiles int64 // tiles written to disk
-	totalDiskBytes int64 // total encoded bytes on disk
+	// Stats (read after Drain).
+	totalDiskTiles atomic.Int64 // tiles on disk
+	totalDiskBytes atomic.Int64 // total encoded bytes on disk
+
+	// First spill read failure (I/O error or checksum mismatch); see Err.
+	readErrOnce sync.Once
+	readErr     error
+	hasReadErr  atomic.Bool
+
+	// Optional decode-ahead pipeline (see EnablePrefetch).
+	pfMu      sync.Mutex
+	pfCond    *sync.Cond                // signaled when pending shrinks or on close
+	pending   map[[3]int]*prefetchEntry // registered by Prefetch, consumed by Get
+	pfJobs    chan prefetchJob          // decode jobs for prefetch workers
+	pfWg      sync.WaitGroup
+	pfMax     int  // max pending decoded tiles before Prefetch blocks
+	pfClosed  bool // set by Close; Prefetch becomes a no-op
+	pfEnabled atomic.Bool
 
 	verbose bool
 }
 
+// prefetchEntry is a tile being decoded ahead of its Get().
+// td is valid once done is closed.
+type prefetchEntry struct {
+	done chan struct{}
+	td   *TileData
+}
+
+// prefetchJob asks a prefetch worker to decode one or more tiles. When span
+// is non-empty, all tiles lie within that byte range of the spill file and
+// are fetched with a single read; otherwise each tile is loaded via load().
+type prefetchJob struct {
+	keys    [][3]int
+	entries []*prefetchEntry
+	locs    []diskEntry // per-key disk location (only with span)
+	span    diskEntry
+}
+
+// estSpillTileBytes is the assumed average size of an encoded tile (JPEG
+// imagery), used to size the in-memory map and the spill file extents.
+const estSpillTileBytes = 20 << 10
+
+const (
+	// readAheadMaxGap is the largest hole between two spilled tiles that is
+	// still read over (and discarded) to merge them into one read.
+	readAheadMaxGap = 64 << 10
+
+	// readAheadMaxSpan caps a merged read so a single prefetch job does not
+	// serialize too many decodes on one worker.
+	readAheadMaxSpan = 1 << 20
+)
+
 // DiskTileStoreConfig configures the disk-backed tile store.
 type DiskTileStoreConfig struct {
 	// InitialCapacity is the estimated number of tiles for map pre-allocation.
@@ -108,6 +183,26 @@ type DiskTileStoreConfig struct {
 	// Format is the encoder format name (e.g. "png", "jpeg", "webp", "terrarium").
 	// Required when MemoryLimitBytes > 0 so that tiles can be decoded on read-back.
 	Format string
+	// Codec converts non-uniform tiles to the bytes the store keeps and
+	// spills. nil means EncodedCodec of Format, TileSize, Dither and
+	// Premultiplied.
+	Codec TileCodec
+	// Backing, when set together with MemoryLimitBytes, holds the encoded
+	// bytes of the tiles put with PutAt (pmtiles.Writer.DataReader). They
+	// are spilled by recording their offset in it instead of being written
+	// to a spill file, so each tile is written to disk once. Tiles put with
+	// Put stay in memory. Ignored unless the codec is an EncodedCodec.
+	Backing io.ReaderAt
+	// Dither ordered-dithers 16-bit tiles (e.g. 16-bit PNGs stored as raw
+	// source bytes) to 8 bits on read-back instead of rounding them.
+	Dither bool
+	// Premultiplied decodes spilled tiles as storing premultiplied colors
+	// (encode.Premultiply).
+	Premultiplied bool
+	// SpillOnPressure spills tiles only once in-memory data exceeds half of
+	// MemoryLimitBytes, oldest first, instead of continuously. Suits stores
+	// whose tiles are mostly read and deleted soon after they are put.
+	SpillOnPressure bool
 	// Verbose enables logging of I/O events.
 	Verbose bool
 }
@@ -124,6 +219,11 @@ func NewDiskTileStore(cfg DiskTileStoreConfig) *DiskTileStore {
 	if dir == "" {
 		dir = os.TempDir()
 	}
+	codec := cfg.Codec
+	if codec == nil {
+		codec = EncodedCodec{Format: cfg.Format, TileSize: cfg.TileSize, Dither: cfg.Dither, Premultiplied: cfg.Premultiplied}
+	}
+	_, encoded := codec.(EncodedCodec)
 
 	// When disk spilling is enabled, the encoded map holds only a small
 	// working set (bounded by MemoryLimitBytes). Pre-allocating for the
@@ -132,10 +232,10 @@ func NewDiskTileStore(cfg DiskTileStoreConfig) *DiskTileStore {
 	encodedCap := cap
 	uniformCap := cap / 4
 	if cfg.MemoryLimitBytes > 0 {
-		// Estimate: average encoded tile is ~20 KB (JPEG). Pre-allocate
-		// for roughly the number of tiles that fit in the memory limit,
-		// capped at a reasonable size to avoid huge upfront allocations.
-		encodedCap = int(cfg.MemoryLimitBytes / (20 * 1024))
+		// Pre-allocate for roughly the number of tiles that fit in the
+		// memory limit (~20 KB each when encoded as JPEG), capped at a
+		// reasonable size to avoid huge upfront allocations.
+		encodedCap = int(cfg.MemoryLimitBytes / int64(codec.SizeHint()))
 		if encodedCap > 1_000_000 {
 			encodedCap = 1_000_000
 		}
@@ -150,14 +250,21 @@ func NewDiskTileStore(cfg DiskTileStoreConfig) *DiskTileStore {
 		encoded:  make(map[[3]int][]byte, encodedCap),
 		index:    make(map[[3]int]diskEntry),
 		tileSize: cfg.TileSize,
-		format:   cfg.Format,
+		codec:    codec,
 		dir:      dir,
 		verbose:  cfg.Verbose,
+
+		spillExtent: prealloc.ExtentFor(int64(cap) * int64(codec.SizeHint())),
 	}
 
 	// Start the dedicated I/O goroutine when disk spilling is enabled.
-	if cfg.MemoryLimitBytes > 0 && cfg.Format != "" {
+	// A store with a backing file has nothing to write.
+	canDecode := cfg.Format != "" || cfg.Codec != nil
+	if cfg.MemoryLimitBytes > 0 && canDecode && cfg.Backing != nil && encoded {
+		s.backing = cfg.Backing
+	} else if cfg.MemoryLimitBytes > 0 && canDecode {
 		s.memoryLimit = cfg.MemoryLimitBytes
+		s.lazy = cfg.SpillOnPressure
 		s.memCond = sync.NewCond(&s.spillMu)
 		s.ioCh = make(chan ioRequest, 256)
 		s.ioWg.Add(1)
@@ -174,7 +281,8 @@ func NewDiskTileStore(cfg DiskTileStoreConfig) *DiskTileStore {
 // The encoded parameter must contain the pre-encoded tile bytes for
 // non-uniform tiles (e.g., from the output encoder).
 // If disk spilling is enabled, non-uniform tiles are also sent to the
-// dedicated I/O goroutine for eventual eviction from memory.
+// dedicated I/O goroutine for eventual eviction from memory (right away,
+// or with SpillOnPressure once memory fills up).
 func (s *DiskTileStore) Put(z, x, y int, td *TileData, encoded []byte) {
 	key := [3]int{z, x, y}
 
@@ -187,17 +295,31 @@ func (s *DiskTileStore) Put(z, x, y int, td *TileData, encoded []byte) {
 		return
 	}
 
-	// Store encoded bytes in memory (much smaller than raw pixels:
-	// ~10-50 KB encoded vs 64-256 KB raw for a 256×256 tile).
+	// Store the codec's bytes in memory: by default the encoded ones,
+	// much smaller than raw pixels (~10-50 KB encoded vs 64-256 KB raw
+	// for a 256×256 tile).
+	encoded = s.codec.Marshal(td, encoded)
 	mem := int64(len(encoded))
+	spill := s.ioCh != nil && len(encoded) > 0
 	s.mu.Lock()
 	s.encoded[key] = encoded
+	if spill && s.lazy {
+		s.unspilled = append(s.unspilled, key)
+		if len(s.unspilled) > 2*len(s.encoded)+1024 {
+			s.compactUnspilled()
+		}
+	}
 	s.mu.Unlock()
 	s.memBytes.Add(mem)
 
 	// Send to I/O goroutine for eventual disk eviction (when enabled).
-	if s.ioCh != nil && len(encoded) > 0 {
-		s.ioCh <- ioRequest{key: key, encoded: encoded, memBytes: mem}
+	if spill {
+		if s.lazy {
+			s.spillOldest()
+		} else {
+			s.queued.Add(mem)
+			s.ioCh <- ioRequest{key: key, encoded: encoded, memBytes: mem}
+		}
 	}
 
 	// Block if the memory limit is exceeded, providing backpressure to
@@ -219,12 +341,134 @@ func (s *DiskTileStore) Put(z, x, y int, td *TileData, encoded []byte) {
 	}
 }
 
-// Get retrieves tile data. Checks uniform tiles first, then decodes in-memory
-// encoded bytes, then falls back to reading from disk.
+// PutAt stores a tile whose encoded bytes are at offset in the store's
+// backing file (DiskTileStoreConfig.Backing), where they were just written
+// to the archive. Only the location and checksum are kept; encoded is not
+// retained. Without a backing file, or for uniform tiles, it is Put.
+func (s *DiskTileStore) PutAt(z, x, y int, td *TileData, encoded []byte, offset int64) {
+	if s.backing == nil || td.IsUniform() || len(encoded) == 0 {
+		s.Put(z, x, y, td, encoded)
+		return
+	}
+	de := diskEntry{
+		offset: offset,
+		length: int32(len(encoded)),
+		crc:    crc32.Checksum(encoded, spillCRC),
+	}
+	s.mu.Lock()
+	s.index[[3]int{z, x, y}] = de
+	s.mu.Unlock()
+	s.mapOverhead.Add(mapOverheadIndex)
+	s.totalDiskTiles.Add(1)
+	s.totalDiskBytes.Add(int64(len(encoded)))
+}
+
+// spillReader returns the file spilled tiles are read from: the backing
+// file, the store's own spill file, or nil before anything was spilled.
+func (s *DiskTileStore) spillReader() io.ReaderAt {
+	if s.backing != nil {
+		return s.backing
+	}
+	if f := s.readFile.Load(); f != nil {
+		return f
+	}
+	return nil
+}
+
+// spillOldest sends the oldest unspilled tiles to the I/O goroutine until
+// the in-memory data not yet on its way to disk is below half the memory
+// limit. The other half leaves room for tiles being written while Put
+// blocks only above the full limit.
+func (s *DiskTileStore) spillOldest() {
+	for s.memBytes.Load()-s.queued.Load() > s.memoryLimit/2 {
+		var req ioRequest
+		found := false
+		s.mu.Lock()
+		for len(s.unspilled) > 0 && !found {
+			req.key = s.unspilled[0]
+			s.unspilled = s.unspilled[1:]
+			req.encoded, found = s.encoded[req.key]
+		}
+		s.mu.Unlock()
+		if !found {
+			return
+		}
+		req.memBytes = int64(len(req.encoded))
+		s.queued.Add(req.memBytes)
+		s.ioCh <- req
+	}
+}
+
+// compactUnspilled drops deleted keys from the unspilled queue so it stays
+// proportional to the tiles in memory. Caller must hold mu.
+func (s *DiskTileStore) compactUnspilled() {
+	live := s.unspilled[:0]
+	for _, k := range s.unspilled {
+		if _, ok := s.encoded[k]; ok {
+			live = append(live, k)
+		}
+	}
+	s.unspilled = live
+}
+
+// Delete removes a tile from the store and frees its memory; a pending
+// spill of it is skipped. Bytes already spilled stay in the temp file until
+// Close. Deleting an absent tile is a no-op. Must not be used for tiles
+// registered with Prefetch.
+func (s *DiskTileStore) Delete(z, x, y int) {
+	key := [3]int{z, x, y}
+	s.mu.Lock()
+	_, uniform := s.uniforms[key]
+	enc, inMem := s.encoded[key]
+	_, onDisk := s.index[key]
+	delete(s.uniforms, key)
+	delete(s.encoded, key)
+	delete(s.index, key)
+	s.mu.Unlock()
+
+	if uniform {
+		s.mapOverhead.Add(-mapOverheadUniform)
+	}
+	if onDisk {
+		s.mapOverhead.Add(-mapOverheadIndex)
+	}
+	if inMem {
+		s.memBytes.Add(-int64(len(enc)))
+		// Wake blocked Put() calls now that memory has been freed.
+		if s.memCond != nil {
+			s.spillMu.Lock()
+			s.memCond.Broadcast()
+			s.spillMu.Unlock()
+		}
+	}
+}
+
+// Get retrieves tile data. Tiles decoded ahead of time by Prefetch are
+// returned directly; otherwise checks uniform tiles first, then decodes
+// in-memory encoded bytes, then falls back to reading from disk.
 // Returns nil if the tile is not present anywhere.
 func (s *DiskTileStore) Get(z, x, y int) *TileData {
 	key := [3]int{z, x, y}
 
+	if s.pfEnabled.Load() {
+		s.pfMu.Lock()
+		e := s.pending[key]
+		s.pfMu.Unlock()
+		if e != nil {
+			<-e.done
+			s.pfMu.Lock()
+			delete(s.pending, key)
+			s.pfCond.Broadcast()
+			s.pfMu.Unlock()
+			return e.td
+		}
+	}
+
+	return s.load(key)
+}
+
+// load reads and decodes a tile without consulting the prefetch queue.
+func (s *DiskTileStore) load(key [3]int) *TileData {
 	// Single lock acquisition for all in-memory lookups.
 	s.mu.RLock()
 	td := s.uniforms[key]
@@ -239,7 +483,7 @@ func (s *DiskTileStore) Get(z, x, y int) *TileData {
 
 	// In-memory encoded tile: decode back to pixel data.
 	if enc != nil {
-		return s.decodeEncoded(enc)
+		return s.codec.Unmarshal(enc)
 	}
 
 	// Slow path: read encoded bytes from disk.
@@ -249,42 +493,260 @@ func (s *DiskTileStore) Get(z, x, y int) *TileData {
 
 	// Load the file handle (lock-free). ReadAt uses pread under the hood,
 	// so concurrent reads are safe without any mutex.
-	f := s.readFile.Load()
+	f := s.spillReader()
 	if f == nil {
 		return nil
 	}
 
 	buf := make([]byte, de.length)
-	_, err := f.ReadAt(buf, de.offset)
-	if err != nil {
+	if _, err := f.ReadAt(buf, de.offset); err != nil {
+		s.failRead(fmt.Errorf("reading spilled tile z%d/%d/%d: %w", key[0], key[1], key[2], err))
+		return nil
+	}
+	if err := de.verify(key, buf); err != nil {
+		s.failRead(err)
 		return nil
 	}
 
-	return s.decodeEncoded(buf)
+	return s.codec.Unmarshal(buf)
 }
 
-// decodeEncoded decodes encoded image bytes (from memory or disk) back to a TileData.
-func (s *DiskTileStore) decodeEncoded(data []byte) *TileData {
-	img, err := encode.DecodeImage(data, s.format)
-	if err != nil {
+// verify checks data read back for the record against its stored CRC.
+func (de diskEntry) verify(key [3]int, data []byte) error {
+	if got := crc32.Checksum(data, spillCRC); got != de.crc {
+		return fmt.Errorf("spilled tile z%d/%d/%d is corrupt (offset %d, %d bytes, crc32 %08x, want %08x); the spill disk may be failing",
+			key[0], key[1], key[2], de.offset, de.length, got, de.crc)
+	}
+	return nil
+}
+
+// failRead records the first spill read failure. Get returns nil for the
+// affected tile, so callers must check Err before trusting a missing child.
+func (s *DiskTileStore) failRead(err error) {
+	s.readErrOnce.Do(func() {
+		s.readErr = err
+		s.hasReadErr.Store(true)
+	})
+}
+
+// Err returns the first error from reading back a spilled tile (an I/O
+// error or a CRC mismatch), or nil. Once set, results of Get can no longer
+// be trusted: a corrupted child reads as missing.
+func (s *DiskTileStore) Err() error {
+	if !s.hasReadErr.Load() {
 		return nil
 	}
+	return s.readErr
+}
+
+// EnablePrefetch starts workers goroutines that decode tiles registered via
+// Prefetch ahead of their Get() calls, so decoding overlaps with the
+// caller's downsample/encode work instead of running on its goroutine.
+// maxPending bounds the number of decoded-but-unconsumed tiles (memory).
+// Must be called before concurrent use of Get/Prefetch; no-op if already
+// enabled.
+func (s *DiskTileStore) EnablePrefetch(workers, maxPending int) {
+	if s.pfEnabled.Load() {
+		return
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	if maxPending < 1 {
+		maxPending = 1
+	}
+	s.pfCond = sync.NewCond(&s.pfMu)
+	s.pending = make(map[[3]int]*prefetchEntry, maxPending)
+	s.pfMax = maxPending
+	s.pfJobs = make(chan prefetchJob, 2*maxPending)
+	for i := 0; i < workers; i++ {
+		s.pfWg.Add(1)
+		go func() {
+			defer s.pfWg.Done()
+			for job := range s.pfJobs {
+				s.runPrefetchJob(job)
+			}
+		}()
+	}
+	s.pfEnabled.Store(true)
+}
 
-	// Fast path: already RGBA.
-	if rgba, ok := img.(*image.RGBA); ok {
-		return newTileData(rgba, s.tileSize)
+// Prefetch schedules keys for background decoding. Each prefetched key must
+// be consumed by exactly one Get(); uniform and absent tiles are skipped
+// since Get() serves them without decoding.
+//
+// Blocks while more than maxPending decoded tiles are waiting to be
+// consumed, unless nothing is pending (so a single oversized batch can
+// never deadlock). Call Prefetch for a batch before handing that batch to
+// the workers that will Get() it. No-op when prefetching is not enabled.
+func (s *DiskTileStore) Prefetch(keys [][3]int) {
+	if !s.pfEnabled.Load() {
+		return
+	}
+
+	// Only tiles that need a decode are worth prefetching. Spilled tiles
+	// are collected with their disk locations so neighbouring records can
+	// be fetched with one read.
+	var mem [][3]int
+	var disk []spilledTile
+	s.mu.RLock()
+	for _, k := range keys {
+		if _, ok := s.uniforms[k]; ok {
+			continue
+		}
+		if _, ok := s.encoded[k]; ok {
+			mem = append(mem, k)
+		} else if de, ok := s.index[k]; ok {
+			disk = append(disk, spilledTile{k, de})
+		}
+	}
+	s.mu.RUnlock()
+	n := len(mem) + len(disk)
+	if n == 0 {
+		return
 	}
 
-	// Fast path: grayscale image.
-	if g, ok := img.(*image.Gray); ok {
-		return &TileData{gray: g, tileSize: s.tileSize}
+	s.pfMu.Lock()
+	defer s.pfMu.Unlock()
+	for !s.pfClosed && len(s.pending) > 0 && len(s.pending)+n > s.pfMax {
+		s.pfCond.Wait()
+	}
+	if s.pfClosed {
+		return
 	}
+	for _, k := range mem {
+		if e := s.register(k); e != nil {
+			s.pfJobs <- prefetchJob{keys: [][3]int{k}, entries: []*prefetchEntry{e}}
+		}
+	}
+	for _, span := range planReads(disk) {
+		job := prefetchJob{span: span.span}
+		for _, t := range span.tiles {
+			if e := s.register(t.key); e != nil {
+				job.keys = append(job.keys, t.key)
+				job.entries = append(job.entries, e)
+				job.locs = append(job.locs, t.loc)
+			}
+		}
+		if len(job.keys) > 0 {
+			s.pfJobs <- job
+		}
+	}
+}
 
-	// General case: convert to RGBA (handles NRGBA from PNG, YCbCr from JPEG, etc.).
-	bounds := img.Bounds()
-	rgba := GetRGBA(bounds.Dx(), bounds.Dy())
-	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
-	return newTileData(rgba, s.tileSize)
+// register adds a pending entry for key, or returns nil if one already
+// exists. Caller must hold pfMu.
+func (s *DiskTileStore) register(key [3]int) *prefetchEntry {
+	if _, ok := s.pending[key]; ok {
+		return nil
+	}
+	e := &prefetchEntry{done: make(chan struct{})}
+	s.pending[key] = e
+	return e
+}
+
+// runPrefetchJob decodes the tiles of job and publishes them to their
+// pending entries. A failed merged read, or a record in it that fails its
+// checksum, falls back to a per-tile load, which re-reads and re-checks it.
+func (s *DiskTileStore) runPrefetchJob(job prefetchJob) {
+	var buf []byte
+	if job.span.length > 0 {
+		if f := s.spillReader(); f != nil {
+			buf = make([]byte, job.span.length)
+			if _, err := f.ReadAt(buf, job.span.offset); err != nil {
+				buf = nil
+			}
+		}
+	}
+	for i, k := range job.keys {
+		e := job.entries[i]
+		var rec []byte
+		if buf != nil {
+			start := job.locs[i].offset - job.span.offset
+			rec = buf[start : start+int64(job.locs[i].length)]
+			if job.locs[i].verify(k, rec) != nil {
+				rec = nil
+			}
+		}
+		if rec != nil {
+			e.td = s.codec.Unmarshal(rec)
+		} else {
+			e.td = s.load(k)
+		}
+		close(e.done)
+	}
+}
+
+// spilledTile is a tile key with its location in the spill file.
+type spilledTile struct {
+	key [3]int
+	loc diskEntry
+}
+
+// readSpan is a contiguous byte range of the spill file covering tiles.
+type readSpan struct {
+	span  diskEntry
+	tiles []spilledTile
+}
+
+// planReads sorts spilled tiles by file offset and merges neighbours into
+// spans of at most readAheadMaxSpan bytes, bridging holes of up to
+// readAheadMaxGap bytes.
+//
+// Tiles are spilled in completion order, which only loosely follows the
+// Hilbert order of the batches, while the downsample phase wants the four
+// children of each parent together. Sorting a batch's children by offset
+// turns many small random reads into a few sequential ones.
+func planReads(tiles []spilledTile) []readSpan {
+	sort.Slice(tiles, func(i, j int) bool { return tiles[i].loc.offset < tiles[j].loc.offset })
+
+	var spans []readSpan
+	for _, t := range tiles {
+		if n := len(spans); n > 0 {
+			cur := &spans[n-1]
+			end := cur.span.offset + int64(cur.span.length)
+			newEnd := t.loc.offset + int64(t.loc.length)
+			if t.loc.offset-end <= readAheadMaxGap && newEnd-cur.span.offset <= readAheadMaxSpan {
+				if newEnd > end {
+					cur.span.length = int32(newEnd - cur.span.offset)
+				}
+				cur.tiles = append(cur.tiles, t)
+				continue
+			}
+		}
+		spans = append(spans, readSpan{
+			span:  diskEntry{offset: t.loc.offset, length: t.loc.length},
+			tiles: []spilledTile{t},
+		})
+	}
+	return spans
+}
+
+// stopPrefetch shuts down prefetch workers and releases unconsumed tiles.
+func (s *DiskTileStore) stopPrefetch() {
+	if !s.pfEnabled.Load() {
+		return
+	}
+	s.pfMu.Lock()
+	if s.pfClosed {
+		s.pfMu.Unlock()
+		return
+	}
+	s.pfClosed = true
+	close(s.pfJobs)
+	s.pfCond.Broadcast()
+	s.pfMu.Unlock()
+
+	s.pfWg.Wait()
+
+	s.pfMu.Lock()
+	for k, e := range s.pending {
+		if e.td != nil {
+			e.td.Release()
+		}
+		delete(s.pending, k)
+	}
+	s.pfMu.Unlock()
 }
 
 // ioLoop is the dedicated I/O goroutine that continuously writes encoded
@@ -296,14 +758,25 @@ func (s *DiskTileStore) decodeEncoded(data []byte) *TileData {
 //
 // Invariant: a non-uniform tile is always in either s.encoded or s.index
 // (or both during the brief window inside the critical section).
-// A Get() will always find it.
+// A Get() will always find it. Tiles deleted before they are written are
+// skipped, and tiles deleted while being written get no index entry.
 func (s *DiskTileStore) ioLoop() {
 	defer s.ioWg.Done()
 
-	var file *os.File // owned by this goroutine for sequential writes
-	var fileOff int64 // current write position (local, no sharing)
+	var file *os.File             // owned by this goroutine for sequential writes
+	var fileOff int64             // current write position (local, no sharing)
+	var alloc *prealloc.Allocator // reserves the file in extents ahead of fileOff
 
 	for req := range s.ioCh {
+		s.queued.Add(-req.memBytes)
+
+		s.mu.RLock()
+		_, live := s.encoded[req.key]
+		s.mu.RUnlock()
+		if !live {
+			continue // deleted; Delete already released its memory
+		}
+
 		// Lazily create the temp file on first write.
 		if file == nil {
 			f, err := os.CreateTemp(s.dir, "pmtiles-tilestore-*.tmp")
@@ -312,12 +785,14 @@ func (s *DiskTileStore) ioLoop() {
 				continue
 			}
 			file = f
+			alloc = prealloc.New(f, s.spillExtent)
 			s.readFile.Store(f) // publish for concurrent readers (lock-free)
 			if s.verbose {
 				log.Printf("Disk tile store: created temp file %s", f.Name())
 			}
 		}
 
+		alloc.Grow(fileOff + int64(len(req.encoded)))
 		n, err := file.Write(req.encoded)
 		if err != nil {
 			log.Printf("WARNING: disk tile store: write error: %v (tile stays in memory)", err)
@@ -327,18 +802,25 @@ func (s *DiskTileStore) ioLoop() {
 		// Add to disk index and evict from in-memory encoded map atomically.
 		// This ensures Get() always finds the tile in one place or the other.
 		s.mu.Lock()
-		s.index[req.key] = diskEntry{
-			offset: fileOff,
-			length: int32(n),
+		_, live = s.encoded[req.key]
+		if live {
+			s.index[req.key] = diskEntry{
+				offset: fileOff,
+				length: int32(n),
+				crc:    crc32.Checksum(req.encoded, spillCRC),
+			}
+			delete(s.encoded, req.key)
 		}
-		delete(s.encoded, req.key)
 		s.mu.Unlock()
 
 		fileOff += int64(n)
+		if !live {
+			continue // deleted while being written
+		}
 		s.memBytes.Add(-req.memBytes)
 		s.mapOverhead.Add(mapOverheadIndex)
-		s.totalDiskTiles++
-		s.totalDiskBytes += int64(n)
+		s.totalDiskTiles.Add(1)
+		s.totalDiskBytes.Add(int64(n))
 
 		// Wake blocked Put() calls now that memory has been freed.
 		if s.memCond != nil {
@@ -360,7 +842,7 @@ func (s *DiskTileStore) Drain() {
 		s.ioWg.Wait()
 		if s.verbose {
 			log.Printf("Disk tile store: drained (%d tiles, %.1f MB encoded on disk)",
-				s.totalDiskTiles, float64(s.totalDiskBytes)/(1024*1024))
+				s.totalDiskTiles.Load(), float64(s.totalDiskBytes.Load())/(1024*1024))
 		}
 	})
 }
@@ -388,6 +870,7 @@ func (s *DiskTileStore) MemoryBytes() int64 {
 // Safe to call after Drain() — the I/O goroutine has exited so the file
 // is no longer being written to.
 func (s *DiskTileStore) Close() {
+	s.stopPrefetch()
 	s.Drain()
 	if f := s.readFile.Swap(nil); f != nil {
 		name := f.Name()
@@ -404,11 +887,11 @@ func (s *DiskTileStore) Stats() string {
 		len(s.uniforms)+len(s.encoded), len(s.uniforms), len(s.encoded),
 		float64(s.memBytes.Load())/(1024*1024),
 		float64(s.mapOverhead.Load())/(1024*1024),
-		len(s.index), float64(s.totalDiskBytes)/(1024*1024))
+		len(s.index), float64(s.totalDiskBytes.Load())/(1024*1024))
 }
 
 // WriteIndexTo writes the disk index to a writer for debugging/checkpointing.
-// Format: count(uint32) + [key_z(int32) key_x(int32) key_y(int32) offset(int64) length(int32)] × count.
+// Format: count(uint32) + [key_z(int32) key_x(int32) key_y(int32) offset(int64) length(int32) crc32c(uint32)] × count.
 func (s *DiskTileStore) WriteIndexTo(w io.Writer) error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -419,13 +902,14 @@ func (s *DiskTileStore) WriteIndexTo(w io.Writer) error {
 		return err
 	}
 
-	entry := make([]byte, 4+4+4+8+4) // 24 bytes per entry
+	entry := make([]byte, 4+4+4+8+4+4) // 28 bytes per entry
 	for key, de := range s.index {
 		binary.LittleEndian.PutUint32(entry[0:4], uint32(key[0]))  // z
 		binary.LittleEndian.PutUint32(entry[4:8], uint32(key[1]))  // x
 		binary.LittleEndian.PutUint32(entry[8:12], uint32(key[2])) // y
 		binary.LittleEndian.PutUint64(entry[12:20], uint64(de.offset))
 		binary.LittleEndian.PutUint32(entry[20:24], uint32(de.length))
+		binary.LittleEndian.PutUint32(entry[24:28], de.crc)
 		if _, err := w.Write(entry); err != nil {
 			return err
 		}
@@ -433,6 +917,169 @@ func (s *DiskTileStore) WriteIndexTo(w io.Writer) error {
 	return nil
 }
 
+// readIndexFrom reads an index written by WriteIndexTo into s.
+func (s *DiskTileStore) readIndexFrom(r io.Reader) error {
+	buf := make([]byte, 28)
+	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+		return err
+	}
+	n := binary.LittleEndian.Uint32(buf[:4])
+	for i := uint32(0); i < n; i++ {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		key := [3]int{
+			int(int32(binary.LittleEndian.Uint32(buf[0:4]))),
+			int(int32(binary.LittleEndian.Uint32(buf[4:8]))),
+			int(int32(binary.LittleEndian.Uint32(buf[8:12]))),
+		}
+		s.index[key] = diskEntry{
+			offset: int64(binary.LittleEndian.Uint64(buf[12:20])),
+			length: int32(binary.LittleEndian.Uint32(buf[20:24])),
+			crc:    binary.LittleEndian.Uint32(buf[24:28]),
+		}
+	}
+	s.mapOverhead.Add(int64(n) * mapOverheadIndex)
+	return nil
+}
+
+// SaveCheckpoint saves the store's tiles for LoadDiskTileStore: the spill
+// file is synced and linked to prefix+".spill" (see checkpoint.Link), and
+// the disk index (WriteIndexTo), the uniform tiles, and the tiles still in
+// memory are written to prefix+".index". A store with a backing file has
+// no spill file; its index refers to the writer's tile data, which the
+// writer's own checkpoint saves. Call after Drain, with no Put or Delete
+// running.
+//
+// After the index: uniform count (uint32) + [z x y (int32) r g b a]
+// × count, then in-memory count (uint32) + [z x y (int32) length (uint32)
+// bytes] × count.
+func (s *DiskTileStore) SaveCheckpoint(prefix string) error {
+	if f := s.readFile.Load(); f != nil {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("syncing spill file: %w", err)
+		}
+		if err := checkpoint.Link(f.Name(), prefix+".spill"); err != nil {
+			return fmt.Errorf("linking spill file: %w", err)
+		}
+	}
+	return checkpoint.WriteFile(prefix+".index", func(w io.Writer) error {
+		if err := s.WriteIndexTo(w); err != nil {
+			return err
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		buf := binary.LittleEndian.AppendUint32(nil, uint32(len(s.uniforms)))
+		for key, td := range s.uniforms {
+			buf = appendKey(buf, key)
+			buf = append(buf, td.color.R, td.color.G, td.color.B, td.color.A)
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(s.encoded)))
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+		for key, data := range s.encoded {
+			buf = binary.LittleEndian.AppendUint32(appendKey(buf[:0], key), uint32(len(data)))
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// appendKey appends a tile key as three little-endian int32s.
+func appendKey(b []byte, key [3]int) []byte {
+	b = binary.LittleEndian.AppendUint32(b, uint32(key[0]))
+	b = binary.LittleEndian.AppendUint32(b, uint32(key[1]))
+	return binary.LittleEndian.AppendUint32(b, uint32(key[2]))
+}
+
+// LoadDiskTileStore creates a store holding the tiles saved by
+// SaveCheckpoint under prefix, for reading: the spill file is opened under a
+// new temp name (checkpoint.Adopt), so Close leaves the checkpoint intact.
+// Without a spill file the index refers to cfg.Backing, the tile data of
+// the writer resumed from the same checkpoint.
+func LoadDiskTileStore(cfg DiskTileStoreConfig, prefix string) (*DiskTileStore, error) {
+	s := NewDiskTileStore(cfg)
+	if err := s.loadCheckpoint(prefix); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("loading tile store checkpoint %s: %w", prefix, err)
+	}
+	return s, nil
+}
+
+func (s *DiskTileStore) loadCheckpoint(prefix string) error {
+	f, err := os.Open(prefix + ".index")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReaderSize(f, 1<<20)
+
+	if err := s.readIndexFrom(r); err != nil {
+		return err
+	}
+	if len(s.index) > 0 {
+		if _, err := os.Stat(prefix + ".spill"); err == nil || s.backing == nil {
+			spill, err := checkpoint.Adopt(prefix+".spill", s.dir, "pmtiles-tilestore-*.tmp")
+			if err != nil {
+				return err
+			}
+			s.readFile.Store(spill)
+			s.backing = nil // saved by a store with a spill file of its own
+		}
+	}
+
+	buf := make([]byte, 16)
+	readKey := func() ([3]int, error) {
+		if _, err := io.ReadFull(r, buf[:12]); err != nil {
+			return [3]int{}, err
+		}
+		return [3]int{
+			int(int32(binary.LittleEndian.Uint32(buf[0:4]))),
+			int(int32(binary.LittleEndian.Uint32(buf[4:8]))),
+			int(int32(binary.LittleEndian.Uint32(buf[8:12]))),
+		}, nil
+	}
+	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+		return err
+	}
+	for n := binary.LittleEndian.Uint32(buf[:4]); n > 0; n-- {
+		key, err := readKey()
+		if err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, buf[12:16]); err != nil {
+			return err
+		}
+		s.uniforms[key] = newTileDataUniform(color.RGBA{buf[12], buf[13], buf[14], buf[15]}, s.tileSize)
+		s.mapOverhead.Add(mapOverheadUniform)
+	}
+	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+		return err
+	}
+	for n := binary.LittleEndian.Uint32(buf[:4]); n > 0; n-- {
+		key, err := readKey()
+		if err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, buf[12:16]); err != nil {
+			return err
+		}
+		data := make([]byte, binary.LittleEndian.Uint32(buf[12:16]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		s.encoded[key] = data
+		s.memBytes.Add(int64(len(data)))
+	}
+	return nil
+}
+
 // TempFilePath returns the path to the temporary spill file, or "" if none exists.
 func (s *DiskTileStore) TempFilePath() string {
 	if f := s.readFile.Load(); f != nil {
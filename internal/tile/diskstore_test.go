@@ -3,6 +3,8 @@ package tile
 import (
 	"image/color"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 
@@ -296,3 +298,524 @@ func TestDiskTileStore_MultipleUniforms_SeparateKeys(t *testing.T) {
 		}
 	}
 }
+
+// --- Prefetch ---
+
+func TestDiskTileStore_Prefetch_GetReturnsDecoded(t *testing.T) {
+	store := NewDiskTileStore(DiskTileStoreConfig{TileSize: 4, Format: "png"})
+	defer store.Close()
+	store.EnablePrefetch(2, 16)
+
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	var keys [][3]int
+	for x := 0; x < 4; x++ {
+		td := newTileData(checkerImage(4, red, blue), 4)
+		store.Put(2, x, 0, td, encodePNG(t, td))
+		keys = append(keys, [3]int{2, x, 0})
+	}
+	uni := newTileDataUniform(red, 4)
+	store.Put(2, 0, 1, uni, nil)
+	keys = append(keys, [3]int{2, 0, 1}, [3]int{2, 3, 3}) // uniform + missing
+
+	store.Prefetch(keys)
+
+	store.pfMu.Lock()
+	pending := len(store.pending)
+	store.pfMu.Unlock()
+	if pending != 4 {
+		t.Errorf("pending = %d, want 4 (uniform and missing tiles skipped)", pending)
+	}
+
+	for x := 0; x < 4; x++ {
+		got := store.Get(2, x, 0)
+		if got == nil {
+			t.Fatalf("Get(2,%d,0) = nil", x)
+		}
+		if c := got.RGBAAt(0, 0); c != red {
+			t.Errorf("Get(2,%d,0) pixel = %v, want %v", x, c, red)
+		}
+	}
+	if got := store.Get(2, 0, 1); got == nil || !got.IsUniform() {
+		t.Error("expected uniform tile from Get")
+	}
+	if got := store.Get(2, 3, 3); got != nil {
+		t.Error("expected nil for missing tile")
+	}
+
+	store.pfMu.Lock()
+	pending = len(store.pending)
+	store.pfMu.Unlock()
+	if pending != 0 {
+		t.Errorf("pending after Get = %d, want 0", pending)
+	}
+}
+
+// TestDiskTileStore_Prefetch_Backpressure verifies that Prefetch blocks when
+// the pending limit is reached and resumes once tiles are consumed, and that
+// a batch larger than the limit is still accepted when nothing is pending.
+func TestDiskTileStore_Prefetch_Backpressure(t *testing.T) {
+	store := NewDiskTileStore(DiskTileStoreConfig{TileSize: 4, Format: "png"})
+	defer store.Close()
+	store.EnablePrefetch(1, 2)
+
+	img := checkerImage(4, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255})
+	for x := 0; x < 6; x++ {
+		td := newTileData(img, 4)
+		store.Put(1, x, 0, td, encodePNG(t, td))
+	}
+
+	// Oversized first batch: accepted because nothing is pending.
+	store.Prefetch([][3]int{{1, 0, 0}, {1, 1, 0}, {1, 2, 0}})
+
+	done := make(chan struct{})
+	go func() {
+		store.Prefetch([][3]int{{1, 3, 0}, {1, 4, 0}})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Prefetch should block while over the pending limit")
+	default:
+	}
+
+	for x := 0; x < 3; x++ {
+		if store.Get(1, x, 0) == nil {
+			t.Fatalf("Get(1,%d,0) = nil", x)
+		}
+	}
+	<-done
+	for x := 3; x < 6; x++ {
+		if store.Get(1, x, 0) == nil {
+			t.Fatalf("Get(1,%d,0) = nil", x)
+		}
+	}
+}
+
+func TestDiskTileStore_Prefetch_CloseWithUnconsumed(t *testing.T) {
+	store := NewDiskTileStore(DiskTileStoreConfig{TileSize: 4, Format: "png"})
+	store.EnablePrefetch(2, 8)
+	td := newTileData(checkerImage(4, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}), 4)
+	store.Put(0, 0, 0, td, encodePNG(t, td))
+	store.Prefetch([][3]int{{0, 0, 0}})
+	store.Close()
+	store.Close()                       // idempotent
+	store.Prefetch([][3]int{{0, 0, 0}}) // no-op after close
+}
+
+func TestPlanReads_MergesNeighbours(t *testing.T) {
+	tiles := []spilledTile{
+		{[3]int{1, 1, 0}, diskEntry{offset: 100, length: 50}},
+		{[3]int{1, 0, 0}, diskEntry{offset: 0, length: 100}},
+		{[3]int{1, 0, 1}, diskEntry{offset: 220 + readAheadMaxGap + 1, length: 10}}, // gap too large
+		{[3]int{1, 1, 1}, diskEntry{offset: 200, length: 20}},                       // small gap, bridged
+	}
+	spans := planReads(tiles)
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if spans[0].span != (diskEntry{offset: 0, length: 220}) || len(spans[0].tiles) != 3 {
+		t.Errorf("span 0 = %+v with %d tiles, want {0 220} with 3", spans[0].span, len(spans[0].tiles))
+	}
+	if spans[1].span.offset != 220+readAheadMaxGap+1 || len(spans[1].tiles) != 1 {
+		t.Errorf("span 1 = %+v with %d tiles", spans[1].span, len(spans[1].tiles))
+	}
+}
+
+func TestPlanReads_RespectsMaxSpan(t *testing.T) {
+	var tiles []spilledTile
+	const size = 100 << 10
+	for i := 0; i < 30; i++ {
+		tiles = append(tiles, spilledTile{[3]int{5, i, 0}, diskEntry{offset: int64(i) * size, length: size}})
+	}
+	for _, sp := range planReads(tiles) {
+		if sp.span.length > readAheadMaxSpan {
+			t.Errorf("span length %d exceeds %d", sp.span.length, readAheadMaxSpan)
+		}
+	}
+}
+
+// TestDiskTileStore_Prefetch_Spilled verifies that tiles read back from the
+// spill file with merged reads decode to the original pixels.
+func TestDiskTileStore_Prefetch_Spilled(t *testing.T) {
+	store := NewDiskTileStore(DiskTileStoreConfig{
+		TileSize:         4,
+		Format:           "png",
+		MemoryLimitBytes: 1,
+		TempDir:          t.TempDir(),
+	})
+	defer store.Close()
+
+	colors := make(map[[3]int]color.RGBA)
+	var keys [][3]int
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			c := color.RGBA{uint8(x * 60), uint8(y * 60), 7, 255}
+			td := newTileData(checkerImage(4, c, color.RGBA{0, 0, 0, 255}), 4)
+			store.Put(2, x, y, td, encodePNG(t, td))
+			colors[[3]int{2, x, y}] = c
+			keys = append(keys, [3]int{2, x, y})
+		}
+	}
+	store.Drain()
+	if store.TempFilePath() == "" {
+		t.Fatal("expected tiles to be spilled")
+	}
+
+	store.EnablePrefetch(2, 64)
+	// Reverse order: planReads must reorder by offset.
+	for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+		keys[i], keys[j] = keys[j], keys[i]
+	}
+	store.Prefetch(keys)
+	for _, k := range keys {
+		got := store.Get(k[0], k[1], k[2])
+		if got == nil {
+			t.Fatalf("Get(%v) = nil", k)
+		}
+		if c := got.RGBAAt(0, 0); c != colors[k] {
+			t.Errorf("Get(%v) pixel = %v, want %v", k, c, colors[k])
+		}
+	}
+}
+
+// corruptSpilledTile flips one byte inside the spill record of key.
+func corruptSpilledTile(t *testing.T, store *DiskTileStore, key [3]int) {
+	t.Helper()
+	store.mu.RLock()
+	de, ok := store.index[key]
+	store.mu.RUnlock()
+	if !ok {
+		t.Fatalf("tile %v not spilled", key)
+	}
+	f, err := os.OpenFile(store.TempFilePath(), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	off := de.offset + int64(de.length)/2
+	if _, err := f.ReadAt(b, off); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if _, err := f.WriteAt(b, off); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func spillCheckerTiles(t *testing.T, n int) (*DiskTileStore, [][3]int) {
+	t.Helper()
+	store := NewDiskTileStore(DiskTileStoreConfig{
+		TileSize:         4,
+		Format:           "png",
+		MemoryLimitBytes: 1,
+		TempDir:          t.TempDir(),
+	})
+	var keys [][3]int
+	for x := 0; x < n; x++ {
+		td := newTileData(checkerImage(4, color.RGBA{uint8(x * 30), 1, 2, 255}, color.RGBA{0, 0, 0, 255}), 4)
+		store.Put(3, x, 0, td, encodePNG(t, td))
+		keys = append(keys, [3]int{3, x, 0})
+	}
+	store.Drain()
+	return store, keys
+}
+
+func TestDiskTileStore_CorruptSpillRecord(t *testing.T) {
+	store, keys := spillCheckerTiles(t, 4)
+	defer store.Close()
+
+	if got := store.Get(keys[0][0], keys[0][1], keys[0][2]); got == nil || store.Err() != nil {
+		t.Fatalf("intact tile: Get = %v, Err = %v", got, store.Err())
+	}
+	corruptSpilledTile(t, store, keys[1])
+	if got := store.Get(keys[1][0], keys[1][1], keys[1][2]); got != nil {
+		t.Error("Get of corrupted tile returned data")
+	}
+	err := store.Err()
+	if err == nil || !strings.Contains(err.Error(), "z3/1/0 is corrupt") {
+		t.Fatalf("Err() = %v, want checksum mismatch for z3/1/0", err)
+	}
+	// Intact tiles stay readable; the first error sticks.
+	if got := store.Get(keys[2][0], keys[2][1], keys[2][2]); got == nil {
+		t.Error("intact tile unreadable after another tile failed")
+	}
+	if store.Err() != err {
+		t.Error("Err() changed after a later successful read")
+	}
+}
+
+func TestDiskTileStore_Prefetch_CorruptSpillRecord(t *testing.T) {
+	store, keys := spillCheckerTiles(t, 8)
+	defer store.Close()
+
+	corruptSpilledTile(t, store, keys[5])
+	store.EnablePrefetch(2, 64)
+	store.Prefetch(keys)
+	for i, k := range keys {
+		got := store.Get(k[0], k[1], k[2])
+		if (got == nil) != (i == 5) {
+			t.Errorf("Get(%v) = %v", k, got)
+		}
+	}
+	if store.Err() == nil {
+		t.Error("Err() = nil after prefetching a corrupted record")
+	}
+}
+
+// --- Delete and SpillOnPressure ---
+
+func TestDiskTileStore_Delete(t *testing.T) {
+	store, keys := spillCheckerTiles(t, 3)
+	defer store.Close()
+
+	td := newTileData(checkerImage(4, color.RGBA{9, 9, 9, 255}, color.RGBA{0, 0, 0, 255}), 4)
+	store.mu.Lock()
+	store.encoded[[3]int{3, 9, 0}] = encodePNG(t, td) // in memory (spill goroutine has exited)
+	store.mu.Unlock()
+	store.memBytes.Add(int64(len(store.encoded[[3]int{3, 9, 0}])))
+	store.Put(3, 10, 0, newTileDataUniform(color.RGBA{1, 2, 3, 255}, 4), nil)
+	overhead := store.mapOverhead.Load()
+
+	store.Delete(keys[0][0], keys[0][1], keys[0][2]) // spilled
+	store.Delete(3, 9, 0)                            // encoded in memory
+	store.Delete(3, 10, 0)                           // uniform
+	store.Delete(3, 99, 0)                           // absent
+
+	for _, k := range [][3]int{keys[0], {3, 9, 0}, {3, 10, 0}} {
+		if store.Get(k[0], k[1], k[2]) != nil {
+			t.Errorf("tile %v still present after Delete", k)
+		}
+	}
+	if store.Get(keys[1][0], keys[1][1], keys[1][2]) == nil {
+		t.Error("undeleted spilled tile missing")
+	}
+	if l := store.Len(); l != 2 {
+		t.Errorf("Len = %d, want 2", l)
+	}
+	if m := store.memBytes.Load(); m != 0 {
+		t.Errorf("memBytes = %d after deleting the only in-memory tile, want 0", m)
+	}
+	if got, want := store.mapOverhead.Load(), overhead-mapOverheadIndex-mapOverheadUniform; got != want {
+		t.Errorf("mapOverhead = %d, want %d", got, want)
+	}
+}
+
+func TestDiskTileStore_SpillOnPressure(t *testing.T) {
+	td := newTileData(checkerImage(4, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}), 4)
+	encoded := encodePNG(t, td)
+	size := int64(len(encoded))
+
+	newStore := func() *DiskTileStore {
+		return NewDiskTileStore(DiskTileStoreConfig{
+			TileSize:         4,
+			Format:           "png",
+			TempDir:          t.TempDir(),
+			MemoryLimitBytes: 20 * size,
+			SpillOnPressure:  true,
+		})
+	}
+
+	// Below half the limit nothing is written.
+	store := newStore()
+	for x := 0; x < 8; x++ {
+		store.Put(4, x, 0, td, encoded)
+	}
+	store.Drain()
+	if store.TempFilePath() != "" || len(store.index) != 0 {
+		t.Errorf("spilled %d tiles below the memory limit", len(store.index))
+	}
+	store.Close()
+
+	// Above it, the oldest tiles are spilled and everything stays readable.
+	store = newStore()
+	defer store.Close()
+	const n = 40
+	for x := 0; x < n; x++ {
+		store.Put(4, x, 0, td, encoded)
+	}
+	store.Drain()
+	if len(store.index) == 0 {
+		t.Fatal("nothing spilled above the memory limit")
+	}
+	if _, ok := store.index[[3]int{4, 0, 0}]; !ok {
+		t.Error("oldest tile was not spilled")
+	}
+	if _, ok := store.encoded[[3]int{4, n - 1, 0}]; !ok {
+		t.Error("newest tile was spilled")
+	}
+	if m := store.memBytes.Load(); m > 10*size {
+		t.Errorf("memBytes = %d after drain, want <= half the limit (%d)", m, 10*size)
+	}
+	for x := 0; x < n; x++ {
+		if got := store.Get(4, x, 0); got == nil {
+			t.Fatalf("tile 4/%d/0 missing", x)
+		} else {
+			got.Release()
+		}
+	}
+}
+
+func TestDiskTileStore_SpillOnPressure_SkipsDeleted(t *testing.T) {
+	td := newTileData(checkerImage(4, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}), 4)
+	encoded := encodePNG(t, td)
+	store := NewDiskTileStore(DiskTileStoreConfig{
+		TileSize:         4,
+		Format:           "png",
+		TempDir:          t.TempDir(),
+		MemoryLimitBytes: 4 * int64(len(encoded)),
+		SpillOnPressure:  true,
+	})
+	defer store.Close()
+
+	// Tiles consumed right after they are put, as children are by the
+	// pipelined generator, never reach the disk.
+	for x := 0; x < 5000; x++ {
+		store.Put(4, x, 0, td, encoded)
+		store.Delete(4, x, 0)
+	}
+	store.Drain()
+	if store.totalDiskTiles.Load() != 0 {
+		t.Errorf("%d deleted tiles were spilled", store.totalDiskTiles.Load())
+	}
+	if store.Len() != 0 || store.memBytes.Load() != 0 {
+		t.Errorf("Len = %d, memBytes = %d after deleting everything", store.Len(), store.memBytes.Load())
+	}
+	// Deleted keys are compacted out of the queue once it outgrows the
+	// tiles in memory by 1024 entries.
+	if len(store.unspilled) > 1025 {
+		t.Errorf("unspilled queue holds %d keys after deleting 5000 tiles", len(store.unspilled))
+	}
+}
+
+func TestDiskTileStore_Checkpoint(t *testing.T) {
+	td := newTileData(checkerImage(4, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}), 4)
+	encoded := encodePNG(t, td)
+	cfg := DiskTileStoreConfig{
+		TileSize:         4,
+		Format:           "png",
+		TempDir:          t.TempDir(),
+		MemoryLimitBytes: 4 * int64(len(encoded)),
+		SpillOnPressure:  true,
+	}
+	store := NewDiskTileStore(cfg)
+	const n = 20
+	for x := 0; x < n; x++ {
+		store.Put(4, x, 0, td, encoded)
+	}
+	c := color.RGBA{10, 20, 30, 255}
+	store.Put(4, 0, 1, newTileDataUniform(c, 4), nil)
+	store.Drain()
+	if len(store.index) == 0 || len(store.encoded) == 0 {
+		t.Fatalf("%d spilled, %d in memory; want both", len(store.index), len(store.encoded))
+	}
+
+	prefix := filepath.Join(t.TempDir(), "z04-store0")
+	if err := store.SaveCheckpoint(prefix); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// Twice: loading must leave the checkpoint usable.
+	for i := 0; i < 2; i++ {
+		loaded, err := LoadDiskTileStore(cfg, prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := loaded.Len(); got != n+1 {
+			t.Errorf("Len = %d, want %d", got, n+1)
+		}
+		for x := 0; x < n; x++ {
+			if got := loaded.Get(4, x, 0); got == nil {
+				t.Fatalf("tile 4/%d/0 missing", x)
+			} else {
+				got.Release()
+			}
+		}
+		if got := loaded.Get(4, 0, 1); got == nil || !got.IsUniform() || got.Color() != c {
+			t.Errorf("uniform tile not restored")
+		}
+		loaded.Close()
+	}
+}
+
+func TestDiskTileStore_Backing(t *testing.T) {
+	// The writer's tile data: two tiles behind some other bytes.
+	red := newTileData(checkerImage(4, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}), 4)
+	green := newTileData(checkerImage(4, color.RGBA{0, 255, 0, 255}, color.RGBA{0, 0, 0, 255}), 4)
+	encRed, encGreen := encodePNG(t, red), encodePNG(t, green)
+	backing, err := os.CreateTemp(t.TempDir(), "tiles-*.tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backing.Close()
+	data := append(append([]byte("header"), encRed...), encGreen...)
+	if _, err := backing.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DiskTileStoreConfig{
+		TileSize:         4,
+		Format:           "png",
+		TempDir:          t.TempDir(),
+		MemoryLimitBytes: 1 << 20,
+		Backing:          backing,
+	}
+	store := NewDiskTileStore(cfg)
+	store.PutAt(3, 0, 0, red, encRed, 6)
+	store.PutAt(3, 1, 0, green, encGreen, int64(6+len(encRed)))
+	c := color.RGBA{10, 20, 30, 255}
+	store.PutAt(3, 0, 1, newTileDataUniform(c, 4), nil, -1)
+	store.Drain()
+
+	if p := store.TempFilePath(); p != "" {
+		t.Errorf("store created spill file %s, want none", p)
+	}
+	if n := store.memBytes.Load(); n != 0 {
+		t.Errorf("store keeps %d bytes of tiles in memory, want 0", n)
+	}
+	check := func(s *DiskTileStore) {
+		t.Helper()
+		if got := s.Get(3, 1, 0); got == nil || got.AsImage().At(0, 0) != (color.RGBA{0, 255, 0, 255}) {
+			t.Errorf("tile 3/1/0 not read back from the backing file")
+		}
+		if got := s.Get(3, 0, 1); got == nil || !got.IsUniform() || got.Color() != c {
+			t.Errorf("uniform tile not kept")
+		}
+	}
+	check(store)
+
+	// A checkpoint of a backed store has no spill file; loading it reads
+	// from the backing file again.
+	prefix := filepath.Join(t.TempDir(), "z03-store0")
+	if err := store.SaveCheckpoint(prefix); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if _, err := os.Stat(prefix + ".spill"); !os.IsNotExist(err) {
+		t.Errorf("checkpoint has a spill file (%v), want none", err)
+	}
+	loaded, err := LoadDiskTileStore(cfg, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(loaded)
+	loaded.Close()
+	cfg.Backing = nil
+	if _, err := LoadDiskTileStore(cfg, prefix); err == nil {
+		t.Error("loading a backed checkpoint without the backing file succeeded")
+	}
+
+	// A record that changed in the backing file fails its checksum.
+	if _, err := backing.WriteAt([]byte{0xff}, 6+20); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Backing = backing
+	store = NewDiskTileStore(cfg)
+	defer store.Close()
+	store.PutAt(3, 0, 0, red, encRed, 6)
+	if got := store.Get(3, 0, 0); got != nil || store.Err() == nil {
+		t.Errorf("corrupt record: Get = %v, Err = %v; want nil and an error", got, store.Err())
+	}
+}
@@ -481,18 +481,19 @@ func downsampleQuadrant(dst *image.RGBA, src *image.RGBA, dstOffX, dstOffY, half
 	}
 }
 
-// downsampleQuadrantTerrarium scales a source quadrant using Terrarium-aware averaging.
-// Decodes Terrarium RGB → elevation, averages valid values, re-encodes to Terrarium RGB.
-func downsampleQuadrantTerrarium(dst *image.RGBA, src *image.RGBA, dstOffX, dstOffY, half, tileSize int, mode Resampling) {
+// downsampleQuadrantTerrarium scales a source quadrant of elevation pixels
+// encoded with dem (Terrarium or Terrain-RGB): decodes RGB → elevation,
+// averages valid values, re-encodes to RGB.
+func downsampleQuadrantTerrarium(dst *image.RGBA, src *image.RGBA, dstOffX, dstOffY, half, tileSize int, mode Resampling, dem encode.DEMEncoding) {
 	switch mode {
 	case ResamplingNearest, ResamplingMode:
 		downsampleQuadrantTerrariumNearest(dst, src, dstOffX, dstOffY, half, tileSize)
 		return
 	case ResamplingLanczos:
-		downsampleQuadrantTerrariumLanczos(dst, src, dstOffX, dstOffY, half, tileSize)
+		downsampleQuadrantTerrariumLanczos(dst, src, dstOffX, dstOffY, half, tileSize, dem)
 		return
 	case ResamplingBicubic:
-		downsampleQuadrantTerrariumBicubic(dst, src, dstOffX, dstOffY, half, tileSize)
+		downsampleQuadrantTerrariumBicubic(dst, src, dstOffX, dstOffY, half, tileSize, dem)
 		return
 	}
 
@@ -506,14 +507,14 @@ func downsampleQuadrantTerrarium(dst *image.RGBA, src *image.RGBA, dstOffX, dstO
 			p01 := srcPixel(src, sx, sy+1, tileSize)
 			p11 := srcPixel(src, sx+1, sy+1, tileSize)
 
-			// Decode Terrarium RGB to elevation, average valid values.
+			// Decode RGB to elevation, average valid values.
 			var sum float64
 			var count int
 			for _, p := range [4]color.RGBA{p00, p10, p01, p11} {
 				if p.A == 0 {
 					continue // nodata
 				}
-				elev := encode.TerrariumToElevation(p)
+				elev := dem.ToElevation(p)
 				if !math.IsNaN(elev) {
 					sum += elev
 					count++
@@ -526,7 +527,7 @@ func downsampleQuadrantTerrarium(dst *image.RGBA, src *image.RGBA, dstOffX, dstO
 			}
 
 			avg := sum / float64(count)
-			dst.SetRGBA(dstOffX+dx, dstOffY+dy, encode.ElevationToTerrarium(avg))
+			dst.SetRGBA(dstOffX+dx, dstOffY+dy, dem.FromElevation(avg))
 		}
 	}
 }
@@ -545,12 +546,12 @@ func downsampleQuadrantTerrariumNearest(dst *image.RGBA, src *image.RGBA, dstOff
 	}
 }
 
-// downsampleQuadrantTerrariumLanczos uses a Lanczos-3 kernel for terrarium data.
-// Decodes Terrarium RGB → elevation, applies Lanczos weights to valid values,
-// and re-encodes the averaged elevation back to Terrarium RGB.
+// downsampleQuadrantTerrariumLanczos uses a Lanczos-3 kernel for elevation data.
+// Decodes RGB → elevation, applies Lanczos weights to valid values,
+// and re-encodes the averaged elevation back to RGB.
 // Out-of-bounds kernel positions are skipped so the source extent is never
 // extended by edge-pixel clamping.
-func downsampleQuadrantTerrariumLanczos(dst *image.RGBA, src *image.RGBA, dstOffX, dstOffY, half, tileSize int) {
+func downsampleQuadrantTerrariumLanczos(dst *image.RGBA, src *image.RGBA, dstOffX, dstOffY, half, tileSize int, dem encode.DEMEncoding) {
 	w := lanczos3Weights2x
 	maxIdx := tileSize - 1
 
@@ -572,7 +573,7 @@ func downsampleQuadrantTerrariumLanczos(dst *image.RGBA, src *image.RGBA, dstOff
 					if p.A == 0 {
 						continue
 					}
-					elev := encode.TerrariumToElevation(p)
+					elev := dem.ToElevation(p)
 					if math.IsNaN(elev) {
 						continue
 					}
@@ -585,15 +586,15 @@ func downsampleQuadrantTerrariumLanczos(dst *image.RGBA, src *image.RGBA, dstOff
 			if wSum == 0 {
 				continue
 			}
-			dst.SetRGBA(dstOffX+dx, dstOffY+dy, encode.ElevationToTerrarium(elevSum/wSum))
+			dst.SetRGBA(dstOffX+dx, dstOffY+dy, dem.FromElevation(elevSum/wSum))
 		}
 	}
 }
 
-// downsampleQuadrantTerrariumBicubic uses a Catmull-Rom bicubic kernel for terrarium data.
+// downsampleQuadrantTerrariumBicubic uses a Catmull-Rom bicubic kernel for elevation data.
 // Out-of-bounds kernel positions are skipped so the source extent is never
 // extended by edge-pixel clamping.
-func downsampleQuadrantTerrariumBicubic(dst *image.RGBA, src *image.RGBA, dstOffX, dstOffY, half, tileSize int) {
+func downsampleQuadrantTerrariumBicubic(dst *image.RGBA, src *image.RGBA, dstOffX, dstOffY, half, tileSize int, dem encode.DEMEncoding) {
 	w := bicubicWeights2x
 	maxIdx := tileSize - 1
 
@@ -615,7 +616,7 @@ func downsampleQuadrantTerrariumBicubic(dst *image.RGBA, src *image.RGBA, dstOff
 					if p.A == 0 {
 						continue
 					}
-					elev := encode.TerrariumToElevation(p)
+					elev := dem.ToElevation(p)
 					if math.IsNaN(elev) {
 						continue
 					}
@@ -628,7 +629,7 @@ func downsampleQuadrantTerrariumBicubic(dst *image.RGBA, src *image.RGBA, dstOff
 			if wSum == 0 {
 				continue
 			}
-			dst.SetRGBA(dstOffX+dx, dstOffY+dy, encode.ElevationToTerrarium(elevSum/wSum))
+			dst.SetRGBA(dstOffX+dx, dstOffY+dy, dem.FromElevation(elevSum/wSum))
 		}
 	}
 }
@@ -925,11 +926,12 @@ func downsampleQuadrantNearest(dst *image.RGBA, src *image.RGBA, dstOffX, dstOff
 }
 
 // downsampleTileTerrarium creates a parent tile by combining up to 4 child tiles
-// using Terrarium-aware averaging (decode RGB→elevation, average, re-encode).
+// of elevations encoded with dem (Terrarium or Terrain-RGB), averaging in
+// elevation space (decode RGB→elevation, average, re-encode).
 //
 // When all four children are uniform with the same color (same elevation),
 // the result is returned as a compact uniform TileData.
-func downsampleTileTerrarium(topLeft, topRight, bottomLeft, bottomRight *TileData, tileSize int, mode Resampling) *TileData {
+func downsampleTileTerrarium(topLeft, topRight, bottomLeft, bottomRight *TileData, tileSize int, mode Resampling, dem encode.DEMEncoding) *TileData {
 	children := [4]*TileData{topLeft, topRight, bottomLeft, bottomRight}
 
 	nonNilCount := 0
@@ -988,7 +990,7 @@ func downsampleTileTerrarium(topLeft, topRight, bottomLeft, bottomRight *TileDat
 		if q.src == nil {
 			continue
 		}
-		downsampleQuadrantTerrarium(dst, q.src, q.dstX, q.dstY, half, tileSize, mode)
+		downsampleQuadrantTerrarium(dst, q.src, q.dstX, q.dstY, half, tileSize, mode, dem)
 	}
 
 	for i, img := range imgs {
@@ -3,6 +3,7 @@ package tile
 import (
 	"image"
 	"image/color"
+	"strings"
 	"testing"
 )
 
@@ -541,3 +542,66 @@ func abs(x int) int {
 	}
 	return x
 }
+
+func TestValidateTileSize(t *testing.T) {
+	for _, size := range []int{2, 8, 250, 256, 384, 512, 1024, 4096} {
+		if err := ValidateTileSize(size); err != nil {
+			t.Errorf("ValidateTileSize(%d) = %v, want nil", size, err)
+		}
+	}
+	for _, size := range []int{-2, 0, 1, 255, 257, 513, 8192} {
+		err := ValidateTileSize(size)
+		if err == nil {
+			t.Errorf("ValidateTileSize(%d) = nil, want error", size)
+		} else if !strings.Contains(err.Error(), "even number between 2 and 4096") {
+			t.Errorf("ValidateTileSize(%d) = %v, want the accepted range", size, err)
+		}
+	}
+}
+
+// gradientTile creates a non-uniform, fully opaque tile so downsampling
+// cannot take the uniform fast path.
+func gradientTile(tileSize int, seed uint8) *TileData {
+	img := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
+	for y := 0; y < tileSize; y++ {
+		for x := 0; x < tileSize; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x) + seed, uint8(y), seed, 255})
+		}
+	}
+	return newTileData(img, tileSize)
+}
+
+// TestDownsampleTile_SizesFullyCovered verifies that for every accepted tile
+// size (powers of two and even non-powers of two) and every resampling mode,
+// the downsampled parent has no unwritten (transparent) pixels.
+func TestDownsampleTile_SizesFullyCovered(t *testing.T) {
+	modes := []Resampling{ResamplingNearest, ResamplingBilinear, ResamplingBicubic, ResamplingLanczos, ResamplingMode}
+	for _, tileSize := range []int{250, 512, 1024} {
+		for _, mode := range modes {
+			tl := gradientTile(tileSize, 10)
+			tr := gradientTile(tileSize, 70)
+			bl := gradientTile(tileSize, 130)
+			br := gradientTile(tileSize, 190)
+
+			result := downsampleTile(tl, tr, bl, br, tileSize, mode)
+			if result == nil {
+				t.Fatalf("size %d mode %d: nil result", tileSize, mode)
+			}
+			b := result.Bounds()
+			if b.Dx() != tileSize || b.Dy() != tileSize {
+				t.Fatalf("size %d mode %d: bounds %v", tileSize, mode, b)
+			}
+			for _, p := range [][2]int{{0, 0}, {tileSize - 1, 0}, {0, tileSize - 1}, {tileSize - 1, tileSize - 1}, {tileSize / 2, tileSize / 2}} {
+				if c := result.RGBAAt(p[0], p[1]); c.A != 255 {
+					t.Errorf("size %d mode %d: pixel %v alpha %d, want 255", tileSize, mode, p, c.A)
+				}
+			}
+			// Quadrant origin pixels come from the matching child.
+			half := tileSize / 2
+			if c := result.RGBAAt(half, half); c.B < 180 {
+				t.Errorf("size %d mode %d: bottom-right quadrant B=%d, want from br child (190)", tileSize, mode, c.B)
+			}
+			result.Release()
+		}
+	}
+}
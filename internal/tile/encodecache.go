@@ -0,0 +1,111 @@
+package tile
+
+import (
+	"container/list"
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+)
+
+// encodeKey identifies the encoding of a tile's content: the encoder, by
+// encoderID, and two independent 64-bit hashes of the pixels, so that
+// distinct tiles sharing an encoding would take a 128-bit collision.
+type encodeKey struct {
+	enc  int
+	hash [2]uint64
+}
+
+// encodeCache maps tile content to its encoded bytes, so that a tile
+// repeating one already encoded (flat fields of classified data, open water,
+// repeated nodata edges) is not encoded again. Least recently used entries
+// are evicted once the encoded bytes exceed maxBytes. Safe for concurrent
+// use; entries are read-only.
+type encodeCache struct {
+	seeds [2]maphash.Seed
+
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	entries  map[encodeKey]*list.Element
+	lru      list.List // of *encodeEntry, most recent first
+
+	hits, misses atomic.Int64
+}
+
+type encodeEntry struct {
+	key  encodeKey
+	data []byte
+}
+
+// newEncodeCache creates a cache of up to maxBytes of encoded tiles.
+func newEncodeCache(maxBytes int64) *encodeCache {
+	return &encodeCache{
+		seeds:    [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()},
+		maxBytes: maxBytes,
+		entries:  make(map[encodeKey]*list.Element),
+	}
+}
+
+// key returns the cache key of td encoded with encoder enc. The kind of
+// tile is hashed with the pixels, so a uniform color never matches the
+// first pixel of an image.
+func (c *encodeCache) key(enc int, td *TileData) encodeKey {
+	var kind byte
+	var pix []byte
+	switch {
+	case td.img != nil:
+		kind, pix = 'c', td.img.Pix
+	case td.gray != nil:
+		kind, pix = 'g', td.gray.Pix
+	default:
+		kind, pix = 'u', []byte{td.color.R, td.color.G, td.color.B, td.color.A}
+	}
+	k := encodeKey{enc: enc}
+	for i, seed := range c.seeds {
+		var h maphash.Hash
+		h.SetSeed(seed)
+		h.WriteByte(kind)
+		h.Write(pix)
+		k.hash[i] = h.Sum64()
+	}
+	return k
+}
+
+// get returns the encoded bytes of key, or nil. The bytes must not be
+// modified.
+func (c *encodeCache) get(key encodeKey) []byte {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(e)
+	}
+	c.mu.Unlock()
+	if !ok {
+		c.misses.Add(1)
+		return nil
+	}
+	c.hits.Add(1)
+	return e.Value.(*encodeEntry).data
+}
+
+// put adds the encoded bytes of key, which the cache keeps and the caller
+// must not modify afterwards. Data larger than the whole cache is not kept.
+func (c *encodeCache) put(key encodeKey, data []byte) {
+	n := int64(len(data))
+	if n > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return // another worker encoded the same tile meanwhile
+	}
+	for c.size+n > c.maxBytes {
+		oldest := c.lru.Back()
+		old := c.lru.Remove(oldest).(*encodeEntry)
+		delete(c.entries, old.key)
+		c.size -= int64(len(old.data))
+	}
+	c.entries[key] = c.lru.PushFront(&encodeEntry{key: key, data: data})
+	c.size += n
+}
@@ -0,0 +1,55 @@
+package tile
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestEncodeCache(t *testing.T) {
+	c := newEncodeCache(10)
+	red := newTileDataUniform(color.RGBA{255, 0, 0, 255}, 4)
+	checker := newTileData(checkerImage(4, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}), 4)
+
+	k := c.key(-1, red)
+	if k != c.key(-1, newTileDataUniform(color.RGBA{255, 0, 0, 255}, 4)) {
+		t.Error("equal tiles have different keys")
+	}
+	if k == c.key(5, red) {
+		t.Error("encoders share a key")
+	}
+	if k == c.key(-1, checker) {
+		t.Error("different tiles share a key")
+	}
+
+	if c.get(k) != nil {
+		t.Fatal("hit in an empty cache")
+	}
+	c.put(k, []byte("red"))
+	if got := c.get(k); string(got) != "red" {
+		t.Fatalf("get = %q, want red", got)
+	}
+
+	// Going over the 10 bytes evicts the least recently used entry.
+	kc := c.key(-1, checker)
+	c.put(kc, []byte("check"))
+	k5 := c.key(5, red)
+	c.put(k5, []byte("red5"))
+	if c.get(k) != nil {
+		t.Error("oldest entry was not evicted")
+	}
+	if c.get(kc) == nil || c.get(k5) == nil {
+		t.Error("recent entries were evicted")
+	}
+	if c.size > 10 {
+		t.Errorf("size = %d, want <= 10", c.size)
+	}
+
+	// Data larger than the cache is not kept.
+	c.put(k, make([]byte, 11))
+	if c.get(k) != nil {
+		t.Error("kept data larger than the cache")
+	}
+	if hits, misses := c.hits.Load(), c.misses.Load(); hits != 3 || misses != 3 {
+		t.Errorf("hits, misses = %d, %d; want 3, 3", hits, misses)
+	}
+}
@@ -0,0 +1,66 @@
+package tile
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// FilterWriter is a TileWriter that pipes each encoded tile through an
+// external command (--tile-filter) before passing it on: the tile goes to
+// the command's stdin and its stdout is written instead. This hooks in tools
+// like pngquant, cwebp, or a watermarking script without native support for
+// each.
+//
+// The command runs via "sh -c" once per tile, concurrently from all workers,
+// with TILE_Z, TILE_X, TILE_Y and TILE_FORMAT in its environment. A non-zero
+// exit status or empty output fails the run. Downsampling still uses the
+// unfiltered tiles, so a filter never compounds across zoom levels.
+type FilterWriter struct {
+	next    TileWriter
+	command string
+	env     []string
+}
+
+// NewFilterWriter wraps next so that tiles of the given format ("png",
+// "webp", ...) pass through command first.
+func NewFilterWriter(next TileWriter, command, format string) *FilterWriter {
+	return &FilterWriter{
+		next:    next,
+		command: command,
+		env:     append(os.Environ(), "TILE_FORMAT="+format),
+	}
+}
+
+// WriteTile runs the filter on data and writes its output.
+func (f *FilterWriter) WriteTile(z, x, y int, data []byte) error {
+	cmd := exec.Command("sh", "-c", f.command)
+	cmd.Env = append(f.env[:len(f.env):len(f.env)],
+		"TILE_Z="+strconv.Itoa(z), "TILE_X="+strconv.Itoa(x), "TILE_Y="+strconv.Itoa(y))
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("tile filter on z%d/%d/%d: %w: %s", z, x, y, err, msg)
+		}
+		return fmt.Errorf("tile filter on z%d/%d/%d: %w", z, x, y, err)
+	}
+	if stdout.Len() == 0 {
+		return fmt.Errorf("tile filter on z%d/%d/%d: no output", z, x, y)
+	}
+	return f.next.WriteTile(z, x, y, stdout.Bytes())
+}
+
+// CompleteZoom forwards to the wrapped writer if it is a ZoomCompleter.
+// All tiles of z have been filtered and written by then, since WriteTile
+// returns only after the wrapped writer does.
+func (f *FilterWriter) CompleteZoom(z int) {
+	if zc, ok := f.next.(ZoomCompleter); ok {
+		zc.CompleteZoom(z)
+	}
+}
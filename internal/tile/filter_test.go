@@ -0,0 +1,75 @@
+package tile
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// zoomRecorder is a TileWriter that also records CompleteZoom calls.
+type zoomRecorder struct {
+	*mockTileWriter
+	completed []int
+}
+
+func (w *zoomRecorder) CompleteZoom(z int) { w.completed = append(w.completed, z) }
+
+func requireShell(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+}
+
+func TestFilterWriter_ReplacesTile(t *testing.T) {
+	requireShell(t)
+	next := newMockTileWriter()
+	f := NewFilterWriter(next, "tr a-z A-Z", "png")
+	if err := f.WriteTile(3, 4, 5, []byte("tile")); err != nil {
+		t.Fatalf("WriteTile: %v", err)
+	}
+	if got := string(next.tiles[[3]int{3, 4, 5}]); got != "TILE" {
+		t.Errorf("written = %q, want %q", got, "TILE")
+	}
+}
+
+func TestFilterWriter_Environment(t *testing.T) {
+	requireShell(t)
+	next := newMockTileWriter()
+	f := NewFilterWriter(next, `printf '%s/%s/%s.%s' "$TILE_Z" "$TILE_X" "$TILE_Y" "$TILE_FORMAT"`, "webp")
+	if err := f.WriteTile(7, 66, 44, []byte("x")); err != nil {
+		t.Fatalf("WriteTile: %v", err)
+	}
+	if got := string(next.tiles[[3]int{7, 66, 44}]); got != "7/66/44.webp" {
+		t.Errorf("written = %q, want %q", got, "7/66/44.webp")
+	}
+}
+
+func TestFilterWriter_Errors(t *testing.T) {
+	requireShell(t)
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"echo broken >&2; exit 3", "broken"},
+		{"cat >/dev/null", "no output"},
+	}
+	for _, tt := range tests {
+		next := newMockTileWriter()
+		err := NewFilterWriter(next, tt.command, "png").WriteTile(1, 0, 0, []byte("tile"))
+		if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), "z1/0/0") {
+			t.Errorf("%q: err = %v, want it to name the tile and contain %q", tt.command, err, tt.want)
+		}
+		if len(next.tiles) != 0 {
+			t.Errorf("%q: tile written despite the error", tt.command)
+		}
+	}
+}
+
+func TestFilterWriter_ForwardsCompleteZoom(t *testing.T) {
+	next := &zoomRecorder{mockTileWriter: newMockTileWriter()}
+	NewFilterWriter(next, "cat", "png").CompleteZoom(9)
+	if len(next.completed) != 1 || next.completed[0] != 9 {
+		t.Errorf("completed = %v, want [9]", next.completed)
+	}
+}
@@ -1,19 +1,23 @@
 package tile
 
 import (
+	"bytes"
 	"fmt"
 	"image"
 	"image/color"
+	"io"
 	"log"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
 	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
 	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+	"github.com/pspoerri/geotiff2pmtiles/tileorder"
 )
 
-// scheduleBatchSize is the number of Hilbert-contiguous tiles handed to a
+// scheduleBatchSize is the number of curve-contiguous tiles handed to a
 // worker at a time. Smaller values give better load balance (workers that
 // finish a batch of easy/empty tiles immediately pull the next batch instead
 // of sitting idle). Larger values give better spatial locality for the COG
@@ -22,6 +26,15 @@ import (
 // imbalance is at most one batch (~1.6 s at z18), which is negligible.
 const scheduleBatchSize = 32
 
+// prefetchBatches is how many scheduling batches of child tiles per worker
+// the store may decode ahead of the downsample workers; two keep every
+// worker supplied. prefetchMaxTiles caps the total so decoded tiles stay
+// below ~256 MB (RGBA, 256 px) regardless of worker count.
+const (
+	prefetchBatches  = 2
+	prefetchMaxTiles = 1024
+)
+
 // Resampling selects the interpolation method.
 type Resampling int
 
@@ -41,9 +54,10 @@ const (
 	// ResamplingMode picks the most common (modal) value in the
 	// neighborhood. Ideal for categorical/classified rasters (e.g. land
 	// cover) where interpolated values are meaningless. At the max zoom
-	// level (COG rendering) it behaves like nearest-neighbor; during
-	// pyramid downsampling it selects the most frequent pixel in each
-	// 2×2 block.
+	// level (COG rendering) it votes over the source pixels inside each
+	// output pixel's footprint; during pyramid downsampling it selects the
+	// most frequent pixel in each 2×2 block. Float (terrain) sources are
+	// sampled nearest-neighbor.
 	ResamplingMode
 )
 
@@ -65,6 +79,28 @@ func ParseResampling(s string) (Resampling, error) {
 	}
 }
 
+// MaxTileSize is the largest accepted output tile size.
+const MaxTileSize = 4096
+
+// ValidateTileSize checks that size can be used for pyramid generation.
+//
+// Downsampling maps each 2×2 group of child tiles onto one parent tile, so
+// every child must shrink to exactly size/2 pixels. Odd sizes would leave
+// the last row and column of each parent unwritten; they are rejected
+// rather than silently producing a corrupt pyramid. Even sizes that are not
+// powers of two (e.g. 250, 384) downsample exactly and are accepted.
+func ValidateTileSize(size int) error {
+	if size < 2 || size > MaxTileSize || size%2 != 0 {
+		return fmt.Errorf("tile size %d must be an even number between 2 and %d", size, MaxTileSize)
+	}
+	return nil
+}
+
+// IsPowerOfTwo reports whether n is a positive power of two.
+func IsPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
 // Config holds tile generation configuration.
 type Config struct {
 	MinZoom          int
@@ -75,11 +111,172 @@ type Config struct {
 	Encoder          encode.Encoder
 	Bounds           cog.Bounds
 	Resampling       Resampling
-	ResamplingGamma  float64     // power-law gamma for resampling interpolation (1.0 = disabled)
-	IsTerrarium      bool        // true for float GeoTIFF → Terrarium encoding
-	FillColor        *color.RGBA // when set, transparent/nodata pixels → fill color; missing tiles → solid fill
-	MemoryLimitBytes int64       // max tile store memory before disk spilling (0 = auto)
-	OutputDir        string      // directory for spill files (defaults to OS temp dir)
+	ResamplingGamma  float64            // power-law gamma for resampling interpolation (1.0 = disabled)
+	IsTerrarium      bool               // true for float GeoTIFF → elevation encoding (Terrarium, or DEMEncoding)
+	DEMEncoding      encode.DEMEncoding // with IsTerrarium: how elevations are packed into RGB (default Terrarium)
+	FillVoids        int                // Terrarium and hillshade: fill NaN holes of up to this many output pixels when rendering (0 = off)
+	Hillshade        *Hillshade         // when set, float sources are rendered as shaded relief instead of Terrarium
+	ColorMap         *ColorMap          // when set, single-band sources are read as values and colored (--color-map)
+	FillColor        *color.RGBA        // when set, transparent/nodata pixels → fill color; missing tiles → solid fill (unless Overlay)
+	Overlay          bool               // layer is drawn over another: missing tiles stay missing, FillColor only recolors pixels
+	Background       *color.RGBA        // when set, tiles are composited over this color at encode time (opaque output)
+	PremultiplyAlpha bool               // store translucent tiles with premultiplied colors (encode.Premultiply, PNG and WebP)
+	MemoryLimitBytes int64              // max tile store memory before disk spilling (0 = auto)
+	OutputDir        string             // directory for spill files (defaults to OS temp dir)
+	// OwnSpillFiles makes the tile stores spill into files of their own in
+	// OutputDir even when the writer's tile data could back them (see
+	// TileLocator), e.g. because OutputDir is a faster disk.
+	OwnSpillFiles bool
+	// SpillFormat is what the tile stores keep of each tile for the
+	// parents: SpillEncoded (or "") the output bytes, decoded on read-back,
+	// or SpillRaw the pixels, several times larger in memory and on disk
+	// but read back without a decode (see NewTileCodec). Raw stores keep
+	// spill files of their own.
+	SpillFormat string
+	// TileOrder is the space-filling curve along which workers take the
+	// tiles of each zoom level: tileorder.Hilbert (the zero value) or
+	// tileorder.ZOrder. It changes only the processing order and thus
+	// cache behaviour, not the output.
+	TileOrder tileorder.Curve
+	// OrderedWrites passes the max-zoom tiles to the writer in tile-ID
+	// order instead of as workers finish them, for writers that keep the
+	// data in write order (pmtiles.WriterOptions.Streaming). Tiles that
+	// finish early are held in memory until those before them are done.
+	OrderedWrites bool
+
+	// Backfill lists sources sampled only for pixels that no source has
+	// data for, after all of them, e.g. existing archives (PMTilesSource)
+	// under new imagery. Sources in another CRS than the sources' are
+	// reprojected per pixel; their EPSG code must be one of coord.ForEPSG.
+	// Images only: float rendering (Terrarium, hillshade, color maps)
+	// ignores them.
+	Backfill []RasterSource
+
+	// InputOrder makes overlapping sources take priority in input order.
+	// By default each tile prefers the source whose resolution best
+	// matches the output and falls back to coarser ones only for pixels
+	// the finer ones leave uncovered.
+	InputOrder bool
+
+	// LevelByLevel finishes each zoom level before starting the next one,
+	// instead of downsampling parents as soon as their children exist.
+	LevelByLevel bool
+
+	// Sharpen lists the zoom ranges whose parent tiles get the local
+	// contrast back that averaging their children removed (see
+	// sharpenDownsampled). Ignored for Terrarium and for the nearest and
+	// mode resampling methods, which do not average.
+	Sharpen []SharpenRange
+
+	// ZoomEncoders overrides Encoder for individual zoom levels (e.g. the
+	// per-zoom qualities chosen by PlanQuality). All encoders must produce
+	// the same format as Encoder.
+	ZoomEncoders map[int]encode.Encoder
+
+	// MaxTileBytes re-encodes tiles larger than this many bytes at stepped
+	// down qualities until they fit (see shrinkTile; 0 = no limit).
+	MaxTileBytes int
+
+	// NaNReport keeps the tiles with the most NaN sampling fallbacks, up to
+	// this many per zoom, in Stats.NaNFallbacks (0 = counts only).
+	NaNReport int
+
+	// SourceCache reuses decoded COG tiles across runs over the same
+	// sources (nil = a fresh cache per run).
+	SourceCache *SourceCache
+
+	// SourceCacheTiles is the number of decoded COG tiles a fresh source
+	// cache keeps (0 = 128 per worker, at least 256). The compressed tier
+	// behind it is set on the sources (cog.Reader.SetRawCache).
+	SourceCacheTiles int
+
+	// DebugOverlay, when set, draws tile boundaries, labels, and an
+	// optional graticule onto every written tile (not valid for Terrarium).
+	DebugOverlay *DebugOverlay
+
+	// Regions, when set, restricts generation to the tiles intersecting
+	// these boxes (see RegionTiles) instead of all tiles in Bounds. Used by
+	// incremental runs to regenerate only what changed inputs affect.
+	Regions []cog.Bounds
+
+	// Dirty, when set, restricts generation to its tiles, in addition to
+	// Regions. Marking a changed max-zoom tile marks all its ancestors, so
+	// the pyramid above an edit is regenerated along with it.
+	Dirty *DirtySet
+
+	// Previous supplies the tiles of the last run, encoded like Encoder's.
+	// With Regions or Dirty, a parent downsampled from children that are
+	// not regenerated reads them from Previous instead of treating them as
+	// empty.
+	Previous TileReader
+
+	// EncodeCacheBytes, when > 0, keeps up to this many bytes of encoded
+	// tiles by content, so that tiles repeating one already encoded skip
+	// the encoder (see encodeCache). The writer's deduplication only saves
+	// their storage.
+	EncodeCacheBytes int64
+
+	// Checkpoint, when set, saves the run's state after every finished
+	// zoom level, or resumes it from a saved one. Implies LevelByLevel;
+	// only valid for a single layer.
+	Checkpoint *Checkpoint
+
+	// Quiet turns off the per-zoom progress bars, for generations running
+	// side by side whose bars would overwrite each other.
+	Quiet bool
+}
+
+// TileReader reads encoded tiles (implemented by pmtiles.Reader).
+type TileReader interface {
+	// ReadTile returns the encoded tile, or nil, nil if it does not exist.
+	ReadTile(z, x, y int) ([]byte, error)
+}
+
+// encoderForZoom returns the encoder to use for tiles at zoom z.
+func (c *Config) encoderForZoom(z int) encode.Encoder {
+	if enc, ok := c.ZoomEncoders[z]; ok {
+		return c.outputEncoder(enc)
+	}
+	return c.outputEncoder(c.Encoder)
+}
+
+// floatSources reports whether the sources are read as float values
+// (Terrarium, hillshade, and color maps) rather than as image bands.
+func (c *Config) floatSources() bool {
+	return c.IsTerrarium || c.Hillshade != nil || c.ColorMap != nil
+}
+
+// backfill returns the Backfill sources, or none when the sources are
+// read as values, which backfill sources need not have.
+func (c *Config) backfill() []RasterSource {
+	if c.floatSources() {
+		return nil
+	}
+	return c.Backfill
+}
+
+// sharpenForZoom returns the Sharpen strength for parent tiles at zoom z
+// (0 = plain downsampling).
+func (c *Config) sharpenForZoom(z int) float64 {
+	if c.IsTerrarium || c.Resampling == ResamplingNearest || c.Resampling == ResamplingMode {
+		return 0
+	}
+	return sharpenStrength(c.Sharpen, z)
+}
+
+// outputEncoder wraps enc to premultiply alpha and composite over
+// Background, when set. Stored tiles keep whatever the encoder produced,
+// so with JPEG the children read back for downsampling are already
+// flattened and parents stay consistent; premultiplied tiles are read back
+// through the codec (EncodedCodec.Premultiplied).
+func (c *Config) outputEncoder(enc encode.Encoder) encode.Encoder {
+	if c.PremultiplyAlpha {
+		enc = encode.Premultiply(enc)
+	}
+	if c.Background == nil {
+		return enc
+	}
+	return encode.Flatten(enc, *c.Background)
 }
 
 // Stats holds generation statistics.
@@ -88,13 +285,60 @@ type Stats struct {
 	EmptyTiles   int64
 	UniformTiles int64
 	TotalBytes   int64
+	// EncodeCacheHits counts tiles whose encoding came from the encoding
+	// cache (Config.EncodeCacheBytes).
+	EncodeCacheHits int64
+	// OversizedTiles counts tiles that encoded to more than
+	// Config.MaxTileBytes; OversizedLeft those still larger after
+	// re-encoding at MinBudgetQuality (or in a format without quality).
+	OversizedTiles int64
+	OversizedLeft  int64
+	// NaNFallbacks counts, per zoom rendered from float sources, the
+	// samples that fell back to nearest-neighbour on nodata taps.
+	NaNFallbacks []NaNFallbacks
+	Timing       Timing
 }
 
 // TileWriter is the interface for writing tiles (implemented by pmtiles.Writer).
+// WriteTile must not retain data after it returns: Generate reuses the
+// buffer for the worker's next tile.
 type TileWriter interface {
 	WriteTile(z, x, y int, data []byte) error
 }
 
+// ZoomCompleter is optionally implemented by a TileWriter that wants to know
+// when every tile of a zoom level has been written (e.g. pmtiles.Writer, to
+// expose finished levels for preview while lower zooms are generated).
+type ZoomCompleter interface {
+	CompleteZoom(z int)
+}
+
+// TileLocator is optionally implemented by a TileWriter that keeps the
+// written tile data in a file it can read back (pmtiles.Writer). The tile
+// stores of a spilling run then read tiles back from that file instead of
+// writing a second copy to spill files of their own.
+type TileLocator interface {
+	// WriteTileLocated writes a tile like WriteTile and returns the offset
+	// of its bytes in DataReader, or -1 if it wrote none.
+	WriteTileLocated(z, x, y int, data []byte) (int64, error)
+	// DataReader returns a reader of the written tile data, or nil if the
+	// writer cannot provide one (e.g. a wrapper around one that cannot).
+	DataReader() io.ReaderAt
+}
+
+// childKeys returns the 2×2 child tile keys of each parent in batch,
+// in the order the downsample workers will request them.
+func childKeys(batch [][3]int) [][3]int {
+	keys := make([][3]int, 0, 4*len(batch))
+	for _, t := range batch {
+		cz, cx, cy := t[0]+1, 2*t[1], 2*t[2]
+		keys = append(keys,
+			[3]int{cz, cx, cy}, [3]int{cz, cx + 1, cy},
+			[3]int{cz, cx, cy + 1}, [3]int{cz, cx + 1, cy + 1})
+	}
+	return keys
+}
+
 // Generate produces tiles for all zoom levels and writes them via the TileWriter.
 //
 // The pipeline uses a pyramid approach:
@@ -103,30 +347,50 @@ type TileWriter interface {
 //
 // This avoids redundant COG reads and coordinate transforms for lower zoom levels.
 //
+// By default the levels are pipelined (see runPipelined): a parent is
+// downsampled as soon as its four children are done, and the children are
+// dropped from the tile store once consumed. Config.LevelByLevel completes
+// each zoom level before starting the next instead.
+//
 // When memory pressure is high (configurable via Config.MemoryLimitBytes), the
 // tile store spills to a temporary file on disk. Tiles are stored along the
 // Hilbert curve for spatial locality during the downsampling read-back pass.
 func Generate(cfg Config, sources []*cog.Reader, writer TileWriter) (Stats, error) {
-	if len(sources) == 0 {
-		return Stats{}, fmt.Errorf("no source files")
+	stats, err := GenerateLayers([]Layer{{Config: cfg, Sources: sources, Writer: writer}})
+	if err != nil {
+		return Stats{}, err
 	}
+	return stats[0], nil
+}
 
-	// Determine the projection from the first source.
-	epsg := sources[0].EPSG()
-	proj := coord.ForEPSG(epsg)
-	if proj == nil {
-		return Stats{}, fmt.Errorf("unsupported EPSG code: %d", epsg)
-	}
+// Layer is one output of GenerateLayers: a set of sources rendered with its
+// own Config into its own writer.
+type Layer struct {
+	Config  Config
+	Sources []*cog.Reader
+	Writer  TileWriter
+}
 
-	// Create shared COG tile caches for the max-zoom rendering pass.
-	cacheSize := cfg.Concurrency * 128
-	if cacheSize < 256 {
-		cacheSize = 256
+// GenerateLayers generates several archives in one pass over the pyramid,
+// e.g. orthophoto imagery and Terrarium terrain of the same area. The layers
+// share one Hilbert-ordered schedule: each worker produces a tile for every
+// layer back to back, so the sources of that area are read while they are
+// hot in the caches, and layers whose sources are in the same CRS project
+// each max-zoom pixel once. Tile stores, encoders, and counts stay per
+// layer; the memory limit is split between the layers' stores.
+//
+// The schedule (zoom range, tile size, bounds, concurrency, LevelByLevel,
+// memory limit, spill directory, verbosity) is taken from the first layer's
+// Config. The other layers must use the same zoom range and tile size.
+//
+// Returns one Stats per layer; their Timing is that of the whole pass.
+func GenerateLayers(layers []Layer) ([]Stats, error) {
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("no layers")
 	}
-	cogCache := cog.NewTileCache(cacheSize)
-	var floatCache *cog.FloatTileCache
-	if cfg.IsTerrarium {
-		floatCache = cog.NewFloatTileCache(cacheSize)
+	cfg := layers[0].Config
+	if err := ValidateTileSize(cfg.TileSize); err != nil {
+		return nil, err
 	}
 
 	// Compute memory limit for disk spilling.
@@ -138,25 +402,128 @@ func Generate(cfg Config, sources []*cog.Reader, writer TileWriter) (Stats, erro
 		memLimit = ComputeMemoryLimit(DefaultMemoryPressurePercent, cfg.Verbose)
 	}
 
-	// Tile image store: holds decoded tiles for the current zoom level
-	// so the next (lower) zoom level can downsample from them.
-	// The initial store is a lightweight placeholder (no I/O goroutine)
-	// because the max-zoom level renders from COG sources, not from a
-	// previous store. Each zoom level creates its own store with disk
-	// spilling enabled.
-	store := NewDiskTileStore(DiskTileStoreConfig{
-		InitialCapacity: 64,
-		TileSize:        cfg.TileSize,
-	})
-	defer store.Close()
+	if cfg.Checkpoint != nil && len(layers) > 1 {
+		return nil, fmt.Errorf("checkpoints support a single layer, got %d", len(layers))
+	}
+
+	p := &pass{cfg: cfg}
+	if len(cfg.Regions) > 0 || cfg.Dirty != nil {
+		p.regen = NewDirtySet(cfg.MinZoom)
+		p.regen.Merge(cfg.Dirty)
+		p.regen.MarkRegions(cfg.MaxZoom, cfg.Regions)
+	}
+	gridEPSG, gridUsers := 0, 0
+	for i, l := range layers {
+		lc := l.Config
+		if lc.MinZoom != cfg.MinZoom || lc.MaxZoom != cfg.MaxZoom || lc.TileSize != cfg.TileSize {
+			return nil, fmt.Errorf("layer %d: zoom %d-%d, tile size %d differ from layer 0 (zoom %d-%d, tile size %d)",
+				i, lc.MinZoom, lc.MaxZoom, lc.TileSize, cfg.MinZoom, cfg.MaxZoom, cfg.TileSize)
+		}
+		g, err := newGeneration(lc, l.Sources, l.Writer)
+		if err != nil {
+			if len(layers) > 1 {
+				return nil, fmt.Errorf("layer %d: %w", i, err)
+			}
+			return nil, err
+		}
+		g.memLimit = memLimit / int64(len(layers))
+		g.useWriterData()
+		g.regen = p.regen
+		p.layers = append(p.layers, g)
+
+		// The first CRS among layers that render from the grid gets one.
+		if g.usesGrid() {
+			if gridUsers == 0 {
+				gridEPSG = g.proj.EPSG()
+			}
+			if g.proj.EPSG() == gridEPSG {
+				gridUsers++
+			}
+		}
+	}
+	// Layers in different user-defined CRSs share the code: give them none.
+	if gridUsers > 1 && gridEPSG != coord.UserDefined {
+		for _, g := range p.layers {
+			g.sharedGrid = g.usesGrid() && g.proj.EPSG() == gridEPSG
+		}
+	}
+
+	var timing Timing
+	var err error
+	start := time.Now()
+	if cfg.LevelByLevel || cfg.Checkpoint != nil {
+		timing, err = p.runLevels()
+	} else {
+		timing, err = p.runPipelined()
+	}
+	if err != nil {
+		return nil, err
+	}
+	timing.Wall = time.Since(start)
+
+	stats := make([]Stats, len(p.layers))
+	for i, g := range p.layers {
+		stats[i] = Stats{
+			TileCount:    g.tileCount.Load(),
+			EmptyTiles:   g.emptyCount.Load(),
+			UniformTiles: g.uniformCount.Load(),
+			TotalBytes:   g.totalBytes.Load(),
+			Timing:       timing,
 
-	var tileCount, emptyCount, uniformCount, grayCount, totalBytes atomic.Int64
+			OversizedTiles: g.oversized.Load(),
+			OversizedLeft:  g.oversizedLeft.Load(),
+			NaNFallbacks:   g.nan.stats(),
+		}
+		if g.encodeCache != nil {
+			stats[i].EncodeCacheHits = g.encodeCache.hits.Load()
+		}
+	}
+	return stats, nil
+}
+
+// newGeneration prepares the per-layer state of a run: projection, source
+// caches, encoders, gamma tables, and the pre-encoded fill tile.
+func newGeneration(cfg Config, sources []*cog.Reader, writer TileWriter) (*generation, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no source files")
+	}
+
+	// Determine the projection from the first source.
+	proj, err := sources[0].Projection()
+	if err != nil {
+		return nil, err
+	}
+
+	// Shared COG tile caches for the max-zoom rendering pass.
+	sc := cfg.SourceCache
+	if sc == nil || (cfg.floatSources() && sc.floats == nil) {
+		sc = NewSourceCache(cfg)
+	}
+
+	g := &generation{
+		cfg:        cfg,
+		sources:    sources,
+		writer:     writer,
+		proj:       proj,
+		cogCache:   sc.tiles,
+		floatCache: sc.floats,
+		encoders:   make(map[int]encode.Encoder),
+	}
+	g.nan.keep = cfg.NaNReport
+	if g.codec, err = NewTileCodec(cfg.SpillFormat, cfg.Encoder.Format(), cfg.TileSize, false, cfg.PremultiplyAlpha); err != nil {
+		return nil, err
+	}
+	for z := cfg.MinZoom; z <= cfg.MaxZoom; z++ {
+		g.encoders[z] = cfg.encoderForZoom(z)
+	}
+	if cfg.EncodeCacheBytes > 0 {
+		g.encodeCache = newEncodeCache(cfg.EncodeCacheBytes)
+	}
 
 	// Build gamma lookup tables for resampling interpolation.
-	// nil when gamma correction is disabled (gamma == 1.0 or terrarium mode).
-	var resamplingLUTs *gammaLUTs
-	if !cfg.IsTerrarium {
-		resamplingLUTs = buildGammaLUTs(cfg.ResamplingGamma)
+	// nil when gamma correction is disabled (gamma == 1.0 or float sources).
+	if !cfg.floatSources() {
+		g.luts = buildGammaLUTs(cfg.ResamplingGamma)
 	}
 
 	// Pre-encode the fill-color tile once so identical fill tiles across all
@@ -164,24 +531,606 @@ func Generate(cfg Config, sources []*cog.Reader, writer TileWriter) (Stats, erro
 	// For uniform tiles, DiskTileStore.Put ignores encoded bytes (stores compact
 	// TileData), so this cache is only used for WriteTile.
 	// The slice is read-only after creation and safe for concurrent access.
-	var (
-		fillTileShared  *TileData // shared uniform fill tile (immutable, safe to share)
-		fillColorCached []byte    // pre-encoded bytes for the fill tile
-	)
-	if cfg.FillColor != nil {
-		fillTileShared = newTileDataUniform(*cfg.FillColor, cfg.TileSize)
+	// Overlays fill no missing tiles and need no fill tile.
+	if cfg.FillColor != nil && !cfg.Overlay {
+		g.fillTile = newTileDataUniform(*cfg.FillColor, cfg.TileSize)
 		var encErr error
-		fillColorCached, encErr = cfg.Encoder.Encode(fillTileShared.AsImage())
+		g.fillEncoded, encErr = cfg.outputEncoder(cfg.Encoder).Encode(g.fillTile.AsImage())
 		if encErr != nil {
-			return Stats{}, fmt.Errorf("encoding fill color tile: %w", encErr)
+			return nil, fmt.Errorf("encoding fill color tile: %w", encErr)
+		}
+	}
+	return g, nil
+}
+
+// pass is one run over the pyramid producing the tiles of every layer.
+type pass struct {
+	cfg    Config // schedule settings, from the first layer
+	layers []*generation
+	regen  *DirtySet // tiles of cfg.Regions and cfg.Dirty (nil = everything)
+}
+
+// newWorker returns the per-goroutine state for all layers. Layers with
+// sharedGrid get the same crsGrid; all layers share one sampleScratch.
+func (p *pass) newWorker() []*tileWorker {
+	var grid *crsGrid
+	scratch := new(sampleScratch)
+	ws := make([]*tileWorker, len(p.layers))
+	for i, g := range p.layers {
+		ws[i] = &tileWorker{g: g, scratch: scratch}
+		if g.sharedGrid {
+			if grid == nil {
+				grid = &crsGrid{}
+			}
+			ws[i].grid = grid
 		}
 	}
+	return ws
+}
 
-	// Process zoom levels from highest to lowest (pyramid approach).
-	for z := cfg.MaxZoom; z >= cfg.MinZoom; z-- {
-		tiles := coord.TilesInBounds(z,
-			cfg.Bounds.MinLon, cfg.Bounds.MinLat,
-			cfg.Bounds.MaxLon, cfg.Bounds.MaxLat)
+// processTile produces tile t for every layer from the layer's stores (see
+// tileWorker.processTile). Reports whether any layer wrote a tile.
+func (p *pass) processTile(ws []*tileWorker, t [3]int, src, dst []*DiskTileStore, consume bool, zt *zoomTimer) (bool, error) {
+	written := false
+	for i, w := range ws {
+		ok, err := w.processTile(t, src[i], dst[i], consume, zt)
+		if err == nil {
+			err = w.g.order.done(t)
+		}
+		if err != nil {
+			if len(ws) > 1 {
+				return false, fmt.Errorf("layer %d: %w", i, err)
+			}
+			return false, err
+		}
+		written = written || ok
+	}
+	return written, nil
+}
+
+// orderWrites puts an orderedWriter in front of the writer of every layer
+// with Config.OrderedWrites, for the max-zoom tiles of the run.
+func (p *pass) orderWrites(tiles [][3]int) {
+	for _, g := range p.layers {
+		if g.cfg.OrderedWrites {
+			g.order = newOrderedWriter(g.writer, tiles, p.cfg.TileOrder)
+			g.writer = g.order
+		}
+	}
+}
+
+// newStores creates one tile store per layer.
+func (p *pass) newStores(capacity int, spillOnPressure bool) []*DiskTileStore {
+	stores := make([]*DiskTileStore, len(p.layers))
+	for i, g := range p.layers {
+		stores[i] = g.newStore(capacity, spillOnPressure)
+	}
+	return stores
+}
+
+// completeZoom notifies every layer's writer that zoom z is fully written.
+func (p *pass) completeZoom(z int) {
+	for _, g := range p.layers {
+		g.completeZoom(z)
+	}
+}
+
+// logZoomDone logs the tile counts of all layers after zoom z and the state
+// of their stores.
+func (p *pass) logZoomDone(z int, stores []*DiskTileStore) {
+	var tiles, gray, uniform, empty int64
+	for _, g := range p.layers {
+		tiles += g.tileCount.Load()
+		gray += g.grayCount.Load()
+		uniform += g.uniformCount.Load()
+		empty += g.emptyCount.Load()
+	}
+	log.Printf("Zoom %d: completed (%d tiles so far, %d gray, %d uniform, %d empty)",
+		z, tiles, gray, uniform, empty)
+	for _, st := range stores {
+		log.Printf("  Store: %s", st.Stats())
+	}
+}
+
+// tileCount returns the number of tiles written so far by all layers.
+func (p *pass) tileCount() int64 {
+	var n int64
+	for _, g := range p.layers {
+		n += g.tileCount.Load()
+	}
+	return n
+}
+
+// zoomTiles returns the tiles of zoom z within the configured bounds, sorted
+// along the curve of Config.TileOrder (Hilbert by default) so that workers
+// process spatially nearby tiles consecutively. This dramatically improves
+// COG tile cache hit rates because the active working set stays in a
+// compact 2D region rather than spanning full rows.
+func (p *pass) zoomTiles(z int) [][3]int {
+	b := p.cfg.Bounds
+	var tiles [][3]int
+	if p.regen != nil {
+		// Dirty tiles outside Bounds would not exist in a full run.
+		ranges := boundsTileRanges(z, b)
+		for _, t := range p.regen.Tiles(z) {
+			for _, r := range ranges {
+				if t[1] >= r[0] && t[2] >= r[1] && t[1] <= r[2] && t[2] <= r[3] {
+					tiles = append(tiles, t)
+					break
+				}
+			}
+		}
+	} else {
+		tiles = coord.TilesInBounds(z, b.MinLon, b.MinLat, b.MaxLon, b.MaxLat)
+	}
+	tileorder.Sort(tiles, p.cfg.TileOrder)
+	return tiles
+}
+
+// boundsTileRanges returns the inclusive tile ranges (min x, min y, max x,
+// max y) that TilesInBounds covers at zoom z: one, or two for bounds across
+// the antimeridian.
+func boundsTileRanges(z int, b cog.Bounds) [][4]int {
+	spans := [][2]float64{{b.MinLon, b.MaxLon}}
+	if b.MinLon > b.MaxLon {
+		spans = [][2]float64{{b.MinLon, 180}, {-180, b.MaxLon}}
+	}
+	var ranges [][4]int
+	for _, sp := range spans {
+		minX, minY, maxX, maxY := coord.TileRange(z, sp[0], b.MinLat, sp[1], b.MaxLat, coord.EdgeExclusive)
+		ranges = append(ranges, [4]int{minX, minY, maxX, maxY})
+	}
+	return ranges
+}
+
+// CountTiles returns the number of tiles the bounds cover over the zoom
+// range minZoom..maxZoom, without enumerating them.
+func CountTiles(minZoom, maxZoom int, b cog.Bounds) int64 {
+	var n int64
+	for z := minZoom; z <= maxZoom; z++ {
+		n += zoomTileCount(z, b)
+	}
+	return n
+}
+
+// zoomTileCount returns the number of tiles the bounds cover at zoom z.
+func zoomTileCount(z int, b cog.Bounds) int64 {
+	var n int64
+	for _, r := range boundsTileRanges(z, b) {
+		n += int64(r[2]-r[0]+1) * int64(r[3]-r[1]+1)
+	}
+	return n
+}
+
+// RegionTiles returns the tiles of zoom z intersecting any of regions, each
+// once, in Hilbert order.
+func RegionTiles(z int, regions []cog.Bounds) [][3]int {
+	seen := make(map[[3]int]struct{})
+	var tiles [][3]int
+	for _, b := range regions {
+		for _, t := range coord.TilesInBounds(z, b.MinLon, b.MinLat, b.MaxLon, b.MaxLat) {
+			if _, ok := seen[t]; !ok {
+				seen[t] = struct{}{}
+				tiles = append(tiles, t)
+			}
+		}
+	}
+	tileorder.Sort(tiles, tileorder.Hilbert)
+	return tiles
+}
+
+// generation is the state of one layer of a run, shared by all workers.
+type generation struct {
+	cfg         Config
+	sources     []*cog.Reader
+	writer      TileWriter
+	proj        coord.Projection
+	cogCache    *cog.TileCache
+	floatCache  *cog.FloatTileCache
+	luts        *gammaLUTs
+	encoders    map[int]encode.Encoder // per zoom, see Config.encoderForZoom
+	encodeCache *encodeCache           // nil unless Config.EncodeCacheBytes > 0
+	memLimit    int64                  // spill threshold for tile stores (0 = never spill)
+	sharedGrid  bool                   // max-zoom pixels are projected via the worker's crsGrid
+	locator     TileLocator            // writer whose tile data backs the stores, see useWriterData
+	backing     io.ReaderAt            // locator's DataReader
+	codec       TileCodec              // what the tile stores keep (Config.SpillFormat)
+	regen       *DirtySet              // see pass.regen
+	order       *orderedWriter         // wraps writer with Config.OrderedWrites, see pass.orderWrites
+
+	fillTile    *TileData // shared uniform fill tile (immutable, safe to share)
+	fillEncoded []byte    // pre-encoded bytes for the fill tile
+
+	tileCount, emptyCount, uniformCount, grayCount, totalBytes atomic.Int64
+	oversized, oversizedLeft                                   atomic.Int64 // see Stats.OversizedTiles
+	nan                                                        nanTracker   // see Stats.NaNFallbacks
+}
+
+// newStore creates a tile store for downsampling reads, spilling to disk
+// under the run's memory limit.
+func (g *generation) newStore(capacity int, spillOnPressure bool) *DiskTileStore {
+	return NewDiskTileStore(g.storeConfig(capacity, spillOnPressure))
+}
+
+// storeConfig returns the configuration of the layer's tile stores.
+func (g *generation) storeConfig(capacity int, spillOnPressure bool) DiskTileStoreConfig {
+	return DiskTileStoreConfig{
+		InitialCapacity:  capacity,
+		TileSize:         g.cfg.TileSize,
+		TempDir:          g.cfg.OutputDir,
+		MemoryLimitBytes: g.memLimit,
+		Format:           g.cfg.Encoder.Format(),
+		Codec:            g.codec,
+		Backing:          g.backing,
+		SpillOnPressure:  spillOnPressure,
+		Verbose:          g.cfg.Verbose,
+	}
+}
+
+// useWriterData makes the layer's tile stores spill into the writer's tile
+// data when they spill at all and the writer is a TileLocator: the bytes a
+// store keeps for the parents are the bytes written to the archive, so
+// every tile reaches the disk once. A debug overlay, a tile filter, or
+// contour lines write other bytes than the stored ones, raw stores
+// (SpillRaw) keep pixels, and ordered writes (Config.OrderedWrites) reach
+// the writer after the store needs their place; the stores then keep spill
+// files of their own, as they do with Config.OwnSpillFiles.
+func (g *generation) useWriterData() {
+	l, ok := g.writer.(TileLocator)
+	if !ok || g.memLimit == 0 || g.cfg.DebugOverlay != nil || g.cfg.OwnSpillFiles || g.cfg.SpillFormat == SpillRaw || g.cfg.OrderedWrites {
+		return
+	}
+	if r := l.DataReader(); r != nil {
+		g.locator, g.backing = l, r
+		if g.cfg.Verbose {
+			log.Printf("Tile stores spill into the archive's tile data")
+		}
+	}
+}
+
+// previousTile returns tile z/x/y of Config.Previous, decoded, if it lies
+// outside the regenerated tiles; nil if it is among them, missing, or there
+// is no previous run.
+func (g *generation) previousTile(z, x, y int) (*TileData, error) {
+	if g.cfg.Previous == nil {
+		return nil, nil
+	}
+	if g.regen.Contains(z, x, y) {
+		return nil, nil
+	}
+	data, err := g.cfg.Previous.ReadTile(z, x, y)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	img, err := encode.DecodeImage(data, g.cfg.Encoder.Format())
+	if err != nil {
+		return nil, fmt.Errorf("decoding previous tile z%d/%d/%d: %w", z, x, y, err)
+	}
+	if g.cfg.PremultiplyAlpha {
+		img = encode.FromPremultiplied(img)
+	}
+	return newTileData(imageToRGBA(img, false), g.cfg.TileSize), nil
+}
+
+// usesGrid reports whether max-zoom tiles of this layer are rendered from
+// per-pixel CRS coordinates of the tile itself, which a crsGrid can supply.
+// Void filling, hillshading, and color maps sample values on a grid of
+// their own.
+func (g *generation) usesGrid() bool {
+	return !(g.cfg.IsTerrarium && g.cfg.FillVoids > 0) && g.cfg.Hillshade == nil && g.cfg.ColorMap == nil
+}
+
+// tileWorker is the per-goroutine state for producing tiles.
+type tileWorker struct {
+	g *generation
+
+	// Source info, built on the first rendered tile (read-only after init).
+	srcInfos []sourceInfo
+
+	// grid, when set, is shared with the worker's other layers in the same
+	// CRS (see GenerateLayers).
+	grid *crsGrid
+
+	// scratch holds the sampling temporaries, shared with the worker's
+	// other layers (tiles are rendered one at a time).
+	scratch *sampleScratch
+
+	// Output buffers, reused for tiles that are only written. Tiles handed
+	// to the store are retained by it, so they get their own slice,
+	// pre-sized from the worker's previous tile to avoid growing (and
+	// copying) it while encoding.
+	outBuf, overlayBuf []byte
+	sizeHint           int
+}
+
+// processTile produces tile t: rendered from the sources at max zoom,
+// downsampled from its children in src otherwise. The tile is encoded and
+// written, and kept in dst for its parent unless t is at MinZoom. With
+// consume, the children are deleted from src once downsampled; that is only
+// safe when each child is read by exactly one parent. Phase durations are
+// added to zt. Reports whether a tile was written (false for empty tiles).
+func (w *tileWorker) processTile(t [3]int, src, dst *DiskTileStore, consume bool, zt *zoomTimer) (bool, error) {
+	g := w.g
+	cfg := &g.cfg
+	z, x, y := t[0], t[1], t[2]
+	var td *TileData
+	phaseStart := time.Now()
+
+	if z == cfg.MaxZoom {
+		if w.srcInfos == nil {
+			w.srcInfos = buildSourceInfos(g.sources, cfg.backfill(), g.proj, !cfg.InputOrder)
+		}
+		var img *image.RGBA
+		w.scratch.kernel.nanFallbacks = 0
+		switch {
+		case cfg.Hillshade != nil:
+			img = renderTileHillshade(z, x, y, cfg.TileSize, w.srcInfos, g.proj, w.scratch, g.floatCache, cfg.Resampling, cfg.FillVoids, *cfg.Hillshade)
+		case cfg.ColorMap != nil:
+			img = renderTileColorMap(z, x, y, cfg.TileSize, w.srcInfos, g.proj, w.scratch, g.floatCache, cfg.Resampling, cfg.ColorMap)
+		case cfg.IsTerrarium:
+			img = renderTileTerrarium(z, x, y, cfg.TileSize, w.srcInfos, g.proj, w.grid, w.scratch, g.floatCache, cfg.Resampling, cfg.FillVoids, cfg.DEMEncoding)
+		default:
+			img = renderTile(z, x, y, cfg.TileSize, w.srcInfos, g.proj, w.grid, w.scratch, g.cogCache, cfg.Resampling, g.luts)
+		}
+		if n := w.scratch.kernel.nanFallbacks; n > 0 {
+			g.nan.record(z, x, y, n)
+		}
+		if img != nil {
+			if cfg.FillColor != nil {
+				applyFillColorTransform(img, *cfg.FillColor)
+			}
+			td = newTileData(img, cfg.TileSize)
+		} else if cfg.FillColor != nil && !cfg.Overlay {
+			td = newTileDataUniform(*cfg.FillColor, cfg.TileSize)
+		}
+		phaseStart = zt.since(&zt.render, phaseStart)
+	} else {
+		childZ := z + 1
+		tl := src.Get(childZ, 2*x, 2*y)
+		tr := src.Get(childZ, 2*x+1, 2*y)
+		bl := src.Get(childZ, 2*x, 2*y+1)
+		br := src.Get(childZ, 2*x+1, 2*y+1)
+		if err := src.Err(); err != nil {
+			return false, fmt.Errorf("downsampling tile z%d/%d/%d: %w", z, x, y, err)
+		}
+		if cfg.Previous != nil {
+			// Children outside the dirty set were not regenerated.
+			for i, c := range []**TileData{&tl, &tr, &bl, &br} {
+				if *c == nil {
+					var err error
+					if *c, err = g.previousTile(childZ, 2*x+i%2, 2*y+i/2); err != nil {
+						return false, err
+					}
+				}
+			}
+		}
+		if g.fillTile != nil {
+			// Reuse the shared fill tile instead of allocating
+			// a new uniform TileData per nil child.
+			if tl == nil {
+				tl = g.fillTile
+			}
+			if tr == nil {
+				tr = g.fillTile
+			}
+			if bl == nil {
+				bl = g.fillTile
+			}
+			if br == nil {
+				br = g.fillTile
+			}
+		}
+		if cfg.IsTerrarium {
+			td = downsampleTileTerrarium(tl, tr, bl, br, cfg.TileSize, cfg.Resampling, cfg.DEMEncoding)
+		} else {
+			td = downsampleTile(tl, tr, bl, br, cfg.TileSize, cfg.Resampling)
+			if s := cfg.sharpenForZoom(z); s > 0 {
+				td = sharpenDownsampled(td, [4]*TileData{tl, tr, bl, br}, cfg.TileSize, s)
+			}
+		}
+		releaseChildren(tl, tr, bl, br)
+		if consume {
+			for _, k := range childKeys([][3]int{t}) {
+				src.Delete(k[0], k[1], k[2])
+			}
+		}
+		phaseStart = zt.since(&zt.downsample, phaseStart)
+	}
+
+	if td == nil {
+		g.emptyCount.Add(1)
+		return false, nil
+	}
+
+	if td.IsUniform() {
+		g.uniformCount.Add(1)
+	} else if td.IsGray() {
+		g.grayCount.Add(1)
+	}
+
+	// Encode the tile. Uniform fill-color tiles reuse pre-encoded bytes to
+	// avoid redundant encoder calls; the PMTiles writer deduplicates
+	// identical content anyway, but skipping re-encoding saves CPU for
+	// sparse datasets.
+	enc := g.encoders[z]
+	var data []byte
+	var cacheKey encodeKey
+	if g.fillEncoded != nil && td.IsUniform() && td.Color() == *cfg.FillColor {
+		data = g.fillEncoded
+	} else if g.encodeCache != nil {
+		cacheKey = g.encodeCache.key(g.encoderID(z), td)
+		data = g.encodeCache.get(cacheKey)
+	}
+	if data == nil {
+		// The store keeps only the TileData of uniform tiles, and no
+		// bytes when it reads them back from the writer's.
+		stored := z > cfg.MinZoom && !td.IsUniform() && g.locator == nil
+		buf := w.outBuf[:0]
+		if stored {
+			buf = make([]byte, 0, w.sizeHint)
+		}
+		var err error
+		data, err = enc.EncodeTo(buf, td.AsImage())
+		if err != nil {
+			return false, fmt.Errorf("encoding tile z%d/%d/%d: %w", z, x, y, err)
+		}
+		if cfg.MaxTileBytes > 0 && len(data) > cfg.MaxTileBytes {
+			if data, err = g.shrinkTile(enc, td, data, z, x, y); err != nil {
+				return false, fmt.Errorf("encoding tile z%d/%d/%d: %w", z, x, y, err)
+			}
+		}
+		if stored {
+			w.sizeHint = len(data) + len(data)/4
+		} else {
+			w.outBuf = data
+		}
+		if g.encodeCache != nil {
+			// The store keeps stored tiles' bytes unchanged; the
+			// worker's output buffer is reused for the next tile.
+			cached := data
+			if !stored {
+				cached = bytes.Clone(data)
+			}
+			g.encodeCache.put(cacheKey, cached)
+		}
+	}
+
+	// The overlay goes on the written tile only; data stays clean for the
+	// next zoom level's downsampling.
+	out := data
+	if cfg.DebugOverlay != nil {
+		var err error
+		out, err = encodeWithOverlay(w.overlayBuf[:0], enc, td, cfg.DebugOverlay, z, x, y)
+		if err != nil {
+			return false, fmt.Errorf("encoding tile z%d/%d/%d: %w", z, x, y, err)
+		}
+		w.overlayBuf = out
+	}
+
+	phaseStart = zt.since(&zt.encode, phaseStart)
+
+	at := int64(-1)
+	var err error
+	if g.locator != nil {
+		at, err = g.locator.WriteTileLocated(z, x, y, out)
+	} else {
+		err = g.writer.WriteTile(z, x, y, out)
+	}
+	if err != nil {
+		return false, fmt.Errorf("writing tile z%d/%d/%d: %w", z, x, y, err)
+	}
+	phaseStart = zt.since(&zt.write, phaseStart)
+
+	// Store for the parent's downsampling, reusing the already-encoded
+	// bytes for efficient disk storage, or their place in the writer's.
+	if z > cfg.MinZoom {
+		if at >= 0 {
+			dst.PutAt(z, x, y, td, data, at)
+		} else {
+			dst.Put(z, x, y, td, data)
+		}
+		zt.since(&zt.store, phaseStart)
+	}
+
+	td.Release()
+
+	g.tileCount.Add(1)
+	g.totalBytes.Add(int64(len(out)))
+	return true, nil
+}
+
+// shrinkTile re-encodes td, which enc encoded to data of more than
+// Config.MaxTileBytes, at qualities 10 lower each step (from lossless, 90)
+// until it fits or MinBudgetQuality is reached, and returns the smallest
+// encoding. Noise-heavy tiles at high quality can reach megabytes, which
+// slows every range request that covers them. Formats without a quality
+// are counted and kept as they are.
+func (g *generation) shrinkTile(enc encode.Encoder, td *TileData, data []byte, z, x, y int) ([]byte, error) {
+	g.oversized.Add(1)
+	size := len(data)
+	q, ok := encode.QualityOf(enc)
+	for ok && len(data) > g.cfg.MaxTileBytes && q > MinBudgetQuality {
+		if q == encode.Lossless {
+			q = 90
+		} else {
+			q = max(q-10, MinBudgetQuality)
+		}
+		lower, _ := encode.WithQuality(enc, q)
+		smaller, err := lower.Encode(td.AsImage())
+		if err != nil {
+			return nil, err
+		}
+		if len(smaller) < len(data) {
+			data = smaller
+		}
+	}
+	if len(data) > g.cfg.MaxTileBytes {
+		g.oversizedLeft.Add(1)
+	}
+	if g.cfg.Verbose && ok {
+		log.Printf("Tile z%d/%d/%d encoded to %d bytes, over the limit of %d; re-encoded at quality %s: %d bytes",
+			z, x, y, size, g.cfg.MaxTileBytes, encode.QualityName(q), len(data))
+	} else if g.cfg.Verbose {
+		log.Printf("Tile z%d/%d/%d encoded to %d bytes, over the limit of %d; %s has no quality to lower",
+			z, x, y, size, g.cfg.MaxTileBytes, enc.Format())
+	}
+	return data, nil
+}
+
+// encoderID identifies the encoder of zoom z for the encoding cache: the
+// zoom itself when Config.ZoomEncoders overrides it, -1 for Encoder.
+func (g *generation) encoderID(z int) int {
+	if _, ok := g.cfg.ZoomEncoders[z]; ok {
+		return z
+	}
+	return -1
+}
+
+// completeZoom notifies the writer that zoom z is fully written.
+func (g *generation) completeZoom(z int) {
+	if zc, ok := g.writer.(ZoomCompleter); ok {
+		zc.CompleteZoom(z)
+	}
+}
+
+// runLevels processes zoom levels from highest to lowest, finishing each
+// level before starting the next. Each level gets its own store, drained to
+// disk before the level below reads it, with the children of each batch
+// decoded ahead of the workers.
+func (p *pass) runLevels() (Timing, error) {
+	cfg := p.cfg
+	var timing Timing
+
+	// Tile image store: holds decoded tiles for the current zoom level
+	// so the next (lower) zoom level can downsample from them.
+	// The initial store is a lightweight placeholder (no I/O goroutine)
+	// because the max-zoom level renders from COG sources, not from a
+	// previous store. Each zoom level creates its own store with disk
+	// spilling enabled. Every layer has its own stores.
+	stores := make([]*DiskTileStore, len(p.layers))
+	for i := range stores {
+		stores[i] = NewDiskTileStore(DiskTileStoreConfig{
+			InitialCapacity: 64,
+			TileSize:        cfg.TileSize,
+		})
+	}
+	defer func() { closeStores(stores) }()
+
+	// A resumed run reads the last checkpointed level's tiles and
+	// continues below it.
+	startZoom := cfg.MaxZoom
+	if ck := cfg.Checkpoint; ck != nil && ck.Resume != nil {
+		resumed, err := p.resumeStores(ck.Resume)
+		if err != nil {
+			return Timing{}, err
+		}
+		closeStores(stores)
+		stores = resumed
+		startZoom = ck.Resume.Zoom - 1
+	}
+
+	for z := startZoom; z >= cfg.MinZoom; z-- {
+		tiles := p.zoomTiles(z)
 
 		if cfg.Verbose {
 			log.Printf("Zoom %d: %d tiles to generate", z, len(tiles))
@@ -191,27 +1140,20 @@ func Generate(cfg Config, sources []*cog.Reader, writer TileWriter) (Stats, erro
 			continue
 		}
 
-		// Sort tiles along the Hilbert curve so that workers process spatially
-		// nearby tiles consecutively. This dramatically improves COG tile cache
-		// hit rates because the active working set stays in a compact 2D region
-		// rather than spanning full rows.
-		coord.SortTilesByHilbert(tiles)
-
 		// Create progress bar for this zoom level.
-		pb := newProgressBar(fmt.Sprintf("Zoom %2d", z), int64(len(tiles)))
+		pb := cfg.progressBar(fmt.Sprintf("Zoom %2d", z), int64(len(tiles)))
 
 		isMaxZoom := (z == cfg.MaxZoom)
+		if isMaxZoom {
+			p.orderWrites(tiles)
+		}
+		zoomStart := time.Now()
+		tilesBefore := p.tileCount()
+		var zt zoomTimer
 
 		// For non-max zoom levels we need the store from the previous (higher) zoom.
 		// After processing this level, we'll replace the store contents.
-		nextStore := NewDiskTileStore(DiskTileStoreConfig{
-			InitialCapacity:  len(tiles),
-			TileSize:         cfg.TileSize,
-			TempDir:          cfg.OutputDir,
-			MemoryLimitBytes: memLimit,
-			Format:           cfg.Encoder.Format(),
-			Verbose:          cfg.Verbose,
-		})
+		nextStores := p.newStores(len(tiles), false)
 
 		// Partition tiles into small Hilbert-contiguous batches and distribute
 		// them via a channel. This gives much better load balance than static
@@ -236,128 +1178,64 @@ func Generate(cfg Config, sources []*cog.Reader, writer TileWriter) (Stats, erro
 		var wg sync.WaitGroup
 		errCh := make(chan error, nWorkers)
 
-		// Feed batches into a channel; workers pull batches on demand.
+		// For downsampled levels, decode each batch's children ahead of
+		// time so workers spend their time downsampling and encoding
+		// rather than decoding.
+		if !isMaxZoom {
+			maxPending := prefetchBatches * nWorkers * 4 * batchSize
+			if maxPending > prefetchMaxTiles {
+				maxPending = prefetchMaxTiles
+			}
+			for _, st := range stores {
+				st.EnablePrefetch(nWorkers, maxPending)
+			}
+		}
+
+		// Feed batches into a channel; workers pull batches on demand. A
+		// worker that fails closes stop, so that the feeder does not block
+		// forever on a full channel nobody reads. A feeder waiting in
+		// Prefetch is released when the stores are closed.
 		batchCh := make(chan [][3]int, nWorkers*2)
+		stop := make(chan struct{})
+		var stopOnce sync.Once
+		halt := func() { stopOnce.Do(func() { close(stop) }) }
 		go func() {
-			for i := 0; i < nTiles; i += batchSize {
-				end := i + batchSize
-				if end > nTiles {
-					end = nTiles
+			defer close(batchCh)
+			for _, batch := range tileorder.Batches(tiles, batchSize) {
+				if !isMaxZoom {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					keys := childKeys(batch)
+					for _, st := range stores {
+						st.Prefetch(keys)
+					}
+				}
+				select {
+				case batchCh <- batch:
+				case <-stop:
+					return
 				}
-				batchCh <- tiles[i:end]
 			}
-			close(batchCh)
 		}()
 
-		for w := 0; w < nWorkers; w++ {
+		for i := 0; i < nWorkers; i++ {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-
-				// Build source info once per worker (read-only after init).
-				var srcInfos []sourceInfo
-				if isMaxZoom {
-					srcInfos = buildSourceInfos(sources)
-				}
-
+				ws := p.newWorker()
 				for batch := range batchCh {
 					for _, t := range batch {
-						z, x, y := t[0], t[1], t[2]
-						var td *TileData
-
-						if isMaxZoom {
-							var img *image.RGBA
-							if cfg.IsTerrarium {
-								img = renderTileTerrarium(z, x, y, cfg.TileSize, srcInfos, proj, floatCache, cfg.Resampling)
-							} else {
-								img = renderTile(z, x, y, cfg.TileSize, srcInfos, proj, cogCache, cfg.Resampling, resamplingLUTs)
-							}
-							if img != nil {
-								if cfg.FillColor != nil {
-									applyFillColorTransform(img, *cfg.FillColor)
-								}
-								td = newTileData(img, cfg.TileSize)
-							} else if cfg.FillColor != nil {
-								td = newTileDataUniform(*cfg.FillColor, cfg.TileSize)
-							}
-						} else {
-							childZ := z + 1
-							tl := store.Get(childZ, 2*x, 2*y)
-							tr := store.Get(childZ, 2*x+1, 2*y)
-							bl := store.Get(childZ, 2*x, 2*y+1)
-							br := store.Get(childZ, 2*x+1, 2*y+1)
-							if fillTileShared != nil {
-								// Reuse the shared fill tile instead of allocating
-								// a new uniform TileData per nil child.
-								if tl == nil {
-									tl = fillTileShared
-								}
-								if tr == nil {
-									tr = fillTileShared
-								}
-								if bl == nil {
-									bl = fillTileShared
-								}
-								if br == nil {
-									br = fillTileShared
-								}
-							}
-							if cfg.IsTerrarium {
-								td = downsampleTileTerrarium(tl, tr, bl, br, cfg.TileSize, cfg.Resampling)
-							} else {
-								td = downsampleTile(tl, tr, bl, br, cfg.TileSize, cfg.Resampling)
-							}
-						}
-
-						if td == nil {
-							emptyCount.Add(1)
-							pb.Increment()
-							continue
-						}
-
-						if td.IsUniform() {
-							uniformCount.Add(1)
-						} else if td.IsGray() {
-							grayCount.Add(1)
-						}
-
-						// Encode the tile. Uniform fill-color tiles reuse
-						// pre-encoded bytes to avoid redundant encoder calls;
-						// the PMTiles writer deduplicates identical content anyway,
-						// but skipping re-encoding saves CPU for sparse datasets.
-						var data []byte
-						if fillColorCached != nil && td.IsUniform() && td.Color() == *cfg.FillColor {
-							data = fillColorCached
-						} else {
-							var err error
-							data, err = cfg.Encoder.Encode(td.AsImage())
-							if err != nil {
-								select {
-								case errCh <- fmt.Errorf("encoding tile z%d/%d/%d: %w", z, x, y, err):
-								default:
-								}
-								return
-							}
-						}
-
-						if err := writer.WriteTile(z, x, y, data); err != nil {
+						if _, err := p.processTile(ws, t, stores, nextStores, false, &zt); err != nil {
+							halt()
 							select {
-							case errCh <- fmt.Errorf("writing tile z%d/%d/%d: %w", z, x, y, err):
+							case errCh <- err:
 							default:
 							}
 							return
 						}
-
-						// Store for next zoom level's downsampling, reusing the
-						// already-encoded bytes for efficient disk storage.
-						if z > cfg.MinZoom {
-							nextStore.Put(z, x, y, td, data)
-						}
-
-						td.Release()
-
-						tileCount.Add(1)
-						totalBytes.Add(int64(len(data)))
 						pb.Increment()
 					}
 				}
@@ -369,33 +1247,43 @@ func Generate(cfg Config, sources []*cog.Reader, writer TileWriter) (Stats, erro
 
 		// Drain the I/O goroutine so all tiles are on disk before the
 		// next zoom level starts reading from this store.
-		nextStore.Drain()
+		for _, st := range nextStores {
+			st.Drain()
+		}
 
 		// Check for errors.
 		select {
 		case err := <-errCh:
-			nextStore.Close()
-			return Stats{}, err
+			closeStores(nextStores)
+			return Timing{}, err
 		default:
 		}
 
+		p.completeZoom(z)
+		timing.Zooms = append(timing.Zooms, zt.result(z, p.tileCount()-tilesBefore, time.Since(zoomStart)))
+
 		if cfg.Verbose {
-			log.Printf("Zoom %d: completed (%d tiles so far, %d gray, %d uniform, %d empty)",
-				z, tileCount.Load(), grayCount.Load(), uniformCount.Load(), emptyCount.Load())
-			log.Printf("  Store: %s", nextStore.Stats())
+			p.logZoomDone(z, nextStores)
+		}
+
+		if cfg.Checkpoint != nil {
+			if err := p.saveCheckpoint(z, nextStores); err != nil {
+				closeStores(nextStores)
+				return Timing{}, err
+			}
 		}
 
 		// Swap stores: the tiles we just generated become the source for the next level.
-		store.Close() // release old store's temp file
-		store = nextStore
+		closeStores(stores) // release old stores' temp files
+		stores = nextStores
 	}
 
-	store.Close()
+	return timing, nil
+}
 
-	return Stats{
-		TileCount:    tileCount.Load(),
-		EmptyTiles:   emptyCount.Load(),
-		UniformTiles: uniformCount.Load(),
-		TotalBytes:   totalBytes.Load(),
-	}, nil
+// closeStores closes every store.
+func closeStores(stores []*DiskTileStore) {
+	for _, st := range stores {
+		st.Close()
+	}
 }
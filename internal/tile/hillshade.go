@@ -0,0 +1,151 @@
+package tile
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+)
+
+// Hillshade configures shaded-relief rendering of float (elevation)
+// sources: instead of encoding the elevations, each max-zoom pixel is lit
+// by a distant light and written as its brightness. Lower zooms are
+// downsampled from the shaded tiles like imagery.
+type Hillshade struct {
+	Azimuth  float64 // direction the light comes from, degrees clockwise from north
+	Altitude float64 // angle of the light above the horizon, degrees
+	ZFactor  float64 // vertical exaggeration; also converts elevation units to metres (0.3048 for feet)
+	Color    bool    // tint the shading by elevation instead of rendering gray
+}
+
+// DefaultHillshade is the light of most shaded-relief maps (and of
+// gdaldem hillshade): from the northwest, 45° above the horizon.
+var DefaultHillshade = Hillshade{Azimuth: 315, Altitude: 45, ZFactor: 1}
+
+// renderTileHillshade renders the hillshade of tile z/tx/ty. Elevations are
+// sampled with a one-pixel margin so that slopes at the tile edge use the
+// neighbouring tile's samples (and with maxVoid > 0, with the void-fill
+// margin, filled before shading). Nodata pixels stay transparent. Returns
+// nil when the tile has no data.
+func renderTileHillshade(z, tx, ty, tileSize int, srcInfos []sourceInfo, proj coord.Projection, scratch *sampleScratch, cache *cog.FloatTileCache, mode Resampling, maxVoid int, hs Hillshade) *image.RGBA {
+	_, midLat, _, _ := coord.TileBounds(z, tx, ty)
+	outputResMeters := coord.ResolutionAtLat(midLat, z, tileSize)
+	outputResCRS := coord.MetersToPixelSizeCRS(outputResMeters, proj.EPSG(), midLat)
+
+	if scratch == nil {
+		scratch = new(sampleScratch)
+	}
+	defer scratch.release()
+
+	m := 1
+	if maxVoid > 0 {
+		m = max(m, voidMargin(maxVoid))
+	}
+	minX, minY, maxX, maxY := paddedTileCRSBounds(z, tx, ty, tileSize, m, proj)
+	tileSrcs := prepareTileSources(scratch.srcs, srcInfos, outputResCRS, minX, minY, maxX, maxY)
+	scratch.srcs = tileSrcs
+	if len(tileSrcs) == 0 {
+		return nil
+	}
+
+	n := tileSize + 2*m
+	grid := renderElevations(z, tx, ty, tileSize, m, tileSrcs, proj, scratch, cache, mode)
+	if maxVoid > 0 {
+		fillVoids(grid, n, n, maxVoid)
+	}
+
+	img := GetRGBA(tileSize, tileSize)
+	hasData := false
+	for py := 0; py < tileSize; py++ {
+		_, lat := coord.PixelToLonLat(z, tx, ty, tileSize, 0, float64(py)+0.5)
+		res := coord.ResolutionAtLat(lat, z, tileSize)
+		for px := 0; px < tileSize; px++ {
+			c, ok := shadePixel(grid, n, (py+m)*n+px+m, res, hs)
+			if ok {
+				img.SetRGBA(px, py, c)
+				hasData = true
+			}
+		}
+	}
+	if !hasData {
+		PutRGBA(img)
+		return nil
+	}
+	return img
+}
+
+// shadePixel shades grid sample i of the n-wide grid, whose pixels are res
+// metres apart. The slope comes from Horn's 3×3 gradient (as in gdaldem);
+// NaN neighbours count as level with the center. Returns false when the
+// sample itself is NaN.
+func shadePixel(grid []float64, n, i int, res float64, hs Hillshade) (color.RGBA, bool) {
+	e := grid[i]
+	if math.IsNaN(e) {
+		return color.RGBA{}, false
+	}
+	at := func(d int) float64 {
+		if v := grid[i+d]; !math.IsNaN(v) {
+			return v
+		}
+		return e
+	}
+	nw, north, ne := at(-n-1), at(-n), at(-n+1)
+	west, east := at(-1), at(1)
+	sw, south, se := at(n-1), at(n), at(n+1)
+
+	// Rise per metre eastwards and northwards (rows run southwards).
+	k := hs.ZFactor / (8 * res)
+	dzdx := ((ne + 2*east + se) - (nw + 2*west + sw)) * k
+	dzdy := ((nw + 2*north + ne) - (sw + 2*south + se)) * k
+
+	// Cosine between the surface normal (-dzdx, -dzdy, 1) and the light.
+	sinAz, cosAz := math.Sincos(hs.Azimuth * math.Pi / 180)
+	sinAlt, cosAlt := math.Sincos(hs.Altitude * math.Pi / 180)
+	shade := (sinAlt - dzdx*sinAz*cosAlt - dzdy*cosAz*cosAlt) / math.Sqrt(1+dzdx*dzdx+dzdy*dzdy)
+	shade = max(shade, 0)
+
+	if !hs.Color {
+		v := uint8(math.Round(255 * min(shade, 1)))
+		return color.RGBA{v, v, v, 255}, true
+	}
+	// Flat ground keeps the tint's own color; slopes facing the light
+	// brighten it, slopes facing away darken it.
+	tint := hypsometricTint(e)
+	f := shade / max(sinAlt, 0.1)
+	scale := func(v uint8) uint8 { return uint8(math.Round(min(float64(v)*f, 255))) }
+	return color.RGBA{scale(tint.R), scale(tint.G), scale(tint.B), 255}, true
+}
+
+// hypsometricStops is the elevation tint of colored hillshades, in metres:
+// green lowlands through tan and brown to white peaks.
+var hypsometricStops = []struct {
+	elevation float64
+	color     color.RGBA
+}{
+	{0, color.RGBA{112, 164, 120, 255}},
+	{300, color.RGBA{168, 196, 136, 255}},
+	{1000, color.RGBA{220, 208, 152, 255}},
+	{2000, color.RGBA{184, 148, 108, 255}},
+	{3000, color.RGBA{164, 140, 128, 255}},
+	{4500, color.RGBA{244, 244, 244, 255}},
+}
+
+// hypsometricTint returns the tint of elevation e, interpolated linearly
+// between hypsometricStops and clamped at both ends.
+func hypsometricTint(e float64) color.RGBA {
+	stops := hypsometricStops
+	if e <= stops[0].elevation {
+		return stops[0].color
+	}
+	for i := 1; i < len(stops); i++ {
+		if e < stops[i].elevation {
+			a, b := stops[i-1], stops[i]
+			t := (e - a.elevation) / (b.elevation - a.elevation)
+			mix := func(x, y uint8) uint8 { return uint8(math.Round(float64(x) + t*(float64(y)-float64(x)))) }
+			return color.RGBA{mix(a.color.R, b.color.R), mix(a.color.G, b.color.G), mix(a.color.B, b.color.B), 255}
+		}
+	}
+	return stops[len(stops)-1].color
+}
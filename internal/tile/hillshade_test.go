@@ -0,0 +1,77 @@
+package tile
+
+import (
+	"math"
+	"testing"
+)
+
+// planeGrid returns a 3×3 grid of a plane rising dx per pixel eastwards
+// and dy per pixel southwards.
+func planeGrid(dx, dy float64) []float64 {
+	grid := make([]float64, 9)
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 3; x++ {
+			grid[y*3+x] = 100 + float64(x)*dx + float64(y)*dy
+		}
+	}
+	return grid
+}
+
+func TestShadePixel(t *testing.T) {
+	hs := DefaultHillshade
+	shade := func(grid []float64) uint8 {
+		t.Helper()
+		c, ok := shadePixel(grid, 3, 4, 10, hs)
+		if !ok {
+			t.Fatal("no shade for a valid sample")
+		}
+		if c.R != c.G || c.G != c.B || c.A != 255 {
+			t.Fatalf("color %v, want opaque gray", c)
+		}
+		return c.R
+	}
+
+	// Flat ground gets the light at its altitude: 255·sin 45°.
+	flat := shade(planeGrid(0, 0))
+	if want := uint8(math.Round(255 * math.Sin(math.Pi/4))); flat != want {
+		t.Errorf("flat = %d, want %d", flat, want)
+	}
+	// Light from the northwest: slopes facing it (rising to the southeast)
+	// are brighter than flat ground, slopes facing away darker.
+	if lit := shade(planeGrid(5, 5)); lit <= flat {
+		t.Errorf("slope facing the light = %d, want > %d", lit, flat)
+	}
+	if dark := shade(planeGrid(-5, -5)); dark >= flat {
+		t.Errorf("slope facing away = %d, want < %d", dark, flat)
+	}
+	// A steep enough slope facing away is in full shadow.
+	if dark := shade(planeGrid(-50, -50)); dark != 0 {
+		t.Errorf("steep slope facing away = %d, want 0", dark)
+	}
+	// The z-factor scales relief: tripled, a slope shades like one three
+	// times as steep.
+	want := shade(planeGrid(-15, -15))
+	hs.ZFactor = 3
+	if got := shade(planeGrid(-5, -5)); got != want {
+		t.Errorf("z-factor 3 = %d, want %d", got, want)
+	}
+	hs = DefaultHillshade
+
+	// NaN neighbours count as level with the center; a NaN center has no shade.
+	grid := planeGrid(0, 0)
+	grid[0] = math.NaN()
+	if got := shade(grid); got != flat {
+		t.Errorf("flat with a NaN neighbour = %d, want %d", got, flat)
+	}
+	grid[4] = math.NaN()
+	if _, ok := shadePixel(grid, 3, 4, 10, hs); ok {
+		t.Error("NaN center was shaded")
+	}
+
+	// Color: flat ground keeps the tint of its elevation.
+	hs.Color = true
+	c, _ := shadePixel(planeGrid(0, 0), 3, 4, 10, hs)
+	if want := hypsometricTint(100); c != want {
+		t.Errorf("colored flat = %v, want tint %v", c, want)
+	}
+}
@@ -3,6 +3,8 @@ package tile
 import (
 	"log"
 	"runtime"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
 )
 
 // DefaultMemoryPressurePercent is the fraction of total RAM at which the tile
@@ -50,3 +52,98 @@ func ComputeMemoryLimit(fraction float64, verbose bool) int64 {
 
 	return limit
 }
+
+// MemoryEstimate is a preflight estimate of a run's peak memory, by
+// consumer. The mapped source files are not counted: their pages belong to
+// the page cache, which the kernel reclaims under pressure.
+type MemoryEstimate struct {
+	SourceCache int64 // decoded COG tiles of the max-zoom pass
+	RawCache    int64 // compressed COG tiles behind them (cog.RawCache), each cache counted once
+	Pinned      int64 // smallest overview of every source, decoded once
+	Workers     int64 // per-worker render, downsample, encode, and projection buffers
+	Stores      int64 // tile stores: the spill threshold, or the largest level without spilling
+	EncodeCache int64 // encoded tiles kept by content (Config.EncodeCacheBytes)
+	Index       int64 // archive directory, dedup map, and store index entries
+	Runtime     int64 // Go runtime base and GC headroom over the short-lived buffers
+}
+
+// Total returns the estimated peak in bytes.
+func (e MemoryEstimate) Total() int64 {
+	return e.SourceCache + e.RawCache + e.Pinned + e.Workers + e.Stores + e.EncodeCache + e.Index + e.Runtime
+}
+
+// Estimation constants. Stored tiles are encoded; 1 byte per pixel is at the
+// upper end of what PNG, JPEG, and WebP produce for imagery.
+const (
+	estStoredBytesPerPixel = 1
+	estIndexBytesPerTile   = 128 // writer entry + dedup entry + store index
+	estWorkerTileBuffers   = 8   // rendered tile, 4 children, parent, 2 encode buffers
+	estRuntimeBase         = 64 << 20
+)
+
+// EstimateMemory estimates the peak memory of GenerateLayers(layers),
+// sizing caches, buffers, and stores the way the run will. Writers are
+// not used.
+func EstimateMemory(layers []Layer) MemoryEstimate {
+	var e MemoryEstimate
+	if len(layers) == 0 {
+		return e
+	}
+	cfg := layers[0].Config
+	tileBytes := int64(cfg.TileSize) * int64(cfg.TileSize) * 4
+
+	memLimit := cfg.MemoryLimitBytes
+	if memLimit == 0 {
+		memLimit = ComputeMemoryLimit(DefaultMemoryPressurePercent, false)
+	}
+	topTiles := zoomTileCount(cfg.MaxZoom, cfg.Bounds)
+	allTiles := CountTiles(cfg.MinZoom, cfg.MaxZoom, cfg.Bounds)
+
+	rawCaches := make(map[*cog.RawCache]bool)
+	for _, l := range layers {
+		// Source cache: entries of the largest source tile, but no more
+		// entries than the sources have tiles.
+		var srcTile, srcTiles int64
+		for _, src := range l.Sources {
+			for level := 0; level < src.IFDCount(); level++ {
+				ts := src.IFDTileSize(level)
+				if ts[0] == 0 || ts[1] == 0 {
+					continue
+				}
+				srcTile = max(srcTile, int64(ts[0])*int64(ts[1])*4)
+				srcTiles += int64((src.IFDWidth(level)+ts[0]-1)/ts[0]) * int64((src.IFDHeight(level)+ts[1]-1)/ts[1])
+			}
+			e.Pinned += src.PinnedBytes()
+			if rc := src.RawCache(); rc != nil && !rawCaches[rc] {
+				rawCaches[rc] = true
+				e.RawCache += rc.Limit()
+			}
+		}
+		e.SourceCache += min(int64(l.Config.sourceCacheSize()), srcTiles) * srcTile
+
+		// A crsGrid holds two float64 per pixel.
+		perWorker := estWorkerTileBuffers*tileBytes + int64(cfg.TileSize)*int64(cfg.TileSize)*16
+		e.Workers += int64(max(cfg.Concurrency, 1)) * perWorker
+
+		stores := topTiles * int64(cfg.TileSize) * int64(cfg.TileSize) * estStoredBytesPerPixel
+		if memLimit > 0 {
+			stores = min(stores, memLimit/int64(len(layers)))
+		}
+		e.Stores += stores
+		e.EncodeCache += l.Config.EncodeCacheBytes
+		e.Index += allTiles * estIndexBytesPerTile
+	}
+	e.Runtime = estRuntimeBase + (e.SourceCache+e.Workers)/4
+	return e
+}
+
+// AvailableMemory returns the memory this process can use without swapping
+// or being OOM-killed: available RAM, capped by a cgroup limit on Linux.
+func AvailableMemory() (uint64, error) {
+	return availableSystemRAM()
+}
+
+// PeakRSS returns the peak resident set size of this process so far.
+func PeakRSS() (int64, error) {
+	return peakRSS()
+}
@@ -0,0 +1,41 @@
+package tile
+
+import (
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+)
+
+func TestEstimateMemory(t *testing.T) {
+	// 4×4 tiles at zoom 4, one quadrant of the world at zoom 3.
+	cfg := Config{
+		MinZoom: 3, MaxZoom: 4, TileSize: 256, Concurrency: 2,
+		Bounds:           cog.Bounds{MinLon: 0.001, MaxLon: 89.999, MinLat: 0.001, MaxLat: 66.5},
+		MemoryLimitBytes: -1,
+	}
+	e := EstimateMemory([]Layer{{Config: cfg}})
+
+	const tilePixels = 256 * 256
+	if want := int64(16 * tilePixels * estStoredBytesPerPixel); e.Stores != want {
+		t.Errorf("Stores = %d, want %d (16 max-zoom tiles without spilling)", e.Stores, want)
+	}
+	if want := int64(2 * (estWorkerTileBuffers*tilePixels*4 + tilePixels*16)); e.Workers != want {
+		t.Errorf("Workers = %d, want %d", e.Workers, want)
+	}
+	if want := int64((16 + 4) * estIndexBytesPerTile); e.Index != want {
+		t.Errorf("Index = %d, want %d", e.Index, want)
+	}
+	if e.SourceCache != 0 || e.Pinned != 0 {
+		t.Errorf("no sources: SourceCache = %d, Pinned = %d, want 0", e.SourceCache, e.Pinned)
+	}
+	if e.Total() != e.Stores+e.Workers+e.Index+e.Runtime {
+		t.Errorf("Total = %d, want the sum of the parts", e.Total())
+	}
+
+	// A spill threshold caps the stores, split between layers.
+	cfg.MemoryLimitBytes = 1 << 20
+	e = EstimateMemory([]Layer{{Config: cfg}, {Config: cfg}})
+	if e.Stores != 1<<20 {
+		t.Errorf("two layers with a 1 MB limit: Stores = %d, want %d", e.Stores, 1<<20)
+	}
+}
@@ -0,0 +1,239 @@
+package tile
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"log"
+	"slices"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+)
+
+// MergeConfig holds configuration for MergeArchives.
+type MergeConfig struct {
+	TileSize     int
+	Encoder      encode.Encoder // encodes overlap tiles, in the archives' format
+	SourceFormat string         // format of the archives' tiles (for decoding)
+	Resampling   Resampling     // for overlap tiles downsampled again
+	Verbose      bool
+	// Premultiplied reads and writes the tiles with premultiplied colors,
+	// for archives written with --premultiply-alpha.
+	Premultiplied bool
+}
+
+// MergeStats counts the tiles written by MergeArchives.
+type MergeStats struct {
+	Copied     int64 // in one archive only, copied as they are
+	Composited int64 // in several archives, later ones drawn over earlier ones
+	Rebuilt    int64 // in several archives, downsampled again from the merged children
+}
+
+// Overlaps returns the number of tiles that were in more than one archive.
+func (s MergeStats) Overlaps() int64 {
+	return s.Composited + s.Rebuilt
+}
+
+// MergeArchives writes the union of the tiles of archives, e.g. regional
+// tilesets, into writer. A tile in one archive only is copied as it is.
+// Where archives overlap, later archives win: a tile in several of them is
+// decoded and the later ones are drawn over the earlier ones, so a region's
+// transparent surroundings do not hide its neighbour. Below the max zoom of
+// all those archives, the tile is instead downsampled again from the merged
+// children, so parents along a boundary show both sides at full opacity
+// instead of two half-transparent halves drawn over each other.
+//
+// Overlaps are resolved one tile at a time, finest zoom first, keeping the
+// merged tiles of one zoom for the next. They lie along the boundaries
+// between the archives, a small part of the whole.
+func MergeArchives(cfg MergeConfig, archives []PMTilesReader, writer TileWriter) (MergeStats, error) {
+	var stats MergeStats
+	if len(archives) == 0 {
+		return stats, nil
+	}
+	first := archives[0].Header()
+	for i, a := range archives[1:] {
+		if h := a.Header(); h.TileType != first.TileType || h.TileCompression != first.TileCompression {
+			return stats, fmt.Errorf("archive %d: %s tiles with compression %d, want %s with compression %d as in archive 0",
+				i+1, pmtiles.TileTypeString(h.TileType), h.TileCompression, pmtiles.TileTypeString(first.TileType), first.TileCompression)
+		}
+	}
+	if cfg.Premultiplied {
+		cfg.Encoder = encode.Premultiply(cfg.Encoder)
+	}
+
+	// Find the tiles that are in more than one archive, with the archives
+	// that have them in input order.
+	overlaps := make(map[[3]int][]int)
+	for i := 1; i < len(archives); i++ {
+		h := archives[i].Header()
+		for z := int(h.MinZoom); z <= int(h.MaxZoom); z++ {
+			err := archives[i].ForEachTileAtZoom(z, func(x, y int) error {
+				var owners []int
+				for j := 0; j < i; j++ {
+					if archives[j].HasTile(z, x, y) {
+						owners = append(owners, j)
+					}
+				}
+				if len(owners) > 0 {
+					overlaps[[3]int{z, x, y}] = append(owners, i)
+				}
+				return nil
+			})
+			if err != nil {
+				return stats, fmt.Errorf("archive %d: %w", i, err)
+			}
+		}
+	}
+	if cfg.Verbose {
+		log.Printf("Merge: %d tile(s) in more than one archive", len(overlaps))
+	}
+
+	// Copy the others.
+	for i, a := range archives {
+		h := a.Header()
+		copyTile := func(z, x, y int, data []byte) error {
+			if _, ok := overlaps[[3]int{z, x, y}]; ok {
+				return nil
+			}
+			stats.Copied++
+			return writer.WriteTile(z, x, y, data)
+		}
+		var err error
+		if s, ok := a.(TileStreamer); ok {
+			err = s.StreamZooms(int(h.MinZoom), int(h.MaxZoom), copyTile)
+		} else {
+			for z := int(h.MinZoom); z <= int(h.MaxZoom) && err == nil; z++ {
+				err = a.ForEachTileAtZoom(z, func(x, y int) error {
+					data, err := a.ReadTile(z, x, y)
+					if err != nil || data == nil {
+						return err
+					}
+					return copyTile(z, x, y, data)
+				})
+			}
+		}
+		if err != nil {
+			return stats, fmt.Errorf("archive %d: %w", i, err)
+		}
+	}
+	if len(overlaps) == 0 {
+		return stats, nil
+	}
+
+	// Resolve the overlaps, finest zoom first.
+	keys := make([][3]int, 0, len(overlaps))
+	for k := range overlaps {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b [3]int) int {
+		if a[0] != b[0] {
+			return b[0] - a[0]
+		}
+		return slices.Compare(a[1:], b[1:])
+	})
+	decode := func(a PMTilesReader, z, x, y int) (*TileData, error) {
+		data, err := a.ReadTile(z, x, y)
+		if err != nil || data == nil {
+			return nil, err
+		}
+		img, err := encode.DecodeImage(data, cfg.SourceFormat)
+		if err != nil {
+			return nil, fmt.Errorf("decoding tile z%d/%d/%d: %w", z, x, y, err)
+		}
+		if cfg.Premultiplied {
+			img = encode.FromPremultiplied(img)
+		}
+		return newTileData(imageToRGBA(img, false), cfg.TileSize), nil
+	}
+
+	merged := make(map[[3]int]*TileData) // merged overlap tiles of the zoom below
+	var current map[[3]int]*TileData
+	level := -1
+	for _, k := range keys {
+		z, x, y := k[0], k[1], k[2]
+		if z != level {
+			for _, td := range merged {
+				td.Release()
+			}
+			merged, current, level = current, make(map[[3]int]*TileData), z
+			if merged == nil {
+				merged = make(map[[3]int]*TileData)
+			}
+		}
+		owners := overlaps[k]
+
+		rebuild := true
+		for _, o := range owners {
+			if int(archives[o].Header().MaxZoom) <= z {
+				rebuild = false
+			}
+		}
+
+		var td *TileData
+		if rebuild {
+			// A child is either merged already or in one archive at most.
+			var children, read [4]*TileData
+			for i := range children {
+				c := [3]int{z + 1, 2*x + i%2, 2*y + i/2}
+				if m, ok := merged[c]; ok {
+					children[i] = m
+					continue
+				}
+				for _, a := range archives {
+					if !a.HasTile(c[0], c[1], c[2]) {
+						continue
+					}
+					child, err := decode(a, c[0], c[1], c[2])
+					if err != nil {
+						releaseChildren(read[0], read[1], read[2], read[3])
+						return stats, err
+					}
+					children[i], read[i] = child, child
+					break
+				}
+			}
+			td = downsampleTile(children[0], children[1], children[2], children[3], cfg.TileSize, cfg.Resampling)
+			releaseChildren(read[0], read[1], read[2], read[3])
+		} else {
+			dst := GetRGBA(cfg.TileSize, cfg.TileSize)
+			for _, o := range owners {
+				src, err := decode(archives[o], z, x, y)
+				if err != nil {
+					PutRGBA(dst)
+					return stats, err
+				}
+				if src != nil {
+					draw.Draw(dst, dst.Bounds(), src.AsImage(), image.Point{}, draw.Over)
+					src.Release()
+				}
+			}
+			td = newTileData(dst, cfg.TileSize)
+		}
+		if td == nil {
+			continue
+		}
+
+		data, err := cfg.Encoder.Encode(td.AsImage())
+		if err != nil {
+			return stats, fmt.Errorf("encoding tile z%d/%d/%d: %w", z, x, y, err)
+		}
+		if err := writer.WriteTile(z, x, y, data); err != nil {
+			return stats, fmt.Errorf("writing tile z%d/%d/%d: %w", z, x, y, err)
+		}
+		current[k] = td
+		if rebuild {
+			stats.Rebuilt++
+		} else {
+			stats.Composited++
+		}
+	}
+	for _, td := range merged {
+		td.Release()
+	}
+	for _, td := range current {
+		td.Release()
+	}
+	return stats, nil
+}
@@ -0,0 +1,99 @@
+package tile
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+)
+
+func TestMergeArchives(t *testing.T) {
+	const tileSize = 8
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	enc := testEncoder(t)
+	// half encodes a tile whose left (or right) half is c and the rest
+	// transparent: a region boundary running through the tile.
+	half := func(c color.RGBA, left bool) []byte {
+		img := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
+		for y := 0; y < tileSize; y++ {
+			for x := 0; x < tileSize; x++ {
+				if (x < tileSize/2) == left {
+					img.SetRGBA(x, y, c)
+				}
+			}
+		}
+		data, err := enc.Encode(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	header := pmtiles.Header{TileType: pmtiles.TileTypePNG, MinZoom: 0, MaxZoom: 1}
+
+	// West ends in the left half of z1 tile (1,0), east begins in its right
+	// half. Both have the parent at zoom 0.
+	west := &mockPMTilesReader{header: header, tiles: map[[3]int][]byte{
+		{1, 0, 0}: encodePNGTile(t, tileSize, red),
+		{1, 1, 0}: half(red, true),
+		{0, 0, 0}: half(red, true),
+	}}
+	east := &mockPMTilesReader{header: header, tiles: map[[3]int][]byte{
+		{1, 1, 0}: half(blue, false),
+		{1, 1, 1}: encodePNGTile(t, tileSize, blue),
+		{0, 0, 0}: half(blue, false),
+	}}
+
+	w := newMockTileWriter()
+	stats, err := MergeArchives(MergeConfig{
+		TileSize: tileSize, Encoder: enc, SourceFormat: "png", Resampling: ResamplingBilinear,
+	}, []PMTilesReader{west, east}, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (MergeStats{Copied: 2, Composited: 1, Rebuilt: 1}); stats != want {
+		t.Errorf("stats %+v, want %+v", stats, want)
+	}
+	if len(w.tiles) != 4 {
+		t.Errorf("%d tiles written, want 4", len(w.tiles))
+	}
+
+	decode := func(z, x, y int) *image.RGBA {
+		t.Helper()
+		img, err := encode.DecodeImage(w.tiles[[3]int{z, x, y}], "png")
+		if err != nil {
+			t.Fatalf("tile %d/%d/%d: %v", z, x, y, err)
+		}
+		return imageToRGBA(img, false)
+	}
+
+	// The boundary tile shows both regions.
+	boundary := decode(1, 1, 0)
+	if got := boundary.RGBAAt(1, 1); got != red {
+		t.Errorf("boundary tile west half %v, want %v", got, red)
+	}
+	if got := boundary.RGBAAt(tileSize-2, 1); got != blue {
+		t.Errorf("boundary tile east half %v, want %v", got, blue)
+	}
+
+	// The parent is downsampled from the merged children: the quarter over
+	// the boundary tile is opaque, where drawing the two parents over each
+	// other would leave it partly transparent.
+	parent := decode(0, 0, 0)
+	for _, p := range [][2]int{{1, 1}, {tileSize/2 + 1, 1}, {tileSize - 1, 1}, {tileSize - 2, tileSize - 2}} {
+		if a := parent.RGBAAt(p[0], p[1]).A; a != 255 {
+			t.Errorf("parent pixel %v alpha %d, want opaque", p, a)
+		}
+	}
+	if a := parent.RGBAAt(1, tileSize-2).A; a != 0 {
+		t.Errorf("parent pixel over the missing tile alpha %d, want transparent", a)
+	}
+
+	// Archives of different tile types are not merged.
+	jpeg := &mockPMTilesReader{header: pmtiles.Header{TileType: pmtiles.TileTypeJPEG}}
+	if _, err := MergeArchives(MergeConfig{TileSize: tileSize, Encoder: enc, SourceFormat: "png"}, []PMTilesReader{west, jpeg}, newMockTileWriter()); err == nil {
+		t.Error("merging png and jpeg archives succeeded, want an error")
+	}
+}
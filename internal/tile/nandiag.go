@@ -0,0 +1,71 @@
+package tile
+
+import (
+	"sort"
+	"sync"
+)
+
+// NaNFallbacks summarizes the float samples of one zoom that fell back to
+// the nearest source pixel because a tap of the interpolation kernel was
+// NaN (nodata). A DEM with large nodata regions triggers this along every
+// region edge; many fallbacks in few tiles point at bad source areas.
+type NaNFallbacks struct {
+	Zoom    int
+	Samples int64 // samples that fell back
+	Tiles   int64 // tiles with at least one fallback
+	// Worst holds the tiles with the most fallbacks, most first, up to
+	// Config.NaNReport of them (none when it is 0).
+	Worst []NaNTile
+}
+
+// NaNTile is the fallback count of one tile.
+type NaNTile struct {
+	Z, X, Y int
+	Samples int64
+}
+
+// nanTracker collects NaNFallbacks per zoom from the tile workers. Only
+// tiles with fallbacks take the lock.
+type nanTracker struct {
+	mu    sync.Mutex
+	zooms map[int]*NaNFallbacks
+	keep  int // tiles kept in Worst
+}
+
+// record adds n fallbacks of tile z/x/y.
+func (t *nanTracker) record(z, x, y, n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.zooms == nil {
+		t.zooms = make(map[int]*NaNFallbacks)
+	}
+	f := t.zooms[z]
+	if f == nil {
+		f = &NaNFallbacks{Zoom: z}
+		t.zooms[z] = f
+	}
+	f.Samples += int64(n)
+	f.Tiles++
+	if t.keep == 0 || (len(f.Worst) == t.keep && int64(n) <= f.Worst[t.keep-1].Samples) {
+		return
+	}
+	i := sort.Search(len(f.Worst), func(i int) bool { return f.Worst[i].Samples < int64(n) })
+	if len(f.Worst) < t.keep {
+		f.Worst = append(f.Worst, NaNTile{})
+	}
+	copy(f.Worst[i+1:], f.Worst[i:])
+	f.Worst[i] = NaNTile{Z: z, X: x, Y: y, Samples: int64(n)}
+}
+
+// stats returns the collected fallbacks by zoom, highest zoom first (nil
+// when there were none).
+func (t *nanTracker) stats() []NaNFallbacks {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []NaNFallbacks
+	for _, f := range t.zooms {
+		out = append(out, *f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Zoom > out[j].Zoom })
+	return out
+}
@@ -0,0 +1,33 @@
+package tile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNaNTracker(t *testing.T) {
+	tr := nanTracker{keep: 2}
+	tr.record(7, 1, 1, 5)
+	tr.record(7, 2, 1, 9)
+	tr.record(7, 3, 1, 1)
+	tr.record(7, 4, 1, 7)
+	tr.record(6, 0, 0, 3)
+
+	got := tr.stats()
+	want := []NaNFallbacks{
+		{Zoom: 7, Samples: 22, Tiles: 4, Worst: []NaNTile{{7, 2, 1, 9}, {7, 4, 1, 7}}},
+		{Zoom: 6, Samples: 3, Tiles: 1, Worst: []NaNTile{{6, 0, 0, 3}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stats() = %+v, want %+v", got, want)
+	}
+
+	counts := nanTracker{}
+	counts.record(7, 1, 1, 5)
+	if got := counts.stats(); len(got) != 1 || got[0].Samples != 5 || got[0].Worst != nil {
+		t.Errorf("without keep: stats() = %+v, want counts only", got)
+	}
+	if got := (&nanTracker{}).stats(); got != nil {
+		t.Errorf("no fallbacks: stats() = %+v, want nil", got)
+	}
+}
@@ -0,0 +1,97 @@
+package tile
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/pspoerri/geotiff2pmtiles/tileorder"
+)
+
+// orderedWriter passes the max-zoom tiles of a run on to the next writer in
+// tile-ID order (Config.OrderedWrites), whatever order the workers finish
+// them in. A tile is held back until every max-zoom tile before it along
+// the Hilbert curve is done; workers take the tiles in Hilbert batches, so
+// only the tiles of the batches in flight are held. Lower zooms pass
+// straight through.
+type orderedWriter struct {
+	next TileWriter
+	zoom int
+
+	mu       sync.Mutex
+	order    [][3]int            // max-zoom tiles in Hilbert (tile ID) order
+	cursor   int                 // index in order of the next tile to pass on
+	finished map[[3]int]struct{} // done tiles from the cursor on
+	held     map[[3]int][]byte   // their data, if they were written
+}
+
+// newOrderedWriter wraps next for a run whose max-zoom tiles are tiles,
+// sorted along curve.
+func newOrderedWriter(next TileWriter, tiles [][3]int, curve tileorder.Curve) *orderedWriter {
+	if curve != tileorder.Hilbert {
+		tiles = append([][3]int(nil), tiles...)
+		tileorder.Sort(tiles, tileorder.Hilbert)
+	}
+	o := &orderedWriter{
+		next:     next,
+		order:    tiles,
+		finished: make(map[[3]int]struct{}),
+		held:     make(map[[3]int][]byte),
+	}
+	if len(tiles) > 0 {
+		o.zoom = tiles[0][0]
+	}
+	return o
+}
+
+// WriteTile passes a lower-zoom tile, or the max-zoom tile all others
+// before it are done for, straight on, and holds a copy of any other
+// max-zoom tile until done releases it.
+func (o *orderedWriter) WriteTile(z, x, y int, data []byte) error {
+	if z != o.zoom {
+		return o.next.WriteTile(z, x, y, data)
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	t := [3]int{z, x, y}
+	if o.cursor < len(o.order) && o.order[o.cursor] == t {
+		return o.next.WriteTile(z, x, y, data)
+	}
+	o.held[t] = bytes.Clone(data)
+	return nil
+}
+
+// done marks tile t finished, written or empty, and passes on the held
+// tiles it was the last one missing before. No-op for a nil writer and
+// for lower zooms.
+func (o *orderedWriter) done(t [3]int) error {
+	if o == nil || t[0] != o.zoom {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.finished[t] = struct{}{}
+	for o.cursor < len(o.order) {
+		next := o.order[o.cursor]
+		if _, ok := o.finished[next]; !ok {
+			break
+		}
+		delete(o.finished, next)
+		o.cursor++
+		if data, ok := o.held[next]; ok {
+			delete(o.held, next)
+			if err := o.next.WriteTile(next[0], next[1], next[2], data); err != nil {
+				return fmt.Errorf("writing tile z%d/%d/%d: %w", next[0], next[1], next[2], err)
+			}
+		}
+	}
+	return nil
+}
+
+// CompleteZoom forwards to the next writer if it is a ZoomCompleter. A
+// zoom completes after all its tiles are done, so none are held then.
+func (o *orderedWriter) CompleteZoom(z int) {
+	if zc, ok := o.next.(ZoomCompleter); ok {
+		zc.CompleteZoom(z)
+	}
+}
@@ -0,0 +1,63 @@
+package tile
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/tileorder"
+)
+
+// orderRecorder records the tiles written to it, in order.
+type orderRecorder struct {
+	tiles [][3]int
+}
+
+func (w *orderRecorder) WriteTile(z, x, y int, data []byte) error {
+	w.tiles = append(w.tiles, [3]int{z, x, y})
+	return nil
+}
+
+func TestOrderedWriter(t *testing.T) {
+	var tiles [][3]int
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			tiles = append(tiles, [3]int{2, x, y})
+		}
+	}
+	next := &orderRecorder{}
+	// Workers may take the tiles along another curve; writes are still in
+	// tile-ID order.
+	tileorder.Sort(tiles, tileorder.ZOrder)
+	o := newOrderedWriter(next, tiles, tileorder.ZOrder)
+
+	// Finish the tiles back to front; the second one is empty.
+	for i := len(tiles) - 1; i >= 0; i-- {
+		tl := tiles[i]
+		if i != 1 {
+			if err := o.WriteTile(tl[0], tl[1], tl[2], []byte("tile")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := o.done(tl); err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && len(next.tiles) > 0 {
+			t.Fatalf("%d tiles written before the first one was done", len(next.tiles))
+		}
+	}
+	// A lower zoom passes straight through.
+	o.WriteTile(1, 0, 0, []byte("parent"))
+
+	want := append([][3]int(nil), tiles...)
+	tileorder.Sort(want, tileorder.Hilbert)
+	for i, tl := range want {
+		if tl == tiles[1] {
+			want = append(want[:i], want[i+1:]...)
+			break
+		}
+	}
+	want = append(want, [3]int{1, 0, 0})
+	if !reflect.DeepEqual(next.tiles, want) {
+		t.Errorf("written %v, want %v", next.tiles, want)
+	}
+}
@@ -0,0 +1,124 @@
+package tile
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+)
+
+// DebugOverlay configures the diagnostic drawing added to output tiles
+// (--debug-overlay): tile boundaries, a z/x/y label, and optionally a
+// lat/lon graticule. The overlay is drawn on a copy of each tile just
+// before encoding; the clean tile is what lower zoom levels are
+// downsampled from, so labels never bleed into parent tiles.
+type DebugOverlay struct {
+	Graticule float64 // graticule spacing in degrees (0 = no graticule)
+}
+
+var (
+	overlayBorder    = color.RGBA{255, 0, 255, 255} // tile boundaries
+	overlayGraticule = color.RGBA{0, 255, 255, 255} // lat/lon lines
+	overlayText      = color.RGBA{255, 255, 255, 255}
+	overlayTextBg    = color.RGBA{0, 0, 0, 160}
+)
+
+// encodeWithOverlay appends the encoding of td with the debug overlay for
+// tile z/x/y drawn on top to dst. td itself is left untouched.
+func encodeWithOverlay(dst []byte, enc encode.Encoder, td *TileData, o *DebugOverlay, z, x, y int) ([]byte, error) {
+	size := td.tileSize
+	img := GetRGBA(size, size)
+	defer PutRGBA(img)
+	draw.Draw(img, img.Bounds(), td.AsImage(), image.Point{}, draw.Src)
+	o.draw(img, z, x, y)
+	return enc.EncodeTo(dst, img)
+}
+
+// draw paints the overlay for tile z/x/y onto img.
+func (o *DebugOverlay) draw(img *image.RGBA, z, x, y int) {
+	size := img.Bounds().Dx()
+	if o.Graticule > 0 {
+		drawGraticule(img, o.Graticule, z, x, y)
+	}
+	for i := 0; i < size; i++ {
+		img.SetRGBA(i, 0, overlayBorder)
+		img.SetRGBA(i, size-1, overlayBorder)
+		img.SetRGBA(0, i, overlayBorder)
+		img.SetRGBA(size-1, i, overlayBorder)
+	}
+	// 3×5 glyphs scaled to stay legible on 512 px and larger tiles.
+	scale := size / 128
+	if scale < 1 {
+		scale = 1
+	}
+	drawLabel(img, fmt.Sprintf("%d/%d/%d", z, x, y), 3*scale, 3*scale, scale)
+}
+
+// drawGraticule draws meridians and parallels at multiples of spacing
+// degrees that cross tile z/x/y. Meridians are straight vertical lines in
+// Web Mercator; parallels are horizontal, so each line is one row or column.
+func drawGraticule(img *image.RGBA, spacing float64, z, x, y int) {
+	size := img.Bounds().Dx()
+	minLon, minLat, maxLon, maxLat := coord.TileBounds(z, x, y)
+	for k := math.Ceil(minLon / spacing); k*spacing < maxLon; k++ {
+		px, _ := coord.TilePixelCoords(k*spacing, 0, z, x, y, size)
+		if col := int(math.Floor(px)); col >= 0 && col < size {
+			for row := 0; row < size; row++ {
+				img.SetRGBA(col, row, overlayGraticule)
+			}
+		}
+	}
+	for k := math.Ceil(minLat / spacing); k*spacing < maxLat; k++ {
+		_, py := coord.TilePixelCoords(0, k*spacing, z, x, y, size)
+		if row := int(math.Floor(py)); row >= 0 && row < size {
+			for col := 0; col < size; col++ {
+				img.SetRGBA(col, row, overlayGraticule)
+			}
+		}
+	}
+}
+
+// glyphs is a 3×5 bitmap font for the characters used in tile labels. Each
+// row is 3 bits, most significant bit on the left.
+var glyphs = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7},
+	'1': {2, 6, 2, 2, 7},
+	'2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 7, 1, 7},
+	'4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7},
+	'7': {7, 1, 1, 1, 1},
+	'8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7},
+	'/': {1, 1, 2, 4, 4},
+}
+
+// drawLabel writes s at (x0, y0) on a translucent dark box, with each font
+// pixel drawn as a scale×scale block. Characters without a glyph are skipped.
+func drawLabel(img *image.RGBA, s string, x0, y0, scale int) {
+	advance := 4 * scale // 3 columns + 1 column spacing
+	box := image.Rect(x0-scale, y0-scale, x0+len(s)*advance, y0+6*scale).Intersect(img.Bounds())
+	draw.Draw(img, box, image.NewUniform(overlayTextBg), image.Point{}, draw.Over)
+
+	for i, r := range s {
+		g, ok := glyphs[r]
+		if !ok {
+			continue
+		}
+		gx := x0 + i*advance
+		for row, bits := range g {
+			for col := 0; col < 3; col++ {
+				if bits&(4>>col) == 0 {
+					continue
+				}
+				block := image.Rect(gx+col*scale, y0+row*scale, gx+(col+1)*scale, y0+(row+1)*scale)
+				draw.Draw(img, block.Intersect(img.Bounds()), image.NewUniform(overlayText), image.Point{}, draw.Src)
+			}
+		}
+	}
+}
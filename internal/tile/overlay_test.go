@@ -0,0 +1,83 @@
+package tile
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+)
+
+func TestDebugOverlay_Draw(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	o := &DebugOverlay{Graticule: 90}
+	o.draw(img, 0, 0, 0)
+
+	for _, p := range [][2]int{{0, 200}, {255, 200}, {200, 0}, {200, 255}} {
+		if c := img.RGBAAt(p[0], p[1]); c != overlayBorder {
+			t.Errorf("border pixel %v = %v, want %v", p, c, overlayBorder)
+		}
+	}
+	// At z0 the meridians at ±90° and 0° fall on columns 64, 128, 192 and
+	// the equator on row 128.
+	if c := img.RGBAAt(128, 200); c != overlayGraticule {
+		t.Errorf("meridian pixel = %v, want %v", c, overlayGraticule)
+	}
+	if c := img.RGBAAt(200, 128); c != overlayGraticule {
+		t.Errorf("equator pixel = %v, want %v", c, overlayGraticule)
+	}
+	if c := img.RGBAAt(100, 200); c.A != 0 {
+		t.Errorf("interior pixel = %v, want untouched", c)
+	}
+
+	white := 0
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			if img.RGBAAt(x, y) == overlayText {
+				white++
+			}
+		}
+	}
+	if white == 0 {
+		t.Error("no label pixels drawn in the top-left corner")
+	}
+}
+
+func TestDebugOverlay_NoGraticule(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	(&DebugOverlay{}).draw(img, 0, 0, 0)
+	if c := img.RGBAAt(128, 200); c.A != 0 {
+		t.Errorf("meridian pixel = %v, want untouched without graticule", c)
+	}
+}
+
+func TestEncodeWithOverlay_LeavesTileClean(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range src.Pix {
+		src.Pix[i] = byte(i)
+	}
+	src.Pix[3] = 255
+	before := append([]byte(nil), src.Pix...)
+	td := newTileData(src, 64)
+
+	enc, err := encode.NewEncoder("png", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := encodeWithOverlay(nil, enc, td, &DebugOverlay{}, 3, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src.Pix, before) {
+		t.Error("encodeWithOverlay modified the source tile")
+	}
+	out, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := color.RGBAModel.Convert(out.At(10, 0)).(color.RGBA); c != overlayBorder {
+		t.Errorf("encoded border pixel = %v, want %v", c, overlayBorder)
+	}
+}
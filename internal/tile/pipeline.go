@@ -0,0 +1,255 @@
+package tile
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pspoerri/geotiff2pmtiles/tileorder"
+)
+
+// pyramidScheduler hands out tiles of all zoom levels at once. Max-zoom
+// tiles are handed out in Hilbert-ordered batches; a lower-zoom tile becomes
+// ready as soon as all of its children inside the bounds are done, so
+// parents are downsampled while other workers are still rendering elsewhere.
+//
+// Ready parents take priority over new max-zoom batches and are taken
+// newest first. This walks the pyramid depth-first along the Hilbert curve:
+// children are consumed shortly after they are stored, which keeps them in
+// memory instead of on the spill disk.
+type pyramidScheduler struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	minZoom, maxZoom int
+	batches          [][][3]int     // max-zoom batches, in Hilbert order
+	nextBatch        int            // index of the next batch to hand out
+	ready            [][3]int       // lower-zoom tiles whose children are done (LIFO)
+	waiting          map[[3]int]int // lower-zoom tile → children not yet done
+	left             map[int]int    // tiles not yet done, per zoom
+	written          map[int]int64  // tiles written (not empty), per zoom
+	started          map[int]time.Time
+	remaining        int
+	err              error
+}
+
+// newPyramidScheduler plans the tiles of levels (zoom → Hilbert-sorted
+// tiles) for processing in batches of batchSize max-zoom tiles.
+//
+// Each lower-zoom tile waits for those of its four children that are in
+// levels; children outside the bounds never complete and are not waited
+// for. A tile whose children are all outside is ready immediately.
+func newPyramidScheduler(levels map[int][][3]int, minZoom, maxZoom, batchSize int) *pyramidScheduler {
+	s := &pyramidScheduler{
+		minZoom: minZoom,
+		maxZoom: maxZoom,
+		waiting: make(map[[3]int]int),
+		left:    make(map[int]int),
+		written: make(map[int]int64),
+		started: make(map[int]time.Time),
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	s.batches = tileorder.Batches(levels[maxZoom], batchSize)
+
+	for z := minZoom; z <= maxZoom; z++ {
+		s.left[z] = len(levels[z])
+		s.remaining += len(levels[z])
+	}
+	for z := maxZoom - 1; z >= minZoom; z-- {
+		for _, t := range levels[z] {
+			s.waiting[t] = 0
+		}
+		for _, c := range levels[z+1] {
+			p := [3]int{z, c[1] / 2, c[2] / 2}
+			if n, ok := s.waiting[p]; ok {
+				s.waiting[p] = n + 1
+			}
+		}
+		// Push in reverse so the stack pops in Hilbert order.
+		tiles := levels[z]
+		for i := len(tiles) - 1; i >= 0; i-- {
+			if s.waiting[tiles[i]] == 0 {
+				delete(s.waiting, tiles[i])
+				s.ready = append(s.ready, tiles[i])
+			}
+		}
+	}
+	return s
+}
+
+// next blocks until work is available and returns it: one ready parent, or
+// the next batch of max-zoom tiles. Returns false when all tiles are done
+// or the run has failed.
+func (s *pyramidScheduler) next() ([][3]int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if s.err != nil || s.remaining == 0 {
+			return nil, false
+		}
+		if n := len(s.ready); n > 0 {
+			t := s.ready[n-1]
+			s.ready = s.ready[:n-1]
+			s.start(t[0])
+			return [][3]int{t}, true
+		}
+		if s.nextBatch < len(s.batches) {
+			b := s.batches[s.nextBatch]
+			s.nextBatch++
+			s.start(s.maxZoom)
+			return b, true
+		}
+		s.cond.Wait()
+	}
+}
+
+// start records when the first tile of zoom z was handed out.
+// Caller must hold mu.
+func (s *pyramidScheduler) start(z int) {
+	if _, ok := s.started[z]; !ok {
+		s.started[z] = time.Now()
+	}
+}
+
+// done marks t as finished (written reports whether it produced a tile) and
+// readies its parent once all of the parent's children are done. When t was
+// the last tile of its zoom, zoomDone is called with that zoom's written
+// tile count and the time since its first tile was handed out.
+//
+// zoomDone runs under the scheduler lock, so zooms are reported strictly in
+// completion order, which is max → min: a parent finishes after its
+// children, so the last tile of a zoom finishes after the last tile of the
+// zoom above it.
+func (s *pyramidScheduler) done(t [3]int, written bool, zoomDone func(z int, tiles int64, wall time.Duration)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	z := t[0]
+	s.remaining--
+	s.left[z]--
+	if written {
+		s.written[z]++
+	}
+	if z > s.minZoom {
+		p := [3]int{z - 1, t[1] / 2, t[2] / 2}
+		if n, ok := s.waiting[p]; ok {
+			if n == 1 {
+				delete(s.waiting, p)
+				s.ready = append(s.ready, p)
+				s.cond.Signal()
+			} else {
+				s.waiting[p] = n - 1
+			}
+		}
+	}
+	if s.remaining == 0 {
+		s.cond.Broadcast()
+	}
+	if s.left[z] == 0 {
+		zoomDone(z, s.written[z], time.Since(s.started[z]))
+	}
+}
+
+// fail records the first error and wakes all waiting workers.
+func (s *pyramidScheduler) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// runPipelined processes all zoom levels concurrently: a parent is
+// downsampled as soon as its children are done (see pyramidScheduler), and
+// the children are deleted from the layer's single shared store once consumed. The
+// store spills only under memory pressure, oldest tiles first, so with
+// depth-first scheduling most children never reach the disk.
+//
+// Per-zoom timings overlap: a zoom's Wall runs from its first tile being
+// handed out to its last tile finishing.
+func (p *pass) runPipelined() (Timing, error) {
+	cfg := p.cfg
+	var timing Timing
+
+	levels := make(map[int][][3]int)
+	var total int
+	for z := cfg.MaxZoom; z >= cfg.MinZoom; z-- {
+		levels[z] = p.zoomTiles(z)
+		total += len(levels[z])
+		if cfg.Verbose {
+			log.Printf("Zoom %d: %d tiles to generate", z, len(levels[z]))
+		}
+	}
+	if total == 0 {
+		return timing, nil
+	}
+	p.orderWrites(levels[cfg.MaxZoom])
+
+	// Children are deleted once consumed, so the store holds a working set
+	// well below the size of the max zoom level. Every layer has its own.
+	stores := p.newStores(len(levels[cfg.MaxZoom]), true)
+	defer closeStores(stores)
+
+	sched := newPyramidScheduler(levels, cfg.MinZoom, cfg.MaxZoom, scheduleBatchSize)
+	timers := make(map[int]*zoomTimer)
+	for z := cfg.MinZoom; z <= cfg.MaxZoom; z++ {
+		timers[z] = &zoomTimer{}
+	}
+
+	label := fmt.Sprintf("Zoom %d", cfg.MaxZoom)
+	if cfg.MinZoom < cfg.MaxZoom {
+		label = fmt.Sprintf("Zoom %d-%d", cfg.MinZoom, cfg.MaxZoom)
+	}
+	pb := cfg.progressBar(label, int64(total))
+
+	nWorkers := cfg.Concurrency
+	if nWorkers > total {
+		nWorkers = total
+	}
+	if nWorkers < 1 {
+		nWorkers = 1
+	}
+
+	zoomDone := func(z int, tiles int64, wall time.Duration) {
+		p.completeZoom(z)
+		timing.Zooms = append(timing.Zooms, timers[z].result(z, tiles, wall))
+		if cfg.Verbose {
+			p.logZoomDone(z, stores)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < nWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ws := p.newWorker()
+			for {
+				job, ok := sched.next()
+				if !ok {
+					return
+				}
+				for _, t := range job {
+					z := t[0]
+					written, err := p.processTile(ws, t, stores, stores, true, timers[z])
+					if err != nil {
+						sched.fail(err)
+						return
+					}
+					pb.Increment()
+					sched.done(t, written, zoomDone)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	pb.Finish()
+
+	if sched.err != nil {
+		return Timing{}, sched.err
+	}
+	return timing, nil
+}
@@ -0,0 +1,136 @@
+package tile
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pspoerri/geotiff2pmtiles/tileorder"
+)
+
+// pyramidLevels returns the tiles of a full square pyramid rooted at 0/0/0.
+func pyramidLevels(minZoom, maxZoom int) map[int][][3]int {
+	levels := make(map[int][][3]int)
+	for z := minZoom; z <= maxZoom; z++ {
+		n := 1 << z
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				levels[z] = append(levels[z], [3]int{z, x, y})
+			}
+		}
+	}
+	return levels
+}
+
+// drainScheduler runs the scheduler on one goroutine and returns the tiles
+// in processing order and the zooms in completion order.
+func drainScheduler(t *testing.T, s *pyramidScheduler) (order [][3]int, zooms []int) {
+	t.Helper()
+	for {
+		job, ok := s.next()
+		if !ok {
+			return order, zooms
+		}
+		for _, tl := range job {
+			order = append(order, tl)
+			s.done(tl, true, func(z int, tiles int64, wall time.Duration) {
+				zooms = append(zooms, z)
+			})
+		}
+	}
+}
+
+func TestPyramidScheduler_ChildrenBeforeParents(t *testing.T) {
+	s := newPyramidScheduler(pyramidLevels(0, 4), 0, 4, 4)
+	order, zooms := drainScheduler(t, s)
+
+	if len(order) != 1+4+16+64+256 {
+		t.Fatalf("processed %d tiles, want %d", len(order), 1+4+16+64+256)
+	}
+	doneAt := make(map[[3]int]int)
+	for i, tl := range order {
+		if _, dup := doneAt[tl]; dup {
+			t.Fatalf("tile %v handed out twice", tl)
+		}
+		doneAt[tl] = i
+	}
+	for tl, i := range doneAt {
+		if tl[0] == 4 {
+			continue
+		}
+		for _, c := range childKeys([][3]int{tl}) {
+			if doneAt[c] > i {
+				t.Errorf("tile %v processed before its child %v", tl, c)
+			}
+		}
+	}
+	if want := []int{4, 3, 2, 1, 0}; len(zooms) != len(want) {
+		t.Fatalf("completed zooms %v, want %v", zooms, want)
+	} else {
+		for i := range want {
+			if zooms[i] != want[i] {
+				t.Fatalf("completed zooms %v, want %v", zooms, want)
+			}
+		}
+	}
+}
+
+func TestPyramidScheduler_ParentsBeforeNextBatch(t *testing.T) {
+	// With batches of four max-zoom tiles in Hilbert order, each batch
+	// completes one parent, which must be downsampled before the next batch
+	// is rendered.
+	levels := pyramidLevels(1, 2)
+	tileorder.Sort(levels[2], tileorder.Hilbert)
+	s := newPyramidScheduler(levels, 1, 2, 4)
+	order, _ := drainScheduler(t, s)
+
+	for i := 0; i+5 <= len(order); i += 5 {
+		for j := 0; j < 4; j++ {
+			if order[i+j][0] != 2 {
+				t.Fatalf("position %d: got %v, want a z2 tile", i+j, order[i+j])
+			}
+		}
+		p := order[i+4]
+		if p[0] != 1 || p[1] != order[i][1]/2 || p[2] != order[i][2]/2 {
+			t.Fatalf("position %d: got %v, want the parent of %v", i+4, p, order[i])
+		}
+	}
+}
+
+func TestPyramidScheduler_ParentOutsideChildLevel(t *testing.T) {
+	// A parent whose children are all outside the bounds is ready at once;
+	// one with some children waits for just those.
+	levels := map[int][][3]int{
+		1: {{1, 0, 0}, {1, 1, 0}},
+		2: {{2, 0, 0}},
+	}
+	s := newPyramidScheduler(levels, 1, 2, 32)
+	if len(s.ready) != 1 || s.ready[0] != [3]int{1, 1, 0} {
+		t.Fatalf("ready = %v, want [1/1/0]", s.ready)
+	}
+	if n := s.waiting[[3]int{1, 0, 0}]; n != 1 {
+		t.Fatalf("1/0/0 waits for %d children, want 1", n)
+	}
+	order, zooms := drainScheduler(t, s)
+	if len(order) != 3 {
+		t.Fatalf("processed %v, want 3 tiles", order)
+	}
+	if len(zooms) != 2 || zooms[0] != 2 || zooms[1] != 1 {
+		t.Errorf("completed zooms %v, want [2 1]", zooms)
+	}
+}
+
+func TestPyramidScheduler_FailStopsWorkers(t *testing.T) {
+	errTest := errors.New("test failure")
+	s := newPyramidScheduler(pyramidLevels(0, 3), 0, 3, 4)
+	if _, ok := s.next(); !ok {
+		t.Fatal("expected work")
+	}
+	s.fail(errTest)
+	if _, ok := s.next(); ok {
+		t.Error("next handed out work after fail")
+	}
+	if s.err != errTest {
+		t.Errorf("err = %v, want %v", s.err, errTest)
+	}
+}
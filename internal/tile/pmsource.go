@@ -0,0 +1,194 @@
+package tile
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+)
+
+// PMTilesSource serves the tiles of a raster PMTiles archive as a
+// RasterSource in Web Mercator (EPSG:3857), e.g. to backfill the areas
+// that a run's GeoTIFFs leave uncovered (Config.Backfill). Level 0 is the
+// archive's max zoom, each further level one zoom lower, down to its min
+// zoom; a tile of the source is the archive tile of the same x and y.
+// Tiles the archive lacks read as transparent.
+type PMTilesSource struct {
+	r        PMTilesReader
+	id       int
+	format   string
+	tileSize int
+	maxZoom  int
+	levels   int
+	geo      cog.GeoInfo
+	bounds   [4]float64 // min x, min y, max x, max y in EPSG:3857
+	empty    *image.NRGBA
+	// premultiplied decodes the tiles as storing premultiplied colors.
+	premultiplied bool
+}
+
+// premultipliedReader is implemented by readers that know whether their
+// tiles store premultiplied colors, such as *pmtiles.Reader.
+type premultipliedReader interface {
+	PremultipliedAlpha() bool
+}
+
+// errNoValues is returned for float reads of a PMTilesSource: its tiles
+// are colors, not values.
+var errNoValues = errors.New("PMTiles sources have no values")
+
+// NewPMTilesSource returns a source reading the tiles of r, identified by
+// id in the tile caches. tileSize is the archive's tile size (e.g. the
+// size recorded in its metadata); 0 decodes a tile to find it. Tiles of
+// readers reporting PremultipliedAlpha are decoded as premultiplied.
+func NewPMTilesSource(r PMTilesReader, id, tileSize int) (*PMTilesSource, error) {
+	h := r.Header()
+	format := pmtiles.TileTypeString(h.TileType)
+	switch format {
+	case "png", "jpeg":
+	case "webp":
+		if !encode.WebPAvailable() {
+			return nil, fmt.Errorf("WebP tiles need a build with libwebp (CGO_ENABLED=1)")
+		}
+	default:
+		return nil, fmt.Errorf("%s tiles cannot be sampled (want png, jpeg, or webp)", format)
+	}
+	if h.MaxZoom < h.MinZoom {
+		return nil, fmt.Errorf("zoom range %d-%d is empty", h.MinZoom, h.MaxZoom)
+	}
+	if tileSize <= 0 {
+		tileSize = decodedTileSize(r, format)
+		if tileSize == 0 {
+			return nil, fmt.Errorf("no tile at zoom %d decodes to learn the tile size", h.MaxZoom)
+		}
+	}
+
+	premultiplied := false
+	if p, ok := r.(premultipliedReader); ok {
+		premultiplied = p.PremultipliedAlpha()
+	}
+
+	pixelSize := 2 * coord.OriginShift / (float64(tileSize) * math.Exp2(float64(h.MaxZoom)))
+	merc := coord.WebMercatorProj{}
+	minLat, maxLat, _ := coord.ClampMercatorLat(float64(h.MinLat), float64(h.MaxLat))
+	minX, minY := merc.FromWGS84(float64(h.MinLon), minLat)
+	maxX, maxY := merc.FromWGS84(float64(h.MaxLon), maxLat)
+	return &PMTilesSource{
+		r:        r,
+		id:       id,
+		format:   format,
+		tileSize: tileSize,
+		maxZoom:  int(h.MaxZoom),
+		levels:   int(h.MaxZoom-h.MinZoom) + 1,
+		geo: cog.GeoInfo{
+			EPSG:       3857,
+			OriginX:    -coord.OriginShift,
+			OriginY:    coord.OriginShift,
+			PixelSizeX: pixelSize,
+			PixelSizeY: pixelSize,
+		},
+		bounds:        [4]float64{minX, minY, maxX, maxY},
+		empty:         image.NewNRGBA(image.Rect(0, 0, tileSize, tileSize)),
+		premultiplied: premultiplied,
+	}, nil
+}
+
+// decodedTileSize returns the width of the first tile at the archive's max
+// zoom that decodes, or 0 if none does.
+func decodedTileSize(r PMTilesReader, format string) int {
+	size := 0
+	z := int(r.Header().MaxZoom)
+	r.ForEachTileAtZoom(z, func(x, y int) error {
+		data, err := r.ReadTile(z, x, y)
+		if err != nil || data == nil {
+			return nil
+		}
+		if img, err := encode.DecodeImage(data, format); err == nil && img.Bounds().Dx() > 0 {
+			size = img.Bounds().Dx()
+			return errStopFeed
+		}
+		return nil
+	})
+	return size
+}
+
+// ID returns the identifier given to NewPMTilesSource.
+func (s *PMTilesSource) ID() int { return s.id }
+
+// GeoInfo returns the georeferencing of the max zoom: the Web Mercator
+// world, one tile per archive tile.
+func (s *PMTilesSource) GeoInfo() cog.GeoInfo { return s.geo }
+
+// BoundsInCRS returns the archive's bounds in EPSG:3857.
+func (s *PMTilesSource) BoundsInCRS() (minX, minY, maxX, maxY float64) {
+	return s.bounds[0], s.bounds[1], s.bounds[2], s.bounds[3]
+}
+
+// PixelSize returns the pixel size at the archive's max zoom in meters.
+func (s *PMTilesSource) PixelSize() float64 { return s.geo.PixelSizeX }
+
+// TileSize returns the archive's tile size in pixels.
+func (s *PMTilesSource) TileSize() int { return s.tileSize }
+
+// OverviewForZoom returns the level whose pixel size best matches
+// outputPixelSizeCRS, as cog.Reader.OverviewForZoom does.
+func (s *PMTilesSource) OverviewForZoom(outputPixelSizeCRS float64) int {
+	best, bestRatio := 0, math.Inf(1)
+	for level := 0; level < s.levels; level++ {
+		if ratio := math.Abs(s.IFDPixelSize(level)/outputPixelSizeCRS - 1); ratio < bestRatio {
+			best, bestRatio = level, ratio
+		}
+	}
+	return best
+}
+
+// IFDPixelSize returns the pixel size of level in meters.
+func (s *PMTilesSource) IFDPixelSize(level int) float64 {
+	return s.geo.PixelSizeX * math.Exp2(float64(level))
+}
+
+// IFDPixelSizeY returns the pixel height of level, the same as its width.
+func (s *PMTilesSource) IFDPixelSizeY(level int) float64 { return s.IFDPixelSize(level) }
+
+// IFDWidth returns the width of the world at level in pixels.
+func (s *PMTilesSource) IFDWidth(level int) int { return s.tileSize << (s.maxZoom - level) }
+
+// IFDHeight returns the height of the world at level in pixels.
+func (s *PMTilesSource) IFDHeight(level int) int { return s.IFDWidth(level) }
+
+// IFDTileSize returns the archive's tile size.
+func (s *PMTilesSource) IFDTileSize(level int) [2]int { return [2]int{s.tileSize, s.tileSize} }
+
+// ReadTile decodes archive tile (col, row) of the zoom of level. Missing
+// tiles are transparent.
+func (s *PMTilesSource) ReadTile(level, col, row int) (image.Image, error) {
+	z := s.maxZoom - level
+	data, err := s.r.ReadTile(z, col, row)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return s.empty, nil
+	}
+	img, err := encode.DecodeImage(data, s.format)
+	if err != nil {
+		return nil, fmt.Errorf("tile %d/%d/%d: %w", z, col, row, err)
+	}
+	if s.premultiplied {
+		img = encode.FromPremultiplied(img)
+	}
+	if b := img.Bounds(); b.Dx() != s.tileSize || b.Dy() != s.tileSize || b.Min != (image.Point{}) {
+		return nil, fmt.Errorf("tile %d/%d/%d is %dx%d, want %dx%d", z, col, row, b.Dx(), b.Dy(), s.tileSize, s.tileSize)
+	}
+	return img, nil
+}
+
+// ReadFloatTile fails: archive tiles hold colors, not values.
+func (s *PMTilesSource) ReadFloatTile(level, col, row int) ([]float32, int, int, error) {
+	return nil, 0, 0, errNoValues
+}
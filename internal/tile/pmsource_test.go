@@ -0,0 +1,106 @@
+package tile
+
+import (
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+)
+
+func TestPMTilesSource(t *testing.T) {
+	const tileSize = 8
+	green := color.RGBA{0, 200, 0, 255}
+	reader := &mockPMTilesReader{
+		tiles: map[[3]int][]byte{
+			{3, 4, 2}: encodePNGTile(t, tileSize, green),
+			{2, 2, 1}: encodePNGTile(t, tileSize, green),
+		},
+		header: pmtiles.Header{TileType: pmtiles.TileTypePNG, MinZoom: 2, MaxZoom: 3,
+			MinLon: 0, MinLat: 0, MaxLon: 45, MaxLat: 40},
+	}
+
+	src, err := NewPMTilesSource(reader, 7, 0)
+	if err != nil {
+		t.Fatalf("NewPMTilesSource: %v", err)
+	}
+	if src.ID() != 7 || src.TileSize() != tileSize {
+		t.Errorf("ID, TileSize = %d, %d, want 7, %d", src.ID(), src.TileSize(), tileSize)
+	}
+	if w := src.IFDWidth(0); w != tileSize<<3 {
+		t.Errorf("IFDWidth(0) = %d, want %d", w, tileSize<<3)
+	}
+	if w := src.IFDWidth(1); w != tileSize<<2 {
+		t.Errorf("IFDWidth(1) = %d, want %d", w, tileSize<<2)
+	}
+	wantPixel := 2 * coord.OriginShift / (tileSize << 3)
+	if got := src.PixelSize(); math.Abs(got-wantPixel) > 1e-6 {
+		t.Errorf("PixelSize = %v, want %v", got, wantPixel)
+	}
+	// Zoom 2 is level 1; coarser output still reads the coarsest level.
+	for res, want := range map[float64]int{wantPixel: 0, 2 * wantPixel: 1, 16 * wantPixel: 1} {
+		if got := src.OverviewForZoom(res); got != want {
+			t.Errorf("OverviewForZoom(%v) = %d, want %d", res, got, want)
+		}
+	}
+	minX, minY, maxX, maxY := src.BoundsInCRS()
+	if minX != 0 || minY != 0 || maxX <= minX || maxY <= minY {
+		t.Errorf("BoundsInCRS = %v %v %v %v", minX, minY, maxX, maxY)
+	}
+
+	img, err := src.ReadTile(1, 2, 1)
+	if err != nil {
+		t.Fatalf("ReadTile: %v", err)
+	}
+	if r, g, _, a := img.At(3, 3).RGBA(); r>>8 != 0 || g>>8 != 200 || a>>8 != 255 {
+		t.Errorf("tile 2/2/1 pixel = %d,%d,_,%d, want green", r>>8, g>>8, a>>8)
+	}
+	img, err = src.ReadTile(0, 0, 0)
+	if err != nil {
+		t.Fatalf("ReadTile of a missing tile: %v", err)
+	}
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+		t.Errorf("missing tile alpha = %d, want transparent", a)
+	}
+	if _, _, _, err := src.ReadFloatTile(0, 4, 2); err == nil {
+		t.Error("ReadFloatTile succeeded, want an error")
+	}
+}
+
+func TestPMTilesSource_RejectsVectorTiles(t *testing.T) {
+	reader := &mockPMTilesReader{header: pmtiles.Header{TileType: pmtiles.TileTypeMVT}}
+	if _, err := NewPMTilesSource(reader, 0, 256); err == nil {
+		t.Error("NewPMTilesSource accepted an MVT archive")
+	}
+}
+
+func TestPMTilesSource_TileSizeMismatch(t *testing.T) {
+	reader := &mockPMTilesReader{
+		tiles:  map[[3]int][]byte{{0, 0, 0}: encodePNGTile(t, 8, color.RGBA{A: 255})},
+		header: pmtiles.Header{TileType: pmtiles.TileTypePNG},
+	}
+	src, err := NewPMTilesSource(reader, 0, 16)
+	if err != nil {
+		t.Fatalf("NewPMTilesSource: %v", err)
+	}
+	if _, err := src.ReadTile(0, 0, 0); err == nil {
+		t.Error("ReadTile of an 8px tile in a 16px source succeeded")
+	}
+}
+
+func TestRankTileSources_BackfillLast(t *testing.T) {
+	// Backfill ranks after every input, however fine its pixels.
+	srcs := []tileSource{
+		{pixelSize: 1, imgW: 0, backfill: true},
+		{pixelSize: 40, imgW: 1},
+		{pixelSize: 5, imgW: 2},
+	}
+	rankTileSources(srcs, 10)
+	want := []int{2, 1, 0}
+	for i, s := range srcs {
+		if s.imgW != want[i] {
+			t.Fatalf("position %d holds input %d, want %d", i, s.imgW, want[i])
+		}
+	}
+}
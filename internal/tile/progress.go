@@ -7,6 +7,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/ui"
 )
 
 // progressBar renders an in-place terminal progress bar for a zoom level.
@@ -16,6 +18,7 @@ type progressBar struct {
 	total     int64
 	processed atomic.Int64
 	label     string
+	quiet     bool // counts without drawing (Config.Quiet)
 	barWidth  int
 	start     time.Time
 	done      chan struct{}
@@ -34,6 +37,15 @@ func newProgressBar(label string, total int64) *progressBar {
 	return pb
 }
 
+// progressBar returns a progress bar for a zoom level of the generation,
+// or with c.Quiet one that only counts.
+func (c *Config) progressBar(label string, total int64) *progressBar {
+	if c.Quiet {
+		return &progressBar{total: total, label: label, quiet: true}
+	}
+	return newProgressBar(label, total)
+}
+
 // Increment marks one more item as processed. Safe for concurrent use.
 func (pb *progressBar) Increment() {
 	pb.processed.Add(1)
@@ -41,6 +53,9 @@ func (pb *progressBar) Increment() {
 
 // Finish stops the refresh loop and prints the final bar state with a newline.
 func (pb *progressBar) Finish() {
+	if pb.quiet {
+		return
+	}
 	close(pb.done)
 	pb.draw()
 	fmt.Fprint(os.Stderr, "\n")
@@ -88,22 +103,11 @@ func (pb *progressBar) draw() {
 	remaining := total - processed
 	if rate > 0 && remaining > 0 {
 		eta := time.Duration(float64(remaining)/rate) * time.Second
-		etaStr = formatDuration(eta)
+		etaStr = ui.Clock(eta)
 	} else if remaining <= 0 {
 		etaStr = "0s"
 	}
 
 	fmt.Fprintf(os.Stderr, "\r%s [%s] %3.0f%%  %d/%d tiles  %.0f/s  %s  ETA %s\033[K",
-		pb.label, bar, frac*100, processed, total, rate, formatDuration(elapsed), etaStr)
-}
-
-// formatDuration formats a duration concisely (e.g. "1m23s", "45s", "0s").
-func formatDuration(d time.Duration) string {
-	d = d.Truncate(time.Second)
-	if d < time.Minute {
-		return fmt.Sprintf("%ds", int(d.Seconds()))
-	}
-	m := int(d.Minutes())
-	s := int(d.Seconds()) - m*60
-	return fmt.Sprintf("%dm%02ds", m, s)
+		pb.label, bar, frac*100, processed, total, rate, ui.Clock(elapsed), etaStr)
 }
@@ -0,0 +1,107 @@
+package tile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+)
+
+// QualityRange sets the encoder quality of zoom levels MinZoom..MaxZoom
+// (MaxZoom -1: every zoom from MinZoom up).
+type QualityRange struct {
+	MinZoom, MaxZoom int
+	Quality          int // 1-100 or encode.Lossless
+}
+
+// ParseQuality parses a --quality value: a single quality ("85",
+// "lossless") or comma-separated per-zoom entries "z<min>-<max>:<q>",
+// "z<zoom>:<q>", and "z<min>+:<q>" (the "z" is optional), e.g.
+// "z0-10:75,z11-14:85,z15+:92". A bare quality among the entries applies
+// to the zooms no entry covers; def is 0 when there is none.
+func ParseQuality(s string) (def int, ranges []QualityRange, err error) {
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		zooms, q, perZoom := strings.Cut(part, ":")
+		if !perZoom {
+			q = zooms
+		}
+		quality, err := parseQualityValue(q)
+		if err != nil {
+			return 0, nil, err
+		}
+		if !perZoom {
+			if def != 0 {
+				return 0, nil, fmt.Errorf("more than one default quality in %q", s)
+			}
+			def = quality
+			continue
+		}
+
+		r := QualityRange{Quality: quality}
+		zooms = strings.TrimPrefix(strings.TrimSpace(zooms), "z")
+		if lo, open := strings.CutSuffix(zooms, "+"); open {
+			r.MaxZoom = -1
+			if r.MinZoom, err = strconv.Atoi(lo); err != nil || r.MinZoom < 0 {
+				return 0, nil, fmt.Errorf("invalid quality zoom %q in %q", lo, part)
+			}
+		} else {
+			lo, hi, isRange := strings.Cut(zooms, "-")
+			if r.MinZoom, err = strconv.Atoi(lo); err != nil || r.MinZoom < 0 {
+				return 0, nil, fmt.Errorf("invalid quality zoom %q in %q", lo, part)
+			}
+			r.MaxZoom = r.MinZoom
+			if isRange {
+				if r.MaxZoom, err = strconv.Atoi(strings.TrimPrefix(hi, "z")); err != nil || r.MaxZoom < r.MinZoom {
+					return 0, nil, fmt.Errorf("invalid quality zoom range %q in %q", zooms, part)
+				}
+			}
+		}
+		for _, o := range ranges {
+			if r.overlaps(o) {
+				return 0, nil, fmt.Errorf("quality zoom range %q overlaps an earlier one", part)
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return def, ranges, nil
+}
+
+// parseQualityValue parses one quality: 1-100 or "lossless".
+func parseQualityValue(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "lossless") {
+		return encode.Lossless, nil
+	}
+	q, err := strconv.Atoi(s)
+	if err != nil || q < 1 || q > 100 {
+		return 0, fmt.Errorf("invalid quality %q (want 1-100 or lossless)", s)
+	}
+	return q, nil
+}
+
+func (r QualityRange) contains(z int) bool {
+	return z >= r.MinZoom && (r.MaxZoom < 0 || z <= r.MaxZoom)
+}
+
+func (r QualityRange) overlaps(o QualityRange) bool {
+	return (r.MaxZoom < 0 || r.MaxZoom >= o.MinZoom) && (o.MaxZoom < 0 || o.MaxZoom >= r.MinZoom)
+}
+
+// ZoomQualities returns the quality of each zoom in minZoom..maxZoom:
+// that of the range containing it, else def. With QualityPlan.Encoders it
+// gives the per-zoom encoders for Config.ZoomEncoders.
+func ZoomQualities(def int, ranges []QualityRange, minZoom, maxZoom int) map[int]int {
+	qualities := make(map[int]int, maxZoom-minZoom+1)
+	for z := minZoom; z <= maxZoom; z++ {
+		qualities[z] = def
+		for _, r := range ranges {
+			if r.contains(z) {
+				qualities[z] = r.Quality
+				break
+			}
+		}
+	}
+	return qualities
+}
@@ -0,0 +1,42 @@
+package tile
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+)
+
+func TestParseQuality(t *testing.T) {
+	def, ranges, err := ParseQuality("z0-10:75, z11-14:85,z15+:lossless")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []QualityRange{{0, 10, 75}, {11, 14, 85}, {15, -1, encode.Lossless}}
+	if def != 0 || !reflect.DeepEqual(ranges, want) {
+		t.Errorf("ParseQuality = %d, %+v, want 0, %+v", def, ranges, want)
+	}
+	got := ZoomQualities(90, ranges, 9, 16)
+	wantQ := map[int]int{9: 75, 10: 75, 11: 85, 14: 85, 15: encode.Lossless, 16: encode.Lossless, 12: 85, 13: 85}
+	if !reflect.DeepEqual(got, wantQ) {
+		t.Errorf("ZoomQualities = %v, want %v", got, wantQ)
+	}
+
+	// A bare quality covers the zooms the ranges leave out.
+	def, ranges, err = ParseQuality("80,12:90")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ZoomQualities(def, ranges, 11, 13); !reflect.DeepEqual(got, map[int]int{11: 80, 12: 90, 13: 80}) {
+		t.Errorf("ZoomQualities with default = %v", got)
+	}
+	if def, ranges, err := ParseQuality("lossless"); err != nil || def != encode.Lossless || ranges != nil {
+		t.Errorf("ParseQuality(lossless) = %d, %v, %v", def, ranges, err)
+	}
+
+	for _, bad := range []string{"", "0", "101", "x", "80,90", "z5-3:80", "z-1:80", "z0-10:75,z10+:80", "z3+:80,z5:90", "z1:best"} {
+		if _, _, err := ParseQuality(bad); err == nil {
+			t.Errorf("ParseQuality(%q): expected an error", bad)
+		}
+	}
+}
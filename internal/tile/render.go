@@ -0,0 +1,108 @@
+package tile
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+)
+
+// Renderer renders and encodes individual tiles on demand from source COGs.
+//
+// Unlike Generate, which builds lower zoom levels by downsampling the level
+// above, a Renderer renders every zoom directly from the sources (using the
+// best overview level). This trades some per-tile cost for random access,
+// which is what an on-demand tile server needs. Safe for concurrent use.
+type Renderer struct {
+	cfg        Config
+	proj       coord.Projection
+	srcInfos   []sourceInfo // read-only after construction
+	cogCache   *cog.TileCache
+	floatCache *cog.FloatTileCache
+	luts       *gammaLUTs
+}
+
+// NewRenderer creates a Renderer for the given configuration and sources.
+// Config.MinZoom/MaxZoom bound the zoom levels that will be rendered.
+func NewRenderer(cfg Config, sources []*cog.Reader) (*Renderer, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no source files")
+	}
+	proj, err := sources[0].Projection()
+	if err != nil {
+		return nil, err
+	}
+
+	cacheSize := cfg.sourceCacheSize()
+	r := &Renderer{
+		cfg:      cfg,
+		proj:     proj,
+		srcInfos: buildSourceInfos(sources, cfg.backfill(), proj, !cfg.InputOrder),
+		cogCache: cog.NewTileCache(cacheSize),
+	}
+	if cfg.floatSources() {
+		r.floatCache = cog.NewFloatTileCache(cacheSize)
+	} else {
+		r.luts = buildGammaLUTs(cfg.ResamplingGamma)
+	}
+	return r, nil
+}
+
+// InRange reports whether z/x/y lies within the configured zoom range and
+// the tile grid covering Config.Bounds.
+func (r *Renderer) InRange(z, x, y int) bool {
+	if z < r.cfg.MinZoom || z > r.cfg.MaxZoom {
+		return false
+	}
+	b := r.cfg.Bounds
+	minX, minY, maxX, maxY := coord.TileRange(z, b.MinLon, b.MinLat, b.MaxLon, b.MaxLat, coord.EdgeExclusive)
+	return x >= minX && x <= maxX && y >= minY && y <= maxY
+}
+
+// RenderTile renders and encodes the tile at z/x/y. It returns nil, nil when
+// the tile has no data (and no fill color is configured, or the layer is an
+// overlay) or lies outside the configured range.
+func (r *Renderer) RenderTile(z, x, y int) ([]byte, error) {
+	if !r.InRange(z, x, y) {
+		return nil, nil
+	}
+
+	var img *image.RGBA
+	switch {
+	case r.cfg.Hillshade != nil:
+		img = renderTileHillshade(z, x, y, r.cfg.TileSize, r.srcInfos, r.proj, nil, r.floatCache, r.cfg.Resampling, r.cfg.FillVoids, *r.cfg.Hillshade)
+	case r.cfg.ColorMap != nil:
+		img = renderTileColorMap(z, x, y, r.cfg.TileSize, r.srcInfos, r.proj, nil, r.floatCache, r.cfg.Resampling, r.cfg.ColorMap)
+	case r.cfg.IsTerrarium:
+		img = renderTileTerrarium(z, x, y, r.cfg.TileSize, r.srcInfos, r.proj, nil, nil, r.floatCache, r.cfg.Resampling, r.cfg.FillVoids, r.cfg.DEMEncoding)
+	default:
+		img = renderTile(z, x, y, r.cfg.TileSize, r.srcInfos, r.proj, nil, nil, r.cogCache, r.cfg.Resampling, r.luts)
+	}
+
+	var td *TileData
+	if img != nil {
+		if r.cfg.FillColor != nil {
+			applyFillColorTransform(img, *r.cfg.FillColor)
+		}
+		td = newTileData(img, r.cfg.TileSize)
+	} else if r.cfg.FillColor != nil && !r.cfg.Overlay {
+		td = newTileDataUniform(*r.cfg.FillColor, r.cfg.TileSize)
+	} else {
+		return nil, nil
+	}
+	defer td.Release()
+
+	enc := r.cfg.encoderForZoom(z)
+	var data []byte
+	var err error
+	if r.cfg.DebugOverlay != nil {
+		data, err = encodeWithOverlay(nil, enc, td, r.cfg.DebugOverlay, z, x, y)
+	} else {
+		data, err = enc.Encode(td.AsImage())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encoding tile z%d/%d/%d: %w", z, x, y, err)
+	}
+	return data, nil
+}
@@ -1,9 +1,11 @@
 package tile
 
 import (
+	"cmp"
 	"image"
+	"image/color"
 	"math"
-	"strconv"
+	"slices"
 	"sync"
 
 	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
@@ -37,27 +39,132 @@ func putLonLat(backing []float64) {
 	lonLatPool.Put(backing)
 }
 
+// crsGrid holds the source CRS coordinates of every pixel centre of one
+// output tile. Layers of a GenerateLayers run whose sources share a CRS
+// render the same tile back to back and project each pixel only once.
+type crsGrid struct {
+	tile  [3]int
+	valid bool
+	xy    []float64 // x, y per pixel, row by row
+}
+
+// project fills the grid for tile z/tx/ty unless it already holds it.
+func (c *crsGrid) project(z, tx, ty, tileSize int, proj coord.Projection) {
+	t := [3]int{z, tx, ty}
+	if c.valid && c.tile == t {
+		return
+	}
+	if n := 2 * tileSize * tileSize; len(c.xy) != n {
+		c.xy = make([]float64, n)
+	}
+	lons, lats, llBacking := getLonLat(tileSize)
+	for px := 0; px < tileSize; px++ {
+		lons[px], _ = coord.PixelToLonLat(z, tx, ty, tileSize, float64(px)+0.5, 0)
+	}
+	for py := 0; py < tileSize; py++ {
+		_, lats[py] = coord.PixelToLonLat(z, tx, ty, tileSize, 0, float64(py)+0.5)
+	}
+	i := 0
+	for py := 0; py < tileSize; py++ {
+		for px := 0; px < tileSize; px++ {
+			c.xy[i], c.xy[i+1] = proj.FromWGS84(lons[px], lats[py])
+			i += 2
+		}
+	}
+	putLonLat(llBacking)
+	c.tile, c.valid = t, true
+}
+
+// sampleScratch is a worker's scratch state for rendering from the sources:
+// the current tile's source list and the temporaries of the sampling
+// kernels. It is passed explicitly down to the kernels, so sampling a pixel
+// allocates nothing and clears only what the kernel uses (the mode
+// histogram alone is over 2 KiB). Not safe for concurrent use; renderTile
+// and renderTileTerrarium create one per tile when given nil.
+type sampleScratch struct {
+	srcs   []tileSource // sources of the tile being rendered
+	hist   modeHistogram
+	kernel kernelScratch
+}
+
+// kernelScratch holds the temporaries of one separable kernel sample:
+// Lanczos-3 uses all 6 taps per axis, bicubic the first 4. The neighbourhood
+// spans at most 2×2 source tiles.
+type kernelScratch struct {
+	wx, wy [6]float64 // tap weights
+	px, py [6]int     // tap pixel coordinates, clamped to the image
+	lx, ly [6]int     // tap coordinates within their source tile
+	tc, tr [6]int     // tap tile column/row, relative to the first tile
+
+	tiles  [2][2]image.Image
+	tileOK [2][2]bool
+	ftData [2][2][]float32 // float tiles (Terrarium)
+	ftW    [2][2]int
+	ftOK   [2][2]bool
+
+	// nanFallbacks counts float samples that fell back to the nearest
+	// pixel because of NaN taps; the tile worker reads and resets it per
+	// tile (Stats.NaNFallbacks).
+	nanFallbacks int
+}
+
+// release drops the references to source tiles and readers after a tile,
+// so the scratch does not keep tiles alive that the cache has evicted.
+func (s *sampleScratch) release() {
+	clear(s.srcs)
+	s.srcs = s.srcs[:0]
+	s.kernel.tiles = [2][2]image.Image{}
+	s.kernel.ftData = [2][2][]float32{}
+}
+
 // sourceInfo caches per-source metadata used during rendering and prefetching.
 type sourceInfo struct {
-	reader  *cog.Reader
-	minCRSX float64
-	minCRSY float64
-	maxCRSX float64
-	maxCRSY float64
-	geo     cog.GeoInfo
+	reader    RasterSource
+	minCRSX   float64
+	minCRSY   float64
+	maxCRSX   float64
+	maxCRSY   float64
+	geo       cog.GeoInfo
+	pixelSize float64 // native pixel size in CRS units for ranking; 0 = keep input order
+	backfill  bool    // sampled only where no other source has data (Config.Backfill)
+	// reproject converts render CRS coordinates to the source's CRS, for
+	// backfill sources in another CRS; nil for sources in the render CRS.
+	reproject func(x, y float64) (float64, float64)
 }
 
-func buildSourceInfos(sources []*cog.Reader) []sourceInfo {
-	infos := make([]sourceInfo, len(sources))
-	for i, src := range sources {
+// buildSourceInfos pre-computes per-source bounds. With byResolution, each
+// tile tries its sources finest first (see rankTileSources); otherwise in
+// input order. The backfill sources follow all others, in their order;
+// those not in proj's CRS are reprojected per pixel.
+func buildSourceInfos(sources []*cog.Reader, backfill []RasterSource, proj coord.Projection, byResolution bool) []sourceInfo {
+	infos := make([]sourceInfo, 0, len(sources)+len(backfill))
+	add := func(src RasterSource) *sourceInfo {
 		minX, minY, maxX, maxY := src.BoundsInCRS()
-		infos[i] = sourceInfo{
+		infos = append(infos, sourceInfo{
 			reader:  src,
 			minCRSX: minX,
 			minCRSY: minY,
 			maxCRSX: maxX,
 			maxCRSY: maxY,
 			geo:     src.GeoInfo(),
+		})
+		return &infos[len(infos)-1]
+	}
+	for _, src := range sources {
+		info := add(src)
+		if byResolution {
+			info.pixelSize = src.PixelSize()
+		}
+	}
+	for _, src := range backfill {
+		info := add(src)
+		info.backfill = true
+		if epsg := info.geo.EPSG; proj != nil && epsg != proj.EPSG() {
+			if srcProj := coord.ForEPSG(epsg); srcProj != nil {
+				info.reproject = func(x, y float64) (float64, float64) {
+					return srcProj.FromWGS84(proj.ToWGS84(x, y))
+				}
+			}
 		}
 	}
 	return infos
@@ -68,65 +175,161 @@ func buildSourceInfos(sources []*cog.Reader) []sourceInfo {
 // pixels within a single output tile, so computing them once per tile instead
 // of per pixel eliminates millions of redundant OverviewForZoom iterations.
 type tileSource struct {
-	reader         *cog.Reader
-	geo            cog.GeoInfo
-	minCRSX        float64
-	minCRSY        float64
-	maxCRSX        float64
-	maxCRSY        float64
-	level          int
-	levelPixelSize float64
-	imgW           int
-	imgH           int
-	tileW          int // source tile width (pixels per COG tile)
-	tileH          int // source tile height (pixels per COG tile)
+	reader          RasterSource
+	geo             cog.GeoInfo
+	pixelSize       float64 // native pixel size (sourceInfo.pixelSize)
+	minCRSX         float64
+	minCRSY         float64
+	maxCRSX         float64
+	maxCRSY         float64
+	level           int
+	levelPixelSize  float64 // pixel width at level in CRS units
+	levelPixelSizeY float64 // pixel height at level, unlike the width for non-square pixels
+	imgW            int
+	imgH            int
+	tileW           int     // source tile width (pixels per COG tile)
+	tileH           int     // source tile height (pixels per COG tile)
+	footprint       float64 // output pixel size in source pixels at level (mode sampling)
+	backfill        bool
+	reproject       func(x, y float64) (float64, float64) // sourceInfo.reproject
 }
 
 // prepareTileSources filters the full source list to only those overlapping
 // the output tile's CRS bounding box, and pre-computes the overview level
 // and pixel dimensions for each. The returned slice is typically much smaller
 // than the full source list, dramatically reducing per-pixel iteration.
-func prepareTileSources(srcInfos []sourceInfo, outputResCRS float64, tileMinCRSX, tileMinCRSY, tileMaxCRSX, tileMaxCRSY float64) []tileSource {
-	var result []tileSource
+// The sources are appended to dst[:0], so a worker reuses one slice.
+func prepareTileSources(dst []tileSource, srcInfos []sourceInfo, outputResCRS float64, tileMinCRSX, tileMinCRSY, tileMaxCRSX, tileMaxCRSY float64) []tileSource {
+	result := dst[:0]
 	for i := range srcInfos {
 		src := &srcInfos[i]
+		minX, minY, maxX, maxY, res := tileMinCRSX, tileMinCRSY, tileMaxCRSX, tileMaxCRSY, outputResCRS
+		if src.reproject != nil {
+			minX, minY, maxX, maxY = reprojectBox(src.reproject, minX, minY, maxX, maxY)
+			res = outputResCRS * (maxX - minX) / (tileMaxCRSX - tileMinCRSX)
+		}
 		// Skip sources that don't overlap the output tile.
-		if tileMaxCRSX < src.minCRSX || tileMinCRSX > src.maxCRSX ||
-			tileMaxCRSY < src.minCRSY || tileMinCRSY > src.maxCRSY {
+		if maxX < src.minCRSX || minX > src.maxCRSX ||
+			maxY < src.minCRSY || minY > src.maxCRSY {
 			continue
 		}
-		level := src.reader.OverviewForZoom(outputResCRS)
+		level := src.reader.OverviewForZoom(res)
 		ifd := src.reader.IFDTileSize(level)
 		result = append(result, tileSource{
-			reader:         src.reader,
-			geo:            src.geo,
-			minCRSX:        src.minCRSX,
-			minCRSY:        src.minCRSY,
-			maxCRSX:        src.maxCRSX,
-			maxCRSY:        src.maxCRSY,
-			level:          level,
-			levelPixelSize: src.reader.IFDPixelSize(level),
-			imgW:           src.reader.IFDWidth(level),
-			imgH:           src.reader.IFDHeight(level),
-			tileW:          ifd[0],
-			tileH:          ifd[1],
+			reader:          src.reader,
+			geo:             src.geo,
+			pixelSize:       src.pixelSize,
+			minCRSX:         src.minCRSX,
+			minCRSY:         src.minCRSY,
+			maxCRSX:         src.maxCRSX,
+			maxCRSY:         src.maxCRSY,
+			level:           level,
+			levelPixelSize:  src.reader.IFDPixelSize(level),
+			levelPixelSizeY: src.reader.IFDPixelSizeY(level),
+			imgW:            src.reader.IFDWidth(level),
+			imgH:            src.reader.IFDHeight(level),
+			tileW:           ifd[0],
+			tileH:           ifd[1],
+			footprint:       res / src.reader.IFDPixelSize(level),
+			backfill:        src.backfill,
+			reproject:       src.reproject,
 		})
 	}
+	rankTileSources(result, outputResCRS)
 	return result
 }
 
+// reprojectBox returns the bounding box, in a source's CRS, of a render CRS
+// box, from a 3x3 grid of points over it. Points that do not project to
+// finite coordinates are ignored.
+func reprojectBox(reproject func(x, y float64) (float64, float64), minX, minY, maxX, maxY float64) (float64, float64, float64, float64) {
+	bMinX, bMinY := math.Inf(1), math.Inf(1)
+	bMaxX, bMaxY := math.Inf(-1), math.Inf(-1)
+	for i := 0; i <= 2; i++ {
+		for j := 0; j <= 2; j++ {
+			x, y := reproject(minX+(maxX-minX)*float64(i)/2, minY+(maxY-minY)*float64(j)/2)
+			if math.IsNaN(x) || math.IsNaN(y) || math.IsInf(x, 0) || math.IsInf(y, 0) {
+				continue
+			}
+			bMinX, bMaxX = math.Min(bMinX, x), math.Max(bMaxX, x)
+			bMinY, bMaxY = math.Min(bMinY, y), math.Max(bMaxY, y)
+		}
+	}
+	return bMinX, bMinY, bMaxX, bMaxY
+}
+
+// rankTileSources orders a tile's sources so that per-pixel sampling, which
+// takes the first source with data, prefers the sharpest one. Every source
+// at least as fine as the output ranks equally and keeps its input order,
+// since it delivers full detail either way. Coarser sources follow,
+// finest first, and only fill pixels the finer ones leave uncovered.
+// Sources without a pixelSize (input order requested) all rank equally.
+// Backfill sources come last, in their own order.
+func rankTileSources(srcs []tileSource, outputResCRS float64) {
+	if len(srcs) < 2 {
+		return
+	}
+	score := func(s *tileSource) float64 {
+		if s.backfill {
+			return math.Inf(1)
+		}
+		if s.pixelSize <= outputResCRS {
+			return 0
+		}
+		return s.pixelSize / outputResCRS
+	}
+	slices.SortStableFunc(srcs, func(a, b tileSource) int {
+		return cmp.Compare(score(&a), score(&b))
+	})
+}
+
+// Below densifyZoom a tile spans enough longitude and latitude that the
+// projected tile edges can bulge past the hull of the four projected
+// corners (e.g. LV95 at continental scale), and sources inside the bulge
+// would be skipped, leaving missing slivers. Such tiles are bounded from
+// densifyPoints segments per edge instead.
+const (
+	densifyZoom   = 8
+	densifyPoints = 16
+)
+
 // tileCRSBounds computes the CRS bounding box of an output tile by projecting
-// all four corners and taking the extremes.
+// its edges (corners only from densifyZoom up).
 func tileCRSBounds(z, tx, ty int, proj coord.Projection) (minX, minY, maxX, maxY float64) {
 	minLon, minLat, maxLon, maxLat := coord.TileBounds(z, tx, ty)
-	x1, y1 := proj.FromWGS84(minLon, minLat)
-	x2, y2 := proj.FromWGS84(minLon, maxLat)
-	x3, y3 := proj.FromWGS84(maxLon, minLat)
-	x4, y4 := proj.FromWGS84(maxLon, maxLat)
-	minX = math.Min(math.Min(x1, x2), math.Min(x3, x4))
-	maxX = math.Max(math.Max(x1, x2), math.Max(x3, x4))
-	minY = math.Min(math.Min(y1, y2), math.Min(y3, y4))
-	maxY = math.Max(math.Max(y1, y2), math.Max(y3, y4))
+	return lonLatBoxCRSBounds(z, minLon, minLat, maxLon, maxLat, proj)
+}
+
+// lonLatBoxCRSBounds returns the CRS bounding box of a lon/lat box at zoom
+// z, sampling each edge at densifyPoints segments below densifyZoom and at
+// the corners otherwise. Samples that do not project to finite coordinates
+// are ignored.
+func lonLatBoxCRSBounds(z int, minLon, minLat, maxLon, maxLat float64, proj coord.Projection) (minX, minY, maxX, maxY float64) {
+	minX, minY = math.Inf(1), math.Inf(1)
+	maxX, maxY = math.Inf(-1), math.Inf(-1)
+	add := func(lon, lat float64) {
+		x, y := proj.FromWGS84(lon, lat)
+		if math.IsNaN(x) || math.IsNaN(y) || math.IsInf(x, 0) || math.IsInf(y, 0) {
+			return
+		}
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+
+	n := 1
+	if z < densifyZoom {
+		n = densifyPoints
+	}
+	for i := 0; i <= n; i++ {
+		lon := minLon + (maxLon-minLon)*float64(i)/float64(n)
+		add(lon, minLat)
+		add(lon, maxLat)
+	}
+	for i := 1; i < n; i++ {
+		lat := minLat + (maxLat-minLat)*float64(i)/float64(n)
+		add(minLon, lat)
+		add(maxLon, lat)
+	}
 	return
 }
 
@@ -136,21 +339,31 @@ func tileCRSBounds(z, tx, ty int, proj coord.Projection) (minX, minY, maxX, maxY
 // expensive trig: Atan, Sinh — 6% of CPU), we precompute longitude per column
 // and latitude per row. In web Mercator tiles, longitude is perfectly linear
 // with pixel X and latitude depends only on pixel Y, so we reduce trig calls
-// from O(tileSize²) to O(tileSize).
-func renderTile(z, tx, ty, tileSize int, srcInfos []sourceInfo, proj coord.Projection, cache *cog.TileCache, mode Resampling, luts *gammaLUTs) *image.RGBA {
+// from O(tileSize²) to O(tileSize). A non-nil grid supplies the projected
+// pixel coordinates instead, filled only if the tile has sources (see crsGrid).
+func renderTile(z, tx, ty, tileSize int, srcInfos []sourceInfo, proj coord.Projection, grid *crsGrid, scratch *sampleScratch, cache *cog.TileCache, mode Resampling, luts *gammaLUTs) *image.RGBA {
 	// Pre-compute the output pixel size in CRS units for selecting the best overview level.
 	_, midLat, _, _ := coord.TileBounds(z, tx, ty)
 	outputResMeters := coord.ResolutionAtLat(midLat, z, tileSize)
 	outputResCRS := coord.MetersToPixelSizeCRS(outputResMeters, proj.EPSG(), midLat)
 
+	if scratch == nil {
+		scratch = new(sampleScratch)
+	}
+	defer scratch.release()
+
 	// Pre-filter sources to only those overlapping this tile.
 	tileMinX, tileMinY, tileMaxX, tileMaxY := tileCRSBounds(z, tx, ty, proj)
-	tileSrcs := prepareTileSources(srcInfos, outputResCRS, tileMinX, tileMinY, tileMaxX, tileMaxY)
+	tileSrcs := prepareTileSources(scratch.srcs, srcInfos, outputResCRS, tileMinX, tileMinY, tileMaxX, tileMaxY)
+	scratch.srcs = tileSrcs
 	if len(tileSrcs) == 0 {
 		return nil
 	}
 
 	img := GetRGBA(tileSize, tileSize)
+	if grid != nil {
+		grid.project(z, tx, ty, tileSize, proj)
+	}
 
 	// Precompute lon per column (linear with pixel X) and lat per row
 	// (non-linear in Mercator, but independent of X). This reduces
@@ -173,9 +386,15 @@ func renderTile(z, tx, ty, tileSize int, srcInfos []sourceInfo, proj coord.Proje
 
 		for px := 0; px < tileSize; px++ {
 			// Convert precomputed WGS84 to source CRS.
-			srcX, srcY := proj.FromWGS84(lons[px], lat)
+			var srcX, srcY float64
+			if grid != nil {
+				i := 2 * (py*tileSize + px)
+				srcX, srcY = grid.xy[i], grid.xy[i+1]
+			} else {
+				srcX, srcY = proj.FromWGS84(lons[px], lat)
+			}
 
-			r, g, b, a, found := sampleFromTileSources(tileSrcs, srcX, srcY, cache, mode, luts)
+			r, g, b, a, found := sampleFromTileSources(tileSrcs, srcX, srcY, cache, mode, luts, scratch)
 			if found {
 				off := rowOff + px*4
 				img.Pix[off+0] = r
@@ -197,59 +416,112 @@ func renderTile(z, tx, ty, tileSize int, srcInfos []sourceInfo, proj coord.Proje
 	return img
 }
 
+// seamHalo is how far, in source pixels, a point may lie outside a source
+// and still be sampled from it when it falls in a gap between sources.
+const seamHalo = 0.5
+
+// sourcePixel converts CRS coordinates to pixel coordinates at the source's
+// pre-computed level. inside reports whether the point lies within the
+// source; near whether it lies within seamHalo pixels of it.
+func (src *tileSource) sourcePixel(srcX, srcY float64) (pixX, pixY float64, inside, near bool) {
+	if src.reproject != nil {
+		srcX, srcY = src.reproject(srcX, srcY)
+	}
+	haloX, haloY := seamHalo*src.levelPixelSize, seamHalo*src.levelPixelSizeY
+	if srcX < src.minCRSX-haloX || srcX > src.maxCRSX+haloX || srcY < src.minCRSY-haloY || srcY > src.maxCRSY+haloY {
+		return 0, 0, false, false
+	}
+	pixX = (srcX - src.geo.OriginX) / src.levelPixelSize
+	pixY = (src.geo.OriginY - srcY) / src.levelPixelSizeY
+	w, h := float64(src.imgW), float64(src.imgH)
+	inside = srcX >= src.minCRSX && srcX <= src.maxCRSX && srcY >= src.minCRSY && srcY <= src.maxCRSY &&
+		pixX >= 0 && pixX < w && pixY >= 0 && pixY < h
+	near = pixX >= -seamHalo && pixX < w+seamHalo && pixY >= -seamHalo && pixY < h+seamHalo
+	return pixX, pixY, inside, near
+}
+
+// seamCandidates collects, for a point inside no source, the sources it
+// lies within seamHalo pixels of. Two or more mean the point falls in a
+// hairline gap between adjacent sources (abutting edges that differ by
+// rounding, or a sub-pixel gap between tie points); a single one is the
+// outer edge of the data, which stays sharp.
+type seamCandidates struct {
+	idx  [4]int
+	pixX [4]float64
+	pixY [4]float64
+	n    int
+}
+
+func (c *seamCandidates) add(i int, pixX, pixY float64) {
+	if c.n < len(c.idx) {
+		c.idx[c.n], c.pixX[c.n], c.pixY[c.n] = i, pixX, pixY
+	}
+	c.n++
+}
+
 // sampleFromTileSources tries each pre-filtered tile source to sample a pixel
 // at the given CRS coordinates. Uses pre-computed overview levels and dimensions
 // to avoid redundant per-pixel computation.
-func sampleFromTileSources(sources []tileSource, srcX, srcY float64, cache *cog.TileCache, mode Resampling, luts *gammaLUTs) (r, g, b, a uint8, found bool) {
+//
+// A point inside no source but within half a source pixel of two of them
+// is sampled from the first such source with its edge extended (the
+// kernels clamp to the image), which closes one-pixel transparent seams
+// between sources that abut exactly.
+func sampleFromTileSources(sources []tileSource, srcX, srcY float64, cache *cog.TileCache, mode Resampling, luts *gammaLUTs, s *sampleScratch) (r, g, b, a uint8, found bool) {
+	var seam seamCandidates
 	for i := range sources {
 		src := &sources[i]
-
-		// Check if point is within this source's bounds.
-		if srcX < src.minCRSX || srcX > src.maxCRSX || srcY < src.minCRSY || srcY > src.maxCRSY {
-			continue
-		}
-
-		// Convert CRS coordinates to pixel coordinates using pre-computed level data.
-		pixX := (srcX - src.geo.OriginX) / src.levelPixelSize
-		pixY := (src.geo.OriginY - srcY) / src.levelPixelSize
-
-		// Check bounds.
-		if pixX < 0 || pixX >= float64(src.imgW) || pixY < 0 || pixY >= float64(src.imgH) {
+		pixX, pixY, inside, near := src.sourcePixel(srcX, srcY)
+		if !inside {
+			if near {
+				seam.add(i, pixX, pixY)
+			}
 			continue
 		}
-
-		var rr, gg, bb, aa uint8
-		var err error
-
-		switch mode {
-		case ResamplingNearest, ResamplingMode:
-			rr, gg, bb, aa, err = nearestSampleCached(src.reader, src.level, pixX, pixY, src.imgW, src.imgH, src.tileW, src.tileH, cache)
-		case ResamplingLanczos:
-			rr, gg, bb, aa, err = lanczosSampleCached(src.reader, src.level, pixX, pixY, src.imgW, src.imgH, src.tileW, src.tileH, cache, luts)
-		case ResamplingBicubic:
-			rr, gg, bb, aa, err = bicubicSampleCached(src.reader, src.level, pixX, pixY, src.imgW, src.imgH, src.tileW, src.tileH, cache, luts)
-		default:
-			rr, gg, bb, aa, err = bilinearSampleCached(src.reader, src.level, pixX, pixY, src.imgW, src.imgH, src.tileW, src.tileH, cache, luts)
-		}
-
-		if err != nil {
-			continue
+		if r, g, b, a, found = sampleTileSource(src, pixX, pixY, cache, mode, luts, s); found {
+			return
 		}
-		if aa == 0 {
-			continue // nodata/transparent — try next source
+	}
+	if seam.n >= 2 {
+		for k := 0; k < seam.n && k < len(seam.idx); k++ {
+			if r, g, b, a, found = sampleTileSource(&sources[seam.idx[k]], seam.pixX[k], seam.pixY[k], cache, mode, luts, s); found {
+				return
+			}
 		}
-		return rr, gg, bb, aa, true
 	}
 	return 0, 0, 0, 0, false
 }
 
+// sampleTileSource samples src at pixel coordinates with the given kernel.
+// found is false on read errors and for transparent (nodata) samples, so
+// the caller can try the next source.
+func sampleTileSource(src *tileSource, pixX, pixY float64, cache *cog.TileCache, mode Resampling, luts *gammaLUTs, s *sampleScratch) (r, g, b, a uint8, found bool) {
+	var err error
+	switch mode {
+	case ResamplingMode:
+		r, g, b, a, err = modeSampleCached(src.reader, src.level, pixX, pixY, src.footprint, src.imgW, src.imgH, src.tileW, src.tileH, cache, &s.hist)
+	case ResamplingNearest:
+		r, g, b, a, err = nearestSampleCached(src.reader, src.level, pixX, pixY, src.imgW, src.imgH, src.tileW, src.tileH, cache)
+	case ResamplingLanczos:
+		r, g, b, a, err = lanczosSampleCached(src.reader, src.level, pixX, pixY, src.imgW, src.imgH, src.tileW, src.tileH, cache, luts, &s.kernel)
+	case ResamplingBicubic:
+		r, g, b, a, err = bicubicSampleCached(src.reader, src.level, pixX, pixY, src.imgW, src.imgH, src.tileW, src.tileH, cache, luts, &s.kernel)
+	default:
+		r, g, b, a, err = bilinearSampleCached(src.reader, src.level, pixX, pixY, src.imgW, src.imgH, src.tileW, src.tileH, cache, luts)
+	}
+	if err != nil || a == 0 {
+		return 0, 0, 0, 0, false // read error or nodata/transparent — try next source
+	}
+	return r, g, b, a, true
+}
+
 // nearestSampleCached reads the nearest (closest) source pixel.
 // imgW and imgH are the image dimensions at this level, used to clamp
 // the rounded pixel coordinate so it never exceeds the valid range.
 // Without clamping, Floor(fx+0.5) can produce imgW when fx >= imgW-0.5,
 // reading from the zero-padded overhang of the last COG tile.
 // tw and th are the source tile dimensions (pre-computed by prepareTileSources).
-func nearestSampleCached(src *cog.Reader, level int, fx, fy float64, imgW, imgH, tw, th int, cache *cog.TileCache) (uint8, uint8, uint8, uint8, error) {
+func nearestSampleCached(src RasterSource, level int, fx, fy float64, imgW, imgH, tw, th int, cache *cog.TileCache) (uint8, uint8, uint8, uint8, error) {
 	px := clamp(int(math.Floor(fx+0.5)), 0, imgW-1)
 	py := clamp(int(math.Floor(fy+0.5)), 0, imgH-1)
 
@@ -260,6 +532,114 @@ func nearestSampleCached(src *cog.Reader, level int, fx, fy float64, imgW, imgH,
 	return p[0], p[1], p[2], p[3], nil
 }
 
+// modeMaxSpan caps the source pixels read per axis for one mode sample.
+// Wider footprints (no suitable overview) are read at an even stride, so a
+// sample costs at most modeMaxSpan² reads.
+const modeMaxSpan = 16
+
+// modeHistogram counts RGBA values for one mode sample. It holds one more
+// than modeMaxSpan² distinct values (the nearest pixel may lie off the
+// strided grid), so it never overflows; a linear scan with
+// a last-hit shortcut beats a map for categorical data, which has few
+// classes and long runs of equal pixels.
+type modeHistogram struct {
+	keys   [modeMaxSpan*modeMaxSpan + 1]uint32
+	counts [modeMaxSpan*modeMaxSpan + 1]int32
+	n      int
+	last   int
+}
+
+// reset empties the histogram for the next sample. Only n and last are
+// cleared; entries at and beyond n are never read.
+func (h *modeHistogram) reset() {
+	h.n, h.last = 0, 0
+}
+
+// add counts key and returns its new count.
+func (h *modeHistogram) add(key uint32) int32 {
+	if h.last < h.n && h.keys[h.last] == key {
+		h.counts[h.last]++
+		return h.counts[h.last]
+	}
+	for i := 0; i < h.n; i++ {
+		if h.keys[i] == key {
+			h.counts[i]++
+			h.last = i
+			return h.counts[i]
+		}
+	}
+	h.keys[h.n], h.counts[h.n] = key, 1
+	h.last = h.n
+	h.n++
+	return 1
+}
+
+// modeSampleCached returns the most common value among the source pixels
+// whose centers fall inside the output pixel's footprint, a square of
+// footprint source pixels centered on (fx, fy). Transparent pixels don't
+// vote. Ties go to the value that reached the winning count first, and the
+// nearest pixel votes first, so a footprint of one pixel or less (no centers
+// inside) and a split vote both resolve like nearestSampleCached.
+//
+// Pixels are read row by row from the decoded tile that holds them; a new
+// tile is fetched only when the footprint crosses a tile boundary.
+func modeSampleCached(src RasterSource, level int, fx, fy, footprint float64, imgW, imgH, tw, th int, cache *cog.TileCache, h *modeHistogram) (uint8, uint8, uint8, uint8, error) {
+	nx := clamp(int(math.Floor(fx+0.5)), 0, imgW-1)
+	ny := clamp(int(math.Floor(fy+0.5)), 0, imgH-1)
+	p, err := readPixelCached(src, level, nx, ny, tw, th, cache)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	if footprint <= 1 {
+		return p[0], p[1], p[2], p[3], nil
+	}
+
+	half := footprint / 2
+	x0 := clamp(int(math.Ceil(fx-half)), 0, imgW-1)
+	x1 := clamp(int(math.Floor(fx+half)), 0, imgW-1)
+	y0 := clamp(int(math.Ceil(fy-half)), 0, imgH-1)
+	y1 := clamp(int(math.Floor(fy+half)), 0, imgH-1)
+	stepX := (x1-x0)/modeMaxSpan + 1
+	stepY := (y1-y0)/modeMaxSpan + 1
+
+	h.reset()
+	best, bestCount := [4]uint8{}, int32(0)
+	if p[3] != 0 {
+		best, bestCount = p, h.add(packRGBA(p))
+	}
+
+	var tile image.Image
+	tileCol, tileRow := -1, -1
+	for y := y0; y <= y1; y += stepY {
+		row := y / th
+		for x := x0; x <= x1; x += stepX {
+			if x == nx && y == ny {
+				continue // already counted
+			}
+			if col := x / tw; col != tileCol || row != tileRow {
+				tile, err = fetchTileCached(src, level, col, row, cache)
+				if err != nil {
+					return 0, 0, 0, 0, err
+				}
+				tileCol, tileRow = col, row
+			}
+			q := pixelFromImage(tile, x%tw, y%th)
+			if q[3] == 0 {
+				continue
+			}
+			if c := h.add(packRGBA(q)); c > bestCount {
+				best, bestCount = q, c
+			}
+		}
+	}
+	return best[0], best[1], best[2], best[3], nil
+}
+
+// packRGBA packs a pixel into a histogram key.
+func packRGBA(p [4]uint8) uint32 {
+	return uint32(p[0])<<24 | uint32(p[1])<<16 | uint32(p[2])<<8 | uint32(p[3])
+}
+
 // bilinearSampleCached performs bilinear interpolation using the tile cache.
 // Pixels with alpha == 0 are treated as nodata and excluded from RGB
 // interpolation so they don't bleed dark colors into the result.  Alpha is
@@ -268,7 +648,7 @@ func nearestSampleCached(src *cog.Reader, level int, fx, fy float64, imgW, imgH,
 // Optimized to do at most 2 cache lookups (instead of 4): pixels in the same
 // source tile are extracted directly from the already-fetched image.
 // tw and th are the source tile dimensions (pre-computed by prepareTileSources).
-func bilinearSampleCached(src *cog.Reader, level int, fx, fy float64, imgW, imgH, tw, th int, cache *cog.TileCache, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
+func bilinearSampleCached(src RasterSource, level int, fx, fy float64, imgW, imgH, tw, th int, cache *cog.TileCache, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
 	x0 := int(math.Floor(fx))
 	y0 := int(math.Floor(fy))
 	x1 := x0 + 1
@@ -393,9 +773,10 @@ func bilinearSampleCached(src *cog.Reader, level int, fx, fy float64, imgW, imgH
 
 // lanczosSampleCached performs Lanczos-3 interpolation using the tile cache.
 // Uses a 6×6 pixel neighborhood for high-quality resampling with sharp detail
-// preservation. Pixels with alpha == 0 are excluded from RGB interpolation
-// so they don't bleed dark colors into the result. Alpha is interpolated
-// with the full kernel weights for smooth edge transitions.
+// preservation. Taps outside the image or the data footprint (alpha == 0)
+// are dropped and the remaining weights renormalized, for colour and alpha
+// alike, so edges stay crisp instead of fading or smearing outward. Output
+// pixels whose nearest source pixel is nodata are left transparent.
 //
 // Optimized to batch tile fetches: the 6×6 neighborhood spans at most 4 source
 // tiles (2×2 tile grid). We determine which unique tiles are needed, fetch each
@@ -406,7 +787,7 @@ func bilinearSampleCached(src *cog.Reader, level int, fx, fy float64, imgW, imgH
 // When all 36 pixels fall within a single YCbCr tile (the common case for JPEG
 // COGs), a specialized fast path avoids per-pixel type assertions and method
 // calls, inlining YCbCr offset computation and RGB conversion directly.
-func lanczosSampleCached(src *cog.Reader, level int, fx, fy float64, imgW, imgH, tw, th int, cache *cog.TileCache, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
+func lanczosSampleCached(src RasterSource, level int, fx, fy float64, imgW, imgH, tw, th int, cache *cog.TileCache, luts *gammaLUTs, ks *kernelScratch) (uint8, uint8, uint8, uint8, error) {
 	const a = 3
 	const n = 2 * a
 
@@ -414,14 +795,16 @@ func lanczosSampleCached(src *cog.Reader, level int, fx, fy float64, imgW, imgH,
 	iy0 := int(math.Floor(fy)) - a + 1
 
 	// Precompute 1D weights and clamped pixel coordinates.
-	var wxArr, wyArr [n]float64
-	var pxArr, pyArr [n]int
+	wxArr, wyArr := &ks.wx, &ks.wy
+	pxArr, pyArr := &ks.px, &ks.py
 	for k := 0; k < n; k++ {
 		pxArr[k] = clamp(ix0+k, 0, imgW-1)
 		pyArr[k] = clamp(iy0+k, 0, imgH-1)
 		wxArr[k] = lanczos3LUT(fx - float64(ix0+k))
 		wyArr[k] = lanczos3LUT(fy - float64(iy0+k))
 	}
+	dropOutsideTaps(wxArr[:n], ix0, imgW)
+	dropOutsideTaps(wyArr[:n], iy0, imgH)
 
 	// Determine the tile column/row range for the 6×6 neighborhood.
 	colMin := pxArr[0] / tw
@@ -430,13 +813,17 @@ func lanczosSampleCached(src *cog.Reader, level int, fx, fy float64, imgW, imgH,
 	rowMax := pyArr[n-1] / th
 
 	// Precompute local coordinates for all kernel positions.
-	var localX [n]int
-	var localY [n]int
+	localX, localY := &ks.lx, &ks.ly
 	for k := 0; k < n; k++ {
 		localX[k] = pxArr[k] % tw
 		localY[k] = pyArr[k] % th
 	}
 
+	// The kernel centre's nearest pixel decides whether the output pixel is
+	// inside the data footprint at all.
+	cx := int(math.Floor(fx+0.5)) - ix0
+	cy := int(math.Floor(fy+0.5)) - iy0
+
 	// Single-tile fast path: when all 36 pixels are in the same tile,
 	// skip per-pixel tile lookup and type assertion.
 	if colMin == colMax && rowMin == rowMax {
@@ -444,29 +831,32 @@ func lanczosSampleCached(src *cog.Reader, level int, fx, fy float64, imgW, imgH,
 		if err != nil {
 			return 0, 0, 0, 0, err
 		}
+		if alphaAt(tile, localX[cx], localY[cy]) == 0 {
+			return 0, 0, 0, 0, nil
+		}
 
 		// Try YCbCr fast path (most common for JPEG COGs).
 		if ycbcr, ok := tile.(*image.YCbCr); ok {
-			return lanczosAccumYCbCr(ycbcr, wxArr, wyArr, localX, localY, luts)
+			return lanczosAccumYCbCr(ycbcr, ks, luts)
 		}
 
 		// Try NYCbCrA fast path (JPEG with alpha).
 		if nycbcra, ok := tile.(*image.NYCbCrA); ok {
-			return lanczosAccumNYCbCrA(nycbcra, wxArr, wyArr, localX, localY, luts)
+			return lanczosAccumNYCbCrA(nycbcra, ks, luts)
 		}
 
 		// Try RGBA fast path (PNG tiles).
 		if rgba, ok := tile.(*image.RGBA); ok {
-			return lanczosAccumRGBA(rgba, wxArr, wyArr, localX, localY, luts)
+			return lanczosAccumRGBA(rgba, ks, luts)
 		}
 
 		// Generic fallback for single tile.
-		return lanczosAccumGeneric(tile, wxArr, wyArr, localX, localY, luts)
+		return lanczosAccumGeneric(tile, ks, luts)
 	}
 
 	// Multi-tile path: fetch the unique tiles (at most 2×2 = 4).
-	var tiles [2][2]image.Image
-	var tileOK [2][2]bool
+	tiles, tileOK := &ks.tiles, &ks.tileOK
+	*tileOK = [2][2]bool{}
 	for r := rowMin; r <= rowMax; r++ {
 		for c := colMin; c <= colMax; c++ {
 			tile, err := fetchTileCached(src, level, c, r, cache)
@@ -478,14 +868,17 @@ func lanczosSampleCached(src *cog.Reader, level int, fx, fy float64, imgW, imgH,
 	}
 
 	// Precompute per-position tile indices.
-	var tileColIdx [n]int
-	var tileRowIdx [n]int
+	tileColIdx, tileRowIdx := &ks.tc, &ks.tr
 	for k := 0; k < n; k++ {
 		tileColIdx[k] = pxArr[k]/tw - colMin
 		tileRowIdx[k] = pyArr[k]/th - rowMin
 	}
 
-	var rSum, gSum, bSum, aSum, wTotal, wRGB float64
+	if tr, tc := tileRowIdx[cy], tileColIdx[cx]; !tileOK[tr][tc] || alphaAt(tiles[tr][tc], localX[cx], localY[cy]) == 0 {
+		return 0, 0, 0, 0, nil
+	}
+
+	var rSum, gSum, bSum, aSum, wRGB float64
 
 	for ky := 0; ky < n; ky++ {
 		wyVal := wyArr[ky]
@@ -509,7 +902,6 @@ func lanczosSampleCached(src *cog.Reader, level int, fx, fy float64, imgW, imgH,
 			p := pixelFromImage(tiles[tr][tc], localX[kx], ly)
 
 			aSum += float64(p[3]) * wt
-			wTotal += wt
 			if p[3] > 0 {
 				rSum += float64(p[0]) * wt
 				gSum += float64(p[1]) * wt
@@ -519,20 +911,21 @@ func lanczosSampleCached(src *cog.Reader, level int, fx, fy float64, imgW, imgH,
 		}
 	}
 
-	if wRGB == 0 {
+	if wRGB <= 0 {
 		return 0, 0, 0, 0, nil
 	}
 
 	if luts != nil {
-		return luts.encode(rSum / wRGB), luts.encode(gSum / wRGB), luts.encode(bSum / wRGB), clampByte(aSum / wTotal), nil
+		return luts.encode(rSum / wRGB), luts.encode(gSum / wRGB), luts.encode(bSum / wRGB), clampByte(aSum / wRGB), nil
 	}
-	return clampByte(rSum / wRGB), clampByte(gSum / wRGB), clampByte(bSum / wRGB), clampByte(aSum / wTotal), nil
+	return clampByte(rSum / wRGB), clampByte(gSum / wRGB), clampByte(bSum / wRGB), clampByte(aSum / wRGB), nil
 }
 
 // lanczosAccumYCbCr is the hot inner loop for Lanczos-3 on YCbCr tiles.
 // Type assertion and stride lookups happen once; per-pixel work is pure
 // integer arithmetic with no interface dispatch.
-func lanczosAccumYCbCr(img *image.YCbCr, wxArr, wyArr [6]float64, lx, ly [6]int, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
+func lanczosAccumYCbCr(img *image.YCbCr, ks *kernelScratch, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
+	wxArr, wyArr, lx, ly := &ks.wx, &ks.wy, &ks.lx, &ks.ly
 	yStride := img.YStride
 	cStride := img.CStride
 	ratio := img.SubsampleRatio
@@ -617,7 +1010,8 @@ func lanczosAccumYCbCr(img *image.YCbCr, wxArr, wyArr [6]float64, lx, ly [6]int,
 }
 
 // lanczosAccumNYCbCrA is the hot inner loop for Lanczos-3 on NYCbCrA tiles.
-func lanczosAccumNYCbCrA(img *image.NYCbCrA, wxArr, wyArr [6]float64, lx, ly [6]int, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
+func lanczosAccumNYCbCrA(img *image.NYCbCrA, ks *kernelScratch, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
+	wxArr, wyArr, lx, ly := &ks.wx, &ks.wy, &ks.lx, &ks.ly
 	yStride := img.YStride
 	cStride := img.CStride
 	aStride := img.AStride
@@ -627,7 +1021,7 @@ func lanczosAccumNYCbCrA(img *image.NYCbCrA, wxArr, wyArr [6]float64, lx, ly [6]
 	crData := img.Cr
 	aData := img.A
 
-	var rSum, gSum, bSum, aSum, wTotal, wRGB float64
+	var rSum, gSum, bSum, aSum, wRGB float64
 
 	for ky := 0; ky < 6; ky++ {
 		wyVal := wyArr[ky]
@@ -668,7 +1062,6 @@ func lanczosAccumNYCbCrA(img *image.NYCbCrA, wxArr, wyArr [6]float64, lx, ly [6]
 
 			alpha := aData[ai]
 			aSum += float64(alpha) * wt
-			wTotal += wt
 
 			if alpha > 0 {
 				yy1 := int32(yData[yi]) * 0x10101
@@ -701,22 +1094,23 @@ func lanczosAccumNYCbCrA(img *image.NYCbCrA, wxArr, wyArr [6]float64, lx, ly [6]
 		}
 	}
 
-	if wRGB == 0 {
+	if wRGB <= 0 {
 		return 0, 0, 0, 0, nil
 	}
 
 	if luts != nil {
-		return luts.encode(rSum / wRGB), luts.encode(gSum / wRGB), luts.encode(bSum / wRGB), clampByte(aSum / wTotal), nil
+		return luts.encode(rSum / wRGB), luts.encode(gSum / wRGB), luts.encode(bSum / wRGB), clampByte(aSum / wRGB), nil
 	}
-	return clampByte(rSum / wRGB), clampByte(gSum / wRGB), clampByte(bSum / wRGB), clampByte(aSum / wTotal), nil
+	return clampByte(rSum / wRGB), clampByte(gSum / wRGB), clampByte(bSum / wRGB), clampByte(aSum / wRGB), nil
 }
 
 // lanczosAccumRGBA is the hot inner loop for Lanczos-3 on RGBA tiles.
-func lanczosAccumRGBA(img *image.RGBA, wxArr, wyArr [6]float64, lx, ly [6]int, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
+func lanczosAccumRGBA(img *image.RGBA, ks *kernelScratch, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
+	wxArr, wyArr, lx, ly := &ks.wx, &ks.wy, &ks.lx, &ks.ly
 	pix := img.Pix
 	stride := img.Stride
 
-	var rSum, gSum, bSum, aSum, wTotal, wRGB float64
+	var rSum, gSum, bSum, aSum, wRGB float64
 
 	for ky := 0; ky < 6; ky++ {
 		wyVal := wyArr[ky]
@@ -734,7 +1128,6 @@ func lanczosAccumRGBA(img *image.RGBA, wxArr, wyArr [6]float64, lx, ly [6]int, l
 			off := rowOff + lx[kx]*4
 			alpha := pix[off+3]
 			aSum += float64(alpha) * wt
-			wTotal += wt
 			if alpha > 0 {
 				rSum += float64(pix[off+0]) * wt
 				gSum += float64(pix[off+1]) * wt
@@ -744,19 +1137,20 @@ func lanczosAccumRGBA(img *image.RGBA, wxArr, wyArr [6]float64, lx, ly [6]int, l
 		}
 	}
 
-	if wRGB == 0 {
+	if wRGB <= 0 {
 		return 0, 0, 0, 0, nil
 	}
 
 	if luts != nil {
-		return luts.encode(rSum / wRGB), luts.encode(gSum / wRGB), luts.encode(bSum / wRGB), clampByte(aSum / wTotal), nil
+		return luts.encode(rSum / wRGB), luts.encode(gSum / wRGB), luts.encode(bSum / wRGB), clampByte(aSum / wRGB), nil
 	}
-	return clampByte(rSum / wRGB), clampByte(gSum / wRGB), clampByte(bSum / wRGB), clampByte(aSum / wTotal), nil
+	return clampByte(rSum / wRGB), clampByte(gSum / wRGB), clampByte(bSum / wRGB), clampByte(aSum / wRGB), nil
 }
 
 // lanczosAccumGeneric is a fallback for rare tile types using the image.Image interface.
-func lanczosAccumGeneric(tile image.Image, wxArr, wyArr [6]float64, lx, ly [6]int, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
-	var rSum, gSum, bSum, aSum, wTotal, wRGB float64
+func lanczosAccumGeneric(tile image.Image, ks *kernelScratch, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
+	wxArr, wyArr, lx, ly := &ks.wx, &ks.wy, &ks.lx, &ks.ly
+	var rSum, gSum, bSum, aSum, wRGB float64
 
 	for ky := 0; ky < 6; ky++ {
 		wyVal := wyArr[ky]
@@ -771,7 +1165,6 @@ func lanczosAccumGeneric(tile image.Image, wxArr, wyArr [6]float64, lx, ly [6]in
 			p := pixelFromImage(tile, lx[kx], ly[ky])
 
 			aSum += float64(p[3]) * wt
-			wTotal += wt
 			if p[3] > 0 {
 				rSum += float64(p[0]) * wt
 				gSum += float64(p[1]) * wt
@@ -781,68 +1174,79 @@ func lanczosAccumGeneric(tile image.Image, wxArr, wyArr [6]float64, lx, ly [6]in
 		}
 	}
 
-	if wRGB == 0 {
+	if wRGB <= 0 {
 		return 0, 0, 0, 0, nil
 	}
 
 	if luts != nil {
-		return luts.encode(rSum / wRGB), luts.encode(gSum / wRGB), luts.encode(bSum / wRGB), clampByte(aSum / wTotal), nil
+		return luts.encode(rSum / wRGB), luts.encode(gSum / wRGB), luts.encode(bSum / wRGB), clampByte(aSum / wRGB), nil
 	}
-	return clampByte(rSum / wRGB), clampByte(gSum / wRGB), clampByte(bSum / wRGB), clampByte(aSum / wTotal), nil
+	return clampByte(rSum / wRGB), clampByte(gSum / wRGB), clampByte(bSum / wRGB), clampByte(aSum / wRGB), nil
 }
 
 // bicubicSampleCached performs Catmull-Rom bicubic interpolation using the
 // tile cache. Uses a 4×4 pixel neighborhood — sharper than bilinear with less
-// ringing than Lanczos-3. Pixels with alpha == 0 are excluded from RGB
-// interpolation. Optimized with batched tile fetches: the 4×4 neighborhood
+// ringing than Lanczos-3. Edge handling matches lanczosSampleCached: taps
+// outside the image or footprint are dropped and the rest renormalized.
+// Optimized with batched tile fetches: the 4×4 neighborhood
 // spans at most 2×2 source tiles.
-func bicubicSampleCached(src *cog.Reader, level int, fx, fy float64, imgW, imgH, tw, th int, cache *cog.TileCache, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
+func bicubicSampleCached(src RasterSource, level int, fx, fy float64, imgW, imgH, tw, th int, cache *cog.TileCache, luts *gammaLUTs, ks *kernelScratch) (uint8, uint8, uint8, uint8, error) {
 	const n = 4
 
 	ix0 := int(math.Floor(fx)) - 1
 	iy0 := int(math.Floor(fy)) - 1
 
-	var wxArr, wyArr [n]float64
-	var pxArr, pyArr [n]int
+	wxArr, wyArr := &ks.wx, &ks.wy
+	pxArr, pyArr := &ks.px, &ks.py
 	for k := 0; k < n; k++ {
 		pxArr[k] = clamp(ix0+k, 0, imgW-1)
 		pyArr[k] = clamp(iy0+k, 0, imgH-1)
 		wxArr[k] = bicubicLUT(fx - float64(ix0+k))
 		wyArr[k] = bicubicLUT(fy - float64(iy0+k))
 	}
+	dropOutsideTaps(wxArr[:n], ix0, imgW)
+	dropOutsideTaps(wyArr[:n], iy0, imgH)
 
 	colMin := pxArr[0] / tw
 	colMax := pxArr[n-1] / tw
 	rowMin := pyArr[0] / th
 	rowMax := pyArr[n-1] / th
 
-	var localX, localY [n]int
+	localX, localY := &ks.lx, &ks.ly
 	for k := 0; k < n; k++ {
 		localX[k] = pxArr[k] % tw
 		localY[k] = pyArr[k] % th
 	}
 
+	// The kernel centre's nearest pixel decides whether the output pixel is
+	// inside the data footprint at all.
+	cx := int(math.Floor(fx+0.5)) - ix0
+	cy := int(math.Floor(fy+0.5)) - iy0
+
 	// Single-tile fast path.
 	if colMin == colMax && rowMin == rowMax {
 		tile, err := fetchTileCached(src, level, colMin, rowMin, cache)
 		if err != nil {
 			return 0, 0, 0, 0, err
 		}
+		if alphaAt(tile, localX[cx], localY[cy]) == 0 {
+			return 0, 0, 0, 0, nil
+		}
 		if ycbcr, ok := tile.(*image.YCbCr); ok {
-			return bicubicAccumYCbCr(ycbcr, wxArr, wyArr, localX, localY, luts)
+			return bicubicAccumYCbCr(ycbcr, ks, luts)
 		}
 		if nycbcra, ok := tile.(*image.NYCbCrA); ok {
-			return bicubicAccumNYCbCrA(nycbcra, wxArr, wyArr, localX, localY, luts)
+			return bicubicAccumNYCbCrA(nycbcra, ks, luts)
 		}
 		if rgba, ok := tile.(*image.RGBA); ok {
-			return bicubicAccumRGBA(rgba, wxArr, wyArr, localX, localY, luts)
+			return bicubicAccumRGBA(rgba, ks, luts)
 		}
-		return bicubicAccumGeneric(tile, wxArr, wyArr, localX, localY, luts)
+		return bicubicAccumGeneric(tile, ks, luts)
 	}
 
 	// Multi-tile path: fetch the unique tiles (at most 2×2 = 4).
-	var tiles [2][2]image.Image
-	var tileOK [2][2]bool
+	tiles, tileOK := &ks.tiles, &ks.tileOK
+	*tileOK = [2][2]bool{}
 	for r := rowMin; r <= rowMax; r++ {
 		for c := colMin; c <= colMax; c++ {
 			tile, err := fetchTileCached(src, level, c, r, cache)
@@ -853,13 +1257,17 @@ func bicubicSampleCached(src *cog.Reader, level int, fx, fy float64, imgW, imgH,
 		}
 	}
 
-	var tileColIdx, tileRowIdx [n]int
+	tileColIdx, tileRowIdx := &ks.tc, &ks.tr
 	for k := 0; k < n; k++ {
 		tileColIdx[k] = pxArr[k]/tw - colMin
 		tileRowIdx[k] = pyArr[k]/th - rowMin
 	}
 
-	var rSum, gSum, bSum, aSum, wTotal, wRGB float64
+	if tr, tc := tileRowIdx[cy], tileColIdx[cx]; !tileOK[tr][tc] || alphaAt(tiles[tr][tc], localX[cx], localY[cy]) == 0 {
+		return 0, 0, 0, 0, nil
+	}
+
+	var rSum, gSum, bSum, aSum, wRGB float64
 
 	for ky := 0; ky < n; ky++ {
 		wyVal := wyArr[ky]
@@ -881,7 +1289,6 @@ func bicubicSampleCached(src *cog.Reader, level int, fx, fy float64, imgW, imgH,
 			p := pixelFromImage(tiles[tr][tc], localX[kx], ly)
 
 			aSum += float64(p[3]) * wt
-			wTotal += wt
 			if p[3] > 0 {
 				rSum += float64(p[0]) * wt
 				gSum += float64(p[1]) * wt
@@ -891,18 +1298,19 @@ func bicubicSampleCached(src *cog.Reader, level int, fx, fy float64, imgW, imgH,
 		}
 	}
 
-	if wRGB == 0 {
+	if wRGB <= 0 {
 		return 0, 0, 0, 0, nil
 	}
 
 	if luts != nil {
-		return luts.encode(rSum / wRGB), luts.encode(gSum / wRGB), luts.encode(bSum / wRGB), clampByte(aSum / wTotal), nil
+		return luts.encode(rSum / wRGB), luts.encode(gSum / wRGB), luts.encode(bSum / wRGB), clampByte(aSum / wRGB), nil
 	}
-	return clampByte(rSum / wRGB), clampByte(gSum / wRGB), clampByte(bSum / wRGB), clampByte(aSum / wTotal), nil
+	return clampByte(rSum / wRGB), clampByte(gSum / wRGB), clampByte(bSum / wRGB), clampByte(aSum / wRGB), nil
 }
 
 // bicubicAccumYCbCr is the inner loop for bicubic on YCbCr tiles.
-func bicubicAccumYCbCr(img *image.YCbCr, wxArr, wyArr [4]float64, lx, ly [4]int, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
+func bicubicAccumYCbCr(img *image.YCbCr, ks *kernelScratch, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
+	wxArr, wyArr, lx, ly := &ks.wx, &ks.wy, &ks.lx, &ks.ly
 	yStride := img.YStride
 	cStride := img.CStride
 	ratio := img.SubsampleRatio
@@ -987,7 +1395,8 @@ func bicubicAccumYCbCr(img *image.YCbCr, wxArr, wyArr [4]float64, lx, ly [4]int,
 }
 
 // bicubicAccumNYCbCrA is the inner loop for bicubic on NYCbCrA tiles.
-func bicubicAccumNYCbCrA(img *image.NYCbCrA, wxArr, wyArr [4]float64, lx, ly [4]int, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
+func bicubicAccumNYCbCrA(img *image.NYCbCrA, ks *kernelScratch, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
+	wxArr, wyArr, lx, ly := &ks.wx, &ks.wy, &ks.lx, &ks.ly
 	yStride := img.YStride
 	cStride := img.CStride
 	aStride := img.AStride
@@ -997,7 +1406,7 @@ func bicubicAccumNYCbCrA(img *image.NYCbCrA, wxArr, wyArr [4]float64, lx, ly [4]
 	crData := img.Cr
 	aData := img.A
 
-	var rSum, gSum, bSum, aSum, wTotal, wRGB float64
+	var rSum, gSum, bSum, aSum, wRGB float64
 
 	for ky := 0; ky < 4; ky++ {
 		wyVal := wyArr[ky]
@@ -1038,7 +1447,6 @@ func bicubicAccumNYCbCrA(img *image.NYCbCrA, wxArr, wyArr [4]float64, lx, ly [4]
 
 			alpha := aData[ai]
 			aSum += float64(alpha) * wt
-			wTotal += wt
 
 			if alpha > 0 {
 				yy1 := int32(yData[yi]) * 0x10101
@@ -1071,22 +1479,23 @@ func bicubicAccumNYCbCrA(img *image.NYCbCrA, wxArr, wyArr [4]float64, lx, ly [4]
 		}
 	}
 
-	if wRGB == 0 {
+	if wRGB <= 0 {
 		return 0, 0, 0, 0, nil
 	}
 
 	if luts != nil {
-		return luts.encode(rSum / wRGB), luts.encode(gSum / wRGB), luts.encode(bSum / wRGB), clampByte(aSum / wTotal), nil
+		return luts.encode(rSum / wRGB), luts.encode(gSum / wRGB), luts.encode(bSum / wRGB), clampByte(aSum / wRGB), nil
 	}
-	return clampByte(rSum / wRGB), clampByte(gSum / wRGB), clampByte(bSum / wRGB), clampByte(aSum / wTotal), nil
+	return clampByte(rSum / wRGB), clampByte(gSum / wRGB), clampByte(bSum / wRGB), clampByte(aSum / wRGB), nil
 }
 
 // bicubicAccumRGBA is the inner loop for bicubic on RGBA tiles.
-func bicubicAccumRGBA(img *image.RGBA, wxArr, wyArr [4]float64, lx, ly [4]int, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
+func bicubicAccumRGBA(img *image.RGBA, ks *kernelScratch, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
+	wxArr, wyArr, lx, ly := &ks.wx, &ks.wy, &ks.lx, &ks.ly
 	pix := img.Pix
 	stride := img.Stride
 
-	var rSum, gSum, bSum, aSum, wTotal, wRGB float64
+	var rSum, gSum, bSum, aSum, wRGB float64
 
 	for ky := 0; ky < 4; ky++ {
 		wyVal := wyArr[ky]
@@ -1104,7 +1513,6 @@ func bicubicAccumRGBA(img *image.RGBA, wxArr, wyArr [4]float64, lx, ly [4]int, l
 			off := rowOff + lx[kx]*4
 			alpha := pix[off+3]
 			aSum += float64(alpha) * wt
-			wTotal += wt
 			if alpha > 0 {
 				rSum += float64(pix[off+0]) * wt
 				gSum += float64(pix[off+1]) * wt
@@ -1114,19 +1522,20 @@ func bicubicAccumRGBA(img *image.RGBA, wxArr, wyArr [4]float64, lx, ly [4]int, l
 		}
 	}
 
-	if wRGB == 0 {
+	if wRGB <= 0 {
 		return 0, 0, 0, 0, nil
 	}
 
 	if luts != nil {
-		return luts.encode(rSum / wRGB), luts.encode(gSum / wRGB), luts.encode(bSum / wRGB), clampByte(aSum / wTotal), nil
+		return luts.encode(rSum / wRGB), luts.encode(gSum / wRGB), luts.encode(bSum / wRGB), clampByte(aSum / wRGB), nil
 	}
-	return clampByte(rSum / wRGB), clampByte(gSum / wRGB), clampByte(bSum / wRGB), clampByte(aSum / wTotal), nil
+	return clampByte(rSum / wRGB), clampByte(gSum / wRGB), clampByte(bSum / wRGB), clampByte(aSum / wRGB), nil
 }
 
 // bicubicAccumGeneric is a fallback for rare tile types using the image.Image interface.
-func bicubicAccumGeneric(tile image.Image, wxArr, wyArr [4]float64, lx, ly [4]int, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
-	var rSum, gSum, bSum, aSum, wTotal, wRGB float64
+func bicubicAccumGeneric(tile image.Image, ks *kernelScratch, luts *gammaLUTs) (uint8, uint8, uint8, uint8, error) {
+	wxArr, wyArr, lx, ly := &ks.wx, &ks.wy, &ks.lx, &ks.ly
+	var rSum, gSum, bSum, aSum, wRGB float64
 
 	for ky := 0; ky < 4; ky++ {
 		wyVal := wyArr[ky]
@@ -1141,7 +1550,6 @@ func bicubicAccumGeneric(tile image.Image, wxArr, wyArr [4]float64, lx, ly [4]in
 			p := pixelFromImage(tile, lx[kx], ly[ky])
 
 			aSum += float64(p[3]) * wt
-			wTotal += wt
 			if p[3] > 0 {
 				rSum += float64(p[0]) * wt
 				gSum += float64(p[1]) * wt
@@ -1151,20 +1559,20 @@ func bicubicAccumGeneric(tile image.Image, wxArr, wyArr [4]float64, lx, ly [4]in
 		}
 	}
 
-	if wRGB == 0 {
+	if wRGB <= 0 {
 		return 0, 0, 0, 0, nil
 	}
 
 	if luts != nil {
-		return luts.encode(rSum / wRGB), luts.encode(gSum / wRGB), luts.encode(bSum / wRGB), clampByte(aSum / wTotal), nil
+		return luts.encode(rSum / wRGB), luts.encode(gSum / wRGB), luts.encode(bSum / wRGB), clampByte(aSum / wRGB), nil
 	}
-	return clampByte(rSum / wRGB), clampByte(gSum / wRGB), clampByte(bSum / wRGB), clampByte(aSum / wTotal), nil
+	return clampByte(rSum / wRGB), clampByte(gSum / wRGB), clampByte(bSum / wRGB), clampByte(aSum / wRGB), nil
 }
 
 // fetchTileCached retrieves a decoded tile image using the cache.
 // Callers extract pixels directly from the returned image to avoid per-pixel
 // cache lookups (the bilinear case needs 4 pixels from potentially the same tile).
-func fetchTileCached(src *cog.Reader, level, col, row int, cache *cog.TileCache) (image.Image, error) {
+func fetchTileCached(src RasterSource, level, col, row int, cache *cog.TileCache) (image.Image, error) {
 	if cache != nil {
 		if tile := cache.Get(src.ID(), level, col, row); tile != nil {
 			return tile, nil
@@ -1250,6 +1658,15 @@ func pixelFromImage(tile image.Image, x, y int) [4]uint8 {
 	case *image.RGBA:
 		i := img.PixOffset(x, y)
 		return [4]uint8{img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]}
+	case *image.Gray:
+		// Grayscale JPEG tiles.
+		v := img.Pix[img.PixOffset(x, y)]
+		return [4]uint8{v, v, v, 255}
+	case *image.CMYK:
+		// CMYK JPEG tiles. The color.Color of At would allocate per pixel.
+		i := img.PixOffset(x, y)
+		r, g, b := color.CMYKToRGB(img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3])
+		return [4]uint8{r, g, b, 255}
 	default:
 		rr, gg, bb, aa := tile.At(x, y).RGBA()
 		return [4]uint8{uint8(rr >> 8), uint8(gg >> 8), uint8(bb >> 8), uint8(aa >> 8)}
@@ -1258,7 +1675,7 @@ func pixelFromImage(tile image.Image, x, y int) [4]uint8 {
 
 // readPixelCached reads a single pixel using the tile cache.
 // tw and th are the source tile dimensions (pre-computed by prepareTileSources).
-func readPixelCached(src *cog.Reader, level, px, py, tw, th int, cache *cog.TileCache) ([4]uint8, error) {
+func readPixelCached(src RasterSource, level, px, py, tw, th int, cache *cog.TileCache) ([4]uint8, error) {
 	col := px / tw
 	row := py / th
 	localX := px % tw
@@ -1271,6 +1688,33 @@ func readPixelCached(src *cog.Reader, level, px, py, tw, th int, cache *cog.Tile
 	return pixelFromImage(tile, localX, localY), nil
 }
 
+// dropOutsideTaps zeroes the weights of kernel taps i0+k that fall outside
+// [0, n). The accumulators renormalize by the remaining weight, so pixels
+// near the image edge are interpolated from real data only instead of from
+// edge pixels repeated by clamping, which smeared them outward into halos
+// along mosaic seams.
+func dropOutsideTaps(w []float64, i0, n int) {
+	for k := range w {
+		if i0+k < 0 || i0+k >= n {
+			w[k] = 0
+		}
+	}
+}
+
+// alphaAt returns the alpha of pixel (x, y) in a decoded tile without
+// converting its colour.
+func alphaAt(tile image.Image, x, y int) uint8 {
+	switch img := tile.(type) {
+	case *image.YCbCr:
+		return 255
+	case *image.RGBA:
+		return img.Pix[img.PixOffset(x, y)+3]
+	case *image.NYCbCrA:
+		return img.A[img.AOffset(x, y)]
+	}
+	return pixelFromImage(tile, x, y)[3]
+}
+
 func clamp(v, lo, hi int) int {
 	if v < lo {
 		return lo
@@ -1444,36 +1888,42 @@ func (g *gammaLUTs) encode(v float64) uint8 {
 }
 
 // renderTileTerrarium renders a single web map tile from float GeoTIFF data,
-// converting elevation values to Terrarium RGB encoding.
-func renderTileTerrarium(z, tx, ty, tileSize int, srcInfos []sourceInfo, proj coord.Projection, cache *cog.FloatTileCache, mode Resampling) *image.RGBA {
+// converting elevation values to RGB with dem (Terrarium or Terrain-RGB). With fillVoids > 0,
+// NaN regions of up to that many pixels are filled first (see
+// renderTileTerrariumFilled), and grid is not used. Otherwise a non-nil grid
+// supplies the projected pixel coordinates (see crsGrid).
+func renderTileTerrarium(z, tx, ty, tileSize int, srcInfos []sourceInfo, proj coord.Projection, grid *crsGrid, scratch *sampleScratch, cache *cog.FloatTileCache, mode Resampling, fillVoids int, dem encode.DEMEncoding) *image.RGBA {
 	_, midLat, _, _ := coord.TileBounds(z, tx, ty)
 	outputResMeters := coord.ResolutionAtLat(midLat, z, tileSize)
 	outputResCRS := coord.MetersToPixelSizeCRS(outputResMeters, proj.EPSG(), midLat)
 
+	if scratch == nil {
+		scratch = new(sampleScratch)
+	}
+	defer scratch.release()
+
 	// Pre-filter sources and pre-compute overview levels for this tile.
+	if fillVoids > 0 {
+		minX, minY, maxX, maxY := paddedTileCRSBounds(z, tx, ty, tileSize, voidMargin(fillVoids), proj)
+		tileSrcs := prepareTileSources(scratch.srcs, srcInfos, outputResCRS, minX, minY, maxX, maxY)
+		scratch.srcs = tileSrcs
+		if len(tileSrcs) == 0 {
+			return nil
+		}
+		return renderTileTerrariumFilled(z, tx, ty, tileSize, tileSrcs, proj, scratch, cache, mode, fillVoids, dem)
+	}
+
 	tileMinX, tileMinY, tileMaxX, tileMaxY := tileCRSBounds(z, tx, ty, proj)
-	tileSrcs := prepareTileSources(srcInfos, outputResCRS, tileMinX, tileMinY, tileMaxX, tileMaxY)
+	tileSrcs := prepareTileSources(scratch.srcs, srcInfos, outputResCRS, tileMinX, tileMinY, tileMaxX, tileMaxY)
+	scratch.srcs = tileSrcs
 	if len(tileSrcs) == 0 {
 		return nil
 	}
 
 	img := GetRGBA(tileSize, tileSize)
 	hasData := false
-
-	// Parse nodata values from the active sources.
-	nodataValues := make([]float64, len(tileSrcs))
-	for i := range tileSrcs {
-		nd := tileSrcs[i].reader.NoData()
-		if nd != "" {
-			v, err := strconv.ParseFloat(nd, 64)
-			if err == nil {
-				nodataValues[i] = v
-			} else {
-				nodataValues[i] = math.NaN()
-			}
-		} else {
-			nodataValues[i] = math.NaN()
-		}
+	if grid != nil {
+		grid.project(z, tx, ty, tileSize, proj)
 	}
 
 	// Precompute lon per column and lat per row to avoid per-pixel trig.
@@ -1489,10 +1939,16 @@ func renderTileTerrarium(z, tx, ty, tileSize int, srcInfos []sourceInfo, proj co
 	for py := 0; py < tileSize; py++ {
 		lat := lats[py]
 		for px := 0; px < tileSize; px++ {
-			srcX, srcY := proj.FromWGS84(lons[px], lat)
-			elevation, found := sampleFromTileSourcesFloat(tileSrcs, nodataValues, srcX, srcY, cache, mode)
+			var srcX, srcY float64
+			if grid != nil {
+				i := 2 * (py*tileSize + px)
+				srcX, srcY = grid.xy[i], grid.xy[i+1]
+			} else {
+				srcX, srcY = proj.FromWGS84(lons[px], lat)
+			}
+			elevation, found := sampleFromTileSourcesFloat(tileSrcs, srcX, srcY, cache, mode, scratch)
 			if found && !math.IsNaN(elevation) {
-				img.SetRGBA(px, py, encode.ElevationToTerrarium(elevation))
+				img.SetRGBA(px, py, dem.FromElevation(elevation))
 				hasData = true
 			}
 			// nodata pixels remain transparent (zero RGBA)
@@ -1509,64 +1965,67 @@ func renderTileTerrarium(z, tx, ty, tileSize int, srcInfos []sourceInfo, proj co
 }
 
 // sampleFromTileSourcesFloat tries each pre-filtered tile source to sample a
-// float elevation at the given CRS coordinates.
-func sampleFromTileSourcesFloat(sources []tileSource, nodataValues []float64, srcX, srcY float64, cache *cog.FloatTileCache, mode Resampling) (float64, bool) {
+// float elevation at the given CRS coordinates. Nodata samples arrive as NaN
+// (the reader masks each source's NoDataSpec on decode), so the kernels skip
+// them and a NaN result falls through to the next source.
+func sampleFromTileSourcesFloat(sources []tileSource, srcX, srcY float64, cache *cog.FloatTileCache, mode Resampling, s *sampleScratch) (float64, bool) {
+	var seam seamCandidates
 	for i := range sources {
 		src := &sources[i]
-
-		if srcX < src.minCRSX || srcX > src.maxCRSX || srcY < src.minCRSY || srcY > src.maxCRSY {
-			continue
-		}
-
-		pixX := (srcX - src.geo.OriginX) / src.levelPixelSize
-		pixY := (src.geo.OriginY - srcY) / src.levelPixelSize
-
-		if pixX < 0 || pixX >= float64(src.imgW) || pixY < 0 || pixY >= float64(src.imgH) {
-			continue
-		}
-
-		var val float64
-		var err error
-
-		switch mode {
-		case ResamplingNearest, ResamplingMode:
-			val, err = nearestSampleFloat(src.reader, src.level, pixX, pixY, src.imgW, src.imgH, cache)
-		case ResamplingLanczos:
-			val, err = lanczosSampleFloat(src.reader, src.level, pixX, pixY, src.imgW, src.imgH, src.tileW, src.tileH, cache)
-		case ResamplingBicubic:
-			val, err = bicubicSampleFloat(src.reader, src.level, pixX, pixY, src.imgW, src.imgH, src.tileW, src.tileH, cache)
-		default:
-			val, err = bilinearSampleFloat(src.reader, src.level, pixX, pixY, src.imgW, src.imgH, cache)
-		}
-
-		if err != nil {
+		pixX, pixY, inside, near := src.sourcePixel(srcX, srcY)
+		if !inside {
+			if near {
+				seam.add(i, pixX, pixY)
+			}
 			continue
 		}
-
-		// Check nodata.
-		if math.IsNaN(val) {
-			continue
+		if val, ok := sampleTileSourceFloat(src, pixX, pixY, cache, mode, s); ok {
+			return val, true
 		}
-		nd := nodataValues[i]
-		if !math.IsNaN(nd) && val == nd {
-			continue
+	}
+	if seam.n >= 2 {
+		for k := 0; k < seam.n && k < len(seam.idx); k++ {
+			if val, ok := sampleTileSourceFloat(&sources[seam.idx[k]], seam.pixX[k], seam.pixY[k], cache, mode, s); ok {
+				return val, true
+			}
 		}
-
-		return val, true
 	}
 	return math.NaN(), false
 }
 
+// sampleTileSourceFloat samples src at pixel coordinates with the given
+// kernel. ok is false on read errors and for NaN (nodata) samples.
+func sampleTileSourceFloat(src *tileSource, pixX, pixY float64, cache *cog.FloatTileCache, mode Resampling, s *sampleScratch) (float64, bool) {
+	var val float64
+	var err error
+	switch mode {
+	case ResamplingNearest, ResamplingMode:
+		val, err = nearestSampleFloat(src.reader, src.level, pixX, pixY, src.imgW, src.imgH, src.tileW, src.tileH, cache)
+	case ResamplingLanczos:
+		val, err = lanczosSampleFloat(src.reader, src.level, pixX, pixY, src.imgW, src.imgH, src.tileW, src.tileH, cache, &s.kernel)
+	case ResamplingBicubic:
+		val, err = bicubicSampleFloat(src.reader, src.level, pixX, pixY, src.imgW, src.imgH, src.tileW, src.tileH, cache, &s.kernel)
+	default:
+		val, err = bilinearSampleFloat(src.reader, src.level, pixX, pixY, src.imgW, src.imgH, src.tileW, src.tileH, cache, &s.kernel)
+	}
+	if err != nil || math.IsNaN(val) {
+		return math.NaN(), false
+	}
+	return val, true
+}
+
 // nearestSampleFloat reads the nearest float pixel.
 // imgW and imgH clamp the rounded coordinate (see nearestSampleCached).
-func nearestSampleFloat(src *cog.Reader, level int, fx, fy float64, imgW, imgH int, cache *cog.FloatTileCache) (float64, error) {
+func nearestSampleFloat(src RasterSource, level int, fx, fy float64, imgW, imgH, tw, th int, cache *cog.FloatTileCache) (float64, error) {
 	px := clamp(int(math.Floor(fx+0.5)), 0, imgW-1)
 	py := clamp(int(math.Floor(fy+0.5)), 0, imgH-1)
-	return readFloatPixelCached(src, level, px, py, cache)
+	return readFloatPixelCached(src, level, px, py, tw, th, cache)
 }
 
 // bilinearSampleFloat performs bilinear interpolation on float data.
-func bilinearSampleFloat(src *cog.Reader, level int, fx, fy float64, imgW, imgH int, cache *cog.FloatTileCache) (float64, error) {
+// Falls back to nearest-neighbor if any neighbor is NaN (counted in
+// ks.nanFallbacks).
+func bilinearSampleFloat(src RasterSource, level int, fx, fy float64, imgW, imgH, tw, th int, cache *cog.FloatTileCache, ks *kernelScratch) (float64, error) {
 	x0 := int(math.Floor(fx))
 	y0 := int(math.Floor(fy))
 	x1 := x0 + 1
@@ -1580,19 +2039,19 @@ func bilinearSampleFloat(src *cog.Reader, level int, fx, fy float64, imgW, imgH
 	dx := fx - math.Floor(fx)
 	dy := fy - math.Floor(fy)
 
-	v00, err := readFloatPixelCached(src, level, x0, y0, cache)
+	v00, err := readFloatPixelCached(src, level, x0, y0, tw, th, cache)
 	if err != nil {
 		return math.NaN(), err
 	}
-	v10, err := readFloatPixelCached(src, level, x1, y0, cache)
+	v10, err := readFloatPixelCached(src, level, x1, y0, tw, th, cache)
 	if err != nil {
 		return math.NaN(), err
 	}
-	v01, err := readFloatPixelCached(src, level, x0, y1, cache)
+	v01, err := readFloatPixelCached(src, level, x0, y1, tw, th, cache)
 	if err != nil {
 		return math.NaN(), err
 	}
-	v11, err := readFloatPixelCached(src, level, x1, y1, cache)
+	v11, err := readFloatPixelCached(src, level, x1, y1, tw, th, cache)
 	if err != nil {
 		return math.NaN(), err
 	}
@@ -1600,11 +2059,12 @@ func bilinearSampleFloat(src *cog.Reader, level int, fx, fy float64, imgW, imgH
 	// If any neighbor is NaN, fall back to nearest.
 	if math.IsNaN(v00) || math.IsNaN(v10) || math.IsNaN(v01) || math.IsNaN(v11) {
 		// Use the center pixel (nearest).
+		ks.nanFallbacks++
 		cx := int(math.Floor(fx + 0.5))
 		cy := int(math.Floor(fy + 0.5))
 		cx = clamp(cx, 0, imgW-1)
 		cy = clamp(cy, 0, imgH-1)
-		return readFloatPixelCached(src, level, cx, cy, cache)
+		return readFloatPixelCached(src, level, cx, cy, tw, th, cache)
 	}
 
 	lerp := func(a, b, t float64) float64 {
@@ -1622,21 +2082,23 @@ func bilinearSampleFloat(src *cog.Reader, level int, fx, fy float64, imgW, imgH
 //
 // Optimized with batched tile fetches (same approach as lanczosSampleCached)
 // and LUT-based kernel evaluation.
-func lanczosSampleFloat(src *cog.Reader, level int, fx, fy float64, imgW, imgH, tw, th int, cache *cog.FloatTileCache) (float64, error) {
+func lanczosSampleFloat(src RasterSource, level int, fx, fy float64, imgW, imgH, tw, th int, cache *cog.FloatTileCache, ks *kernelScratch) (float64, error) {
 	const a = 3
 	const n = 2 * a
 
 	ix0 := int(math.Floor(fx)) - a + 1
 	iy0 := int(math.Floor(fy)) - a + 1
 
-	var pxArr, pyArr [n]int
-	var wxArr, wyArr [n]float64
+	pxArr, pyArr := &ks.px, &ks.py
+	wxArr, wyArr := &ks.wx, &ks.wy
 	for k := 0; k < n; k++ {
 		pxArr[k] = clamp(ix0+k, 0, imgW-1)
 		pyArr[k] = clamp(iy0+k, 0, imgH-1)
 		wxArr[k] = lanczos3LUT(fx - float64(ix0+k))
 		wyArr[k] = lanczos3LUT(fy - float64(iy0+k))
 	}
+	dropOutsideTaps(wxArr[:n], ix0, imgW)
+	dropOutsideTaps(wyArr[:n], iy0, imgH)
 
 	// Determine the tile column/row range for the 6×6 neighborhood.
 	colMin := pxArr[0] / tw
@@ -1645,9 +2107,8 @@ func lanczosSampleFloat(src *cog.Reader, level int, fx, fy float64, imgW, imgH,
 	rowMax := pyArr[n-1] / th
 
 	// Fetch the unique float tiles (at most 2×2 = 4).
-	var ftData [2][2][]float32
-	var ftW [2][2]int
-	var ftOK [2][2]bool
+	ftData, ftW, ftOK := &ks.ftData, &ks.ftW, &ks.ftOK
+	*ftOK = [2][2]bool{}
 	srcID := src.ID()
 	for r := rowMin; r <= rowMax; r++ {
 		for c := colMin; c <= colMax; c++ {
@@ -1720,9 +2181,10 @@ func lanczosSampleFloat(src *cog.Reader, level int, fx, fy float64, imgW, imgH,
 
 	if wTotal == 0 {
 		if hasNaN {
+			ks.nanFallbacks++
 			cx := clamp(int(math.Floor(fx+0.5)), 0, imgW-1)
 			cy := clamp(int(math.Floor(fy+0.5)), 0, imgH-1)
-			return readFloatPixelCached(src, level, cx, cy, cache)
+			return readFloatPixelCached(src, level, cx, cy, tw, th, cache)
 		}
 		return math.NaN(), nil
 	}
@@ -1733,20 +2195,22 @@ func lanczosSampleFloat(src *cog.Reader, level int, fx, fy float64, imgW, imgH,
 // bicubicSampleFloat performs Catmull-Rom bicubic interpolation on float data.
 // NaN pixels are excluded from the weighted sum; if all neighbors are NaN,
 // falls back to nearest-neighbor.
-func bicubicSampleFloat(src *cog.Reader, level int, fx, fy float64, imgW, imgH, tw, th int, cache *cog.FloatTileCache) (float64, error) {
+func bicubicSampleFloat(src RasterSource, level int, fx, fy float64, imgW, imgH, tw, th int, cache *cog.FloatTileCache, ks *kernelScratch) (float64, error) {
 	const n = 4
 
 	ix0 := int(math.Floor(fx)) - 1
 	iy0 := int(math.Floor(fy)) - 1
 
-	var pxArr, pyArr [n]int
-	var wxArr, wyArr [n]float64
+	pxArr, pyArr := &ks.px, &ks.py
+	wxArr, wyArr := &ks.wx, &ks.wy
 	for k := 0; k < n; k++ {
 		pxArr[k] = clamp(ix0+k, 0, imgW-1)
 		pyArr[k] = clamp(iy0+k, 0, imgH-1)
 		wxArr[k] = bicubicLUT(fx - float64(ix0+k))
 		wyArr[k] = bicubicLUT(fy - float64(iy0+k))
 	}
+	dropOutsideTaps(wxArr[:n], ix0, imgW)
+	dropOutsideTaps(wyArr[:n], iy0, imgH)
 
 	colMin := pxArr[0] / tw
 	colMax := pxArr[n-1] / tw
@@ -1754,9 +2218,8 @@ func bicubicSampleFloat(src *cog.Reader, level int, fx, fy float64, imgW, imgH,
 	rowMax := pyArr[n-1] / th
 
 	// Fetch the unique float tiles (at most 2×2 = 4).
-	var ftData [2][2][]float32
-	var ftW [2][2]int
-	var ftOK [2][2]bool
+	ftData, ftW, ftOK := &ks.ftData, &ks.ftW, &ks.ftOK
+	*ftOK = [2][2]bool{}
 	srcID := src.ID()
 	for r := rowMin; r <= rowMax; r++ {
 		for c := colMin; c <= colMax; c++ {
@@ -1829,9 +2292,10 @@ func bicubicSampleFloat(src *cog.Reader, level int, fx, fy float64, imgW, imgH,
 
 	if wTotal == 0 {
 		if hasNaN {
+			ks.nanFallbacks++
 			cx := clamp(int(math.Floor(fx+0.5)), 0, imgW-1)
 			cy := clamp(int(math.Floor(fy+0.5)), 0, imgH-1)
-			return readFloatPixelCached(src, level, cx, cy, cache)
+			return readFloatPixelCached(src, level, cx, cy, tw, th, cache)
 		}
 		return math.NaN(), nil
 	}
@@ -1840,11 +2304,8 @@ func bicubicSampleFloat(src *cog.Reader, level int, fx, fy float64, imgW, imgH,
 }
 
 // readFloatPixelCached reads a single float pixel using the tile cache.
-func readFloatPixelCached(src *cog.Reader, level, px, py int, cache *cog.FloatTileCache) (float64, error) {
-	tileSize := src.IFDTileSize(level)
-	tw := tileSize[0]
-	th := tileSize[1]
-
+// tw and th are the source tile dimensions (pre-computed by prepareTileSources).
+func readFloatPixelCached(src RasterSource, level, px, py, tw, th int, cache *cog.FloatTileCache) (float64, error) {
 	col := px / tw
 	row := py / th
 	localX := px % tw
@@ -1,8 +1,13 @@
 package tile
 
 import (
+	"image"
+	"image/color"
 	"math"
 	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
 )
 
 // --- gamma LUTs ---
@@ -92,6 +97,47 @@ func TestClamp(t *testing.T) {
 	}
 }
 
+// --- edge handling ---
+
+func TestDropOutsideTaps(t *testing.T) {
+	w := []float64{1, 2, 3, 4, 5, 6}
+	dropOutsideTaps(w, -2, 3) // taps -2..3 on an axis of length 3
+	want := []float64{0, 0, 3, 4, 5, 0}
+	for k := range w {
+		if w[k] != want[k] {
+			t.Errorf("weights = %v, want %v", w, want)
+			break
+		}
+	}
+}
+
+func TestLanczosAccumRGBA_FootprintEdge(t *testing.T) {
+	// Left half opaque grey, right half nodata. A sample just inside the
+	// footprint must stay fully opaque rather than fading, since alpha is
+	// renormalized over the in-footprint taps only.
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 4; x++ {
+			img.SetRGBA(x, y, color.RGBA{100, 100, 100, 255})
+		}
+	}
+	fx, fy := 3.4, 3.5
+	ix0, iy0 := int(math.Floor(fx))-2, int(math.Floor(fy))-2
+	var ks kernelScratch
+	for k := 0; k < 6; k++ {
+		ks.wx[k] = lanczos3(fx - float64(ix0+k))
+		ks.wy[k] = lanczos3(fy - float64(iy0+k))
+		ks.lx[k], ks.ly[k] = ix0+k, iy0+k
+	}
+	r, _, _, a, _ := lanczosAccumRGBA(img, &ks, nil)
+	if a != 255 || r != 100 {
+		t.Errorf("edge sample = r%d a%d, want r100 a255", r, a)
+	}
+	if got := alphaAt(img, 4, 0); got != 0 {
+		t.Errorf("alphaAt nodata pixel = %d, want 0", got)
+	}
+}
+
 // --- clampByte ---
 
 func TestClampByte(t *testing.T) {
@@ -368,3 +414,251 @@ func TestParseResampling_AllModesDistinct(t *testing.T) {
 		seen[r] = m
 	}
 }
+
+// --- mode sampling ---
+
+func TestModeHistogram_Counts(t *testing.T) {
+	var h modeHistogram
+	keys := []uint32{1, 2, 1, 3, 1, 2}
+	want := []int32{1, 1, 2, 1, 3, 2}
+	for i, k := range keys {
+		if got := h.add(k); got != want[i] {
+			t.Errorf("add #%d (%d) = %d, want %d", i, k, got, want[i])
+		}
+	}
+	if h.n != 3 {
+		t.Errorf("distinct values = %d, want 3", h.n)
+	}
+}
+
+func TestModeHistogram_HoldsFullFootprint(t *testing.T) {
+	var h modeHistogram
+	for i := 0; i < len(h.keys); i++ {
+		if got := h.add(uint32(i)); got != 1 {
+			t.Fatalf("add(%d) = %d, want 1", i, got)
+		}
+	}
+	if got := h.add(0); got != 2 {
+		t.Errorf("add(0) after filling = %d, want 2", got)
+	}
+}
+
+func TestPackRGBA_Distinct(t *testing.T) {
+	a := packRGBA([4]uint8{1, 2, 3, 4})
+	b := packRGBA([4]uint8{4, 3, 2, 1})
+	if a == b {
+		t.Errorf("packRGBA collides: %#x", a)
+	}
+}
+
+// --- source ranking ---
+
+func TestRankTileSources(t *testing.T) {
+	// Output resolution 10: sources at 10 and 2 are both sharp enough and
+	// keep their input order; coarser ones follow, finest first.
+	srcs := []tileSource{
+		{pixelSize: 50, imgW: 0},
+		{pixelSize: 10, imgW: 1},
+		{pixelSize: 20, imgW: 2},
+		{pixelSize: 2, imgW: 3},
+	}
+	rankTileSources(srcs, 10)
+	want := []int{1, 3, 2, 0}
+	for i, s := range srcs {
+		if s.imgW != want[i] {
+			t.Fatalf("position %d holds input %d, want %d (order %v)", i, s.imgW, want[i], want)
+		}
+	}
+}
+
+func TestRankTileSources_InputOrder(t *testing.T) {
+	// pixelSize 0 (InputOrder) ranks every source equally.
+	srcs := []tileSource{{imgW: 0}, {imgW: 1}, {imgW: 2}}
+	rankTileSources(srcs, 10)
+	for i, s := range srcs {
+		if s.imgW != i {
+			t.Fatalf("source %d moved to %d", s.imgW, i)
+		}
+	}
+}
+
+// --- tile CRS bounds ---
+
+func TestTileCRSBounds_DensifiedAtLowZoom(t *testing.T) {
+	// A z2 tile over Europe in LV95: the projected edges curve, so the four
+	// corners alone miss part of the footprint.
+	proj := coord.ForEPSG(2056)
+	z, tx, ty := 2, 2, 1
+	minLon, minLat, maxLon, maxLat := coord.TileBounds(z, tx, ty)
+
+	// Reference: 1024 samples per edge.
+	refMinX, refMinY := math.Inf(1), math.Inf(1)
+	refMaxX, refMaxY := math.Inf(-1), math.Inf(-1)
+	for i := 0; i <= 1024; i++ {
+		f := float64(i) / 1024
+		lon, lat := minLon+f*(maxLon-minLon), minLat+f*(maxLat-minLat)
+		for _, p := range [][2]float64{{lon, minLat}, {lon, maxLat}, {minLon, lat}, {maxLon, lat}} {
+			x, y := proj.FromWGS84(p[0], p[1])
+			refMinX, refMaxX = math.Min(refMinX, x), math.Max(refMaxX, x)
+			refMinY, refMaxY = math.Min(refMinY, y), math.Max(refMaxY, y)
+		}
+	}
+	tol := 0.002 * math.Max(refMaxX-refMinX, refMaxY-refMinY)
+	within := func(minX, minY, maxX, maxY float64) bool {
+		return minX <= refMinX+tol && minY <= refMinY+tol && maxX >= refMaxX-tol && maxY >= refMaxY-tol
+	}
+
+	x1, y1 := proj.FromWGS84(minLon, minLat)
+	x2, y2 := proj.FromWGS84(minLon, maxLat)
+	x3, y3 := proj.FromWGS84(maxLon, minLat)
+	x4, y4 := proj.FromWGS84(maxLon, maxLat)
+	if within(math.Min(math.Min(x1, x2), math.Min(x3, x4)), math.Min(math.Min(y1, y2), math.Min(y3, y4)),
+		math.Max(math.Max(x1, x2), math.Max(x3, x4)), math.Max(math.Max(y1, y2), math.Max(y3, y4))) {
+		t.Fatal("corner hull already covers the footprint; pick a more curved tile")
+	}
+	if minX, minY, maxX, maxY := tileCRSBounds(z, tx, ty, proj); !within(minX, minY, maxX, maxY) {
+		t.Errorf("tileCRSBounds = [%.0f %.0f %.0f %.0f], want to cover [%.0f %.0f %.0f %.0f]",
+			minX, minY, maxX, maxY, refMinX, refMinY, refMaxX, refMaxY)
+	}
+}
+
+func TestTileCRSBounds_CornersAtHighZoom(t *testing.T) {
+	// From densifyZoom up, the bounds are exactly the corner hull.
+	proj := coord.ForEPSG(2056)
+	z, tx, ty := densifyZoom+4, 2136, 1448
+	minLon, minLat, maxLon, maxLat := coord.TileBounds(z, tx, ty)
+	x1, y1 := proj.FromWGS84(minLon, minLat)
+	x2, y2 := proj.FromWGS84(maxLon, maxLat)
+	x3, y3 := proj.FromWGS84(minLon, maxLat)
+	x4, y4 := proj.FromWGS84(maxLon, minLat)
+	minX, minY, maxX, maxY := tileCRSBounds(z, tx, ty, proj)
+	if minX != math.Min(math.Min(x1, x2), math.Min(x3, x4)) || maxY != math.Max(math.Max(y1, y2), math.Max(y3, y4)) ||
+		maxX != math.Max(math.Max(x1, x2), math.Max(x3, x4)) || minY != math.Min(math.Min(y1, y2), math.Min(y3, y4)) {
+		t.Errorf("tileCRSBounds at z%d = [%f %f %f %f], want the corner hull", z, minX, minY, maxX, maxY)
+	}
+}
+
+// --- per-pixel allocations ---
+
+// allocTestTiles returns one decoded 64×64 tile of every type the COG
+// reader produces, filled with a pattern.
+func allocTestTiles() map[string]image.Image {
+	r := image.Rect(0, 0, 64, 64)
+	rgba := image.NewRGBA(r)
+	gray := image.NewGray(r)
+	cmyk := image.NewCMYK(r)
+	ycbcr := image.NewYCbCr(r, image.YCbCrSubsampleRatio420)
+	nycbcra := image.NewNYCbCrA(r, image.YCbCrSubsampleRatio420)
+	for i := range rgba.Pix {
+		rgba.Pix[i] = uint8(i*7) | 1 // odd: alpha never 0
+		cmyk.Pix[i] = uint8(i * 5)
+	}
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 3)
+		ycbcr.Y[i] = uint8(i * 3)
+		nycbcra.Y[i] = uint8(i * 3)
+		nycbcra.A[i] = 255
+	}
+	return map[string]image.Image{"rgba": rgba, "gray": gray, "cmyk": cmyk, "ycbcr": ycbcr, "nycbcra": nycbcra}
+}
+
+// allocTestPoints are sample positions in a 2×2 grid of 64px tiles: inside
+// one tile, across the vertical, horizontal, and corner tile boundaries,
+// and at the image edge.
+var allocTestPoints = [][2]float64{{20.3, 30.7}, {63.6, 10.2}, {10.5, 64.4}, {63.9, 63.1}, {0.2, 127.8}}
+
+func TestSampleTileSource_NoAllocs(t *testing.T) {
+	modes := []Resampling{ResamplingNearest, ResamplingBilinear, ResamplingBicubic, ResamplingLanczos, ResamplingMode}
+	for name, tile := range allocTestTiles() {
+		src := &cog.Reader{}
+		cache := cog.NewTileCache(16)
+		for row := 0; row < 2; row++ {
+			for col := 0; col < 2; col++ {
+				cache.Put(src.ID(), 0, col, row, tile)
+			}
+		}
+		// One CRS unit per pixel, upper-left corner at the origin.
+		ts := tileSource{reader: src, imgW: 128, imgH: 128, tileW: 64, tileH: 64, footprint: 5,
+			levelPixelSize: 1, levelPixelSizeY: 1, minCRSY: -128, maxCRSX: 128}
+		srcs := []tileSource{ts}
+		var scratch sampleScratch
+		for _, mode := range modes {
+			for _, luts := range []*gammaLUTs{nil, buildGammaLUTs(2.2)} {
+				allocs := testing.AllocsPerRun(20, func() {
+					for _, p := range allocTestPoints {
+						if _, _, _, _, found := sampleTileSource(&ts, p[0], p[1], cache, mode, luts, &scratch); !found {
+							t.Fatalf("%s mode %d: no sample at %v", name, mode, p)
+						}
+					}
+					if _, _, _, _, found := sampleFromTileSources(srcs, 40, -30, cache, mode, luts, &scratch); !found {
+						t.Fatalf("%s mode %d: no sample at CRS (40, -30)", name, mode)
+					}
+				})
+				if allocs != 0 {
+					t.Errorf("%s mode %d gamma %v: %v allocs per run, want 0", name, mode, luts != nil, allocs)
+				}
+			}
+		}
+	}
+}
+
+func TestSampleTileSourceFloat_NoAllocs(t *testing.T) {
+	src := &cog.Reader{}
+	cache := cog.NewFloatTileCache(16)
+	for row := 0; row < 2; row++ {
+		for col := 0; col < 2; col++ {
+			data := make([]float32, 64*64)
+			for i := range data {
+				data[i] = float32(i%97) + 100
+			}
+			data[5] = float32(math.NaN())
+			cache.Put(src.ID(), 0, col, row, data, 64, 64)
+		}
+	}
+	ts := tileSource{reader: src, imgW: 128, imgH: 128, tileW: 64, tileH: 64}
+	var scratch sampleScratch
+	for _, mode := range []Resampling{ResamplingNearest, ResamplingBilinear, ResamplingBicubic, ResamplingLanczos} {
+		allocs := testing.AllocsPerRun(20, func() {
+			for _, p := range allocTestPoints {
+				if _, ok := sampleTileSourceFloat(&ts, p[0], p[1], cache, mode, &scratch); !ok {
+					t.Fatalf("mode %d: no sample at %v", mode, p)
+				}
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("mode %d: %v allocs per run, want 0", mode, allocs)
+		}
+	}
+}
+
+func TestSampleScratchRelease(t *testing.T) {
+	s := &sampleScratch{srcs: []tileSource{{reader: &cog.Reader{}}}}
+	s.kernel.tiles[1][1] = image.NewRGBA(image.Rect(0, 0, 1, 1))
+	s.kernel.ftData[0][1] = []float32{1}
+	s.release()
+	if len(s.srcs) != 0 || s.srcs[:1][0].reader != nil {
+		t.Error("release kept the tile sources")
+	}
+	if s.kernel.tiles[1][1] != nil || s.kernel.ftData[0][1] != nil {
+		t.Error("release kept source tiles")
+	}
+}
+
+func TestSourcePixel_NonSquare(t *testing.T) {
+	// 10 × 30 CRS units per pixel, upper-left corner at (1000, 2000).
+	src := tileSource{
+		geo:            cog.GeoInfo{OriginX: 1000, OriginY: 2000},
+		imgW:           100,
+		imgH:           100,
+		levelPixelSize: 10, levelPixelSizeY: 30,
+		minCRSX: 1000, maxCRSX: 2000, minCRSY: -1000, maxCRSY: 2000,
+	}
+	pixX, pixY, inside, _ := src.sourcePixel(1250, 1100)
+	if !inside || pixX != 25 || pixY != 30 {
+		t.Errorf("sourcePixel(1250, 1100) = (%g, %g), inside %v; want (25, 30), inside", pixX, pixY, inside)
+	}
+	// The seam halo is half a pixel along each axis.
+	if _, _, inside, near := src.sourcePixel(1500, -1010); inside || !near {
+		t.Errorf("10 units below the bottom edge: inside %v, near %v; want near only", inside, near)
+	}
+}
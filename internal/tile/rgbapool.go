@@ -1,8 +1,12 @@
 package tile
 
 import (
+	"fmt"
 	"image"
+	"runtime"
+	"sort"
 	"sync"
+	"sync/atomic"
 )
 
 // rgbaPoolKey identifies a pool by image dimensions.
@@ -15,18 +19,38 @@ type rgbaPoolKey struct {
 // distinct tile sizes exist per run, so the map stays tiny.
 var rgbaPools sync.Map
 
+// Pool counters, always maintained (one atomic add per call).
+var rgbaGets, rgbaAllocs, rgbaPuts atomic.Int64
+
+// Leak-check state: while enabled, every image handed out by GetRGBA is
+// recorded with its call site until it comes back through PutRGBA.
+var (
+	rgbaLeakCheck atomic.Bool
+	rgbaLeakMu    sync.Mutex
+	rgbaOut       map[*image.RGBA]string // outstanding image → GetRGBA call site
+	rgbaUntracked int64                  // puts of images not handed out while tracking
+)
+
 // GetRGBA returns a zeroed *image.RGBA from the pool, or allocates a new one.
 // The returned image has Rect (0,0)-(w,h) with all pixels set to zero.
 func GetRGBA(w, h int) *image.RGBA {
+	rgbaGets.Add(1)
+	var img *image.RGBA
 	key := rgbaPoolKey{w, h}
 	if p, ok := rgbaPools.Load(key); ok {
 		if v := p.(*sync.Pool).Get(); v != nil {
-			img := v.(*image.RGBA)
+			img = v.(*image.RGBA)
 			clear(img.Pix)
-			return img
 		}
 	}
-	return image.NewRGBA(image.Rect(0, 0, w, h))
+	if img == nil {
+		rgbaAllocs.Add(1)
+		img = image.NewRGBA(image.Rect(0, 0, w, h))
+	}
+	if rgbaLeakCheck.Load() {
+		trackRGBA(img)
+	}
+	return img
 }
 
 // PutRGBA returns an *image.RGBA to the pool for reuse.
@@ -35,7 +59,126 @@ func PutRGBA(img *image.RGBA) {
 	if img == nil {
 		return
 	}
+	rgbaPuts.Add(1)
+	if rgbaLeakCheck.Load() {
+		untrackRGBA(img)
+	}
 	key := rgbaPoolKey{img.Rect.Dx(), img.Rect.Dy()}
 	p, _ := rgbaPools.LoadOrStore(key, &sync.Pool{})
 	p.(*sync.Pool).Put(img)
 }
+
+// RGBAPoolStats is a snapshot of the RGBA pool counters since process start
+// (or the last ResetRGBAPoolStats).
+type RGBAPoolStats struct {
+	Gets   int64 // GetRGBA calls
+	Allocs int64 // GetRGBA calls that allocated because the pool was empty
+	Puts   int64 // non-nil PutRGBA calls
+}
+
+// Reuses is the number of GetRGBA calls served from the pool.
+func (s RGBAPoolStats) Reuses() int64 { return s.Gets - s.Allocs }
+
+// Outstanding is Gets - Puts: images handed out and not returned. Images
+// that are dropped for the GC instead of returned count here too, as do
+// (negatively) images put that never came from GetRGBA, such as decoded
+// tiles, so a non-zero value is a hint rather than proof of a leak.
+func (s RGBAPoolStats) Outstanding() int64 { return s.Gets - s.Puts }
+
+// String formats the stats for logs.
+func (s RGBAPoolStats) String() string {
+	reuse := 0.0
+	if s.Gets > 0 {
+		reuse = 100 * float64(s.Reuses()) / float64(s.Gets)
+	}
+	return fmt.Sprintf("%d gets (%d allocated, %.0f%% reused), %d puts, %d outstanding",
+		s.Gets, s.Allocs, reuse, s.Puts, s.Outstanding())
+}
+
+// ReadRGBAPoolStats returns the current pool counters.
+func ReadRGBAPoolStats() RGBAPoolStats {
+	return RGBAPoolStats{Gets: rgbaGets.Load(), Allocs: rgbaAllocs.Load(), Puts: rgbaPuts.Load()}
+}
+
+// ResetRGBAPoolStats zeroes the pool counters.
+func ResetRGBAPoolStats() {
+	rgbaGets.Store(0)
+	rgbaAllocs.Store(0)
+	rgbaPuts.Store(0)
+}
+
+// RGBALeak groups outstanding images by the code that got them.
+type RGBALeak struct {
+	Site  string // function and file:line that called GetRGBA
+	Count int
+}
+
+// SetRGBALeakCheck turns leak tracking on or off. Turning it on clears any
+// previous record. Tracking takes a mutex and a runtime.Caller per call, so
+// it is meant for diagnosis, not production runs. Tracked images are kept
+// reachable until they are returned.
+func SetRGBALeakCheck(on bool) {
+	rgbaLeakMu.Lock()
+	defer rgbaLeakMu.Unlock()
+	if on {
+		rgbaOut = make(map[*image.RGBA]string)
+		rgbaUntracked = 0
+	} else {
+		rgbaOut = nil
+	}
+	rgbaLeakCheck.Store(on)
+}
+
+// RGBALeaks returns the images handed out since leak checking was enabled
+// and not yet returned, grouped by call site (most first), and the number
+// of PutRGBA calls for images GetRGBA did not hand out while tracking.
+// The latter are usually decoded images adopted into the pool, but a
+// double Put of the same image also shows up there.
+func RGBALeaks() (leaks []RGBALeak, untrackedPuts int64) {
+	rgbaLeakMu.Lock()
+	defer rgbaLeakMu.Unlock()
+	bySite := make(map[string]int)
+	for _, site := range rgbaOut {
+		bySite[site]++
+	}
+	for site, n := range bySite {
+		leaks = append(leaks, RGBALeak{Site: site, Count: n})
+	}
+	sort.Slice(leaks, func(i, j int) bool {
+		if leaks[i].Count != leaks[j].Count {
+			return leaks[i].Count > leaks[j].Count
+		}
+		return leaks[i].Site < leaks[j].Site
+	})
+	return leaks, rgbaUntracked
+}
+
+// trackRGBA records img as outstanding, attributed to GetRGBA's caller.
+func trackRGBA(img *image.RGBA) {
+	site := "unknown"
+	if pc, file, line, ok := runtime.Caller(2); ok {
+		name := "?"
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			name = fn.Name()
+		}
+		site = fmt.Sprintf("%s (%s:%d)", name, file, line)
+	}
+	rgbaLeakMu.Lock()
+	if rgbaOut != nil {
+		rgbaOut[img] = site
+	}
+	rgbaLeakMu.Unlock()
+}
+
+// untrackRGBA removes img from the outstanding set.
+func untrackRGBA(img *image.RGBA) {
+	rgbaLeakMu.Lock()
+	if rgbaOut != nil {
+		if _, ok := rgbaOut[img]; ok {
+			delete(rgbaOut, img)
+		} else {
+			rgbaUntracked++
+		}
+	}
+	rgbaLeakMu.Unlock()
+}
@@ -2,7 +2,11 @@ package tile
 
 import (
 	"image"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
 )
 
 // TestGetRGBA_CorrectDimensions verifies the returned image has the requested bounds.
@@ -86,3 +90,111 @@ func TestGetRGBA_PoolReuseIdentity(t *testing.T) {
 		}
 	}
 }
+
+// TestRGBAPool_StressBalanced hammers the pool from many goroutines with
+// balanced Get/Put pairs and checks that the counters and the leak tracker
+// agree that nothing is outstanding.
+func TestRGBAPool_StressBalanced(t *testing.T) {
+	ResetRGBAPoolStats()
+	SetRGBALeakCheck(true)
+	defer SetRGBALeakCheck(false)
+
+	const workers, iters = 16, 500
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			size := 8 + 8*(w%3) // a few distinct pools
+			for i := 0; i < iters; i++ {
+				a := GetRGBA(size, size)
+				b := GetRGBA(size, size)
+				a.Pix[0], b.Pix[0] = 1, 2
+				PutRGBA(b)
+				PutRGBA(a)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	s := ReadRGBAPoolStats()
+	if s.Gets != 2*workers*iters || s.Puts != s.Gets {
+		t.Errorf("stats = %s, want %d gets and as many puts", s, 2*workers*iters)
+	}
+	if s.Outstanding() != 0 {
+		t.Errorf("Outstanding = %d, want 0", s.Outstanding())
+	}
+	if s.Allocs > s.Gets || s.Reuses() < 0 {
+		t.Errorf("inconsistent stats: %s", s)
+	}
+	if leaks, untracked := RGBALeaks(); len(leaks) != 0 || untracked != 0 {
+		t.Errorf("RGBALeaks = %v, %d untracked; want none", leaks, untracked)
+	}
+}
+
+// TestRGBAPool_LeakCheckReportsSite verifies that an image that is never
+// returned is reported with the caller of GetRGBA, and that puts of images
+// the pool did not hand out are counted separately.
+func TestRGBAPool_LeakCheckReportsSite(t *testing.T) {
+	SetRGBALeakCheck(true)
+	defer SetRGBALeakCheck(false)
+
+	leaked := leakOneRGBA()
+	PutRGBA(image.NewRGBA(image.Rect(0, 0, 4, 4))) // foreign image
+
+	leaks, untracked := RGBALeaks()
+	if len(leaks) != 1 || leaks[0].Count != 1 || !strings.Contains(leaks[0].Site, "leakOneRGBA") {
+		t.Fatalf("RGBALeaks = %v, want one leak from leakOneRGBA", leaks)
+	}
+	if untracked != 1 {
+		t.Errorf("untracked puts = %d, want 1", untracked)
+	}
+
+	PutRGBA(leaked)
+	if leaks, _ := RGBALeaks(); len(leaks) != 0 {
+		t.Errorf("after Put: RGBALeaks = %v, want none", leaks)
+	}
+}
+
+func leakOneRGBA() *image.RGBA {
+	return GetRGBA(4, 4)
+}
+
+// TestTransformRebuild_NoRGBALeaks runs a pyramid rebuild with leak checking
+// and verifies every pooled image taken by the pipeline is returned.
+func TestTransformRebuild_NoRGBALeaks(t *testing.T) {
+	tileSize := 8
+	bounds := testBounds()
+	img := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7) // non-uniform, so tiles keep their pixels
+	}
+	img.Pix[3] = 255
+	tileBytes, err := testEncoder(t).Encode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := &mockPMTilesReader{
+		tiles: map[[3]int][]byte{
+			{2, 2, 1}: tileBytes, {2, 3, 1}: tileBytes,
+			{2, 2, 2}: tileBytes, {2, 3, 2}: tileBytes,
+		},
+		header: pmtiles.Header{MinZoom: 2, MaxZoom: 2,
+			MinLon: bounds[0], MinLat: bounds[1], MaxLon: bounds[2], MaxLat: bounds[3]},
+	}
+
+	SetRGBALeakCheck(true)
+	defer SetRGBALeakCheck(false)
+
+	cfg := TransformConfig{
+		MinZoom: 0, MaxZoom: 2, TileSize: tileSize, Concurrency: 2,
+		Encoder: testEncoder(t), SourceFormat: "png", Resampling: ResamplingBilinear,
+		Mode: TransformRebuild, Bounds: bounds,
+	}
+	if _, err := Transform(cfg, reader, newMockTileWriter()); err != nil {
+		t.Fatalf("Transform: %v", err)
+	}
+	if leaks, _ := RGBALeaks(); len(leaks) != 0 {
+		t.Errorf("pooled images not returned: %v", leaks)
+	}
+}
@@ -0,0 +1,213 @@
+package tile
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// SharpenRange enables contrast-preserving downsampling for the parent
+// tiles of zoom levels MinZoom..MaxZoom.
+type SharpenRange struct {
+	MinZoom, MaxZoom int
+	// Strength scales how much of the variance lost by averaging is put
+	// back: 1 restores all of it, 0.5 half (see sharpenDownsampled).
+	Strength float64
+}
+
+// maxSharpenGain caps the detail gain of one parent tile, so a parent that
+// averaged fine noise down to an almost flat image is not blown up.
+const maxSharpenGain = 2.0
+
+// ParseSharpen parses a --sharpen value: comma-separated zoom ranges
+// "min-max" or single zooms "z", each optionally followed by ":strength"
+// (default 1), e.g. "0-8" or "0-6:1,7-9:0.5".
+func ParseSharpen(s string) ([]SharpenRange, error) {
+	var ranges []SharpenRange
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		zooms, strength, hasStrength := strings.Cut(part, ":")
+		r := SharpenRange{Strength: 1}
+		if hasStrength {
+			v, err := strconv.ParseFloat(strings.TrimSpace(strength), 64)
+			if err != nil || v <= 0 || v > 4 {
+				return nil, fmt.Errorf("invalid sharpen strength %q in %q (want 0 < strength ≤ 4)", strength, part)
+			}
+			r.Strength = v
+		}
+		lo, hi, isRange := strings.Cut(zooms, "-")
+		var err error
+		if r.MinZoom, err = strconv.Atoi(strings.TrimSpace(lo)); err != nil {
+			return nil, fmt.Errorf("invalid sharpen zoom %q in %q", lo, part)
+		}
+		r.MaxZoom = r.MinZoom
+		if isRange {
+			if r.MaxZoom, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil {
+				return nil, fmt.Errorf("invalid sharpen zoom %q in %q", hi, part)
+			}
+		}
+		if r.MinZoom < 0 || r.MaxZoom < r.MinZoom {
+			return nil, fmt.Errorf("invalid sharpen zoom range %q", zooms)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// sharpenStrength returns the strength of the first range containing z,
+// or 0 when none does.
+func sharpenStrength(ranges []SharpenRange, z int) float64 {
+	for _, r := range ranges {
+		if z >= r.MinZoom && z <= r.MaxZoom {
+			return r.Strength
+		}
+	}
+	return 0
+}
+
+// sharpenDownsampled puts back the local contrast that downsampling the
+// four children into td averaged away.
+//
+// Averaging each 2×2 block loses exactly the variance within the block.
+// The mean of that variance over the children's opaque blocks is the
+// energy to restore. The parent's own detail is each pixel's difference
+// from the mean of its opaque 3×3 neighbourhood; it is amplified by the
+// gain g that raises its energy by strength times the lost energy:
+// (1+g)²·E_parent = E_parent + strength·E_lost, with g at most
+// maxSharpenGain. Tiles made of uniform children lose nothing and are
+// returned unchanged. Transparent pixels and alpha are left alone.
+//
+// td is consumed; the returned tile replaces it.
+func sharpenDownsampled(td *TileData, children [4]*TileData, tileSize int, strength float64) *TileData {
+	if td == nil || td.IsUniform() || strength <= 0 {
+		return td
+	}
+	lost := lostVariance(children, tileSize)
+	if lost == 0 {
+		return td
+	}
+
+	src := td.ToRGBA()
+	var detail float64
+	var n int
+	for y := 0; y < tileSize; y++ {
+		for x := 0; x < tileSize; x++ {
+			off := y*src.Stride + x*4
+			if src.Pix[off+3] == 0 {
+				continue
+			}
+			mean := localMean(src, x, y, tileSize)
+			for c := 0; c < 3; c++ {
+				d := float64(src.Pix[off+c]) - mean[c]
+				detail += d * d
+			}
+			n++
+		}
+	}
+	if n == 0 || detail == 0 {
+		return td
+	}
+	detail /= float64(n)
+	gain := math.Min(math.Sqrt(1+strength*lost/detail)-1, maxSharpenGain)
+
+	dst := GetRGBA(tileSize, tileSize)
+	for y := 0; y < tileSize; y++ {
+		for x := 0; x < tileSize; x++ {
+			off := y*src.Stride + x*4
+			dOff := y*dst.Stride + x*4
+			a := src.Pix[off+3]
+			dst.Pix[dOff+3] = a
+			if a == 0 {
+				dst.Pix[dOff], dst.Pix[dOff+1], dst.Pix[dOff+2] = src.Pix[off], src.Pix[off+1], src.Pix[off+2]
+				continue
+			}
+			mean := localMean(src, x, y, tileSize)
+			for c := 0; c < 3; c++ {
+				v := float64(src.Pix[off+c])
+				dst.Pix[dOff+c] = clampByte(v + gain*(v-mean[c]))
+			}
+		}
+	}
+	if td.img == nil {
+		PutRGBA(src) // expanded from a gray tile
+	}
+	td.Release()
+	return newTileData(dst, tileSize)
+}
+
+// lostVariance returns the mean per-block variance (summed over R, G, B)
+// of the children's 2×2 blocks whose four pixels are all opaque.
+func lostVariance(children [4]*TileData, tileSize int) float64 {
+	var sum float64
+	var blocks int
+	for _, c := range children {
+		if c == nil {
+			continue
+		}
+		if c.IsUniform() {
+			if c.Color().A != 0 {
+				blocks += (tileSize / 2) * (tileSize / 2)
+			}
+			continue
+		}
+		for y := 0; y+1 < tileSize; y += 2 {
+			for x := 0; x+1 < tileSize; x += 2 {
+				var px [4][3]uint8
+				opaque := true
+				for i := 0; i < 4; i++ {
+					p := c.RGBAAt(x+i%2, y+i/2)
+					if p.A == 0 {
+						opaque = false
+						break
+					}
+					px[i] = [3]uint8{p.R, p.G, p.B}
+				}
+				if !opaque {
+					continue
+				}
+				for ch := 0; ch < 3; ch++ {
+					var s, s2 float64
+					for i := 0; i < 4; i++ {
+						v := float64(px[i][ch])
+						s += v
+						s2 += v * v
+					}
+					sum += s2/4 - (s/4)*(s/4)
+				}
+				blocks++
+			}
+		}
+	}
+	if blocks == 0 {
+		return 0
+	}
+	return sum / float64(blocks)
+}
+
+// localMean returns the mean R, G, B of the opaque pixels in the 3×3
+// neighbourhood of (x, y), clipped to the tile.
+func localMean(img *image.RGBA, x, y, tileSize int) [3]float64 {
+	var sum [3]float64
+	var n float64
+	for ny := y - 1; ny <= y+1; ny++ {
+		if ny < 0 || ny >= tileSize {
+			continue
+		}
+		for nx := x - 1; nx <= x+1; nx++ {
+			if nx < 0 || nx >= tileSize {
+				continue
+			}
+			off := ny*img.Stride + nx*4
+			if img.Pix[off+3] == 0 {
+				continue
+			}
+			sum[0] += float64(img.Pix[off])
+			sum[1] += float64(img.Pix[off+1])
+			sum[2] += float64(img.Pix[off+2])
+			n++
+		}
+	}
+	return [3]float64{sum[0] / n, sum[1] / n, sum[2] / n}
+}
@@ -0,0 +1,103 @@
+package tile
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// stripedImage has vertical stripes 4 pixels wide (100 and 140) plus, when
+// noise is set, a ±10 pixel checkerboard that 2×2 averaging removes.
+func stripedImage(tileSize int, noise bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
+	for y := 0; y < tileSize; y++ {
+		for x := 0; x < tileSize; x++ {
+			v := 100 + 40*((x/4)%2)
+			if noise {
+				v += 20*((x+y)%2) - 10
+			}
+			img.SetRGBA(x, y, color.RGBA{uint8(v), uint8(v), uint8(v), 255})
+		}
+	}
+	return img
+}
+
+// stripeContrast is the difference between a bright and a dark stripe of a
+// downsampled tile, away from the tile edges.
+func stripeContrast(td *TileData) int {
+	return int(td.RGBAAt(3, 5).R) - int(td.RGBAAt(5, 5).R)
+}
+
+func TestParseSharpen(t *testing.T) {
+	got, err := ParseSharpen("0-6, 7-9:0.5,12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SharpenRange{{0, 6, 1}, {7, 9, 0.5}, {12, 12, 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseSharpen = %+v, want %+v", got, want)
+	}
+	if s := sharpenStrength(got, 8); s != 0.5 {
+		t.Errorf("strength at z8 = %g, want 0.5", s)
+	}
+	if s := sharpenStrength(got, 10); s != 0 {
+		t.Errorf("strength at z10 = %g, want 0", s)
+	}
+	for _, bad := range []string{"", "a-3", "5-2", "-1", "0-3:0", "0-3:x", "0-3:9"} {
+		if _, err := ParseSharpen(bad); err == nil {
+			t.Errorf("ParseSharpen(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestSharpenDownsampled(t *testing.T) {
+	const tileSize = 16
+	child := func(noise bool) *TileData { return fullTile(stripedImage(tileSize, noise), tileSize) }
+
+	noisy := [4]*TileData{child(true), child(true), child(true), child(true)}
+	plain := downsampleTile(noisy[0], noisy[1], noisy[2], noisy[3], tileSize, ResamplingBilinear)
+	base := stripeContrast(plain)
+	sharp := sharpenDownsampled(downsampleTile(noisy[0], noisy[1], noisy[2], noisy[3], tileSize, ResamplingBilinear), noisy, tileSize, 1)
+	if got := stripeContrast(sharp); got <= base {
+		t.Errorf("sharpened stripe contrast %d, want more than plain %d", got, base)
+	}
+	half := sharpenDownsampled(downsampleTile(noisy[0], noisy[1], noisy[2], noisy[3], tileSize, ResamplingBilinear), noisy, tileSize, 0.5)
+	if got := stripeContrast(half); got <= base || got >= stripeContrast(sharp) {
+		t.Errorf("strength 0.5 stripe contrast %d, want between %d and %d", got, base, stripeContrast(sharp))
+	}
+
+	// Stripes aligned with the 2×2 blocks lose nothing when averaged.
+	clean := [4]*TileData{child(false), child(false), child(false), child(false)}
+	td := downsampleTile(clean[0], clean[1], clean[2], clean[3], tileSize, ResamplingBilinear)
+	if got := sharpenDownsampled(td, clean, tileSize, 1); got != td {
+		t.Error("children without sub-block detail: tile was changed")
+	}
+
+	// Uniform parents stay uniform.
+	red := [4]*TileData{solidTile(tileSize, color.RGBA{255, 0, 0, 255}), nil, nil, nil}
+	td = newTileDataUniform(color.RGBA{255, 0, 0, 255}, tileSize)
+	if got := sharpenDownsampled(td, red, tileSize, 1); got != td {
+		t.Error("uniform tile was changed")
+	}
+}
+
+func TestSharpenDownsampled_KeepsTransparency(t *testing.T) {
+	const tileSize = 16
+	img := stripedImage(tileSize, true)
+	for y := 0; y < tileSize; y++ {
+		for x := 0; x < tileSize/2; x++ {
+			img.SetRGBA(x, y, color.RGBA{})
+		}
+	}
+	children := [4]*TileData{fullTile(img, tileSize), nil, nil, nil}
+	td := sharpenDownsampled(downsampleTile(children[0], nil, nil, nil, tileSize, ResamplingBilinear), children, tileSize, 1)
+	for y := 0; y < tileSize; y++ {
+		for x := 0; x < tileSize; x++ {
+			wantOpaque := x >= tileSize/4 && x < tileSize/2 && y < tileSize/2
+			if got := td.RGBAAt(x, y).A == 255; got != wantOpaque {
+				t.Fatalf("pixel (%d,%d) opaque = %v, want %v", x, y, got, wantOpaque)
+			}
+		}
+	}
+}
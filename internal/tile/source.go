@@ -0,0 +1,41 @@
+package tile
+
+import (
+	"image"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+)
+
+// RasterSource is a georeferenced raster that the renderers sample: a
+// full-resolution level and coarser overview levels, each split into
+// tiles of a fixed size. *cog.Reader is one; PMTilesSource serves the
+// tiles of an existing archive.
+//
+// Levels are numbered from 0, the finest. Pixel sizes and bounds are in
+// the units of the source's CRS (GeoInfo().EPSG). Implementations must
+// be safe for concurrent use.
+type RasterSource interface {
+	// ID identifies the source in the shared tile caches; it must be
+	// unique among the sources of a run.
+	ID() int
+	GeoInfo() cog.GeoInfo
+	BoundsInCRS() (minX, minY, maxX, maxY float64)
+	// PixelSize is the pixel size of level 0, used to rank sources.
+	PixelSize() float64
+	// OverviewForZoom returns the level whose pixel size best matches an
+	// output pixel of outputPixelSizeCRS.
+	OverviewForZoom(outputPixelSizeCRS float64) int
+	IFDPixelSize(level int) float64
+	IFDPixelSizeY(level int) float64
+	IFDWidth(level int) int
+	IFDHeight(level int) int
+	IFDTileSize(level int) [2]int
+	// ReadTile returns tile (col, row) of level as an image. Pixels
+	// without data are transparent.
+	ReadTile(level, col, row int) (image.Image, error)
+	// ReadFloatTile returns the tile as values, for elevation and color
+	// map rendering; sources without values return an error.
+	ReadFloatTile(level, col, row int) ([]float32, int, int, error)
+}
+
+var _ RasterSource = (*cog.Reader)(nil)
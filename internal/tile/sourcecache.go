@@ -0,0 +1,43 @@
+package tile
+
+import "github.com/pspoerri/geotiff2pmtiles/internal/cog"
+
+// SourceCache holds decoded COG tiles for the max-zoom rendering pass.
+// Generate creates a fresh one per run unless Config.SourceCache is set; a
+// long-running caller (the daemon mode) passes one in so consecutive runs
+// over the same open sources start with a warm cache. Safe for concurrent
+// use, but only valid for the sources and band config it was filled from.
+type SourceCache struct {
+	tiles  *cog.TileCache
+	floats *cog.FloatTileCache
+}
+
+// NewSourceCache creates a cache of cfg.SourceCacheTiles tiles, or sized for
+// cfg.Concurrency workers. The float cache is only allocated for configs
+// reading float sources (Terrarium and hillshade).
+func NewSourceCache(cfg Config) *SourceCache {
+	size := cfg.sourceCacheSize()
+	sc := &SourceCache{tiles: cog.NewTileCache(size)}
+	if cfg.floatSources() {
+		sc.floats = cog.NewFloatTileCache(size)
+	}
+	return sc
+}
+
+// sourceCacheSize returns the number of decoded COG tiles to keep:
+// SourceCacheTiles if set, else sourceCacheSizeFor(Concurrency).
+func (c *Config) sourceCacheSize() int {
+	if c.SourceCacheTiles > 0 {
+		return c.SourceCacheTiles
+	}
+	return sourceCacheSizeFor(c.Concurrency)
+}
+
+// sourceCacheSizeFor returns the number of decoded COG tiles to keep for n
+// workers: 128 per worker, at least 256.
+func sourceCacheSizeFor(n int) int {
+	if size := n * 128; size > 256 {
+		return size
+	}
+	return 256
+}
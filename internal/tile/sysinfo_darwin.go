@@ -25,3 +25,19 @@ func totalSystemRAM() (uint64, error) {
 	}
 	return size, nil
 }
+
+// availableSystemRAM returns the total RAM on macOS, which compresses and
+// purges memory rather than reporting a cheap "available" figure.
+func availableSystemRAM() (uint64, error) {
+	return totalSystemRAM()
+}
+
+// peakRSS returns the process's peak resident set size in bytes
+// (ru_maxrss is in bytes on macOS).
+func peakRSS() (int64, error) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, err
+	}
+	return ru.Maxrss, nil
+}
@@ -2,7 +2,14 @@
 
 package tile
 
-import "syscall"
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
 
 // totalSystemRAM returns the total physical RAM in bytes on Linux.
 func totalSystemRAM() (uint64, error) {
@@ -12,3 +19,72 @@ func totalSystemRAM() (uint64, error) {
 	}
 	return info.Totalram * uint64(info.Unit), nil
 }
+
+// availableSystemRAM returns the memory that can be allocated without
+// swapping on Linux: MemAvailable from /proc/meminfo, lowered to the
+// headroom of the process's cgroup when it has a memory limit.
+func availableSystemRAM() (uint64, error) {
+	avail, err := memAvailable()
+	if err != nil {
+		return 0, err
+	}
+	// cgroup v2, then v1 (whose "unlimited" is a huge number).
+	for _, f := range [][2]string{
+		{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory.current"},
+		{"/sys/fs/cgroup/memory/memory.limit_in_bytes", "/sys/fs/cgroup/memory/memory.usage_in_bytes"},
+	} {
+		limit, err1 := readUintFile(f[0])
+		usage, err2 := readUintFile(f[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if limit > usage && limit-usage < avail {
+			avail = limit - usage
+		}
+		break
+	}
+	return avail, nil
+}
+
+// memAvailable reads MemAvailable from /proc/meminfo.
+func memAvailable() (uint64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("/proc/meminfo: %w", err)
+			}
+			return kb * 1024, nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("/proc/meminfo: no MemAvailable")
+}
+
+// readUintFile parses a file holding a single integer ("max" is an error).
+func readUintFile(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// peakRSS returns the process's peak resident set size in bytes
+// (ru_maxrss is in kilobytes on Linux).
+func peakRSS() (int64, error) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, err
+	}
+	return ru.Maxrss * 1024, nil
+}
@@ -8,3 +8,13 @@ import "fmt"
 func totalSystemRAM() (uint64, error) {
 	return 0, fmt.Errorf("unsupported platform for RAM detection")
 }
+
+// availableSystemRAM is unsupported on this platform.
+func availableSystemRAM() (uint64, error) {
+	return 0, fmt.Errorf("unsupported platform for RAM detection")
+}
+
+// peakRSS is unsupported on this platform.
+func peakRSS() (int64, error) {
+	return 0, fmt.Errorf("unsupported platform for peak RSS")
+}
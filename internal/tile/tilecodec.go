@@ -0,0 +1,117 @@
+package tile
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+)
+
+// TileCodec converts the non-uniform tiles of a DiskTileStore to the bytes
+// the store keeps in memory and spills to disk, and back.
+type TileCodec interface {
+	// Marshal returns the stored form of td. encoded holds td in the
+	// output format, as written to the archive, and may be returned as is
+	// (the store then keeps it: callers must not reuse its buffer).
+	Marshal(td *TileData, encoded []byte) []byte
+	// Unmarshal decodes stored bytes to a TileData owned by the caller,
+	// or returns nil if they cannot be decoded.
+	Unmarshal(data []byte) *TileData
+	// SizeHint is the typical size of a stored tile in bytes, used to size
+	// the in-memory map and the spill file extents.
+	SizeHint() int
+}
+
+// Spill formats for NewTileCodec (Config.SpillFormat, --spill-format).
+const (
+	SpillEncoded = "encoded" // tiles in the output format (EncodedCodec)
+	SpillRaw     = "raw"     // serialized pixels (RawCodec)
+)
+
+// NewTileCodec returns the codec of a spill format: "encoded" (or "")
+// keeps tiles in the output format, "raw" their pixels. premultiplied
+// marks encoded tiles as storing premultiplied colors.
+func NewTileCodec(spillFormat, format string, tileSize int, dither, premultiplied bool) (TileCodec, error) {
+	switch spillFormat {
+	case "", SpillEncoded:
+		return EncodedCodec{Format: format, TileSize: tileSize, Dither: dither, Premultiplied: premultiplied}, nil
+	case SpillRaw:
+		return RawCodec{TileSize: tileSize}, nil
+	}
+	return nil, fmt.Errorf("unknown spill format %q (want %s or %s)", spillFormat, SpillEncoded, SpillRaw)
+}
+
+// EncodedCodec keeps the encoded output bytes (PNG/WebP/JPEG) of a tile,
+// 5-25× smaller than its pixels, and decodes them on read-back. For lossy
+// formats the parents are then computed from decoded, not original, pixels.
+type EncodedCodec struct {
+	Format   string // encoder format name (e.g. "png", "jpeg", "webp", "terrarium")
+	TileSize int
+	Dither   bool // dither 16-bit tiles to 8 bits on decode
+	// Premultiplied decodes the colors as premultiplied, for tiles
+	// written through encode.Premultiply.
+	Premultiplied bool
+}
+
+// Marshal returns encoded unchanged.
+func (c EncodedCodec) Marshal(td *TileData, encoded []byte) []byte {
+	return encoded
+}
+
+// Unmarshal decodes encoded image bytes back to a TileData.
+func (c EncodedCodec) Unmarshal(data []byte) *TileData {
+	img, err := encode.DecodeImage(data, c.Format)
+	if err != nil {
+		return nil
+	}
+	if c.Premultiplied {
+		img = encode.FromPremultiplied(img)
+	}
+
+	// Fast path: already RGBA.
+	if rgba, ok := img.(*image.RGBA); ok {
+		return newTileData(rgba, c.TileSize)
+	}
+
+	// Fast path: grayscale image.
+	if g, ok := img.(*image.Gray); ok {
+		return &TileData{gray: g, tileSize: c.TileSize}
+	}
+
+	// General case: convert to RGBA (handles NRGBA from PNG, YCbCr from
+	// JPEG, 16-bit PNG, etc.).
+	return newTileData(imageToRGBA(img, c.Dither), c.TileSize)
+}
+
+// SizeHint is the average size of an encoded tile (JPEG imagery).
+func (c EncodedCodec) SizeHint() int {
+	return estSpillTileBytes
+}
+
+// RawCodec keeps the pixels of a tile as SerializeAppend writes them,
+// after a byte holding the tileDataType. Read-back is a copy instead of a
+// decode, and lossless, at the cost of 4 bytes per pixel (1 for gray
+// tiles) in memory and on disk.
+type RawCodec struct {
+	TileSize int
+}
+
+// Marshal serializes the pixels of td; encoded is not used.
+func (c RawCodec) Marshal(td *TileData, encoded []byte) []byte {
+	buf, typ := td.SerializeAppend([]byte{0})
+	buf[0] = byte(typ)
+	return buf
+}
+
+// Unmarshal deserializes the pixels written by Marshal.
+func (c RawCodec) Unmarshal(data []byte) *TileData {
+	if len(data) < 1 {
+		return nil
+	}
+	return DeserializeTileData(data[1:], tileDataType(data[0]), c.TileSize)
+}
+
+// SizeHint is the size of an RGBA tile.
+func (c RawCodec) SizeHint() int {
+	return 1 + 4*c.TileSize*c.TileSize
+}
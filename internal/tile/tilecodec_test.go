@@ -0,0 +1,89 @@
+package tile
+
+import (
+	"bytes"
+	"image/color"
+	"os"
+	"testing"
+)
+
+func TestRawCodec_Roundtrip(t *testing.T) {
+	codec := RawCodec{TileSize: 4}
+	for name, td := range map[string]*TileData{
+		"rgba": newTileData(checkerImage(4, color.RGBA{10, 20, 30, 128}, color.RGBA{200, 0, 90, 255}), 4),
+		"gray": newTileData(grayCheckerImage(4, 100, 200), 4),
+	} {
+		want := td.ToRGBA().Pix
+		data := codec.Marshal(td, nil)
+		td.Release() // the codec must have copied the pixels
+
+		got := codec.Unmarshal(data)
+		if got == nil {
+			t.Fatalf("%s: Unmarshal returned nil", name)
+		}
+		if !bytes.Equal(got.ToRGBA().Pix, want) {
+			t.Errorf("%s: pixels changed in the round trip", name)
+		}
+	}
+	if codec.Unmarshal(nil) != nil {
+		t.Error("Unmarshal(nil) != nil")
+	}
+}
+
+func TestNewTileCodec(t *testing.T) {
+	for spill, want := range map[string]TileCodec{
+		"":        EncodedCodec{Format: "png", TileSize: 256},
+		"encoded": EncodedCodec{Format: "png", TileSize: 256},
+		"raw":     RawCodec{TileSize: 256},
+	} {
+		got, err := NewTileCodec(spill, "png", 256, false, false)
+		if err != nil || got != want {
+			t.Errorf("NewTileCodec(%q) = %v, %v; want %v", spill, got, err, want)
+		}
+	}
+	if _, err := NewTileCodec("webp", "png", 256, false, false); err == nil {
+		t.Error("NewTileCodec accepted an unknown spill format")
+	}
+}
+
+func TestDiskTileStore_RawCodec(t *testing.T) {
+	// A backing file is ignored: it holds encoded bytes, not pixels.
+	backing, err := os.CreateTemp(t.TempDir(), "tiles-*.tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backing.Close()
+
+	store := NewDiskTileStore(DiskTileStoreConfig{
+		TileSize:         4,
+		TempDir:          t.TempDir(),
+		MemoryLimitBytes: 1 << 20,
+		Format:           "jpeg",
+		Codec:            RawCodec{TileSize: 4},
+		Backing:          backing,
+	})
+	defer store.Close()
+
+	// Translucent pixels survive exactly, which a JPEG spill could not do.
+	img := checkerImage(4, color.RGBA{10, 20, 30, 128}, color.RGBA{200, 0, 90, 255})
+	want := append([]byte(nil), img.Pix...)
+	for i := 0; i < 10; i++ {
+		td := newTileData(checkerImage(4, color.RGBA{10, 20, 30, 128}, color.RGBA{200, 0, 90, 255}), 4)
+		store.PutAt(6, i, 0, td, []byte("not a tile"), 0)
+		td.Release()
+	}
+	store.Drain()
+
+	if store.TempFilePath() == "" {
+		t.Fatal("raw store did not spill to a file of its own")
+	}
+	for i := 0; i < 10; i++ {
+		got := store.Get(6, i, 0)
+		if got == nil {
+			t.Fatalf("tile 6/%d/0 missing", i)
+		}
+		if !bytes.Equal(got.ToRGBA().Pix, want) {
+			t.Errorf("tile 6/%d/0: pixels changed through the spill file", i)
+		}
+	}
+}
@@ -118,16 +118,17 @@ func (t *TileData) ToRGBA() *image.RGBA {
 
 // AsImage returns an image.Image suitable for encoders. For full tiles it
 // returns the underlying *image.RGBA (so encoders can type-switch to the fast
-// path). For gray tiles it expands to RGBA and caches the result in t.img
-// so that Release() can return it to the pool. For uniform tiles it returns
-// *TileData itself (which implements image.Image via generic At()).
+// path). For gray tiles it returns the *image.Gray directly so JPEG and PNG
+// emit single-channel output (1-component JPEG, 8-bit gray PNG) without an
+// RGBA expansion; encoders that need RGB convert themselves. For uniform
+// tiles it returns *TileData itself (which implements image.Image via
+// generic At()).
 func (t *TileData) AsImage() image.Image {
 	if t.img != nil {
 		return t.img
 	}
 	if t.gray != nil {
-		t.img = t.ToRGBA()
-		return t.img
+		return t.gray
 	}
 	return t
 }
@@ -290,6 +291,18 @@ func (t *TileData) Release() {
 	}
 }
 
+// releaseChildren releases the child tiles read from a store for
+// downsampling. Store.Get decodes a fresh TileData per call for non-uniform
+// tiles, so the caller owns their images. Uniform tiles (including the
+// shared fill tile) hold no image, which makes Release a no-op for them.
+func releaseChildren(tl, tr, bl, br *TileData) {
+	for _, c := range [4]*TileData{tl, tr, bl, br} {
+		if c != nil {
+			c.Release()
+		}
+	}
+}
+
 // MemoryBytes returns the estimated heap bytes used by this tile's pixel data.
 func (t *TileData) MemoryBytes() int64 {
 	if t.img != nil {
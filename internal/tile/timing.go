@@ -0,0 +1,116 @@
+package tile
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ZoomTiming breaks down where the time for one zoom level went. Wall is
+// elapsed time for the level; the phase fields are summed over all workers,
+// so with N workers they can add up to roughly N × Wall.
+type ZoomTiming struct {
+	Zoom       int
+	Tiles      int64
+	Wall       time.Duration
+	Render     time.Duration // rendering from the sources (max zoom only)
+	Downsample time.Duration // reading children and downsampling (lower zooms)
+	Encode     time.Duration // encoding, including the debug overlay copy
+	Write      time.Duration // TileWriter.WriteTile
+	Store      time.Duration // keeping the tile for the next zoom's downsampling
+}
+
+// Timing is the per-phase timing breakdown of a run.
+type Timing struct {
+	Zooms []ZoomTiming // in processing order (max zoom first)
+	// Wall is the elapsed time of the whole generation. Zoom levels run
+	// concurrently unless Config.LevelByLevel is set, so their Wall times
+	// overlap and do not add up to it. Zero means unknown (the zoom walls
+	// are summed instead).
+	Wall time.Duration
+	// Finalize is the time spent finalizing the archive (clustering tile
+	// data, writing directories). Generate does not finalize, so callers
+	// fill this in.
+	Finalize time.Duration
+}
+
+// zoomTimer accumulates phase durations from concurrent workers.
+type zoomTimer struct {
+	render, downsample, encode, write, store atomic.Int64
+}
+
+// since adds the time elapsed since start to phase and returns now, so
+// consecutive phases can be chained without extra clock reads.
+func (zt *zoomTimer) since(phase *atomic.Int64, start time.Time) time.Time {
+	now := time.Now()
+	phase.Add(int64(now.Sub(start)))
+	return now
+}
+
+func (zt *zoomTimer) result(z int, tiles int64, wall time.Duration) ZoomTiming {
+	return ZoomTiming{
+		Zoom:       z,
+		Tiles:      tiles,
+		Wall:       wall,
+		Render:     time.Duration(zt.render.Load()),
+		Downsample: time.Duration(zt.downsample.Load()),
+		Encode:     time.Duration(zt.encode.Load()),
+		Write:      time.Duration(zt.write.Load()),
+		Store:      time.Duration(zt.store.Load()),
+	}
+}
+
+// Table formats the timing breakdown as an aligned text table: one row per
+// zoom, a total row with each phase's share of the summed worker time, and
+// the finalize time.
+func (t Timing) Table() string {
+	var b strings.Builder
+	row := func(label string, tiles, wall, render, down, enc, write, store string) {
+		fmt.Fprintf(&b, "  %-6s %8s %9s %10s %10s %10s %10s %10s\n",
+			label, tiles, wall, render, down, enc, write, store)
+	}
+	row("Zoom", "Tiles", "Wall", "Render", "Downsample", "Encode", "Write", "Store")
+
+	var total ZoomTiming
+	for _, zt := range t.Zooms {
+		row(fmt.Sprint(zt.Zoom), fmt.Sprint(zt.Tiles), fmtDuration(zt.Wall),
+			fmtDuration(zt.Render), fmtDuration(zt.Downsample), fmtDuration(zt.Encode),
+			fmtDuration(zt.Write), fmtDuration(zt.Store))
+		total.Tiles += zt.Tiles
+		total.Wall += zt.Wall
+		total.Render += zt.Render
+		total.Downsample += zt.Downsample
+		total.Encode += zt.Encode
+		total.Write += zt.Write
+		total.Store += zt.Store
+	}
+
+	if t.Wall > 0 {
+		total.Wall = t.Wall
+	}
+
+	work := total.Render + total.Downsample + total.Encode + total.Write + total.Store
+	share := func(d time.Duration) string {
+		if work <= 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.0f%%", 100*float64(d)/float64(work))
+	}
+	row("Total", fmt.Sprint(total.Tiles), fmtDuration(total.Wall),
+		fmtDuration(total.Render), fmtDuration(total.Downsample), fmtDuration(total.Encode),
+		fmtDuration(total.Write), fmtDuration(total.Store))
+	row("", "", "", share(total.Render), share(total.Downsample), share(total.Encode),
+		share(total.Write), share(total.Store))
+	fmt.Fprintf(&b, "  Finalize: %s (phase columns are summed over workers)\n", fmtDuration(t.Finalize))
+	return b.String()
+}
+
+// fmtDuration rounds d for display: milliseconds below a minute, tenths of
+// a second above.
+func fmtDuration(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
@@ -0,0 +1,57 @@
+package tile
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimingTable(t *testing.T) {
+	tm := Timing{
+		Zooms: []ZoomTiming{
+			{Zoom: 3, Tiles: 4, Wall: time.Second, Render: 3 * time.Second, Encode: time.Second},
+			{Zoom: 2, Tiles: 1, Wall: 100 * time.Millisecond, Downsample: 0, Encode: 0},
+		},
+		Finalize: 250 * time.Millisecond,
+	}
+	out := tm.Table()
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	if len(lines) != 6 { // header, 2 zooms, total, shares, finalize
+		t.Fatalf("got %d lines:\n%s", len(lines), out)
+	}
+	for _, want := range []string{"Render", "1.1s", "75%", "25%", "Finalize: 250ms"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("table missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestTimingTable_OverlappingZooms(t *testing.T) {
+	// Pipelined zooms overlap, so the total wall time is the run's, not
+	// the sum of the zoom walls.
+	tm := Timing{
+		Zooms: []ZoomTiming{
+			{Zoom: 3, Tiles: 4, Wall: time.Second},
+			{Zoom: 2, Tiles: 1, Wall: 900 * time.Millisecond},
+		},
+		Wall: 1200 * time.Millisecond,
+	}
+	out := tm.Table()
+	if !strings.Contains(out, "1.2s") || strings.Contains(out, "1.9s") {
+		t.Errorf("total wall should be the run's 1.2s:\n%s", out)
+	}
+}
+
+func TestZoomTimer(t *testing.T) {
+	var zt zoomTimer
+	start := time.Now().Add(-time.Millisecond)
+	next := zt.since(&zt.render, start)
+	zt.since(&zt.encode, next)
+	got := zt.result(5, 1, time.Millisecond)
+	if got.Render < time.Millisecond || got.Zoom != 5 || got.Tiles != 1 {
+		t.Errorf("zoomTimer result = %+v", got)
+	}
+	if got.Downsample != 0 || got.Write != 0 {
+		t.Errorf("untouched phases = %v, %v, want 0", got.Downsample, got.Write)
+	}
+}
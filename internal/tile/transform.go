@@ -1,17 +1,19 @@
 package tile
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
 	"log"
 	"sync"
 	"sync/atomic"
 
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
 	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
 	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
 	"github.com/pspoerri/geotiff2pmtiles/internal/pmtiles"
+	"github.com/pspoerri/geotiff2pmtiles/tileorder"
 )
 
 // TransformMode selects the processing strategy.
@@ -25,6 +27,10 @@ const (
 	// TransformRebuild decodes max-zoom tiles and rebuilds the entire
 	// pyramid via downsampling (resampling change or adding lower zooms).
 	TransformRebuild
+	// TransformExtend copies all existing tiles verbatim and generates only
+	// the zoom levels below the source min zoom, downsampled from the
+	// source's min-zoom tiles. Requires an unchanged tile format.
+	TransformExtend
 )
 
 // TransformConfig holds configuration for the PMTiles transform pipeline.
@@ -43,25 +49,113 @@ type TransformConfig struct {
 	Bounds           [4]float32 // MinLon, MinLat, MaxLon, MaxLat
 	MemoryLimitBytes int64
 	OutputDir        string
+	Dither           bool // ordered-dither 16-bit source tiles to 8 bits instead of rounding
+	// SourcePremultiplied decodes the input tiles as storing premultiplied
+	// colors (the source's "premultiplied_alpha" metadata).
+	SourcePremultiplied bool
+	// PremultiplyAlpha writes the output tiles with premultiplied colors
+	// (encode.Premultiply). Passthrough and extend copy tiles verbatim,
+	// so they require it to match SourcePremultiplied.
+	PremultiplyAlpha bool
 }
 
-// PMTilesReader is the interface for reading tiles from a PMTiles archive.
+// decodeSource decodes an input tile of SourceFormat, taking its colors
+// as premultiplied when SourcePremultiplied is set.
+func (c *TransformConfig) decodeSource(data []byte) (image.Image, error) {
+	img, err := encode.DecodeImage(data, c.SourceFormat)
+	if err != nil {
+		return nil, err
+	}
+	if c.SourcePremultiplied {
+		img = encode.FromPremultiplied(img)
+	}
+	return img, nil
+}
+
+// PMTilesReader is the interface for reading tiles from a PMTiles archive,
+// such as *pmtiles.Reader. Tiles are enumerated with a callback rather than
+// returned as a list, which at z18 for a country would take hundreds of MB
+// per zoom. Implementations must be safe for concurrent use: ReadTile is
+// called from several workers while ForEachTileAtZoom is walking.
 type PMTilesReader interface {
 	ReadTile(z, x, y int) ([]byte, error)
-	TilesAtZoom(z int) [][3]int
+	HasTile(z, x, y int) bool
+	TileCountAtZoom(z int) int
+	// ForEachTileAtZoom calls fn for every tile of zoom z in tileID order;
+	// an error from fn stops the walk and is returned.
+	ForEachTileAtZoom(z int, fn func(x, y int) error) error
 	Header() pmtiles.Header
 }
 
+// errStopFeed ends a ForEachTileAtZoom walk whose consumers have stopped.
+var errStopFeed = errors.New("tile feed stopped")
+
+// feedTiles sends the tiles of zoom z to the returned channel from a
+// goroutine, as the reader enumerates them. Closing stop ends the walk
+// early, e.g. after the workers failed; the channel is closed either way.
+func feedTiles(reader PMTilesReader, z, buffer int, stop <-chan struct{}) <-chan [3]int {
+	ch := make(chan [3]int, buffer)
+	go func() {
+		defer close(ch)
+		reader.ForEachTileAtZoom(z, func(x, y int) error {
+			select {
+			case ch <- [3]int{z, x, y}:
+				return nil
+			case <-stop:
+				return errStopFeed
+			}
+		})
+	}()
+	return ch
+}
+
+// TileStreamer is implemented by readers that can deliver the tiles of a
+// zoom range in one sequential pass, such as *pmtiles.Reader. data is only
+// valid during fn; an error returned by fn stops the walk.
+type TileStreamer interface {
+	StreamZooms(minZoom, maxZoom int, fn func(z, x, y int, data []byte) error) error
+}
+
+// TileRefStreamer is a TileStreamer that also passes the offset of each
+// tile's data in the archive, such as *pmtiles.Reader. Tiles that share
+// data share an offset.
+type TileRefStreamer interface {
+	StreamZoomRefs(minZoom, maxZoom int, fn func(z, x, y int, offset uint64, data []byte) error) error
+}
+
+// TileCopier is implemented by writers that can keep the data sharing of a
+// streamed archive without hashing the tiles, such as *pmtiles.Writer.
+type TileCopier interface {
+	CopyTile(z, x, y int, offset uint64, data []byte) error
+}
+
 // Transform reads tiles from an existing PMTiles archive, applies the
 // configured transformations, and writes the result via the TileWriter.
 func Transform(cfg TransformConfig, reader PMTilesReader, writer TileWriter) (Stats, error) {
+	if cfg.PremultiplyAlpha != cfg.SourcePremultiplied && (cfg.Mode == TransformPassthrough || cfg.Mode == TransformExtend) {
+		return Stats{}, fmt.Errorf("tiles are copied verbatim, so alpha premultiplication must stay %v (got %v)", cfg.SourcePremultiplied, cfg.PremultiplyAlpha)
+	}
+	if cfg.PremultiplyAlpha {
+		cfg.Encoder = encode.Premultiply(cfg.Encoder)
+	}
 	switch cfg.Mode {
 	case TransformPassthrough:
 		return transformPassthrough(cfg, reader, writer)
 	case TransformReencode:
 		return transformReencode(cfg, reader, writer)
 	case TransformRebuild:
+		if err := ValidateTileSize(cfg.TileSize); err != nil {
+			return Stats{}, err
+		}
 		return transformRebuild(cfg, reader, writer)
+	case TransformExtend:
+		if err := ValidateTileSize(cfg.TileSize); err != nil {
+			return Stats{}, err
+		}
+		if f := cfg.Encoder.Format(); f != cfg.SourceFormat {
+			return Stats{}, fmt.Errorf("extend copies tiles verbatim, so the format must stay %s (got %s)", cfg.SourceFormat, f)
+		}
+		return transformExtend(cfg, reader, writer)
 	default:
 		return Stats{}, fmt.Errorf("unknown transform mode: %d", cfg.Mode)
 	}
@@ -71,13 +165,87 @@ func Transform(cfg TransformConfig, reader PMTilesReader, writer TileWriter) (St
 func transformPassthrough(cfg TransformConfig, reader PMTilesReader, writer TileWriter) (Stats, error) {
 	var tileCount, emptyCount, totalBytes atomic.Int64
 
+	var err error
+	if streamer, ok := reader.(TileStreamer); ok {
+		err = streamPassthrough(cfg, reader, streamer, writer, &tileCount, &emptyCount, &totalBytes)
+	} else {
+		err = readPassthrough(cfg, reader, writer, &tileCount, &emptyCount, &totalBytes)
+	}
+	if err != nil {
+		return Stats{}, err
+	}
+
+	// Fill empty tiles if requested.
+	if cfg.FillColor != nil {
+		fc, err := fillEmptyTiles(cfg, reader, writer)
+		if err != nil {
+			return Stats{}, err
+		}
+		tileCount.Add(fc.TileCount)
+		totalBytes.Add(fc.TotalBytes)
+	}
+
+	return Stats{
+		TileCount:  tileCount.Load(),
+		EmptyTiles: emptyCount.Load(),
+		TotalBytes: totalBytes.Load(),
+	}, nil
+}
+
+// streamPassthrough copies the tiles of the zoom range in one sequential
+// pass over the archive's tile data.
+func streamPassthrough(cfg TransformConfig, reader PMTilesReader, streamer TileStreamer, writer TileWriter, tileCount, emptyCount, totalBytes *atomic.Int64) error {
+	var total int
+	for z := cfg.MinZoom; z <= cfg.MaxZoom; z++ {
+		total += reader.TileCountAtZoom(z)
+	}
+	pb := newProgressBar(fmt.Sprintf("Zoom %d-%d", cfg.MinZoom, cfg.MaxZoom), int64(total))
+	defer pb.Finish()
+
+	// With offsets, a copying writer keeps the source's shared tile data
+	// instead of hashing every tile again.
+	refs, _ := streamer.(TileRefStreamer)
+	copier, _ := writer.(TileCopier)
+	if refs == nil || copier == nil {
+		return streamer.StreamZooms(cfg.MinZoom, cfg.MaxZoom, func(z, x, y int, data []byte) error {
+			defer pb.Increment()
+			if len(data) == 0 {
+				emptyCount.Add(1)
+				return nil
+			}
+			if err := writer.WriteTile(z, x, y, data); err != nil {
+				return fmt.Errorf("writing tile z%d/%d/%d: %w", z, x, y, err)
+			}
+			tileCount.Add(1)
+			totalBytes.Add(int64(len(data)))
+			return nil
+		})
+	}
+	return refs.StreamZoomRefs(cfg.MinZoom, cfg.MaxZoom, func(z, x, y int, offset uint64, data []byte) error {
+		defer pb.Increment()
+		if len(data) == 0 {
+			emptyCount.Add(1)
+			return nil
+		}
+		if err := copier.CopyTile(z, x, y, offset, data); err != nil {
+			return fmt.Errorf("writing tile z%d/%d/%d: %w", z, x, y, err)
+		}
+		tileCount.Add(1)
+		totalBytes.Add(int64(len(data)))
+		return nil
+	})
+}
+
+// readPassthrough copies the tiles zoom by zoom with ReadTile, spread over
+// cfg.Concurrency workers.
+func readPassthrough(cfg TransformConfig, reader PMTilesReader, writer TileWriter, tileCount, emptyCount, totalBytes *atomic.Int64) error {
 	for z := cfg.MaxZoom; z >= cfg.MinZoom; z-- {
-		tiles := reader.TilesAtZoom(z)
-		pb := newProgressBar(fmt.Sprintf("Zoom %2d", z), int64(len(tiles)))
+		nTiles := reader.TileCountAtZoom(z)
+		pb := newProgressBar(fmt.Sprintf("Zoom %2d", z), int64(nTiles))
 
 		nWorkers := cfg.Concurrency
-		if nWorkers > len(tiles) {
-			nWorkers = len(tiles)
+		if nWorkers > nTiles {
+			nWorkers = nTiles
 		}
 		if nWorkers < 1 {
 			nWorkers = 1
@@ -85,14 +253,8 @@ func transformPassthrough(cfg TransformConfig, reader PMTilesReader, writer Tile
 
 		var wg sync.WaitGroup
 		errCh := make(chan error, nWorkers)
-		tileCh := make(chan [3]int, nWorkers*2)
-
-		go func() {
-			for _, t := range tiles {
-				tileCh <- t
-			}
-			close(tileCh)
-		}()
+		stop := make(chan struct{})
+		tileCh := feedTiles(reader, z, nWorkers*2, stop)
 
 		for w := 0; w < nWorkers; w++ {
 			wg.Add(1)
@@ -130,30 +292,16 @@ func transformPassthrough(cfg TransformConfig, reader PMTilesReader, writer Tile
 		}
 
 		wg.Wait()
+		close(stop) // ends the feed if the workers stopped early
 		pb.Finish()
 
 		select {
 		case err := <-errCh:
-			return Stats{}, err
+			return err
 		default:
 		}
 	}
-
-	// Fill empty tiles if requested.
-	if cfg.FillColor != nil {
-		fc, err := fillEmptyTiles(cfg, reader, writer)
-		if err != nil {
-			return Stats{}, err
-		}
-		tileCount.Add(fc.TileCount)
-		totalBytes.Add(fc.TotalBytes)
-	}
-
-	return Stats{
-		TileCount:  tileCount.Load(),
-		EmptyTiles: emptyCount.Load(),
-		TotalBytes: totalBytes.Load(),
-	}, nil
+	return nil
 }
 
 // transformReencode decodes each tile and re-encodes in the target format.
@@ -161,12 +309,12 @@ func transformReencode(cfg TransformConfig, reader PMTilesReader, writer TileWri
 	var tileCount, emptyCount, uniformCount, totalBytes atomic.Int64
 
 	for z := cfg.MaxZoom; z >= cfg.MinZoom; z-- {
-		tiles := reader.TilesAtZoom(z)
-		pb := newProgressBar(fmt.Sprintf("Zoom %2d", z), int64(len(tiles)))
+		nTiles := reader.TileCountAtZoom(z)
+		pb := newProgressBar(fmt.Sprintf("Zoom %2d", z), int64(nTiles))
 
 		nWorkers := cfg.Concurrency
-		if nWorkers > len(tiles) {
-			nWorkers = len(tiles)
+		if nWorkers > nTiles {
+			nWorkers = nTiles
 		}
 		if nWorkers < 1 {
 			nWorkers = 1
@@ -174,14 +322,8 @@ func transformReencode(cfg TransformConfig, reader PMTilesReader, writer TileWri
 
 		var wg sync.WaitGroup
 		errCh := make(chan error, nWorkers)
-		tileCh := make(chan [3]int, nWorkers*2)
-
-		go func() {
-			for _, t := range tiles {
-				tileCh <- t
-			}
-			close(tileCh)
-		}()
+		stop := make(chan struct{})
+		tileCh := feedTiles(reader, z, nWorkers*2, stop)
 
 		for w := 0; w < nWorkers; w++ {
 			wg.Add(1)
@@ -203,7 +345,7 @@ func transformReencode(cfg TransformConfig, reader PMTilesReader, writer TileWri
 						continue
 					}
 
-					img, err := encode.DecodeImage(rawData, cfg.SourceFormat)
+					img, err := cfg.decodeSource(rawData)
 					if err != nil {
 						select {
 						case errCh <- fmt.Errorf("decoding tile z%d/%d/%d: %w", z, x, y, err):
@@ -212,7 +354,7 @@ func transformReencode(cfg TransformConfig, reader PMTilesReader, writer TileWri
 						return
 					}
 
-					rgba := imageToRGBA(img)
+					rgba := imageToRGBA(img, cfg.Dither)
 					td := newTileData(rgba, cfg.TileSize)
 					if td.IsUniform() {
 						uniformCount.Add(1)
@@ -244,6 +386,7 @@ func transformReencode(cfg TransformConfig, reader PMTilesReader, writer TileWri
 		}
 
 		wg.Wait()
+		close(stop) // ends the feed if the workers stopped early
 		pb.Finish()
 
 		select {
@@ -270,18 +413,55 @@ func transformReencode(cfg TransformConfig, reader PMTilesReader, writer TileWri
 	}, nil
 }
 
+// transformExtend copies the source's zoom levels within the target range
+// verbatim, then downsamples the levels below the source min zoom from the
+// source's min-zoom tiles.
+func transformExtend(cfg TransformConfig, reader PMTilesReader, writer TileWriter) (Stats, error) {
+	srcMinZoom := int(reader.Header().MinZoom)
+
+	copyCfg := cfg
+	if copyCfg.MinZoom < srcMinZoom {
+		copyCfg.MinZoom = srcMinZoom
+	}
+	stats, err := transformPassthrough(copyCfg, reader, writer)
+	if err != nil {
+		return Stats{}, err
+	}
+	if cfg.MinZoom >= srcMinZoom {
+		return stats, nil
+	}
+
+	low, err := transformRebuild(cfg, reader, writer)
+	if err != nil {
+		return Stats{}, err
+	}
+	stats.TileCount += low.TileCount
+	stats.EmptyTiles += low.EmptyTiles
+	stats.UniformTiles += low.UniformTiles
+	stats.TotalBytes += low.TotalBytes
+	return stats, nil
+}
+
 // transformRebuild reads max-zoom tiles, then rebuilds the entire pyramid
 // from the top down using the specified resampling method.
+//
+// In TransformExtend mode the pyramid starts at the source min zoom instead.
+// Those tiles were already copied by transformExtend, so they are decoded
+// only to seed the store and are not written again.
 func transformRebuild(cfg TransformConfig, reader PMTilesReader, writer TileWriter) (Stats, error) {
 	srcHeader := reader.Header()
 	srcMaxZoom := int(srcHeader.MaxZoom)
+	seedOnly := cfg.Mode == TransformExtend
+	if seedOnly {
+		srcMaxZoom = int(srcHeader.MinZoom)
+	}
 
 	// The effective max zoom is the minimum of source and target max zoom,
 	// since we can't create detail that doesn't exist.
 	effectiveMaxZoom := cfg.MaxZoom
 	if effectiveMaxZoom > srcMaxZoom {
 		effectiveMaxZoom = srcMaxZoom
-		if cfg.Verbose {
+		if cfg.Verbose && !seedOnly {
 			log.Printf("Target max zoom %d exceeds source max zoom %d, clamping to %d",
 				cfg.MaxZoom, srcMaxZoom, effectiveMaxZoom)
 		}
@@ -307,11 +487,11 @@ func transformRebuild(cfg TransformConfig, reader PMTilesReader, writer TileWrit
 	// all positions from bounds.
 	var sourceTilesAtMax map[[2]int]bool
 	if cfg.FillColor != nil {
-		srcTiles := reader.TilesAtZoom(effectiveMaxZoom)
-		sourceTilesAtMax = make(map[[2]int]bool, len(srcTiles))
-		for _, t := range srcTiles {
-			sourceTilesAtMax[[2]int{t[1], t[2]}] = true
-		}
+		sourceTilesAtMax = make(map[[2]int]bool, reader.TileCountAtZoom(effectiveMaxZoom))
+		reader.ForEachTileAtZoom(effectiveMaxZoom, func(x, y int) error {
+			sourceTilesAtMax[[2]int{x, y}] = true
+			return nil
+		})
 	}
 
 	// Pre-encode the fill tile once so identical fill tiles across all
@@ -346,7 +526,11 @@ func transformRebuild(cfg TransformConfig, reader PMTilesReader, writer TileWrit
 
 		if isMaxZoom && cfg.FillColor == nil {
 			// No fill — only process existing source tiles.
-			realTiles = reader.TilesAtZoom(z)
+			realTiles = make([][3]int, 0, reader.TileCountAtZoom(z))
+			reader.ForEachTileAtZoom(z, func(x, y int) error {
+				realTiles = append(realTiles, [3]int{z, x, y})
+				return nil
+			})
 		} else if cfg.FillColor != nil {
 			allTiles := coord.TilesInBounds(z,
 				float64(cfg.Bounds[0]), float64(cfg.Bounds[1]),
@@ -426,7 +610,7 @@ func transformRebuild(cfg TransformConfig, reader PMTilesReader, writer TileWrit
 			continue
 		}
 
-		coord.SortTilesByHilbert(realTiles)
+		tileorder.Sort(realTiles, tileorder.Hilbert)
 
 		nextStore := NewDiskTileStore(DiskTileStoreConfig{
 			InitialCapacity:  len(realTiles),
@@ -434,6 +618,8 @@ func transformRebuild(cfg TransformConfig, reader PMTilesReader, writer TileWrit
 			TempDir:          cfg.OutputDir,
 			MemoryLimitBytes: memLimit,
 			Format:           cfg.Encoder.Format(),
+			Dither:           cfg.Dither,
+			Premultiplied:    cfg.PremultiplyAlpha,
 			Verbose:          cfg.Verbose,
 		})
 
@@ -482,6 +668,23 @@ func transformRebuild(cfg TransformConfig, reader PMTilesReader, writer TileWrit
 							hasSource := sourceTilesAtMax == nil || sourceTilesAtMax[[2]int{x, y}]
 							if hasSource {
 								rawData, err := reader.ReadTile(z, x, y)
+								if err == nil && rawData != nil && seedOnly {
+									// Already copied verbatim: keep the
+									// source bytes for the parents only.
+									img, err := cfg.decodeSource(rawData)
+									if err != nil {
+										select {
+										case errCh <- fmt.Errorf("decoding tile z%d/%d/%d: %w", z, x, y, err):
+										default:
+										}
+										return
+									}
+									td = newTileData(imageToRGBA(img, cfg.Dither), cfg.TileSize)
+									nextStore.Put(z, x, y, td, rawData)
+									td.Release()
+									pb.Increment()
+									continue
+								}
 								if err != nil {
 									select {
 									case errCh <- fmt.Errorf("reading tile z%d/%d/%d: %w", z, x, y, err):
@@ -490,7 +693,7 @@ func transformRebuild(cfg TransformConfig, reader PMTilesReader, writer TileWrit
 									return
 								}
 								if rawData != nil {
-									img, err := encode.DecodeImage(rawData, cfg.SourceFormat)
+									img, err := cfg.decodeSource(rawData)
 									if err != nil {
 										select {
 										case errCh <- fmt.Errorf("decoding tile z%d/%d/%d: %w", z, x, y, err):
@@ -498,7 +701,7 @@ func transformRebuild(cfg TransformConfig, reader PMTilesReader, writer TileWrit
 										}
 										return
 									}
-									rgba := imageToRGBA(img)
+									rgba := imageToRGBA(img, cfg.Dither)
 									if cfg.FillColor != nil {
 										applyFillColorTransform(rgba, *cfg.FillColor)
 									}
@@ -514,6 +717,13 @@ func transformRebuild(cfg TransformConfig, reader PMTilesReader, writer TileWrit
 							tr := store.Get(childZ, 2*x+1, 2*y)
 							bl := store.Get(childZ, 2*x, 2*y+1)
 							br := store.Get(childZ, 2*x+1, 2*y+1)
+							if err := store.Err(); err != nil {
+								select {
+								case errCh <- fmt.Errorf("downsampling tile z%d/%d/%d: %w", z, x, y, err):
+								default:
+								}
+								return
+							}
 							// Substitute nil children with the shared fill tile
 							// so downsample operates on 4 tiles.
 							if fillTileShared != nil {
@@ -531,6 +741,7 @@ func transformRebuild(cfg TransformConfig, reader PMTilesReader, writer TileWrit
 								}
 							}
 							td = downsampleTile(tl, tr, bl, br, cfg.TileSize, cfg.Resampling)
+							releaseChildren(tl, tr, bl, br)
 						}
 
 						if td == nil {
@@ -621,6 +832,10 @@ func transformRebuild(cfg TransformConfig, reader PMTilesReader, writer TileWrit
 // fillEmptyTiles generates tiles for positions within the bounds that are
 // missing from the source archive, filling them with the configured solid color.
 // Used by passthrough and reencode modes where tiles are copied from the source.
+// Every position gets the same encoded bytes, which the writer stores once
+// (see pmtiles.Writer.WriteTile), so the cost is the walk: each zoom's rows
+// are spread over cfg.Concurrency workers, and zooms the archive already
+// covers completely are not walked at all.
 func fillEmptyTiles(cfg TransformConfig, reader PMTilesReader, writer TileWriter) (Stats, error) {
 	if cfg.FillColor == nil {
 		return Stats{}, nil
@@ -641,51 +856,121 @@ func fillEmptyTiles(cfg TransformConfig, reader PMTilesReader, writer TileWriter
 		return Stats{}, fmt.Errorf("encoding fill tile: %w", err)
 	}
 
-	var tileCount, totalBytes atomic.Int64
-
+	b := cog.Bounds{
+		MinLon: float64(cfg.Bounds[0]), MinLat: float64(cfg.Bounds[1]),
+		MaxLon: float64(cfg.Bounds[2]), MaxLat: float64(cfg.Bounds[3]),
+	}
+	var tileCount int64
 	for z := cfg.MaxZoom; z >= cfg.MinZoom; z-- {
-		allTiles := coord.TilesInBounds(z,
-			float64(cfg.Bounds[0]), float64(cfg.Bounds[1]),
-			float64(cfg.Bounds[2]), float64(cfg.Bounds[3]))
-
-		existingTiles := reader.TilesAtZoom(z)
-		existing := make(map[[2]int]bool, len(existingTiles))
-		for _, t := range existingTiles {
-			existing[[2]int{t[1], t[2]}] = true
+		ranges := boundsTileRanges(z, b)
+		if archiveCoversRanges(reader, z, ranges) {
+			continue
 		}
-
-		var fillCount int
-		for _, t := range allTiles {
-			x, y := t[1], t[2]
-			if existing[[2]int{x, y}] {
-				continue
-			}
-			if err := writer.WriteTile(z, x, y, fillData); err != nil {
-				return Stats{}, fmt.Errorf("writing fill tile z%d/%d/%d: %w", z, x, y, err)
-			}
-			fillCount++
+		fillCount, err := fillZoom(cfg, reader, writer, z, ranges, fillData)
+		if err != nil {
+			return Stats{}, err
 		}
-
 		if fillCount > 0 && cfg.Verbose {
 			log.Printf("Zoom %d: filled %d empty tile(s)", z, fillCount)
 		}
-		tileCount.Add(int64(fillCount))
-		totalBytes.Add(int64(fillCount) * int64(len(fillData)))
+		tileCount += fillCount
 	}
 
 	return Stats{
-		TileCount:  tileCount.Load(),
-		TotalBytes: totalBytes.Load(),
+		TileCount:  tileCount,
+		TotalBytes: tileCount * int64(len(fillData)),
 	}, nil
 }
 
-// imageToRGBA converts an image.Image to *image.RGBA.
-func imageToRGBA(img image.Image) *image.RGBA {
-	if rgba, ok := img.(*image.RGBA); ok {
-		return rgba
+// archiveCoversRanges reports whether the archive has every tile of zoom z
+// in ranges (see boundsTileRanges). Only the archive's own tiles are
+// walked, and only when there are at least as many as the ranges hold;
+// fewer means some position is missing. Bounds across the antimeridian,
+// whose ranges can share tiles, are never reported as covered.
+func archiveCoversRanges(reader PMTilesReader, z int, ranges [][4]int) bool {
+	if len(ranges) != 1 {
+		return false
+	}
+	r := ranges[0]
+	want := int64(r[2]-r[0]+1) * int64(r[3]-r[1]+1)
+	if int64(reader.TileCountAtZoom(z)) < want {
+		return false
+	}
+	var inside int64
+	reader.ForEachTileAtZoom(z, func(x, y int) error {
+		if x >= r[0] && x <= r[2] && y >= r[1] && y <= r[3] {
+			inside++
+		}
+		return nil
+	})
+	return inside == want
+}
+
+// fillZoom writes fillData to every position of zoom z in ranges that the
+// archive lacks, looking each one up in the archive index. Rows are taken
+// by cfg.Concurrency workers in turn. Tiles of the second range that the
+// first also holds (bounds across the antimeridian) are skipped, as
+// coord.ForEachTileInBounds does. Returns the number of tiles written.
+func fillZoom(cfg TransformConfig, reader PMTilesReader, writer TileWriter, z int, ranges [][4]int, fillData []byte) (int64, error) {
+	type row struct{ r, y int }
+	var rows []row
+	for i, r := range ranges {
+		for y := r[1]; y <= r[3]; y++ {
+			rows = append(rows, row{i, y})
+		}
+	}
+
+	nWorkers := cfg.Concurrency
+	if nWorkers > len(rows) {
+		nWorkers = len(rows)
+	}
+	if nWorkers < 1 {
+		nWorkers = 1
+	}
+
+	var next, filled atomic.Int64
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	errCh := make(chan error, 1)
+	for w := 0; w < nWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !failed.Load() {
+				i := int(next.Add(1) - 1)
+				if i >= len(rows) {
+					return
+				}
+				rw := rows[i]
+				r, y := ranges[rw.r], rw.y
+				for x := r[0]; x <= r[2]; x++ {
+					if rw.r > 0 {
+						if f := ranges[0]; x >= f[0] && x <= f[2] && y >= f[1] && y <= f[3] {
+							continue
+						}
+					}
+					if reader.HasTile(z, x, y) {
+						continue
+					}
+					if err := writer.WriteTile(z, x, y, fillData); err != nil {
+						failed.Store(true)
+						select {
+						case errCh <- fmt.Errorf("writing fill tile z%d/%d/%d: %w", z, x, y, err):
+						default:
+						}
+						return
+					}
+					filled.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case err := <-errCh:
+		return 0, err
+	default:
 	}
-	bounds := img.Bounds()
-	rgba := GetRGBA(bounds.Dx(), bounds.Dy())
-	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
-	return rgba
+	return filled.Load(), nil
 }
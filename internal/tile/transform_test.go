@@ -2,8 +2,11 @@ package tile
 
 import (
 	"bytes"
+	"fmt"
 	"image"
 	"image/color"
+	"path/filepath"
+	"sort"
 	"sync"
 	"testing"
 
@@ -23,6 +26,25 @@ func (r *mockPMTilesReader) ReadTile(z, x, y int) ([]byte, error) {
 	return r.tiles[[3]int{z, x, y}], nil
 }
 
+func (r *mockPMTilesReader) HasTile(z, x, y int) bool {
+	_, ok := r.tiles[[3]int{z, x, y}]
+	return ok
+}
+
+func (r *mockPMTilesReader) TileCountAtZoom(z int) int {
+	return len(r.TilesAtZoom(z))
+}
+
+func (r *mockPMTilesReader) ForEachTileAtZoom(z int, fn func(x, y int) error) error {
+	for _, t := range r.TilesAtZoom(z) {
+		if err := fn(t[1], t[2]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TilesAtZoom lists the tiles of zoom z in tileID order.
 func (r *mockPMTilesReader) TilesAtZoom(z int) [][3]int {
 	var result [][3]int
 	for k := range r.tiles {
@@ -30,6 +52,9 @@ func (r *mockPMTilesReader) TilesAtZoom(z int) [][3]int {
 			result = append(result, k)
 		}
 	}
+	sort.Slice(result, func(i, j int) bool {
+		return pmtiles.ZXYToTileID(z, result[i][1], result[i][2]) < pmtiles.ZXYToTileID(z, result[j][1], result[j][2])
+	})
 	return result
 }
 
@@ -90,7 +115,8 @@ func encodePNGTile(t *testing.T, tileSize int, c color.RGBA) []byte {
 
 // --- Test setup ---
 //
-// Bounds (0, 0, 90, 45) produce these tile positions:
+// Bounds (0, -40, 135, 45) produce these tile positions (the bounds are
+// kept off tile edges so no touching-only tiles are involved):
 //   Zoom 2: (2,2,1), (2,3,1), (2,2,2), (2,3,2)  — 4 tiles
 //   Zoom 1: (1,1,0), (1,1,1)                      — 2 tiles
 //   Zoom 0: (0,0,0)                                — 1 tile
@@ -100,7 +126,7 @@ func encodePNGTile(t *testing.T, tileSize int, c color.RGBA) []byte {
 //   (2,2,2) and (2,3,2) → parent (1,1,1)
 
 func testBounds() [4]float32 {
-	return [4]float32{0, 0, 90, 45}
+	return [4]float32{0, -40, 135, 45}
 }
 
 func testEncoder(t *testing.T) encode.Encoder {
@@ -459,3 +485,288 @@ func TestTransformRebuild_FillColor_StatsConsistency(t *testing.T) {
 		t.Errorf("EmptyTiles = %d, want 0 (fill should cover all positions)", stats.EmptyTiles)
 	}
 }
+
+// --- Transform extend tests ---
+
+// TestTransformExtend_CopiesExistingLevels verifies that lowering the min
+// zoom copies the source levels byte for byte and only downsamples the new
+// levels from the source min zoom.
+func TestTransformExtend_CopiesExistingLevels(t *testing.T) {
+	tileSize := 8
+	bounds := testBounds()
+
+	tiles := map[[3]int][]byte{
+		{2, 2, 1}: encodePNGTile(t, tileSize, color.RGBA{200, 0, 0, 255}),
+		{2, 3, 1}: encodePNGTile(t, tileSize, color.RGBA{0, 200, 0, 255}),
+		{2, 2, 2}: encodePNGTile(t, tileSize, color.RGBA{0, 0, 200, 255}),
+		// Deliberately not the downsample of the z2 tiles: it must be
+		// copied, not rebuilt.
+		{1, 1, 0}: encodePNGTile(t, tileSize, color.RGBA{9, 9, 9, 255}),
+		{1, 1, 1}: encodePNGTile(t, tileSize, color.RGBA{0, 0, 200, 255}),
+	}
+	reader := &mockPMTilesReader{
+		tiles: tiles,
+		header: pmtiles.Header{MinZoom: 1, MaxZoom: 2,
+			MinLon: bounds[0], MinLat: bounds[1], MaxLon: bounds[2], MaxLat: bounds[3]},
+	}
+	writer := newMockTileWriter()
+
+	cfg := TransformConfig{
+		MinZoom:      0,
+		MaxZoom:      2,
+		TileSize:     tileSize,
+		Concurrency:  2,
+		Encoder:      testEncoder(t),
+		SourceFormat: "png",
+		Resampling:   ResamplingNearest,
+		Mode:         TransformExtend,
+		Bounds:       bounds,
+	}
+
+	stats, err := Transform(cfg, reader, writer)
+	if err != nil {
+		t.Fatalf("Transform: %v", err)
+	}
+
+	for k, want := range tiles {
+		if got := writer.tiles[k]; !bytes.Equal(got, want) {
+			t.Errorf("tile %v not copied verbatim", k)
+		}
+	}
+	if n := writer.tileCountAtZoom(0); n != 1 {
+		t.Fatalf("zoom 0: got %d tiles, want 1", n)
+	}
+	if stats.TileCount != int64(len(tiles))+1 {
+		t.Errorf("TileCount = %d, want %d", stats.TileCount, len(tiles)+1)
+	}
+
+	// z0 is downsampled from the source's z1 tiles: (1,1,0) lands in the
+	// top-right quadrant.
+	img, err := encode.DecodeImage(writer.tiles[[3]int{0, 0, 0}], "png")
+	if err != nil {
+		t.Fatalf("decode z0: %v", err)
+	}
+	r, g, b, a := img.At(tileSize*3/4, tileSize/4).RGBA()
+	if r>>8 != 9 || g>>8 != 9 || b>>8 != 9 || a>>8 != 255 {
+		t.Errorf("z0 top-right = rgba(%d,%d,%d,%d), want rgba(9,9,9,255)", r>>8, g>>8, b>>8, a>>8)
+	}
+}
+
+// TestTransformExtend_NoLowerZooms verifies that extend without a lower
+// min zoom is a plain copy.
+func TestTransformExtend_NoLowerZooms(t *testing.T) {
+	tileSize := 8
+	bounds := testBounds()
+	reader := &mockPMTilesReader{
+		tiles: map[[3]int][]byte{
+			{2, 2, 1}: encodePNGTile(t, tileSize, color.RGBA{200, 0, 0, 255}),
+		},
+		header: pmtiles.Header{MinZoom: 2, MaxZoom: 2,
+			MinLon: bounds[0], MinLat: bounds[1], MaxLon: bounds[2], MaxLat: bounds[3]},
+	}
+	writer := newMockTileWriter()
+
+	_, err := Transform(TransformConfig{
+		MinZoom: 2, MaxZoom: 2, TileSize: tileSize, Concurrency: 1,
+		Encoder: testEncoder(t), SourceFormat: "png", Mode: TransformExtend, Bounds: bounds,
+	}, reader, writer)
+	if err != nil {
+		t.Fatalf("Transform: %v", err)
+	}
+	if len(writer.tiles) != 1 {
+		t.Errorf("got %d tiles, want 1", len(writer.tiles))
+	}
+}
+
+// TestTransformExtend_RejectsFormatChange verifies that extend refuses to
+// mix verbatim copies with a different target format.
+func TestTransformExtend_RejectsFormatChange(t *testing.T) {
+	enc, err := encode.NewEncoder("jpeg", 85)
+	if err != nil {
+		t.Fatalf("NewEncoder: %v", err)
+	}
+	reader := &mockPMTilesReader{tiles: map[[3]int][]byte{}, header: pmtiles.Header{MinZoom: 1, MaxZoom: 2}}
+	_, err = Transform(TransformConfig{
+		MinZoom: 0, MaxZoom: 2, TileSize: 8, Concurrency: 1,
+		Encoder: enc, SourceFormat: "png", Mode: TransformExtend,
+	}, reader, newMockTileWriter())
+	if err == nil {
+		t.Fatal("expected an error for a format change in extend mode")
+	}
+}
+
+// mockStreamingReader adds StreamZooms to mockPMTilesReader and counts the
+// streamed tiles.
+type mockStreamingReader struct {
+	*mockPMTilesReader
+	streamed int
+}
+
+func (r *mockStreamingReader) StreamZooms(minZoom, maxZoom int, fn func(z, x, y int, data []byte) error) error {
+	for z := minZoom; z <= maxZoom; z++ {
+		for _, t := range r.TilesAtZoom(z) {
+			r.streamed++
+			if err := fn(t[0], t[1], t[2], r.tiles[t]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TestTransformPassthrough_Stream verifies that a reader implementing
+// TileStreamer is copied through StreamZooms with the same result as the
+// per-tile path.
+func TestTransformPassthrough_Stream(t *testing.T) {
+	tileSize := 8
+	tiles := map[[3]int][]byte{
+		{2, 2, 1}: encodePNGTile(t, tileSize, color.RGBA{200, 0, 0, 255}),
+		{2, 3, 1}: encodePNGTile(t, tileSize, color.RGBA{0, 200, 0, 255}),
+		{1, 1, 0}: encodePNGTile(t, tileSize, color.RGBA{0, 0, 200, 255}),
+		{0, 0, 0}: encodePNGTile(t, tileSize, color.RGBA{9, 9, 9, 255}),
+	}
+	cfg := TransformConfig{MinZoom: 1, MaxZoom: 2, Concurrency: 2, Mode: TransformPassthrough}
+
+	plain := newMockTileWriter()
+	wantStats, err := Transform(cfg, &mockPMTilesReader{tiles: tiles}, plain)
+	if err != nil {
+		t.Fatalf("Transform: %v", err)
+	}
+
+	reader := &mockStreamingReader{mockPMTilesReader: &mockPMTilesReader{tiles: tiles}}
+	streamed := newMockTileWriter()
+	stats, err := Transform(cfg, reader, streamed)
+	if err != nil {
+		t.Fatalf("Transform (stream): %v", err)
+	}
+	if reader.streamed != 3 {
+		t.Errorf("streamed %d tiles, want the 3 tiles of zooms 1-2", reader.streamed)
+	}
+	if stats.TileCount != 3 || stats.TileCount != wantStats.TileCount || stats.TotalBytes != wantStats.TotalBytes {
+		t.Errorf("stats = %d tiles, %d bytes; want %d tiles, %d bytes", stats.TileCount, stats.TotalBytes, wantStats.TileCount, wantStats.TotalBytes)
+	}
+	for k, want := range plain.tiles {
+		if !bytes.Equal(streamed.tiles[k], want) {
+			t.Errorf("tile %v differs between streamed and per-tile copy", k)
+		}
+	}
+}
+
+// TestTransformPassthrough_KeepsSharedData copies a pmtiles archive whose
+// tiles share data into a pmtiles writer, which must keep the sharing
+// through CopyTile instead of hashing the tiles.
+func TestTransformPassthrough_KeepsSharedData(t *testing.T) {
+	dir := t.TempDir()
+	opts := pmtiles.WriterOptions{MaxZoom: 2, TileFormat: pmtiles.TileTypePNG, TileSize: 8, TempDir: dir}
+	src, err := pmtiles.NewWriter(filepath.Join(dir, "src.pmtiles"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for z := 0; z <= 2; z++ {
+		for x := 0; x < 1<<z; x++ {
+			for y := 0; y < 1<<z; y++ {
+				data := []byte(fmt.Sprintf("tile %d/%d/%d", z, x, y))
+				if x == y {
+					data = []byte("diagonal")
+				}
+				if err := src.WriteTile(z, x, y, data); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	if err := src.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := pmtiles.OpenReader(filepath.Join(dir, "src.pmtiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	outPath := filepath.Join(dir, "out.pmtiles")
+	out, err := pmtiles.NewWriter(outPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := TransformConfig{MinZoom: 0, MaxZoom: 2, Concurrency: 1, Mode: TransformPassthrough}
+	if _, err := Transform(cfg, reader, out); err != nil {
+		t.Fatalf("Transform: %v", err)
+	}
+	if err := out.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	copied, err := pmtiles.OpenReader(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+	if got, want := copied.Header().NumTileContents, reader.Header().NumTileContents; got != want {
+		t.Errorf("copy has %d tile contents, want %d as in the source", got, want)
+	}
+	if got := copied.NumTiles(); got != 21 {
+		t.Errorf("copy has %d tiles, want 21", got)
+	}
+}
+
+// lookupCountingReader counts the HasTile lookups per zoom.
+type lookupCountingReader struct {
+	*mockPMTilesReader
+	mu      sync.Mutex
+	lookups map[int]int
+}
+
+func (r *lookupCountingReader) HasTile(z, x, y int) bool {
+	r.mu.Lock()
+	r.lookups[z]++
+	r.mu.Unlock()
+	return r.mockPMTilesReader.HasTile(z, x, y)
+}
+
+// TestFillEmptyTiles verifies that passthrough fills the missing positions
+// with identical bytes from several workers, and that a zoom the archive
+// covers completely is not walked.
+func TestFillEmptyTiles(t *testing.T) {
+	tileSize := 8
+	fill := color.RGBA{255, 0, 0, 255}
+	bounds := testBounds()
+
+	// Zoom 2 complete, zoom 1 missing (1,1,1), zoom 0 empty.
+	tiles := make(map[[3]int][]byte)
+	for _, pos := range [][3]int{{2, 2, 1}, {2, 3, 1}, {2, 2, 2}, {2, 3, 2}, {1, 1, 0}} {
+		tiles[pos] = encodePNGTile(t, tileSize, color.RGBA{0, 100, 0, 255})
+	}
+	reader := &lookupCountingReader{
+		mockPMTilesReader: &mockPMTilesReader{tiles: tiles},
+		lookups:           make(map[int]int),
+	}
+	writer := newMockTileWriter()
+
+	cfg := TransformConfig{
+		MinZoom:     0,
+		MaxZoom:     2,
+		TileSize:    tileSize,
+		Concurrency: 4,
+		Encoder:     testEncoder(t),
+		Mode:        TransformPassthrough,
+		FillColor:   &fill,
+		Bounds:      bounds,
+	}
+	stats, err := Transform(cfg, reader, writer)
+	if err != nil {
+		t.Fatalf("Transform: %v", err)
+	}
+	if stats.TileCount != 7 {
+		t.Errorf("TileCount = %d, want 7 (5 copied, 2 filled)", stats.TileCount)
+	}
+	if n := reader.lookups[2]; n != 0 {
+		t.Errorf("zoom 2 is complete but was walked (%d lookups)", n)
+	}
+	fillData := writer.tiles[[3]int{1, 1, 1}]
+	if fillData == nil || !bytes.Equal(writer.tiles[[3]int{0, 0, 0}], fillData) {
+		t.Fatal("missing positions at zooms 0 and 1 not filled with the same tile")
+	}
+	if bytes.Equal(writer.tiles[[3]int{1, 1, 0}], fillData) {
+		t.Error("existing tile (1,1,0) overwritten with the fill tile")
+	}
+}
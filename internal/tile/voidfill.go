@@ -0,0 +1,166 @@
+package tile
+
+import (
+	"image"
+	"math"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/cog"
+	"github.com/pspoerri/geotiff2pmtiles/internal/coord"
+	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+)
+
+// maxVoidMargin caps the border rendered around a tile for void filling.
+const maxVoidMargin = 64
+
+// voidMargin returns how many pixels are rendered beyond each tile edge so
+// that voids of up to maxPixels pixels crossing the edge are seen whole, with
+// a ring of valid samples around them: about the diameter of a compact void
+// of that size plus two pixels.
+func voidMargin(maxPixels int) int {
+	return min(int(math.Ceil(math.Sqrt(float64(maxPixels))))+2, maxVoidMargin)
+}
+
+// renderTileTerrariumFilled is renderTileTerrarium with void filling: the
+// tile is rendered as elevations with a margin on every side, NaN regions of
+// at most maxVoid pixels are filled, and the tile's own pixels are encoded.
+// Neighbouring tiles render the same margin samples, so a void crossing the
+// boundary gets the same fill on both sides.
+func renderTileTerrariumFilled(z, tx, ty, tileSize int, tileSrcs []tileSource, proj coord.Projection, scratch *sampleScratch, cache *cog.FloatTileCache, mode Resampling, maxVoid int, dem encode.DEMEncoding) *image.RGBA {
+	m := voidMargin(maxVoid)
+	n := tileSize + 2*m
+	grid := renderElevations(z, tx, ty, tileSize, m, tileSrcs, proj, scratch, cache, mode)
+	fillVoids(grid, n, n, maxVoid)
+
+	img := GetRGBA(tileSize, tileSize)
+	hasData := false
+	for py := 0; py < tileSize; py++ {
+		row := grid[(py+m)*n+m:]
+		for px := 0; px < tileSize; px++ {
+			if v := row[px]; !math.IsNaN(v) {
+				img.SetRGBA(px, py, dem.FromElevation(v))
+				hasData = true
+			}
+		}
+	}
+	if !hasData {
+		PutRGBA(img)
+		return nil
+	}
+	return img
+}
+
+// renderElevations samples the tile extended by margin pixels on every
+// side as elevations: an n×n grid with n = tileSize+2*margin, NaN where no
+// source has data.
+func renderElevations(z, tx, ty, tileSize, margin int, tileSrcs []tileSource, proj coord.Projection, scratch *sampleScratch, cache *cog.FloatTileCache, mode Resampling) []float64 {
+	n := tileSize + 2*margin
+	lons := make([]float64, n)
+	lats := make([]float64, n)
+	for i := 0; i < n; i++ {
+		lons[i], _ = coord.PixelToLonLat(z, tx, ty, tileSize, float64(i-margin)+0.5, 0)
+		_, lats[i] = coord.PixelToLonLat(z, tx, ty, tileSize, 0, float64(i-margin)+0.5)
+	}
+
+	grid := make([]float64, n*n)
+	for gy := 0; gy < n; gy++ {
+		for gx := 0; gx < n; gx++ {
+			srcX, srcY := proj.FromWGS84(lons[gx], lats[gy])
+			elevation, found := sampleFromTileSourcesFloat(tileSrcs, srcX, srcY, cache, mode, scratch)
+			if !found {
+				elevation = math.NaN()
+			}
+			grid[gy*n+gx] = elevation
+		}
+	}
+	return grid
+}
+
+// paddedTileCRSBounds is tileCRSBounds for the tile extended by margin
+// pixels on every side, so sources that only cover the margin are included.
+func paddedTileCRSBounds(z, tx, ty, tileSize, margin int, proj coord.Projection) (minX, minY, maxX, maxY float64) {
+	lo, hi := float64(-margin), float64(tileSize+margin)
+	minLon, maxLat := coord.PixelToLonLat(z, tx, ty, tileSize, lo, lo)
+	maxLon, minLat := coord.PixelToLonLat(z, tx, ty, tileSize, hi, hi)
+	return lonLatBoxCRSBounds(z, minLon, minLat, maxLon, maxLat, proj)
+}
+
+// fillVoids fills every 4-connected NaN region of at most maxPixels pixels
+// in the w×h grid by inverse-distance weighting (power 2) of the valid
+// pixels bordering the region. Regions touching the grid edge are left
+// alone, since their true extent is unknown: they may be the start of the
+// area outside the data. Returns the number of pixels filled.
+func fillVoids(grid []float64, w, h, maxPixels int) int {
+	seen := make([]bool, len(grid))
+	var stack, void, edge []int
+	filled := 0
+
+	for start := range grid {
+		if seen[start] || !math.IsNaN(grid[start]) {
+			continue
+		}
+
+		// Flood-fill the region. Keep walking past maxPixels so the whole
+		// region is marked and not revisited from another start pixel.
+		void = void[:0]
+		stack = append(stack[:0], start)
+		seen[start] = true
+		touchesEdge := false
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			void = append(void, i)
+			x, y := i%w, i/w
+			if x == 0 || y == 0 || x == w-1 || y == h-1 {
+				touchesEdge = true
+			}
+			for _, d := range [4][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+				nx, ny := x+d[0], y+d[1]
+				if nx < 0 || ny < 0 || nx >= w || ny >= h {
+					continue
+				}
+				j := ny*w + nx
+				if !seen[j] && math.IsNaN(grid[j]) {
+					seen[j] = true
+					stack = append(stack, j)
+				}
+			}
+		}
+		if touchesEdge || len(void) > maxPixels {
+			continue
+		}
+
+		// Valid 8-neighbours of the region. The region does not touch the
+		// edge, so all neighbours are in bounds. seen marks them only while
+		// collecting: a valid pixel may border several regions.
+		edge = edge[:0]
+		for _, i := range void {
+			x, y := i%w, i/w
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					j := (y+dy)*w + x + dx
+					if !seen[j] && !math.IsNaN(grid[j]) {
+						seen[j] = true
+						edge = append(edge, j)
+					}
+				}
+			}
+		}
+		for _, j := range edge {
+			seen[j] = false
+		}
+
+		for _, i := range void {
+			x, y := i%w, i/w
+			var sum, wSum float64
+			for _, j := range edge {
+				dx, dy := float64(j%w-x), float64(j/w-y)
+				wt := 1 / (dx*dx + dy*dy)
+				sum += wt * grid[j]
+				wSum += wt
+			}
+			grid[i] = sum / wSum
+		}
+		filled += len(void)
+	}
+	return filled
+}
@@ -0,0 +1,57 @@
+package tile
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFillVoids(t *testing.T) {
+	const w, h = 8, 6
+	nan := math.NaN()
+	grid := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			grid[y*w+x] = float64(10*x + y) // plane
+		}
+	}
+	// 2-pixel interior void, 4-pixel interior void, and a void on the edge.
+	small := []int{2*w + 2, 2*w + 3}
+	large := []int{2*w + 5, 2*w + 6, 3*w + 5, 3*w + 6}
+	onEdge := []int{5*w + 0, 5*w + 1}
+	for _, i := range append(append(append([]int{}, small...), large...), onEdge...) {
+		grid[i] = nan
+	}
+
+	if n := fillVoids(grid, w, h, 3); n != len(small) {
+		t.Errorf("filled %d pixels, want %d", n, len(small))
+	}
+	for _, i := range small {
+		x, y := i%w, i/w
+		// IDW of a plane stays within the border values and near the plane.
+		if v, want := grid[i], float64(10*x+y); math.IsNaN(v) || math.Abs(v-want) > 5 {
+			t.Errorf("pixel (%d,%d) = %v, want ≈ %v", x, y, v, want)
+		}
+	}
+	for _, i := range append(large, onEdge...) {
+		if !math.IsNaN(grid[i]) {
+			t.Errorf("pixel (%d,%d) = %v, want NaN (too large or touching the edge)", i%w, i/w, grid[i])
+		}
+	}
+}
+
+func TestFillVoids_Flat(t *testing.T) {
+	grid := []float64{
+		5, 5, 5, 5,
+		5, math.NaN(), math.NaN(), 5,
+		5, math.NaN(), 5, 5,
+		5, 5, 5, 5,
+	}
+	if n := fillVoids(grid, 4, 4, 10); n != 3 {
+		t.Fatalf("filled %d pixels, want 3", n)
+	}
+	for i, v := range grid {
+		if math.Abs(v-5) > 1e-9 {
+			t.Errorf("grid[%d] = %v, want 5", i, v)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package tile
+
+import "io"
+
+// MaxZoomLabel is the highest zoom a tile may be labelled with after
+// --zoom-offset is applied.
+const MaxZoomLabel = 30
+
+// ZoomOffsetWriter is a TileWriter that adds a fixed offset to the zoom of
+// every tile before passing it on (--zoom-offset). x and y are unchanged:
+// the offset relabels zoom levels, e.g. so 512px tiles carry the zoom at
+// which their scale matches 256px tiles, for clients configured with the
+// same offset. Generation itself, including the tile enumeration and the
+// parent/child relations used for downsampling, runs on the real zooms.
+type ZoomOffsetWriter struct {
+	next   TileWriter
+	offset int
+}
+
+// NewZoomOffsetWriter wraps next so that tiles of zoom z are written as
+// zoom z+offset.
+func NewZoomOffsetWriter(next TileWriter, offset int) *ZoomOffsetWriter {
+	return &ZoomOffsetWriter{next: next, offset: offset}
+}
+
+// WriteTile writes the tile under its offset zoom.
+func (w *ZoomOffsetWriter) WriteTile(z, x, y int, data []byte) error {
+	return w.next.WriteTile(z+w.offset, x, y, data)
+}
+
+// CompleteZoom forwards the offset zoom to the wrapped writer if it is a
+// ZoomCompleter.
+func (w *ZoomOffsetWriter) CompleteZoom(z int) {
+	if zc, ok := w.next.(ZoomCompleter); ok {
+		zc.CompleteZoom(z + w.offset)
+	}
+}
+
+// WriteTileLocated writes the tile under its offset zoom; see TileLocator.
+// The wrapped writer must be a TileLocator (DataReader is non-nil).
+func (w *ZoomOffsetWriter) WriteTileLocated(z, x, y int, data []byte) (int64, error) {
+	return w.next.(TileLocator).WriteTileLocated(z+w.offset, x, y, data)
+}
+
+// DataReader returns the wrapped writer's, or nil if it is not a
+// TileLocator.
+func (w *ZoomOffsetWriter) DataReader() io.ReaderAt {
+	if l, ok := w.next.(TileLocator); ok {
+		return l.DataReader()
+	}
+	return nil
+}
+
+// ZoomOffsetReader reads tiles that were written through a ZoomOffsetWriter
+// by their real zoom, e.g. as Config.Previous of an incremental run.
+type ZoomOffsetReader struct {
+	next   TileReader
+	offset int
+}
+
+// NewZoomOffsetReader wraps next so that zoom z is read from zoom z+offset.
+func NewZoomOffsetReader(next TileReader, offset int) *ZoomOffsetReader {
+	return &ZoomOffsetReader{next: next, offset: offset}
+}
+
+// ReadTile reads the tile stored under the offset zoom.
+func (r *ZoomOffsetReader) ReadTile(z, x, y int) ([]byte, error) {
+	return r.next.ReadTile(z+r.offset, x, y)
+}
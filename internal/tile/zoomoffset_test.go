@@ -0,0 +1,31 @@
+package tile
+
+import (
+	"reflect"
+	"testing"
+)
+
+// mapReader is a TileReader over a map of tiles.
+type mapReader map[[3]int][]byte
+
+func (r mapReader) ReadTile(z, x, y int) ([]byte, error) { return r[[3]int{z, x, y}], nil }
+
+func TestZoomOffsetWriter(t *testing.T) {
+	next := &zoomRecorder{mockTileWriter: newMockTileWriter()}
+	w := NewZoomOffsetWriter(next, 2)
+	if err := w.WriteTile(3, 4, 5, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	w.CompleteZoom(3)
+	if got := string(next.tiles[[3]int{5, 4, 5}]); got != "a" {
+		t.Errorf("tile 3/4/5 written as 5/4/5 = %q, want %q", got, "a")
+	}
+	if !reflect.DeepEqual(next.completed, []int{5}) {
+		t.Errorf("completed zooms = %v, want [5]", next.completed)
+	}
+
+	r := NewZoomOffsetReader(mapReader(next.tiles), 2)
+	if data, err := r.ReadTile(3, 4, 5); err != nil || string(data) != "a" {
+		t.Errorf("ReadTile(3, 4, 5) = %q, %v; want %q", data, err, "a")
+	}
+}
@@ -0,0 +1,137 @@
+// Package ui formats sizes and durations for the commands' console output:
+// the settings summary, memory estimates, and the final "Done" lines.
+// People get rounded sizes in binary (KiB, MiB) or SI (kB, MB) units and the
+// decimal separator of their locale; scripts get raw numbers with
+// --machine-readable.
+package ui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Units selects the multiple of a byte that sizes are shown in.
+type Units int
+
+const (
+	Binary Units = iota // powers of 1024: KiB, MiB, GiB
+	SI                  // powers of 1000: kB, MB, GB
+)
+
+// ParseUnits parses a --units value: "binary" or "si".
+func ParseUnits(s string) (Units, error) {
+	switch strings.ToLower(s) {
+	case "binary", "iec":
+		return Binary, nil
+	case "si", "decimal":
+		return SI, nil
+	}
+	return Binary, fmt.Errorf("unknown units %q (want binary, si)", s)
+}
+
+// Format formats sizes and durations. The zero value shows binary units
+// with a decimal point.
+type Format struct {
+	Units Units
+	// MachineReadable shows sizes as a plain number of bytes and durations
+	// as seconds with a decimal point, regardless of Units and Decimal.
+	MachineReadable bool
+	// Decimal separates the fraction in human-readable output; 0 means '.'.
+	Decimal byte
+}
+
+// New returns the Format for a --units value and --machine-readable, with
+// the decimal separator of the locale in the environment (LC_ALL,
+// LC_NUMERIC, LANG).
+func New(units string, machineReadable bool) (Format, error) {
+	u, err := ParseUnits(units)
+	if err != nil {
+		return Format{}, err
+	}
+	return Format{Units: u, MachineReadable: machineReadable, Decimal: LocaleDecimal(locale())}, nil
+}
+
+// locale returns the locale that governs numbers, as POSIX resolves it.
+func locale() string {
+	for _, k := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// commaLanguages write a decimal comma (ISO 639-1 codes).
+var commaLanguages = map[string]bool{
+	"bg": true, "ca": true, "cs": true, "da": true, "de": true, "el": true,
+	"es": true, "et": true, "fi": true, "fr": true, "hr": true, "hu": true,
+	"id": true, "it": true, "lt": true, "lv": true, "nb": true, "nl": true,
+	"nn": true, "no": true, "pl": true, "pt": true, "ro": true, "ru": true,
+	"sk": true, "sl": true, "sr": true, "sv": true, "tr": true, "uk": true,
+	"vi": true,
+}
+
+// LocaleDecimal returns the decimal separator of a POSIX locale name such
+// as "de_DE.UTF-8": ',' for languages that write a decimal comma, '.' for
+// the others and for "C", "POSIX", and "". It goes by language only, so
+// regional exceptions such as de_CH get the comma too.
+func LocaleDecimal(locale string) byte {
+	lang, _, _ := strings.Cut(locale, ".")
+	lang, _, _ = strings.Cut(lang, "@")
+	lang, _, _ = strings.Cut(lang, "_")
+	if commaLanguages[strings.ToLower(lang)] {
+		return ','
+	}
+	return '.'
+}
+
+// Size formats a byte count, e.g. "1.5 MiB", "1,5 MB", or "1572864".
+func (f Format) Size(bytes int64) string {
+	if f.MachineReadable {
+		return fmt.Sprintf("%d", bytes)
+	}
+	base, names := int64(1024), [...]string{"KiB", "MiB", "GiB", "TiB"}
+	if f.Units == SI {
+		base, names = 1000, [...]string{"kB", "MB", "GB", "TB"}
+	}
+	if bytes < base && bytes > -base {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	v := float64(bytes) / float64(base)
+	i := 0
+	for i < len(names)-1 && (v >= float64(base) || v <= -float64(base)) {
+		v /= float64(base)
+		i++
+	}
+	return f.decimal(fmt.Sprintf("%.1f", v)) + " " + names[i]
+}
+
+// Duration formats an elapsed time, e.g. "1m2.345s" (to the millisecond,
+// as time.Duration prints it) or "62.345" seconds.
+func (f Format) Duration(d time.Duration) string {
+	if f.MachineReadable {
+		return fmt.Sprintf("%.3f", d.Seconds())
+	}
+	return f.decimal(d.Round(time.Millisecond).String())
+}
+
+func (f Format) decimal(s string) string {
+	if f.Decimal == 0 || f.Decimal == '.' {
+		return s
+	}
+	return strings.Replace(s, ".", string(f.Decimal), 1)
+}
+
+// Clock formats a duration to the second for a progress line, e.g. "45s"
+// or "1m23s".
+func Clock(d time.Duration) string {
+	d = d.Truncate(time.Second)
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+	m := int(d.Minutes())
+	s := int(d.Seconds()) - m*60
+	return fmt.Sprintf("%dm%02ds", m, s)
+}
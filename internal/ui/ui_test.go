@@ -0,0 +1,88 @@
+package ui
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	binary := Format{}
+	si := Format{Units: SI}
+	comma := Format{Units: SI, Decimal: ','}
+	machine := Format{Units: SI, Decimal: ',', MachineReadable: true}
+
+	sizes := []struct {
+		f    Format
+		n    int64
+		want string
+	}{
+		{binary, 0, "0 B"},
+		{binary, 1023, "1023 B"},
+		{binary, 1536, "1.5 KiB"},
+		{binary, 3 << 30, "3.0 GiB"},
+		{binary, 5 << 40, "5.0 TiB"},
+		{si, 999, "999 B"},
+		{si, 1500, "1.5 kB"},
+		{si, 2_500_000_000, "2.5 GB"},
+		{comma, 1_500_000, "1,5 MB"},
+		{machine, 1_500_000, "1500000"},
+	}
+	for _, tc := range sizes {
+		if got := tc.f.Size(tc.n); got != tc.want {
+			t.Errorf("%+v.Size(%d) = %q, want %q", tc.f, tc.n, got, tc.want)
+		}
+	}
+
+	d := 62*time.Second + 345600*time.Microsecond
+	if got := si.Duration(d); got != "1m2.346s" {
+		t.Errorf("Duration = %q, want 1m2.346s", got)
+	}
+	if got := comma.Duration(d); got != "1m2,346s" {
+		t.Errorf("Duration with comma = %q, want 1m2,346s", got)
+	}
+	if got := machine.Duration(d); got != "62.346" {
+		t.Errorf("machine-readable Duration = %q, want 62.346", got)
+	}
+}
+
+func TestLocaleDecimal(t *testing.T) {
+	for locale, want := range map[string]byte{
+		"":                  '.',
+		"C":                 '.',
+		"POSIX":             '.',
+		"en_US.UTF-8":       '.',
+		"de_DE.UTF-8":       ',',
+		"fr_FR@euro":        ',',
+		"pt_BR":             ',',
+		"ja_JP.eucJP":       '.',
+		"sv_SE.ISO-8859-15": ',',
+	} {
+		if got := LocaleDecimal(locale); got != want {
+			t.Errorf("LocaleDecimal(%q) = %q, want %q", locale, got, want)
+		}
+	}
+}
+
+func TestParseUnits(t *testing.T) {
+	if u, err := ParseUnits("SI"); err != nil || u != SI {
+		t.Errorf("ParseUnits(SI) = %v, %v", u, err)
+	}
+	if u, err := ParseUnits("binary"); err != nil || u != Binary {
+		t.Errorf("ParseUnits(binary) = %v, %v", u, err)
+	}
+	if _, err := ParseUnits("bits"); err == nil {
+		t.Error("ParseUnits(bits) succeeded, want an error")
+	}
+}
+
+func TestClock(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		0:                                     "0s",
+		45*time.Second + 900*time.Millisecond: "45s",
+		83 * time.Second:                      "1m23s",
+	} {
+		if got := Clock(d); got != want {
+			t.Errorf("Clock(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
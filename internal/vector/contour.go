@@ -0,0 +1,210 @@
+// Package vector builds Mapbox Vector Tiles from elevation tiles: contour
+// lines traced by marching squares, encoded as MVT protobuf.
+package vector
+
+import (
+	"math"
+	"sort"
+)
+
+// Point is a position in grid units: sample (i, j) of a grid lies at
+// (i, j), so a w×h grid spans [0, w-1]×[0, h-1].
+type Point struct {
+	X, Y float64
+}
+
+// Contour holds every line of one elevation level.
+type Contour struct {
+	Elevation float64
+	Lines     [][]Point
+}
+
+// segment is one cell's piece of a contour, between two crossed cell
+// edges (see edgeKey).
+type segment [2]int
+
+// Trace returns the contour lines of grid, w×h elevations in row-major
+// order (NaN = nodata), at every multiple of interval, sorted by
+// elevation. Levels without lines are left out.
+//
+// Each 2×2 cell of samples is classified by which corners lie at or above
+// the level (marching squares); a line crosses the cell edges whose two
+// corners are on different sides, at the linearly interpolated position.
+// Saddle cells are resolved by the mean of their four corners. Cells with
+// a NaN corner have no lines, so lines end at nodata. The cell pieces are
+// joined into polylines through their shared edges; lines that close on
+// themselves repeat their first point at the end. Lines that shrink to a
+// point, at a peak exactly at the level, are dropped.
+func Trace(grid []float64, w, h int, interval float64) []Contour {
+	if interval <= 0 || w < 2 || h < 2 || len(grid) < w*h {
+		return nil
+	}
+
+	// Segments per level index k (level k·interval). A cell crosses level
+	// L when its minimum is below L and its maximum at or above it.
+	segs := make(map[int][]segment)
+	for j := 0; j+1 < h; j++ {
+		for i := 0; i+1 < w; i++ {
+			tl, tr := grid[j*w+i], grid[j*w+i+1]
+			bl, br := grid[(j+1)*w+i], grid[(j+1)*w+i+1]
+			lo := math.Min(math.Min(tl, tr), math.Min(bl, br))
+			hi := math.Max(math.Max(tl, tr), math.Max(bl, br))
+			if math.IsNaN(lo) || math.IsNaN(hi) {
+				continue
+			}
+			for k := int(math.Floor(lo/interval)) + 1; float64(k)*interval <= hi; k++ {
+				segs[k] = appendCellSegments(segs[k], i, j, w, tl, tr, br, bl, float64(k)*interval)
+			}
+		}
+	}
+
+	levels := make([]int, 0, len(segs))
+	for k := range segs {
+		levels = append(levels, k)
+	}
+	sort.Ints(levels)
+
+	contours := make([]Contour, 0, len(levels))
+	for _, k := range levels {
+		level := float64(k) * interval
+		at := func(key int) Point { return crossing(grid, w, key, level) }
+		if lines := joinSegments(segs[k], at); len(lines) > 0 {
+			contours = append(contours, Contour{Elevation: level, Lines: lines})
+		}
+	}
+	return contours
+}
+
+// edgeKey identifies a cell edge by its first sample: the horizontal edge
+// from (i, j) to (i+1, j) is 2·(j·w+i), the vertical edge from (i, j) to
+// (i, j+1) is 2·(j·w+i)+1. Neighbouring cells share the key of their
+// common edge, which is how their segments are joined.
+func edgeKey(i, j, w int, vertical bool) int {
+	k := 2 * (j*w + i)
+	if vertical {
+		k++
+	}
+	return k
+}
+
+// crossing returns where level crosses the edge key. Only called for
+// crossed edges, whose two samples differ.
+func crossing(grid []float64, w, key int, level float64) Point {
+	s := key / 2
+	i, j := s%w, s/w
+	a := grid[s]
+	if key%2 == 0 {
+		t := (level - a) / (grid[s+1] - a)
+		return Point{float64(i) + t, float64(j)}
+	}
+	t := (level - a) / (grid[s+w] - a)
+	return Point{float64(i), float64(j) + t}
+}
+
+// appendCellSegments appends the segments of cell (i, j) at level to segs.
+func appendCellSegments(segs []segment, i, j, w int, tl, tr, br, bl, level float64) []segment {
+	top, bottom := edgeKey(i, j, w, false), edgeKey(i, j+1, w, false)
+	left, right := edgeKey(i, j, w, true), edgeKey(i+1, j, w, true)
+
+	c := 0
+	if tl >= level {
+		c |= 8
+	}
+	if tr >= level {
+		c |= 4
+	}
+	if br >= level {
+		c |= 2
+	}
+	if bl >= level {
+		c |= 1
+	}
+	switch c {
+	case 1, 14:
+		segs = append(segs, segment{left, bottom})
+	case 2, 13:
+		segs = append(segs, segment{bottom, right})
+	case 3, 12:
+		segs = append(segs, segment{left, right})
+	case 4, 11:
+		segs = append(segs, segment{top, right})
+	case 6, 9:
+		segs = append(segs, segment{top, bottom})
+	case 7, 8:
+		segs = append(segs, segment{left, top})
+	case 5, 10:
+		// Saddle: the corners on the same side as the cell mean are
+		// connected through the center, the other two are cut off.
+		above := (tl+tr+br+bl)/4 >= level
+		if above == (c == 5) {
+			segs = append(segs, segment{left, top}, segment{bottom, right})
+		} else {
+			segs = append(segs, segment{top, right}, segment{left, bottom})
+		}
+	}
+	return segs
+}
+
+// joinSegments links segments that share an edge into polylines and maps
+// their edge keys to points with at.
+func joinSegments(segs []segment, at func(int) Point) [][]Point {
+	// Every edge is shared by at most two cells, and a cell touches each
+	// of its edges with at most one segment.
+	byEdge := make(map[int][]int, 2*len(segs))
+	for s, sg := range segs {
+		byEdge[sg[0]] = append(byEdge[sg[0]], s)
+		byEdge[sg[1]] = append(byEdge[sg[1]], s)
+	}
+	used := make([]bool, len(segs))
+	next := func(key int) (int, bool) {
+		for _, s := range byEdge[key] {
+			if !used[s] {
+				used[s] = true
+				if segs[s][0] == key {
+					return segs[s][1], true
+				}
+				return segs[s][0], true
+			}
+		}
+		return 0, false
+	}
+
+	var lines [][]Point
+	for s := range segs {
+		if used[s] {
+			continue
+		}
+		used[s] = true
+		fwd := []int{segs[s][0], segs[s][1]}
+		for k, ok := next(fwd[len(fwd)-1]); ok; k, ok = next(k) {
+			fwd = append(fwd, k)
+		}
+		var back []int
+		for k, ok := next(fwd[0]); ok; k, ok = next(k) {
+			back = append(back, k)
+		}
+		line := make([]Point, 0, len(back)+len(fwd))
+		for i := len(back) - 1; i >= 0; i-- {
+			line = append(line, at(back[i]))
+		}
+		for _, k := range fwd {
+			line = append(line, at(k))
+		}
+		// A sample exactly at the level is "above", so a peak, pit, or
+		// ridge at the level yields lines that shrink to a point.
+		if !degenerate(line) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// degenerate reports whether all points of line coincide.
+func degenerate(line []Point) bool {
+	for _, p := range line[1:] {
+		if p != line[0] {
+			return false
+		}
+	}
+	return true
+}
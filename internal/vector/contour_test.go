@@ -0,0 +1,119 @@
+package vector
+
+import (
+	"math"
+	"testing"
+)
+
+// cone returns an n×n grid rising linearly from 0 at the corners' distance
+// to peak at the center.
+func cone(n int, peak float64) []float64 {
+	grid := make([]float64, n*n)
+	c := float64(n-1) / 2
+	for j := 0; j < n; j++ {
+		for i := 0; i < n; i++ {
+			d := math.Hypot(float64(i)-c, float64(j)-c)
+			grid[j*n+i] = peak - d*peak/c
+		}
+	}
+	return grid
+}
+
+func TestTraceCone(t *testing.T) {
+	const n = 21
+	contours := Trace(cone(n, 100), n, n, 25)
+	var levels []float64
+	for _, c := range contours {
+		levels = append(levels, c.Elevation)
+	}
+	// The corners lie below 0. The peak at exactly 100 only touches
+	// its level in a point, which is no line.
+	want := []float64{-25, 0, 25, 50, 75}
+	if len(levels) != len(want) {
+		t.Fatalf("levels = %v, want %v", levels, want)
+	}
+	for i := range want {
+		if levels[i] != want[i] {
+			t.Fatalf("levels = %v, want %v", levels, want)
+		}
+	}
+
+	// Inner levels are single closed rings at their radius.
+	for _, c := range contours[2:] {
+		if len(c.Lines) != 1 {
+			t.Fatalf("level %g: %d lines, want 1 ring", c.Elevation, len(c.Lines))
+		}
+		line := c.Lines[0]
+		if line[0] != line[len(line)-1] {
+			t.Errorf("level %g: ring not closed: %v … %v", c.Elevation, line[0], line[len(line)-1])
+		}
+		radius := (100 - c.Elevation) * 10 / 100
+		for _, p := range line {
+			if r := math.Hypot(p.X-10, p.Y-10); math.Abs(r-radius) > 0.15 {
+				t.Errorf("level %g: point %v at radius %.3f, want %.3f", c.Elevation, p, r, radius)
+				break
+			}
+		}
+	}
+}
+
+func TestTraceNoData(t *testing.T) {
+	// A ramp in x with NaN samples in the middle row: the line stops at
+	// the cells that touch them.
+	const w, h = 4, 5
+	grid := make([]float64, w*h)
+	for j := 0; j < h; j++ {
+		for i := 0; i < w; i++ {
+			grid[j*w+i] = float64(i) * 10
+		}
+	}
+	grid[2*w+1] = math.NaN()
+	grid[2*w+2] = math.NaN()
+	contours := Trace(grid, w, h, 25)
+	if len(contours) != 1 || contours[0].Elevation != 25 {
+		t.Fatalf("contours = %+v, want one level at 25", contours)
+	}
+	lines := contours[0].Lines
+	if len(lines) != 2 {
+		t.Fatalf("%d lines, want 2 split by nodata: %v", len(lines), lines)
+	}
+	for _, line := range lines {
+		for _, p := range line {
+			if p.X != 2.5 {
+				t.Errorf("point %v, want x = 2.5", p)
+			}
+		}
+	}
+}
+
+func TestTraceSaddle(t *testing.T) {
+	// High top-left and bottom-right corners; the cell mean decides
+	// whether they are connected through the center.
+	near := func(p, q Point) bool { return math.Abs(p.X-q.X) < 1e-9 && math.Abs(p.Y-q.Y) < 1e-9 }
+	for _, tc := range []struct {
+		grid []float64
+		want [2]Point // one of the two lines
+	}{
+		// Mean 3 < 5: the high corners are cut off.
+		{[]float64{6, 0, 0, 6}, [2]Point{{0, 1.0 / 6}, {1.0 / 6, 0}}},
+		// Mean 7 ≥ 5: the low corners are cut off.
+		{[]float64{10, 4, 4, 10}, [2]Point{{5.0 / 6, 0}, {1, 1.0 / 6}}},
+	} {
+		contours := Trace(tc.grid, 2, 2, 5)
+		if len(contours) != 1 || len(contours[0].Lines) != 2 {
+			t.Fatalf("%v: contours = %+v, want 2 lines at 5", tc.grid, contours)
+		}
+		found := false
+		for _, line := range contours[0].Lines {
+			if len(line) != 2 {
+				t.Fatalf("%v: line %v, want 2 points", tc.grid, line)
+			}
+			if (near(line[0], tc.want[0]) && near(line[1], tc.want[1])) || (near(line[0], tc.want[1]) && near(line[1], tc.want[0])) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%v: lines %v, want one from %v to %v", tc.grid, contours[0].Lines, tc.want[0], tc.want[1])
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package vector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/tile"
+)
+
+// IntervalRange sets the contour interval, in elevation units (metres for
+// most DEMs), of zoom levels MinZoom..MaxZoom.
+type IntervalRange struct {
+	MinZoom, MaxZoom int
+	Interval         float64
+}
+
+// IndexEvery is how many intervals apart the index contours (the bold,
+// labelled lines of a topographic map) are.
+const IndexEvery = 5
+
+// DefaultInterval returns the contour interval for zoom z when no range
+// covers it: 10 m from zoom 13, doubling or so per zoom out, so that
+// lines stay a few pixels apart in typical relief.
+func DefaultInterval(z int) float64 {
+	switch {
+	case z <= 8:
+		return 500
+	case z == 9:
+		return 200
+	case z == 10:
+		return 100
+	case z == 11:
+		return 50
+	case z == 12:
+		return 20
+	default:
+		return 10
+	}
+}
+
+// ParseIntervals parses a --contour-interval value: either one interval
+// for all zooms ("25") or comma-separated "min-max:interval" ranges and
+// single zooms "z:interval", e.g. "0-10:100,11-12:50,13-14:10". Zooms no
+// range covers use DefaultInterval.
+func ParseIntervals(s string) ([]IntervalRange, error) {
+	if v, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+		if v <= 0 {
+			return nil, fmt.Errorf("invalid contour interval %q (want > 0)", s)
+		}
+		return []IntervalRange{{MinZoom: 0, MaxZoom: tile.MaxZoomLabel, Interval: v}}, nil
+	}
+	var ranges []IntervalRange
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		zooms, interval, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid contour interval %q (want \"min-max:interval\" or \"z:interval\")", part)
+		}
+		var r IntervalRange
+		v, err := strconv.ParseFloat(strings.TrimSpace(interval), 64)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("invalid contour interval %q in %q (want > 0)", interval, part)
+		}
+		r.Interval = v
+		lo, hi, isRange := strings.Cut(zooms, "-")
+		if r.MinZoom, err = strconv.Atoi(strings.TrimSpace(lo)); err != nil {
+			return nil, fmt.Errorf("invalid contour zoom %q in %q", lo, part)
+		}
+		r.MaxZoom = r.MinZoom
+		if isRange {
+			if r.MaxZoom, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil {
+				return nil, fmt.Errorf("invalid contour zoom %q in %q", hi, part)
+			}
+		}
+		if r.MinZoom < 0 || r.MaxZoom < r.MinZoom {
+			return nil, fmt.Errorf("invalid contour zoom range %q", zooms)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// IntervalForZoom returns the interval of the first range containing z,
+// or DefaultInterval(z) when none does.
+func IntervalForZoom(ranges []IntervalRange, z int) float64 {
+	for _, r := range ranges {
+		if z >= r.MinZoom && z <= r.MaxZoom {
+			return r.Interval
+		}
+	}
+	return DefaultInterval(z)
+}
@@ -0,0 +1,39 @@
+package vector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIntervals(t *testing.T) {
+	got, err := ParseIntervals("0-10:100, 11:50,12-14:12.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []IntervalRange{{0, 10, 100}, {11, 11, 50}, {12, 14, 12.5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseIntervals = %+v, want %+v", got, want)
+	}
+	if v := IntervalForZoom(got, 13); v != 12.5 {
+		t.Errorf("interval at z13 = %g, want 12.5", v)
+	}
+	if v := IntervalForZoom(got, 16); v != DefaultInterval(16) {
+		t.Errorf("interval at z16 = %g, want the default %g", v, DefaultInterval(16))
+	}
+
+	all, err := ParseIntervals("25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, z := range []int{0, 9, 18} {
+		if v := IntervalForZoom(all, z); v != 25 {
+			t.Errorf("single interval at z%d = %g, want 25", z, v)
+		}
+	}
+
+	for _, bad := range []string{"", "0", "-5", "0-3", "a-3:10", "5-2:10", "-1:10", "0-3:0", "0-3:x"} {
+		if _, err := ParseIntervals(bad); err == nil {
+			t.Errorf("ParseIntervals(%q): expected an error", bad)
+		}
+	}
+}
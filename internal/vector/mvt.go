@@ -0,0 +1,170 @@
+package vector
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Extent is the tile coordinate range of the MVT layers written here: a
+// tile spans 0..Extent on both axes, with y pointing down.
+const Extent = 4096
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// MVT geometry commands and the LineString geometry type
+// (vector_tile.proto, version 2).
+const (
+	cmdMoveTo      = 1
+	cmdLineTo      = 2
+	geomLineString = 2
+)
+
+// EncodeContours encodes contours as an MVT tile with one layer named
+// layer. Points must be in tile coordinates (see Extent); they are rounded
+// to integers, points on a straight run between their neighbours are
+// left out, and lines left with fewer than two distinct points are
+// dropped. Each level becomes one (multi-)LineString feature with the
+// properties "ele", the elevation, and "index", true for levels that are
+// a multiple of indexInterval (the bold lines of a topographic map).
+// Returns nil when no line is left.
+func EncodeContours(layer string, contours []Contour, indexInterval float64) []byte {
+	var features [][]byte
+	var values [][]byte
+	valueIndex := make(map[float64]uint32)
+	trueIdx, falseIdx := -1, -1
+	boolValue := func(b bool) uint32 {
+		idx := &falseIdx
+		if b {
+			idx = &trueIdx
+		}
+		if *idx < 0 {
+			*idx = len(values)
+			v := 0
+			if b {
+				v = 1
+			}
+			values = append(values, appendVarintField(nil, 7, uint64(v)))
+		}
+		return uint32(*idx)
+	}
+
+	for _, c := range contours {
+		geom := lineGeometry(c.Lines)
+		if geom == nil {
+			continue
+		}
+		ele, ok := valueIndex[c.Elevation]
+		if !ok {
+			ele = uint32(len(values))
+			valueIndex[c.Elevation] = ele
+			values = append(values, numberValue(c.Elevation))
+		}
+		index := indexInterval > 0 && math.Mod(c.Elevation, indexInterval) == 0
+		tags := []uint32{0, ele, 1, boolValue(index)}
+
+		var f []byte
+		f = appendVarintField(f, 1, uint64(len(features)+1))
+		f = appendPackedField(f, 2, tags)
+		f = appendVarintField(f, 3, geomLineString)
+		f = appendPackedField(f, 4, geom)
+		features = append(features, f)
+	}
+	if len(features) == 0 {
+		return nil
+	}
+
+	var l []byte
+	l = appendVarintField(l, 15, 2)
+	l = appendBytesField(l, 1, []byte(layer))
+	for _, f := range features {
+		l = appendBytesField(l, 2, f)
+	}
+	l = appendBytesField(l, 3, []byte("ele"))
+	l = appendBytesField(l, 3, []byte("index"))
+	for _, v := range values {
+		l = appendBytesField(l, 4, v)
+	}
+	l = appendVarintField(l, 5, Extent)
+	return appendBytesField(nil, 3, l)
+}
+
+// lineGeometry encodes lines as MVT geometry commands: a MoveTo and a
+// LineTo per line, with zigzag-encoded deltas from the previous point.
+// Returns nil when no line has two distinct points.
+func lineGeometry(lines [][]Point) []uint32 {
+	var geom []uint32
+	var cx, cy int32
+	pts := make([][2]int32, 0, 64)
+	for _, line := range lines {
+		pts = pts[:0]
+		for _, p := range line {
+			q := [2]int32{int32(math.Round(p.X)), int32(math.Round(p.Y))}
+			if n := len(pts); n > 0 && pts[n-1] == q {
+				continue
+			} else if n >= 2 && straight(pts[n-2], pts[n-1], q) {
+				pts[n-1] = q
+				continue
+			}
+			pts = append(pts, q)
+		}
+		if len(pts) < 2 {
+			continue
+		}
+		geom = append(geom, command(cmdMoveTo, 1), zigzag(pts[0][0]-cx), zigzag(pts[0][1]-cy))
+		geom = append(geom, command(cmdLineTo, len(pts)-1))
+		for i := 1; i < len(pts); i++ {
+			geom = append(geom, zigzag(pts[i][0]-pts[i-1][0]), zigzag(pts[i][1]-pts[i-1][1]))
+		}
+		cx, cy = pts[len(pts)-1][0], pts[len(pts)-1][1]
+	}
+	return geom
+}
+
+// straight reports whether b lies on the way from a to c, so that it
+// can be left out of a line.
+func straight(a, b, c [2]int32) bool {
+	abx, aby := int64(b[0]-a[0]), int64(b[1]-a[1])
+	bcx, bcy := int64(c[0]-b[0]), int64(c[1]-b[1])
+	return abx*bcy == aby*bcx && abx*bcx+aby*bcy > 0
+}
+
+// numberValue encodes v as an MVT Value: sint_value when it is a whole
+// number, double_value otherwise.
+func numberValue(v float64) []byte {
+	if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+		n := int64(v)
+		return appendVarintField(nil, 6, uint64(n<<1)^uint64(n>>63))
+	}
+	b := appendTag(nil, 3, wireFixed64)
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+}
+
+func command(id, count int) uint32 { return uint32(id&7) | uint32(count)<<3 }
+
+func zigzag(n int32) uint32 { return uint32(n<<1) ^ uint32(n>>31) }
+
+func appendTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	return binary.AppendUvarint(appendTag(b, field, wireVarint), v)
+}
+
+func appendBytesField(b []byte, field int, data []byte) []byte {
+	b = binary.AppendUvarint(appendTag(b, field, wireBytes), uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendPackedField(b []byte, field int, vs []uint32) []byte {
+	var packed []byte
+	for _, v := range vs {
+		packed = binary.AppendUvarint(packed, uint64(v))
+	}
+	return appendBytesField(b, field, packed)
+}
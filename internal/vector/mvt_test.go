@@ -0,0 +1,154 @@
+package vector
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// pbField is one decoded protobuf field: a varint, fixed64, or bytes value.
+type pbField struct {
+	num   int
+	value uint64
+	bytes []byte
+}
+
+// decodePB splits a protobuf message into its fields.
+func decodePB(t *testing.T, b []byte) []pbField {
+	t.Helper()
+	var fields []pbField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("bad field key")
+		}
+		b = b[n:]
+		f := pbField{num: int(key >> 3)}
+		switch key & 7 {
+		case wireVarint:
+			f.value, n = binary.Uvarint(b)
+			b = b[n:]
+		case wireFixed64:
+			f.value = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			f.bytes = b[n : n+int(l)]
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// decodePacked decodes a packed repeated uint32 field.
+func decodePacked(b []byte) []uint32 {
+	var vs []uint32
+	for len(b) > 0 {
+		v, n := binary.Uvarint(b)
+		vs = append(vs, uint32(v))
+		b = b[n:]
+	}
+	return vs
+}
+
+func TestEncodeContours(t *testing.T) {
+	contours := []Contour{
+		{Elevation: 100, Lines: [][]Point{
+			{{10, 10}, {20.4, 10}, {20.2, 10.3}, {20, 30}},
+			{{5, 5}, {5.2, 5.1}}, // collapses to one point: dropped
+		}},
+		{Elevation: 110.5, Lines: [][]Point{{{0, 0}, {-3, 4}}}},
+	}
+	tileData := EncodeContours("contour", contours, 100)
+
+	tileFields := decodePB(t, tileData)
+	if len(tileFields) != 1 || tileFields[0].num != 3 {
+		t.Fatalf("tile fields = %+v, want one layer", tileFields)
+	}
+	var name string
+	var keys []string
+	var values [][]pbField
+	var features [][]pbField
+	var version, extent uint64
+	for _, f := range decodePB(t, tileFields[0].bytes) {
+		switch f.num {
+		case 1:
+			name = string(f.bytes)
+		case 2:
+			features = append(features, decodePB(t, f.bytes))
+		case 3:
+			keys = append(keys, string(f.bytes))
+		case 4:
+			values = append(values, decodePB(t, f.bytes))
+		case 5:
+			extent = f.value
+		case 15:
+			version = f.value
+		}
+	}
+	if name != "contour" || version != 2 || extent != Extent {
+		t.Errorf("layer %q version %d extent %d, want contour, 2, %d", name, version, extent, Extent)
+	}
+	if !reflect.DeepEqual(keys, []string{"ele", "index"}) {
+		t.Errorf("keys = %v", keys)
+	}
+	if len(features) != 2 {
+		t.Fatalf("%d features, want 2", len(features))
+	}
+
+	value := func(i uint32) interface{} {
+		v := values[i][0]
+		switch v.num {
+		case 3:
+			return math.Float64frombits(v.value)
+		case 6:
+			return int64(v.value>>1) ^ -int64(v.value&1)
+		case 7:
+			return v.value == 1
+		}
+		return nil
+	}
+	for i, want := range []struct {
+		ele   interface{}
+		index bool
+		geom  []uint32
+	}{
+		// MoveTo(10,10) LineTo(+10,0)(0,+20): the duplicate (20,10) is dropped.
+		{int64(100), true, []uint32{9, 20, 20, 18, 20, 0, 0, 40}},
+		// Each feature starts from (0,0) again.
+		{110.5, false, []uint32{9, 0, 0, 10, 5, 8}},
+	} {
+		var tags, geom []uint32
+		var typ uint64
+		for _, f := range features[i] {
+			switch f.num {
+			case 2:
+				tags = decodePacked(f.bytes)
+			case 3:
+				typ = f.value
+			case 4:
+				geom = decodePacked(f.bytes)
+			}
+		}
+		if typ != geomLineString || len(tags) != 4 || tags[0] != 0 || tags[2] != 1 {
+			t.Fatalf("feature %d: type %d tags %v", i, typ, tags)
+		}
+		if got := value(tags[1]); got != want.ele {
+			t.Errorf("feature %d: ele = %v (%T), want %v (%T)", i, got, got, want.ele, want.ele)
+		}
+		if got := value(tags[3]); got != want.index {
+			t.Errorf("feature %d: index = %v, want %v", i, got, want.index)
+		}
+		if !reflect.DeepEqual(geom, want.geom) {
+			t.Errorf("feature %d: geometry = %v, want %v", i, geom, want.geom)
+		}
+	}
+
+	if got := EncodeContours("contour", []Contour{{Elevation: 5, Lines: [][]Point{{{1, 1}, {1.2, 1}}}}}, 0); got != nil {
+		t.Errorf("tile with only degenerate lines = %d bytes, want nil", len(got))
+	}
+}
@@ -0,0 +1,136 @@
+package vector
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sync/atomic"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+	"github.com/pspoerri/geotiff2pmtiles/internal/tile"
+)
+
+// LayerName is the MVT layer the contour lines are written to.
+const LayerName = "contour"
+
+// ContourWriter is a TileWriter that turns Terrarium tiles into contour
+// MVT tiles (--format contours). Generation runs as for Terrarium output;
+// each tile is decoded back to elevations, traced at the interval of its
+// zoom, and passed on gzip-compressed. Tiles without lines are dropped.
+//
+// Lines are traced between pixel centers. To reach the tile edge, the
+// grid is extended by one pixel on each side, extrapolated linearly from
+// the two outermost pixels; the extension ends half a pixel outside the
+// tile, in the buffer renderers clip away, so lines of neighbouring tiles
+// meet. Safe for concurrent use if the wrapped writer is.
+type ContourWriter struct {
+	next      tile.TileWriter
+	intervals []IntervalRange
+	written   atomic.Int64
+}
+
+// NewContourWriter wraps next so that Terrarium tiles are written as
+// contour tiles, with the intervals of ranges (DefaultInterval elsewhere).
+func NewContourWriter(next tile.TileWriter, ranges []IntervalRange) *ContourWriter {
+	return &ContourWriter{next: next, intervals: ranges}
+}
+
+// WriteTile traces the Terrarium tile data and writes its contours.
+func (w *ContourWriter) WriteTile(z, x, y int, data []byte) error {
+	img, err := encode.DecodeImage(data, "terrarium")
+	if err != nil {
+		return fmt.Errorf("contours: decoding tile %d/%d/%d: %w", z, x, y, err)
+	}
+	grid, gw, gh := paddedElevations(img)
+	interval := IntervalForZoom(w.intervals, z)
+	contours := Trace(grid, gw, gh, interval)
+
+	// Padded sample (i, j) is the center of pixel (i-1, j-1).
+	scale := float64(Extent) / float64(img.Bounds().Dx())
+	for _, c := range contours {
+		for _, line := range c.Lines {
+			for i := range line {
+				line[i].X = (line[i].X - 0.5) * scale
+				line[i].Y = (line[i].Y - 0.5) * scale
+			}
+		}
+	}
+	mvt := EncodeContours(LayerName, contours, IndexEvery*interval)
+	if mvt == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(mvt); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := w.next.WriteTile(z, x, y, buf.Bytes()); err != nil {
+		return err
+	}
+	w.written.Add(1)
+	return nil
+}
+
+// TileCount returns the number of contour tiles written so far.
+func (w *ContourWriter) TileCount() int64 {
+	return w.written.Load()
+}
+
+// CompleteZoom forwards to the wrapped writer if it is a ZoomCompleter.
+func (w *ContourWriter) CompleteZoom(z int) {
+	if zc, ok := w.next.(tile.ZoomCompleter); ok {
+		zc.CompleteZoom(z)
+	}
+}
+
+// paddedElevations decodes img's Terrarium pixels into a grid one sample
+// larger on each side (see ContourWriter). An edge sample next to nodata
+// is copied instead of extrapolated.
+func paddedElevations(img image.Image) ([]float64, int, int) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	gw, gh := w+2, h+2
+	grid := make([]float64, gw*gh)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			grid[(y+1)*gw+x+1] = encode.TerrariumToElevation(terrariumPixel(img, b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	extrapolate := func(edge, inner float64) float64 {
+		if math.IsNaN(inner) {
+			return edge
+		}
+		return 2*edge - inner
+	}
+	for y := 1; y <= h; y++ {
+		row := grid[y*gw : (y+1)*gw]
+		row[0] = extrapolate(row[1], row[min(2, w)])
+		row[gw-1] = extrapolate(row[gw-2], row[max(gw-3, 1)])
+	}
+	for x := 0; x < gw; x++ {
+		grid[x] = extrapolate(grid[gw+x], grid[min(2, h)*gw+x])
+		grid[(gh-1)*gw+x] = extrapolate(grid[(gh-2)*gw+x], grid[max(gh-3, 1)*gw+x])
+	}
+	return grid, gw, gh
+}
+
+// terrariumPixel returns the pixel at (x, y), reading the PNG decoder's
+// RGBA and NRGBA images directly. Terrarium pixels are opaque or fully
+// transparent, so the two agree.
+func terrariumPixel(img image.Image, x, y int) color.RGBA {
+	switch m := img.(type) {
+	case *image.NRGBA:
+		p := m.Pix[m.PixOffset(x, y):]
+		return color.RGBA{p[0], p[1], p[2], p[3]}
+	case *image.RGBA:
+		p := m.Pix[m.PixOffset(x, y):]
+		return color.RGBA{p[0], p[1], p[2], p[3]}
+	}
+	return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+}
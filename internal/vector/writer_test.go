@@ -0,0 +1,111 @@
+package vector
+
+import (
+	"bytes"
+	"compress/gzip"
+	"image"
+	"io"
+	"testing"
+
+	"github.com/pspoerri/geotiff2pmtiles/internal/encode"
+)
+
+// recorder is a TileWriter and ZoomCompleter that keeps what it gets.
+type recorder struct {
+	tiles     map[[3]int][]byte
+	completed []int
+}
+
+func (r *recorder) WriteTile(z, x, y int, data []byte) error {
+	r.tiles[[3]int{z, x, y}] = append([]byte(nil), data...)
+	return nil
+}
+
+func (r *recorder) CompleteZoom(z int) { r.completed = append(r.completed, z) }
+
+// terrariumTile encodes a size×size Terrarium tile with elevation elev(x, y).
+func terrariumTile(t *testing.T, size int, elev func(x, y int) float64) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.SetRGBA(x, y, encode.ElevationToTerrarium(elev(x, y)))
+		}
+	}
+	data, err := (&encode.TerrariumEncoder{}).Encode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestContourWriter(t *testing.T) {
+	const size = 16
+	next := &recorder{tiles: make(map[[3]int][]byte)}
+	w := NewContourWriter(next, []IntervalRange{{MinZoom: 0, MaxZoom: 12, Interval: 20}})
+
+	// A ramp rising 10 m per pixel eastwards, 5 m at the first pixel
+	// center: north-south lines at 20, 40, …, 140 m inside the tile, and
+	// at 0 and 160 m on its west and east edges from the extrapolation.
+	ramp := terrariumTile(t, size, func(x, y int) float64 { return float64(x)*10 + 5 })
+	if err := w.WriteTile(12, 1, 2, ramp); err != nil {
+		t.Fatal(err)
+	}
+	flat := terrariumTile(t, size, func(x, y int) float64 { return 5 })
+	if err := w.WriteTile(12, 1, 3, flat); err != nil {
+		t.Fatal(err)
+	}
+	w.CompleteZoom(12)
+
+	if len(next.tiles) != 1 || next.tiles[[3]int{12, 1, 3}] != nil {
+		t.Fatalf("wrote %d tiles, want only the ramp (the flat tile has no lines)", len(next.tiles))
+	}
+	if w.TileCount() != 1 {
+		t.Errorf("TileCount = %d, want 1", w.TileCount())
+	}
+	if len(next.completed) != 1 || next.completed[0] != 12 {
+		t.Errorf("completed zooms = %v, want [12]", next.completed)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(next.tiles[[3]int{12, 1, 2}]))
+	if err != nil {
+		t.Fatalf("tile is not gzip-compressed: %v", err)
+	}
+	mvt, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var features [][]pbField
+	for _, f := range decodePB(t, decodePB(t, mvt)[0].bytes) {
+		if f.num == 2 {
+			features = append(features, decodePB(t, f.bytes))
+		}
+	}
+	if len(features) != 9 {
+		t.Fatalf("%d features, want 9 levels (0-160 m)", len(features))
+	}
+
+	// Each line spans the tile from half a pixel above it to half a pixel
+	// below it: pixel centers plus the extrapolated border.
+	const px = Extent / size
+	for i, f := range features {
+		for _, fld := range f {
+			if fld.num != 4 {
+				continue
+			}
+			g := decodePacked(fld.bytes)
+			if len(g) != 6 || g[0] != 9 || g[3] != 1<<3|cmdLineTo {
+				t.Fatalf("geometry %v, want a MoveTo and one LineTo", g)
+			}
+			unzig := func(v uint32) int { return int(v>>1) ^ -int(v&1) }
+			if x, want := unzig(g[1]), i*2*px; x != want {
+				t.Errorf("level %d m at x=%d, want %d", i*20, x, want)
+			}
+			y0 := unzig(g[2])
+			y1 := y0 + unzig(g[5])
+			if min(y0, y1) != -px/2 || max(y0, y1) != Extent+px/2 {
+				t.Errorf("line from y=%d to y=%d, want %d to %d", y0, y1, -px/2, Extent+px/2)
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package tileorder
+
+import "testing"
+
+// makeTileList builds a slice of [3]int tiles covering all tiles at zoom z.
+func makeTileList(z int) [][3]int {
+	n := 1 << uint(z)
+	return grid(z, 0, 0, n, n)
+}
+
+// benchmarkSort measures sorting all tiles of zoom z along curve c.
+func benchmarkSort(b *testing.B, z int, c Curve) {
+	for i := 0; i < b.N; i++ {
+		// Rebuild the slice each iteration so we sort an unsorted list.
+		b.StopTimer()
+		tiles := makeTileList(z)
+		b.StartTimer()
+		Sort(tiles, c)
+	}
+}
+
+// BenchmarkSortHilbert_Z8 measures sorting 256×256 = 65536 tiles.
+// This is a realistic zoom-level batch size for a moderate-resolution dataset.
+func BenchmarkSortHilbert_Z8(b *testing.B) { benchmarkSort(b, 8, Hilbert) }
+
+// BenchmarkSortHilbert_Z10 measures sorting 1024×1024 = 1M tiles.
+// Representative of a high-resolution global dataset at z10.
+func BenchmarkSortHilbert_Z10(b *testing.B) { benchmarkSort(b, 10, Hilbert) }
+
+// BenchmarkSortHilbert_Z6 measures sorting 4096 tiles.
+// Representative of a small regional dataset or a single zoom batch.
+func BenchmarkSortHilbert_Z6(b *testing.B) { benchmarkSort(b, 6, Hilbert) }
+
+// BenchmarkSortZOrder_Z8 is BenchmarkSortHilbert_Z8 along the Z-order
+// curve, whose index is a few bit operations instead of a loop per bit.
+func BenchmarkSortZOrder_Z8(b *testing.B) { benchmarkSort(b, 8, ZOrder) }
+
+// BenchmarkSortZOrder_Z10 is BenchmarkSortHilbert_Z10 along the Z-order
+// curve.
+func BenchmarkSortZOrder_Z10(b *testing.B) { benchmarkSort(b, 10, ZOrder) }
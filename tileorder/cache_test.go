@@ -0,0 +1,128 @@
+package tileorder
+
+import (
+	"container/list"
+	"testing"
+)
+
+// Cache simulation: workers render the tiles of a zoom level from a source
+// raster whose tiles do not line up with the output grid, through a shared
+// LRU cache of source tiles, as the tile pipeline does with its COG tile
+// cache. The hit rate shows how well an order keeps the workers' combined
+// working set compact.
+const (
+	simWorkers   = 8
+	simBatch     = 32   // as the pipeline's scheduleBatchSize
+	simCacheSize = 96   // source tiles
+	simScale     = 0.73 // source tiles per output tile, per axis
+)
+
+// simulateHitRate returns the source cache hit rate of rendering tiles in
+// the given order: workers take batches from a shared queue and advance
+// one tile each in turn.
+func simulateHitRate(tiles [][3]int) float64 {
+	cache := newLRU(simCacheSize)
+	var hits, accesses int
+	batches := Batches(tiles, simBatch)
+	current := make([][][3]int, simWorkers)
+	for next := 0; ; {
+		active := 0
+		for w := range current {
+			if len(current[w]) == 0 && next < len(batches) {
+				current[w] = batches[next]
+				next++
+			}
+			if len(current[w]) == 0 {
+				continue
+			}
+			active++
+			t := current[w][0]
+			current[w] = current[w][1:]
+			// The source tiles under output tile t.
+			x0, x1 := int(float64(t[1])*simScale), int(float64(t[1]+1)*simScale)
+			y0, y1 := int(float64(t[2])*simScale), int(float64(t[2]+1)*simScale)
+			for sy := y0; sy <= y1; sy++ {
+				for sx := x0; sx <= x1; sx++ {
+					accesses++
+					if cache.get([2]int{sx, sy}) {
+						hits++
+					}
+				}
+			}
+		}
+		if active == 0 {
+			break
+		}
+	}
+	return float64(hits) / float64(accesses)
+}
+
+// simTiles returns the output tiles of the simulation: a 200x120 block of
+// zoom 10, not aligned to any power of two, in row-major order.
+func simTiles() [][3]int { return grid(10, 300, 170, 200, 120) }
+
+func TestCacheHitRates(t *testing.T) {
+	rows := simulateHitRate(simTiles())
+	rates := make(map[Curve]float64)
+	for _, c := range []Curve{Hilbert, ZOrder} {
+		tiles := simTiles()
+		Sort(tiles, c)
+		rates[c] = simulateHitRate(tiles)
+		t.Logf("%-8s %.1f%% source cache hits", c, 100*rates[c])
+		if rates[c] <= rows {
+			t.Errorf("%s: %.1f%% hits, no better than row-major order (%.1f%%)", c, 100*rates[c], 100*rows)
+		}
+	}
+	t.Logf("%-8s %.1f%% source cache hits", "rows", 100*rows)
+}
+
+// BenchmarkCacheHitRate reports the simulated source cache hit rate of
+// each order as the "hit%" metric, next to the cost of the simulation.
+func BenchmarkCacheHitRate(b *testing.B) {
+	orders := []struct {
+		name string
+		sort func([][3]int)
+	}{
+		{"hilbert", func(t [][3]int) { Sort(t, Hilbert) }},
+		{"zorder", func(t [][3]int) { Sort(t, ZOrder) }},
+		{"rows", func([][3]int) {}},
+	}
+	for _, o := range orders {
+		b.Run(o.name, func(b *testing.B) {
+			tiles := simTiles()
+			o.sort(tiles)
+			var rate float64
+			for i := 0; i < b.N; i++ {
+				rate = simulateHitRate(tiles)
+			}
+			b.ReportMetric(100*rate, "hit%")
+		})
+	}
+}
+
+// lru is a least-recently-used set of source tiles.
+type lru struct {
+	size  int
+	order *list.List // front = most recent
+	items map[[2]int]*list.Element
+}
+
+func newLRU(size int) *lru {
+	return &lru{size: size, order: list.New(), items: make(map[[2]int]*list.Element)}
+}
+
+// get reports whether k is cached and caches it, evicting the least
+// recently used entry when full.
+func (c *lru) get(k [2]int) bool {
+	if e, ok := c.items[k]; ok {
+		c.order.MoveToFront(e)
+		return true
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.([2]int))
+	}
+	c.items[k] = c.order.PushFront(k)
+	return false
+}
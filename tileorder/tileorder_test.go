@@ -0,0 +1,112 @@
+package tileorder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHilbertIndexBijective(t *testing.T) {
+	for _, n := range []uint64{1, 2, 4, 8, 16} {
+		seen := make(map[uint64]bool)
+		for x := uint64(0); x < n; x++ {
+			for y := uint64(0); y < n; y++ {
+				d := HilbertIndex(x, y, n)
+				if d >= n*n || seen[d] {
+					t.Fatalf("HilbertIndex(%d, %d, %d) = %d: out of range or duplicate", x, y, n, d)
+				}
+				seen[d] = true
+			}
+		}
+	}
+}
+
+// TestHilbertNeighbours checks the defining property of the Hilbert curve:
+// consecutive tiles are edge neighbours.
+func TestHilbertNeighbours(t *testing.T) {
+	tiles := grid(5, 0, 0, 32, 32)
+	Sort(tiles, Hilbert)
+	for i := 1; i < len(tiles); i++ {
+		dx, dy := tiles[i][1]-tiles[i-1][1], tiles[i][2]-tiles[i-1][2]
+		if dx*dx+dy*dy != 1 {
+			t.Fatalf("tiles %v and %v are consecutive but not neighbours", tiles[i-1], tiles[i])
+		}
+	}
+}
+
+func TestMortonIndex(t *testing.T) {
+	tests := []struct{ x, y, want uint64 }{
+		{0, 0, 0},
+		{1, 0, 1},
+		{0, 1, 2},
+		{1, 1, 3},
+		{2, 0, 4},
+		{3, 5, 0b100111},
+		{0xFFFFFFFF, 0, 0x5555555555555555},
+		{0, 0xFFFFFFFF, 0xAAAAAAAAAAAAAAAA},
+	}
+	for _, tt := range tests {
+		if got := MortonIndex(tt.x, tt.y); got != tt.want {
+			t.Errorf("MortonIndex(%d, %d) = %#x, want %#x", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestSortZOrder(t *testing.T) {
+	tiles := grid(2, 0, 0, 4, 4)
+	Sort(tiles, ZOrder)
+	want := [][3]int{
+		{2, 0, 0}, {2, 1, 0}, {2, 0, 1}, {2, 1, 1},
+		{2, 2, 0}, {2, 3, 0}, {2, 2, 1}, {2, 3, 1},
+		{2, 0, 2}, {2, 1, 2}, {2, 0, 3}, {2, 1, 3},
+		{2, 2, 2}, {2, 3, 2}, {2, 2, 3}, {2, 3, 3},
+	}
+	if !reflect.DeepEqual(tiles, want) {
+		t.Errorf("Z-order = %v, want %v", tiles, want)
+	}
+}
+
+func TestParseCurve(t *testing.T) {
+	for _, c := range []Curve{Hilbert, ZOrder} {
+		got, err := ParseCurve(c.String())
+		if err != nil || got != c {
+			t.Errorf("ParseCurve(%q) = %v, %v", c, got, err)
+		}
+	}
+	if got, err := ParseCurve("morton"); err != nil || got != ZOrder {
+		t.Errorf("ParseCurve(morton) = %v, %v", got, err)
+	}
+	if _, err := ParseCurve("rows"); err == nil {
+		t.Error("ParseCurve(rows) succeeded")
+	}
+}
+
+func TestBatches(t *testing.T) {
+	tiles := grid(3, 0, 0, 7, 1)
+	batches := Batches(tiles, 3)
+	if len(batches) != 3 || len(batches[0]) != 3 || len(batches[2]) != 1 {
+		t.Fatalf("batch sizes %d, want 3, 3, 1", len(batches))
+	}
+	if batches[2][0] != tiles[6] {
+		t.Errorf("last batch %v, want %v", batches[2], tiles[6:])
+	}
+	// Appending to a batch must not overwrite the next one.
+	_ = append(batches[0], [3]int{9, 9, 9})
+	if batches[1][0] != tiles[3] {
+		t.Errorf("appending to a batch changed the next: %v", batches[1])
+	}
+	if got := Batches(nil, 32); len(got) != 0 {
+		t.Errorf("Batches(nil) = %v", got)
+	}
+}
+
+// grid returns the tiles of zoom z in the w x h rectangle at (x0, y0), in
+// row-major order.
+func grid(z, x0, y0, w, h int) [][3]int {
+	tiles := make([][3]int, 0, w*h)
+	for y := y0; y < y0+h; y++ {
+		for x := x0; x < x0+w; x++ {
+			tiles = append(tiles, [3]int{z, x, y})
+		}
+	}
+	return tiles
+}